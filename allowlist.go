@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+)
+
+func signAllowlistCmd(c *cli.Cmd) {
+	c.Spec = "--key [--out] FILE"
+	keyPath := c.String(cli.StringOpt{
+		Name: "k key",
+		Desc: "File with the hex private key of a signer trusted by nodes, see --auth-allowlist-signers.",
+	})
+	outPath := c.String(cli.StringOpt{
+		Name: "o out",
+		Desc: "Write the signed allowlist to the file instead of stdout.",
+	})
+	path := c.StringArg("FILE", "", `Allowlist JSON like {"entries": [{"key": "<node ID>", "permissions": ["write"]}]}.`)
+	c.Action = func() {
+		key, err := crypto.LoadECDSA(*keyPath)
+		if err != nil {
+			log.Fatalln("failed to load the signer key:", err)
+		}
+		data, err := ioutil.ReadFile(*path)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		signed, err := authcenter.SignAllowlist(data, key)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("signed by", crypto.PubkeyToAddress(key.PublicKey).Hex())
+		if len(*outPath) == 0 {
+			fmt.Println(string(signed))
+			return
+		}
+		if err := ioutil.WriteFile(*outPath, append(signed, '\n'), 0644); err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// AnnotationsHandler returns annotations of the record at path.
+func (p *PublicServer) AnnotationsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := ctx.RecordStore().Annotations(ctx.WithRequest(c), c.Param("path"))
+		if err == rs.ErrRecordNotFound {
+			c.AbortWithStatus(404)
+			return
+		} else if serveCircuitError(c, err) {
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, res)
+	}
+}
+
+// AnnotateHandler merges the JSON object of the body into annotations of the record at path,
+// keys set to null are removed. Additional keys to remove may be given as key query params.
+func (p *PublicServer) AnnotateHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var set rs.Annotations
+		if c.Request.ContentLength != 0 {
+			if err := json.NewDecoder(c.Request.Body).Decode(&set); err != nil {
+				c.String(400, "error: annotations must be a json object: %v", err)
+				return
+			}
+		}
+		res, err := ctx.RecordStore().Annotate(ctx.WithRequest(c), c.Param("path"), set, c.QueryArray("key"))
+		switch err {
+		case nil:
+			c.JSON(200, res)
+		case rs.ErrRecordNotFound:
+			c.AbortWithStatus(404)
+		case rs.ErrReadOnly:
+			c.String(403, "error: %v", err)
+		case rs.ErrAnnotationsTooLarge:
+			c.String(413, "error: %v", err)
+		default:
+			if serveCircuitError(c, err) {
+				return
+			}
+			c.String(400, "error: %v", err)
+		}
+	}
+}
+
+// serveAnnotations tells annotations of the record along with its meta.
+func serveAnnotations(ctx APIContext, c *gin.Context, r *rs.Record) {
+	ann := ctx.RecordStore().AnnotationsOf(r.Id())
+	if len(ann) == 0 {
+		return
+	}
+	if data, err := json.Marshal(ann); err == nil {
+		c.Header("X-Meta-Annotations", string(data))
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// AntiEntropyStatusHandler reports anti-entropy rounds of this node.
+func (p *PrivateServer) AntiEntropyStatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().AntiEntropyStatus())
+	}
+}
+
+// AntiEntropyDigestHandler serves the digest of records to a peer, ?namespaces lists the
+// namespaces the peer follows.
+func (p *PrivateServer) AntiEntropyDigestHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		digest, err := ctx.RecordStore().DigestRecords(ctx.WithRequest(c), splitQuery(c.Query("namespaces")))
+		if err == rs.ErrNoSharedNamespaces {
+			c.String(409, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, digest)
+	}
+}
+
+// AntiEntropyEntriesHandler lists current versions of records in the ?buckets of the digest.
+func (p *PrivateServer) AntiEntropyEntriesHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var buckets []int
+		for _, v := range splitQuery(c.Query("buckets")) {
+			b, err := strconv.Atoi(v)
+			if err != nil || b < 0 {
+				c.String(400, "error: malformed bucket %q", v)
+				return
+			}
+			buckets = append(buckets, b)
+		}
+		entries, err := ctx.RecordStore().RecordEntries(ctx.WithRequest(c), splitQuery(c.Query("namespaces")), buckets)
+		if err == rs.ErrNoSharedNamespaces {
+			c.String(409, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, entries)
+	}
+}
+
+// AntiEntropyRecordsHandler streams records a peer pulls, see rs.AntiEntropyPull.
+func (p *PrivateServer) AntiEntropyRecordsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var pull rs.AntiEntropyPull
+		if err := c.BindJSON(&pull); err != nil {
+			return
+		}
+		c.Status(200)
+		if _, err := ctx.RecordStore().ExportRecordsByID(ctx.WithRequest(c), c.Writer, pull.IDs, pull.MaxBytes); err != nil {
+			// the status is sent already, the peer imports the records it got
+			log.Warningf("failed to export records to a peer: %v", err)
+		}
+	}
+}
+
+// AntiEntropyPushHandler imports records a peer pushes.
+func (p *PrivateServer) AntiEntropyPushHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		n, err := ctx.RecordStore().AcceptPush(ctx.WithRequest(c), c.Request.Body)
+		if err == rs.ErrAntiEntropyDisabled {
+			c.String(409, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, gin.H{
+			"imported": n,
+		})
+	}
+}
+
+func splitQuery(v string) []string {
+	if len(v) == 0 {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// ArchiveRecordsHeader is the trailer of record archives, it carries the number of records
+// archived and is set only once the archive is complete.
+const ArchiveRecordsHeader = "X-Archive-Records"
+
+func archiveFormat(c *gin.Context) string {
+	if format := c.Query("format"); len(format) > 0 {
+		return format
+	}
+	return rs.ArchiveCAR
+}
+
+// RecordsExportHandler streams an archive of records selected by query params prefix,
+// namespaces, since (RFC 3339) and history, in the format of query param format, car or tar.
+// Only local tools presenting the support token may request it.
+func (p *PrivateServer) RecordsExportHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkSupportToken(ctx, c) {
+			c.String(403, "error: support token mismatch")
+			return
+		}
+		q := &rs.ArchiveQuery{
+			Prefix:     c.Query("prefix"),
+			Namespaces: splitQuery(c.Query("namespaces")),
+			History:    c.Query("history") == "true",
+		}
+		if v := c.Query("since"); len(v) > 0 {
+			ts, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.String(400, "error: bad since: %v", err)
+				return
+			}
+			q.Since = ts
+		}
+		format := archiveFormat(c)
+		if format != rs.ArchiveCAR && format != rs.ArchiveTar {
+			c.String(400, "error: %v: %s", rs.ErrArchiveFormat, format)
+			return
+		}
+		if format == rs.ArchiveCAR {
+			c.Header("Content-Type", "application/vnd.ipld.car")
+		} else {
+			c.Header("Content-Type", "application/x-tar")
+		}
+		c.Header("Trailer", ArchiveRecordsHeader)
+		c.Status(200)
+		manifest, err := ctx.RecordStore().ExportArchive(ctx.WithRequest(c), q, format, c.Writer)
+		if err != nil {
+			// the status is sent already, the missing trailer tells the export failed
+			log.Warningf("record archive export failed: %v", err)
+			return
+		}
+		c.Writer.Header().Set(ArchiveRecordsHeader, strconv.Itoa(manifest.Records))
+	}
+}
+
+// RecordsImportHandler imports an archive of records in the format of query param format.
+func (p *PrivateServer) RecordsImportHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkSupportToken(ctx, c) {
+			c.String(403, "error: support token mismatch")
+			return
+		}
+		defer c.Request.Body.Close()
+		result, err := ctx.RecordStore().ImportArchive(ctx.WithRequest(c), archiveFormat(c), c.Request.Body)
+		if err == rs.ErrArchiveFormat {
+			c.String(400, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, result)
+	}
+}
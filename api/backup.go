@@ -0,0 +1,105 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// Coordinated backups are made by the backup command on each member, see rs.BackupPlan.
+// Members and replicas of the plan are passed as query params, so every member computes
+// the same plan. Only local tools presenting the support token may request these routes.
+
+// BackupShardHeader is the trailer of shard exports, it carries the number of versions
+// exported and is set only once the export is complete.
+const BackupShardHeader = "X-Backup-Shard-Versions"
+
+func backupPlanQuery(c *gin.Context) (*rs.BackupPlan, error) {
+	var replicas int
+	if v := c.Query("replicas"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		replicas = n
+	}
+	return rs.NewBackupPlan(splitQuery(c.Query("members")), replicas)
+}
+
+// BackupIndexHandler lists object versions of records and their owners in the plan.
+func (p *PrivateServer) BackupIndexHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkSupportToken(ctx, c) {
+			c.String(403, "error: support token mismatch")
+			return
+		}
+		plan, err := backupPlanQuery(c)
+		if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		index, err := ctx.RecordStore().BackupIndex(ctx.WithRequest(c), plan)
+		if err == rs.ErrNotBackupMember {
+			c.String(400, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, index)
+	}
+}
+
+// BackupShardHandler streams a CAR export of object versions the node owns in the plan.
+func (p *PrivateServer) BackupShardHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkSupportToken(ctx, c) {
+			c.String(403, "error: support token mismatch")
+			return
+		}
+		plan, err := backupPlanQuery(c)
+		if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		} else if !plan.IsMember(ctx.NodeID()) {
+			c.String(400, "error: %v", rs.ErrNotBackupMember)
+			return
+		}
+		c.Header("Content-Type", "application/vnd.ipld.car")
+		c.Header("Trailer", BackupShardHeader)
+		c.Status(200)
+		count, err := ctx.RecordStore().ExportBackupShard(ctx.WithRequest(c), plan, c.Writer)
+		if err != nil {
+			// the status is sent already, the missing trailer tells the export failed
+			log.Warningf("backup shard export failed: %v", err)
+			return
+		}
+		c.Writer.Header().Set(BackupShardHeader, strconv.Itoa(count))
+	}
+}
+
+// BackupImportHandler imports the CAR export of the member in query param owner and pins
+// versions of records it owns in the plan.
+func (p *PrivateServer) BackupImportHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkSupportToken(ctx, c) {
+			c.String(403, "error: support token mismatch")
+			return
+		}
+		plan, err := backupPlanQuery(c)
+		if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		defer c.Request.Body.Close()
+		result, err := ctx.RecordStore().ImportBackupShard(ctx.WithRequest(c), plan, c.Query("owner"), c.Request.Body)
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, result)
+	}
+}
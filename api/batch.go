@@ -0,0 +1,209 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+const (
+	batchGetRoute = "/api/v1/batch/get"
+	// batchMaxPaths limits paths of a batch request.
+	batchMaxPaths = 100
+	// batchMaxBytes limits content of a batch response, objects that don't fit are
+	// reported with 413 and should be read with the content API.
+	batchMaxBytes = 8 << 20
+	batchWorkers  = 8
+)
+
+// BatchGetRequest lists paths of records to read at once.
+type BatchGetRequest struct {
+	Paths []string `json:"paths"`
+}
+
+// BatchGetItem is a record of a batch response, Status is the one a content request
+// of the path would get.
+type BatchGetItem struct {
+	Path    string            `json:"path"`
+	Status  int               `json:"status"`
+	Meta    *proto.ObjectMeta `json:"meta,omitempty"`
+	Content []byte            `json:"content,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// BatchGetHandler reads the records of up to batchMaxPaths paths concurrently and responds
+// with NDJSON lines of BatchGetItem in the order of paths, or with parts of a multipart/mixed
+// body if the client accepts it.
+func (p *PublicServer) BatchGetHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req BatchGetRequest
+		if err := c.BindJSON(&req); err != nil {
+			return
+		} else if len(req.Paths) == 0 {
+			c.String(400, "error: no paths")
+			return
+		} else if len(req.Paths) > batchMaxPaths {
+			c.String(400, "error: at most %d paths are allowed", batchMaxPaths)
+			return
+		}
+		items := make([]*BatchGetItem, len(req.Paths))
+		var budget int64 = batchMaxBytes
+		idx := make(chan int)
+		wg := new(sync.WaitGroup)
+		for i := 0; i < batchWorkers && i < len(req.Paths); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range idx {
+					items[i] = batchGet(ctx, c, req.Paths[i], &budget)
+				}
+			}()
+		}
+		for i := range req.Paths {
+			idx <- i
+		}
+		close(idx)
+		wg.Wait()
+		if strings.Contains(c.GetHeader("Accept"), "multipart/mixed") {
+			serveBatchMultipart(c, items)
+			return
+		}
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(200)
+		enc := json.NewEncoder(c.Writer)
+		for _, item := range items {
+			if err := enc.Encode(item); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// batchGet reads a record of the batch, the policy and rate limits are applied to each
+// path as the middleware does for single reads.
+func batchGet(ctx APIContext, c *gin.Context, path string, budget *int64) *BatchGetItem {
+	item := &BatchGetItem{
+		Path: path,
+	}
+	if !strings.HasPrefix(path, "/") {
+		item.Status, item.Error = 400, "path must be absolute"
+		return item
+	}
+	client := clientID(c, ctx.ClientHeader())
+	ns := rs.NamespaceOf(path)
+	if len(ns) > 0 {
+		if ok, _ := ctx.RecordStore().AllowRequest(ns, client); !ok {
+			item.Status, item.Error = 429, fmt.Sprintf("rate limit of namespace %s exceeded", ns)
+			return item
+		}
+	}
+	if policy := ctx.Policy(); policy != nil {
+		in := &PolicyInput{
+			Op:     PolicyRead,
+			Caller: client,
+			Path:   path,
+			Labels: make(map[string]string),
+			Time:   time.Now(),
+		}
+		if len(ns) > 0 {
+			in.Labels["namespace"] = ns
+		}
+		ok, reason, err := policy.Evaluate(in)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"policy": policy.file,
+				"op":     in.Op,
+				"path":   in.Path,
+			}).Warningf("policy evaluation failed: %v", err)
+			item.Status, item.Error = 403, "policy evaluation failed"
+			return item
+		} else if !ok {
+			item.Status, item.Error = 403, reason
+			return item
+		}
+	}
+	startedAt := time.Now()
+	r, err := ctx.RecordStore().ReadRecord(ctx.WithRequest(c), path)
+	ctx.RecordStore().ObserveRead(path, time.Since(startedAt), err)
+	if err == rs.ErrRecordNotFound {
+		item.Status = 404
+		if r != nil {
+			item.Meta = r.Object.Meta()
+		}
+		return item
+	} else if _, ok := err.(*rs.CircuitError); ok {
+		item.Status, item.Error = 504, err.Error()
+		return item
+	} else if err == rs.ErrNoNamespaceKey {
+		item.Status, item.Error = 403, err.Error()
+		return item
+	} else if err != nil {
+		item.Status, item.Error = 500, err.Error()
+		return item
+	}
+	defer r.Body.Close()
+	item.Meta = r.Object.Meta()
+	size := item.Meta.Size()
+	if atomic.AddInt64(budget, -size) < 0 {
+		atomic.AddInt64(budget, size)
+		item.Status, item.Error = 413, "object doesn't fit the batch, read it with the content API"
+		return item
+	}
+	if item.Content, err = ioutil.ReadAll(io.LimitReader(r.Body, size)); err != nil {
+		item.Status, item.Error = 500, err.Error()
+		item.Content = nil
+		return item
+	}
+	item.Status = 200
+	return item
+}
+
+func serveBatchMultipart(c *gin.Context, items []*BatchGetItem) {
+	mw := multipart.NewWriter(c.Writer)
+	c.Header("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	c.Status(200)
+	for _, item := range items {
+		h := make(textproto.MIMEHeader)
+		h.Set("X-Batch-Path", item.Path)
+		h.Set("X-Batch-Status", strconv.Itoa(item.Status))
+		if item.Meta != nil {
+			h.Set("X-Meta-ID", item.Meta.Id())
+			h.Set("X-Meta-Version", item.Meta.Version())
+			if m := item.Meta.UserMeta(); len(m) > 0 {
+				h.Set("X-Meta-UserMeta", m)
+			}
+		}
+		body := item.Content
+		if len(item.Error) > 0 {
+			h.Set("Content-Type", "text/plain; charset=utf-8")
+			body = []byte("error: " + item.Error)
+		} else if ctype := mime.TypeByExtension(filepath.Ext(item.Path)); len(ctype) > 0 {
+			h.Set("Content-Type", ctype)
+		} else {
+			h.Set("Content-Type", "application/octet-stream")
+		}
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return
+		} else if _, err := part.Write(body); err != nil {
+			return
+		}
+	}
+	mw.Close()
+}
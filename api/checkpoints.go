@@ -0,0 +1,37 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+func (p *PrivateServer) CheckpointCreateHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp, err := ctx.RecordStore().CreateCheckpoint(c.Param("name"))
+		if err == rs.ErrCheckpointName {
+			c.String(400, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, cp)
+	}
+}
+
+func (p *PrivateServer) CheckpointGetHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp, err := ctx.RecordStore().GetCheckpoint(c.Param("name"))
+		switch err {
+		case nil:
+			c.JSON(200, cp)
+		case rs.ErrCheckpointNotFound:
+			c.AbortWithStatus(404)
+		case rs.ErrCheckpointName:
+			c.String(400, "error: %v", err)
+		default:
+			c.String(500, "error: %v", err)
+		}
+	}
+}
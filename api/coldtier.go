@@ -0,0 +1,28 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// ColdTierStatusHandler reports the cold tier of the node and versions offloaded to it.
+func (p *PrivateServer) ColdTierStatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().ColdTierStatus())
+	}
+}
+
+// ColdTierApplyHandler runs a cold tier pass and reports the outcome.
+func (p *PrivateServer) ColdTierApplyHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := ctx.RecordStore().ApplyColdTier(c.Request.Context()); err == rs.ErrColdTierRunning {
+			c.String(409, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, ctx.RecordStore().ColdTierStatus())
+	}
+}
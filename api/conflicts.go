@@ -0,0 +1,39 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// ConflictListHandler lists unresolved write conflicts of records, ?all=1 includes
+// the resolved ones.
+func (p *PrivateServer) ConflictListHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		all := c.Query("all") == "1" || c.Query("all") == "true"
+		list, err := ctx.RecordStore().ListConflicts(ctx.WithRequest(c), all)
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		if list == nil {
+			list = []*rs.Conflict{}
+		}
+		c.JSON(200, list)
+	}
+}
+
+// ConflictDismissHandler marks the conflict resolved once an operator has settled it.
+func (p *PrivateServer) ConflictDismissHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conflict, err := ctx.RecordStore().DismissConflict(ctx.WithRequest(c), c.Param("id"))
+		if err == rs.ErrConflictNotFound {
+			c.AbortWithStatus(404)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, conflict)
+	}
+}
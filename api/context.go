@@ -2,7 +2,11 @@ package api
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/AtlantPlatform/atlant-go/cache"
 	"github.com/AtlantPlatform/atlant-go/contracts"
 	"github.com/AtlantPlatform/atlant-go/fs"
 	"github.com/AtlantPlatform/atlant-go/rs"
@@ -21,6 +25,159 @@ func NewContext(ctx context.Context, r rs.PlanetaryRecordStore, mgr contracts.Ma
 	return APIContext{ctx}
 }
 
+// WithSharedFolders returns a copy of the context that exposes shared folders.
+func (c APIContext) WithSharedFolders(folders rs.SharedFolders) APIContext {
+	return APIContext{context.WithValue(c.Context, "shared_folders", folders)}
+}
+
+// WithBootstrapToken returns a copy of the context that allows peers presenting
+// the token to request snapshots of this node.
+func (c APIContext) WithBootstrapToken(token string) APIContext {
+	return APIContext{context.WithValue(c.Context, "bootstrap_token", token)}
+}
+
+// WithRouteTimeouts returns a copy of the context that limits duration of API requests.
+func (c APIContext) WithRouteTimeouts(timeouts *RouteTimeouts) APIContext {
+	return APIContext{context.WithValue(c.Context, "route_timeouts", timeouts)}
+}
+
+// WithProxyRoutes returns a copy of the context that forwards unrouted public API
+// requests to legacy services.
+func (c APIContext) WithProxyRoutes(routes ProxyRoutes) APIContext {
+	return APIContext{context.WithValue(c.Context, "proxy_routes", routes)}
+}
+
+// WithCORS returns a copy of the context that lets browser apps of the given origins
+// call the public API.
+func (c APIContext) WithCORS(cfg *CORSConfig) APIContext {
+	return APIContext{context.WithValue(c.Context, "cors", cfg)}
+}
+
+// WithSwarmKeyInfo returns a copy of the context that reports the fingerprint of the swarm key in status.
+func (c APIContext) WithSwarmKeyInfo(info *SwarmKeyInfo) APIContext {
+	return APIContext{context.WithValue(c.Context, "swarm_key_info", info)}
+}
+
+// WithClientHeader returns a copy of the context that identifies clients of the public
+// API by the header, it must be set by a trusted proxy.
+func (c APIContext) WithClientHeader(header string) APIContext {
+	return APIContext{context.WithValue(c.Context, "client_header", header)}
+}
+
+// WithIngestNodes returns a copy of the context that accepts writes on the given nodes
+// only, other nodes forward public write requests to them.
+func (c APIContext) WithIngestNodes(nodes []string) APIContext {
+	return APIContext{context.WithValue(c.Context, "ingest_nodes", nodes)}
+}
+
+// WithSupport returns a copy of the context that serves support bundles to local tools
+// presenting the private token, config is included into bundles and must be redacted already.
+func (c APIContext) WithSupport(config interface{}) APIContext {
+	return APIContext{context.WithValue(c.Context, "support_config", config)}
+}
+
+// WithPrivateToken returns a copy of the context that requires the token from
+// clients of the local private API.
+func (c APIContext) WithPrivateToken(token *PrivateToken) APIContext {
+	return APIContext{context.WithValue(c.Context, "private_token", token)}
+}
+
+// WithURLSigner returns a copy of the context that honors pre-signed URLs of records.
+func (c APIContext) WithURLSigner(signer *URLSigner) APIContext {
+	return APIContext{context.WithValue(c.Context, "url_signer", signer)}
+}
+
+// WithShutdown returns a copy of the context that lets local tools stop the node,
+// fn must shut it down gracefully.
+func (c APIContext) WithShutdown(fn func()) APIContext {
+	return APIContext{context.WithValue(c.Context, "shutdown", fn)}
+}
+
+// WithPlugins returns a copy of the context that serves routes of the plugins.
+func (c APIContext) WithPlugins(list []Plugin) APIContext {
+	return APIContext{context.WithValue(c.Context, "plugins", list)}
+}
+
+// WithDrain returns a copy of the context that refuses writes once Drain is called.
+func (c APIContext) WithDrain() APIContext {
+	return APIContext{context.WithValue(c.Context, "drain_state", new(drainState))}
+}
+
+// WithThrottles returns a copy of the context that throttles clients of the public API.
+func (c APIContext) WithThrottles(cfg *ThrottleConfig) APIContext {
+	ctx := context.WithValue(c.Context, "throttles", cfg)
+	return APIContext{context.WithValue(ctx, "throttle_state", newThrottles(cfg))}
+}
+
+// WithLoadShed returns a copy of the context that drains load balancer traffic at
+// /lb-health while the node is overloaded.
+func (c APIContext) WithLoadShed(cfg *LoadShedConfig) APIContext {
+	return APIContext{context.WithValue(c.Context, "load_shed_state", newLoadShedder(cfg))}
+}
+
+// WithCache returns a copy of the context that reports hit rates of the cache of hot lookups.
+func (c APIContext) WithCache(cc *cache.Cache) APIContext {
+	return APIContext{context.WithValue(c.Context, "cache", cc)}
+}
+
+// WithShadow returns a copy of the context that mirrors a sample of public read requests
+// to a staging node.
+func (c APIContext) WithShadow(cfg *ShadowConfig) APIContext {
+	return APIContext{context.WithValue(c.Context, "shadow_state", newShadower(cfg))}
+}
+
+// WithIdempotencyTTL returns a copy of the context that keeps outcomes of idempotent
+// writes for ttl, zero disables idempotency keys.
+func (c APIContext) WithIdempotencyTTL(ttl time.Duration) APIContext {
+	return APIContext{context.WithValue(c.Context, "idempotency_ttl", ttl)}
+}
+
+// WithReadyMinPeers returns a copy of the context that reports the node as not ready
+// while it has fewer swarm peers.
+func (c APIContext) WithReadyMinPeers(n int) APIContext {
+	return APIContext{context.WithValue(c.Context, "ready_min_peers", n)}
+}
+
+// WithSRI returns a copy of the context that serves HTML records with integrity
+// attributes on their record-hosted assets.
+func (c APIContext) WithSRI(enabled bool) APIContext {
+	return APIContext{context.WithValue(c.Context, "sri", enabled)}
+}
+
+// WithNetworkStats returns a copy of the context that serves anonymized stats of the network
+// to the public, see NetworkStats.
+func (c APIContext) WithNetworkStats(enabled bool) APIContext {
+	return APIContext{context.WithValue(c.Context, "network_stats", enabled)}
+}
+
+// WithUploadDir returns a copy of the context that stages resumable uploads in dir.
+func (c APIContext) WithUploadDir(dir string) APIContext {
+	return APIContext{context.WithValue(c.Context, "upload_dir", dir)}
+}
+
+// WithPolicy returns a copy of the context that admits API requests by the policy.
+func (c APIContext) WithPolicy(policy *Policy) APIContext {
+	return APIContext{context.WithValue(c.Context, "policy", policy)}
+}
+
+// WithGating returns a copy of the context that admits public writes of Ethereum addresses
+// that pass on-chain checks.
+func (c APIContext) WithGating(cfg *GatingConfig) APIContext {
+	return APIContext{context.WithValue(c.Context, "gating", cfg)}
+}
+
+// JoinIssuer is what join tokens minted by the node bundle besides its own addresses.
+type JoinIssuer struct {
+	SwarmKey  string
+	Bootstrap []string
+}
+
+// WithJoinIssuer returns a copy of the context that mints join tokens of the testnet
+// with the swarm key.
+func (c APIContext) WithJoinIssuer(issuer *JoinIssuer) APIContext {
+	return APIContext{context.WithValue(c.Context, "join_issuer", issuer)}
+}
+
 func (c APIContext) NodeID() string {
 	return c.Value("node_id").(string)
 }
@@ -68,3 +225,239 @@ func (c APIContext) ETHAddr() string {
 func (c APIContext) Env() string {
 	return c.Value("env").(string)
 }
+
+func (c APIContext) SharedFolders() rs.SharedFolders {
+	v := c.Value("shared_folders")
+	if v == nil {
+		return nil
+	}
+	return v.(rs.SharedFolders)
+}
+
+func (c APIContext) BootstrapToken() string {
+	v := c.Value("bootstrap_token")
+	if v == nil {
+		return ""
+	}
+	return v.(string)
+}
+
+func (c APIContext) RouteTimeouts() *RouteTimeouts {
+	v := c.Value("route_timeouts")
+	if v == nil {
+		return nil
+	}
+	return v.(*RouteTimeouts)
+}
+
+// CORS returns origins allowed to call the public API, nil if CORS is off.
+func (c APIContext) CORS() *CORSConfig {
+	v := c.Value("cors")
+	if v == nil {
+		return nil
+	}
+	return v.(*CORSConfig)
+}
+
+// SwarmKeyInfo identifies the swarm key of the node, nil if it's unknown.
+func (c APIContext) SwarmKeyInfo() *SwarmKeyInfo {
+	v := c.Value("swarm_key_info")
+	if v == nil {
+		return nil
+	}
+	return v.(*SwarmKeyInfo)
+}
+
+func (c APIContext) ProxyRoutes() ProxyRoutes {
+	v := c.Value("proxy_routes")
+	if v == nil {
+		return nil
+	}
+	return v.(ProxyRoutes)
+}
+
+func (c APIContext) ClientHeader() string {
+	v := c.Value("client_header")
+	if v == nil {
+		return ""
+	}
+	return v.(string)
+}
+
+func (c APIContext) IngestNodes() []string {
+	v := c.Value("ingest_nodes")
+	if v == nil {
+		return nil
+	}
+	return v.([]string)
+}
+
+func (c APIContext) PrivateToken() *PrivateToken {
+	v := c.Value("private_token")
+	if v == nil {
+		return nil
+	}
+	return v.(*PrivateToken)
+}
+
+func (c APIContext) URLSigner() *URLSigner {
+	v := c.Value("url_signer")
+	if v == nil {
+		return nil
+	}
+	return v.(*URLSigner)
+}
+
+func (c APIContext) Shutdown() func() {
+	v := c.Value("shutdown")
+	if v == nil {
+		return nil
+	}
+	return v.(func())
+}
+
+func (c APIContext) Throttles() *ThrottleConfig {
+	v := c.Value("throttles")
+	if v == nil {
+		return nil
+	}
+	return v.(*ThrottleConfig)
+}
+
+// throttleState is shared by the public API that throttles and the private one that reports.
+func (c APIContext) throttleState() *throttles {
+	v := c.Value("throttle_state")
+	if v == nil {
+		return nil
+	}
+	return v.(*throttles)
+}
+
+// ThrottleStats returns requests of the public API in flight and refused by throttles,
+// nil if the node runs without throttles.
+func (c APIContext) ThrottleStats() *ThrottleStats {
+	if t := c.throttleState(); t != nil {
+		return t.Stats()
+	}
+	return nil
+}
+
+func (c APIContext) Plugins() []Plugin {
+	v, _ := c.Value("plugins").([]Plugin)
+	return v
+}
+
+func (c APIContext) drainState() *drainState {
+	v := c.Value("drain_state")
+	if v == nil {
+		return nil
+	}
+	return v.(*drainState)
+}
+
+func (c APIContext) loadShedder() *loadShedder {
+	v := c.Value("load_shed_state")
+	if v == nil {
+		return nil
+	}
+	return v.(*loadShedder)
+}
+
+// LoadReport returns the load of the node as served at /lb-health, nil if the node
+// runs without load thresholds.
+func (c APIContext) LoadReport() *LoadReport {
+	if l := c.loadShedder(); l != nil {
+		return l.Report(c)
+	}
+	return nil
+}
+
+func (c APIContext) shadowState() *shadower {
+	v := c.Value("shadow_state")
+	if v == nil {
+		return nil
+	}
+	return v.(*shadower)
+}
+
+// ShadowStats returns mirrored requests and divergences of the staging node,
+// nil if the node doesn't mirror requests.
+func (c APIContext) ShadowStats() *ShadowStats {
+	if s := c.shadowState(); s != nil {
+		return s.Stats()
+	}
+	return nil
+}
+
+// CacheStats returns counters of the cache of hot lookups, nil if caching is disabled.
+func (c APIContext) CacheStats() []cache.ClassStats {
+	v := c.Value("cache")
+	if v == nil {
+		return nil
+	}
+	return v.(*cache.Cache).Stats()
+}
+
+func (c APIContext) IdempotencyTTL() time.Duration {
+	v := c.Value("idempotency_ttl")
+	if v == nil {
+		return 0
+	}
+	return v.(time.Duration)
+}
+
+func (c APIContext) ReadyMinPeers() int {
+	v := c.Value("ready_min_peers")
+	if v == nil {
+		return 0
+	}
+	return v.(int)
+}
+
+func (c APIContext) SRI() bool {
+	v, _ := c.Value("sri").(bool)
+	return v
+}
+
+func (c APIContext) NetworkStats() bool {
+	v, _ := c.Value("network_stats").(bool)
+	return v
+}
+
+// UploadDir returns the dir of staged uploads, a dir in the system temp dir by default.
+func (c APIContext) UploadDir() string {
+	v := c.Value("upload_dir")
+	if v == nil || len(v.(string)) == 0 {
+		return filepath.Join(os.TempDir(), "atlant-uploads")
+	}
+	return v.(string)
+}
+
+func (c APIContext) Policy() *Policy {
+	v := c.Value("policy")
+	if v == nil {
+		return nil
+	}
+	return v.(*Policy)
+}
+
+func (c APIContext) Gating() *GatingConfig {
+	v := c.Value("gating")
+	if v == nil {
+		return nil
+	}
+	return v.(*GatingConfig)
+}
+
+// JoinIssuer returns nil unless the node may mint join tokens.
+func (c APIContext) JoinIssuer() *JoinIssuer {
+	v, _ := c.Value("join_issuer").(*JoinIssuer)
+	if v == nil || len(v.SwarmKey) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (c APIContext) SupportConfig() interface{} {
+	return c.Value("support_config")
+}
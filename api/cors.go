@@ -0,0 +1,120 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Browser dapps call the public API of a node from their own origins. CORS is off unless
+// origins are configured: allowed origins get the CORS headers on every response and their
+// preflight requests are answered before throttles and policies, which would refuse them
+// for the lack of credentials. Preflights of other origins are refused with 403.
+
+// CORSConfig sets which browser origins may call the public API.
+type CORSConfig struct {
+	// Origins are allowed origins, e.g. https://app.example.com, "*" allows any.
+	Origins []string
+	Methods []string
+	Headers []string
+	// Credentials allows cookies and the Authorization header of the browser, the origin
+	// is echoed then instead of "*".
+	Credentials bool
+	MaxAge      time.Duration
+}
+
+var (
+	defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE"}
+	defaultCORSHeaders = []string{
+		"Authorization", "Content-Type", "Range", "If-None-Match",
+		IdempotencyKeyHeader, APIVersionHeader, EthAddressHeader, uploadLengthHeader,
+	}
+	// corsExposedHeaders are response headers readable by scripts besides the safelisted ones.
+	corsExposedHeaders = strings.Join([]string{
+		"ETag", "Content-Range", "Accept-Ranges", "Retry-After", APIVersionHeader,
+		"Deprecation", "Sunset", "X-Meta-ID", "X-Meta-Version", "X-Meta-Previous",
+		"X-Meta-Path", "X-Meta-UserMeta", "X-Meta-Deleted", "X-Meta-ExpiresAt",
+		"X-Meta-Signer", "X-Meta-Signature", "X-Meta-Annotations",
+		"X-RateLimit-Limit", "X-RateLimit-Remaining",
+		"X-Quota-Limit", "X-Quota-Remaining", "X-Quota-Reset", traceIDHeader,
+	}, ", ")
+)
+
+func (cfg *CORSConfig) allows(origin string) bool {
+	for _, v := range cfg.Origins {
+		if v == "*" || strings.EqualFold(v, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *CORSConfig) anyOrigin() bool {
+	for _, v := range cfg.Origins {
+		if v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// corsHeaders sets CORS headers for allowed origins and answers their preflight requests.
+func corsHeaders(ctx APIContext) gin.HandlerFunc {
+	cfg := ctx.CORS()
+	if cfg == nil {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	methods := cfg.Methods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := cfg.Headers
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(headers, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if len(origin) == 0 {
+			c.Next()
+			return
+		}
+		preflight := c.Request.Method == "OPTIONS" &&
+			len(c.GetHeader("Access-Control-Request-Method")) > 0
+		if !cfg.allows(origin) {
+			if preflight {
+				c.String(403, "error: origin %s is not allowed", origin)
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+		if cfg.anyOrigin() && !cfg.Credentials {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+		if cfg.Credentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			c.Header("Access-Control-Expose-Headers", corsExposedHeaders)
+			c.Next()
+			return
+		}
+		c.Header("Access-Control-Allow-Methods", allowMethods)
+		c.Header("Access-Control-Allow-Headers", allowHeaders)
+		if cfg.MaxAge > 0 {
+			c.Header("Access-Control-Max-Age", maxAge)
+		}
+		c.AbortWithStatus(204)
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A node drains before it stops: writes of the public and gRPC APIs are refused with 503,
+// so that clients retry them at other nodes, while reads are still served and announces
+// of writes accepted before are published. /readyz and /lb-health fail while the node
+// drains, so that load balancers take it out of the pool.
+
+var errNodeDraining = errors.New("node is shutting down")
+
+type drainState struct {
+	since int64
+}
+
+// Drain stops accepting writes, it's called once the node starts to shut down.
+func (c APIContext) Drain() {
+	if d := c.drainState(); d != nil {
+		atomic.CompareAndSwapInt64(&d.since, 0, time.Now().UnixNano())
+	}
+}
+
+// Draining tells whether the node shuts down and refuses writes.
+func (c APIContext) Draining() bool {
+	if d := c.drainState(); d != nil {
+		return atomic.LoadInt64(&d.since) > 0
+	}
+	return false
+}
+
+// drainWrites refuses public writes while the node drains.
+func drainWrites(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ctx.Draining() && isWriteRoute(c.Request.URL.Path) {
+			c.Header("Retry-After", "5")
+			c.String(503, "error: node is shutting down, writes are not accepted")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// probeDrain fails once the node started to shut down.
+func (h *healthChecker) probeDrain(details map[string]interface{}) error {
+	draining := h.ctx.Draining()
+	details["draining"] = draining
+	if draining {
+		return errNodeDraining
+	}
+	return nil
+}
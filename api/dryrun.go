@@ -0,0 +1,51 @@
+package api
+
+import (
+	"math/big"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/contracts"
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// DryRunReport lists writes and transactions of contract operations skipped in dry-run mode.
+type DryRunReport struct {
+	DryRun       bool                     `json:"dry_run"`
+	Writes       []*rs.SimulatedWrite     `json:"writes"`
+	Transactions []*contracts.Transaction `json:"transactions"`
+}
+
+// DryRunHandler reports what contract operations would have written so far.
+func (p *PrivateServer) DryRunHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mgr := ctx.ContractsManager()
+		c.JSON(200, &DryRunReport{
+			DryRun:       mgr.DryRun() && ctx.RecordStore().DryRun(),
+			Writes:       ctx.RecordStore().SimulatedWrites(),
+			Transactions: mgr.SimulatedTransactions(),
+		})
+	}
+}
+
+// DryRunDistributionHandler simulates a distribution round of ?pool= wei by the current beat reports.
+func (p *PrivateServer) DryRunDistributionHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pool, ok := new(big.Int).SetString(c.Query("pool"), 10)
+		if !ok {
+			c.String(400, "error: %v", contracts.ErrBadPool)
+			return
+		}
+		round, err := ctx.ContractsManager().Distribute(c.Request.Context(), pool)
+		switch err {
+		case nil:
+			c.JSON(200, round)
+		case contracts.ErrNoSigner:
+			c.String(409, "error: %v", err)
+		case contracts.ErrBadPool:
+			c.String(400, "error: %v", err)
+		default:
+			c.String(500, "error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// NamespaceKeysHandler lists keys of encrypted namespaces held by the node.
+func (p *PrivateServer) NamespaceKeysHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys, err := ctx.RecordStore().ListNamespaceKeys(ctx.WithRequest(c))
+		if serveKeyError(c, err) {
+			return
+		}
+		c.JSON(200, keys)
+	}
+}
+
+// RotateNamespaceKeyHandler creates a new key of the namespace for new versions.
+func (p *PrivateServer) RotateNamespaceKeyHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, err := ctx.RecordStore().RotateNamespaceKey(ctx.WithRequest(c), c.Param("ns"))
+		if serveKeyError(c, err) {
+			return
+		}
+		c.JSON(200, key)
+	}
+}
+
+// ExportNamespaceKeyHandler responds with the key and its secret, only local tools
+// presenting the support token may export keys.
+func (p *PrivateServer) ExportNamespaceKeyHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkSupportToken(ctx, c) {
+			c.String(403, "error: support token mismatch")
+			return
+		}
+		key, err := ctx.RecordStore().ExportNamespaceKey(ctx.WithRequest(c), c.Param("id"))
+		if serveKeyError(c, err) {
+			return
+		}
+		c.JSON(200, key)
+	}
+}
+
+// ImportNamespaceKeyHandler adds a key exported by another node.
+func (p *PrivateServer) ImportNamespaceKeyHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var key rs.NamespaceKey
+		if err := c.BindJSON(&key); err != nil {
+			return
+		}
+		err := ctx.RecordStore().ImportNamespaceKey(ctx.WithRequest(c), &key)
+		if serveKeyError(c, err) {
+			return
+		}
+		key.Key = nil
+		c.JSON(200, key)
+	}
+}
+
+func serveKeyError(c *gin.Context, err error) bool {
+	switch err {
+	case nil:
+		return false
+	case rs.ErrNoNamespaceKey:
+		c.String(404, "error: %v", err)
+	case rs.ErrExternalKeys:
+		c.String(409, "error: %v", err)
+	case rs.ErrNamespaceKeyBad:
+		c.String(400, "error: %v", err)
+	default:
+		c.String(500, "error: %v", err)
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+func (p *PrivateServer) FolderIdentityHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		folders := ctx.SharedFolders()
+		if folders == nil {
+			c.String(501, "error: shared folders are not enabled")
+			return
+		}
+		c.JSON(200, gin.H{
+			"node_id":    ctx.NodeID(),
+			"public_key": folders.PublicKey(),
+		})
+	}
+}
+
+func (p *PrivateServer) FolderCreateHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		folders := ctx.SharedFolders()
+		if folders == nil {
+			c.String(501, "error: shared folders are not enabled")
+			return
+		}
+		folder, err := folders.CreateFolder(reqCtx, c.Param("name"))
+		if err != nil {
+			serveFolderError(c, err)
+			return
+		}
+		c.JSON(200, folder)
+	}
+}
+
+func (p *PrivateServer) FolderGetHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		folders := ctx.SharedFolders()
+		if folders == nil {
+			c.String(501, "error: shared folders are not enabled")
+			return
+		}
+		folder, err := folders.GetFolder(reqCtx, c.Param("name"))
+		if err != nil {
+			serveFolderError(c, err)
+			return
+		}
+		c.JSON(200, folder)
+	}
+}
+
+type folderMemberRequest struct {
+	NodeID    string `json:"node_id"`
+	PublicKey string `json:"public_key"`
+}
+
+func (p *PrivateServer) FolderAddMemberHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		folders := ctx.SharedFolders()
+		if folders == nil {
+			c.String(501, "error: shared folders are not enabled")
+			return
+		}
+		var req *folderMemberRequest
+		if err := c.BindJSON(&req); err != nil {
+			return
+		} else if len(req.NodeID) == 0 {
+			c.String(400, "error: node_id is required")
+			return
+		}
+		folder, err := folders.AddMember(reqCtx, c.Param("name"), req.NodeID, req.PublicKey)
+		if err != nil {
+			serveFolderError(c, err)
+			return
+		}
+		c.JSON(200, folder)
+	}
+}
+
+func (p *PrivateServer) FolderRemoveMemberHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		folders := ctx.SharedFolders()
+		if folders == nil {
+			c.String(501, "error: shared folders are not enabled")
+			return
+		}
+		folder, err := folders.RemoveMember(reqCtx, c.Param("name"), c.Param("node"))
+		if err != nil {
+			serveFolderError(c, err)
+			return
+		}
+		c.JSON(200, folder)
+	}
+}
+
+func (p *PrivateServer) FolderPutHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		folders := ctx.SharedFolders()
+		if folders == nil {
+			c.String(501, "error: shared folders are not enabled")
+			return
+		}
+		path := c.Param("path")
+		if len(path) == 0 || path == "/" {
+			c.AbortWithStatus(400)
+			return
+		}
+		r, err := folders.PutFile(reqCtx, c.Param("name"), path, c.Request.Body)
+		if err != nil {
+			serveFolderError(c, err)
+			return
+		}
+		c.JSON(200, r.Object.Meta())
+	}
+}
+
+func (p *PrivateServer) FolderGetFileHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		folders := ctx.SharedFolders()
+		if folders == nil {
+			c.String(501, "error: shared folders are not enabled")
+			return
+		}
+		r, data, err := folders.GetFile(reqCtx, c.Param("name"), c.Param("path"))
+		if err != nil {
+			serveFolderError(c, err)
+			return
+		}
+		meta := r.Object.Meta()
+		if meta != nil {
+			serveMeta(c, meta)
+		}
+		c.Data(200, "application/octet-stream", data)
+	}
+}
+
+// FolderReencryptHandler starts re-encryption of the folder with a new key, to be used
+// when a folder key is compromised. Progress is reported by FolderReencryptStatusHandler.
+func (p *PrivateServer) FolderReencryptHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		folders := ctx.SharedFolders()
+		if folders == nil {
+			c.String(501, "error: shared folders are not enabled")
+			return
+		}
+		job, err := folders.ReencryptFolder(reqCtx, c.Param("name"))
+		if err != nil {
+			serveFolderError(c, err)
+			return
+		}
+		c.JSON(202, job)
+	}
+}
+
+func (p *PrivateServer) FolderReencryptStatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		folders := ctx.SharedFolders()
+		if folders == nil {
+			c.String(501, "error: shared folders are not enabled")
+			return
+		}
+		job, ok := folders.ReencryptStatus(c.Param("name"))
+		if !ok {
+			c.String(404, "error: no re-encryption job for the folder")
+			return
+		}
+		c.JSON(200, job)
+	}
+}
+
+func serveFolderError(c *gin.Context, err error) {
+	if serveWriteError(c, err) {
+		return
+	}
+	switch err {
+	case rs.ErrFolderNotFound, rs.ErrRecordNotFound:
+		c.String(404, "error: %v", err)
+	case rs.ErrFolderName, rs.ErrFolderExists:
+		c.String(400, "error: %v", err)
+	case rs.ErrNotFolderMember, rs.ErrFolderCipher:
+		c.String(403, "error: %v", err)
+	case rs.ErrFolderRevoked:
+		c.String(410, "error: %v", err)
+	case rs.ErrReencryptRunning:
+		c.String(409, "error: %v", err)
+	case rs.ErrFolderTooLarge:
+		c.String(413, "error: %v", err)
+	default:
+		c.String(500, "error: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+//+build gofuzz testing
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var fuzzSigner = NewURLSigner(&PrivateToken{
+	mux:   new(sync.RWMutex),
+	token: "fuzz",
+})
+
+// FuzzRequest reads an HTTP request and runs the parsers of the API over its headers,
+// query and body, checking that whatever they accept is consistent.
+func FuzzRequest(data []byte) int {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return 0
+	}
+	h := req.Header
+	for _, size := range []int64{1, 1024, 1 << 40} {
+		start, end, ok, err := parseByteRange(h.Get("Range"), size)
+		if err == nil && ok && (start < 0 || end < start || end >= size) {
+			panic(fmt.Sprintf("range %d-%d out of content of size %d", start, end, size))
+		}
+	}
+	if v := h.Get("Content-Range"); len(v) > 0 {
+		start, end, _, err := parseContentRange(v)
+		if err == nil && end < start {
+			panic(fmt.Sprintf("content range ends before it starts: %d-%d", start, end))
+		}
+	}
+	etagMatches(h.Get("If-None-Match"), objectETag("fuzz"))
+	if _, err := parseExpiry(&gin.Context{Request: req}); err != nil {
+		return 0
+	}
+	fuzzSigner.Verify(req.URL.Path, req.URL.Query(), "127.0.0.1")
+	if req.Method == "POST" {
+		var batch BatchGetRequest
+		if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+			return 0
+		}
+	}
+	return 1
+}
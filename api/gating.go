@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/contracts"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Writes of the public API may be gated on the chain: the Ethereum address a client writes
+// for (X-Eth-Address) must hold a minimum balance of ATL tokens, be approved by the KYC
+// registry, or both. Outcomes of checks are kept in the state store, so that writes don't
+// call Ethereum nodes each time; refusals are kept shorter than admissions, so that accounts
+// that top up or pass KYC get in soon. Failed lookups are not kept.
+
+const (
+	defaultGateTTL     = 10 * time.Minute
+	defaultGateDenyTTL = time.Minute
+	gateCheckTimeout   = 30 * time.Second
+)
+
+// GatingConfig sets on-chain checks of writers, zero values disable them.
+type GatingConfig struct {
+	// MinBalance is the ATL balance an address must hold to write.
+	MinBalance float64
+	// RequireKYC admits addresses approved by the KYC registry only.
+	RequireKYC bool
+	// TTL and DenyTTL are how long admissions and refusals are kept.
+	TTL     time.Duration
+	DenyTTL time.Duration
+}
+
+// Enabled tells whether any check is set.
+func (cfg *GatingConfig) Enabled() bool {
+	return cfg.MinBalance > 0 || cfg.RequireKYC
+}
+
+// GateResult is the outcome of on-chain checks of an address.
+type GateResult struct {
+	Address   string              `json:"address"`
+	Allowed   bool                `json:"allowed"`
+	Reason    string              `json:"reason,omitempty"`
+	Balance   *float64            `json:"balance,omitempty"`
+	KYC       contracts.KYCStatus `json:"kyc,omitempty"`
+	CheckedAt time.Time           `json:"checked_at"`
+}
+
+// checkWriter runs the checks of the config against the chain.
+func checkWriter(mgr contracts.Manager, cfg *GatingConfig, addr string) (*GateResult, error) {
+	if mgr == nil {
+		return nil, fmt.Errorf("no contracts manager")
+	}
+	result := &GateResult{
+		Address:   addr,
+		Allowed:   true,
+		CheckedAt: time.Now().UTC(),
+	}
+	if cfg.RequireKYC {
+		kyc, err := mgr.KYCManager()
+		if err != nil {
+			return nil, err
+		}
+		status, err := kyc.AccountStatus(addr)
+		if err != nil {
+			return nil, err
+		}
+		result.KYC = status
+		if status != contracts.StatusApproved {
+			result.Allowed = false
+			result.Reason = fmt.Sprintf("KYC status of %s is %s", addr, status)
+			return result, nil
+		}
+	}
+	if cfg.MinBalance > 0 {
+		atl, err := mgr.TokenManager(contracts.TokenATL, "")
+		if err != nil {
+			return nil, err
+		}
+		balance, err := atl.AccountBalance(addr)
+		if err != nil {
+			return nil, err
+		}
+		result.Balance = &balance
+		if balance < cfg.MinBalance {
+			result.Allowed = false
+			result.Reason = fmt.Sprintf("ATL balance of %s is below %g", addr, cfg.MinBalance)
+		}
+	}
+	return result, nil
+}
+
+// checkWriterTimeout gives up on checks that take longer than timeout, calls of contracts
+// have retries of their own and take no context.
+func checkWriterTimeout(mgr contracts.Manager, cfg *GatingConfig, addr string, timeout time.Duration) (*GateResult, error) {
+	type outcome struct {
+		result *GateResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := checkWriter(mgr, cfg, addr)
+		done <- outcome{result, err}
+	}()
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+// cachedGate returns the kept outcome of checks of the address, nil if there's none.
+func cachedGate(ss state.IndexedStore, addr string) *GateResult {
+	var result *GateResult
+	if err := ss.View(context.Background(), state.WriteGatesKey(addr), func(_ *state.Key, v []byte) error {
+		var r GateResult
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+		result = &r
+		return nil
+	}); err != nil {
+		return nil
+	}
+	return result
+}
+
+func keepGate(ss state.IndexedStore, cfg *GatingConfig, result *GateResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	k := state.WriteGatesKey(result.Address)
+	k.TTL = cfg.TTL
+	if !result.Allowed {
+		k.TTL = cfg.DenyTTL
+	}
+	if err := ss.Update(context.Background(), k, func(_ *state.Key, _ []byte) ([]byte, error) {
+		return data, nil
+	}); err != nil {
+		log.WithField("address", result.Address).Debugf("failed to keep write gate: %v", err)
+	}
+}
+
+// writeGating admits public writes of addresses that pass the on-chain checks.
+func writeGating(ctx APIContext) gin.HandlerFunc {
+	cfg := ctx.Gating()
+	if cfg == nil || !cfg.Enabled() {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultGateTTL
+	}
+	if cfg.DenyTTL <= 0 {
+		cfg.DenyTTL = defaultGateDenyTTL
+	}
+	ss := ctx.StateStore()
+	mgr := ctx.ContractsManager()
+	return func(c *gin.Context) {
+		if !isWriteRoute(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		addr := c.Request.Header.Get(EthAddressHeader)
+		if len(addr) == 0 {
+			c.String(403, "error: writes require %s of an eligible account", EthAddressHeader)
+			c.Abort()
+			return
+		} else if !ethAddressRx.MatchString(addr) {
+			c.String(400, "error: invalid %s: %s", EthAddressHeader, addr)
+			c.Abort()
+			return
+		}
+		addr = strings.ToLower(addr)
+		result := cachedGate(ss, addr)
+		if result == nil {
+			var err error
+			if result, err = checkWriterTimeout(mgr, cfg, addr, gateCheckTimeout); err != nil {
+				log.WithField("address", addr).Warningf("failed to check writer on chain: %v", err)
+				c.String(503, "error: failed to check account %s: %v", addr, err)
+				c.Abort()
+				return
+			}
+			keepGate(ss, cfg, result)
+		}
+		if !result.Allowed {
+			c.String(403, "error: %s", result.Reason)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
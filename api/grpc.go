@@ -0,0 +1,397 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/AtlantPlatform/atlant-go/api/pb"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// The gRPC API serves the record store to services that want typed clients and streaming,
+// see api/pb/records.proto. gRPC and its JSON gateway share a listener: requests of gRPC
+// clients are told apart by their content type, the rest go to the gateway, which calls
+// the gRPC server over loopback. Policies apply as on the public API, throttles and rate
+// limits of the public API don't.
+
+// grpcMaxMessageSize limits contents sent in a single message, larger objects are read and
+// written over the REST API.
+const grpcMaxMessageSize = 64 * MB
+
+type GRPCServer struct {
+	srv          *grpc.Server
+	clientHeader string
+
+	mux     *sync.Mutex
+	httpSrv *http.Server
+}
+
+func NewGRPCServer(ctx APIContext) *GRPCServer {
+	srv := grpc.NewServer(
+		grpc.MaxRecvMsgSize(grpcMaxMessageSize+MB),
+		grpc.MaxSendMsgSize(grpcMaxMessageSize+MB),
+		// spans of calls continue traces of the traceparent in the metadata
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+	)
+	pb.RegisterRecordsServer(srv, &recordsService{
+		ctx: ctx,
+	})
+	return &GRPCServer{
+		srv:          srv,
+		clientHeader: ctx.ClientHeader(),
+		mux:          new(sync.Mutex),
+	}
+}
+
+// ListenAndServe serves gRPC and the gateway at the address.
+func (g *GRPCServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	gateway := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(grpcHeaderMatcher(g.clientHeader)))
+	if err := pb.RegisterRecordsHandlerFromEndpoint(context.Background(), gateway, loopbackAddr(l.Addr()),
+		[]grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(grpcMaxMessageSize + MB)),
+		}); err != nil {
+		l.Close()
+		return err
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			g.srv.ServeHTTP(w, r)
+			return
+		}
+		gateway.ServeHTTP(w, r)
+	})
+	srv := &http.Server{
+		// gRPC clients speak HTTP/2 without TLS
+		Handler:           h2c.NewHandler(handler, &http2.Server{}),
+		ReadHeaderTimeout: publicHeaderTimeout,
+	}
+	g.mux.Lock()
+	g.httpSrv = srv
+	g.mux.Unlock()
+	log.WithField("addr", l.Addr().String()).Infoln("serving gRPC API")
+	return srv.Serve(l)
+}
+
+// Close stops serving, ListenAndServe returns http.ErrServerClosed then.
+func (g *GRPCServer) Close() error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.srv.Stop()
+	if g.httpSrv == nil {
+		return nil
+	}
+	return g.httpSrv.Close()
+}
+
+// loopbackAddr is the address the gateway dials, listeners on all interfaces are
+// reached over loopback.
+func loopbackAddr(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return addr.String()
+	}
+	host := tcpAddr.IP.String()
+	if tcpAddr.IP.IsUnspecified() {
+		host = "127.0.0.1"
+		if tcpAddr.IP.To4() == nil {
+			host = "::1"
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(tcpAddr.Port))
+}
+
+// grpcHeaderMatcher passes the client header of policies from gateway requests.
+func grpcHeaderMatcher(header string) runtime.HeaderMatcherFunc {
+	return func(key string) (string, bool) {
+		if len(header) > 0 && strings.EqualFold(key, header) {
+			return strings.ToLower(key), true
+		}
+		return runtime.DefaultHeaderMatcher(key)
+	}
+}
+
+type recordsService struct {
+	pb.UnimplementedRecordsServer
+
+	ctx APIContext
+}
+
+func (s *recordsService) Get(ctx context.Context, req *pb.GetRequest) (*pb.Record, error) {
+	path := "/" + strings.TrimPrefix(req.Path, "/")
+	if err := s.admit(ctx, &PolicyInput{
+		Op:   PolicyRead,
+		Path: path,
+	}); err != nil {
+		return nil, err
+	}
+	asOf, err := parseAsOf(s.ctx, req.AsOf)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	store := s.ctx.RecordStore()
+	startedAt := time.Now()
+	r, err := store.ReadRecord(ctx, path, rs.ReadOptions{
+		AsOf:      asOf,
+		Version:   req.Version,
+		NoContent: req.NoContent,
+	})
+	store.ObserveRead(path, time.Since(startedAt), err)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.Record{
+		Meta: pbObjectMeta(r.Object.Meta()),
+	}
+	resp.Signer, resp.Signature = r.Signer(r.Object.Version)
+	if r.Body == nil {
+		return resp, nil
+	}
+	defer r.Body.Close()
+	if resp.Meta.Size > grpcMaxMessageSize {
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"object is larger than %d bytes, read it over the REST API", grpcMaxMessageSize)
+	}
+	if resp.Content, err = ioutil.ReadAll(r.Body); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return resp, nil
+}
+
+func (s *recordsService) Put(ctx context.Context, req *pb.PutRequest) (*pb.ObjectMeta, error) {
+	path := "/" + strings.TrimPrefix(req.Path, "/")
+	if path == "/" || len(filepath.Base(path)) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "path is required")
+	} else if len(req.UserMeta) > 0 && !json.Valid([]byte(req.UserMeta)) {
+		return nil, status.Errorf(codes.InvalidArgument, "user meta json is not valid: %s", req.UserMeta)
+	} else if len(s.ctx.IngestNodes()) > 0 && !isIngestNode(s.ctx) {
+		return nil, status.Error(codes.FailedPrecondition, "writes go through ingest nodes, use the REST API")
+	} else if s.ctx.Draining() {
+		return nil, status.Error(codes.Unavailable, errNodeDraining.Error())
+	}
+	in := &PolicyInput{
+		Op:     PolicyWrite,
+		Path:   path,
+		Size:   int64(len(req.Content)),
+		Labels: make(map[string]string),
+	}
+	userMetaLabels(in.Labels, req.UserMeta)
+	if err := s.admit(ctx, in); err != nil {
+		return nil, err
+	}
+	body := ioutil.NopCloser(bytes.NewReader(req.Content))
+	r, err := writeRecord(ctx, s.ctx.RecordStore(), path, body, int64(len(req.Content)), []byte(req.UserMeta), time.Time{})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pbObjectMeta(r.Object.Meta()), nil
+}
+
+func (s *recordsService) List(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	prefix := "/" + strings.Trim(req.Prefix, "/") + "/"
+	if prefix == "//" {
+		prefix = "/"
+	}
+	if req.Limit < 0 || req.Limit > maxListLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxListLimit)
+	} else if err := s.admit(ctx, &PolicyInput{
+		Op:   PolicyRead,
+		Path: prefix,
+	}); err != nil {
+		return nil, err
+	}
+	list, err := listRecords(ctx, s.ctx.RecordStore(), prefix, req.Cursor, int(req.Limit))
+	if err == nil && len(list.Files)+len(list.Dirs) == 0 && len(req.Cursor) == 0 {
+		err = rs.ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.ListResponse{
+		Dirs:       list.Dirs,
+		Files:      make([]*pb.ObjectMeta, 0, len(list.Files)),
+		NextCursor: list.NextCursor,
+	}
+	for _, meta := range list.Files {
+		resp.Files = append(resp.Files, pbObjectMeta(meta))
+	}
+	return resp, nil
+}
+
+func (s *recordsService) Subscribe(req *pb.SubscribeRequest, stream grpc.ServerStreamingServer[pb.RecordChange]) error {
+	ctx := stream.Context()
+	prefixes := req.Prefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{"/"}
+	}
+	for _, prefix := range prefixes {
+		if err := s.admit(ctx, &PolicyInput{
+			Op:   PolicyRead,
+			Path: "/" + strings.TrimPrefix(prefix, "/"),
+		}); err != nil {
+			return err
+		}
+	}
+	opts := rs.WatchOptions{
+		Filters: req.Filters,
+		Buffer:  subscribeBuffer,
+	}
+	if len(req.Prefixes) == 1 {
+		opts.Prefix = req.Prefixes[0]
+	}
+	w, err := s.ctx.RecordStore().Watch(opts)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer w.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change, ok := <-w.C:
+			if !ok {
+				return nil
+			} else if !matchPrefixes(change.Path, req.Prefixes) {
+				continue
+			}
+			if err := stream.Send(&pb.RecordChange{
+				Op:              change.Op.String(),
+				Id:              change.ID,
+				Path:            change.Path,
+				Version:         change.Version,
+				VersionPrevious: change.VersionPrevious,
+				NodeId:          change.NodeID,
+				Time:            change.Time.UnixNano(),
+				Changed:         change.Changed,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// admit checks the request against the policy of the public API, see policyCheck.
+func (s *recordsService) admit(ctx context.Context, in *PolicyInput) error {
+	policy := s.ctx.Policy()
+	if policy == nil {
+		return nil
+	}
+	in.Caller = grpcCaller(ctx, s.ctx.ClientHeader())
+	in.Time = time.Now()
+	if in.Labels == nil {
+		in.Labels = make(map[string]string)
+	}
+	if ns := rs.NamespaceOf(strings.TrimSuffix(in.Path, "/") + "/"); len(ns) > 0 {
+		in.Labels["namespace"] = ns
+	}
+	ok, reason, err := policy.Evaluate(in)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"policy": policy.file,
+			"op":     in.Op,
+			"path":   in.Path,
+		}).Warningf("policy evaluation failed: %v", err)
+		return status.Error(codes.PermissionDenied, "policy evaluation failed")
+	} else if !ok {
+		return status.Error(codes.PermissionDenied, reason)
+	}
+	return nil
+}
+
+// grpcCaller identifies the client as clientID does, requests of the gateway carry
+// the address of their client in X-Forwarded-For.
+func grpcCaller(ctx context.Context, header string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(header) > 0 {
+		if v := md.Get(strings.ToLower(header)); len(v) > 0 && len(v[0]) > 0 {
+			return strings.TrimSpace(strings.Split(v[0], ",")[0])
+		}
+	}
+	if v := md.Get("x-forwarded-for"); len(v) > 0 && len(v[0]) > 0 {
+		return strings.TrimSpace(strings.Split(v[0], ",")[0])
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// grpcError maps errors of the record store to status codes, as serveWriteError and
+// serveCircuitError do for the REST API.
+func grpcError(err error) error {
+	switch err := err.(type) {
+	case *rs.HookRejection:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case *rs.FenceError:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case *rs.ProtocolError:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case *rs.ClockSkewError, *rs.CircuitError:
+		return status.Error(codes.Unavailable, err.Error())
+	case *rs.DiskBudgetError:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	switch err {
+	case rs.ErrRecordNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case rs.ErrWORMNamespace:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case rs.ErrSealedDamaged:
+		return status.Error(codes.DataLoss, err.Error())
+	case rs.ErrNotAuthorized, rs.ErrReadOnly, rs.ErrProbationNamespace, rs.ErrNamespaceNotAllowed,
+		rs.ErrNoNamespaceKey:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func pbObjectMeta(meta *proto.ObjectMeta) *pb.ObjectMeta {
+	if meta == nil {
+		return nil
+	}
+	return &pb.ObjectMeta{
+		Id:              meta.Id(),
+		Path:            meta.Path(),
+		CreatedAt:       meta.CreatedAt(),
+		Version:         meta.Version(),
+		VersionPrevious: meta.VersionPrevious(),
+		IsDeleted:       meta.IsDeleted(),
+		Size:            meta.Size(),
+		UserMeta:        meta.UserMeta(),
+	}
+}
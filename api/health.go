@@ -0,0 +1,235 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Health probes tell orchestrators and load balancers what a node is up to. /healthz fails
+// only if the node is broken and should be restarted, which is the case when the state store
+// can't be written. /readyz also fails while the node warms up: it has too few swarm peers,
+// the record store didn't sync yet, or the authority didn't resolve, so that no traffic is
+// routed to it until then, and again once the node drains to stop. Results are cached for
+// a second, probes are served on the public API and bypass its throttles.
+
+const (
+	healthProbeTimeout = 2 * time.Second
+	healthCacheTTL     = time.Second
+
+	HealthPass = "pass"
+	HealthFail = "fail"
+)
+
+var errProbeTimeout = errors.New("probe timed out")
+
+type HealthCheck struct {
+	Status   string                 `json:"status"`
+	Error    string                 `json:"error,omitempty"`
+	Duration float64                `json:"duration_seconds"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+type HealthReport struct {
+	Status    string                  `json:"status"`
+	NodeID    string                  `json:"node_id"`
+	Checks    map[string]*HealthCheck `json:"checks"`
+	CheckedAt time.Time               `json:"checked_at"`
+}
+
+type healthProbe func(details map[string]interface{}) error
+
+type healthChecker struct {
+	ctx      APIContext
+	minPeers int
+
+	mux     *sync.Mutex
+	reports map[string]*HealthReport
+}
+
+func newHealthChecker(ctx APIContext) *healthChecker {
+	return &healthChecker{
+		ctx:      ctx,
+		minPeers: ctx.ReadyMinPeers(),
+		mux:      new(sync.Mutex),
+		reports:  make(map[string]*HealthReport),
+	}
+}
+
+func runProbe(probe healthProbe) *HealthCheck {
+	startedAt := time.Now()
+	check := &HealthCheck{
+		Status: HealthPass,
+	}
+	details := make(map[string]interface{})
+	errC := make(chan error, 1)
+	go func() {
+		errC <- probe(details)
+	}()
+	var err error
+	select {
+	case err = <-errC:
+		check.Details = details
+	case <-time.After(healthProbeTimeout):
+		err = errProbeTimeout
+	}
+	if err != nil {
+		check.Status = HealthFail
+		check.Error = err.Error()
+	}
+	check.Duration = time.Since(startedAt).Seconds()
+	return check
+}
+
+// report runs the probes of the kind, or returns the cached report if it's recent enough.
+func (h *healthChecker) report(kind string, probes map[string]healthProbe) *HealthReport {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if r, ok := h.reports[kind]; ok && time.Since(r.CheckedAt) < healthCacheTTL {
+		return r
+	}
+	r := &HealthReport{
+		Status:    HealthPass,
+		NodeID:    h.ctx.NodeID(),
+		Checks:    make(map[string]*HealthCheck, len(probes)),
+		CheckedAt: time.Now(),
+	}
+	var wg sync.WaitGroup
+	var checkMux sync.Mutex
+	for name, probe := range probes {
+		wg.Add(1)
+		go func(name string, probe healthProbe) {
+			defer wg.Done()
+			check := runProbe(probe)
+			checkMux.Lock()
+			r.Checks[name] = check
+			checkMux.Unlock()
+		}(name, probe)
+	}
+	wg.Wait()
+	for _, check := range r.Checks {
+		if check.Status != HealthPass {
+			r.Status = HealthFail
+		}
+	}
+	h.reports[kind] = r
+	return r
+}
+
+// probeSwarm checks that the node has enough peers to exchange blocks with.
+func (h *healthChecker) probeSwarm(details map[string]interface{}) error {
+	peers := len(h.ctx.FileStore().SwarmPeers())
+	details["peers"] = peers
+	details["min_peers"] = h.minPeers
+	details["bootstrapped"] = peers >= h.minPeers
+	if peers < h.minPeers {
+		return fmt.Errorf("connected to %d peers, %d required", peers, h.minPeers)
+	}
+	return nil
+}
+
+// probeState writes a key to the state store and reads it back.
+func (h *healthChecker) probeState(details map[string]interface{}) error {
+	ss := h.ctx.StateStore()
+	nonce := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+	k := state.HealthKey("probe")
+	k.TTL = time.Minute
+	if err := ss.Update(h.ctx, k, func(k *state.Key, v []byte) ([]byte, error) {
+		return nonce, nil
+	}); err != nil {
+		return fmt.Errorf("write failed: %v", err)
+	}
+	var read []byte
+	if err := ss.View(h.ctx, k, func(k *state.Key, v []byte) error {
+		read = append(read, v...)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("read failed: %v", err)
+	} else if string(read) != string(nonce) {
+		return errors.New("read a different value than written")
+	}
+	// a recovered store serves, the damage is reported but doesn't fail the probe
+	if damage := state.Damage(ss); damage != nil {
+		details["degraded"] = true
+		details["recovery"] = damage.Action
+		details["quarantined_keys"] = damage.QuarantinedTotal
+	}
+	return nil
+}
+
+// probeRecords checks that the record store is synced and handles announces.
+func (h *healthChecker) probeRecords(details map[string]interface{}) error {
+	store := h.ctx.RecordStore()
+	queues := store.QueueStats()
+	syncStats := store.SyncStats()
+	details["inbound_queued"] = queues.InboundDepth
+	details["outbound_queued"] = queues.OutboundDepth
+	details["syncs"] = syncStats.Syncs
+	details["sync_failures"] = syncStats.Failures
+	if cp := syncStats.Checkpoint; cp != nil {
+		details["synced_at"] = cp.Time
+	}
+	if !store.IsReady() {
+		return errors.New("record store is not synced yet")
+	}
+	return nil
+}
+
+// probeAuth checks that the authority has been resolved.
+func (h *healthChecker) probeAuth(details map[string]interface{}) error {
+	status := authcenter.Default.Status()
+	details["entries"] = status.Entries
+	details["refreshed_at"] = status.RefreshedAt
+	if len(status.Failed) > 0 {
+		details["failed_domains"] = status.Failed
+	}
+	if status.RefreshedAt.IsZero() {
+		return errors.New("authority is not resolved yet")
+	} else if status.Entries == 0 {
+		if len(status.Error) > 0 {
+			return errors.New(status.Error)
+		}
+		return errors.New("authority has no entries")
+	}
+	return nil
+}
+
+func serveHealthReport(c *gin.Context, r *HealthReport) {
+	c.Header("Cache-Control", "no-store")
+	if r.Status != HealthPass {
+		c.JSON(503, r)
+		return
+	}
+	c.JSON(200, r)
+}
+
+// HealthzHandler reports whether the node is alive.
+func (p *PublicServer) HealthzHandler(ctx APIContext) gin.HandlerFunc {
+	h := p.health
+	return func(c *gin.Context) {
+		serveHealthReport(c, h.report("healthz", map[string]healthProbe{
+			"state": h.probeState,
+		}))
+	}
+}
+
+// ReadyzHandler reports whether the node is ready to serve traffic.
+func (p *PublicServer) ReadyzHandler(ctx APIContext) gin.HandlerFunc {
+	h := p.health
+	return func(c *gin.Context) {
+		serveHealthReport(c, h.report("readyz", map[string]healthProbe{
+			"swarm":   h.probeSwarm,
+			"state":   h.probeState,
+			"records": h.probeRecords,
+			"auth":    h.probeAuth,
+			"drain":   h.probeDrain,
+		}))
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+type webhookRequest struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Prefix  string `json:"prefix"`
+	Timeout string `json:"timeout"`
+	Policy  string `json:"policy"`
+}
+
+func (p *PrivateServer) HookListHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().ListHooks())
+	}
+}
+
+// HookRegisterHandler registers an out-of-process webhook as a pre-commit hook.
+func (p *PrivateServer) HookRegisterHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req *webhookRequest
+		if err := c.BindJSON(&req); err != nil {
+			return
+		} else if len(req.Name) == 0 || len(req.URL) == 0 {
+			c.String(400, "error: name and url are required")
+			return
+		}
+		opts := rs.HookOptions{
+			Prefix: req.Prefix,
+		}
+		if len(req.Timeout) > 0 {
+			timeout, err := time.ParseDuration(req.Timeout)
+			if err != nil {
+				c.String(400, "error: %v", err)
+				return
+			}
+			opts.Timeout = timeout
+		}
+		policy, err := rs.HookPolicyFromString(req.Policy)
+		if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		opts.Policy = policy
+		if err := ctx.RecordStore().RegisterHook(req.Name, rs.NewWebhook(req.URL), opts); err == rs.ErrHookExists {
+			c.String(409, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.Status(200)
+	}
+}
+
+func (p *PrivateServer) HookUnregisterHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !ctx.RecordStore().UnregisterHook(c.Param("name")) {
+			c.AbortWithStatus(404)
+			return
+		}
+		c.Status(200)
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Writes carrying an Idempotency-Key header are done once: the outcome is kept in the state
+// store for the TTL and returned again to retries with the same key. A key is reserved while
+// its write is in progress, so a concurrent retry gets 409, and a key reused for a different
+// request gets 422. Server errors are not kept, so that the write may be retried.
+
+const (
+	IdempotencyKeyHeader  = "Idempotency-Key"
+	idempotencyPendingTTL = 10 * time.Minute
+	maxIdempotencyKeySize = 255
+)
+
+type idempotentOutcome struct {
+	Fingerprint string            `json:"fingerprint"`
+	Pending     bool              `json:"pending,omitempty"`
+	Status      int               `json:"status,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+}
+
+// recordingWriter keeps a copy of the response for the outcome.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyKey scopes the key to the client, keys of the state store are 26 bytes long.
+func idempotencyKey(client, key string) []byte {
+	sum := sha256.Sum256([]byte(client + "\x00" + key))
+	return []byte(hex.EncodeToString(sum[:13]))
+}
+
+// requestFingerprint tells requests made with the same key apart.
+func requestFingerprint(c *gin.Context) string {
+	sum := sha256.Sum256([]byte(c.Request.Method + " " + c.Request.URL.RequestURI() + "\x00" +
+		c.Request.Header.Get("Content-Length") + "\x00" + c.Request.Header.Get("X-Meta-UserMeta")))
+	return hex.EncodeToString(sum[:])
+}
+
+func isKeptStatus(status int) bool {
+	return status < 500 && status != 409 && status != 429
+}
+
+// idempotentWrites replays outcomes of writes made with an idempotency key.
+func idempotentWrites(ctx APIContext, ttl time.Duration) gin.HandlerFunc {
+	header := ctx.ClientHeader()
+	return func(c *gin.Context) {
+		key := c.Request.Header.Get(IdempotencyKeyHeader)
+		if len(key) == 0 || ttl <= 0 {
+			c.Next()
+			return
+		} else if len(key) > maxIdempotencyKeySize {
+			c.String(400, "error: %s is longer than %d bytes", IdempotencyKeyHeader, maxIdempotencyKeySize)
+			c.Abort()
+			return
+		}
+		ss := ctx.StateStore()
+		fingerprint := requestFingerprint(c)
+		k := state.IdempotencyKey(idempotencyKey(clientID(c, header), key))
+		k.TTL = idempotencyPendingTTL
+		var prev *idempotentOutcome
+		if err := ss.Update(ctx, k, func(k *state.Key, v []byte) ([]byte, error) {
+			if v != nil {
+				prev = new(idempotentOutcome)
+				if err := json.Unmarshal(v, prev); err == nil {
+					return nil, state.ErrNoUpdate
+				}
+				prev = nil
+			}
+			return json.Marshal(&idempotentOutcome{
+				Fingerprint: fingerprint,
+				Pending:     true,
+			})
+		}); err != nil {
+			log.Warningf("failed to reserve idempotency key: %v", err)
+			c.String(500, "error: %v", err)
+			c.Abort()
+			return
+		}
+		if prev != nil {
+			switch {
+			case prev.Fingerprint != fingerprint:
+				c.String(422, "error: %s was used for a different request", IdempotencyKeyHeader)
+			case prev.Pending:
+				c.String(409, "error: request with this %s is in progress", IdempotencyKeyHeader)
+			default:
+				for k, v := range prev.Headers {
+					c.Header(k, v)
+				}
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(prev.Status, prev.Headers["Content-Type"], prev.Body)
+			}
+			c.Abort()
+			return
+		}
+
+		w := &recordingWriter{
+			ResponseWriter: c.Writer,
+			body:           new(bytes.Buffer),
+		}
+		c.Writer = w
+		c.Next()
+
+		status := w.Status()
+		if !isKeptStatus(status) {
+			if err := ss.Delete(k); err != nil && err != state.ErrNotFound {
+				log.Warningf("failed to release idempotency key: %v", err)
+			}
+			return
+		}
+		outcome := &idempotentOutcome{
+			Fingerprint: fingerprint,
+			Status:      status,
+			Headers:     make(map[string]string),
+			Body:        w.body.Bytes(),
+		}
+		for name, values := range w.Header() {
+			if len(values) > 0 {
+				outcome.Headers[name] = values[0]
+			}
+		}
+		k.TTL = ttl
+		if err := ss.Update(ctx, k, func(k *state.Key, v []byte) ([]byte, error) {
+			return json.Marshal(outcome)
+		}); err != nil {
+			log.Warningf("failed to keep outcome of idempotent write: %v", err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// IdentityLinksHandler lists rotations of node identities known to the authority.
+func (p *PrivateServer) IdentityLinksHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, authcenter.Links())
+	}
+}
+
+// IdentityRotateHandler rotates the identity of the node, the new one is used once the node restarts.
+func (p *PrivateServer) IdentityRotateHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rotation, err := ctx.RecordStore().RotateIdentity(ctx.WithRequest(c))
+		if err == rs.ErrIdentityRotated {
+			c.String(409, "error: %v", err)
+			return
+		} else if serveWriteError(c, err) {
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, rotation)
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/tracing"
+)
+
+// Writes of a cluster may be accepted by designated ingest nodes only, so that keys with
+// write permissions are kept off edge gateways. Other nodes forward public write requests
+// to an ingest node over libp2p, the response of the ingest node is passed to the client
+// as is. Ingest nodes serve the writes on the peer listener of the private API.
+
+const (
+	ingestPrefix   = "/private/v1/ingest/"
+	ingestDownTime = 30 * time.Second
+)
+
+// ingestPool picks ingest nodes round-robin, skipping the ones that failed recently.
+type ingestPool struct {
+	nodes []string
+
+	mux       *sync.Mutex
+	next      int
+	downUntil map[string]time.Time
+}
+
+func newIngestPool(nodes []string) *ingestPool {
+	return &ingestPool{
+		nodes:     nodes,
+		mux:       new(sync.Mutex),
+		downUntil: make(map[string]time.Time),
+	}
+}
+
+// pick returns the next node that is up, or the next one at all if every node is down.
+func (p *ingestPool) pick() string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	now := time.Now()
+	for i := 0; i < len(p.nodes); i++ {
+		nodeID := p.nodes[(p.next+i)%len(p.nodes)]
+		if now.After(p.downUntil[nodeID]) {
+			p.next = (p.next + i + 1) % len(p.nodes)
+			return nodeID
+		}
+	}
+	nodeID := p.nodes[p.next%len(p.nodes)]
+	p.next = (p.next + 1) % len(p.nodes)
+	return nodeID
+}
+
+func (p *ingestPool) markDown(nodeID string) {
+	p.mux.Lock()
+	p.downUntil[nodeID] = time.Now().Add(ingestDownTime)
+	p.mux.Unlock()
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func isIngestNode(ctx APIContext) bool {
+	for _, nodeID := range ctx.IngestNodes() {
+		if nodeID == ctx.NodeID() {
+			return true
+		}
+	}
+	return false
+}
+
+// ingestProxy forwards public write requests to ingest nodes, unless none are designated
+// or this node is one of them.
+func ingestProxy(ctx APIContext) gin.HandlerFunc {
+	nodes := ctx.IngestNodes()
+	if len(nodes) == 0 || isIngestNode(ctx) {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	pool := newIngestPool(nodes)
+	client := ctx.FileStore().Client()
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = pool.pick()
+			req.URL.Path = ingestPrefix + strings.TrimPrefix(req.URL.Path, "/api/v1/")
+			req.URL.RawPath = ""
+			req.Host = req.URL.Host
+			tracing.InjectHTTP(req.Context(), req.Header)
+		},
+		Transport: roundTripFunc(client.Do),
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			pool.markDown(req.URL.Host)
+			log.WithField("node", req.URL.Host).Warningf("failed to forward write to ingest node: %v", err)
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprintf(w, "error: ingest node unavailable")
+		},
+	}
+	return func(c *gin.Context) {
+		proxy.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
+
+// ingestOnly refuses forwarded writes if this node is not an ingest node,
+// which means the cluster members disagree on the ingest nodes.
+func ingestOnly(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isIngestNode(ctx) {
+			c.String(421, "error: node %s is not an ingest node", ctx.NodeID())
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
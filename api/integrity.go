@@ -0,0 +1,250 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// Subresource integrity lets browsers check that scripts and stylesheets of dApps hosted in
+// records were not tampered with. Assets referenced by an HTML record are resolved to records,
+// relative refs against the path of the page and absolute ones under the content route, the
+// integrity manifest of the page lists their checksums. With SRI enabled, HTML records are
+// served with integrity attributes added to the asset tags. External assets are left alone,
+// their content is unknown to the node.
+
+const (
+	contentRoute   = "/api/v1/content"
+	maxSRIPageSize = 4 * 1024 * 1024
+)
+
+var (
+	sriTagRx  = regexp.MustCompile(`(?is)<(script|link)\b[^>]*>`)
+	sriAttrRx = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+))?`)
+)
+
+type IntegrityAsset struct {
+	Ref       string `json:"ref"`
+	Path      string `json:"path,omitempty"`
+	Version   string `json:"cid,omitempty"`
+	Integrity string `json:"integrity,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type IntegrityManifest struct {
+	Path    string            `json:"path"`
+	Version string            `json:"cid"`
+	Assets  []*IntegrityAsset `json:"assets"`
+}
+
+// assetTag is a script or stylesheet tag of a page that refers to an asset.
+type assetTag struct {
+	start, end   int
+	ref          string
+	hasIntegrity bool
+}
+
+func findAssetTags(page []byte) []*assetTag {
+	var tags []*assetTag
+	for _, loc := range sriTagRx.FindAllSubmatchIndex(page, -1) {
+		attrs := make(map[string]string)
+		for _, m := range sriAttrRx.FindAllSubmatch(page[loc[3]:loc[1]-1], -1) {
+			attrs[strings.ToLower(string(m[1]))] = html.UnescapeString(strings.Trim(string(m[2]), `"'`))
+		}
+		tag := &assetTag{
+			start: loc[0],
+			end:   loc[1],
+		}
+		_, tag.hasIntegrity = attrs["integrity"]
+		switch strings.ToLower(string(page[loc[2]:loc[3]])) {
+		case "script":
+			tag.ref = attrs["src"]
+		case "link":
+			switch strings.ToLower(attrs["rel"]) {
+			case "stylesheet", "preload", "modulepreload":
+				tag.ref = attrs["href"]
+			}
+		}
+		if len(tag.ref) > 0 {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// assetRecordPath resolves the ref of a page asset to a record path and an optional version,
+// ok is false if the asset is not hosted in records.
+func assetRecordPath(pagePath, ref string) (p string, version string, ok bool) {
+	u, err := url.Parse(ref)
+	if err != nil || len(u.Scheme) > 0 || len(u.Host) > 0 || len(u.Path) == 0 {
+		return "", "", false
+	}
+	switch {
+	case strings.HasPrefix(u.Path, contentRoute+"/"):
+		p = strings.TrimPrefix(u.Path, contentRoute)
+	case strings.HasPrefix(u.Path, "/"):
+		// other routes of the gateway
+		return "", "", false
+	default:
+		p = path.Join(path.Dir(pagePath), u.Path)
+	}
+	return p, u.Query().Get("ver"), true
+}
+
+// sriHash converts a hex sha256 to the format of integrity attributes.
+func sriHash(sum string) (string, error) {
+	data, err := hex.DecodeString(sum)
+	if err != nil {
+		return "", err
+	}
+	return "sha256-" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// pageAssets resolves assets of the page and computes their integrity, assets that
+// failed to resolve are reported with an error.
+func pageAssets(ctx context.Context, store rs.PlanetaryRecordStore, pagePath string, tags []*assetTag) []*IntegrityAsset {
+	var assets []*IntegrityAsset
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		if seen[tag.ref] {
+			continue
+		}
+		seen[tag.ref] = true
+		p, version, ok := assetRecordPath(pagePath, tag.ref)
+		if !ok {
+			continue
+		}
+		asset := &IntegrityAsset{
+			Ref:  tag.ref,
+			Path: p,
+		}
+		assets = append(assets, asset)
+		entry, err := store.RecordChecksum(ctx, p, version)
+		if err == nil {
+			asset.Version = entry.Version
+			asset.Integrity, err = sriHash(entry.SHA256)
+		}
+		if err != nil {
+			asset.Error = err.Error()
+		}
+	}
+	return assets
+}
+
+// injectIntegrity adds integrity attributes to tags of assets that resolved, tags that
+// have the attribute already are kept as is.
+func injectIntegrity(page []byte, tags []*assetTag, assets []*IntegrityAsset) []byte {
+	integrity := make(map[string]string, len(assets))
+	for _, a := range assets {
+		if len(a.Integrity) > 0 {
+			integrity[a.Ref] = a.Integrity
+		}
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(page)+len(tags)*64))
+	var last int
+	for _, tag := range tags {
+		v, ok := integrity[tag.ref]
+		if !ok || tag.hasIntegrity {
+			continue
+		}
+		closing := tag.end - 1
+		if page[closing-1] == '/' {
+			closing--
+		}
+		buf.Write(page[last:closing])
+		buf.WriteString(` integrity="` + v + `"`)
+		last = closing
+	}
+	buf.Write(page[last:])
+	return buf.Bytes()
+}
+
+func isHTMLPath(p string) bool {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+// serveWithIntegrity serves an HTML record with integrity attributes injected,
+// pages too big to be rewritten are served as is.
+func serveWithIntegrity(ctx APIContext, c *gin.Context, body io.ReadCloser, meta *proto.ObjectMeta) {
+	page, err := ioutil.ReadAll(io.LimitReader(body, maxSRIPageSize+1))
+	if err != nil {
+		c.String(500, "error: %v", err)
+		return
+	} else if len(page) > maxSRIPageSize {
+		serveObject(c, ioutil.NopCloser(io.MultiReader(bytes.NewReader(page), body)), meta)
+		return
+	}
+	tags := findAssetTags(page)
+	assets := pageAssets(ctx.WithRequest(c), ctx.RecordStore(), meta.Path(), tags)
+	for _, a := range assets {
+		if len(a.Error) > 0 {
+			log.WithFields(log.Fields{
+				"page":  meta.Path(),
+				"asset": a.Ref,
+			}).Warningf("failed to compute asset integrity: %s", a.Error)
+		}
+	}
+	serveMeta(c, meta)
+	// no modification time, the page changes with its assets
+	http.ServeContent(c.Writer, c.Request, meta.Path(), time.Time{},
+		bytes.NewReader(injectIntegrity(page, tags, assets)))
+}
+
+// IntegrityHandler serves the integrity manifest of an HTML record.
+func (p *PublicServer) IntegrityHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		r, err := ctx.RecordStore().ReadRecord(reqCtx, c.Param("path"), rs.ReadOptions{
+			Version: c.Query("ver"),
+		})
+		if err == rs.ErrRecordNotFound {
+			c.AbortWithStatus(404)
+			return
+		} else if serveCircuitError(c, err) {
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		defer r.Body.Close()
+		meta := r.Object.Meta()
+		page, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSRIPageSize+1))
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		} else if len(page) > maxSRIPageSize {
+			c.String(413, "error: page is larger than %d bytes", maxSRIPageSize)
+			return
+		}
+		manifest := &IntegrityManifest{
+			Path:    meta.Path(),
+			Version: meta.Version(),
+			Assets:  pageAssets(reqCtx, ctx.RecordStore(), meta.Path(), findAssetTags(page)),
+		}
+		if manifest.Assets == nil {
+			manifest.Assets = []*IntegrityAsset{}
+		}
+		c.JSON(200, manifest)
+	}
+}
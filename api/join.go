@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// JoinTokenResponse is a minted join token, Token is passed to init --join-token.
+type JoinTokenResponse struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	Bootstrap []string  `json:"bootstrap"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// JoinTokenMintHandler issues a join token for a new testnet node, valid for the ttl
+// query param, 72h by default.
+func (p *PrivateServer) JoinTokenMintHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		issuer := ctx.JoinIssuer()
+		if issuer == nil {
+			c.String(403, "error: join tokens are issued within testnet only")
+			return
+		}
+		var ttl time.Duration
+		if v := c.Query("ttl"); len(v) > 0 {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				c.String(400, "error: invalid ttl %s", v)
+				return
+			}
+			ttl = d
+		}
+		token, err := ctx.RecordStore().MintJoinToken(issuer.SwarmKey, issuer.Bootstrap, ttl)
+		switch err {
+		case nil:
+			c.JSON(200, &JoinTokenResponse{
+				ID:        token.ID,
+				Token:     token.Encode(),
+				Bootstrap: token.Bootstrap,
+				ExpiresAt: token.ExpiresAt,
+			})
+		case rs.ErrNotAuthorized, rs.ErrJoinTokenDisabled:
+			c.String(403, "error: %v", err)
+		default:
+			c.String(500, "error: %v", err)
+		}
+	}
+}
+
+// JoinTokensHandler lists join tokens issued by this node.
+func (p *PrivateServer) JoinTokensHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		grants, err := ctx.RecordStore().ListJoinTokens(ctx.WithRequest(c))
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, grants)
+	}
+}
+
+// JoinTokenRevokeHandler refuses further redemptions of the join token.
+func (p *PrivateServer) JoinTokenRevokeHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := ctx.RecordStore().RevokeJoinToken(c.Param("id"))
+		if err == rs.ErrJoinToken {
+			c.String(404, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.Status(200)
+	}
+}
+
+// JoinHandler redeems a join token of a peer, this node vouches for the peer then. Refused
+// tokens are answered with 403, other failures with 503, so the peer retries them.
+func (p *PrivateServer) JoinHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req rs.JoinRequest
+		if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+			c.String(400, "error: malformed join request: %v", err)
+			return
+		} else if !fs.IsValidNodeID(req.NodeID) {
+			c.String(400, "error: invalid node ID %s", req.NodeID)
+			return
+		}
+		err := ctx.RecordStore().RedeemJoinToken(ctx.WithRequest(c), &req)
+		switch err {
+		case nil:
+			c.Status(200)
+		case rs.ErrJoinToken, rs.ErrJoinTokenDisabled, rs.ErrNotAuthorized:
+			log.WithField("node", req.NodeID).Debugf("refused a join token: %v", err)
+			c.String(403, "error: %v", err)
+		default:
+			c.String(503, "error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// /lb-health tells upstream load balancers to drain traffic from a node that is overloaded,
+// before its clients see errors. Unlike /readyz it reflects load, not warm-up: the node
+// drains once any signal crosses its threshold, and takes traffic again only after all of
+// them stayed below the recover fraction of their thresholds for the hold time, so that a
+// node on the edge doesn't flap in and out of the pool. Signals are sampled once a second.
+
+const (
+	LoadPass  = "pass"
+	LoadDrain = "drain"
+
+	loadSampleTTL     = time.Second
+	loadLatencyWindow = 512
+
+	// signals of the load, also names of details in the report
+	LoadQueueDepth = "queue_depth"
+	LoadLatency    = "latency_p95"
+	LoadMemory     = "memory"
+)
+
+// LoadShedConfig sets load thresholds of /lb-health, zero values disable them.
+type LoadShedConfig struct {
+	// MaxQueueDepth is the number of announces queued inbound and outbound.
+	MaxQueueDepth int64
+	// MaxLatency is the 95th percentile of the latest public API requests.
+	MaxLatency time.Duration
+	// MaxMemory is the heap in use, in bytes.
+	MaxMemory uint64
+	// Recover is the fraction of thresholds all signals must fall below to take traffic
+	// again, 0.8 by default. Hold is how long they must stay there, 30s by default.
+	Recover float64
+	Hold    time.Duration
+}
+
+// LoadSignal is a sampled signal of the load with its threshold.
+type LoadSignal struct {
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Over      bool    `json:"over"`
+}
+
+// LoadReport is the state of the node served at /lb-health.
+type LoadReport struct {
+	Status    string                 `json:"status"`
+	NodeID    string                 `json:"node_id"`
+	Signals   map[string]*LoadSignal `json:"signals"`
+	Since     time.Time              `json:"since"`
+	CheckedAt time.Time              `json:"checked_at"`
+}
+
+type loadShedder struct {
+	cfg *LoadShedConfig
+
+	mux        *sync.Mutex
+	latencies  []float64
+	next       int
+	draining   bool
+	since      time.Time
+	belowSince time.Time
+	report     *LoadReport
+}
+
+func newLoadShedder(cfg *LoadShedConfig) *loadShedder {
+	if cfg.Recover <= 0 || cfg.Recover > 1 {
+		cfg.Recover = 0.8
+	}
+	if cfg.Hold <= 0 {
+		cfg.Hold = 30 * time.Second
+	}
+	return &loadShedder{
+		cfg:       cfg,
+		mux:       new(sync.Mutex),
+		latencies: make([]float64, 0, loadLatencyWindow),
+		since:     time.Now(),
+	}
+}
+
+// observe records the latency of a public API request, streams stay open for long and are skipped.
+func (l *loadShedder) observe(d time.Duration) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if len(l.latencies) < loadLatencyWindow {
+		l.latencies = append(l.latencies, d.Seconds())
+		return
+	}
+	l.latencies[l.next] = d.Seconds()
+	l.next = (l.next + 1) % loadLatencyWindow
+}
+
+// latencyP95 must be called with the lock held.
+func (l *loadShedder) latencyP95() float64 {
+	if len(l.latencies) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), l.latencies...)
+	sort.Float64s(sorted)
+	return sorted[(len(sorted)*95+99)/100-1]
+}
+
+// Report samples the signals, or returns the last report if it's recent enough.
+func (l *loadShedder) Report(ctx APIContext) *LoadReport {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	now := time.Now()
+	if l.report != nil && now.Sub(l.report.CheckedAt) < loadSampleTTL {
+		return l.report
+	}
+	signals := make(map[string]*LoadSignal)
+	if l.cfg.MaxQueueDepth > 0 {
+		queues := ctx.RecordStore().QueueStats()
+		signals[LoadQueueDepth] = &LoadSignal{
+			Value:     float64(queues.InboundDepth + queues.OutboundDepth),
+			Threshold: float64(l.cfg.MaxQueueDepth),
+		}
+	}
+	if l.cfg.MaxLatency > 0 {
+		signals[LoadLatency] = &LoadSignal{
+			Value:     l.latencyP95(),
+			Threshold: l.cfg.MaxLatency.Seconds(),
+		}
+	}
+	if l.cfg.MaxMemory > 0 {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		signals[LoadMemory] = &LoadSignal{
+			Value:     float64(mem.HeapInuse),
+			Threshold: float64(l.cfg.MaxMemory),
+		}
+	}
+	over, below := false, true
+	for _, s := range signals {
+		s.Over = s.Value > s.Threshold
+		over = over || s.Over
+		below = below && s.Value <= s.Threshold*l.cfg.Recover
+	}
+	switch {
+	case !l.draining && over:
+		l.draining, l.since = true, now
+		l.belowSince = time.Time{}
+		log.WithField("signals", overSignals(signals)).Warningln("node is overloaded, draining traffic at /lb-health")
+	case l.draining && !below:
+		l.belowSince = time.Time{}
+	case l.draining && l.belowSince.IsZero():
+		l.belowSince = now
+	case l.draining && now.Sub(l.belowSince) >= l.cfg.Hold:
+		l.draining, l.since = false, now
+		log.Infoln("node load recovered, taking traffic at /lb-health")
+	}
+	l.report = &LoadReport{
+		Status:    LoadPass,
+		NodeID:    ctx.NodeID(),
+		Signals:   signals,
+		Since:     l.since,
+		CheckedAt: now,
+	}
+	if l.draining {
+		l.report.Status = LoadDrain
+	}
+	return l.report
+}
+
+func overSignals(signals map[string]*LoadSignal) []string {
+	var names []string
+	for name, s := range signals {
+		if s.Over {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadLatencies records latencies of public API requests for /lb-health.
+func loadLatencies(ctx APIContext) gin.HandlerFunc {
+	l := ctx.loadShedder()
+	return func(c *gin.Context) {
+		if l == nil || l.cfg.MaxLatency <= 0 {
+			c.Next()
+			return
+		}
+		startedAt := time.Now()
+		c.Next()
+		if c.Writer.Status() == 101 || c.Writer.Header().Get("Content-Type") == "text/event-stream" {
+			return
+		}
+		l.observe(time.Since(startedAt))
+	}
+}
+
+// LBHealthHandler reports whether load balancers should route traffic to the node,
+// it always passes if no load thresholds are set.
+func (p *PublicServer) LBHealthHandler(ctx APIContext) gin.HandlerFunc {
+	l := ctx.loadShedder()
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		if ctx.Draining() {
+			c.JSON(503, &LoadReport{
+				Status:    LoadDrain,
+				NodeID:    ctx.NodeID(),
+				Signals:   map[string]*LoadSignal{},
+				Since:     p.startedAt,
+				CheckedAt: time.Now(),
+			})
+			return
+		} else if l == nil {
+			c.JSON(200, &LoadReport{
+				Status:    LoadPass,
+				NodeID:    ctx.NodeID(),
+				Signals:   map[string]*LoadSignal{},
+				Since:     p.startedAt,
+				CheckedAt: time.Now(),
+			})
+			return
+		}
+		r := l.Report(ctx)
+		if r.Status != LoadPass {
+			c.JSON(503, r)
+			return
+		}
+		c.JSON(200, r)
+	}
+}
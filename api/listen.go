@@ -0,0 +1,267 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The public API may listen on a few addresses at once. An address is a TCP host:port,
+// a Unix socket path prefixed with unix:, or a multiaddr like the ones of the fs listener:
+// /ip4/0.0.0.0/tcp/33780, /ip6/::/tcp/33780 or /unix/var/run/atlant.sock. Rebind re-creates
+// Unix sockets in place, e.g. after their files were removed, and retries addresses that
+// failed to bind, while the rest keep serving.
+
+// ListenAddr is a parsed listen address of the public API.
+type ListenAddr struct {
+	Network string
+	Address string
+}
+
+// ParseListenAddr parses a host:port, a unix: path or a multiaddr.
+func ParseListenAddr(addr string) (*ListenAddr, error) {
+	addr = strings.TrimSpace(addr)
+	switch {
+	case len(addr) == 0:
+		return nil, errors.New("empty listen address")
+	case strings.HasPrefix(addr, "unix:"):
+		path := strings.TrimPrefix(addr, "unix:")
+		if len(path) == 0 {
+			return nil, fmt.Errorf("no socket path in listen address %s", addr)
+		}
+		return &ListenAddr{"unix", path}, nil
+	case strings.HasPrefix(addr, "/"):
+		return parseListenMultiaddr(addr)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid listen address %s: %v", addr, err)
+	}
+	return &ListenAddr{"tcp", addr}, nil
+}
+
+func parseListenMultiaddr(addr string) (*ListenAddr, error) {
+	parts := strings.Split(strings.TrimPrefix(addr, "/"), "/")
+	if parts[0] == "unix" && len(parts) > 1 {
+		return &ListenAddr{"unix", "/" + strings.Join(parts[1:], "/")}, nil
+	} else if len(parts) != 4 || parts[2] != "tcp" {
+		return nil, fmt.Errorf("unsupported listen multiaddr %s", addr)
+	}
+	host, port := parts[1], parts[3]
+	switch parts[0] {
+	case "ip4":
+		if ip := net.ParseIP(host); ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid IPv4 address in multiaddr %s", addr)
+		}
+		return &ListenAddr{"tcp4", net.JoinHostPort(host, port)}, nil
+	case "ip6":
+		if ip := net.ParseIP(host); ip == nil {
+			return nil, fmt.Errorf("invalid IPv6 address in multiaddr %s", addr)
+		}
+		return &ListenAddr{"tcp6", net.JoinHostPort(host, port)}, nil
+	case "dns4", "dns6", "dns":
+		return &ListenAddr{"tcp", net.JoinHostPort(host, port)}, nil
+	}
+	return nil, fmt.Errorf("unsupported listen multiaddr %s", addr)
+}
+
+// Multiaddr returns the address in the multiaddr notation.
+func (a *ListenAddr) Multiaddr() string {
+	if a.Network == "unix" {
+		return "/unix" + a.Address
+	}
+	host, port, _ := net.SplitHostPort(a.Address)
+	proto := "ip4"
+	if ip := net.ParseIP(host); ip == nil {
+		proto = "dns"
+	} else if ip.To4() == nil {
+		proto = "ip6"
+	}
+	return fmt.Sprintf("/%s/%s/tcp/%s", proto, host, port)
+}
+
+func (a *ListenAddr) String() string {
+	if a.Network == "unix" {
+		return "unix:" + a.Address
+	}
+	return a.Address
+}
+
+type publicListener struct {
+	addr *ListenAddr
+	net.Listener
+	// replaced listeners are closed by Rebind, their serve errors are expected
+	replaced bool
+}
+
+// listen binds the address, a stale Unix socket file is removed beforehand.
+func (a *ListenAddr) listen() (net.Listener, error) {
+	if a.Network != "unix" {
+		return net.Listen(a.Network, a.Address)
+	}
+	if info, err := os.Lstat(a.Address); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(a.Address)
+	}
+	l, err := net.Listen("unix", a.Address)
+	if err != nil {
+		return nil, err
+	}
+	// socket files are removed by Close, a rebound socket must outlive its old listener
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	return l, nil
+}
+
+// rebindUnix binds a socket next to the path and renames it over the path, so the socket
+// file never disappears while the old listener is still serving.
+func (a *ListenAddr) rebindUnix() (net.Listener, error) {
+	tmpPath := a.Address + ".new"
+	os.Remove(tmpPath)
+	l, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Rename(tmpPath, a.Address); err != nil {
+		l.Close()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	return l, nil
+}
+
+func (p *PublicServer) serve(srv *http.Server, addrs []string) error {
+	parsed := make([]*ListenAddr, 0, len(addrs))
+	for _, addr := range addrs {
+		a, err := ParseListenAddr(addr)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, a)
+	}
+	if len(parsed) == 0 {
+		return errors.New("no listen addresses of the public API")
+	}
+	p.srvMux.Lock()
+	p.srv = srv
+	// Serve sets the TLS config of the server up for HTTP/2, so it's checked once here
+	p.useTLS = srv.TLSConfig != nil
+	p.addrs = parsed
+	p.listeners = nil
+	p.serveErr = make(chan error, 1)
+	var bound int
+	for _, a := range parsed {
+		if err := p.bindLocked(a); err != nil {
+			log.Warningf("failed to listen at %s: %v", a, err)
+			continue
+		}
+		bound++
+	}
+	errC := p.serveErr
+	p.srvMux.Unlock()
+	if bound == 0 {
+		return fmt.Errorf("failed to listen at any of %s", strings.Join(addrs, ", "))
+	}
+	return <-errC
+}
+
+// bindLocked listens at the address and serves on the listener, srvMux must be held.
+func (p *PublicServer) bindLocked(a *ListenAddr) error {
+	l, err := a.listen()
+	if err != nil {
+		return err
+	}
+	p.startLocked(&publicListener{addr: a, Listener: l})
+	return nil
+}
+
+func (p *PublicServer) startLocked(pl *publicListener) {
+	p.listeners = append(p.listeners, pl)
+	srv, errC, useTLS := p.srv, p.serveErr, p.useTLS
+	log.Infoln("serving public API at", pl.addr.Multiaddr())
+	go func() {
+		var err error
+		if useTLS {
+			err = srv.ServeTLS(pl, "", "")
+		} else {
+			err = srv.Serve(pl)
+		}
+		p.srvMux.Lock()
+		replaced := pl.replaced
+		p.srvMux.Unlock()
+		if replaced {
+			return
+		}
+		select {
+		case errC <- err:
+		default:
+		}
+	}()
+}
+
+// Rebind re-creates Unix sockets of the public API and listens at addresses that failed
+// to bind before. Connections accepted already are served until they are done.
+func (p *PublicServer) Rebind() error {
+	p.srvMux.Lock()
+	defer p.srvMux.Unlock()
+	if p.srv == nil {
+		return nil
+	}
+	var failed []string
+	for _, a := range p.addrs {
+		var old *publicListener
+		for _, pl := range p.listeners {
+			if pl.addr == a {
+				old = pl
+				break
+			}
+		}
+		if a.Network != "unix" {
+			if old != nil {
+				continue
+			} else if err := p.bindLocked(a); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", a, err))
+			}
+			continue
+		}
+		l, err := a.rebindUnix()
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", a, err))
+			continue
+		}
+		if old != nil {
+			p.dropLocked(old)
+		}
+		p.startLocked(&publicListener{addr: a, Listener: l})
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to rebind the public API at %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// dropLocked stops accepting on the listener, srvMux must be held.
+func (p *PublicServer) dropLocked(old *publicListener) {
+	old.replaced = true
+	old.Close()
+	for i, pl := range p.listeners {
+		if pl == old {
+			p.listeners = append(p.listeners[:i], p.listeners[i+1:]...)
+			break
+		}
+	}
+}
+
+// ListenAddrs returns addresses the public API is listening at.
+func (p *PublicServer) ListenAddrs() []*ListenAddr {
+	p.srvMux.Lock()
+	defer p.srvMux.Unlock()
+	addrs := make([]*ListenAddr, 0, len(p.listeners))
+	for _, pl := range p.listeners {
+		addrs = append(addrs, pl.addr)
+	}
+	return addrs
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/logging"
+)
+
+const (
+	defaultLogQueryLimit = 100
+	maxLogQueryLimit     = 1000
+	maxLogLineSize       = 1024 * 1024
+)
+
+type LogQueryResult struct {
+	Entries    []map[string]interface{} `json:"entries"`
+	NextOffset int                      `json:"next_offset,omitempty"`
+}
+
+// LogQueryHandler serves recent entries of the rotating log files, newest first.
+// Supported query params: since (RFC3339 or a duration like 2h), level (minimum severity),
+// grep (case-insensitive substring), offset and limit for pagination.
+func (p *PrivateServer) LogQueryHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dir := ctx.LogDir()
+		if len(dir) == 0 {
+			c.String(404, "error: log dir is not set")
+			return
+		}
+		var since time.Time
+		if v := c.Query("since"); len(v) > 0 {
+			if d, err := time.ParseDuration(v); err == nil {
+				since = time.Now().Add(-d)
+			} else if ts, err := time.Parse(time.RFC3339, v); err == nil {
+				since = ts
+			} else {
+				c.String(400, "error: since must be RFC3339 timestamp or a duration")
+				return
+			}
+		}
+		level := log.DebugLevel
+		if v := c.Query("level"); len(v) > 0 {
+			lvl, err := log.ParseLevel(v)
+			if err != nil {
+				c.String(400, "error: %v", err)
+				return
+			}
+			level = lvl
+		}
+		grep := strings.ToLower(c.Query("grep"))
+		offset, _ := strconv.Atoi(c.Query("offset"))
+		if offset < 0 {
+			offset = 0
+		}
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		if limit <= 0 {
+			limit = defaultLogQueryLimit
+		} else if limit > maxLogQueryLimit {
+			limit = maxLogQueryLimit
+		}
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.log"))
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		// file names are dates, so the reverse order is the newest first
+		sort.Sort(sort.Reverse(sort.StringSlice(files)))
+		result := &LogQueryResult{
+			Entries: make([]map[string]interface{}, 0, limit),
+		}
+		var skipped int
+		for _, name := range files {
+			day, err := time.ParseInLocation("2006-01-02", strings.TrimSuffix(filepath.Base(name), ".log"), time.Local)
+			if err == nil && !since.IsZero() && day.AddDate(0, 0, 1).Before(since) {
+				break
+			}
+			entries, err := readLogEntries(name, since, level, grep)
+			if err != nil {
+				log.Warningf("failed to read log file %s: %v", name, err)
+				continue
+			}
+			for i := len(entries) - 1; i >= 0; i-- {
+				if skipped < offset {
+					skipped++
+					continue
+				}
+				if len(result.Entries) == limit {
+					result.NextOffset = offset + limit
+					c.JSON(200, result)
+					return
+				}
+				result.Entries = append(result.Entries, entries[i])
+			}
+		}
+		c.JSON(200, result)
+	}
+}
+
+func readLogEntries(name string, since time.Time, level log.Level, grep string) ([]map[string]interface{}, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []map[string]interface{}
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), maxLogLineSize)
+	for s.Scan() {
+		line := s.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if len(grep) > 0 && !strings.Contains(strings.ToLower(string(line)), grep) {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			// not a JSON formatted entry, e.g. a panic trace
+			entries = append(entries, map[string]interface{}{
+				"raw": string(line),
+			})
+			continue
+		}
+		if v, ok := entry["level"].(string); ok {
+			if lvl, err := log.ParseLevel(v); err == nil && lvl > level {
+				continue
+			}
+		}
+		if v, ok := entry["time"].(string); ok && !since.IsZero() {
+			if ts, err := time.Parse(time.RFC3339, v); err == nil && ts.Before(since) {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, s.Err()
+}
+
+// LogLevelsHandler lists log levels of modules and the default level.
+func (p *PrivateServer) LogLevelsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, logging.Levels())
+	}
+}
+
+// LogLevelSetHandler changes the log level of a module, or the default one if the module
+// is "default". Supported query params: level (a name or 0...5) and for, a duration after
+// which the previous level is restored.
+func (p *PrivateServer) LogLevelSetHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		level, err := logging.ParseLevel(c.Query("level"))
+		if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		var d time.Duration
+		if v := c.Query("for"); len(v) > 0 {
+			if d, err = time.ParseDuration(v); err != nil || d < 0 {
+				c.String(400, "error: for must be a positive duration")
+				return
+			}
+		}
+		module := c.Param("module")
+		if err := logging.SetLevelFor(module, level, d); err != nil {
+			c.String(404, "error: %v", err)
+			return
+		}
+		log.WithFields(log.Fields{
+			"module": module,
+			"level":  level.String(),
+			"for":    d.String(),
+		}).Infoln("log level changed")
+		c.JSON(200, logging.Levels())
+	}
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/metrics"
+	"github.com/AtlantPlatform/atlant-go/rs"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// slowStatsTTL limits how often repo stats and key counts are collected, both walk
+// the whole store and are too expensive to be done on every scrape.
+const slowStatsTTL = time.Minute
+
+type bucketKeys struct {
+	bucket state.BucketID
+	keys   int
+}
+
+type slowStats struct {
+	mux       *sync.Mutex
+	updatedAt time.Time
+	repo      *fs.RepoStats
+	keys      []bucketKeys
+}
+
+func newSlowStats() *slowStats {
+	return &slowStats{
+		mux: new(sync.Mutex),
+	}
+}
+
+func (s *slowStats) Get(ctx APIContext) (*fs.RepoStats, []bucketKeys) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if time.Since(s.updatedAt) < slowStatsTTL {
+		return s.repo, s.keys
+	}
+	s.repo = ctx.FileStore().RepoStats()
+	s.keys = s.keys[:0]
+	for _, id := range state.Buckets() {
+		count, err := state.CountKeys(ctx, ctx.StateStore(), id)
+		if err != nil {
+			log.Debugf("failed to count keys of bucket %s: %v", id, err)
+			continue
+		}
+		s.keys = append(s.keys, bucketKeys{id, count})
+	}
+	s.updatedAt = time.Now()
+	return s.repo, s.keys
+}
+
+// MetricsHandler exposes metrics of the catalog in the Prometheus text format.
+func (p *PrivateServer) MetricsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		e := metrics.NewEncoder()
+		p.collectMetrics(ctx.WithRequest(c), e)
+		if err := e.Err(); err != nil {
+			log.Warningf("metrics don't match the catalog: %v", err)
+		}
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(200)
+		e.WriteTo(c.Writer)
+	}
+}
+
+func (p *PrivateServer) collectMetrics(ctx APIContext, e *metrics.Encoder) {
+	store := ctx.RecordStore()
+	e.Gauge(metrics.NodeUptime, time.Since(p.startedAt).Seconds())
+	e.Gauge(metrics.NodeInfo, 1, ctx.NodeID(), ctx.Version())
+	if clock := store.ClockStatus(); clock.Source != "none" {
+		if skew, err := time.ParseDuration(clock.Skew); err == nil {
+			e.Gauge(metrics.NodeClockSkew, skew.Seconds())
+		}
+	}
+	if throttles := ctx.ThrottleStats(); throttles != nil {
+		e.Gauge(metrics.APIRequestsInFlight, float64(throttles.InFlight))
+		e.Gauge(metrics.APISubscriptions, float64(throttles.Subscriptions))
+		for _, reason := range []string{
+			ThrottleRate, ThrottleStreams, ThrottleTokenStreams,
+			ThrottleSubscriptions, ThrottleTokenSubscriptions, ThrottleQuota,
+		} {
+			e.Counter(metrics.APIThrottled, float64(throttles.Refused[reason]), reason)
+		}
+	}
+	if load := ctx.LoadReport(); load != nil {
+		draining := 0.0
+		if load.Status != LoadPass {
+			draining = 1
+		}
+		e.Gauge(metrics.APILoadDraining, draining)
+	}
+	for _, s := range ctx.CacheStats() {
+		class := string(s.Class)
+		e.Counter(metrics.CacheHits, float64(s.Hits), class)
+		e.Counter(metrics.CacheMisses, float64(s.Misses), class)
+		e.Counter(metrics.CacheEvictions, float64(s.Evictions), class)
+		e.Gauge(metrics.CacheEntries, float64(s.Entries), class)
+	}
+	if shadow := ctx.ShadowStats(); shadow != nil {
+		e.Counter(metrics.APIShadowed, float64(shadow.Matched), "matched")
+		e.Counter(metrics.APIShadowed, float64(shadow.Diverged), "diverged")
+		e.Counter(metrics.APIShadowed, float64(shadow.Failed), "failed")
+		e.Counter(metrics.APIShadowed, float64(shadow.Dropped), "dropped")
+	}
+
+	queues := store.QueueStats()
+	e.Gauge(metrics.RSInboundQueueDepth, float64(queues.InboundDepth))
+	e.Gauge(metrics.RSOutboundQueueDepth, float64(queues.OutboundDepth))
+	e.Gauge(metrics.RSOutboundJournaled, float64(queues.Journal.Pending))
+	e.Gauge(metrics.RSInboundBusyWorkers, float64(queues.Inbound.Busy))
+	e.Gauge(metrics.RSInboundMaxDepth, float64(queues.Inbound.MaxDepth))
+	e.Counter(metrics.RSInboundFailed, float64(queues.Inbound.Failed))
+	e.Histogram(metrics.RSInboundWait, queues.Inbound.Wait)
+	syncs := store.SyncStats()
+	e.Histogram(metrics.RSSyncDuration, syncs.Durations)
+	e.Counter(metrics.RSSyncFailures, float64(syncs.Failures))
+	for name, st := range store.CircuitStates() {
+		e.Gauge(metrics.RSCircuitOpen, boolValue(st == "open"), name)
+	}
+	watches := store.WatchStats()
+	e.Gauge(metrics.RSWatchers, float64(watches.Watchers))
+	e.Counter(metrics.RSWatchDelivered, float64(watches.Delivered))
+	e.Counter(metrics.RSWatchDropped, float64(watches.Dropped))
+	webhooks := store.WebhookStats()
+	e.Gauge(metrics.RSWebhookQueued, float64(webhooks.Queued))
+	e.Counter(metrics.RSWebhookDelivered, float64(webhooks.Delivered))
+	e.Counter(metrics.RSWebhookFailed, float64(webhooks.Failed))
+	e.Counter(metrics.RSWebhookDeadLetters, float64(webhooks.DeadLetter))
+	dedup := store.DedupStats()
+	e.Counter(metrics.RSDedupChecked, float64(dedup.Checked))
+	e.Counter(metrics.RSDedupDuplicates, float64(dedup.Duplicates))
+	e.Gauge(metrics.RSProtocolWriteBlocked, boolValue(len(store.ProtocolStatus().WriteError) > 0))
+	retention := store.RetentionStatus()
+	e.Counter(metrics.RSRetentionDropped, float64(retention.VersionsDropped))
+	e.Counter(metrics.RSRetentionReclaimed, float64(retention.BytesReclaimed))
+	e.Counter(metrics.RSRateLimited, float64(store.RateLimitStats().Rejected))
+	for _, rep := range store.SLOReports() {
+		e.Gauge(metrics.RSSLOAvailability, rep.Availability, rep.Namespace)
+		e.Gauge(metrics.RSSLOLatencyP99, rep.LatencyP99, rep.Namespace)
+		e.Gauge(metrics.RSSLOReplicationLagP99, rep.ReplicationLagP99, rep.Namespace)
+		if rep.SLO == nil {
+			continue
+		}
+		for _, sli := range []string{rs.SLIAvailability, rs.SLILatency, rs.SLIReplicationLag} {
+			var breached float64
+			for _, b := range rep.Breaches {
+				if b == sli {
+					breached = 1
+				}
+			}
+			e.Gauge(metrics.RSSLOBreached, breached, rep.Namespace, sli)
+		}
+	}
+	gc := store.GCStatus()
+	e.Counter(metrics.RSGCRuns, float64(gc.Runs))
+	e.Counter(metrics.RSGCFailures, float64(gc.Failures))
+	e.Counter(metrics.RSGCVersionsDropped, float64(gc.VersionsDropped))
+	e.Counter(metrics.RSGCOrphansUnpinned, float64(gc.OrphansUnpinned))
+	e.Counter(metrics.RSGCReclaimed, float64(gc.BytesReclaimed))
+	disk := store.DiskUsageStatus()
+	e.Gauge(metrics.RSDiskUsage, float64(disk.Usage))
+	e.Gauge(metrics.RSDiskBudget, float64(disk.MaxUsage))
+	e.Counter(metrics.RSDiskWritesRefused, float64(disk.WritesRefused))
+	e.Counter(metrics.RSDiskPinsSkipped, float64(disk.PinsSkipped))
+	repin := store.RepinStatus()
+	e.Gauge(metrics.RSRepinPending, float64(repin.Pending))
+	e.Counter(metrics.RSRepinRepaired, float64(repin.Repaired))
+	e.Counter(metrics.RSRepinFetched, float64(repin.Fetched))
+	e.Gauge(metrics.RSRepinLost, float64(repin.LostCount))
+	antiEntropy := store.AntiEntropyStatus()
+	e.Counter(metrics.RSAntiEntropyRounds, float64(antiEntropy.Rounds))
+	e.Counter(metrics.RSAntiEntropyRepaired, float64(antiEntropy.Repaired))
+	e.Counter(metrics.RSAntiEntropyBytes, float64(antiEntropy.Bytes))
+
+	fileStore := ctx.FileStore()
+	repo, keys := p.slowStats.Get(ctx)
+	if bitswap := fileStore.BitswapStats(); bitswap != nil {
+		e.Gauge(metrics.FSPeers, float64(len(bitswap.Peers)))
+	}
+	if repo != nil {
+		e.Gauge(metrics.FSPins, float64(repo.NumPins))
+	}
+	if bw := fileStore.BandwidthStats(); bw != nil {
+		e.Counter(metrics.FSBandwidthIn, float64(bw.TotalIn))
+		e.Counter(metrics.FSBandwidthOut, float64(bw.TotalOut))
+	}
+	if repo != nil {
+		e.Gauge(metrics.FSRepoSize, float64(repo.RepoSize))
+		e.Gauge(metrics.FSRepoStorageMax, float64(repo.StorageMax))
+		e.Gauge(metrics.FSRepoObjects, float64(repo.NumObjects))
+	}
+	if disk, err := fileStore.DiskStats(); err == nil {
+		e.Gauge(metrics.FSDiskTotal, float64(disk.BytesAll))
+		e.Gauge(metrics.FSDiskFree, float64(disk.BytesFree))
+	}
+	if blocklist := fileStore.BlocklistStats(); blocklist != nil {
+		e.Counter(metrics.FSBlocklistRefused, float64(blocklist.Refused))
+	}
+	if debts := fileStore.DebtLimitStats(); debts != nil {
+		e.Gauge(metrics.FSBitswapThrottled, float64(len(debts.Throttled)))
+		e.Counter(metrics.FSBitswapThrottles, float64(debts.ThrottledTotal))
+	}
+	if pex := fileStore.PeerExchangeStats(); pex != nil {
+		e.Gauge(metrics.FSExchangedPeers, float64(pex.Known))
+	}
+	if mmap := fileStore.MmapCacheStats(); mmap != nil {
+		e.Gauge(metrics.FSMmapCacheBytes, float64(mmap.Bytes))
+		e.Counter(metrics.FSMmapCacheHits, float64(mmap.Hits))
+		e.Counter(metrics.FSMmapCacheEvictions, float64(mmap.Evictions))
+	}
+	if inline := fileStore.InlineStats(); inline != nil {
+		e.Counter(metrics.FSInlineStored, float64(inline.Stored))
+		e.Counter(metrics.FSInlineHits, float64(inline.Hits))
+	}
+
+	for _, b := range keys {
+		e.Gauge(metrics.StateKeys, float64(b.keys), b.bucket.String())
+	}
+	stateStats := ctx.StateStore().Stats()
+	e.Counter(metrics.StateRejectedKeys, float64(stateStats.RejectedKeys))
+	e.Counter(metrics.StateRejectedValues, float64(stateStats.RejectedValues))
+	badger := store.BadgerStats()
+	e.Counter(metrics.StateBadgerBlockedPuts, float64(badger.NumBlockedPuts))
+	e.Counter(metrics.StateBadgerWrittenBytes, float64(badger.NumBytesWritten))
+	conflicts := make([]string, 0, len(stateStats.Conflicts))
+	for _, id := range state.Buckets() {
+		if _, ok := stateStats.Conflicts[id.String()]; ok {
+			conflicts = append(conflicts, id.String())
+		}
+	}
+	for _, bucket := range conflicts {
+		e.Counter(metrics.StateWriteAttempts, float64(stateStats.Conflicts[bucket].Attempts), bucket)
+	}
+	for _, bucket := range conflicts {
+		e.Counter(metrics.StateWriteConflicts, float64(stateStats.Conflicts[bucket].Conflicts), bucket)
+	}
+	for _, bucket := range conflicts {
+		e.Counter(metrics.StateConflictsExhausted, float64(stateStats.Conflicts[bucket].Exhausted), bucket)
+	}
+	if maint := stateStats.Maintenance; maint != nil {
+		e.Counter(metrics.StateGCRuns, float64(maint.Runs))
+		e.Counter(metrics.StateGCFailures, float64(maint.Failures))
+		e.Counter(metrics.StateGCRewrites, float64(maint.Rewrites))
+		e.Counter(metrics.StateGCReclaimedBytes, float64(maint.ReclaimedBytes))
+	}
+}
+
+func boolValue(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// MirrorStatusHandler reports the mirror policy of the node and passes that apply it.
+func (p *PrivateServer) MirrorStatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().MirrorStatus())
+	}
+}
+
+// MirrorPolicyHandler replaces the mirror policy with comma-separated prefixes and namespaces
+// of the query, without both the node becomes a full replica.
+func (p *PrivateServer) MirrorPolicyHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy := &rs.MirrorPolicy{
+			Prefixes:   splitQuery(c.Query("prefixes")),
+			Namespaces: splitQuery(c.Query("namespaces")),
+		}
+		if err := ctx.RecordStore().SetMirrorPolicy(ctx, policy); err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		c.JSON(200, ctx.RecordStore().MirrorStatus())
+	}
+}
+
+// MirrorApplyHandler runs a mirror pass and reports the outcome.
+func (p *PrivateServer) MirrorApplyHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := ctx.RecordStore().ApplyMirror(c.Request.Context()); err == rs.ErrMirrorRunning {
+			c.String(409, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, ctx.RecordStore().MirrorStatus())
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// Applications sharing a node may use routes scoped to their namespace at /api/v1/ns/:name/,
+// where paths are relative to the namespace and records of other namespaces are not found.
+// Scoped requests are rewritten to the global routes before throttles, policies and ingest
+// forwarding, so these see the full path of the record.
+
+const namespaceRoutes = "/api/v1/ns/"
+
+func routeNamespaces(r *gin.Engine, p *PublicServer, ctx APIContext, idempotent gin.HandlerFunc) {
+	ns := r.Group(namespaceRoutes + ":ns")
+	ns.POST("/put/*path", idempotent, ingestProxy(ctx), p.PutHandler(ctx))
+	ns.POST("/delete/:id", idempotent, ingestProxy(ctx), p.DeleteHandler(ctx))
+	ns.GET("/content/*path", p.ContentHandler(ctx))
+	ns.GET("/meta/*path", p.MetaHandler(ctx))
+	ns.GET("/annotations/*path", p.AnnotationsHandler(ctx))
+	ns.POST("/annotate/*path", p.AnnotateHandler(ctx))
+	ns.GET("/listVersions/*path", p.ListVersionsHandler(ctx))
+	ns.GET("/listAll/*prefix", p.ListAllHandler(ctx))
+}
+
+// namespaceScope rewrites requests to namespace routes into requests to the global ones.
+func namespaceScope(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.Request.URL.Path, namespaceRoutes) {
+			c.Next()
+			return
+		}
+		ns := c.Param("ns")
+		if len(ns) == 0 || ns == "." || ns == ".." {
+			c.String(400, "error: invalid namespace")
+			c.Abort()
+			return
+		}
+		route := strings.TrimPrefix(c.Request.URL.Path, namespaceRoutes+ns+"/")
+		if idx := strings.IndexByte(route, '/'); idx > 0 {
+			route = route[:idx]
+		}
+		for i, param := range c.Params {
+			switch param.Key {
+			case "path", "prefix":
+				c.Params[i].Value = "/" + ns + "/" + strings.TrimPrefix(param.Value, "/")
+				c.Request.URL.Path = "/api/v1/" + route + c.Params[i].Value
+			case "id":
+				if !inNamespace(ctx, c, ns, param.Value) {
+					c.Status(404)
+					c.Abort()
+					return
+				}
+				c.Request.URL.Path = "/api/v1/" + route + "/" + param.Value
+			}
+		}
+		c.Request.URL.RawPath = ""
+		c.Next()
+	}
+}
+
+// inNamespace tells whether the record with the given ID is stored in the namespace.
+func inNamespace(ctx APIContext, c *gin.Context, ns, id string) bool {
+	history, err := ctx.RecordStore().RecordHistory(ctx.WithRequest(c), id)
+	if err != nil {
+		return false
+	}
+	return rs.NamespaceOf(history.Path) == ns
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"html/template"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// Network stats are a public, anonymized summary of the network as seen by the node, for
+// community explorers that aggregate them across nodes. They carry no IDs or addresses of
+// nodes: counts of records and bytes stored by this node, its swarm peers and uptime, and
+// histograms of versions of nodes, taken from their last lifecycle events, and of protocol
+// versions peers presented. Stats are collected at most once per networkStatsTTL, since
+// records are counted by a walk of the state store.
+
+const (
+	networkStatsTTL    = time.Minute
+	networkStatsWindow = 24 * time.Hour
+)
+
+// NetworkStats is the anonymized summary served at /api/v1/network.
+type NetworkStats struct {
+	Version string `json:"version"`
+	Network string `json:"network"`
+	// Records is the number of records known to the node, tombstones included.
+	Records int `json:"records"`
+	// Bytes is the size of the IPFS repo of the node.
+	Bytes  uint64 `json:"bytes"`
+	Peers  int    `json:"peers"`
+	Uptime int64  `json:"uptime_seconds"`
+	// Nodes is the number of nodes that published lifecycle events within a day
+	// and haven't stopped since.
+	Nodes     int            `json:"nodes"`
+	Versions  map[string]int `json:"versions"`
+	Protocols map[string]int `json:"protocols"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+type networkStats struct {
+	mux       *sync.Mutex
+	startedAt time.Time
+	last      *NetworkStats
+}
+
+func newNetworkStats(startedAt time.Time) *networkStats {
+	return &networkStats{
+		mux:       new(sync.Mutex),
+		startedAt: startedAt,
+	}
+}
+
+// get returns stats collected within networkStatsTTL, collecting them if there are none.
+func (n *networkStats) get(ctx APIContext) *NetworkStats {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if n.last != nil && time.Since(n.last.UpdatedAt) < networkStatsTTL {
+		return n.last
+	}
+	n.last = n.collect(ctx)
+	return n.last
+}
+
+func (n *networkStats) collect(ctx APIContext) *NetworkStats {
+	store := ctx.RecordStore()
+	stats := &NetworkStats{
+		Version:   ctx.Version(),
+		Network:   ctx.Env(),
+		Peers:     len(ctx.FileStore().SwarmPeers()),
+		Uptime:    int64(time.Since(n.startedAt).Seconds()),
+		Versions:  make(map[string]int),
+		Protocols: make(map[string]int),
+		UpdatedAt: time.Now().UTC(),
+	}
+	if repo := ctx.FileStore().RepoStats(); repo != nil {
+		stats.Bytes = repo.RepoSize
+	}
+	walkCtx, cancelFn := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelFn()
+	if _, err := store.WalkRecordsPage(walkCtx, "", 0, func(_ string, _ *rs.Record) error {
+		stats.Records++
+		return nil
+	}); err != nil {
+		log.Debugf("failed to count records for network stats: %v", err)
+	}
+	events, err := store.LifecycleEvents(walkCtx, "", time.Now().Add(-networkStatsWindow))
+	if err != nil {
+		log.Debugf("failed to list lifecycle events for network stats: %v", err)
+	}
+	// events are in order, the last one of a node wins
+	lastEvents := make(map[string]*rs.LifecycleEvent, len(events))
+	for _, e := range events {
+		lastEvents[e.NodeID] = e
+	}
+	for _, e := range lastEvents {
+		if e.State == rs.LifecycleStopped {
+			continue
+		}
+		stats.Nodes++
+		stats.Versions[versionLabel(e.Version)]++
+	}
+	for _, peer := range store.ProtocolStatus().Peers {
+		stats.Protocols[strconv.Itoa(int(peer.Version))]++
+	}
+	return stats
+}
+
+// versionLabel keeps a version short, so that custom builds don't make every node unique.
+func versionLabel(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) == 0 {
+		return "unknown"
+	} else if len(v) > 32 {
+		return v[:32]
+	}
+	return v
+}
+
+// NetworkStatsHandler serves the network stats as JSON.
+func (p *PublicServer) NetworkStatsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Cache-Control", "public, max-age=60")
+		c.JSON(200, p.netStats.get(ctx))
+	}
+}
+
+// NetworkPageHandler serves the network stats as an HTML page.
+func (p *PublicServer) NetworkPageHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats := p.netStats.get(ctx)
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Header("Cache-Control", "public, max-age=60")
+		if err := networkPageTemplate.Execute(c.Writer, &networkPage{
+			NetworkStats: stats,
+			Versions:     sortedCounts(stats.Versions),
+			Protocols:    sortedCounts(stats.Protocols),
+			Uptime:       (time.Duration(stats.Uptime) * time.Second).String(),
+		}); err != nil {
+			log.Debugf("failed to render network page: %v", err)
+		}
+	}
+}
+
+type networkPage struct {
+	*NetworkStats
+	Versions  []labelCount
+	Protocols []labelCount
+	Uptime    string
+}
+
+type labelCount struct {
+	Label string
+	Count int
+}
+
+// sortedCounts lists counts of a histogram, the most frequent first.
+func sortedCounts(m map[string]int) []labelCount {
+	list := make([]labelCount, 0, len(m))
+	for label, count := range m {
+		list = append(list, labelCount{label, count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count == list[j].Count {
+			return list[i].Label < list[j].Label
+		}
+		return list[i].Count > list[j].Count
+	})
+	return list
+}
+
+var networkPageTemplate = template.Must(template.New("Network").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ATLANT network</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+td, th { padding: 0.3em 1em; text-align: left; border-bottom: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<h1>ATLANT network{{if .Network}} ({{.Network}}){{end}}</h1>
+<table>
+<tr><th>Records</th><td>{{.Records}}</td></tr>
+<tr><th>Bytes stored</th><td>{{.Bytes}}</td></tr>
+<tr><th>Swarm peers</th><td>{{.Peers}}</td></tr>
+<tr><th>Active nodes</th><td>{{.Nodes}}</td></tr>
+<tr><th>Uptime</th><td>{{.Uptime}}</td></tr>
+<tr><th>Node version</th><td>{{.Version}}</td></tr>
+</table>
+<h2>Versions of nodes</h2>
+<table>
+{{range .Versions}}<tr><td>{{.Label}}</td><td>{{.Count}}</td></tr>
+{{else}}<tr><td>no lifecycle events seen</td></tr>
+{{end}}</table>
+<h2>Protocol versions of peers</h2>
+<table>
+{{range .Protocols}}<tr><td>{{.Label}}</td><td>{{.Count}}</td></tr>
+{{else}}<tr><td>no peers seen</td></tr>
+{{end}}</table>
+<p>Updated at {{.UpdatedAt.Format "2006-01-02 15:04:05 MST"}}, JSON at <a href="/api/v1/network">/api/v1/network</a>.</p>
+</body>
+</html>
+`))
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/metrics"
+)
+
+// ObservabilityBundleHandler serves Prometheus alerting rules and a Grafana dashboard
+// generated from the node's metric catalog, packed into a tarball.
+func (p *PrivateServer) ObservabilityBundleHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		buf := new(bytes.Buffer)
+		if err := metrics.WriteBundle(buf, ctx.Version()); err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.Header("Content-Disposition",
+			fmt.Sprintf("attachment; filename=\"atlant-observability-%s.tar.gz\"", ctx.Version()))
+		c.Data(200, "application/gzip", buf.Bytes())
+	}
+}
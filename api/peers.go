@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/fs"
+)
+
+const peerConnectTimeout = 30 * time.Second
+
+// ClusterPeer is a swarm peer with its standing in the cluster.
+type ClusterPeer struct {
+	*fs.SwarmPeer
+
+	// Version is the gossip protocol version reported by the peer, nil if it wasn't seen yet.
+	Version     *uint16                 `json:"version,omitempty"`
+	LastSeen    time.Time               `json:"last_seen,omitempty"`
+	Permissions []authcenter.Permission `json:"permissions"`
+}
+
+// PeersHandler lists connected swarm peers with their versions and permissions.
+func (p *PrivateServer) PeersHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		seen := make(map[string]*ClusterPeer)
+		for _, v := range ctx.RecordStore().ProtocolStatus().Peers {
+			version := v.Version
+			seen[v.NodeID] = &ClusterPeer{
+				Version:  &version,
+				LastSeen: v.LastSeen,
+			}
+		}
+		swarm := ctx.FileStore().SwarmPeers()
+		peers := make([]*ClusterPeer, 0, len(swarm))
+		for _, sp := range swarm {
+			cp, ok := seen[sp.NodeID]
+			if !ok {
+				cp = &ClusterPeer{}
+			}
+			cp.SwarmPeer = sp
+			cp.Permissions = authcenter.AllPermissions(sp.NodeID)
+			if cp.Permissions == nil {
+				cp.Permissions = []authcenter.Permission{}
+			}
+			if authcenter.OnProbation(sp.NodeID) {
+				cp.Permissions = append(cp.Permissions, authcenter.ProbationPermission)
+			}
+			peers = append(peers, cp)
+		}
+		c.JSON(200, gin.H{
+			"node_id": ctx.NodeID(),
+			"peers":   peers,
+		})
+	}
+}
+
+type peerConnectRequest struct {
+	Addr string `json:"addr"`
+}
+
+// PeerConnectHandler connects to a peer by its multiaddr ending with /ipfs/<node ID>.
+func (p *PrivateServer) PeerConnectHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req *peerConnectRequest
+		if err := c.BindJSON(&req); err != nil {
+			return
+		} else if len(req.Addr) == 0 {
+			c.String(400, "error: addr is required")
+			return
+		}
+		reqCtx, cancelFn := context.WithTimeout(ctx.WithRequest(c), peerConnectTimeout)
+		defer cancelFn()
+		nodeID, err := ctx.FileStore().ConnectPeer(reqCtx, req.Addr)
+		if err == fs.ErrNoPeerHost {
+			c.String(503, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(502, "error: %v", err)
+			return
+		}
+		c.JSON(200, gin.H{
+			"node_id": nodeID,
+		})
+	}
+}
+
+// PeerDisconnectHandler closes connections with a peer, it may reconnect later.
+func (p *PrivateServer) PeerDisconnectHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := ctx.FileStore().DisconnectPeer(c.Param("node"))
+		if err == fs.ErrNoPeerHost {
+			c.String(503, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		c.Status(204)
+	}
+}
+
+// ExchangedPeersHandler lists peers kept by the peer exchange as bootstrap candidates.
+func (p *PrivateServer) ExchangedPeersHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats := ctx.FileStore().PeerExchangeStats()
+		if stats == nil {
+			c.String(501, "error: peer exchange is disabled")
+			return
+		}
+		peers, err := ctx.FileStore().ExchangedPeers()
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		if peers == nil {
+			peers = []*fs.ExchangedPeer{}
+		}
+		c.JSON(200, gin.H{
+			"stats": stats,
+			"peers": peers,
+		})
+	}
+}
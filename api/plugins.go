@@ -0,0 +1,27 @@
+package api
+
+import "github.com/gin-gonic/gin"
+
+// Plugin adds routes to the private API under /private/v1/plugins/<name>, see package plugins.
+type Plugin interface {
+	PluginName() string
+	MountRoutes(r gin.IRoutes, ctx APIContext)
+}
+
+// mountPlugins serves routes of plugins, each under a prefix of its own.
+func mountPlugins(r *gin.Engine, ctx APIContext) {
+	for _, plugin := range ctx.Plugins() {
+		plugin.MountRoutes(r.Group("/private/v1/plugins/"+plugin.PluginName()), ctx)
+	}
+}
+
+// PluginsHandler lists plugins of the node with their commands and whether they serve routes.
+func (p *PrivateServer) PluginsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list := ctx.Plugins()
+		if list == nil {
+			list = []Plugin{}
+		}
+		c.JSON(200, list)
+	}
+}
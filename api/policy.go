@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// Admission policies are Starlark scripts provided by the operator. A policy defines
+// decide(req) that is called for every API request, it returns True or None to admit
+// the request, False or a string with the reason to refuse it. Policies are
+// deterministic: there is no I/O and a decision may only take a bounded number of steps.
+
+const (
+	PolicyRead   = "read"
+	PolicyWrite  = "write"
+	PolicyDelete = "delete"
+	PolicyAdmin  = "admin"
+
+	maxPolicySteps = 100000
+)
+
+var ErrPolicyDecide = errors.New("policy must define decide(req)")
+
+// PolicyInput is what a policy knows of a request, it's passed to decide as a struct.
+type PolicyInput struct {
+	Op     string
+	Caller string
+	Path   string
+	Size   int64
+	Labels map[string]string
+	Time   time.Time
+}
+
+type Policy struct {
+	file   string
+	decide starlark.Callable
+}
+
+// LoadPolicy runs the policy file once and keeps its decide function.
+func LoadPolicy(file string) (*Policy, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	thread := &starlark.Thread{
+		Name: "policy",
+		Print: func(_ *starlark.Thread, msg string) {
+			log.WithField("policy", file).Infoln(msg)
+		},
+	}
+	globals, err := starlark.ExecFile(thread, file, src, starlark.StringDict{
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+	})
+	if err != nil {
+		return nil, err
+	}
+	decide, ok := globals["decide"].(starlark.Callable)
+	if !ok {
+		return nil, ErrPolicyDecide
+	}
+	globals.Freeze()
+	return &Policy{
+		file:   file,
+		decide: decide,
+	}, nil
+}
+
+// Evaluate calls decide with the input, a refused request has a reason.
+func (p *Policy) Evaluate(in *PolicyInput) (bool, string, error) {
+	labels := starlark.NewDict(len(in.Labels))
+	for k, v := range in.Labels {
+		labels.SetKey(starlark.String(k), starlark.String(v))
+	}
+	req := starlarkstruct.FromStringDict(starlark.String("request"), starlark.StringDict{
+		"op":     starlark.String(in.Op),
+		"caller": starlark.String(in.Caller),
+		"path":   starlark.String(in.Path),
+		"size":   starlark.MakeInt64(in.Size),
+		"labels": labels,
+		"time":   starlark.MakeInt64(in.Time.Unix()),
+		"hour":   starlark.MakeInt(in.Time.UTC().Hour()),
+	})
+	req.Freeze()
+	thread := &starlark.Thread{
+		Name: "decide",
+	}
+	thread.SetMaxExecutionSteps(maxPolicySteps)
+	v, err := starlark.Call(thread, p.decide, starlark.Tuple{req}, nil)
+	if err != nil {
+		return false, "", err
+	}
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return true, "", nil
+	case starlark.Bool:
+		if v {
+			return true, "", nil
+		}
+		return false, "refused by policy", nil
+	case starlark.String:
+		return false, string(v), nil
+	default:
+		return false, "", fmt.Errorf("decide returned %s, want bool, None or string", v.Type())
+	}
+}
+
+// admit evaluates the policy, refusals and failures are reported to the client.
+// A policy that fails refuses the request.
+func (p *Policy) admit(w http.ResponseWriter, in *PolicyInput) bool {
+	ok, reason, err := p.Evaluate(in)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"policy": p.file,
+			"op":     in.Op,
+			"path":   in.Path,
+		}).Warningf("policy evaluation failed: %v", err)
+		http.Error(w, "error: policy evaluation failed", http.StatusForbidden)
+		return false
+	} else if !ok {
+		http.Error(w, "error: "+reason, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// policyCheck admits public API requests, the op is derived from the route.
+func policyCheck(ctx APIContext) gin.HandlerFunc {
+	policy := ctx.Policy()
+	header := ctx.ClientHeader()
+	if policy == nil {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	return func(c *gin.Context) {
+		if c.GetBool(presignedKey) {
+			// pre-signed URLs need no other auth
+			c.Next()
+			return
+		} else if c.Request.URL.Path == batchGetRoute {
+			// paths of a batch are checked one by one, see batchGet
+			c.Next()
+			return
+		}
+		in := &PolicyInput{
+			Op:     PolicyRead,
+			Caller: clientID(c, header),
+			Path:   c.Param("path"),
+			Labels: make(map[string]string),
+			Time:   time.Now(),
+		}
+		switch {
+		case strings.HasPrefix(c.Request.URL.Path, "/api/v1/put/"),
+			strings.HasPrefix(c.Request.URL.Path, "/api/v1/upload"):
+			in.Op = PolicyWrite
+			in.Size, _ = strconv.ParseInt(c.Request.Header.Get("Content-Length"), 10, 64)
+			userMetaLabels(in.Labels, c.Request.Header.Get("X-Meta-UserMeta"))
+		case strings.HasPrefix(c.Request.URL.Path, "/api/v1/delete/"):
+			in.Op = PolicyDelete
+			in.Path = c.Param("id")
+		case len(in.Path) == 0:
+			in.Path = c.Param("prefix")
+		}
+		if ns := rs.NamespaceOf(strings.TrimSuffix(in.Path, "/") + "/"); len(ns) > 0 {
+			in.Labels["namespace"] = ns
+		}
+		if !policy.admit(c.Writer, in) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// userMetaLabels adds top-level string fields of the user meta as meta.<field> labels.
+func userMetaLabels(labels map[string]string, userMeta string) {
+	if len(userMeta) == 0 {
+		return
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(userMeta), &fields); err != nil {
+		return
+	}
+	for k, v := range fields {
+		if s, ok := v.(string); ok {
+			labels["meta."+k] = s
+		}
+	}
+}
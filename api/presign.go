@@ -0,0 +1,196 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Pre-signed URLs let external parties read a single record for a limited time, without
+// any other auth. The URL carries the expiry, an optional client IP and an HMAC of these
+// with the path of the record, made with a key kept in the state dir. Admission policies
+// are skipped for requests with a valid signature, a malformed, expired or foreign one is
+// refused. Rotating the key revokes all URLs issued so far.
+
+const (
+	presignExpiresParam   = "expires"
+	presignIPParam        = "ip"
+	presignSignatureParam = "signature"
+
+	presignRoute      = "/api/v1/content"
+	presignedKey      = "presigned"
+	presignVersion    = "v1"
+	defaultPresignTTL = time.Hour
+	maxPresignTTL     = 7 * 24 * time.Hour
+)
+
+var (
+	ErrPresignExpired   = errors.New("pre-signed URL has expired")
+	ErrPresignSignature = errors.New("pre-signed URL signature is invalid")
+	ErrPresignIP        = errors.New("pre-signed URL was issued for another client")
+)
+
+// URLSigner signs and verifies pre-signed URLs of records.
+type URLSigner struct {
+	key *PrivateToken
+}
+
+// NewURLSigner returns a signer using the key, it's stored the same way as the private token.
+func NewURLSigner(key *PrivateToken) *URLSigner {
+	return &URLSigner{
+		key: key,
+	}
+}
+
+func (s *URLSigner) signature(path string, expires int64, ip string) string {
+	mac := hmac.New(sha256.New, []byte(s.key.String()))
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s", presignVersion, path, expires, ip)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the query of a URL that grants reads of the path until expiresAt,
+// only to the client with that IP if one is set.
+func (s *URLSigner) Sign(path string, expiresAt time.Time, ip string) url.Values {
+	q := make(url.Values)
+	q.Set(presignExpiresParam, strconv.FormatInt(expiresAt.Unix(), 10))
+	if len(ip) > 0 {
+		q.Set(presignIPParam, ip)
+	}
+	q.Set(presignSignatureParam, s.signature(path, expiresAt.Unix(), ip))
+	return q
+}
+
+// Verify checks the query of a pre-signed URL of the path requested by the client.
+func (s *URLSigner) Verify(path string, q url.Values, client string) error {
+	expires, err := strconv.ParseInt(q.Get(presignExpiresParam), 10, 64)
+	if err != nil {
+		return ErrPresignSignature
+	}
+	ip := q.Get(presignIPParam)
+	sig := s.signature(path, expires, ip)
+	if !hmac.Equal([]byte(sig), []byte(q.Get(presignSignatureParam))) {
+		return ErrPresignSignature
+	} else if time.Now().Unix() > expires {
+		return ErrPresignExpired
+	} else if len(ip) > 0 && ip != client {
+		return ErrPresignIP
+	}
+	return nil
+}
+
+// presignedReads verifies signatures of pre-signed URLs, requests with a valid one
+// are marked so that admission policies let them through.
+func presignedReads(ctx APIContext) gin.HandlerFunc {
+	signer := ctx.URLSigner()
+	header := ctx.ClientHeader()
+	return func(c *gin.Context) {
+		q := c.Request.URL.Query()
+		if len(q.Get(presignSignatureParam)) == 0 {
+			c.Next()
+			return
+		}
+		if signer == nil {
+			c.String(403, "error: pre-signed URLs are not enabled")
+			c.Abort()
+			return
+		} else if (c.Request.Method != "GET" && c.Request.Method != "HEAD") ||
+			!strings.HasPrefix(c.Request.URL.Path, presignRoute+"/") {
+			c.String(403, "error: pre-signed URLs are valid for record content only")
+			c.Abort()
+			return
+		}
+		client := clientID(c, header)
+		if err := signer.Verify(c.Param("path"), q, client); err != nil {
+			log.WithFields(log.Fields{
+				"path":   c.Param("path"),
+				"client": client,
+			}).Debugln(err)
+			c.String(403, "error: %v", err)
+			c.Abort()
+			return
+		}
+		c.Set(presignedKey, true)
+		c.Next()
+	}
+}
+
+type presignRequest struct {
+	Path string `json:"path"`
+	// TTL is a duration like 24h, an hour by default.
+	TTL string `json:"ttl"`
+	// IP restricts the URL to a client address, as seen by the public API.
+	IP string `json:"ip"`
+}
+
+type presignResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PresignHandler issues a pre-signed URL of a record path, relative to the public API.
+func (p *PrivateServer) PresignHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		signer := ctx.URLSigner()
+		if signer == nil {
+			c.String(501, "error: pre-signed URLs are not enabled")
+			return
+		}
+		var req *presignRequest
+		if err := c.BindJSON(&req); err != nil {
+			return
+		} else if !strings.HasPrefix(req.Path, "/") || strings.HasSuffix(req.Path, "/") {
+			c.String(400, "error: path of a record is required")
+			return
+		} else if len(req.IP) > 0 && net.ParseIP(req.IP) == nil {
+			c.String(400, "error: malformed IP: %s", req.IP)
+			return
+		}
+		ttl := defaultPresignTTL
+		if len(req.TTL) > 0 {
+			v, err := time.ParseDuration(req.TTL)
+			if err != nil || v <= 0 {
+				c.String(400, "error: malformed TTL: %s", req.TTL)
+				return
+			} else if v > maxPresignTTL {
+				c.String(400, "error: TTL is over %s", maxPresignTTL)
+				return
+			}
+			ttl = v
+		}
+		expiresAt := time.Now().Add(ttl).UTC().Truncate(time.Second)
+		u := &url.URL{
+			Path:     presignRoute + req.Path,
+			RawQuery: signer.Sign(req.Path, expiresAt, req.IP).Encode(),
+		}
+		c.JSON(200, &presignResponse{
+			URL:       u.String(),
+			ExpiresAt: expiresAt,
+		})
+	}
+}
+
+// PresignRotateHandler replaces the signing key, URLs issued before are refused.
+func (p *PrivateServer) PresignRotateHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		signer := ctx.URLSigner()
+		if signer == nil {
+			c.String(501, "error: pre-signed URLs are not enabled")
+			return
+		}
+		if _, err := signer.key.Rotate(); err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.Status(200)
+	}
+}
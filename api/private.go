@@ -1,53 +1,646 @@
 package api
 
 import (
+	"crypto/subtle"
+	"io"
 	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/AtlantPlatform/atlant-go/api/routes"
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/fs"
 	"github.com/AtlantPlatform/atlant-go/rs"
+	"github.com/AtlantPlatform/atlant-go/state"
 )
 
 type PrivateServer struct {
-	mux http.Handler
+	mux       http.Handler
+	startedAt time.Time
+	history   *statsHistory
+	slowStats *slowStats
+	token     *PrivateToken
+	policy    *Policy
+
+	closersMux *sync.Mutex
+	closers    []io.Closer
 }
 
 func NewPrivateServer() *PrivateServer {
-	return &PrivateServer{}
+	return &PrivateServer{
+		startedAt:  time.Now(),
+		history:    newStatsHistory(),
+		slowStats:  newSlowStats(),
+		closersMux: new(sync.Mutex),
+	}
+}
+
+func (p *PrivateServer) addCloser(c io.Closer) {
+	p.closersMux.Lock()
+	p.closers = append(p.closers, c)
+	p.closersMux.Unlock()
+}
+
+// Close stops all listeners of the private server, including the metrics one.
+func (p *PrivateServer) Close() error {
+	p.closersMux.Lock()
+	defer p.closersMux.Unlock()
+	var lastErr error
+	for _, c := range p.closers {
+		if err := c.Close(); err != nil {
+			lastErr = err
+		}
+	}
+	p.closers = nil
+	return lastErr
 }
 
 // Listen starts a TCP listener, for private server it is advised to use a randomly
 // assinged port, e.g. "127.0.0.1:0". Returns the final address or an error if any.
+// Requests must present the private token, if the context has one.
 func (p *PrivateServer) Listen(addr string) (string, error) {
 	l, err := net.Listen("tcp4", addr)
 	if err != nil {
 		return "", err
 	}
+	p.addCloser(l)
 	log.Debugln("PrivateServer listen on", l.Addr().String())
 	// start a HTTP server using node's private listener
-	go http.Serve(l, p.mux)
+	go http.Serve(l, p.localHandler())
+	return l.Addr().String(), nil
+}
+
+// ListenPeers starts a TCP listener for libp2p streams of peers, only the routes
+// peers use are served. Returns the final address or an error if any.
+func (p *PrivateServer) ListenPeers(addr string) (string, error) {
+	l, err := net.Listen("tcp4", addr)
+	if err != nil {
+		return "", err
+	}
+	p.addCloser(l)
+	log.Debugln("PrivateServer peer listen on", l.Addr().String())
+	go http.Serve(l, p.peerHandler())
 	return l.Addr().String(), nil
 }
 
+// ListenMetrics serves only /metrics of the private API on a fixed address,
+// so Prometheus can scrape the node without access to the rest of the private API.
+func (p *PrivateServer) ListenMetrics(addr string) error {
+	log.Debugln("PrivateServer metrics listen on", addr)
+	srv := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/metrics" {
+				http.NotFound(w, r)
+				return
+			}
+			p.mux.ServeHTTP(w, r)
+		}),
+	}
+	p.addCloser(srv)
+	return srv.ListenAndServe()
+}
+
 func (p *PrivateServer) RouteAPI(ctx APIContext) {
 	r := gin.Default()
-	r.GET("/private/v1/ping", p.PingHandler(ctx))
+	r.Use(traceRequests("private"))
+	r.Use(requestTimeouts(ctx))
+	handle(r, routes.Private("Ping"), p.PingHandler(ctx))
 	r.GET("/private/v1/records", p.RecordsHandler(ctx))
+	r.GET("/private/v1/records/export", p.RecordsExportHandler(ctx))
+	r.POST("/private/v1/records/import", p.RecordsImportHandler(ctx))
 	r.POST("/private/v1/announce", p.AnnounceHandler(ctx))
+	r.GET("/private/v1/snapshot/blocks", p.SnapshotBlocksHandler(ctx))
+	r.POST(ingestPrefix+"put/*path", ingestOnly(ctx), putHandler(ctx))
+	r.POST(ingestPrefix+"delete/:id", ingestOnly(ctx), deleteHandler(ctx))
+	r.GET("/private/v1/logs", p.LogQueryHandler(ctx))
+	r.GET("/private/v1/log-levels", p.LogLevelsHandler(ctx))
+	r.GET("/private/v1/plugins", p.PluginsHandler(ctx))
+	mountPlugins(r, ctx)
+	r.PUT("/private/v1/log-levels/:module", p.LogLevelSetHandler(ctx))
+	r.GET("/private/v1/watch", p.WatchPollHandler(ctx))
+	r.GET("/private/v1/protocol", p.ProtocolStatusHandler(ctx))
+	r.GET("/private/v1/retention", p.RetentionStatusHandler(ctx))
+	r.GET("/private/v1/worm", p.WORMNamespacesHandler(ctx))
+	r.GET("/private/v1/gc", p.GCStatusHandler(ctx))
+	r.POST("/private/v1/gc", p.GCRunHandler(ctx))
+	r.GET("/private/v1/disk", p.DiskUsageHandler(ctx))
+	r.GET("/private/v1/encryption/keys", p.NamespaceKeysHandler(ctx))
+	r.POST("/private/v1/encryption/keys", p.ImportNamespaceKeyHandler(ctx))
+	r.POST("/private/v1/encryption/rotate/:ns", p.RotateNamespaceKeyHandler(ctx))
+	r.GET("/private/v1/encryption/export/:id", p.ExportNamespaceKeyHandler(ctx))
+	r.GET("/private/v1/erasure", p.ErasureStatusHandler(ctx))
+	r.POST("/private/v1/erasure", p.ErasureRepairHandler(ctx))
+	r.POST("/private/v1/fsck", p.FsckHandler(ctx))
+	r.GET("/private/v1/repin", p.RepinStatusHandler(ctx))
+	r.GET("/private/v1/mirror", p.MirrorStatusHandler(ctx))
+	r.PUT("/private/v1/mirror", p.MirrorPolicyHandler(ctx))
+	r.POST("/private/v1/mirror/apply", p.MirrorApplyHandler(ctx))
+	r.GET("/private/v1/cold-tier", p.ColdTierStatusHandler(ctx))
+	r.POST("/private/v1/cold-tier/apply", p.ColdTierApplyHandler(ctx))
+	r.GET("/private/v1/dry-run", p.DryRunHandler(ctx))
+	r.POST("/private/v1/dry-run/distribution", p.DryRunDistributionHandler(ctx))
+	r.GET("/private/v1/reindex", p.ReindexStatusHandler(ctx))
+	r.POST("/private/v1/reindex", p.ReindexHandler(ctx))
+	r.GET("/private/v1/anti-entropy", p.AntiEntropyStatusHandler(ctx))
+	r.GET("/private/v1/anti-entropy/digest", p.AntiEntropyDigestHandler(ctx))
+	r.GET("/private/v1/anti-entropy/entries", p.AntiEntropyEntriesHandler(ctx))
+	r.POST("/private/v1/anti-entropy/records", p.AntiEntropyRecordsHandler(ctx))
+	r.POST("/private/v1/anti-entropy/push", p.AntiEntropyPushHandler(ctx))
+	r.GET("/private/v1/state/backup", p.StateBackupHandler(ctx))
+	r.GET("/private/v1/backup/index", p.BackupIndexHandler(ctx))
+	r.GET("/private/v1/backup/shard", p.BackupShardHandler(ctx))
+	r.POST("/private/v1/backup/shard", p.BackupImportHandler(ctx))
+	r.GET("/private/v1/state/damage", p.StateDamageHandler(ctx))
+	r.DELETE("/private/v1/state/damage", p.StateDamageClearHandler(ctx))
+	r.GET("/private/v1/state/maintenance", p.StateMaintenanceHandler(ctx))
+	r.POST("/private/v1/state/maintenance", p.StateMaintenanceRunHandler(ctx))
+	r.GET("/private/v1/ratelimits", p.RateLimitStatsHandler(ctx))
+	r.GET("/private/v1/shadow", p.ShadowStatsHandler(ctx))
+	r.GET("/private/v1/cache", p.CacheStatsHandler(ctx))
+	r.GET("/private/v1/usage", p.NodeUsageHandler(ctx))
+	r.GET("/private/v1/lifecycle", p.LifecycleEventsHandler(ctx))
+	r.GET("/private/v1/search", p.SearchStatsHandler(ctx))
+	r.GET("/private/v1/clock", p.ClockStatusHandler(ctx))
+	r.GET("/private/v1/beats", p.BeatsHandler(ctx))
+	r.GET("/private/v1/bitswap/ledgers", p.BitswapLedgersHandler(ctx))
+	r.GET("/private/v1/peers", p.PeersHandler(ctx))
+	r.GET("/private/v1/peers/exchange", p.ExchangedPeersHandler(ctx))
+	r.POST("/private/v1/peers/connect", p.PeerConnectHandler(ctx))
+	r.POST("/private/v1/peers/disconnect/:node", p.PeerDisconnectHandler(ctx))
+	r.GET("/private/v1/vouches", p.VouchesHandler(ctx))
+	r.POST("/private/v1/vouches/:node", p.VouchHandler(ctx))
+	r.GET("/private/v1/transfers", p.TransferListHandler(ctx))
+	r.POST("/private/v1/transfers", p.TransferOfferHandler(ctx))
+	r.POST("/private/v1/transfers/:id/accept", p.TransferAcceptHandler(ctx))
+	r.DELETE("/private/v1/transfers/:id", p.TransferWithdrawHandler(ctx))
+	r.GET("/private/v1/identity/links", p.IdentityLinksHandler(ctx))
+	r.POST("/private/v1/identity/rotate", p.IdentityRotateHandler(ctx))
+	r.POST("/private/v1/join", p.JoinHandler(ctx))
+	r.GET("/private/v1/join-tokens", p.JoinTokensHandler(ctx))
+	r.POST("/private/v1/join-tokens", p.JoinTokenMintHandler(ctx))
+	r.POST("/private/v1/join-tokens/revoke/:id", p.JoinTokenRevokeHandler(ctx))
+	r.GET("/private/v1/auth", p.AuthStatusHandler(ctx))
+	r.POST("/private/v1/auth/refresh", p.AuthRefreshHandler(ctx))
+	r.POST("/private/v1/token/rotate", p.TokenRotateHandler(ctx))
+	r.POST("/private/v1/presign", p.PresignHandler(ctx))
+	r.POST("/private/v1/presign/rotate", p.PresignRotateHandler(ctx))
+	r.POST("/private/v1/shutdown", p.ShutdownHandler(ctx))
+	handle(r, routes.Private("Status"), p.StatusHandler(ctx))
+	r.GET("/private/v1/sync", p.SyncStatsHandler(ctx))
+	r.POST("/private/v1/sync", p.SyncHandler(ctx))
+	r.GET("/private/v1/support/bundle", p.SupportBundleHandler(ctx))
+	r.GET("/private/v1/observability/bundle", p.ObservabilityBundleHandler(ctx))
+	r.GET("/metrics", p.MetricsHandler(ctx))
+	r.POST("/private/v1/checkpoints/:name", p.CheckpointCreateHandler(ctx))
+	r.GET("/private/v1/checkpoints/:name", p.CheckpointGetHandler(ctx))
+
+	r.GET("/private/v1/hooks", p.HookListHandler(ctx))
+	r.POST("/private/v1/hooks", p.HookRegisterHandler(ctx))
+	r.POST("/private/v1/hooks/delete/:name", p.HookUnregisterHandler(ctx))
+	r.GET("/private/v1/webhooks", p.WebhookListHandler(ctx))
+	r.POST("/private/v1/webhooks", p.WebhookRegisterHandler(ctx))
+	r.POST("/private/v1/webhooks/delete/:name", p.WebhookUnregisterHandler(ctx))
+	r.GET("/private/v1/webhooks/dead", p.DeadLettersHandler(ctx))
+	r.POST("/private/v1/webhooks/dead/requeue", p.DeadLettersRequeueHandler(ctx))
+	r.POST("/private/v1/webhooks/dead/purge", p.DeadLettersPurgeHandler(ctx))
+	registerTaskActions(ctx)
+	r.GET("/private/v1/tasks", p.TaskListHandler(ctx))
+	r.GET("/private/v1/task-runs", p.TaskRunsHandler(ctx))
+	r.PUT("/private/v1/tasks/:name", p.TaskPutHandler(ctx))
+	r.DELETE("/private/v1/tasks/:name", p.TaskDeleteHandler(ctx))
+	r.POST("/private/v1/tasks/:name/run", p.TaskRunHandler(ctx))
+	r.GET("/private/v1/tasks/:name/runs", p.TaskRunsHandler(ctx))
+	r.GET("/private/v1/conflicts", p.ConflictListHandler(ctx))
+	r.GET("/private/v1/expiries", p.ExpiryListHandler(ctx))
+	r.DELETE("/private/v1/conflicts/:id", p.ConflictDismissHandler(ctx))
+
+	r.GET("/private/v1/folders/identity", p.FolderIdentityHandler(ctx))
+	r.POST("/private/v1/folders/create/:name", p.FolderCreateHandler(ctx))
+	r.GET("/private/v1/folders/info/:name", p.FolderGetHandler(ctx))
+	r.POST("/private/v1/folders/members/:name", p.FolderAddMemberHandler(ctx))
+	r.POST("/private/v1/folders/removeMember/:name/:node", p.FolderRemoveMemberHandler(ctx))
+	r.POST("/private/v1/folders/put/:name/*path", p.FolderPutHandler(ctx))
+	r.GET("/private/v1/folders/content/:name/*path", p.FolderGetFileHandler(ctx))
+	r.POST("/private/v1/folders/reencrypt/:name", p.FolderReencryptHandler(ctx))
+	r.GET("/private/v1/folders/reencrypt/:name", p.FolderReencryptStatusHandler(ctx))
+	go p.history.Run(ctx, p.startedAt)
+	p.token = ctx.PrivateToken()
+	p.policy = ctx.Policy()
 	p.mux = r
 }
 
 func (p *PrivateServer) PingHandler(ctx APIContext) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		c.Header(rs.ProtocolVersionHeader, strconv.Itoa(int(rs.ProtocolVersion)))
+		c.Header(rs.NodeTimeHeader, strconv.FormatInt(time.Now().UnixNano(), 10))
 		c.String(200, ctx.NodeID())
 	}
 }
 
+// ProtocolStatusHandler reports protocol versions present in the cluster
+// and whether this node may produce records.
+func (p *PrivateServer) ProtocolStatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().ProtocolStatus())
+	}
+}
+
+// BitswapLedgersHandler reports bytes exchanged with connected peers and throttled debtors.
+func (p *PrivateServer) BitswapLedgersHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"ledgers":     ctx.FileStore().BitswapLedgers(),
+			"debt_limits": ctx.FileStore().DebtLimitStats(),
+		})
+	}
+}
+
+// ClockStatusHandler reports skew of the local clock against NTP and peers.
+func (p *PrivateServer) ClockStatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().ClockStatus())
+	}
+}
+
+// BeatsHandler reports beats sent by this node and liveness of peers by the ticks they sent.
+func (p *PrivateServer) BeatsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := ctx.RecordStore()
+		c.JSON(200, gin.H{
+			"beats": store.BeatStats(),
+			"peers": store.PeerLiveness(),
+		})
+	}
+}
+
+// AuthStatusHandler reports the auth domains in use and when they were last resolved.
+func (p *PrivateServer) AuthStatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, authcenter.Default.Status())
+	}
+}
+
+// AuthRefreshHandler re-resolves the auth domains right away, so permission grants
+// and revocations take effect without waiting for the next update.
+func (p *PrivateServer) AuthRefreshHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		if err := authcenter.Default.Refresh(reqCtx); err != nil {
+			c.String(502, "error: %v", err)
+			return
+		}
+		c.JSON(200, authcenter.Default.Status())
+	}
+}
+
+// RetentionStatusHandler reports retention policies in effect and space reclaimed so far.
+func (p *PrivateServer) RetentionStatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().RetentionStatus())
+	}
+}
+
+// WORMNamespacesHandler lists write-once namespaces with the time this node has seen them as such.
+func (p *PrivateServer) WORMNamespacesHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().WORMNamespaces())
+	}
+}
+
+// NodeUsageHandler reports usage of nodes for accounting, pass ?node= for a single node.
+func (p *PrivateServer) NodeUsageHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usage, err := ctx.RecordStore().NodeUsage(ctx.WithRequest(c), c.Query("node"))
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, usage)
+	}
+}
+
+// LifecycleEventsHandler lists lifecycle events published by nodes, pass ?node= for a single
+// node and ?since= with an RFC 3339 time or a duration like 24h for recent events only.
+func (p *PrivateServer) LifecycleEventsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var since time.Time
+		if v := c.Query("since"); len(v) > 0 {
+			if d, err := time.ParseDuration(v); err == nil {
+				since = time.Now().Add(-d)
+			} else if since, err = time.Parse(time.RFC3339, v); err != nil {
+				c.String(400, "error: malformed since: %s", v)
+				return
+			}
+		}
+		events, err := ctx.RecordStore().LifecycleEvents(ctx.WithRequest(c), c.Query("node"), since)
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		if events == nil {
+			events = []*rs.LifecycleEvent{}
+		}
+		c.JSON(200, events)
+	}
+}
+
+// RateLimitStatsHandler reports rate limits of namespaces and requests refused so far.
+func (p *PrivateServer) RateLimitStatsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().RateLimitStats())
+	}
+}
+
+// ShadowStatsHandler reports requests mirrored to the staging node and the latest divergences.
+func (p *PrivateServer) ShadowStatsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats := ctx.ShadowStats()
+		if stats == nil {
+			c.String(404, "error: requests are not mirrored")
+			return
+		}
+		c.JSON(200, stats)
+	}
+}
+
+// ExpiryListHandler lists versions of records scheduled to expire, the earliest first.
+func (p *PrivateServer) ExpiryListHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := ctx.RecordStore().Expiries(ctx.WithRequest(c))
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		if list == nil {
+			list = []*rs.Expiry{}
+		}
+		c.JSON(200, list)
+	}
+}
+
+// CacheStatsHandler reports hits and misses of the cache of hot lookups per class.
+func (p *PrivateServer) CacheStatsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats := ctx.CacheStats()
+		if stats == nil {
+			c.String(404, "error: caching is disabled")
+			return
+		}
+		c.JSON(200, stats)
+	}
+}
+
+// GCStatusHandler reports the GC policy, the last collection and totals.
+func (p *PrivateServer) GCStatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().GCStatus())
+	}
+}
+
+// DiskUsageHandler reports disk usage of the node against its disk budget.
+func (p *PrivateServer) DiskUsageHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().DiskUsageStatus())
+	}
+}
+
+// GCRunHandler runs garbage collection right away and responds with its report,
+// only local tools presenting the support token may trigger it.
+func (p *PrivateServer) GCRunHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkSupportToken(ctx, c) {
+			c.String(403, "error: support token mismatch")
+			return
+		}
+		// not bound to the request, so a collection isn't interrupted halfway
+		report, err := ctx.RecordStore().CollectGarbage(ctx)
+		if err == rs.ErrGCRunning {
+			c.String(409, "error: %v", err)
+			return
+		} else if err != nil {
+			c.JSON(500, report)
+			return
+		}
+		c.JSON(200, report)
+	}
+}
+
+// ReindexStatusHandler reports progress of index rebuilds.
+func (p *PrivateServer) ReindexStatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().ReindexStatus())
+	}
+}
+
+// ReindexHandler starts rebuilds of the index query params, all indexes by default.
+func (p *PrivateServer) ReindexHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		indexes := c.QueryArray("index")
+		if len(indexes) == 0 {
+			indexes = rs.Indexes
+		}
+		var jobs []*rs.ReindexJob
+		for _, index := range indexes {
+			job, err := ctx.RecordStore().Reindex(ctx.WithRequest(c), index)
+			switch err {
+			case nil:
+				jobs = append(jobs, job)
+				continue
+			case rs.ErrSearchDisabled:
+				if len(c.QueryArray("index")) == 0 {
+					// not asked for explicitly
+					continue
+				}
+				c.String(400, "error: %v", err)
+			case rs.ErrUnknownIndex:
+				c.String(400, "error: %v", err)
+			case rs.ErrReindexRunning:
+				c.String(409, "error: %s: %v", index, err)
+			default:
+				c.String(503, "error: %v", err)
+			}
+			return
+		}
+		c.JSON(200, jobs)
+	}
+}
+
+// RepinStatusHandler reports progress of refetching versions that lost blocks.
+func (p *PrivateServer) RepinStatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().RepinStatus())
+	}
+}
+
+// ErasureStatusHandler reports storage classes of namespaces and shard health of erasure
+// coded objects found by the last repair.
+func (p *PrivateServer) ErasureStatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().ErasureStatus())
+	}
+}
+
+// ErasureRepairHandler checks and repairs shards right away, only local tools
+// presenting the support token may trigger it.
+func (p *PrivateServer) ErasureRepairHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkSupportToken(ctx, c) {
+			c.String(403, "error: support token mismatch")
+			return
+		}
+		status, err := ctx.RecordStore().CheckShards(ctx)
+		if err == rs.ErrRepairRunning {
+			c.String(409, "error: %v", err)
+			return
+		} else if err != nil {
+			c.JSON(500, status)
+			return
+		}
+		c.JSON(200, status)
+	}
+}
+
+// FsckHandler verifies objects of records and with ?repair=true fetches missing blocks,
+// only local tools presenting the support token may run it.
+func (p *PrivateServer) FsckHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkSupportToken(ctx, c) {
+			c.String(403, "error: support token mismatch")
+			return
+		}
+		repair, _ := strconv.ParseBool(c.Query("repair"))
+		// not bound to the request, so a repair isn't interrupted halfway
+		report, err := ctx.RecordStore().Fsck(ctx, repair)
+		if err == rs.ErrFsckRunning {
+			c.String(409, "error: %v", err)
+			return
+		} else if err != nil {
+			c.JSON(500, report)
+			return
+		}
+		c.JSON(200, report)
+	}
+}
+
+// StateDamageHandler reports the damage found in the state store, 404 if it's fine.
+func (p *PrivateServer) StateDamageHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := state.Damage(ctx.StateStore())
+		if report == nil {
+			c.String(404, "error: state store is not damaged")
+			return
+		}
+		c.JSON(200, report)
+	}
+}
+
+// StateDamageClearHandler drops the damage report once the operator has checked the
+// quarantined keys, the state store leaves degraded mode. Only local tools presenting
+// the support token may clear it.
+func (p *PrivateServer) StateDamageClearHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkSupportToken(ctx, c) {
+			c.String(403, "error: support token mismatch")
+			return
+		}
+		if err := state.ClearDamage(ctx.StateStore()); err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.Status(204)
+	}
+}
+
+// StateMaintenanceHandler reports value log GC of the state store, 404 if the backend has none.
+func (p *PrivateServer) StateMaintenanceHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats := state.Maintenance(ctx.StateStore())
+		if stats == nil {
+			c.String(404, "error: %v", state.ErrNoMaintenance)
+			return
+		}
+		c.JSON(200, stats)
+	}
+}
+
+// StateMaintenanceRunHandler runs value log GC of the state store and responds with the run.
+func (p *PrivateServer) StateMaintenanceRunHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		run, err := state.RunMaintenance(ctx.StateStore())
+		switch err {
+		case nil:
+			c.JSON(200, run)
+		case state.ErrNoMaintenance:
+			c.String(404, "error: %v", err)
+		case state.ErrMaintenanceRunning:
+			c.String(409, "error: %v", err)
+		default:
+			if run != nil {
+				c.JSON(500, run)
+				return
+			}
+			c.String(500, "error: %v", err)
+		}
+	}
+}
+
+// StateBackupHeader is the trailer carrying the version to make the next incremental backup from.
+const StateBackupHeader = "X-Backup-Version"
+
+// StateBackupHandler streams a dump of the state store for backups, query param since makes
+// an incremental one. Only local tools presenting the support token may request it.
+func (p *PrivateServer) StateBackupHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkSupportToken(ctx, c) {
+			c.String(403, "error: support token mismatch")
+			return
+		}
+		var since uint64
+		if v := c.Query("since"); len(v) > 0 {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				c.String(400, "error: bad since: %v", err)
+				return
+			}
+			since = n
+		}
+		if !state.CanBackup(ctx.StateStore()) {
+			c.String(501, "error: %v", state.ErrBackupUnsupported)
+			return
+		}
+		c.Header("Content-Type", "application/octet-stream")
+		c.Header("Trailer", StateBackupHeader)
+		c.Status(200)
+		version, err := state.Backup(ctx.StateStore(), c.Writer, since)
+		if err != nil {
+			// the status is sent already, the missing trailer tells the backup failed
+			log.Warningf("state backup failed: %v", err)
+			return
+		}
+		c.Writer.Header().Set(StateBackupHeader, strconv.FormatUint(version, 10))
+	}
+}
+
 func (p *PrivateServer) RecordsHandler(ctx APIContext) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if err := ctx.RecordStore().ExportRecords(ctx, c.Writer); err != nil {
+		reqCtx := ctx.WithRequest(c)
+		// peers syncing from a checkpoint want records changed since then only
+		var since time.Time
+		if v := c.Query("since"); len(v) > 0 {
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || ts < 0 {
+				c.String(400, "error: since must be a unix time in nanoseconds")
+				return
+			}
+			since = time.Unix(0, ts)
+		}
+		if err := ctx.RecordStore().ExportRecords(reqCtx, c.Writer, since); err != nil {
 			c.AbortWithStatus(500)
 		}
 		c.Status(200)
@@ -64,3 +657,129 @@ func (p *PrivateServer) AnnounceHandler(ctx APIContext) gin.HandlerFunc {
 		c.Status(200)
 	}
 }
+
+// SnapshotBlocksHandler streams a CAR export of all object versions to a peer
+// that bootstraps from this node. Disabled unless a bootstrap token is configured.
+func (p *PrivateServer) SnapshotBlocksHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		token := ctx.BootstrapToken()
+		if len(token) == 0 {
+			c.String(403, "error: snapshots are disabled on this node")
+			return
+		}
+		reqToken := c.Request.Header.Get(rs.BootstrapTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(reqToken)) != 1 {
+			c.String(403, "error: bootstrap token mismatch")
+			return
+		}
+		c.Header("Content-Type", "application/vnd.ipld.car")
+		c.Status(200)
+		if err := ctx.RecordStore().ExportSnapshot(reqCtx, c.Writer); err != nil {
+			log.Warningf("failed to export snapshot: %v", err)
+		}
+	}
+}
+
+// ShutdownHandler stops the node gracefully once the response is sent.
+// NodeStatus is a summary of the node for operators.
+type NodeStatus struct {
+	NodeID    string         `json:"node_id"`
+	SessionID string         `json:"session_id"`
+	Version   string         `json:"version"`
+	Network   string         `json:"network"`
+	Protocol  uint16         `json:"protocol_version"`
+	Uptime    string         `json:"uptime"`
+	Ready     bool           `json:"ready"`
+	Peers     int            `json:"peers"`
+	Queues    *rs.QueueStats `json:"queues"`
+	Sync      *rs.SyncStats  `json:"sync"`
+	Beats     *rs.BeatStats  `json:"beats"`
+	// StateDamage is set if the state store runs degraded after a recovery.
+	StateDamage *state.DamageReport `json:"state_damage,omitempty"`
+	// Reindex lists index rebuilds in progress.
+	Reindex []*rs.ReindexJob `json:"reindex,omitempty"`
+	// SwarmKey identifies the key of the IPFS swarm, nodes of a swarm report the same one.
+	SwarmKey *SwarmKeyInfo `json:"swarm_key,omitempty"`
+	// NAT tells whether peers reach the node and the addresses they may dial it at.
+	NAT *fs.NATStats `json:"nat,omitempty"`
+}
+
+// SwarmKeyInfo identifies a swarm key without disclosing it.
+type SwarmKeyInfo struct {
+	Fingerprint string `json:"fingerprint"`
+	// Public names a key published with the node, e.g. "public testnet", anyone may join its swarm.
+	Public string `json:"public,omitempty"`
+}
+
+func (p *PrivateServer) StatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := ctx.RecordStore()
+		c.JSON(200, &NodeStatus{
+			NodeID:    ctx.NodeID(),
+			SessionID: ctx.SessionID(),
+			Version:   ctx.Version(),
+			Network:   ctx.Env(),
+			Protocol:  rs.ProtocolVersion,
+			Uptime:    time.Since(p.startedAt).String(),
+			Ready:     store.IsReady(),
+			Peers:     len(ctx.FileStore().SwarmPeers()),
+			Queues:    store.QueueStats(),
+			Sync:      store.SyncStats(),
+			Beats:     store.BeatStats(),
+
+			StateDamage: state.Damage(ctx.StateStore()),
+			Reindex:     runningReindexJobs(store),
+			SwarmKey:    ctx.SwarmKeyInfo(),
+			NAT:         ctx.FileStore().NATStats(),
+		})
+	}
+}
+
+func runningReindexJobs(store rs.PlanetaryRecordStore) []*rs.ReindexJob {
+	var jobs []*rs.ReindexJob
+	for _, job := range store.ReindexStatus() {
+		if job.Running {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+func (p *PrivateServer) SyncStatsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().SyncStats())
+	}
+}
+
+// SyncHandler fetches records changed on peers since the last sync, the request
+// returns once the sync is done.
+func (p *PrivateServer) SyncHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := ctx.RecordStore()
+		if err := store.Sync(); err == rs.ErrSyncRunning {
+			c.String(409, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, store.SyncStats())
+	}
+}
+
+func (p *PrivateServer) ShutdownHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		shutdown := ctx.Shutdown()
+		if shutdown == nil {
+			c.String(501, "error: shutdown is not supported")
+			return
+		}
+		log.Infoln("shutting down on request of a local tool")
+		c.String(202, "shutting down")
+		go func() {
+			time.Sleep(time.Second)
+			shutdown()
+		}()
+	}
+}
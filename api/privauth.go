@@ -0,0 +1,166 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The private API is served on two loopback listeners. The local one is used by tools
+// and operators and requires the bearer token kept in the state dir. The peer one is
+// where libp2p streams of other nodes are forwarded, it serves only the routes peers
+// need and no token is required there, as peers are authenticated by libp2p.
+
+// peerRoutes are private API paths served to peers, as well as writes forwarded to ingest nodes.
+var peerRoutes = map[string]bool{
+	"/private/v1/ping":            true,
+	"/private/v1/records":         true,
+	"/private/v1/announce":        true,
+	"/private/v1/snapshot/blocks": true,
+	"/private/v1/join":            true,
+
+	"/private/v1/anti-entropy/digest":  true,
+	"/private/v1/anti-entropy/entries": true,
+	"/private/v1/anti-entropy/records": true,
+	"/private/v1/anti-entropy/push":    true,
+}
+
+// PrivateToken is the bearer token of the local private API, stored in a file
+// readable only by the node user.
+type PrivateToken struct {
+	path  string
+	mux   *sync.RWMutex
+	token string
+}
+
+// LoadPrivateToken reads the token file, a new token is generated if there is none.
+func LoadPrivateToken(path string) (*PrivateToken, error) {
+	t := &PrivateToken{
+		path: path,
+		mux:  new(sync.RWMutex),
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if _, err := t.Rotate(); err != nil {
+			return nil, err
+		}
+		return t, nil
+	} else if err != nil {
+		return nil, err
+	}
+	t.token = strings.TrimSpace(string(data))
+	if len(t.token) == 0 {
+		if _, err := t.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *PrivateToken) String() string {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	return t.token
+}
+
+func (t *PrivateToken) Valid(token string) bool {
+	current := t.String()
+	return len(current) > 0 && subtle.ConstantTimeCompare([]byte(current), []byte(token)) == 1
+}
+
+// Rotate replaces the token with a new one, requests with the old token are refused.
+func (t *PrivateToken) Rotate() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	tmp := t.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(t.path), 0700); err != nil {
+		return "", err
+	} else if err := ioutil.WriteFile(tmp, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	} else if err := os.Rename(tmp, t.path); err != nil {
+		return "", err
+	}
+	t.token = token
+	return token, nil
+}
+
+// requestToken returns the bearer token of the request, the support token header
+// is accepted as well for older tools.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return r.Header.Get(SupportTokenHeader)
+}
+
+// localHandler requires the private token, unless the node runs without one.
+// Requests are admitted by the policy as admin requests.
+func (p *PrivateServer) localHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.token != nil && !p.token.Valid(requestToken(r)) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="atlant-private"`)
+			http.Error(w, "error: private API token required", http.StatusUnauthorized)
+			return
+		}
+		if p.policy != nil {
+			caller, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				caller = r.RemoteAddr
+			}
+			if !p.policy.admit(w, &PolicyInput{
+				Op:     PolicyAdmin,
+				Caller: caller,
+				Path:   r.URL.Path,
+				Labels: map[string]string{},
+				Time:   time.Now(),
+			}) {
+				return
+			}
+		}
+		p.mux.ServeHTTP(w, r)
+	})
+}
+
+func (p *PrivateServer) peerHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !peerRoutes[r.URL.Path] && !strings.HasPrefix(r.URL.Path, ingestPrefix) {
+			http.NotFound(w, r)
+			return
+		}
+		p.mux.ServeHTTP(w, r)
+	})
+}
+
+// TokenRotateHandler replaces the private token and responds with the new one.
+func (p *PrivateServer) TokenRotateHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t := ctx.PrivateToken()
+		if t == nil {
+			c.String(501, "error: private API token is not configured")
+			return
+		}
+		token, err := t.Rotate()
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, gin.H{
+			"token": token,
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// ProxyRoutes forwards requests by the longest matching path prefix to legacy services,
+// so their APIs can be moved onto nodes one prefix at a time. Routes of the node
+// always take precedence, only requests that match none of them are forwarded.
+type ProxyRoutes map[string]*url.URL
+
+// ParseProxyRoutes parses a comma-separated list of prefix=URL pairs,
+// e.g. "/kyc=http://10.0.0.5:8080,/legacy=http://10.0.0.6/api". The prefix is replaced
+// by the path of the URL when forwarding.
+func ParseProxyRoutes(s string) (ProxyRoutes, error) {
+	routes := make(ProxyRoutes)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") {
+			return nil, fmt.Errorf("bad proxy route: %s", pair)
+		}
+		target, err := url.Parse(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy route: %s: %v", pair, err)
+		} else if target.Scheme != "http" && target.Scheme != "https" {
+			return nil, fmt.Errorf("bad proxy route: %s: target must be an http(s) URL", pair)
+		}
+		routes[strings.TrimSuffix(parts[0], "/")] = target
+	}
+	return routes, nil
+}
+
+// Match returns the longest prefix matching the path on segment boundaries.
+func (p ProxyRoutes) Match(reqPath string) (string, *url.URL) {
+	var matched string
+	var target *url.URL
+	for prefix, u := range p {
+		if reqPath != prefix && !strings.HasPrefix(reqPath, prefix+"/") {
+			continue
+		}
+		if target == nil || len(prefix) > len(matched) {
+			matched = prefix
+			target = u
+		}
+	}
+	return matched, target
+}
+
+// proxyHandler forwards unrouted requests to legacy services, others get 404.
+func proxyHandler(ctx APIContext) gin.HandlerFunc {
+	routes := ctx.ProxyRoutes()
+	proxies := make(map[string]*httputil.ReverseProxy, len(routes))
+	for prefix, target := range routes {
+		proxies[prefix] = newReverseProxy(prefix, target)
+	}
+	return func(c *gin.Context) {
+		prefix, target := routes.Match(c.Request.URL.Path)
+		if target == nil {
+			c.String(404, "404 page not found")
+			return
+		}
+		proxies[prefix].ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+func newReverseProxy(prefix string, target *url.URL) *httputil.ReverseProxy {
+	director := func(req *http.Request) {
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		req.Header.Set("X-Forwarded-Host", req.Host)
+		req.Header.Set("X-Forwarded-Proto", proto)
+		req.URL.Scheme = target.Scheme
+		req.URL.Host = target.Host
+		req.URL.Path = joinProxyPath(target.Path, strings.TrimPrefix(req.URL.Path, prefix))
+		req.URL.RawPath = ""
+		if len(target.RawQuery) > 0 {
+			if len(req.URL.RawQuery) > 0 {
+				req.URL.RawQuery = target.RawQuery + "&" + req.URL.RawQuery
+			} else {
+				req.URL.RawQuery = target.RawQuery
+			}
+		}
+		req.Host = target.Host
+	}
+	return &httputil.ReverseProxy{
+		Director: director,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			log.WithFields(log.Fields{
+				"prefix": prefix,
+				"target": target.Host,
+			}).Warningf("failed to proxy %s: %v", req.URL.Path, err)
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprintf(w, "error: legacy service unavailable")
+		},
+	}
+}
+
+func joinProxyPath(base, rest string) string {
+	if len(rest) == 0 {
+		if len(base) == 0 {
+			return "/"
+		}
+		return base
+	}
+	joined := path.Join("/", base, rest)
+	if strings.HasSuffix(rest, "/") && !strings.HasSuffix(joined, "/") {
+		joined += "/"
+	}
+	return joined
+}
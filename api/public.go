@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -14,40 +15,116 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/AtlantPlatform/atlant-go/api/routes"
 	"github.com/AtlantPlatform/atlant-go/contracts"
 	"github.com/AtlantPlatform/atlant-go/fs"
 	"github.com/AtlantPlatform/atlant-go/proto"
 	"github.com/AtlantPlatform/atlant-go/rs"
+	"github.com/AtlantPlatform/atlant-go/state"
 )
 
 type PublicServer struct {
 	mux       *gin.Engine
+	handler   http.Handler
 	startedAt time.Time
+	uploads   *uploadSessions
+	health    *healthChecker
+	netStats  *networkStats
+
+	srvMux    *sync.Mutex
+	srv       *http.Server
+	addrs     []*ListenAddr
+	listeners []*publicListener
+	serveErr  chan error
+	useTLS    bool
 }
 
 func NewPublicServer() *PublicServer {
 	return &PublicServer{
 		startedAt: time.Now(),
+		srvMux:    new(sync.Mutex),
+	}
+}
+
+// ListenAndServe serves the public API at all of the addresses, see ParseListenAddr.
+// Addresses that fail to bind are logged and skipped, unless none of them binds.
+func (p *PublicServer) ListenAndServe(addrs []string) error {
+	srv := &http.Server{
+		Handler:           p.handler,
+		ReadHeaderTimeout: publicHeaderTimeout,
 	}
+	return p.serve(srv, addrs)
 }
 
-func (p *PublicServer) ListenAndServe(addr string) error {
-	return p.mux.Run(addr)
+// Close stops serving the public API, ListenAndServe returns http.ErrServerClosed then.
+func (p *PublicServer) Close() error {
+	p.srvMux.Lock()
+	defer p.srvMux.Unlock()
+	if p.srv == nil {
+		return nil
+	}
+	err := p.srv.Close()
+	for _, pl := range p.listeners {
+		if pl.addr.Network == "unix" {
+			os.Remove(pl.addr.Address)
+		}
+	}
+	return err
+}
+
+// handle registers handlers of a route of the routes table, the client package is
+// generated from the same table.
+func handle(r gin.IRoutes, route routes.Route, handlers ...gin.HandlerFunc) {
+	r.Handle(route.Method, route.Path, handlers...)
 }
 
 func (p *PublicServer) RouteAPI(ctx APIContext) {
+	p.uploads = newUploadSessions(ctx.UploadDir())
+	p.health = newHealthChecker(ctx)
 	r := gin.Default()
-	r.POST("/api/v1/put/*path", p.PutHandler(ctx))
-	r.POST("/api/v1/delete/:id", p.DeleteHandler(ctx))
-	r.GET("/api/v1/content/*path", p.ContentHandler(ctx))
-	r.GET("/api/v1/meta/*path", p.MetaHandler(ctx))
-	r.GET("/api/v1/listVersions/*path", p.ListVersionsHandler(ctx))
-	r.GET("/api/v1/listAll/*prefix", p.ListAllHandler(ctx))
+	// probes are routed before the middleware, so that throttles and policies don't refuse them
+	r.GET("/healthz", p.HealthzHandler(ctx))
+	r.GET("/readyz", p.ReadyzHandler(ctx))
+	r.GET("/lb-health", p.LBHealthHandler(ctx))
+	r.Use(corsHeaders(ctx))
+	r.Use(traceRequests("public"))
+	r.Use(loadLatencies(ctx))
+	r.Use(namespaceScope(ctx))
+	r.Use(requestThrottles(ctx))
+	r.Use(requestTimeouts(ctx))
+	r.Use(rateLimits(ctx))
+	r.Use(presignedReads(ctx))
+	r.Use(drainWrites(ctx))
+	r.Use(policyCheck(ctx))
+	r.Use(writeGating(ctx))
+	r.Use(shadowReads(ctx))
+	idempotent := idempotentWrites(ctx, ctx.IdempotencyTTL())
+	handle(r, routes.Public("Put"), idempotent, ingestProxy(ctx), p.PutHandler(ctx))
+	handle(r, routes.Public("Delete"), idempotent, ingestProxy(ctx), p.DeleteHandler(ctx))
+	r.POST("/api/v1/upload/*path", idempotent, p.UploadHandler(ctx))
+	r.GET("/api/v1/uploads/:id", p.UploadStatusHandler(ctx))
+	r.PUT("/api/v1/uploads/:id", p.UploadChunkHandler(ctx))
+	r.DELETE("/api/v1/uploads/:id", p.UploadCancelHandler(ctx))
+	handle(r, routes.Public("Content"), p.ContentHandler(ctx))
+	handle(r, routes.Public("Meta"), p.MetaHandler(ctx))
+	r.POST(batchGetRoute, p.BatchGetHandler(ctx))
+	r.GET("/api/v1/annotations/*path", p.AnnotationsHandler(ctx))
+	r.POST("/api/v1/annotate/*path", p.AnnotateHandler(ctx))
+	r.GET("/api/v1/integrity/*path", p.IntegrityHandler(ctx))
+	handle(r, routes.Public("Proof"), p.ProofHandler(ctx))
+	handle(r, routes.Public("ListVersions"), p.ListVersionsHandler(ctx))
+	r.GET("/api/v1/records/:id/versions", p.RecordVersionsHandler(ctx))
+	r.GET("/api/v1/records/:id/versions/:version", p.RecordVersionHandler(ctx))
+	handle(r, routes.Public("ListPage"), p.ListAllHandler(ctx))
+	handle(r, routes.Public("Subscribe"), p.SubscribeHandler(ctx))
+	r.GET("/api/v1/search", p.SearchHandler(ctx))
+	routeNamespaces(r, p, ctx, idempotent)
 
 	r.GET("/api/v1/tokenDistributionInfo", p.TokenDistributionInfo(ctx))
 	r.GET("/api/v1/kycStatus", p.KYCStatus(ctx))
@@ -56,18 +133,30 @@ func (p *PublicServer) RouteAPI(ctx APIContext) {
 	r.GET("/api/v1/ptoBalance/:token", p.PropertyTokenBalance(ctx))
 
 	r.GET("/api/v1/newID", p.IDHandler(ctx))
-	r.GET("/api/v1/ping", p.PingHandler(ctx))
+	handle(r, routes.Public("Ping"), p.PingHandler(ctx))
 	r.GET("/api/v1/env", p.EnvHandler(ctx))
 	r.GET("/api/v1/session", p.SessionHandler(ctx))
-	r.GET("/api/v1/version", p.VersionHandler(ctx))
-	r.GET("/api/v1/stats", p.StatsHandler(ctx))
+	handle(r, routes.Public("Version"), p.VersionHandler(ctx))
+	r.GET("/api/versions", p.VersionsHandler(ctx))
+	handle(r, routes.Public("Stats"), p.StatsHandler(ctx))
+	r.GET("/api/v1/slo", p.SLOHandler(ctx))
+	r.GET("/api/v1/popular", p.PopularHandler(ctx))
 	r.GET("/api/v1/logs", p.LogListHandler(ctx))
 	r.GET("/api/v1/log/:year/:month/:day", p.LogGetHandler(ctx))
 
 	r.GET("/index/*prefix", p.IndexHandler(ctx))
+	if ctx.NetworkStats() {
+		p.netStats = newNetworkStats(p.startedAt)
+		r.GET("/api/v1/network", p.NetworkStatsHandler(ctx))
+		r.GET("/network", p.NetworkPageHandler(ctx))
+	}
 	r.StaticFS("/assets", assetFS())
+	if len(ctx.ProxyRoutes()) > 0 {
+		r.NoRoute(proxyHandler(ctx))
+	}
 
 	p.mux = r
+	p.handler = negotiateVersion(r, ctx.ProxyRoutes())
 }
 
 func (p *PublicServer) PingHandler(ctx APIContext) gin.HandlerFunc {
@@ -123,48 +212,161 @@ type DiskStats struct {
 }
 
 type Stats struct {
-	Uptime         string             `json:"uptime,omitempty"`
-	DiskStats      *DiskStats         `json:"disk_stats,omitempty"`
-	BandwidthStats *fs.BandwidthStats `json:"bandwidth_stats,omitempty"`
-	RepoStats      *fs.RepoStats      `json:"repo_stats,omitempty"`
-	BitswapStats   *fs.BitswapStats   `json:"bitswap_stats,omitempty"`
-	BadgerStats    *rs.BadgerStats    `json:"badger_stats,omitempty"`
+	Uptime         string                `json:"uptime,omitempty"`
+	DiskStats      *DiskStats            `json:"disk_stats,omitempty"`
+	BandwidthStats *fs.BandwidthStats    `json:"bandwidth_stats,omitempty"`
+	RepoStats      *fs.RepoStats         `json:"repo_stats,omitempty"`
+	BitswapStats   *fs.BitswapStats      `json:"bitswap_stats,omitempty"`
+	BadgerStats    *rs.BadgerStats       `json:"badger_stats,omitempty"`
+	Circuits       map[string]string     `json:"circuits,omitempty"`
+	BlocklistStats *fs.BlocklistStats    `json:"blocklist_stats,omitempty"`
+	MmapCacheStats *fs.MmapCacheStats    `json:"mmap_cache_stats,omitempty"`
+	PeerExchange   *fs.PeerExchangeStats `json:"peer_exchange,omitempty"`
+	Inline         *fs.InlineStats       `json:"inline_stats,omitempty"`
+	StateStats     *state.StoreStats     `json:"state_stats,omitempty"`
+	WatchStats     *rs.WatchStats        `json:"watch_stats,omitempty"`
+	DedupStats     *rs.DedupStats        `json:"dedup_stats,omitempty"`
 }
 
 func (p *PublicServer) StatsHandler(ctx APIContext) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		stats := &Stats{
-			Uptime:         fmt.Sprintf("%s", time.Since(p.startedAt)),
-			BandwidthStats: ctx.FileStore().BandwidthStats(),
-			RepoStats:      ctx.FileStore().RepoStats(),
-			BadgerStats:    ctx.RecordStore().BadgerStats(),
-		}
-		if useBitswap := c.Query("bitswap"); useBitswap == "1" || useBitswap == "true" {
-			stats.BitswapStats = ctx.FileStore().BitswapStats()
-		}
-		if ds, err := ctx.FileStore().DiskStats(); err == nil {
-			stats.DiskStats = &DiskStats{
-				DiskStats: ds,
+		useBitswap := c.Query("bitswap")
+		c.JSON(200, collectStats(ctx, p.startedAt, useBitswap == "1" || useBitswap == "true"))
+	}
+}
+
+// SLOHandler reports SLIs of namespaces within their windows and objectives breached,
+// query param namespace limits the report to a single namespace.
+func (p *PublicServer) SLOHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reports := ctx.RecordStore().SLOReports()
+		if ns, ok := c.GetQuery("namespace"); ok {
+			for _, rep := range reports {
+				if rep.Namespace == ns {
+					c.JSON(200, rep)
+					return
+				}
 			}
-			stats.DiskStats.KBytesAll = float64(ds.BytesAll) / KB
-			stats.DiskStats.KBytesUsed = float64(ds.BytesUsed) / KB
-			stats.DiskStats.KBytesFree = float64(ds.BytesFree) / KB
-			stats.DiskStats.MBytesAll = float64(ds.BytesAll) / MB
-			stats.DiskStats.MBytesUsed = float64(ds.BytesUsed) / MB
-			stats.DiskStats.MBytesFree = float64(ds.BytesFree) / MB
-			stats.DiskStats.GBytesAll = float64(ds.BytesAll) / GB
-			stats.DiskStats.GBytesUsed = float64(ds.BytesUsed) / GB
-			stats.DiskStats.GBytesFree = float64(ds.BytesFree) / GB
+			c.AbortWithStatus(404)
+			return
 		}
-		c.JSON(200, stats)
+		c.JSON(200, reports)
 	}
 }
 
+// PopularHandler lists the most read records under prefix within window, e.g. 1h or 7d,
+// paths the policy wouldn't let the client read are left out.
+func (p *PublicServer) PopularHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q := &rs.PopularQuery{
+			Prefix: c.Query("prefix"),
+		}
+		if v := c.Query("window"); len(v) > 0 {
+			window, err := parseWindow(v)
+			if err != nil || window <= 0 {
+				c.String(400, "error: window must be a positive duration, e.g. 1h or 7d")
+				return
+			}
+			q.Window = window
+		}
+		if v := c.Query("limit"); len(v) > 0 {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > rs.MaxPopularLimit {
+				c.String(400, "error: limit must be between 1 and %d", rs.MaxPopularLimit)
+				return
+			}
+			q.Limit = n
+		}
+		res, err := ctx.RecordStore().PopularRecords(ctx.WithRequest(c), q)
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		if policy := ctx.Policy(); policy != nil {
+			client := clientID(c, ctx.ClientHeader())
+			records := res.Records[:0]
+			for _, rec := range res.Records {
+				in := &PolicyInput{
+					Op:     PolicyRead,
+					Caller: client,
+					Path:   rec.Path,
+					Labels: make(map[string]string),
+					Time:   time.Now(),
+				}
+				if ns := rs.NamespaceOf(rec.Path); len(ns) > 0 {
+					in.Labels["namespace"] = ns
+				}
+				if ok, _, err := policy.Evaluate(in); ok && err == nil {
+					records = append(records, rec)
+				}
+			}
+			res.Records = records
+		}
+		c.JSON(200, res)
+	}
+}
+
+// parseWindow parses a duration that may be given in days, e.g. 7d.
+func parseWindow(v string) (time.Duration, error) {
+	if strings.HasSuffix(v, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
+
+func collectStats(ctx APIContext, startedAt time.Time, withBitswap bool) *Stats {
+	stats := &Stats{
+		Uptime:         fmt.Sprintf("%s", time.Since(startedAt)),
+		BandwidthStats: ctx.FileStore().BandwidthStats(),
+		RepoStats:      ctx.FileStore().RepoStats(),
+		BadgerStats:    ctx.RecordStore().BadgerStats(),
+		Circuits:       ctx.RecordStore().CircuitStates(),
+		BlocklistStats: ctx.FileStore().BlocklistStats(),
+		MmapCacheStats: ctx.FileStore().MmapCacheStats(),
+		PeerExchange:   ctx.FileStore().PeerExchangeStats(),
+		Inline:         ctx.FileStore().InlineStats(),
+		StateStats:     ctx.StateStore().Stats(),
+		WatchStats:     ctx.RecordStore().WatchStats(),
+		DedupStats:     ctx.RecordStore().DedupStats(),
+	}
+	if withBitswap {
+		stats.BitswapStats = ctx.FileStore().BitswapStats()
+	}
+	if ds, err := ctx.FileStore().DiskStats(); err == nil {
+		stats.DiskStats = &DiskStats{
+			DiskStats: ds,
+		}
+		stats.DiskStats.KBytesAll = float64(ds.BytesAll) / KB
+		stats.DiskStats.KBytesUsed = float64(ds.BytesUsed) / KB
+		stats.DiskStats.KBytesFree = float64(ds.BytesFree) / KB
+		stats.DiskStats.MBytesAll = float64(ds.BytesAll) / MB
+		stats.DiskStats.MBytesUsed = float64(ds.BytesUsed) / MB
+		stats.DiskStats.MBytesFree = float64(ds.BytesFree) / MB
+		stats.DiskStats.GBytesAll = float64(ds.BytesAll) / GB
+		stats.DiskStats.GBytesUsed = float64(ds.BytesUsed) / GB
+		stats.DiskStats.GBytesFree = float64(ds.BytesFree) / GB
+	}
+	return stats
+}
+
 func (p *PublicServer) ContentHandler(ctx APIContext) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		r, err := ctx.RecordStore().ReadRecord(ctx, c.Param("path"), rs.ReadOptions{
+		reqCtx := ctx.WithRequest(c)
+		asOf, err := parseAsOf(reqCtx, c.Query("as_of"))
+		if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		startedAt := time.Now()
+		r, err := ctx.RecordStore().ReadRecord(reqCtx, c.Param("path"), rs.ReadOptions{
+			AsOf:    asOf,
 			Version: c.Query("ver"),
 		})
+		ctx.RecordStore().ObserveRead(c.Param("path"), time.Since(startedAt), err)
 		if err == rs.ErrRecordNotFound {
 			if r != nil {
 				if meta := r.Object.Meta(); meta != nil {
@@ -175,20 +377,44 @@ func (p *PublicServer) ContentHandler(ctx APIContext) gin.HandlerFunc {
 			}
 			c.AbortWithStatus(404)
 			return
+		} else if serveCircuitError(c, err) {
+			return
+		} else if err == rs.ErrNoNamespaceKey {
+			c.String(403, "error: %v", err)
+			return
 		} else if err != nil {
 			c.String(500, "error: %v", err)
 			return
 		}
+		if r.Body != nil {
+			// stops fetches of blocks if the client went away mid-body
+			defer r.Body.Close()
+		}
+		serveSigner(c, r)
+		serveAnnotations(ctx, c, r)
+		if meta := r.Object.Meta(); ctx.SRI() && isHTMLPath(meta.Path()) {
+			serveWithIntegrity(ctx, c, r.Body, meta)
+			return
+		}
 		serveObject(c, r.Body, r.Object.Meta())
 	}
 }
 
 func (p *PublicServer) MetaHandler(ctx APIContext) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		r, err := ctx.RecordStore().ReadRecord(ctx, c.Param("path"), rs.ReadOptions{
+		reqCtx := ctx.WithRequest(c)
+		asOf, err := parseAsOf(reqCtx, c.Query("as_of"))
+		if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		startedAt := time.Now()
+		r, err := ctx.RecordStore().ReadRecord(reqCtx, c.Param("path"), rs.ReadOptions{
+			AsOf:      asOf,
 			Version:   c.Query("ver"),
 			NoContent: true,
 		})
+		ctx.RecordStore().ObserveRead(c.Param("path"), time.Since(startedAt), err)
 		if err == rs.ErrRecordNotFound {
 			if r != nil {
 				c.JSON(200, r.Object.Meta())
@@ -196,16 +422,29 @@ func (p *PublicServer) MetaHandler(ctx APIContext) gin.HandlerFunc {
 			}
 			c.AbortWithStatus(404)
 			return
+		} else if serveCircuitError(c, err) {
+			return
+		} else if err == rs.ErrNoNamespaceKey {
+			c.String(403, "error: %v", err)
+			return
 		} else if err != nil {
 			c.String(500, "error: %v", err)
 			return
 		}
+		serveSigner(c, r)
+		serveAnnotations(ctx, c, r)
 		c.JSON(200, r.Object.Meta())
 	}
 }
 
 func (p *PublicServer) PutHandler(ctx APIContext) gin.HandlerFunc {
+	return putHandler(ctx)
+}
+
+// putHandler creates or updates the record, it also serves writes forwarded to ingest nodes.
+func putHandler(ctx APIContext) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
 		size, _ := strconv.ParseInt(c.Request.Header.Get("Content-Length"), 10, 64)
 		userMeta := c.Request.Header.Get("X-Meta-UserMeta")
 		if len(userMeta) > 0 {
@@ -214,35 +453,99 @@ func (p *PublicServer) PutHandler(ctx APIContext) gin.HandlerFunc {
 				return
 			}
 		}
+		expiresAt, err := parseExpiry(c)
+		if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
 		path := c.Param("path")
 		if len(path) == 0 || path == "/" || len(filepath.Base(path)) == 0 {
 			c.AbortWithStatus(400)
 			return
 		}
-		r, err := ctx.RecordStore().CreateRecord(ctx, path, c.Request.Body, rs.CreateOptions{
-			Size:     size,
-			UserMeta: []byte(userMeta),
-		})
-		if err == rs.ErrRecordExists {
-			log.Debugln("record exists, updating:", path)
-			r, err = ctx.RecordStore().UpdateRecord(ctx, path, c.Request.Body, rs.UpdateOptions{
+		if isDryRun(c) {
+			opts := rs.CreateOptions{
 				Size:     size,
 				UserMeta: []byte(userMeta),
-			})
-		} else if err == nil {
-			log.Debugln("record not exists, created:", path, r.Id())
+			}
+			plan, err := ctx.RecordStore().DryRunWrite(reqCtx, rs.WriteCreate, path, c.Request.Body, opts)
+			if err == rs.ErrRecordExists {
+				plan, err = ctx.RecordStore().DryRunWrite(reqCtx, rs.WriteUpdate, path, c.Request.Body, opts)
+			}
+			serveWritePlan(c, plan, err)
+			return
 		}
-		if err != nil {
+		r, err := writeRecord(reqCtx, ctx.RecordStore(), path, c.Request.Body, size, []byte(userMeta), expiresAt)
+		if serveWriteError(c, err) {
+			return
+		} else if err != nil {
 			c.String(500, "error: %v", err)
 			return
 		}
+		serveSigner(c, r)
 		c.JSON(200, r.Object.Meta())
 	}
 }
 
+// parseExpiry reads the expiry of the written version from X-Meta-ExpiresAt, an RFC 3339
+// time, or X-Meta-TTL, a duration from now. Zero time is returned if neither is set.
+func parseExpiry(c *gin.Context) (time.Time, error) {
+	if v := c.Request.Header.Get("X-Meta-ExpiresAt"); len(v) > 0 {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad expiry time: %v", err)
+		} else if !t.After(time.Now()) {
+			return time.Time{}, fmt.Errorf("expiry time is in the past: %s", v)
+		}
+		return t, nil
+	}
+	if v := c.Request.Header.Get("X-Meta-TTL"); len(v) > 0 {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad TTL: %v", err)
+		} else if d <= 0 {
+			return time.Time{}, fmt.Errorf("TTL must be positive: %s", v)
+		}
+		return time.Now().Add(d), nil
+	}
+	return time.Time{}, nil
+}
+
+// writeRecord creates the record at path, or updates it if it exists. Zero expiresAt
+// writes a version that never expires.
+func writeRecord(ctx context.Context, store rs.PlanetaryRecordStore,
+	path string, body io.ReadCloser, size int64, userMeta []byte, expiresAt time.Time) (*rs.Record, error) {
+	r, err := store.CreateRecord(ctx, path, body, rs.CreateOptions{
+		Size:      size,
+		UserMeta:  userMeta,
+		ExpiresAt: expiresAt,
+	})
+	if err == rs.ErrRecordExists {
+		log.Debugln("record exists, updating:", path)
+		r, err = store.UpdateRecord(ctx, path, body, rs.UpdateOptions{
+			Size:      size,
+			UserMeta:  userMeta,
+			ExpiresAt: expiresAt,
+		})
+	} else if err == nil {
+		log.Debugln("record not exists, created:", path, r.Id())
+	}
+	return r, err
+}
+
 func (p *PublicServer) DeleteHandler(ctx APIContext) gin.HandlerFunc {
+	return deleteHandler(ctx)
+}
+
+func deleteHandler(ctx APIContext) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		r, err := ctx.RecordStore().DeleteRecord(ctx, c.Param("id"))
+		reqCtx := ctx.WithRequest(c)
+		if isDryRun(c) {
+			plan, err := ctx.RecordStore().DryRunWrite(reqCtx, rs.WriteDelete, c.Param("id"), nil)
+			serveWritePlan(c, plan, err)
+			return
+		}
+		r, err := ctx.RecordStore().DeleteRecord(reqCtx, c.Param("id"))
 		if err == rs.ErrRecordNotFound {
 			if r != nil {
 				if meta := r.Object.Meta(); meta != nil {
@@ -253,6 +556,8 @@ func (p *PublicServer) DeleteHandler(ctx APIContext) gin.HandlerFunc {
 			}
 			c.Status(404)
 			return
+		} else if serveWriteError(c, err) {
+			return
 		} else if err != nil {
 			c.String(500, "error: %v", err)
 			return
@@ -308,6 +613,7 @@ type DistributionInfo struct {
 
 func (p *PublicServer) TokenDistributionInfo(ctx APIContext) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
 		accountAddr := strings.ToLower(c.Query("account"))
 		if len(accountAddr) == 0 {
 			accountAddr = ctx.ETHAddr()
@@ -316,7 +622,7 @@ func (p *PublicServer) TokenDistributionInfo(ctx APIContext) gin.HandlerFunc {
 			}
 		}
 		var report *rs.BeatReport
-		r, err := ctx.RecordStore().ReadRecord(ctx, fmt.Sprintf("/beat_reports/%s.json", accountAddr))
+		r, err := ctx.RecordStore().ReadRecord(reqCtx, fmt.Sprintf("/beat_reports/%s.json", accountAddr))
 		if err == rs.ErrRecordNotFound {
 			c.JSON(200, &DistributionInfo{})
 			return
@@ -420,6 +726,86 @@ func numeric(str string) string {
 	return string(safe)
 }
 
+// parseAsOf parses as_of query param, it can be a RFC3339 timestamp,
+// unix time in seconds or a name of an index checkpoint.
+func parseAsOf(ctx APIContext, v string) (time.Time, error) {
+	if len(v) == 0 {
+		return time.Time{}, nil
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return ts, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	cp, err := ctx.RecordStore().GetCheckpoint(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("as_of is not a timestamp nor a known checkpoint: %v", err)
+	}
+	return cp.Time, nil
+}
+
+// serveWriteError responds to writes refused by the node itself, rather than failed.
+// isDryRun reports whether the write should only be validated, see ?dry_run=true.
+func isDryRun(c *gin.Context) bool {
+	v := c.Query("dry_run")
+	return v == "1" || v == "true"
+}
+
+func serveWritePlan(c *gin.Context, plan *rs.WritePlan, err error) {
+	if err == rs.ErrRecordNotFound {
+		c.Status(404)
+		return
+	} else if serveWriteError(c, err) {
+		return
+	} else if err != nil {
+		c.String(500, "error: %v", err)
+		return
+	}
+	c.JSON(200, plan)
+}
+
+func serveWriteError(c *gin.Context, err error) bool {
+	switch err := err.(type) {
+	case *rs.HookRejection:
+		c.String(422, "error: %v", err)
+	case *rs.FenceError:
+		c.String(403, "error: %v", err)
+	case *rs.ProtocolError:
+		c.String(409, "error: %v", err)
+	case *rs.ClockSkewError:
+		c.String(503, "error: %v", err)
+	case *rs.DiskBudgetError:
+		c.String(507, "error: %v", err)
+	default:
+		if err == rs.ErrWORMNamespace {
+			c.String(409, "error: %v", err)
+			return true
+		} else if err != rs.ErrNotAuthorized && err != rs.ErrReadOnly &&
+			err != rs.ErrProbationNamespace && err != rs.ErrNamespaceNotAllowed && err != rs.ErrNotOwner {
+			return false
+		}
+		c.String(403, "error: %v", err)
+	}
+	return true
+}
+
+// serveCircuitError responds with 504 and a retry hint if the read was cut short by
+// a circuit breaker, so clients back off instead of piling up on a hung subsystem.
+func serveCircuitError(c *gin.Context, err error) bool {
+	cerr, ok := err.(*rs.CircuitError)
+	if !ok {
+		return false
+	}
+	retryAfter := int(math.Ceil(cerr.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.String(504, "error: %v", cerr)
+	return true
+}
+
 func serveMeta(c *gin.Context, meta *proto.ObjectMeta) {
 	c.Header("X-Meta-ID", meta.Id())
 	c.Header("X-Meta-Version", meta.Version())
@@ -435,29 +821,38 @@ func serveMeta(c *gin.Context, meta *proto.ObjectMeta) {
 	if meta.IsDeleted() {
 		c.Header("X-Meta-Deleted", "true")
 	}
+	if t := meta.ExpiresAt(); t > 0 {
+		c.Header("X-Meta-ExpiresAt", time.Unix(0, t).UTC().Format(time.RFC3339))
+	}
+}
+
+// serveSigner tells which node signed the announce of the served version.
+func serveSigner(c *gin.Context, r *rs.Record) {
+	nodeID, sig := r.Signer(r.Object.Version)
+	if len(nodeID) == 0 {
+		return
+	}
+	c.Header("X-Meta-Signer", nodeID)
+	c.Header("X-Meta-Signature", sig)
 }
 
 func serveObject(c *gin.Context, r io.ReadCloser, meta *proto.ObjectMeta) {
 	serveMeta(c, meta)
+	etag := objectETag(meta.Version())
+	c.Header("ETag", etag)
 	ts := time.Unix(0, meta.CreatedAt())
 	if seekable, ok := r.(io.ReadSeeker); ok {
 		http.ServeContent(c.Writer, c.Request, meta.Path(), ts, seekable)
 		return
 	}
-	// actually do all the work http.ServeContent does, but without support
-	// of ranges and partial reads due to lack of io.Seeker interface.
+	// actually do all the work http.ServeContent does, ranges are served
+	// by skipping the body due to lack of io.Seeker interface.
 	if !ts.IsZero() {
 		c.Header("Last-Modified", ts.UTC().Format(http.TimeFormat))
 	}
 	ctype := mime.TypeByExtension(filepath.Ext(meta.Path()))
 	c.Header("Content-Type", ctype)
-	if meta.Size() > 0 {
-		c.Header("Content-Length", strconv.FormatInt(meta.Size(), 10))
-		io.CopyN(c.Writer, r, meta.Size())
-		return
-	}
-	io.Copy(c.Writer, r)
-	return
+	serveStream(c, r, meta.Size(), etag)
 }
 
 //go:generate go-bindata-assetfs -pkg api assets/templates assets/icons
@@ -527,10 +922,33 @@ type ListVersionsResponse struct {
 	Versions []*proto.ObjectMeta `json:"versions"`
 }
 
+// ProofHandler serves the Merkle inclusion proof of a record in a manifest checkpoint,
+// the current manifest index of the namespace is used if checkpoint is not set.
+func (p *PublicServer) ProofHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		proof, err := ctx.RecordStore().RecordProof(ctx.WithRequest(c), c.Param("path"), c.Query("checkpoint"))
+		switch {
+		case err == rs.ErrRecordNotFound, err == rs.ErrNotInIndex:
+			c.String(404, "error: %v", err)
+			return
+		case err == rs.ErrNoMerkleRoot:
+			c.String(422, "error: %v", err)
+			return
+		case serveCircuitError(c, err):
+			return
+		case err != nil:
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, proof)
+	}
+}
+
 func (p *PublicServer) ListVersionsHandler(ctx APIContext) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
 		var versions []*proto.ObjectMeta
-		r, err := ctx.RecordStore().ReadRecord(ctx, c.Param("path"), rs.ReadOptions{
+		r, err := ctx.RecordStore().ReadRecord(reqCtx, c.Param("path"), rs.ReadOptions{
 			NoContent: true,
 		})
 		if err == rs.ErrRecordNotFound {
@@ -545,7 +963,7 @@ func (p *PublicServer) ListVersionsHandler(ctx APIContext) gin.HandlerFunc {
 		versions = append(versions, r.Object.Meta())
 		limit := r.Previous().Len()
 		for i := 0; i < limit; i++ {
-			r, err := ctx.RecordStore().ReadRecord(ctx, "", rs.ReadOptions{
+			r, err := ctx.RecordStore().ReadRecord(reqCtx, "", rs.ReadOptions{
 				Version:   r.Previous().At(i).Version(),
 				NoContent: true,
 			})
@@ -553,6 +971,9 @@ func (p *PublicServer) ListVersionsHandler(ctx APIContext) gin.HandlerFunc {
 				if r == nil {
 					continue
 				}
+			} else if reqCtx.Err() != nil {
+				// the client went away or the request timed out
+				return
 			} else if err != nil {
 				log.Warningf("failed to read record from store: %v", err)
 				continue
@@ -566,11 +987,91 @@ func (p *PublicServer) ListVersionsHandler(ctx APIContext) gin.HandlerFunc {
 	}
 }
 
+// RecordVersionsHandler lists the version chain of a record without reading objects,
+// which makes it cheaper than listVersions for long histories.
+func (p *PublicServer) RecordVersionsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		history, err := ctx.RecordStore().RecordHistory(reqCtx, c.Param("id"))
+		if err == rs.ErrRecordNotFound {
+			c.AbortWithStatus(404)
+			return
+		} else if serveCircuitError(c, err) {
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, history)
+	}
+}
+
+// RecordVersionHandler serves content of a version from the history of the record.
+func (p *PublicServer) RecordVersionHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		history, err := ctx.RecordStore().RecordHistory(reqCtx, c.Param("id"))
+		if err == rs.ErrRecordNotFound {
+			c.AbortWithStatus(404)
+			return
+		} else if serveCircuitError(c, err) {
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		version := c.Param("version")
+		var found bool
+		for _, v := range history.Versions {
+			if v.Version == version {
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.String(404, "error: version %s is not in the history of record %s", version, history.ID)
+			return
+		}
+		startedAt := time.Now()
+		r, err := ctx.RecordStore().ReadRecord(reqCtx, history.ID, rs.ReadOptions{
+			Version: version,
+		})
+		ctx.RecordStore().ObserveRead(history.Path, time.Since(startedAt), err)
+		if err == rs.ErrRecordNotFound {
+			if r != nil {
+				if meta := r.Object.Meta(); meta != nil {
+					serveMeta(c, meta)
+					c.Status(404)
+					return
+				}
+			}
+			c.AbortWithStatus(404)
+			return
+		} else if serveCircuitError(c, err) {
+			return
+		} else if err == rs.ErrNoNamespaceKey {
+			c.String(403, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+		serveObject(c, r.Body, r.Object.Meta())
+	}
+}
+
 type ListResponse struct {
 	Dirs  []string
 	Files []*proto.ObjectMeta
+	// NextCursor continues a paginated listing, it's empty on the last page.
+	NextCursor string `json:",omitempty"`
 }
 
+const maxListLimit = 10000
+
 type ObjectMetas []*proto.ObjectMeta
 
 func (s ObjectMetas) Len() int           { return len(s) }
@@ -579,6 +1080,7 @@ func (s ObjectMetas) Less(i, j int) bool { return s[i].Path() < s[j].Path() }
 
 func (p *PublicServer) ListAllHandler(ctx APIContext) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
 		prefix := c.Param("prefix")
 		if !strings.HasPrefix(prefix, "/") {
 			prefix = "/" + prefix
@@ -586,57 +1088,96 @@ func (p *PublicServer) ListAllHandler(ctx APIContext) gin.HandlerFunc {
 		if !strings.HasSuffix(prefix, "/") {
 			prefix = prefix + "/"
 		}
-		resp := &ListResponse{}
-		seenDirs := make(map[string]struct{})
-		err := ctx.RecordStore().WalkRecords(ctx, "", func(path string, r *rs.Record) error {
-			if len(path) == 0 {
-				return nil
-			} else if !strings.HasPrefix(path, prefix) {
-				return nil
-			}
-			path = strings.TrimPrefix(path, prefix)
-			parts := strings.Split(path, "/")
-			if len(parts) > 1 {
-				dir := parts[0]
-				if _, ok := seenDirs[dir]; ok {
-					return nil
-				}
-				seenDirs[dir] = struct{}{}
-				resp.Dirs = append(resp.Dirs, filepath.Join(prefix, dir)+"/")
-				return nil
-			}
-			var meta *proto.ObjectMeta
-			if metaRecord, err := ctx.RecordStore().ReadRecord(ctx, r.Path(), rs.ReadOptions{
-				Version:   r.Current().Version(),
-				NoContent: true,
-			}); err == rs.ErrRecordNotFound {
-				return nil
-			} else if err != nil {
-				log.Warningf("failed to fetch record: %v", err)
-				return nil
-			} else {
-				meta = metaRecord.Object.Meta()
+		var limit int
+		if v := c.Query("limit"); len(v) > 0 {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > maxListLimit {
+				c.String(400, "error: limit must be between 1 and %d", maxListLimit)
+				return
 			}
-			resp.Files = append(resp.Files, meta)
-
-			return nil
-		})
-		if err == rs.ErrRecordNotFound || len(resp.Files)+len(resp.Dirs) == 0 {
+			limit = n
+		}
+		cursor := c.Query("cursor")
+		resp, err := listRecords(reqCtx, ctx.RecordStore(), prefix, cursor, limit)
+		if err == rs.ErrRecordNotFound || (len(resp.Files)+len(resp.Dirs) == 0 && len(cursor) == 0) {
 			c.Status(404)
 			return
 		} else if err != nil {
 			c.String(500, "error: %v", err)
 			return
 		}
-
-		sort.Sort(sort.StringSlice(resp.Dirs))
-		sort.Sort(ObjectMetas(resp.Files))
 		c.JSON(200, resp)
 	}
 }
 
+// listRecords lists files and dirs right under the prefix, which must end with a slash.
+// With a limit set the listing is paginated, see ListResponse.NextCursor.
+func listRecords(ctx context.Context, store rs.PlanetaryRecordStore, prefix, cursor string, limit int) (*ListResponse, error) {
+	resp := &ListResponse{}
+	seenDirs := make(map[string]struct{})
+	// a page ends after the limit of entries, the next one starts at the record that follows
+	pageFull := func() error {
+		if limit > 0 && len(resp.Files)+len(resp.Dirs) >= limit {
+			return rs.ErrWalkStop
+		}
+		return nil
+	}
+	walkPage := store.WalkRecordsPage
+	if ns := rs.NamespaceOf(prefix); len(ns) > 0 {
+		// records of a namespace are walked over its index
+		walkPage = func(walkCtx context.Context, cursor string, limit int, fn rs.RecordWalkFunc) (string, error) {
+			return store.WalkNamespacePage(walkCtx, ns, cursor, limit, fn)
+		}
+	}
+	next, err := walkPage(ctx, cursor, 0, func(path string, r *rs.Record) error {
+		if len(path) == 0 {
+			return nil
+		} else if !strings.HasPrefix(path, prefix) {
+			return nil
+		}
+		path = strings.TrimPrefix(path, prefix)
+		parts := strings.Split(path, "/")
+		if len(parts) > 1 {
+			dir := parts[0]
+			if _, ok := seenDirs[dir]; ok {
+				return nil
+			}
+			seenDirs[dir] = struct{}{}
+			resp.Dirs = append(resp.Dirs, filepath.Join(prefix, dir)+"/")
+			return pageFull()
+		}
+		var meta *proto.ObjectMeta
+		if metaRecord, err := store.ReadRecord(ctx, r.Path(), rs.ReadOptions{
+			Version:   r.Current().Version(),
+			NoContent: true,
+		}); err == rs.ErrRecordNotFound {
+			return nil
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			log.Warningf("failed to fetch record: %v", err)
+			return nil
+		} else {
+			meta = metaRecord.Object.Meta()
+		}
+		resp.Files = append(resp.Files, meta)
+
+		return pageFull()
+	})
+	if err != nil {
+		return resp, err
+	}
+	if limit > 0 {
+		resp.NextCursor = next
+	}
+	sort.Sort(sort.StringSlice(resp.Dirs))
+	sort.Sort(ObjectMetas(resp.Files))
+	return resp, nil
+}
+
 func (p *PublicServer) IndexHandler(ctx APIContext) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
 		prefix := c.Param("prefix")
 		if !strings.HasPrefix(prefix, "/") {
 			prefix = "/" + prefix
@@ -652,7 +1193,7 @@ func (p *PublicServer) IndexHandler(ctx APIContext) gin.HandlerFunc {
 		}
 
 		seenDirs := make(map[string]struct{})
-		err := ctx.RecordStore().WalkRecords(ctx, "", func(path string, r *rs.Record) error {
+		err := ctx.RecordStore().WalkRecords(reqCtx, "", func(path string, r *rs.Record) error {
 			if len(path) == 0 {
 				return nil
 			} else if !strings.HasPrefix(path, prefix) {
@@ -676,11 +1217,13 @@ func (p *PublicServer) IndexHandler(ctx APIContext) gin.HandlerFunc {
 				return nil
 			}
 			var meta *proto.ObjectMeta
-			if metaRecord, err := ctx.RecordStore().ReadRecord(ctx, r.Path(), rs.ReadOptions{
+			if metaRecord, err := ctx.RecordStore().ReadRecord(reqCtx, r.Path(), rs.ReadOptions{
 				Version:   r.Current().Version(),
 				NoContent: true,
 			}); err == rs.ErrRecordNotFound {
 				return nil
+			} else if reqCtx.Err() != nil {
+				return reqCtx.Err()
 			} else if err != nil {
 				log.Warningf("failed to fetch record: %v", err)
 				return nil
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Versions are CIDs of the object content, so they make strong ETags: content served under
+// an ETag never changes. http.ServeContent honors If-None-Match, If-Range and Range for
+// seekable bodies, like DAG readers and cached objects. Bodies that can't seek, e.g. objects
+// rebuilt from erasure coded shards, are served a single range by skipping up to its start.
+
+// objectETag returns the strong ETag of an object version.
+func objectETag(version string) string {
+	return `"` + version + `"`
+}
+
+// etagMatches tells whether the If-None-Match header lists the ETag, compared weakly.
+func etagMatches(header, etag string) bool {
+	if len(header) == 0 {
+		return false
+	} else if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, v := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(v), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseByteRange parses a Range header of a single range over content of the size, end is
+// inclusive. Multiple ranges are not supported, ok is false for them and the whole content
+// is served instead. A range that can't be satisfied returns an error.
+func parseByteRange(header string, size int64) (start, end int64, ok bool, err error) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, 0, false, nil
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(header, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false, nil
+	}
+	first, last := strings.TrimSpace(bounds[0]), strings.TrimSpace(bounds[1])
+	switch {
+	case len(first) == 0:
+		// a suffix of the given length
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false, fmt.Errorf("invalid range: %s", header)
+		} else if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, nil
+	default:
+		if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 {
+			return 0, 0, false, fmt.Errorf("invalid range: %s", header)
+		} else if start >= size {
+			return 0, 0, false, fmt.Errorf("range starts after the end: %s", header)
+		}
+		end = size - 1
+		if len(last) > 0 {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+				return 0, 0, false, fmt.Errorf("invalid range: %s", header)
+			} else if end >= size {
+				end = size - 1
+			}
+		}
+		return start, end, true, nil
+	}
+}
+
+// serveStream serves a body that can't seek, honoring conditional and range requests.
+func serveStream(c *gin.Context, r io.Reader, size int64, etag string) {
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	rangeHeader := c.GetHeader("Range")
+	if ifRange := c.GetHeader("If-Range"); len(ifRange) > 0 && ifRange != etag {
+		// the client has another version, it gets the whole content
+		rangeHeader = ""
+	}
+	if size <= 0 {
+		io.Copy(c.Writer, r)
+		return
+	}
+	c.Header("Accept-Ranges", "bytes")
+	start, end, ok, err := parseByteRange(rangeHeader, size)
+	if err != nil {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+		c.String(http.StatusRequestedRangeNotSatisfiable, "error: %v", err)
+		return
+	} else if !ok {
+		c.Header("Content-Length", strconv.FormatInt(size, 10))
+		c.Status(http.StatusOK)
+		io.CopyN(c.Writer, r, size)
+		return
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, start); err != nil {
+		c.String(http.StatusInternalServerError, "error: %v", err)
+		return
+	}
+	c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	c.Header("Content-Length", strconv.FormatInt(end-start+1, 10))
+	c.Status(http.StatusPartialContent)
+	io.CopyN(c.Writer, r, end-start+1)
+}
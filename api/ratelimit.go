@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// rateLimits refuses requests to records of namespaces once the client exceeds
+// the rate limit of the namespace, see rs.RateLimitPolicy.
+func rateLimits(ctx APIContext) gin.HandlerFunc {
+	header := ctx.ClientHeader()
+	return func(c *gin.Context) {
+		var ns string
+		if path := c.Param("path"); len(path) > 0 {
+			ns = rs.NamespaceOf(path)
+		} else if prefix := c.Param("prefix"); len(prefix) > 0 {
+			ns = rs.NamespaceOf(strings.TrimSuffix(prefix, "/") + "/")
+		}
+		if len(ns) == 0 {
+			c.Next()
+			return
+		}
+		ok, wait := ctx.RecordStore().AllowRequest(ns, clientID(c, header))
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			c.String(429, "error: rate limit of namespace %s exceeded", ns)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// clientID identifies the client by the header set by a trusted proxy,
+// or by its remote address. Only the first address of a list is used.
+func clientID(c *gin.Context, header string) string {
+	if len(header) > 0 {
+		if v := c.Request.Header.Get(header); len(v) > 0 {
+			return strings.TrimSpace(strings.Split(v, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
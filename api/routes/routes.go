@@ -0,0 +1,151 @@
+// Package routes declares routes of the node APIs that the client package covers. The api
+// package serves these routes from the table and the typed client is generated from it, so
+// paths, methods and query params of both can't drift apart. The package has no imports,
+// clients built on it don't pull the node in.
+package routes
+
+// Route is a route of the public or the private API.
+type Route struct {
+	// Name is the name of the client method of the route, unique within its API.
+	Name   string
+	Method string
+	// Path is the gin pattern of the route, its :params and the trailing *wildcard are
+	// arguments of the client method in the order they appear.
+	Path    string
+	Private bool
+	// Query lists query params the client sets after the path arguments, as "name" or
+	// "name:arg" to call the argument differently, followed by " int" or " []string" for
+	// arguments that aren't strings. Empty and zero arguments are not sent.
+	Query []string
+	// Returns is what the client decodes the response into, a pointer to a model of the
+	// client package, "string" or "json.RawMessage". Routes without it have client methods
+	// written by hand on top of the generated path builder.
+	Returns string
+	Doc     string
+}
+
+// Table lists the routes of the client, see the client package.
+var Table = []Route{
+	{
+		Name:    "Ping",
+		Method:  "GET",
+		Path:    "/api/v1/ping",
+		Returns: "string",
+		Doc:     "checks that the node serves its public API",
+	},
+	{
+		Name:    "Version",
+		Method:  "GET",
+		Path:    "/api/v1/version",
+		Returns: "string",
+		Doc:     "returns the version of the node",
+	},
+	{
+		Name:    "Stats",
+		Method:  "GET",
+		Path:    "/api/v1/stats",
+		Returns: "json.RawMessage",
+		Doc:     "returns the raw JSON of node stats, its layout follows the node version",
+	},
+	{
+		Name:   "Content",
+		Method: "GET",
+		Path:   "/api/v1/content/*path",
+		Query:  []string{"ver:version"},
+		Doc:    "serves the content of a record, meta is in the headers",
+	},
+	{
+		Name:    "Meta",
+		Method:  "GET",
+		Path:    "/api/v1/meta/*path",
+		Query:   []string{"ver:version"},
+		Returns: "*ObjectMeta",
+		Doc:     "returns meta of the record at path, version is optional",
+	},
+	{
+		Name:   "Put",
+		Method: "POST",
+		Path:   "/api/v1/put/*path",
+		Doc:    "creates or updates the record at path",
+	},
+	{
+		Name:   "Delete",
+		Method: "POST",
+		Path:   "/api/v1/delete/:id",
+		Doc:    "deletes the record with the ID, meta of the deleted version is in the headers",
+	},
+	{
+		Name:    "ListVersions",
+		Method:  "GET",
+		Path:    "/api/v1/listVersions/*path",
+		Returns: "*ListVersionsResponse",
+		Doc:     "returns all versions of the record at path",
+	},
+	{
+		Name:    "ListPage",
+		Method:  "GET",
+		Path:    "/api/v1/listAll/*prefix",
+		Query:   []string{"cursor", "limit int"},
+		Returns: "*ListResponse",
+		Doc:     "lists files and dirs right under the prefix, a page of at most limit entries starts at the cursor, pages continue at NextCursor of the previous one",
+	},
+	{
+		Name:   "Subscribe",
+		Method: "GET",
+		Path:   "/api/v1/subscribe",
+		Query:  []string{"prefix:prefixes []string", "filter:filters []string"},
+		Doc:    "streams record changes as Server-Sent Events",
+	},
+	{
+		Name:    "Proof",
+		Method:  "GET",
+		Path:    "/api/v1/proof/*path",
+		Query:   []string{"checkpoint"},
+		Returns: "*Proof",
+		Doc:     "fetches the inclusion proof of the record at path, checkpoint is the CID of a manifest index or empty for the current one. The proof must be checked with VerifyProof",
+	},
+	{
+		Name:    "Ping",
+		Method:  "GET",
+		Path:    "/private/v1/ping",
+		Private: true,
+		Returns: "string",
+		Doc:     "checks that the node serves its private API",
+	},
+	{
+		Name:    "Status",
+		Method:  "GET",
+		Path:    "/private/v1/status",
+		Private: true,
+		Returns: "*NodeStatus",
+		Doc:     "returns the status of the node",
+	},
+}
+
+// Lookup finds the route of the public or the private API by its name.
+func Lookup(private bool, name string) (Route, bool) {
+	for _, r := range Table {
+		if r.Private == private && r.Name == name {
+			return r, true
+		}
+	}
+	return Route{}, false
+}
+
+// Public returns the route of the public API by its name, it panics if there's none.
+func Public(name string) Route {
+	return mustLookup(false, name)
+}
+
+// Private returns the route of the private API by its name, it panics if there's none.
+func Private(name string) Route {
+	return mustLookup(true, name)
+}
+
+func mustLookup(private bool, name string) Route {
+	r, ok := Lookup(private, name)
+	if !ok {
+		panic("routes: no route " + name + " in the table")
+	}
+	return r
+}
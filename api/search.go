@@ -0,0 +1,80 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// SearchHandler queries the search index. Query params: q (terms of paths, user meta and
+// text content), prefix, type (content type, e.g. image/*), meta.<field> (value of a user
+// meta field, may be repeated for other fields), since and until (RFC3339 bounds of the
+// modification time), deleted, cursor and limit.
+func (p *PublicServer) SearchHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q := &rs.SearchQuery{
+			Text:        c.Query("q"),
+			Prefix:      c.Query("prefix"),
+			ContentType: strings.ToLower(c.Query("type")),
+			Deleted:     c.Query("deleted") == "true",
+			Cursor:      c.Query("cursor"),
+		}
+		for name, values := range c.Request.URL.Query() {
+			if strings.HasPrefix(name, "meta.") && len(values) > 0 {
+				if q.Meta == nil {
+					q.Meta = make(map[string]string)
+				}
+				q.Meta[strings.TrimPrefix(name, "meta.")] = values[0]
+			}
+		}
+		for _, bound := range []struct {
+			name string
+			t    *time.Time
+		}{
+			{"since", &q.Since},
+			{"until", &q.Until},
+		} {
+			if v := c.Query(bound.name); len(v) > 0 {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					c.String(400, "error: %s must be an RFC3339 time: %v", bound.name, err)
+					return
+				}
+				*bound.t = t
+			}
+		}
+		if v := c.Query("limit"); len(v) > 0 {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > rs.MaxSearchLimit {
+				c.String(400, "error: limit must be between 1 and %d", rs.MaxSearchLimit)
+				return
+			}
+			q.Limit = n
+		}
+		res, err := ctx.RecordStore().Search(ctx.WithRequest(c), q)
+		if _, ok := err.(rs.SearchQueryError); ok {
+			c.String(400, "error: %v", err)
+			return
+		} else if err == rs.ErrSearchDisabled {
+			c.String(501, "error: %v", err)
+			return
+		} else if serveCircuitError(c, err) {
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, res)
+	}
+}
+
+// SearchStatsHandler reports the state of the search index.
+func (p *PrivateServer) SearchStatsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, ctx.RecordStore().SearchStats())
+	}
+}
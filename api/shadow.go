@@ -0,0 +1,299 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Shadowing mirrors a sample of public read requests to a staging node, so that a new release
+// can be validated against production traffic before promotion. Requests are mirrored after
+// the response has been served, from a bounded queue, a full queue drops them. Responses of
+// the staging node are discarded, only their status and a digest of the body are compared
+// to the served ones. Divergences are counted and the latest of them kept for the report.
+// Only routes that read records are mirrored, responses of the others differ between nodes.
+
+const (
+	// ShadowHeader is set on mirrored requests, a node never mirrors requests carrying it.
+	ShadowHeader = "X-Shadow"
+
+	shadowQueueSize      = 256
+	maxShadowDivergences = 100
+)
+
+var shadowRoutes = []string{
+	"/api/v1/content/",
+	"/api/v1/meta/",
+	"/api/v1/listVersions/",
+	"/api/v1/records/",
+	"/api/v1/listAll/",
+	"/api/v1/search",
+	"/api/v1/integrity/",
+	"/index/",
+}
+
+// shadowHeaders are request headers passed to the staging node.
+var shadowHeaders = []string{"Accept", "Range", "If-None-Match", "If-Modified-Since"}
+
+// ShadowConfig sets where and how much of the read traffic is mirrored.
+type ShadowConfig struct {
+	// Target is the base URL of the public API of the staging node.
+	Target *url.URL
+	// Fraction of read requests mirrored, between 0 and 1.
+	Fraction float64
+	Timeout  time.Duration
+	Workers  int
+}
+
+// ShadowDivergence is a mirrored request answered differently by the staging node.
+type ShadowDivergence struct {
+	Method       string    `json:"method"`
+	URI          string    `json:"uri"`
+	Status       int       `json:"status"`
+	ShadowStatus int       `json:"shadow_status,omitempty"`
+	Size         int64     `json:"size"`
+	ShadowSize   int64     `json:"shadow_size,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	At           time.Time `json:"at"`
+}
+
+type ShadowStats struct {
+	Target   string  `json:"target"`
+	Fraction float64 `json:"fraction"`
+	Queued   int     `json:"queued"`
+	Mirrored uint64  `json:"mirrored_total"`
+	Matched  uint64  `json:"matched_total"`
+	Diverged uint64  `json:"diverged_total"`
+	Failed   uint64  `json:"failed_total"`
+	Dropped  uint64  `json:"dropped_total"`
+	// Divergences are the latest ones, newest first.
+	Divergences []*ShadowDivergence `json:"divergences,omitempty"`
+}
+
+type shadowRequest struct {
+	method string
+	uri    string
+	header http.Header
+	status int
+	size   int64
+	sum    []byte
+}
+
+type shadower struct {
+	cfg    *ShadowConfig
+	client *http.Client
+	queue  chan *shadowRequest
+
+	mux         *sync.Mutex
+	rnd         *rand.Rand
+	stats       ShadowStats
+	divergences []*ShadowDivergence
+}
+
+func newShadower(cfg *ShadowConfig) *shadower {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	s := &shadower{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+		},
+		queue: make(chan *shadowRequest, shadowQueueSize),
+		mux:   new(sync.Mutex),
+		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		stats: ShadowStats{
+			Target:   cfg.Target.String(),
+			Fraction: cfg.Fraction,
+		},
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		go s.run()
+	}
+	return s
+}
+
+// sample tells whether the request is mirrored.
+func (s *shadower) sample(req *http.Request) bool {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	} else if len(req.Header.Get(ShadowHeader)) > 0 {
+		return false
+	} else if len(req.URL.Query().Get(presignSignatureParam)) > 0 {
+		// signatures of this node are refused by the staging node
+		return false
+	}
+	var routed bool
+	for _, prefix := range shadowRoutes {
+		if strings.HasPrefix(req.URL.Path, prefix) {
+			routed = true
+			break
+		}
+	}
+	if !routed {
+		return false
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.rnd.Float64() < s.cfg.Fraction
+}
+
+func (s *shadower) enqueue(req *shadowRequest) {
+	select {
+	case s.queue <- req:
+	default:
+		s.mux.Lock()
+		s.stats.Dropped++
+		s.mux.Unlock()
+	}
+}
+
+func (s *shadower) run() {
+	for req := range s.queue {
+		div := s.mirror(req)
+		s.mux.Lock()
+		s.stats.Mirrored++
+		switch {
+		case div == nil:
+			s.stats.Matched++
+		case len(div.Error) > 0:
+			s.stats.Failed++
+		default:
+			s.stats.Diverged++
+		}
+		if div != nil {
+			s.divergences = append(s.divergences, div)
+			if len(s.divergences) > maxShadowDivergences {
+				s.divergences = s.divergences[1:]
+			}
+		}
+		s.mux.Unlock()
+	}
+}
+
+// mirror sends the request to the staging node, returns nil if the response matches.
+func (s *shadower) mirror(req *shadowRequest) *ShadowDivergence {
+	div := &ShadowDivergence{
+		Method: req.method,
+		URI:    req.uri,
+		Status: req.status,
+		Size:   req.size,
+		At:     time.Now().UTC(),
+	}
+	target := strings.TrimSuffix(s.cfg.Target.String(), "/") + req.uri
+	shadowReq, err := http.NewRequest(req.method, target, nil)
+	if err != nil {
+		div.Error = err.Error()
+		return div
+	}
+	shadowReq.Header = req.header
+	shadowReq.Header.Set(ShadowHeader, "1")
+	resp, err := s.client.Do(shadowReq)
+	if err != nil {
+		div.Error = err.Error()
+		return div
+	}
+	defer resp.Body.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, resp.Body)
+	if err != nil {
+		div.Error = fmt.Sprintf("failed to read response: %v", err)
+		return div
+	}
+	if resp.StatusCode == req.status && size == req.size && bytes.Equal(h.Sum(nil), req.sum) {
+		return nil
+	}
+	div.ShadowStatus = resp.StatusCode
+	div.ShadowSize = size
+	log.WithFields(log.Fields{
+		"uri":           req.uri,
+		"status":        req.status,
+		"shadow_status": resp.StatusCode,
+	}).Debugln("staging node diverged on a mirrored request")
+	return div
+}
+
+func (s *shadower) Stats() *ShadowStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	stats := s.stats
+	stats.Queued = len(s.queue)
+	stats.Divergences = make([]*ShadowDivergence, 0, len(s.divergences))
+	for i := len(s.divergences) - 1; i >= 0; i-- {
+		stats.Divergences = append(stats.Divergences, s.divergences[i])
+	}
+	return &stats
+}
+
+// digestWriter hashes the response body as it's written.
+type digestWriter struct {
+	gin.ResponseWriter
+	h    hash.Hash
+	size int64
+}
+
+func (w *digestWriter) Write(data []byte) (int, error) {
+	w.h.Write(data)
+	w.size += int64(len(data))
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *digestWriter) WriteString(s string) (int, error) {
+	io.WriteString(w.h, s)
+	w.size += int64(len(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// shadowReads mirrors a sample of served read requests to the staging node.
+func shadowReads(ctx APIContext) gin.HandlerFunc {
+	s := ctx.shadowState()
+	if s == nil {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	return func(c *gin.Context) {
+		if !s.sample(c.Request) {
+			c.Next()
+			return
+		}
+		req := &shadowRequest{
+			method: c.Request.Method,
+			uri:    c.Request.URL.RequestURI(),
+			header: make(http.Header),
+		}
+		for _, name := range shadowHeaders {
+			if v := c.Request.Header.Get(name); len(v) > 0 {
+				req.header.Set(name, v)
+			}
+		}
+		w := &digestWriter{
+			ResponseWriter: c.Writer,
+			h:              sha256.New(),
+		}
+		c.Writer = w
+		c.Next()
+		if c.Request.Context().Err() != nil {
+			// the client went away, the response is incomplete
+			return
+		}
+		req.status = w.Status()
+		req.size = w.size
+		req.sum = w.h.Sum(nil)
+		s.enqueue(req)
+	}
+}
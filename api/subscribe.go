@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+const (
+	subscribeBuffer    = 256
+	subscribeHeartbeat = 30 * time.Second
+)
+
+// SubscribeHandler streams record changes as Server-Sent Events. Query params: prefix
+// (may be repeated), filter (JSONPath expression, may be repeated). Each event is named
+// after the operation (create, update or delete) and carries the change as JSON data.
+// The stream ends with the route timeout, so the route should be configured without one.
+func (p *PublicServer) SubscribeHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		prefixes := c.QueryArray("prefix")
+		opts := rs.WatchOptions{
+			Filters: c.QueryArray("filter"),
+			Buffer:  subscribeBuffer,
+		}
+		if len(prefixes) == 1 {
+			opts.Prefix = prefixes[0]
+		}
+		w, err := ctx.RecordStore().Watch(opts)
+		if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		defer w.Close()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(200)
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(subscribeHeartbeat)
+		defer heartbeat.Stop()
+		var eventID uint64
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+					return
+				}
+				c.Writer.Flush()
+			case change, ok := <-w.C:
+				if !ok {
+					return
+				} else if !matchPrefixes(change.Path, prefixes) {
+					continue
+				}
+				data, err := json.Marshal(change)
+				if err != nil {
+					continue
+				}
+				eventID++
+				if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", eventID, change.Op, data); err != nil {
+					return
+				}
+				c.Writer.Flush()
+			}
+		}
+	}
+}
+
+func matchPrefixes(path string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+)
+
+// SupportTokenHeader carries the private token for tools that don't send it as a bearer,
+// the token is known only to local tools that can read the token file of the node.
+const SupportTokenHeader = "X-Support-Token"
+
+const (
+	defaultSupportLogWindow = 72 * time.Hour
+	// maxSupportLogSize limits each log file in the bundle, the tail is kept.
+	maxSupportLogSize = 16 * 1024 * 1024
+
+	statsHistoryInterval = 5 * time.Minute
+	statsHistorySize     = 288
+)
+
+// SupportBundle is a gzipped tarball with diagnostics of a node for bug reports.
+type SupportBundle struct {
+	gw  *gzip.Writer
+	tw  *tar.Writer
+	now time.Time
+}
+
+func NewSupportBundle(w io.Writer) *SupportBundle {
+	gw := gzip.NewWriter(w)
+	return &SupportBundle{
+		gw:  gw,
+		tw:  tar.NewWriter(gw),
+		now: time.Now(),
+	}
+}
+
+func (b *SupportBundle) AddFile(name string, data []byte) error {
+	if err := b.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: b.now,
+	}); err != nil {
+		return err
+	}
+	_, err := b.tw.Write(data)
+	return err
+}
+
+func (b *SupportBundle) AddJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("failed to encode %s: %v", name, err)
+		return err
+	}
+	return b.AddFile(name, data)
+}
+
+// AddLogs adds log files of the last window, and fatal entries with panic traces
+// found in them as crashes.json.
+func (b *SupportBundle) AddLogs(dir string, window time.Duration) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	since := b.now.Add(-window)
+	crashes := make([]map[string]interface{}, 0)
+	for _, name := range files {
+		day, err := time.ParseInLocation("2006-01-02", strings.TrimSuffix(filepath.Base(name), ".log"), time.Local)
+		if err == nil && day.AddDate(0, 0, 1).Before(since) {
+			continue
+		}
+		data, err := readTail(name, maxSupportLogSize)
+		if err != nil {
+			log.Warningf("failed to read log file %s: %v", name, err)
+			continue
+		}
+		if err := b.AddFile("logs/"+filepath.Base(name), data); err != nil {
+			return err
+		}
+		entries, err := readLogEntries(name, since, log.FatalLevel, "")
+		if err != nil {
+			continue
+		}
+		crashes = append(crashes, entries...)
+	}
+	return b.AddJSON("crashes.json", crashes)
+}
+
+func readTail(name string, limit int64) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() > limit {
+		if _, err := f.Seek(info.Size()-limit, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return ioutil.ReadAll(io.LimitReader(f, limit))
+}
+
+// AddRuntime adds build info and stack traces of all goroutines.
+func (b *SupportBundle) AddRuntime(version string) error {
+	if err := b.AddJSON("runtime.json", map[string]interface{}{
+		"version":    version,
+		"go_version": runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+		"cpus":       runtime.NumCPU(),
+		"goroutines": runtime.NumGoroutine(),
+		"created_at": b.now,
+	}); err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	if err := pprof.Lookup("goroutine").WriteTo(buf, 2); err != nil {
+		return err
+	}
+	return b.AddFile("goroutines.txt", buf.Bytes())
+}
+
+func (b *SupportBundle) Close() error {
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	return b.gw.Close()
+}
+
+type statsSample struct {
+	Time  time.Time `json:"time"`
+	Stats *Stats    `json:"stats"`
+}
+
+// statsHistory keeps periodic snapshots of node stats, so bundles show the trend
+// that led to an issue, not only the current state.
+type statsHistory struct {
+	mux     *sync.RWMutex
+	samples []*statsSample
+}
+
+func newStatsHistory() *statsHistory {
+	return &statsHistory{
+		mux: new(sync.RWMutex),
+	}
+}
+
+func (h *statsHistory) Run(ctx APIContext, startedAt time.Time) {
+	t := time.NewTicker(statsHistoryInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			sample := &statsSample{
+				Time:  time.Now(),
+				Stats: collectStats(ctx, startedAt, false),
+			}
+			h.mux.Lock()
+			h.samples = append(h.samples, sample)
+			if len(h.samples) > statsHistorySize {
+				h.samples = h.samples[len(h.samples)-statsHistorySize:]
+			}
+			h.mux.Unlock()
+		}
+	}
+}
+
+func (h *statsHistory) Samples() []*statsSample {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	return append([]*statsSample(nil), h.samples...)
+}
+
+// SupportBundleHandler serves a support bundle with redacted config, recent logs and crashes,
+// status snapshots, stats history and peer lists. Query param logs sets the log window, e.g. 24h.
+func (p *PrivateServer) SupportBundleHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkSupportToken(ctx, c) {
+			c.String(403, "error: support token mismatch")
+			return
+		}
+		window := defaultSupportLogWindow
+		if v := c.Query("logs"); len(v) > 0 {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				c.String(400, "error: %v", err)
+				return
+			}
+			window = d
+		}
+		c.Header("Content-Type", "application/gzip")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"atlant-support-%s.tar.gz\"",
+			time.Now().UTC().Format("20060102-150405")))
+		c.Status(200)
+		if err := p.writeSupportBundle(ctx, c.Writer, window); err != nil {
+			log.Warningf("failed to write support bundle: %v", err)
+		}
+	}
+}
+
+// checkSupportToken tells whether the request comes from a local tool.
+func checkSupportToken(ctx APIContext, c *gin.Context) bool {
+	token := ctx.PrivateToken()
+	return token != nil && token.Valid(requestToken(c.Request))
+}
+
+func (p *PrivateServer) writeSupportBundle(ctx APIContext, w io.Writer, window time.Duration) error {
+	store := ctx.RecordStore()
+	b := NewSupportBundle(w)
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"config.json", ctx.SupportConfig()},
+		{"node.json", map[string]string{
+			"node_id":    ctx.NodeID(),
+			"session_id": ctx.SessionID(),
+			"env":        ctx.Env(),
+		}},
+		{"status/stats.json", collectStats(ctx, p.startedAt, true)},
+		{"status/stats_history.json", p.history.Samples()},
+		{"status/protocol.json", store.ProtocolStatus()},
+		{"status/clock.json", store.ClockStatus()},
+		{"status/auth.json", authcenter.Default.Status()},
+		{"status/retention.json", store.RetentionStatus()},
+		{"status/hooks.json", store.ListHooks()},
+		{"status/write_fence.json", errString(store.WriteFence())},
+		{"peers.json", map[string]interface{}{
+			"bitswap":      ctx.FileStore().BitswapStats().Peers,
+			"cluster":      store.ProtocolStatus().Peers,
+			"clock_offset": store.ClockStatus().PeerOffsets,
+		}},
+	}
+	for _, f := range files {
+		if err := b.AddJSON(f.name, f.v); err != nil {
+			return err
+		}
+	}
+	if dir := ctx.LogDir(); len(dir) > 0 {
+		if err := b.AddLogs(dir, window); err != nil {
+			return err
+		}
+	}
+	if err := b.AddRuntime(ctx.Version()); err != nil {
+		return err
+	}
+	return b.Close()
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// taskActionRotatePresign rotates the key of pre-signed URLs, revoking all URLs issued so far.
+const taskActionRotatePresign = "rotate-presign"
+
+type scheduledTaskRequest struct {
+	Schedule string            `json:"schedule"`
+	Action   string            `json:"action"`
+	Params   map[string]string `json:"params"`
+	Paused   bool              `json:"paused"`
+}
+
+// registerTaskActions adds actions of the API layer that scheduled tasks may run.
+func registerTaskActions(ctx APIContext) {
+	if signer := ctx.URLSigner(); signer != nil {
+		ctx.RecordStore().RegisterTaskAction(taskActionRotatePresign, func(_ context.Context, _ map[string]string) (string, error) {
+			if _, err := signer.key.Rotate(); err != nil {
+				return "", err
+			}
+			return "rotated the key of pre-signed URLs", nil
+		})
+	}
+}
+
+func (p *PrivateServer) TaskListHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"tasks":   ctx.RecordStore().ScheduledTasks(),
+			"actions": ctx.RecordStore().TaskActions(),
+		})
+	}
+}
+
+// TaskPutHandler creates or replaces the task named in the path.
+func (p *PrivateServer) TaskPutHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req *scheduledTaskRequest
+		if err := c.BindJSON(&req); err != nil {
+			return
+		}
+		task, err := ctx.RecordStore().PutScheduledTask(&rs.ScheduledTask{
+			Name:     c.Param("name"),
+			Schedule: req.Schedule,
+			Action:   req.Action,
+			Params:   req.Params,
+			Paused:   req.Paused,
+		})
+		if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		c.JSON(200, task)
+	}
+}
+
+func (p *PrivateServer) TaskDeleteHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := ctx.RecordStore().DeleteScheduledTask(c.Param("name")); err == rs.ErrTaskNotFound {
+			c.AbortWithStatus(404)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.Status(200)
+	}
+}
+
+// TaskRunHandler runs the task now and responds with the run once it's done.
+func (p *PrivateServer) TaskRunHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		run, err := ctx.RecordStore().RunScheduledTask(ctx.WithRequest(c), c.Param("name"))
+		switch err {
+		case nil:
+			c.JSON(200, run)
+		case rs.ErrTaskNotFound:
+			c.AbortWithStatus(404)
+		case rs.ErrTaskRunning:
+			c.String(409, "error: %v", err)
+		default:
+			c.String(500, "error: %v", err)
+		}
+	}
+}
+
+// TaskRunsHandler lists the run history of the task, of all tasks at /private/v1/task-runs,
+// ?limit= caps the number of runs (100 by default).
+func (p *PrivateServer) TaskRunsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 100
+		if v := c.Query("limit"); len(v) > 0 {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				c.String(400, "error: malformed limit")
+				return
+			}
+			limit = n
+		}
+		runs, err := ctx.RecordStore().TaskRuns(ctx.WithRequest(c), c.Param("name"), limit)
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, runs)
+	}
+}
@@ -0,0 +1,370 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Throttles protect the public server from clients that hammer it. Unlike rate limits of
+// namespaces, they are local to the node and apply to all routes: each client address gets
+// a token bucket of requests and a cap of requests in flight, request bodies are capped,
+// and writes are counted against a quota of the Ethereum address the client writes for.
+// Requests and subscriptions in flight are capped per token as well, i.e. the bearer token
+// or the Ethereum address of the request, so that a client spread over many addresses can't
+// hold all connections of the server. Subscriptions are event streams and WebSockets, they
+// stay open for long and are capped apart from plain requests.
+
+const (
+	// EthAddressHeader names the Ethereum address a client writes on behalf of.
+	EthAddressHeader = "X-Eth-Address"
+
+	throttleSweepInterval = time.Minute
+
+	// header reads of the public server are bounded, so that slow clients can't keep
+	// connections that never reach the throttles
+	publicHeaderTimeout = 10 * time.Second
+)
+
+// Reasons of refused requests, reported as labels of the throttled metric.
+const (
+	ThrottleRate               = "rate"
+	ThrottleStreams            = "streams"
+	ThrottleTokenStreams       = "token_streams"
+	ThrottleSubscriptions      = "subscriptions"
+	ThrottleTokenSubscriptions = "token_subscriptions"
+	ThrottleQuota              = "quota"
+)
+
+var throttleMessages = map[string]string{
+	ThrottleRate:               "request rate limit exceeded",
+	ThrottleStreams:            "too many concurrent requests",
+	ThrottleTokenStreams:       "too many concurrent requests of the token",
+	ThrottleSubscriptions:      "too many open subscriptions",
+	ThrottleTokenSubscriptions: "too many open subscriptions of the token",
+}
+
+var ethAddressRx = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+
+// ThrottleConfig sets throttles of the public server, zero values disable them.
+type ThrottleConfig struct {
+	// Rate is requests per second of a client address, Burst is the bucket size.
+	Rate  float64
+	Burst int
+	// MaxStreams is the number of requests of a client address in flight.
+	MaxStreams int
+	// MaxTokenStreams is the number of requests of a token in flight.
+	MaxTokenStreams int
+	// MaxSubscriptions and MaxTokenSubscriptions are the numbers of subscriptions
+	// a client address and a token may keep open, they count as streams as well.
+	MaxSubscriptions      int
+	MaxTokenSubscriptions int
+	// MaxBody is the max size of request bodies in bytes.
+	MaxBody int64
+	// WriteQuota is the number of writes an Ethereum address may make per QuotaWindow.
+	WriteQuota  int
+	QuotaWindow time.Duration
+}
+
+// CapsConns tells whether requests in flight are capped in any way.
+func (cfg *ThrottleConfig) CapsConns() bool {
+	return cfg.MaxStreams > 0 || cfg.MaxTokenStreams > 0 ||
+		cfg.MaxSubscriptions > 0 || cfg.MaxTokenSubscriptions > 0
+}
+
+// ThrottleStats reports requests in flight and requests refused by throttles.
+type ThrottleStats struct {
+	InFlight      int               `json:"in_flight"`
+	Subscriptions int               `json:"subscriptions"`
+	Refused       map[string]uint64 `json:"refused"`
+}
+
+type tokenBucket struct {
+	tokens        float64
+	updated       time.Time
+	inFlight      int
+	subscriptions int
+}
+
+type connCount struct {
+	inFlight      int
+	subscriptions int
+}
+
+type quotaWindow struct {
+	start time.Time
+	used  int
+}
+
+type throttles struct {
+	cfg *ThrottleConfig
+
+	mux     *sync.Mutex
+	buckets map[string]*tokenBucket
+	tokens  map[string]*connCount
+	quotas  map[string]*quotaWindow
+	swept   time.Time
+	stats   ThrottleStats
+}
+
+func newThrottles(cfg *ThrottleConfig) *throttles {
+	if cfg.Rate > 0 && cfg.Burst <= 0 {
+		cfg.Burst = int(2*cfg.Rate) + 1
+	}
+	return &throttles{
+		cfg:     cfg,
+		mux:     new(sync.Mutex),
+		buckets: make(map[string]*tokenBucket),
+		tokens:  make(map[string]*connCount),
+		quotas:  make(map[string]*quotaWindow),
+		swept:   time.Now(),
+		stats: ThrottleStats{
+			Refused: make(map[string]uint64),
+		},
+	}
+}
+
+// sweep drops idle buckets and ended quota windows, must be called with the lock held.
+func (t *throttles) sweep(now time.Time) {
+	if now.Sub(t.swept) < throttleSweepInterval {
+		return
+	}
+	t.swept = now
+	for client, b := range t.buckets {
+		if b.inFlight == 0 && now.Sub(b.updated) > throttleSweepInterval {
+			delete(t.buckets, client)
+		}
+	}
+	for addr, q := range t.quotas {
+		if now.Sub(q.start) >= t.cfg.QuotaWindow {
+			delete(t.quotas, addr)
+		}
+	}
+}
+
+// acquire takes a token and a stream slot of the client, and of its token unless it's empty,
+// sub tells whether the request is a subscription. It returns the tokens left, how long to
+// wait and the reason if the request is refused.
+func (t *throttles) acquire(client, token string, sub bool, now time.Time) (ok bool, left int, wait time.Duration, reason string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.sweep(now)
+	b, found := t.buckets[client]
+	if !found {
+		b = &tokenBucket{
+			tokens:  float64(t.cfg.Burst),
+			updated: now,
+		}
+		t.buckets[client] = b
+	}
+	if t.cfg.Rate > 0 {
+		b.tokens += now.Sub(b.updated).Seconds() * t.cfg.Rate
+		if max := float64(t.cfg.Burst); b.tokens > max {
+			b.tokens = max
+		}
+		b.updated = now
+		if b.tokens < 1 {
+			wait = time.Duration((1 - b.tokens) / t.cfg.Rate * float64(time.Second))
+			return t.refuse(ThrottleRate, 0, wait)
+		}
+	}
+	tc := t.tokens[token]
+	if tc == nil {
+		tc = new(connCount)
+	}
+	switch {
+	case t.cfg.MaxStreams > 0 && b.inFlight >= t.cfg.MaxStreams:
+		return t.refuse(ThrottleStreams, int(b.tokens), time.Second)
+	case sub && t.cfg.MaxSubscriptions > 0 && b.subscriptions >= t.cfg.MaxSubscriptions:
+		return t.refuse(ThrottleSubscriptions, int(b.tokens), time.Second)
+	case len(token) == 0:
+	case t.cfg.MaxTokenStreams > 0 && tc.inFlight >= t.cfg.MaxTokenStreams:
+		return t.refuse(ThrottleTokenStreams, int(b.tokens), time.Second)
+	case sub && t.cfg.MaxTokenSubscriptions > 0 && tc.subscriptions >= t.cfg.MaxTokenSubscriptions:
+		return t.refuse(ThrottleTokenSubscriptions, int(b.tokens), time.Second)
+	}
+	if t.cfg.Rate > 0 {
+		b.tokens--
+	}
+	b.updated = now
+	b.inFlight++
+	t.stats.InFlight++
+	if len(token) > 0 {
+		tc.inFlight++
+		t.tokens[token] = tc
+	}
+	if sub {
+		b.subscriptions++
+		tc.subscriptions++
+		t.stats.Subscriptions++
+	}
+	return true, int(b.tokens), 0, ""
+}
+
+func (t *throttles) countRefused(reason string) {
+	t.mux.Lock()
+	t.stats.Refused[reason]++
+	t.mux.Unlock()
+}
+
+// refuse counts a refused request, must be called with the lock held.
+func (t *throttles) refuse(reason string, left int, wait time.Duration) (bool, int, time.Duration, string) {
+	t.stats.Refused[reason]++
+	return false, left, wait, reason
+}
+
+func (t *throttles) release(client, token string, sub bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if b, ok := t.buckets[client]; ok && b.inFlight > 0 {
+		b.inFlight--
+		if sub && b.subscriptions > 0 {
+			b.subscriptions--
+		}
+	}
+	if tc, ok := t.tokens[token]; ok {
+		tc.inFlight--
+		if sub {
+			tc.subscriptions--
+		}
+		if tc.inFlight <= 0 {
+			delete(t.tokens, token)
+		}
+	}
+	t.stats.InFlight--
+	if sub {
+		t.stats.Subscriptions--
+	}
+}
+
+// Stats returns a snapshot of requests in flight and refused so far.
+func (t *throttles) Stats() *ThrottleStats {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	stats := &ThrottleStats{
+		InFlight:      t.stats.InFlight,
+		Subscriptions: t.stats.Subscriptions,
+		Refused:       make(map[string]uint64, len(t.stats.Refused)),
+	}
+	for reason, n := range t.stats.Refused {
+		stats.Refused[reason] = n
+	}
+	return stats
+}
+
+// takeQuota counts a write of the address, windows are aligned to the wall clock.
+func (t *throttles) takeQuota(addr string, now time.Time) (ok bool, left int, reset time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	start := now.Truncate(t.cfg.QuotaWindow)
+	q, found := t.quotas[addr]
+	if !found || !q.start.Equal(start) {
+		q = &quotaWindow{
+			start: start,
+		}
+		t.quotas[addr] = q
+	}
+	reset = start.Add(t.cfg.QuotaWindow)
+	if q.used >= t.cfg.WriteQuota {
+		return false, 0, reset
+	}
+	q.used++
+	return true, t.cfg.WriteQuota - q.used, reset
+}
+
+// isSubscription tells whether the request opens an event stream or a WebSocket.
+func isSubscription(r *http.Request) bool {
+	return r.URL.Path == "/api/v1/subscribe" ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream") ||
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// throttleToken returns the token the request is counted against: a digest of the bearer
+// token, or the Ethereum address the client writes for. It's empty if the request has neither.
+func throttleToken(r *http.Request) string {
+	if v := requestToken(r); len(v) > 0 {
+		sum := sha256.Sum256([]byte(v))
+		return "bearer:" + hex.EncodeToString(sum[:8])
+	}
+	if v := r.Header.Get(EthAddressHeader); ethAddressRx.MatchString(v) {
+		return strings.ToLower(v)
+	}
+	return ""
+}
+
+func isWriteRoute(path string) bool {
+	return strings.HasPrefix(path, "/api/v1/put/") ||
+		strings.HasPrefix(path, "/api/v1/delete/") ||
+		strings.HasPrefix(path, "/api/v1/upload")
+}
+
+// requestThrottles applies throttles of the context to public API requests.
+func requestThrottles(ctx APIContext) gin.HandlerFunc {
+	cfg := ctx.Throttles()
+	if cfg == nil {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	t := ctx.throttleState()
+	header := ctx.ClientHeader()
+	return func(c *gin.Context) {
+		now := time.Now()
+		if cfg.MaxBody > 0 {
+			if c.Request.ContentLength > cfg.MaxBody {
+				c.String(http.StatusRequestEntityTooLarge, "error: request body is larger than %d bytes", cfg.MaxBody)
+				c.Abort()
+				return
+			}
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, cfg.MaxBody)
+		}
+		client := clientID(c, header)
+		if cfg.Rate > 0 || cfg.CapsConns() {
+			token := throttleToken(c.Request)
+			sub := isSubscription(c.Request)
+			ok, left, wait, reason := t.acquire(client, token, sub, now)
+			if cfg.Rate > 0 {
+				c.Header("X-RateLimit-Limit", strconv.FormatFloat(cfg.Rate, 'f', -1, 64))
+				c.Header("X-RateLimit-Remaining", strconv.Itoa(left))
+			}
+			if !ok {
+				c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+				c.String(429, "error: %s", throttleMessages[reason])
+				c.Abort()
+				return
+			}
+			defer t.release(client, token, sub)
+		}
+		if cfg.WriteQuota > 0 && cfg.QuotaWindow > 0 && isWriteRoute(c.Request.URL.Path) {
+			addr := client
+			if v := c.Request.Header.Get(EthAddressHeader); len(v) > 0 {
+				if !ethAddressRx.MatchString(v) {
+					c.String(400, "error: invalid %s: %s", EthAddressHeader, v)
+					c.Abort()
+					return
+				}
+				addr = strings.ToLower(v)
+			}
+			ok, left, reset := t.takeQuota(addr, now)
+			c.Header("X-Quota-Limit", fmt.Sprintf("%d;w=%d", cfg.WriteQuota, int(cfg.QuotaWindow.Seconds())))
+			c.Header("X-Quota-Remaining", strconv.Itoa(left))
+			c.Header("X-Quota-Reset", strconv.FormatInt(reset.Unix(), 10))
+			if !ok {
+				t.countRefused(ThrottleQuota)
+				c.Header("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+				c.String(429, "error: write quota of %s exceeded", addr)
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RouteTimeouts limits how long requests may run, by the longest matching path prefix.
+// Requests of routes without a timeout are still cancelled when the client goes away.
+type RouteTimeouts struct {
+	Default time.Duration
+	Routes  map[string]time.Duration
+}
+
+// ParseRouteTimeouts parses a comma-separated list of prefix=duration pairs,
+// e.g. "/api/v1/content=30m,/private/v1/export=0". Zero disables the timeout.
+func ParseRouteTimeouts(s string) (map[string]time.Duration, error) {
+	routes := make(map[string]time.Duration)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") {
+			return nil, fmt.Errorf("bad route timeout: %s", pair)
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad route timeout: %s: %v", pair, err)
+		}
+		routes[parts[0]] = d
+	}
+	return routes, nil
+}
+
+func (t *RouteTimeouts) For(path string) time.Duration {
+	timeout := t.Default
+	var matched string
+	for prefix, d := range t.Routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(matched) {
+			matched = prefix
+			timeout = d
+		}
+	}
+	return timeout
+}
+
+// requestTimeouts binds the request context to the route timeout.
+func requestTimeouts(ctx APIContext) gin.HandlerFunc {
+	timeouts := ctx.RouteTimeouts()
+	return func(c *gin.Context) {
+		if timeouts == nil {
+			c.Next()
+			return
+		}
+		timeout := timeouts.For(c.Request.URL.Path)
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+		reqCtx, cancelFn := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancelFn()
+		c.Request = c.Request.WithContext(reqCtx)
+		c.Next()
+	}
+}
+
+// requestContext carries cancellation of a request and values of the API context.
+type requestContext struct {
+	context.Context
+
+	values context.Context
+}
+
+func (r requestContext) Value(key interface{}) interface{} {
+	if v := r.Context.Value(key); v != nil {
+		return v
+	}
+	return r.values.Value(key)
+}
+
+// WithRequest returns a copy of the context that is cancelled when the request is
+// finished, the client goes away or the route timeout expires.
+func (c APIContext) WithRequest(gc *gin.Context) APIContext {
+	return APIContext{requestContext{
+		Context: gc.Request.Context(),
+		values:  c.Context,
+	}}
+}
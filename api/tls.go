@@ -0,0 +1,207 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+)
+
+// ClientAuthMode defines whether clients of the public API must present certificates.
+type ClientAuthMode string
+
+const (
+	ClientAuthNone     ClientAuthMode = "none"
+	ClientAuthOptional ClientAuthMode = "optional"
+	ClientAuthRequire  ClientAuthMode = "require"
+	// ClientAuthAuthority requires certificates of nodes listed in the authority,
+	// the node ID is taken from the common name of the certificate.
+	ClientAuthAuthority ClientAuthMode = "authority"
+)
+
+// TLSConfig configures TLS of the public API. Either a certificate and key files,
+// or ACME domains to obtain certificates from Let's Encrypt must be set.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+
+	ACMEDomains  []string
+	ACMEEmail    string
+	ACMECacheDir string
+	// ACMEHTTPAddr serves HTTP-01 challenges and redirects to HTTPS, e.g. ":80".
+	ACMEHTTPAddr string
+
+	ClientAuth ClientAuthMode
+	// ClientCAFile lists CAs that sign client certificates.
+	ClientCAFile string
+}
+
+var errNoCertificate = errors.New("neither certificate files nor ACME domains are set")
+
+// certReloader serves the certificate from files and reloads it once the files
+// are changed, so renewed certificates are picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mux       *sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+const certCheckInterval = time.Minute
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		mux:      new(sync.RWMutex),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) load() error {
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		err = fmt.Errorf("failed to load TLS certificate: %v", err)
+		return err
+	}
+	c.mux.Lock()
+	c.cert = &cert
+	c.modTime = info.ModTime()
+	c.mux.Unlock()
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mux.Lock()
+	recheck := time.Since(c.checkedAt) > certCheckInterval
+	if recheck {
+		c.checkedAt = time.Now()
+	}
+	c.mux.Unlock()
+	if recheck {
+		if info, err := os.Stat(c.certFile); err == nil && info.ModTime().After(c.modTime) {
+			if err := c.load(); err != nil {
+				log.Warningf("keeping the old TLS certificate: %v", err)
+			} else {
+				log.Infoln("reloaded TLS certificate from", c.certFile)
+			}
+		}
+	}
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.cert, nil
+}
+
+func (t *TLSConfig) build() (*tls.Config, error) {
+	var cfg *tls.Config
+	switch {
+	case len(t.ACMEDomains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(t.ACMEDomains...),
+			Cache:      autocert.DirCache(t.ACMECacheDir),
+			Email:      t.ACMEEmail,
+		}
+		cfg = m.TLSConfig()
+		if len(t.ACMEHTTPAddr) > 0 {
+			go func() {
+				if err := http.ListenAndServe(t.ACMEHTTPAddr, m.HTTPHandler(nil)); err != nil {
+					log.Warningf("failed to serve ACME challenges at %s: %v", t.ACMEHTTPAddr, err)
+				}
+			}()
+		}
+	case len(t.CertFile) > 0:
+		certs, err := newCertReloader(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg = &tls.Config{
+			GetCertificate: certs.GetCertificate,
+		}
+	default:
+		return nil, errNoCertificate
+	}
+	cfg.MinVersion = tls.VersionTLS12
+
+	switch t.ClientAuth {
+	case ClientAuthNone, "":
+		return cfg, nil
+	case ClientAuthOptional:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case ClientAuthAuthority:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.VerifyPeerCertificate = verifyAuthorityNode
+	default:
+		return nil, fmt.Errorf("unknown client auth mode: %s", t.ClientAuth)
+	}
+	if len(t.ClientCAFile) == 0 {
+		return nil, errors.New("client auth requires a CA of client certificates")
+	}
+	data, err := ioutil.ReadFile(t.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", t.ClientCAFile)
+	}
+	cfg.ClientCAs = pool
+	return cfg, nil
+}
+
+// verifyAuthorityNode accepts client certificates of nodes listed in the authority,
+// it's called after the chain has been verified against the client CAs.
+func verifyAuthorityNode(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return errors.New("no verified client certificate")
+	}
+	nodeID := chains[0][0].Subject.CommonName
+	if _, ok := authcenter.Default.Entries()[nodeID]; !ok {
+		return fmt.Errorf("client node %s is not in the authority", nodeID)
+	}
+	return nil
+}
+
+// ClientNodeID returns the node ID of a client verified by its certificate.
+func ClientNodeID(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// ListenAndServeTLS serves the public API over TLS at all of the addresses, see TLSConfig.
+func (p *PublicServer) ListenAndServeTLS(addrs []string, cfg *TLSConfig) error {
+	tlsConfig, err := cfg.build()
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           p.handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: publicHeaderTimeout,
+	}
+	return p.serve(srv, addrs)
+}
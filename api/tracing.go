@@ -0,0 +1,63 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/AtlantPlatform/atlant-go/tracing"
+)
+
+// traceIDHeader carries the ID of the trace of a request back, so that clients can report it.
+const traceIDHeader = "X-Trace-ID"
+
+// traceRequests starts a span of each request, continuing the trace of the traceparent
+// header if the client sent one. Requests that fail with 5xx are logged with the trace ID.
+func traceRequests(server string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := tracing.ExtractHTTP(c.Request.Context(), c.Request.Header)
+		reqCtx, span := tracing.Start(reqCtx, server+" "+handlerSpanName(c),
+			attribute.String("http.method", c.Request.Method),
+			attribute.String("http.target", c.Request.URL.Path),
+			attribute.String("http.client_ip", c.ClientIP()),
+		)
+		c.Request = c.Request.WithContext(reqCtx)
+		if traceID := tracing.TraceID(reqCtx); len(traceID) > 0 {
+			c.Header(traceIDHeader, traceID)
+		}
+		c.Next()
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.status_code", status))
+		var err error
+		if status >= 500 {
+			err = fmt.Errorf("request failed with %d", status)
+			if len(c.Errors) > 0 {
+				err = c.Errors.Last()
+			}
+			tracing.Log(reqCtx).WithFields(log.Fields{
+				"method": c.Request.Method,
+				"path":   c.Request.URL.Path,
+				"status": status,
+			}).Warningf("request failed: %v", err)
+		}
+		tracing.End(span, err)
+	}
+}
+
+var handlerFuncSuffix = regexp.MustCompile(`\.func\d+$`)
+
+// handlerSpanName names the span after the handler of the route, e.g. PublicServer.ContentHandler,
+// paths have IDs and record paths in them and would make a name per request.
+func handlerSpanName(c *gin.Context) string {
+	name := c.HandlerName()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = handlerFuncSuffix.ReplaceAllString(name, "")
+	name = strings.NewReplacer("(*", "", ")", "").Replace(name)
+	return strings.TrimPrefix(name, "api.")
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+type transferOfferRequest struct {
+	// Scope is a namespace, a record path or a folder ending with a slash.
+	Scope string `json:"scope"`
+	To    string `json:"to"`
+}
+
+// TransferListHandler lists transfers of ownership and owners of transferred scopes.
+func (p *PrivateServer) TransferListHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := ctx.RecordStore()
+		c.JSON(200, gin.H{
+			"transfers": store.Transfers(),
+			"owners":    store.TransferOwners(),
+		})
+	}
+}
+
+// TransferOfferHandler offers ownership of a scope of this node to another node.
+func (p *PrivateServer) TransferOfferHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req *transferOfferRequest
+		if err := c.BindJSON(&req); err != nil {
+			return
+		}
+		t, err := ctx.RecordStore().OfferTransfer(ctx.WithRequest(c), req.Scope, req.To)
+		if err == rs.ErrTransferNotOwner {
+			c.String(403, "error: %v", err)
+			return
+		} else if serveWriteError(c, err) {
+			return
+		} else if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		c.JSON(200, t)
+	}
+}
+
+func serveTransferError(c *gin.Context, err error) {
+	switch err {
+	case rs.ErrTransferNotFound:
+		c.AbortWithStatus(404)
+	case rs.ErrTransferNotOwner, rs.ErrTransferNotRecipient:
+		c.String(403, "error: %v", err)
+	case rs.ErrTransferNotPending:
+		c.String(409, "error: %v", err)
+	default:
+		if !serveWriteError(c, err) {
+			c.String(500, "error: %v", err)
+		}
+	}
+}
+
+// TransferAcceptHandler accepts a transfer offered to this node.
+func (p *PrivateServer) TransferAcceptHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t, err := ctx.RecordStore().AcceptTransfer(ctx.WithRequest(c), c.Param("id"))
+		if err != nil {
+			serveTransferError(c, err)
+			return
+		}
+		c.JSON(200, t)
+	}
+}
+
+// TransferWithdrawHandler withdraws a pending transfer offered by this node.
+func (p *PrivateServer) TransferWithdrawHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := ctx.RecordStore().WithdrawTransfer(ctx.WithRequest(c), c.Param("id")); err != nil {
+			serveTransferError(c, err)
+			return
+		}
+		c.Status(200)
+	}
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// Uploads accept large documents that don't fit a single put request. A multipart upload
+// streams the file part of a form into a record. A resumable upload is staged in a file of
+// the upload dir: the client starts a session with the total length, sends chunks with
+// Content-Range and asks for the offset to resume after a failure. The record is written
+// once the last chunk arrives. Sessions live in memory, so they don't survive restarts.
+
+const (
+	uploadSessionTTL   = 24 * time.Hour
+	maxUploadMetaSize  = 64 * 1024
+	maxUploadSessions  = 1000
+	uploadLengthHeader = "X-Upload-Length"
+)
+
+var (
+	ErrUploadNotFound = errors.New("upload session not found")
+	ErrUploadOffset   = errors.New("chunk does not start at the upload offset")
+	ErrUploadLimit    = errors.New("too many upload sessions")
+)
+
+// UploadSession is the state of a resumable upload reported to clients.
+type UploadSession struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Length    int64     `json:"length"`
+	Offset    int64     `json:"offset"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	userMeta []byte
+	file     string
+	mux      *sync.Mutex
+}
+
+type uploadSessions struct {
+	dir string
+
+	mux      *sync.Mutex
+	sessions map[string]*UploadSession
+}
+
+func newUploadSessions(dir string) *uploadSessions {
+	return &uploadSessions{
+		dir:      dir,
+		mux:      new(sync.Mutex),
+		sessions: make(map[string]*UploadSession),
+	}
+}
+
+func (u *uploadSessions) start(path string, length int64, userMeta []byte) (*UploadSession, error) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	u.expire(time.Now())
+	if len(u.sessions) >= maxUploadSessions {
+		return nil, ErrUploadLimit
+	}
+	if err := os.MkdirAll(u.dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &UploadSession{
+		ID:        proto.NewID(),
+		Path:      path,
+		Length:    length,
+		ExpiresAt: time.Now().Add(uploadSessionTTL),
+		userMeta:  userMeta,
+		mux:       new(sync.Mutex),
+	}
+	s.file = filepath.Join(u.dir, s.ID)
+	if err := ioutil.WriteFile(s.file, nil, 0600); err != nil {
+		return nil, err
+	}
+	u.sessions[s.ID] = s
+	return s, nil
+}
+
+// expire drops sessions that were idle for too long, must be called with the lock held.
+func (u *uploadSessions) expire(now time.Time) {
+	for id, s := range u.sessions {
+		if now.After(s.ExpiresAt) {
+			os.Remove(s.file)
+			delete(u.sessions, id)
+		}
+	}
+}
+
+func (u *uploadSessions) get(id string) (*UploadSession, bool) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	s, ok := u.sessions[id]
+	if !ok || time.Now().After(s.ExpiresAt) {
+		return nil, false
+	}
+	return s, true
+}
+
+func (u *uploadSessions) remove(id string) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if s, ok := u.sessions[id]; ok {
+		os.Remove(s.file)
+		delete(u.sessions, id)
+	}
+}
+
+// snapshot returns a copy of the session state, must be called with the session lock held.
+func (s *UploadSession) snapshot() *UploadSession {
+	return &UploadSession{
+		ID:        s.ID,
+		Path:      s.Path,
+		Length:    s.Length,
+		Offset:    s.Offset,
+		ExpiresAt: s.ExpiresAt,
+	}
+}
+
+// appendChunk writes the chunk at the session offset, the chunk must start there.
+// It returns the number of bytes written, a partial chunk is kept.
+func (s *UploadSession) appendChunk(start int64, body io.Reader) (int64, error) {
+	if start != s.Offset {
+		return 0, ErrUploadOffset
+	}
+	f, err := os.OpenFile(s.file, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, io.LimitReader(body, s.Length-s.Offset))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	s.Offset += n
+	s.ExpiresAt = time.Now().Add(uploadSessionTTL)
+	return n, err
+}
+
+// parseContentRange parses "bytes start-end/total", end is inclusive.
+func parseContentRange(v string) (start, end, total int64, err error) {
+	if !strings.HasPrefix(v, "bytes ") {
+		return 0, 0, 0, fmt.Errorf("unsupported Content-Range: %s", v)
+	}
+	v = strings.TrimPrefix(v, "bytes ")
+	parts := strings.SplitN(v, "/", 2)
+	bounds := strings.SplitN(parts[0], "-", 2)
+	if len(parts) != 2 || len(bounds) != 2 {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %s", v)
+	}
+	if start, err = strconv.ParseInt(bounds[0], 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %s", v)
+	} else if end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil || end < start {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %s", v)
+	}
+	if parts[1] == "*" {
+		return start, end, -1, nil
+	} else if total, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %s", v)
+	}
+	return start, end, total, nil
+}
+
+// UploadHandler writes a record from a multipart form, or starts a resumable upload
+// if the X-Upload-Length header is set.
+func (p *PublicServer) UploadHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		path := c.Param("path")
+		if len(path) == 0 || path == "/" || len(filepath.Base(path)) == 0 {
+			c.AbortWithStatus(400)
+			return
+		}
+		if v := c.Request.Header.Get(uploadLengthHeader); len(v) > 0 {
+			length, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || length <= 0 {
+				c.String(400, "error: invalid %s: %s", uploadLengthHeader, v)
+				return
+			}
+			userMeta := c.Request.Header.Get("X-Meta-UserMeta")
+			if len(userMeta) > 0 && !json.Valid([]byte(userMeta)) {
+				c.String(400, "error: user meta json is not valid: %s", userMeta)
+				return
+			}
+			s, err := p.uploads.start(path, length, []byte(userMeta))
+			if err == ErrUploadLimit {
+				c.String(503, "error: %v", err)
+				return
+			} else if err != nil {
+				c.String(500, "error: %v", err)
+				return
+			}
+			c.Header("Location", "/api/v1/uploads/"+s.ID)
+			c.JSON(201, s.snapshot())
+			return
+		}
+		mr, err := c.Request.MultipartReader()
+		if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		var userMeta []byte
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				c.String(400, "error: no file field in the form")
+				return
+			} else if err != nil {
+				c.String(400, "error: %v", err)
+				return
+			}
+			switch part.FormName() {
+			case "meta":
+				// meta must precede the file, which is streamed as it arrives
+				userMeta, err = ioutil.ReadAll(io.LimitReader(part, maxUploadMetaSize))
+				part.Close()
+				if err != nil {
+					c.String(400, "error: %v", err)
+					return
+				} else if !json.Valid(userMeta) {
+					c.String(400, "error: user meta json is not valid: %s", userMeta)
+					return
+				}
+			case "file":
+				r, err := writeRecord(reqCtx, ctx.RecordStore(), path, part, 0, userMeta, time.Time{})
+				part.Close()
+				if serveWriteError(c, err) {
+					return
+				} else if err != nil {
+					c.String(500, "error: %v", err)
+					return
+				}
+				c.JSON(200, r.Object.Meta())
+				return
+			default:
+				part.Close()
+			}
+		}
+	}
+}
+
+// UploadChunkHandler appends a chunk to a resumable upload, the chunk range is given by
+// Content-Range. Once the upload is complete the record is written and its meta returned.
+func (p *PublicServer) UploadChunkHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqCtx := ctx.WithRequest(c)
+		s, ok := p.uploads.get(c.Param("id"))
+		if !ok {
+			c.String(404, "error: %v", ErrUploadNotFound)
+			return
+		}
+		start, end, total, err := parseContentRange(c.Request.Header.Get("Content-Range"))
+		if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		} else if total >= 0 && total != s.Length {
+			c.String(400, "error: upload length is %d, not %d", s.Length, total)
+			return
+		} else if end >= s.Length {
+			c.String(416, "error: chunk ends past the upload length %d", s.Length)
+			return
+		}
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		if _, err := s.appendChunk(start, io.LimitReader(c.Request.Body, end-start+1)); err == ErrUploadOffset {
+			c.Header("Range", fmt.Sprintf("bytes=0-%d", s.Offset-1))
+			c.String(409, "error: %v, resume at %d", err, s.Offset)
+			return
+		} else if err != nil {
+			log.WithField("upload", s.ID).Warningf("failed to stage upload chunk: %v", err)
+		}
+		if s.Offset < s.Length {
+			if s.Offset > 0 {
+				c.Header("Range", fmt.Sprintf("bytes=0-%d", s.Offset-1))
+			}
+			c.JSON(202, s.snapshot())
+			return
+		}
+		r, err := commitUpload(reqCtx, ctx.RecordStore(), s)
+		if serveWriteError(c, err) {
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		p.uploads.remove(s.ID)
+		c.JSON(200, r.Object.Meta())
+	}
+}
+
+func commitUpload(ctx context.Context, store rs.PlanetaryRecordStore, s *UploadSession) (*rs.Record, error) {
+	f, err := os.Open(s.file)
+	if err != nil {
+		return nil, err
+	}
+	return writeRecord(ctx, store, s.Path, f, s.Length, s.userMeta, time.Time{})
+}
+
+// UploadStatusHandler reports the offset to resume a resumable upload at.
+func (p *PublicServer) UploadStatusHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s, ok := p.uploads.get(c.Param("id"))
+		if !ok {
+			c.String(404, "error: %v", ErrUploadNotFound)
+			return
+		}
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		if s.Offset > 0 {
+			c.Header("Range", fmt.Sprintf("bytes=0-%d", s.Offset-1))
+		}
+		c.JSON(200, s.snapshot())
+	}
+}
+
+func (p *PublicServer) UploadCancelHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := p.uploads.get(c.Param("id")); !ok {
+			c.String(404, "error: %v", ErrUploadNotFound)
+			return
+		}
+		p.uploads.remove(c.Param("id"))
+		c.Status(200)
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Routes of the public API carry their version in the path, e.g. /api/v1/content, so that
+// endpoints may change in a new version while clients of the old one keep working. Clients
+// that don't pin a version call /api/<route> and negotiate it: the API-Version header, e.g.
+// "1" or "v1", or a vendor media type in Accept, application/vnd.atlant.v1+json, selects
+// the version, the current one is served otherwise. Unknown versions are refused with 406.
+// Responses of versioned routes carry the version served in API-Version, and deprecated
+// versions add Deprecation and Sunset headers, so clients learn about them before they go.
+
+// APIVersionHeader selects the version of unversioned routes, responses carry the version served.
+const APIVersionHeader = "API-Version"
+
+// APIVersion is a version of the public API.
+type APIVersion struct {
+	Name       string     `json:"name"`
+	Current    bool       `json:"current,omitempty"`
+	Deprecated bool       `json:"deprecated,omitempty"`
+	Sunset     *time.Time `json:"sunset,omitempty"`
+}
+
+// apiVersions are served versions, the current one is served to clients that don't ask for one.
+// The JSON gateway of the gRPC API is served at /api/v2 by the gRPC listener, see grpc.go.
+var apiVersions = []*APIVersion{
+	{Name: "v1", Current: true},
+}
+
+var (
+	versionSegmentRx = regexp.MustCompile(`^v[0-9]+$`)
+	vendorMediaRx    = regexp.MustCompile(`^application/vnd\.atlant\.(v[0-9]+)(\+[a-z]+)?$`)
+)
+
+func findAPIVersion(name string) *APIVersion {
+	for _, v := range apiVersions {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+func currentAPIVersion() *APIVersion {
+	for _, v := range apiVersions {
+		if v.Current {
+			return v
+		}
+	}
+	return apiVersions[len(apiVersions)-1]
+}
+
+// requestedAPIVersion returns the version the client asked for, empty if it didn't.
+func requestedAPIVersion(r *http.Request) string {
+	if v := strings.TrimSpace(r.Header.Get(APIVersionHeader)); len(v) > 0 {
+		if !strings.HasPrefix(v, "v") {
+			v = "v" + v
+		}
+		return strings.ToLower(v)
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if m := vendorMediaRx.FindStringSubmatch(mediaType); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// negotiateVersion routes unversioned requests of the public API to the negotiated version
+// and marks responses of versioned routes with their version. Paths forwarded to legacy
+// services are left as they are.
+func negotiateVersion(next http.Handler, proxied ProxyRoutes) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/api/versions" {
+			next.ServeHTTP(w, r)
+			return
+		} else if _, target := proxied.Match(r.URL.Path); target != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := strings.TrimPrefix(r.URL.Path, "/api/")
+		segment := route
+		if idx := strings.IndexByte(route, '/'); idx >= 0 {
+			segment = route[:idx]
+		}
+		if versionSegmentRx.MatchString(segment) {
+			if v := findAPIVersion(segment); v != nil {
+				setVersionHeaders(w.Header(), v)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		v := currentAPIVersion()
+		if name := requestedAPIVersion(r); len(name) > 0 {
+			if v = findAPIVersion(name); v == nil {
+				http.Error(w, "error: API version "+name+" is not supported, see /api/versions", 406)
+				return
+			}
+		}
+		setVersionHeaders(w.Header(), v)
+		r.URL.Path = "/api/" + v.Name + "/" + route
+		if len(r.URL.RawPath) > 0 {
+			r.URL.RawPath = "/api/" + v.Name + "/" + strings.TrimPrefix(r.URL.RawPath, "/api/")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setVersionHeaders(h http.Header, v *APIVersion) {
+	h.Set(APIVersionHeader, v.Name)
+	if v.Deprecated {
+		h.Set("Deprecation", "true")
+		if v.Sunset != nil {
+			h.Set("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+		}
+	}
+}
+
+// VersionsHandler lists versions of the public API.
+func (p *PublicServer) VersionsHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"current":  currentAPIVersion().Name,
+			"versions": apiVersions,
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/fs"
+)
+
+// VouchesHandler lists nodes on probation with their vouchers.
+func (p *PrivateServer) VouchesHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, authcenter.Probations())
+	}
+}
+
+// VouchHandler vouches for a node on behalf of this node.
+func (p *PrivateServer) VouchHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nodeID := c.Param("node")
+		if !fs.IsValidNodeID(nodeID) {
+			c.String(400, "error: invalid node ID %s", nodeID)
+			return
+		}
+		r, err := ctx.RecordStore().Vouch(ctx.WithRequest(c), nodeID)
+		if serveWriteError(c, err) {
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, r.Object.Meta())
+	}
+}
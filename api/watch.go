@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+const (
+	defaultWatchPollTimeout = 30 * time.Second
+	maxWatchPollTimeout     = 5 * time.Minute
+	maxWatchPollChanges     = 100
+)
+
+// WatchPollHandler long-polls for record changes. Query params: prefix, filter
+// (JSONPath expression, may be repeated) and timeout. Responds with the changes
+// that arrived before the timeout, an empty list otherwise.
+func (p *PrivateServer) WatchPollHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := defaultWatchPollTimeout
+		if v := c.Query("timeout"); len(v) > 0 {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				c.String(400, "error: %v", err)
+				return
+			} else if d > maxWatchPollTimeout {
+				d = maxWatchPollTimeout
+			}
+			timeout = d
+		}
+		w, err := ctx.RecordStore().Watch(rs.WatchOptions{
+			Prefix:  c.Query("prefix"),
+			Filters: c.QueryArray("filter"),
+		})
+		if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		defer w.Close()
+		changes := make([]*rs.RecordChange, 0, 1)
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		select {
+		case <-c.Request.Context().Done():
+			if c.Request.Context().Err() == context.DeadlineExceeded {
+				// the route timeout is shorter than the poll timeout
+				c.JSON(200, changes)
+			}
+			return
+		case <-t.C:
+			c.JSON(200, changes)
+			return
+		case change := <-w.C:
+			changes = append(changes, change)
+		}
+		// collect changes that have arrived together with the first one
+	collect:
+		for len(changes) < maxWatchPollChanges {
+			select {
+			case change := <-w.C:
+				changes = append(changes, change)
+			default:
+				break collect
+			}
+		}
+		c.JSON(200, changes)
+	}
+}
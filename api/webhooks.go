@@ -0,0 +1,105 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+type changeWebhookRequest struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Prefix string   `json:"prefix"`
+	Ops    []string `json:"ops"`
+	Secret string   `json:"secret"`
+}
+
+func (p *PrivateServer) WebhookListHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"webhooks": ctx.RecordStore().ListWebhooks(),
+			"stats":    ctx.RecordStore().WebhookStats(),
+		})
+	}
+}
+
+// WebhookRegisterHandler registers a webhook notified about record changes, the response
+// carries the secret payloads are signed with, it's not shown again.
+func (p *PrivateServer) WebhookRegisterHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req *changeWebhookRequest
+		if err := c.BindJSON(&req); err != nil {
+			return
+		} else if len(req.Name) == 0 || len(req.URL) == 0 {
+			c.String(400, "error: name and url are required")
+			return
+		}
+		hook := &rs.ChangeWebhook{
+			Name:   req.Name,
+			URL:    req.URL,
+			Prefix: req.Prefix,
+			Ops:    req.Ops,
+			Secret: req.Secret,
+		}
+		if err := ctx.RecordStore().RegisterWebhook(hook); err == rs.ErrWebhookExists {
+			c.String(409, "error: %v", err)
+			return
+		} else if err != nil {
+			c.String(400, "error: %v", err)
+			return
+		}
+		c.JSON(200, hook)
+	}
+}
+
+func (p *PrivateServer) WebhookUnregisterHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := ctx.RecordStore().UnregisterWebhook(c.Param("name")); err == rs.ErrWebhookNotFound {
+			c.AbortWithStatus(404)
+			return
+		} else if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.Status(200)
+	}
+}
+
+func (p *PrivateServer) DeadLettersHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := ctx.RecordStore().DeadLetters()
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, list)
+	}
+}
+
+// DeadLettersRequeueHandler queues the dead letter given by ?id= again, all of them if it's not set.
+func (p *PrivateServer) DeadLettersRequeueHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		n, err := ctx.RecordStore().RequeueDeadLetters(c.Query("id"))
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, gin.H{
+			"requeued": n,
+		})
+	}
+}
+
+// DeadLettersPurgeHandler drops the dead letter given by ?id=, all of them if it's not set.
+func (p *PrivateServer) DeadLettersPurgeHandler(ctx APIContext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		n, err := ctx.RecordStore().PurgeDeadLetters(c.Query("id"))
+		if err != nil {
+			c.String(500, "error: %v", err)
+			return
+		}
+		c.JSON(200, gin.H{
+			"purged": n,
+		})
+	}
+}
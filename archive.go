@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/api"
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+func recordsCmd(c *cli.Cmd) {
+	c.Command("export", "Write records with blocks of their objects into a CAR or tar archive.", recordsExportCmd)
+	c.Command("import", "Import an archive of records into the running node.", recordsImportCmd)
+}
+
+// archiveFormatOf guesses the format of the archive by its name, CAR is the default.
+func archiveFormatOf(name string) string {
+	if strings.HasSuffix(name, ".tar") {
+		return rs.ArchiveTar
+	}
+	return rs.ArchiveCAR
+}
+
+func recordsExportCmd(c *cli.Cmd) {
+	out := c.String(cli.StringOpt{
+		Name:  "o out",
+		Desc:  "Output file of the archive, - writes to stdout.",
+		Value: "-",
+	})
+	format := c.String(cli.StringOpt{
+		Name: "format",
+		Desc: "Format of the archive: car or tar, guessed by the extension of the output file.",
+	})
+	prefix := c.String(cli.StringOpt{
+		Name: "prefix",
+		Desc: "Export records under the path prefix only.",
+	})
+	namespaces := c.String(cli.StringOpt{
+		Name: "namespaces",
+		Desc: "Export records of the comma-separated namespaces only.",
+	})
+	since := c.String(cli.StringOpt{
+		Name: "since",
+		Desc: "Export records changed since the time only, in RFC 3339.",
+	})
+	history := c.Bool(cli.BoolOpt{
+		Name:  "history",
+		Desc:  "Add objects of previous versions of records.",
+		Value: false,
+	})
+	c.Action = func() {
+		if len(*format) == 0 {
+			*format = archiveFormatOf(*out)
+		}
+		q := url.Values{}
+		q.Set("format", *format)
+		q.Set("prefix", *prefix)
+		q.Set("namespaces", *namespaces)
+		q.Set("since", *since)
+		if *history {
+			q.Set("history", "true")
+		}
+		resp, err := backupRequest("GET", "/private/v1/records/export?"+q.Encode(), nil)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer resp.Body.Close()
+		var w io.Writer = os.Stdout
+		if *out != "-" {
+			f, err := os.OpenFile(*out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+			if err != nil {
+				log.Fatalln("failed to create archive file:", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			log.Fatalln("failed to write archive:", err)
+		}
+		// the trailer is set only once the archive is complete
+		records := resp.Trailer.Get(api.ArchiveRecordsHeader)
+		if len(records) == 0 {
+			log.Fatalln("archive is incomplete, see the log of the node")
+		}
+		log.WithFields(log.Fields{
+			"format":  *format,
+			"records": records,
+		}).Println("archive done")
+	}
+}
+
+func recordsImportCmd(c *cli.Cmd) {
+	c.Spec = "[--format] ARCHIVE"
+	name := c.StringArg("ARCHIVE", "", "Archive of records, - reads stdin.")
+	format := c.String(cli.StringOpt{
+		Name: "format",
+		Desc: "Format of the archive: car or tar, guessed by the extension of the file.",
+	})
+	c.Action = func() {
+		if len(*format) == 0 {
+			*format = archiveFormatOf(*name)
+		}
+		var r io.Reader = os.Stdin
+		if *name != "-" {
+			f, err := os.Open(*name)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer f.Close()
+			r = f
+		}
+		resp, err := backupRequest("POST", "/private/v1/records/import?format="+url.QueryEscape(*format), r)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer resp.Body.Close()
+		var result rs.ArchiveImport
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			log.Fatalln("failed to read the import result:", err)
+		}
+		fmt.Printf("Imported %d records and %d blocks, pinned %d objects, %d failed, %d skipped\n",
+			result.Records, result.Blocks, result.Pinned, result.Failed, result.Skipped)
+	}
+}
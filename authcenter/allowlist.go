@@ -0,0 +1,146 @@
+package authcenter
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// An allowlist file grants permissions without DNS. The list is signed with an Ethereum
+// key, the signature covers the allowlist field in compact JSON and nodes accept lists
+// signed by the configured addresses only, so a file copied around can't be tampered with:
+//
+//	{
+//	  "allowlist": {"entries": [{"key": "<node ID>", "permissions": ["write"]}]},
+//	  "signature": "0x<65 bytes of the secp256k1 signature>"
+//	}
+
+type allowlistFile struct {
+	Allowlist json.RawMessage `json:"allowlist"`
+	Signature string          `json:"signature"`
+}
+
+type allowlist struct {
+	Entries []allowlistEntry `json:"entries"`
+}
+
+type allowlistEntry struct {
+	Key         string   `json:"key"`
+	Permissions []string `json:"permissions"`
+}
+
+var ErrAllowlistSignature = errors.New("allowlist is not signed by any of the trusted signers")
+
+// NewFileProvider loads entries of a signed allowlist file, signers are Ethereum addresses
+// trusted to sign it.
+func NewFileProvider(path string, signers []string) (Provider, error) {
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no trusted signers of the allowlist %s", path)
+	}
+	p := &fileProvider{
+		path: path,
+	}
+	for _, addr := range signers {
+		if !strings.HasPrefix(addr, "0x") || len(addr) != 42 {
+			return nil, fmt.Errorf("invalid allowlist signer address %s", addr)
+		}
+		p.signers = append(p.signers, strings.ToLower(addr))
+	}
+	return p, nil
+}
+
+type fileProvider struct {
+	path    string
+	signers []string
+}
+
+func (p *fileProvider) Name() string {
+	return "file:" + p.path
+}
+
+func (p *fileProvider) Load(ctx context.Context) ([]Entry, error) {
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	list, signer, err := verifyAllowlist(data)
+	if err != nil {
+		return nil, err
+	}
+	var trusted bool
+	for _, addr := range p.signers {
+		if addr == signer {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return nil, ErrAllowlistSignature
+	}
+	entries := make([]Entry, 0, len(list.Entries))
+	for _, e := range list.Entries {
+		if len(e.Key) == 0 {
+			continue
+		}
+		entries = append(entries, Entry{
+			Key:         e.Key,
+			Permissions: ParsePermissions(p.Name(), e.Permissions),
+		})
+	}
+	return entries, nil
+}
+
+// verifyAllowlist decodes a signed allowlist file and returns the lowercase address of its signer.
+func verifyAllowlist(data []byte) (list *allowlist, signer string, err error) {
+	var f allowlistFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, "", fmt.Errorf("malformed allowlist: %v", err)
+	} else if len(f.Allowlist) == 0 {
+		return nil, "", errors.New("malformed allowlist: no allowlist field")
+	}
+	sig, err := hexutil.Decode(f.Signature)
+	if err != nil || len(sig) != 65 {
+		return nil, "", errors.New("malformed allowlist signature")
+	}
+	var raw bytes.Buffer
+	if err := json.Compact(&raw, f.Allowlist); err != nil {
+		return nil, "", fmt.Errorf("malformed allowlist: %v", err)
+	}
+	pub, err := crypto.SigToPub(crypto.Keccak256(raw.Bytes()), sig)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid allowlist signature: %v", err)
+	}
+	list = &allowlist{}
+	if err := json.Unmarshal(f.Allowlist, list); err != nil {
+		return nil, "", fmt.Errorf("malformed allowlist: %v", err)
+	}
+	return list, strings.ToLower(crypto.PubkeyToAddress(*pub).Hex()), nil
+}
+
+// SignAllowlist wraps the allowlist JSON into a file signed with the key.
+func SignAllowlist(data []byte, key *ecdsa.PrivateKey) ([]byte, error) {
+	var list allowlist
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("malformed allowlist: %v", err)
+	}
+	raw, err := json.Marshal(&list)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.Sign(crypto.Keccak256(raw), key)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(&allowlistFile{
+		Allowlist: raw,
+		Signature: hexutil.Encode(sig),
+	}, "", "  ")
+}
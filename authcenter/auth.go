@@ -1,7 +1,10 @@
 package authcenter
 
 import (
+	"context"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -11,26 +14,90 @@ func init() {
 	Default = NewDNSAuth(DefaultMainDomains, 1*time.Minute)
 }
 
-func InitWithDomains(domains []string) {
+// InitWithDomains replaces the default authority, the domains are re-resolved
+// every refresh interval so permission changes reach running nodes.
+func InitWithDomains(domains []string, refresh time.Duration) {
 	if Default != nil {
 		Default.StopUpdates()
 	}
-	Default = NewDNSAuth(domains, 1*time.Minute)
+	Default = NewDNSAuth(domains, refresh)
 }
 
 type Auth interface {
 	Entries() map[string]Entry
 	HasPermissions(key string, perms ...Permission) bool
 	AllPermissions(key string) []Permission
+	// MinProtocolVersion is the oldest gossip protocol version still accepted in the network.
+	MinProtocolVersion() int
+	// Refresh re-resolves the authority now instead of waiting for the next update.
+	Refresh(ctx context.Context) error
+	Status() *Status
 	StopUpdates()
 }
 
+type Status struct {
+	Domains []string `json:"domains"`
+	// Promoted are domains added by the majority of the authority.
+	Promoted []string `json:"promoted,omitempty"`
+	// Failed are domains that failed to resolve on the last update, their entries are kept.
+	Failed      []string  `json:"failed,omitempty"`
+	Entries     int       `json:"entries"`
+	MinProtocol int       `json:"min_protocol"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+	Interval    string    `json:"interval"`
+	Error       string    `json:"error,omitempty"`
+	// Providers are sources of entries besides the domains, see Provider.
+	Providers []*ProviderStatus `json:"providers,omitempty"`
+}
+
 type Permission string
 
 const (
 	RecordWritePermission Permission = "write"
 )
 
+// namespacePermissionPrefix starts tags of permissions limited to a namespace, e.g. "write/docs".
+const namespacePermissionPrefix = "write/"
+
+// NamespaceWritePermission allows writes of records of the namespace only, so that applications
+// sharing the network get a write ACL of their own.
+func NamespaceWritePermission(ns string) Permission {
+	return Permission(namespacePermissionPrefix + ns)
+}
+
+// Namespace returns the namespace of a namespace write permission, ok is false for other ones.
+func (p Permission) Namespace() (ns string, ok bool) {
+	if !strings.HasPrefix(string(p), namespacePermissionPrefix) {
+		return "", false
+	}
+	ns = strings.TrimPrefix(string(p), namespacePermissionPrefix)
+	if len(ns) == 0 || strings.Contains(ns, "/") {
+		return "", false
+	}
+	return ns, true
+}
+
+// priorityPermissionPrefix starts tags that rank writers when their writes of a record
+// conflict, e.g. "priority/10", see Priority.
+const priorityPermissionPrefix = "priority/"
+
+// PriorityPermission ranks the writer, writers without one have priority 0.
+func PriorityPermission(n int) Permission {
+	return Permission(priorityPermissionPrefix + strconv.Itoa(n))
+}
+
+// Priority returns the rank of a priority permission, ok is false for other ones.
+func (p Permission) Priority() (n int, ok bool) {
+	if !strings.HasPrefix(string(p), priorityPermissionPrefix) {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(string(p), priorityPermissionPrefix))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
 type Entry struct {
 	Key         string
 	Permissions []Permission
@@ -1,8 +1,12 @@
 package authcenter
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -24,12 +28,14 @@ var DefaultTestDomains = []string{
 
 func NewDNSAuth(domains []string, dur time.Duration) Auth {
 	d := &dnsAuth{
-		mux:     new(sync.RWMutex),
-		dur:     dur,
-		domains: domains,
-		entries: make(map[string][]Entry),
+		mux:          new(sync.RWMutex),
+		dur:          dur,
+		domains:      domains,
+		entries:      make(map[string][]Entry),
+		minProtocols: make(map[string]int),
 
-		stopC: make(chan struct{}),
+		refreshC: make(chan chan error),
+		stopC:    make(chan struct{}),
 	}
 	go d.refresh()
 	return d
@@ -39,88 +45,228 @@ type dnsAuth struct {
 	mux     *sync.RWMutex
 	dur     time.Duration
 	domains []string
-	entries map[string][]Entry
+	// promoted are domains added by the majority of auth domains on the last sync.
+	promoted []string
+	entries  map[string][]Entry
+	// minProtocols are min-protocol labels set on the auth domains.
+	minProtocols map[string]int
+	// minProtocol is the highest of min-protocol labels set on the auth domains.
+	minProtocol int
+	failed      []string
+	syncedAt    time.Time
+	syncErr     error
 
-	stopC chan struct{}
+	refreshC chan chan error
+	stopC    chan struct{}
 }
 
-func (d *dnsAuth) refresh() {
-	sync := func() error {
-		seen := make(map[string]struct{})
-		promoted := make(map[string]int)
-		checkDomain := func(domain string) {
-			if _, ok := seen[domain]; ok {
-				return
-			}
-			labels, err := net.LookupTXT(domain)
-			if err != nil {
-				if strings.Contains(err.Error(), "no such host") {
-					return
-				}
-				log.WithField("domain", domain).Infoln("failed to fetch TXT records:", err)
-				return
-			}
-			seen[domain] = struct{}{}
-			for _, label := range labels {
-				key, tags, ok := parseLabel(label)
-				if !ok {
-					log.WithField("domain", domain).Infoln("malformed label on auth domain:", label)
-					continue
-				}
-				if key == "promote" {
-					seenTags := make(map[string]struct{})
-					for _, tag := range tags {
-						if _, ok := seenTags[tag]; ok {
-							continue
-						}
-						seenTags[tag] = struct{}{}
-						promoted[tag]++
-					}
+// minRefreshInterval limits forced refreshes, so they can't be used to flood DNS.
+const minRefreshInterval = 5 * time.Second
+
+var errNoDomainsResolved = errors.New("none of the auth domains resolved")
+
+// domainRecords are labels of an auth domain.
+type domainRecords struct {
+	entries     []Entry
+	promotes    []string
+	minProtocol int
+}
+
+// lookupDomain fetches TXT records of an auth domain. A domain that doesn't exist
+// has no records, other lookup failures are retried over DNS-over-HTTPS and returned
+// as errors if that fails too.
+func lookupDomain(domain string) (*domainRecords, error) {
+	labels, err := net.LookupTXT(domain)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such host") {
+			return &domainRecords{}, nil
+		}
+		dohLabels, ok, dohErr := lookupTXTOverHTTPS(domain)
+		if !ok {
+			return nil, err
+		} else if dohErr != nil {
+			return nil, fmt.Errorf("%v, DNS-over-HTTPS: %v", err, dohErr)
+		}
+		labels = dohLabels
+	}
+	records := &domainRecords{}
+	seenTags := make(map[string]struct{})
+	for _, label := range labels {
+		key, tags, ok := parseLabel(label)
+		if !ok {
+			log.WithField("domain", domain).Infoln("malformed label on auth domain:", label)
+			continue
+		}
+		if key == "promote" {
+			for _, tag := range tags {
+				if _, ok := seenTags[tag]; ok {
 					continue
 				}
-				entry := Entry{
-					Key: key,
-				}
-				for _, tag := range tags {
-					switch p := Permission(tag); p {
-					case RecordWritePermission:
-						entry.Permissions = append(entry.Permissions, p)
-					default:
-						log.WithField("domain", domain).Infoln("unknown permission tag:", tag)
-					}
-				}
-				sort.Sort(Permissions(entry.Permissions))
-				d.entries[domain] = append(d.entries[domain], entry)
+				seenTags[tag] = struct{}{}
+				records.promotes = append(records.promotes, tag)
+			}
+			continue
+		} else if key == "min-protocol" {
+			v, err := strconv.Atoi(tags[0])
+			if err != nil || v < 0 {
+				log.WithField("domain", domain).Infoln("malformed min-protocol label:", label)
+			} else if v > records.minProtocol {
+				records.minProtocol = v
 			}
+			continue
 		}
+		records.entries = append(records.entries, Entry{
+			Key:         key,
+			Permissions: ParsePermissions(domain, tags),
+		})
+	}
+	return records, nil
+}
 
-		d.mux.Lock()
-		defer d.mux.Unlock()
-		d.entries = make(map[string][]Entry, len(d.entries))
-		for _, domain := range d.domains {
-			checkDomain(domain)
-		}
-		for domain, n := range promoted {
-			if _, ok := seen[domain]; ok {
-				// already seen that domain
-				continue
-			} else if shouldCare := checkRatio(n, len(seen)); !shouldCare {
-				// should not care for promotions without majority
-				continue
+// sync re-resolves the auth domains without blocking permission checks. Entries of
+// domains that failed to resolve are kept from the previous sync, so a DNS hiccup
+// doesn't revoke permissions across the network.
+func (d *dnsAuth) sync() error {
+	d.mux.RLock()
+	domains := append([]string(nil), d.domains...)
+	prevEntries := d.entries
+	prevProtocols := d.minProtocols
+	prev := d.keyEntries()
+	d.mux.RUnlock()
+
+	entries := make(map[string][]Entry, len(prevEntries))
+	minProtocols := make(map[string]int, len(prevProtocols))
+	seen := make(map[string]struct{})
+	promoted := make(map[string]int)
+	var failed []string
+	checkDomain := func(domain string) {
+		if _, ok := seen[domain]; ok {
+			return
+		}
+		records, err := lookupDomain(domain)
+		if err != nil {
+			log.WithField("domain", domain).Infoln("failed to fetch TXT records:", err)
+			failed = append(failed, domain)
+			if list, ok := prevEntries[domain]; ok {
+				entries[domain] = list
+			}
+			if v, ok := prevProtocols[domain]; ok {
+				minProtocols[domain] = v
 			}
-			d.domains = append(d.domains, domain)
-			checkDomain(domain)
+			return
+		}
+		seen[domain] = struct{}{}
+		for _, tag := range records.promotes {
+			promoted[tag]++
+		}
+		if len(records.entries) > 0 {
+			entries[domain] = records.entries
+		}
+		minProtocols[domain] = records.minProtocol
+	}
+	for _, domain := range domains {
+		checkDomain(domain)
+	}
+	if len(seen) == 0 && len(domains) > 0 {
+		d.mux.Lock()
+		d.failed = failed
+		d.syncErr = errNoDomainsResolved
+		d.mux.Unlock()
+		return errNoDomainsResolved
+	}
+	var promotedDomains []string
+	for domain, n := range promoted {
+		if _, ok := seen[domain]; ok {
+			// already seen that domain
+			continue
+		} else if shouldCare := checkRatio(n, len(seen)); !shouldCare {
+			// should not care for promotions without majority
+			continue
+		}
+		promotedDomains = append(promotedDomains, domain)
+		checkDomain(domain)
+	}
+	sort.Strings(promotedDomains)
+	var minProtocol int
+	for _, v := range minProtocols {
+		if v > minProtocol {
+			minProtocol = v
+		}
+	}
+
+	d.mux.Lock()
+	d.entries = entries
+	d.minProtocols = minProtocols
+	d.minProtocol = minProtocol
+	d.promoted = promotedDomains
+	d.failed = failed
+	d.syncedAt = time.Now()
+	d.syncErr = nil
+	next := d.keyEntries()
+	d.mux.Unlock()
+	logChanges(prev, next)
+	return nil
+}
+
+func logChanges(prev, next map[string]Entry) {
+	for key, e := range next {
+		p, ok := prev[key]
+		if !ok {
+			log.WithField("key", key).Infof("authority added node with permissions %v", e.Permissions)
+		} else if !samePermissions(p.Permissions, e.Permissions) {
+			log.WithField("key", key).Infof("authority changed node permissions from %v to %v", p.Permissions, e.Permissions)
+		}
+	}
+	for key := range prev {
+		if _, ok := next[key]; !ok {
+			log.WithField("key", key).Infoln("authority removed node")
 		}
-		return nil
 	}
+}
+
+func samePermissions(a, b []Permission) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *dnsAuth) refresh() {
 	t := time.NewTimer(time.Millisecond)
+	defer t.Stop()
 	for {
 		select {
 		case <-d.stopC:
 			return
+		case errC := <-d.refreshC:
+			d.mux.RLock()
+			recent := time.Since(d.syncedAt) < minRefreshInterval
+			d.mux.RUnlock()
+			if recent {
+				errC <- nil
+				continue
+			}
+			err := d.sync()
+			errC <- err
+			if !t.Stop() {
+				select {
+				case <-t.C:
+				default:
+				}
+			}
+			if err != nil {
+				t.Reset(time.Minute)
+				continue
+			}
+			t.Reset(d.dur)
 		case <-t.C:
-			if err := sync(); err != nil {
-				log.Warningln("DNS auth sync failed: %v", err)
+			if err := d.sync(); err != nil {
+				log.Warningf("DNS auth sync failed: %v", err)
 				t.Reset(time.Minute)
 				continue
 			}
@@ -129,6 +275,46 @@ func (d *dnsAuth) refresh() {
 	}
 }
 
+// Refresh re-resolves the auth domains now, unless they've been resolved a moment ago.
+func (d *dnsAuth) Refresh(ctx context.Context) error {
+	errC := make(chan error, 1)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-d.stopC:
+		return errUpdatesStopped
+	case d.refreshC <- errC:
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errC:
+		return err
+	}
+}
+
+var errUpdatesStopped = errors.New("auth updates are stopped")
+
+func (d *dnsAuth) Status() *Status {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	status := &Status{
+		Domains:     append([]string(nil), d.domains...),
+		Promoted:    append([]string(nil), d.promoted...),
+		Failed:      append([]string(nil), d.failed...),
+		MinProtocol: d.minProtocol,
+		RefreshedAt: d.syncedAt,
+		Interval:    d.dur.String(),
+	}
+	for _, list := range d.entries {
+		status.Entries += len(list)
+	}
+	if d.syncErr != nil {
+		status.Error = d.syncErr.Error()
+	}
+	return status
+}
+
 // checkRatio returns true if majority of total promotes a domain.
 func checkRatio(n, total int) bool {
 	switch {
@@ -190,14 +376,27 @@ func (d *dnsAuth) HasPermissions(key string, perms ...Permission) bool {
 	return false
 }
 
+func (d *dnsAuth) MinProtocolVersion() int {
+	d.mux.RLock()
+	v := d.minProtocol
+	d.mux.RUnlock()
+	return v
+}
+
 func (d *dnsAuth) Entries() map[string]Entry {
 	d.mux.RLock()
+	m := d.keyEntries()
+	d.mux.RUnlock()
+	return m
+}
+
+// keyEntries must be called with the lock held.
+func (d *dnsAuth) keyEntries() map[string]Entry {
 	m := make(map[string]Entry, len(d.entries))
 	for _, list := range d.entries {
 		for _, e := range list {
 			m[e.Key] = e
 		}
 	}
-	d.mux.RUnlock()
 	return m
 }
@@ -0,0 +1,148 @@
+package authcenter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proxy"
+)
+
+// Nodes behind corporate firewalls may be unable to resolve the auth domains, since only
+// the proxy reaches the internet. When a lookup fails, TXT records are requested from
+// DNS-over-HTTPS resolvers through the proxy, using their JSON API.
+
+// DefaultDoHResolvers are JSON API endpoints of public DNS-over-HTTPS resolvers.
+var DefaultDoHResolvers = []string{
+	"https://cloudflare-dns.com/dns-query",
+	"https://dns.google/resolve",
+}
+
+const (
+	dohTimeout  = 10 * time.Second
+	dohTypeTXT  = 16
+	dohNXDomain = 3
+)
+
+var (
+	dohMux       = new(sync.RWMutex)
+	dohResolvers = DefaultDoHResolvers
+	dohClient    = proxy.Client(dohTimeout)
+)
+
+// UseDoHResolvers sets DNS-over-HTTPS resolvers used when a system lookup of an auth domain
+// fails, an empty list disables the fallback.
+func UseDoHResolvers(resolvers []string) {
+	var list []string
+	for _, r := range resolvers {
+		if r = strings.TrimSpace(r); len(r) > 0 {
+			list = append(list, r)
+		}
+	}
+	dohMux.Lock()
+	dohResolvers = list
+	dohMux.Unlock()
+}
+
+type dohAnswer struct {
+	Type int    `json:"type"`
+	Data string `json:"data"`
+}
+
+type dohResponse struct {
+	Status int         `json:"Status"`
+	Answer []dohAnswer `json:"Answer"`
+}
+
+// lookupTXTOverHTTPS tries the resolvers in order, ok is false if the fallback is disabled.
+// A domain that doesn't exist has no records.
+func lookupTXTOverHTTPS(domain string) (labels []string, ok bool, err error) {
+	dohMux.RLock()
+	resolvers := dohResolvers
+	dohMux.RUnlock()
+	if len(resolvers) == 0 {
+		return nil, false, nil
+	}
+	for _, resolver := range resolvers {
+		if labels, err = queryDoH(resolver, domain); err == nil {
+			return labels, true, nil
+		}
+		log.WithFields(log.Fields{
+			"domain":   domain,
+			"resolver": resolver,
+		}).Debugf("DNS-over-HTTPS lookup failed: %v", err)
+	}
+	return nil, true, err
+}
+
+func queryDoH(resolver, domain string) ([]string, error) {
+	u, err := url.Parse(resolver)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("name", domain)
+	q.Set("type", "TXT")
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/dns-json")
+	resp, err := dohClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("resolver responded with status %d", resp.StatusCode)
+	}
+	var r dohResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("malformed resolver response: %v", err)
+	}
+	if r.Status == dohNXDomain {
+		return nil, nil
+	} else if r.Status != 0 {
+		return nil, fmt.Errorf("resolver responded with DNS status %d", r.Status)
+	}
+	var labels []string
+	for _, a := range r.Answer {
+		if a.Type == dohTypeTXT {
+			labels = append(labels, joinTXTStrings(a.Data))
+		}
+	}
+	return labels, nil
+}
+
+// joinTXTStrings joins character strings of a TXT record given in presentation format,
+// e.g. "a" "b" becomes ab, the same way the system resolver does.
+func joinTXTStrings(data string) string {
+	data = strings.TrimSpace(data)
+	if !strings.HasPrefix(data, `"`) {
+		return data
+	}
+	var b bytes.Buffer
+	var quoted, escaped bool
+	for _, c := range data {
+		switch {
+		case escaped:
+			b.WriteRune(c)
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,159 @@
+package authcenter
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A node may rotate its identity, e.g. when it moves to new hardware. The old ID links
+// itself to the new one, see rs.PlanetaryRecordStore, so permissions of the old ID pass to the new
+// one while the old ID keeps none, the old key is retired. Links may chain, an ID rotated
+// a few times holds permissions of the first ID of the chain.
+
+// maxLinkChain limits the number of rotations followed from an ID.
+const maxLinkChain = 16
+
+// Link is a rotation of the From ID to the To ID.
+type Link struct {
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+	Since time.Time `json:"since"`
+}
+
+var links = struct {
+	mux    *sync.RWMutex
+	byFrom map[string]*Link
+	byTo   map[string]*Link
+}{
+	mux:    new(sync.RWMutex),
+	byFrom: make(map[string]*Link),
+	byTo:   make(map[string]*Link),
+}
+
+// SetLinks replaces the known identity links. An ID rotates once, so of a few links of
+// the same ID, or to the same ID, the earliest is kept.
+func SetLinks(list []*Link) {
+	list = append([]*Link(nil), list...)
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Since.Before(list[j].Since)
+	})
+	byFrom := make(map[string]*Link, len(list))
+	byTo := make(map[string]*Link, len(list))
+	for _, l := range list {
+		if _, ok := byFrom[l.From]; ok || l.From == l.To {
+			continue
+		} else if _, ok := byTo[l.To]; ok {
+			continue
+		}
+		byFrom[l.From] = l
+		byTo[l.To] = l
+	}
+	links.mux.Lock()
+	links.byFrom = byFrom
+	links.byTo = byTo
+	links.mux.Unlock()
+}
+
+// Links returns the known identity links, the earliest first.
+func Links() []*Link {
+	links.mux.RLock()
+	defer links.mux.RUnlock()
+	list := make([]*Link, 0, len(links.byFrom))
+	for _, l := range links.byFrom {
+		list = append(list, l)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Since.Before(list[j].Since)
+	})
+	return list
+}
+
+// Successor returns the latest ID the key was rotated to, the key itself if it wasn't.
+func Successor(key string) string {
+	links.mux.RLock()
+	defer links.mux.RUnlock()
+	for i := 0; i < maxLinkChain; i++ {
+		l, ok := links.byFrom[key]
+		if !ok {
+			break
+		}
+		key = l.To
+	}
+	return key
+}
+
+// Predecessors returns IDs the key was rotated from, the latest first.
+func Predecessors(key string) []string {
+	links.mux.RLock()
+	defer links.mux.RUnlock()
+	var list []string
+	for i := 0; i < maxLinkChain; i++ {
+		l, ok := links.byTo[key]
+		if !ok {
+			break
+		}
+		list = append(list, l.From)
+		key = l.From
+	}
+	return list
+}
+
+// Retired tells whether the key was rotated to another ID.
+func Retired(key string) bool {
+	links.mux.RLock()
+	_, ok := links.byFrom[key]
+	links.mux.RUnlock()
+	return ok
+}
+
+// HasPermissions tells whether the key holds the permissions of the default authority,
+// directly or through IDs it was rotated from. Retired keys hold no permissions.
+func HasPermissions(key string, perms ...Permission) bool {
+	if Retired(key) {
+		return false
+	} else if Default.HasPermissions(key, perms...) {
+		return true
+	}
+	for _, prev := range Predecessors(key) {
+		if Default.HasPermissions(prev, perms...) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllPermissions returns permissions of the key and IDs it was rotated from, see HasPermissions.
+func AllPermissions(key string) []Permission {
+	if Retired(key) {
+		return nil
+	}
+	perms := Default.AllPermissions(key)
+	for _, prev := range Predecessors(key) {
+		perms = append(perms, Default.AllPermissions(prev)...)
+	}
+	if len(perms) == 0 {
+		return nil
+	}
+	seen := make(map[Permission]bool, len(perms))
+	list := perms[:0]
+	for _, p := range perms {
+		if !seen[p] {
+			seen[p] = true
+			list = append(list, p)
+		}
+	}
+	sort.Sort(Permissions(list))
+	return list
+}
+
+// Priority returns the highest priority granted to the key, see PriorityPermission.
+func Priority(key string) int {
+	var max int
+	for _, p := range AllPermissions(key) {
+		if n, ok := p.Priority(); ok && n > max {
+			max = n
+		}
+	}
+	return max
+}
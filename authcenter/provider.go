@@ -0,0 +1,301 @@
+package authcenter
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Besides DNS auth domains, permissions may come from providers, like a registry contract
+// or a signed allowlist file, so private deployments don't need public DNS. Providers are
+// combined with DNS domains, if any: a node holds the permissions granted by any source.
+// Entries of a provider that failed to load are kept from its previous load.
+
+// Provider is a source of authority entries.
+type Provider interface {
+	// Name identifies the provider in the status, e.g. file:/etc/atlant/allowlist.json.
+	Name() string
+	Load(ctx context.Context) ([]Entry, error)
+}
+
+// ProviderStatus is the state of a provider as of its last load.
+type ProviderStatus struct {
+	Name     string    `json:"name"`
+	Entries  int       `json:"entries"`
+	LoadedAt time.Time `json:"loaded_at,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// providerLoadTimeout limits a single load of a provider.
+const providerLoadTimeout = time.Minute
+
+// Init replaces the default authority.
+func Init(auth Auth) {
+	if Default != nil {
+		Default.StopUpdates()
+	}
+	Default = auth
+}
+
+// NewAuth combines entries of the providers with the DNS authority, which may be nil if
+// no domains are used. Providers are reloaded every refresh interval.
+func NewAuth(dns Auth, providers []Provider, refresh time.Duration) Auth {
+	a := &providerAuth{
+		mux:       new(sync.RWMutex),
+		dur:       refresh,
+		dns:       dns,
+		providers: providers,
+		entries:   make(map[string][]Entry),
+		status:    make(map[string]*ProviderStatus),
+
+		refreshC: make(chan chan error),
+		stopC:    make(chan struct{}),
+	}
+	for _, p := range providers {
+		a.status[p.Name()] = &ProviderStatus{
+			Name: p.Name(),
+		}
+	}
+	go a.refresh()
+	return a
+}
+
+type providerAuth struct {
+	mux       *sync.RWMutex
+	dur       time.Duration
+	dns       Auth
+	providers []Provider
+	// entries are loaded by provider names.
+	entries  map[string][]Entry
+	status   map[string]*ProviderStatus
+	syncedAt time.Time
+
+	refreshC chan chan error
+	stopC    chan struct{}
+}
+
+// sync loads all providers, the last error is returned if any failed.
+func (a *providerAuth) sync() error {
+	prev := a.providerEntries()
+	var lastErr error
+	for _, p := range a.providers {
+		ctx, cancelFn := context.WithTimeout(context.Background(), providerLoadTimeout)
+		list, err := p.Load(ctx)
+		cancelFn()
+		a.mux.Lock()
+		status := a.status[p.Name()]
+		if err != nil {
+			log.WithField("provider", p.Name()).Infoln("failed to load auth entries:", err)
+			status.Error = err.Error()
+			lastErr = err
+			a.mux.Unlock()
+			continue
+		}
+		for i := range list {
+			sort.Sort(Permissions(list[i].Permissions))
+		}
+		a.entries[p.Name()] = list
+		status.Entries = len(list)
+		status.LoadedAt = time.Now()
+		status.Error = ""
+		a.mux.Unlock()
+	}
+	a.mux.Lock()
+	a.syncedAt = time.Now()
+	a.mux.Unlock()
+	logChanges(prev, a.providerEntries())
+	return lastErr
+}
+
+func (a *providerAuth) refresh() {
+	t := time.NewTimer(time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-a.stopC:
+			return
+		case errC := <-a.refreshC:
+			a.mux.RLock()
+			recent := time.Since(a.syncedAt) < minRefreshInterval
+			a.mux.RUnlock()
+			if recent {
+				errC <- nil
+				continue
+			}
+			errC <- a.sync()
+			if !t.Stop() {
+				select {
+				case <-t.C:
+				default:
+				}
+			}
+			t.Reset(a.dur)
+		case <-t.C:
+			if err := a.sync(); err != nil {
+				log.Warningf("auth providers sync failed: %v", err)
+			}
+			t.Reset(a.dur)
+		}
+	}
+}
+
+// Refresh re-resolves DNS domains and reloads the providers now.
+func (a *providerAuth) Refresh(ctx context.Context) error {
+	if a.dns != nil {
+		if err := a.dns.Refresh(ctx); err != nil {
+			return err
+		}
+	}
+	errC := make(chan error, 1)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-a.stopC:
+		return errUpdatesStopped
+	case a.refreshC <- errC:
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errC:
+		return err
+	}
+}
+
+func (a *providerAuth) Status() *Status {
+	status := &Status{
+		Interval: a.dur.String(),
+	}
+	if a.dns != nil {
+		status = a.dns.Status()
+	}
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	for _, p := range a.providers {
+		s := *a.status[p.Name()]
+		status.Providers = append(status.Providers, &s)
+		status.Entries += s.Entries
+		if s.LoadedAt.After(status.RefreshedAt) {
+			status.RefreshedAt = s.LoadedAt
+		}
+	}
+	return status
+}
+
+func (a *providerAuth) StopUpdates() {
+	close(a.stopC)
+	if a.dns != nil {
+		a.dns.StopUpdates()
+	}
+}
+
+func (a *providerAuth) AllPermissions(key string) []Permission {
+	var perms []Permission
+	if a.dns != nil {
+		perms = a.dns.AllPermissions(key)
+	}
+	a.mux.RLock()
+	for _, list := range a.entries {
+		for _, e := range list {
+			if e.Key == key {
+				perms = append(perms, e.AllPermissions()...)
+			}
+		}
+	}
+	a.mux.RUnlock()
+	return perms
+}
+
+func (a *providerAuth) HasPermissions(key string, perms ...Permission) bool {
+	if a.dns != nil && a.dns.HasPermissions(key, perms...) {
+		return true
+	}
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	for _, list := range a.entries {
+		for _, e := range list {
+			if e.Key == key && e.HasPermissions(perms...) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (a *providerAuth) MinProtocolVersion() int {
+	if a.dns == nil {
+		return 0
+	}
+	return a.dns.MinProtocolVersion()
+}
+
+// Entries returns entries of all sources, permissions of a key granted by a few sources are merged.
+func (a *providerAuth) Entries() map[string]Entry {
+	m := a.providerEntries()
+	if a.dns != nil {
+		for _, e := range a.dns.Entries() {
+			mergeEntry(m, e)
+		}
+	}
+	return m
+}
+
+func (a *providerAuth) providerEntries() map[string]Entry {
+	m := make(map[string]Entry)
+	a.mux.RLock()
+	for _, list := range a.entries {
+		for _, e := range list {
+			mergeEntry(m, e)
+		}
+	}
+	a.mux.RUnlock()
+	return m
+}
+
+func mergeEntry(m map[string]Entry, e Entry) {
+	prev, ok := m[e.Key]
+	if !ok {
+		m[e.Key] = e
+		return
+	}
+	seen := make(map[Permission]bool, len(prev.Permissions))
+	perms := append([]Permission(nil), prev.Permissions...)
+	for _, p := range perms {
+		seen[p] = true
+	}
+	for _, p := range e.Permissions {
+		if !seen[p] {
+			perms = append(perms, p)
+		}
+	}
+	sort.Sort(Permissions(perms))
+	m[e.Key] = Entry{
+		Key:         e.Key,
+		Permissions: perms,
+	}
+}
+
+// ParsePermissions keeps known permission tags of the source, the rest are logged and skipped.
+func ParsePermissions(source string, tags []string) []Permission {
+	var perms []Permission
+	for _, tag := range tags {
+		switch p := Permission(tag); p {
+		case RecordWritePermission:
+			perms = append(perms, p)
+		default:
+			if _, ok := p.Namespace(); ok {
+				perms = append(perms, p)
+				continue
+			} else if _, ok := p.Priority(); ok {
+				perms = append(perms, p)
+				continue
+			}
+			log.WithField("source", source).Infoln("unknown permission tag:", tag)
+		}
+	}
+	sort.Sort(Permissions(perms))
+	return perms
+}
@@ -0,0 +1,61 @@
+package authcenter
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ProbationPermission is granted to nodes vouched for by enough permission holders.
+// Probationary nodes may write to the probation namespace only, until the probation ends.
+const ProbationPermission Permission = "probation"
+
+type Probation struct {
+	Key      string    `json:"key"`
+	Vouchers []string  `json:"vouchers"`
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+}
+
+var probations = struct {
+	mux   *sync.RWMutex
+	byKey map[string]*Probation
+}{
+	mux:   new(sync.RWMutex),
+	byKey: make(map[string]*Probation),
+}
+
+// SetProbations replaces the probations granted by vouches, keys that hold
+// permissions of the authority are skipped.
+func SetProbations(list []*Probation) {
+	byKey := make(map[string]*Probation, len(list))
+	for _, p := range list {
+		if len(AllPermissions(p.Key)) == 0 {
+			byKey[p.Key] = p
+		}
+	}
+	probations.mux.Lock()
+	probations.byKey = byKey
+	probations.mux.Unlock()
+}
+
+// OnProbation tells whether the key has a probation that hasn't ended yet.
+func OnProbation(key string) bool {
+	probations.mux.RLock()
+	defer probations.mux.RUnlock()
+	p, ok := probations.byKey[key]
+	return ok && time.Now().Before(p.Until)
+}
+
+func Probations() []*Probation {
+	probations.mux.RLock()
+	defer probations.mux.RUnlock()
+	list := make([]*Probation, 0, len(probations.byKey))
+	for _, p := range probations.byKey {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Since.Before(list[j].Since)
+	})
+	return list
+}
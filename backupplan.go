@@ -0,0 +1,351 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/api"
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// Coordinated backups keep one copy of each object per cluster instead of one per node: every
+// member runs backup with the same --members, gets the index of the plan and blocks of the
+// versions it owns from its running node, and adds them to its archive. The restore planner
+// reads archives of the members, checks that they share the plan and that owners of every
+// indexed version are among them, then imports blocks of all archives into the restored node.
+
+// backupShard spools the index and blocks of the node to disk, as tar needs sizes upfront.
+type backupShard struct {
+	index     *rs.BackupIndex
+	indexFile *os.File
+	blocks    *os.File
+	versions  int
+}
+
+func (s *backupShard) Close() {
+	for _, f := range []*os.File{s.indexFile, s.blocks} {
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+}
+
+func (s *backupShard) write(tw *tar.Writer) error {
+	files := []struct {
+		name string
+		f    *os.File
+	}{
+		{backupIndexFile, s.indexFile},
+		{backupBlocksFile, s.blocks},
+	}
+	for _, v := range files {
+		name, f := v.name, v.f
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		} else if err := writeBackupFile(tw, name, info.Size(), f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func backupPlanQuery(plan *rs.BackupPlan) url.Values {
+	q := url.Values{}
+	q.Set("members", strings.Join(plan.Members, ","))
+	q.Set("replicas", strconv.Itoa(plan.Replicas))
+	return q
+}
+
+// backupRequest calls the private API of the running node with the support token.
+func backupRequest(method, path string, body io.Reader) (*http.Response, error) {
+	info, err := readPrivateAPIFile()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", info.Addr, path), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+info.Token)
+	resp, err := (&http.Client{Timeout: 24 * time.Hour}).Do(req)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode != 200 {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("node responded with %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return resp, nil
+}
+
+// fetchBackupShard gets the index of the plan and blocks the node owns from the running node.
+func fetchBackupShard(plan *rs.BackupPlan) (*backupShard, error) {
+	shard := &backupShard{}
+	var err error
+	if shard.indexFile, err = ioutil.TempFile("", "atlant-index"); err != nil {
+		return nil, err
+	} else if shard.blocks, err = ioutil.TempFile("", "atlant-blocks"); err != nil {
+		shard.Close()
+		return nil, err
+	}
+	if err := func() error {
+		query := backupPlanQuery(plan).Encode()
+		resp, err := backupRequest("GET", "/private/v1/backup/index?"+query, nil)
+		if err != nil {
+			return err
+		}
+		err = json.NewDecoder(io.TeeReader(resp.Body, shard.indexFile)).Decode(&shard.index)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("bad index: %v", err)
+		}
+		log.WithFields(log.Fields{
+			"plan":     plan.ID,
+			"versions": len(shard.index.Objects),
+		}).Println("got the backup index, exporting blocks of the node")
+		if resp, err = backupRequest("GET", "/private/v1/backup/shard?"+query, nil); err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if _, err := io.Copy(shard.blocks, resp.Body); err != nil {
+			return err
+		}
+		// the trailer is set only once the export is complete
+		if shard.versions, err = strconv.Atoi(resp.Trailer.Get(api.BackupShardHeader)); err != nil {
+			return errors.New("blocks export is incomplete")
+		}
+		return nil
+	}(); err != nil {
+		shard.Close()
+		return nil, err
+	}
+	return shard, nil
+}
+
+// backupArchive is an archive of a coordinated backup read by the restore planner.
+type backupArchive struct {
+	name     string
+	manifest *backupManifest
+	index    *rs.BackupIndex
+}
+
+// openBackupArchive calls fn with tar entries of the archive until fn returns io.EOF or an error.
+func openBackupArchive(name string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(hdr, tr); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func readBackupArchive(name string) (*backupArchive, error) {
+	archive := &backupArchive{name: name}
+	if err := openBackupArchive(name, func(hdr *tar.Header, r io.Reader) error {
+		switch hdr.Name {
+		case backupManifestFile:
+			if err := json.NewDecoder(r).Decode(&archive.manifest); err != nil {
+				return fmt.Errorf("bad manifest: %v", err)
+			} else if archive.manifest.Plan == nil {
+				return errors.New("not a coordinated backup, restore it with restore")
+			}
+		case backupIndexFile:
+			if err := json.NewDecoder(r).Decode(&archive.index); err != nil {
+				return fmt.Errorf("bad index: %v", err)
+			}
+			return io.EOF
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if archive.manifest == nil {
+		return nil, errors.New("manifest is missing")
+	} else if archive.index == nil {
+		return nil, errors.New("index is missing")
+	}
+	return archive, nil
+}
+
+// backupRestorePlan tells which archives restore blocks of which members and which versions
+// no archive has.
+type backupRestorePlan struct {
+	Plan     *rs.BackupPlan
+	Archives map[string]*backupArchive
+	// Missing are members without an archive.
+	Missing []string
+	// Versions is the number of versions in indexes of all archives, Uncovered lists
+	// versions none of their owners backed up.
+	Versions  int
+	Uncovered []string
+}
+
+func planBackupRestore(archives []*backupArchive) (*backupRestorePlan, error) {
+	plan := &backupRestorePlan{
+		Plan:     archives[0].manifest.Plan,
+		Archives: make(map[string]*backupArchive, len(archives)),
+	}
+	// owned[version] is set when an owner of the version has it in its index
+	owned := make(map[string]bool)
+	for _, a := range archives {
+		if a.manifest.Plan.ID != plan.Plan.ID {
+			return nil, fmt.Errorf("%s is made with plan %s, %s with plan %s",
+				a.name, a.manifest.Plan.ID, archives[0].name, plan.Plan.ID)
+		} else if prev, ok := plan.Archives[a.manifest.NodeID]; ok {
+			return nil, fmt.Errorf("%s and %s are both made by %s", prev.name, a.name, a.manifest.NodeID)
+		}
+		plan.Archives[a.manifest.NodeID] = a
+		for _, obj := range a.index.Objects {
+			if owned[obj.Version] {
+				continue
+			}
+			owned[obj.Version] = false
+			for _, owner := range obj.Owners {
+				if owner == a.manifest.NodeID {
+					owned[obj.Version] = true
+				}
+			}
+		}
+	}
+	for _, nodeID := range plan.Plan.Members {
+		if _, ok := plan.Archives[nodeID]; !ok {
+			plan.Missing = append(plan.Missing, nodeID)
+		}
+	}
+	plan.Versions = len(owned)
+	for version, ok := range owned {
+		if !ok {
+			plan.Uncovered = append(plan.Uncovered, version)
+		}
+	}
+	sort.Strings(plan.Uncovered)
+	return plan, nil
+}
+
+func restorePlanCmd(c *cli.Cmd) {
+	c.Spec = "[--import] ARCHIVE..."
+	names := c.StringsArg("ARCHIVE", nil, "Archives of a coordinated backup made by members of the cluster.")
+	doImport := c.Bool(cli.BoolOpt{
+		Name:  "import",
+		Desc:  "Import blocks of all archives into the running node, restored from its own archive.",
+		Value: false,
+	})
+	c.Action = func() {
+		var archives []*backupArchive
+		for _, name := range *names {
+			archive, err := readBackupArchive(name)
+			if err != nil {
+				log.Fatalf("failed to read %s: %v", name, err)
+			}
+			archives = append(archives, archive)
+		}
+		plan, err := planBackupRestore(archives)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Plan:\t%s, %d members, %d replicas\n", plan.Plan.ID, len(plan.Plan.Members), plan.Plan.Replicas)
+		for _, nodeID := range plan.Plan.Members {
+			if a, ok := plan.Archives[nodeID]; ok {
+				fmt.Fprintf(w, "%s:\t%s, %d versions, made %s\n", nodeID, a.name,
+					a.manifest.ShardVersions, a.manifest.CreatedAt.Format(time.RFC3339))
+			} else {
+				fmt.Fprintf(w, "%s:\tmissing\n", nodeID)
+			}
+		}
+		fmt.Fprintf(w, "Versions:\t%d, %d not backed up by their owners\n", plan.Versions, len(plan.Uncovered))
+		w.Flush()
+		for _, version := range plan.Uncovered {
+			log.WithField("version", version).Debugln("not backed up by its owners")
+		}
+		if len(plan.Missing) > 0 {
+			log.Warningf("archives of %d members are missing: %s", len(plan.Missing), strings.Join(plan.Missing, ", "))
+		}
+		if len(plan.Uncovered) > 0 {
+			log.Warningf("%d versions are in no archive, they are fetched from peers if any has them", len(plan.Uncovered))
+		}
+		if !*doImport {
+			return
+		}
+		query := backupPlanQuery(plan.Plan)
+		for _, nodeID := range plan.Plan.Members {
+			a, ok := plan.Archives[nodeID]
+			if !ok {
+				continue
+			}
+			query.Set("owner", nodeID)
+			result, err := importBackupBlocks(a.name, query)
+			if err != nil {
+				log.Fatalf("failed to import blocks of %s: %v", a.name, err)
+			}
+			log.WithFields(log.Fields{
+				"owner":  nodeID,
+				"blocks": result.Blocks,
+				"pinned": result.Pinned,
+				"failed": result.Failed,
+			}).Println("imported", a.name)
+		}
+	}
+}
+
+// importBackupBlocks streams blocks of the archive to the running node.
+func importBackupBlocks(name string, query url.Values) (*rs.BackupImport, error) {
+	var result *rs.BackupImport
+	if err := openBackupArchive(name, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Name != backupBlocksFile {
+			return nil
+		}
+		resp, err := backupRequest("POST", "/private/v1/backup/shard?"+query.Encode(), r)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return err
+		}
+		return io.EOF
+	}); err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, errors.New("blocks are missing")
+	}
+	return result, nil
+}
@@ -0,0 +1,238 @@
+// Package cache keeps results of hot lookups in memory, so that requests don't read them
+// from the state store or IPFS again and again. Entries are grouped by class, each class
+// has its own TTL, and the least recently used entries are evicted once the cache is full.
+// Owners of the data invalidate entries when it changes, the TTL only bounds staleness of
+// changes nobody has been told about.
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Class groups entries of the same kind of lookup.
+type Class string
+
+const (
+	// Paths maps paths of records to their IDs.
+	Paths Class = "paths"
+	// Objects maps versions of records to their object metadata, versions never change.
+	Objects Class = "objects"
+)
+
+// Classes lists the known classes with their default TTLs.
+var Classes = map[Class]time.Duration{
+	Paths:   time.Minute,
+	Objects: time.Hour,
+}
+
+// DefaultSize is the default number of entries kept by the cache.
+const DefaultSize = 10000
+
+// ParseTTLs parses TTLs of classes given as class=duration pairs separated by comma.
+func ParseTTLs(s string) (map[Class]time.Duration, error) {
+	ttls := make(map[Class]time.Duration)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad cache TTL: %s", pair)
+		}
+		class := Class(strings.TrimSpace(parts[0]))
+		if _, ok := Classes[class]; !ok {
+			return nil, fmt.Errorf("bad cache TTL: %s: unknown class", pair)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("bad cache TTL: %s: %v", pair, err)
+		} else if d < 0 {
+			return nil, fmt.Errorf("bad cache TTL: %s: must not be negative", pair)
+		}
+		ttls[class] = d
+	}
+	return ttls, nil
+}
+
+// Config sets the size of the cache and TTLs of classes, a zero TTL disables the class.
+type Config struct {
+	Size int
+	TTLs map[Class]time.Duration
+}
+
+// ClassStats are counters of a class since the start of the node.
+type ClassStats struct {
+	Class     Class         `json:"class"`
+	TTL       time.Duration `json:"ttl"`
+	Entries   int           `json:"entries"`
+	Hits      uint64        `json:"hits"`
+	Misses    uint64        `json:"misses"`
+	Evictions uint64        `json:"evictions"`
+}
+
+type entry struct {
+	class     Class
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+type entryKey struct {
+	class Class
+	key   string
+}
+
+// Cache is safe for concurrent use, a nil Cache caches nothing.
+type Cache struct {
+	size int
+	ttls map[Class]time.Duration
+
+	mux     *sync.Mutex
+	lru     *list.List
+	entries map[entryKey]*list.Element
+	stats   map[Class]*ClassStats
+}
+
+// New creates a cache, it returns nil if the size is not positive.
+func New(cfg *Config) *Cache {
+	if cfg == nil || cfg.Size <= 0 {
+		return nil
+	}
+	c := &Cache{
+		size:    cfg.Size,
+		ttls:    make(map[Class]time.Duration),
+		mux:     new(sync.Mutex),
+		lru:     list.New(),
+		entries: make(map[entryKey]*list.Element),
+		stats:   make(map[Class]*ClassStats),
+	}
+	for class, ttl := range Classes {
+		if v, ok := cfg.TTLs[class]; ok {
+			ttl = v
+		}
+		c.ttls[class] = ttl
+		c.stats[class] = &ClassStats{
+			Class: class,
+			TTL:   ttl,
+		}
+	}
+	return c
+}
+
+// Get returns the value cached for the key, expired entries are dropped.
+func (c *Cache) Get(class Class, key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	stats, ok := c.stats[class]
+	if !ok || c.ttls[class] <= 0 {
+		return nil, false
+	}
+	el, ok := c.entries[entryKey{class, key}]
+	if !ok {
+		stats.Misses++
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if time.Now().After(e.expiresAt) {
+		c.remove(el)
+		stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	stats.Hits++
+	return e.value, true
+}
+
+// Set caches the value for the TTL of its class.
+func (c *Cache) Set(class Class, key string, value interface{}) {
+	if c == nil {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	ttl := c.ttls[class]
+	if ttl <= 0 {
+		return
+	}
+	k := entryKey{class, key}
+	if el, ok := c.entries[k]; ok {
+		e := el.Value.(*entry)
+		e.value = value
+		e.expiresAt = time.Now().Add(ttl)
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[k] = c.lru.PushFront(&entry{
+		class:     class,
+		key:       key,
+		value:     value,
+		expiresAt: time.Now().Add(ttl),
+	})
+	c.stats[class].Entries++
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.stats[el.Value.(*entry).class].Evictions++
+		c.remove(el)
+	}
+}
+
+// Invalidate drops the entry of the key.
+func (c *Cache) Invalidate(class Class, key string) {
+	if c == nil {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if el, ok := c.entries[entryKey{class, key}]; ok {
+		c.remove(el)
+	}
+}
+
+// InvalidateClass drops all entries of the class.
+func (c *Cache) InvalidateClass(class Class) {
+	if c == nil {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*entry).class == class {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// remove must be called with the lock held.
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, entryKey{e.class, e.key})
+	c.stats[e.class].Entries--
+}
+
+// Stats returns counters of all classes sorted by class.
+func (c *Cache) Stats() []ClassStats {
+	if c == nil {
+		return nil
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	all := make([]ClassStats, 0, len(c.stats))
+	for _, s := range c.stats {
+		all = append(all, *s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Class < all[j].Class
+	})
+	return all
+}
@@ -0,0 +1,230 @@
+// Package client talks to a remote ATLANT Node over its public REST API, and over its
+// private API with PrivateClient. It depends on the standard library only, so tools built
+// on it run without IPFS and badger.
+//
+// Paths of the routes and methods that decode plain responses are generated from the
+// routes table the node serves, see api/routes. Methods that stream bodies or read meta
+// from headers are written by hand on top of the generated path builders.
+package client
+
+//go:generate go run ./internal/clientgen -in ../api/routes/routes.go -out routes_gen.go
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("record not found")
+
+// APIError is returned when the node responds with an unexpected status.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if len(e.Message) == 0 {
+		return fmt.Sprintf("node responded with %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("node responded with %d: %s", e.StatusCode, e.Message)
+}
+
+// ObjectMeta mirrors the JSON encoding of record meta served by the node.
+type ObjectMeta struct {
+	ID              string `json:"id"`
+	Path            string `json:"path"`
+	CreatedAt       int64  `json:"createdAt"`
+	Version         string `json:"version"`
+	VersionPrevious string `json:"versionPrevious"`
+	IsDeleted       bool   `json:"isDeleted"`
+	Size            int64  `json:"size"`
+	UserMeta        string `json:"userMeta"`
+	// Signer is the node that signed the announce of the version, it's set from headers only.
+	Signer string `json:"-"`
+}
+
+type ListResponse struct {
+	Dirs  []string
+	Files []*ObjectMeta
+	// NextCursor continues a paginated listing, it's empty on the last page.
+	NextCursor string `json:",omitempty"`
+}
+
+type ListVersionsResponse struct {
+	ID       string        `json:"id"`
+	Versions []*ObjectMeta `json:"versions"`
+}
+
+type Client struct {
+	addr  string
+	token string
+	cli   *http.Client
+}
+
+// New returns a client of the node's public API at addr, e.g. http://localhost:33780.
+func New(addr string, timeout time.Duration) *Client {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+	return &Client{
+		addr: strings.TrimSuffix(addr, "/"),
+		cli: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// Get returns the content of a record, version is optional. The body must be closed by the caller.
+func (c *Client) Get(ctx context.Context, path, version string) (io.ReadCloser, *ObjectMeta, error) {
+	resp, err := c.do(ctx, "GET", contentPath(path, version), nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, metaFromHeader(resp.Header), nil
+}
+
+// Put creates or updates the record at path. Size is optional, userMeta must be valid JSON if set.
+func (c *Client) Put(ctx context.Context, path string, body io.Reader, size int64, userMeta string) (*ObjectMeta, error) {
+	header := make(http.Header)
+	if len(userMeta) > 0 {
+		header.Set("X-Meta-UserMeta", userMeta)
+	}
+	if size > 0 {
+		header.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	resp, err := c.do(ctx, "POST", putPath(path), header, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var meta *ObjectMeta
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		err = fmt.Errorf("failed to decode record meta: %v", err)
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (c *Client) Delete(ctx context.Context, id string) (*ObjectMeta, error) {
+	resp, err := c.do(ctx, "POST", deletePath(id), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return metaFromHeader(resp.Header), nil
+}
+
+// ListAll lists files and dirs right under the prefix in one response, see ListPage
+// for dirs too large for that.
+func (c *Client) ListAll(ctx context.Context, prefix string) (*ListResponse, error) {
+	return c.ListPage(ctx, prefix, "", 0)
+}
+
+func (c *Client) callString(ctx context.Context, method, path string) (string, error) {
+	resp, err := c.do(ctx, method, path, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	return string(data), err
+}
+
+func (c *Client) callRaw(ctx context.Context, method, path string) (json.RawMessage, error) {
+	resp, err := c.do(ctx, method, path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (c *Client) callJSON(ctx context.Context, method, path string, v interface{}) error {
+	resp, err := c.do(ctx, method, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		err = fmt.Errorf("failed to decode response: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	return c.send(ctx, c.cli, method, path, header, body)
+}
+
+func (c *Client) send(ctx context.Context, cli *http.Client, method, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.addr+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if v := header.Get("Content-Length"); len(v) > 0 {
+		req.ContentLength, _ = strconv.ParseInt(v, 10, 64)
+	}
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := cli.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == 404:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimPrefix(strings.TrimSpace(string(msg)), "error: "),
+		}
+	}
+	return resp, nil
+}
+
+func metaFromHeader(h http.Header) *ObjectMeta {
+	meta := &ObjectMeta{
+		ID:              h.Get("X-Meta-ID"),
+		Path:            h.Get("X-Meta-Path"),
+		Version:         h.Get("X-Meta-Version"),
+		VersionPrevious: h.Get("X-Meta-Previous"),
+		UserMeta:        h.Get("X-Meta-UserMeta"),
+		IsDeleted:       h.Get("X-Meta-Deleted") == "true",
+		Signer:          h.Get("X-Meta-Signer"),
+	}
+	meta.Size, _ = strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	if ts, err := http.ParseTime(h.Get("Last-Modified")); err == nil {
+		meta.CreatedAt = ts.UnixNano()
+	}
+	return meta
+}
+
+func escapePath(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Path: path}).EscapedPath()
+}
+
+func encodeQuery(q url.Values) string {
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
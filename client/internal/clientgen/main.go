@@ -0,0 +1,384 @@
+// Command clientgen generates path builders and typed methods of the client from the routes
+// table the api package serves, see api/routes/routes.go.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	in  = flag.String("in", "../api/routes/routes.go", "File with the Table var.")
+	out = flag.String("out", "routes_gen.go", "File to write.")
+)
+
+type param struct {
+	Name string
+	Arg  string
+	Type string
+}
+
+type spec struct {
+	Name    string
+	Method  string
+	Path    string
+	Private bool
+	Query   []string
+	Returns string
+	Doc     string
+
+	params []*param
+	query  []*param
+}
+
+func main() {
+	flag.Parse()
+	specs, err := parseTable(*in)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := checkSpecs(specs); err != nil {
+		log.Fatalln(err)
+	}
+	src, err := generate(specs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := ioutil.WriteFile(*out, src, 0644); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func parseTable(file string) ([]*spec, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	var list *ast.CompositeLit
+	ast.Inspect(f, func(n ast.Node) bool {
+		vs, ok := n.(*ast.ValueSpec)
+		if !ok || len(vs.Names) != 1 || vs.Names[0].Name != "Table" || len(vs.Values) != 1 {
+			return true
+		}
+		list, _ = vs.Values[0].(*ast.CompositeLit)
+		return false
+	})
+	if list == nil {
+		return nil, fmt.Errorf("%s: no Table var", file)
+	}
+	var specs []*spec
+	for _, elt := range list.Elts {
+		lit, ok := elt.(*ast.CompositeLit)
+		if !ok {
+			return nil, fmt.Errorf("%s: routes must be literals", fset.Position(elt.Pos()))
+		}
+		s := new(spec)
+		for _, field := range lit.Elts {
+			kv, ok := field.(*ast.KeyValueExpr)
+			if !ok {
+				return nil, fmt.Errorf("%s: route fields must be named", fset.Position(field.Pos()))
+			}
+			name := kv.Key.(*ast.Ident).Name
+			var err error
+			switch v := kv.Value.(type) {
+			case *ast.BasicLit:
+				err = s.set(name, v)
+			case *ast.Ident:
+				if name == "Private" {
+					s.Private = v.Name == "true"
+				}
+			case *ast.CompositeLit:
+				if name == "Query" {
+					for _, q := range v.Elts {
+						bl, ok := q.(*ast.BasicLit)
+						if !ok {
+							err = fmt.Errorf("query params must be string literals")
+							break
+						}
+						str, uerr := strconv.Unquote(bl.Value)
+						if uerr != nil {
+							err = uerr
+							break
+						}
+						s.Query = append(s.Query, str)
+					}
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", fset.Position(kv.Pos()), err)
+			}
+		}
+		specs = append(specs, s)
+	}
+	return specs, nil
+}
+
+func (s *spec) set(field string, v *ast.BasicLit) (err error) {
+	switch field {
+	case "Name":
+		s.Name, err = strconv.Unquote(v.Value)
+	case "Method":
+		s.Method, err = strconv.Unquote(v.Value)
+	case "Path":
+		s.Path, err = strconv.Unquote(v.Value)
+	case "Returns":
+		s.Returns, err = strconv.Unquote(v.Value)
+	case "Doc":
+		s.Doc, err = strconv.Unquote(v.Value)
+	}
+	return err
+}
+
+func checkSpecs(specs []*spec) error {
+	names := make(map[string]bool)
+	paths := make(map[string]string)
+	for _, s := range specs {
+		key := s.Method + " " + s.Path
+		if len(s.Name) == 0 || len(s.Path) == 0 || len(s.Doc) == 0 {
+			return fmt.Errorf("route %s must have Name, Path and Doc", key)
+		} else if name, ok := paths[key]; ok {
+			return fmt.Errorf("routes %s and %s share %s", name, s.Name, key)
+		} else if names[s.funcName()] {
+			return fmt.Errorf("route name %s is used twice", s.Name)
+		}
+		switch s.Method {
+		case "GET", "POST", "PUT", "DELETE":
+		default:
+			return fmt.Errorf("route %s has unsupported method %q", s.Name, s.Method)
+		}
+		switch {
+		case len(s.Returns) == 0, s.Returns == "string", s.Returns == "json.RawMessage":
+		case strings.HasPrefix(s.Returns, "*"):
+		default:
+			return fmt.Errorf("route %s must return a pointer, string or json.RawMessage", s.Name)
+		}
+		if err := s.parseParams(); err != nil {
+			return fmt.Errorf("route %s: %v", s.Name, err)
+		}
+		names[s.funcName()] = true
+		paths[key] = s.Name
+	}
+	return nil
+}
+
+// parseParams collects arguments of the client method from the path and query params.
+func (s *spec) parseParams() error {
+	args := make(map[string]bool)
+	segments := strings.Split(s.Path, "/")
+	for i, seg := range segments {
+		if !strings.HasPrefix(seg, ":") && !strings.HasPrefix(seg, "*") {
+			continue
+		} else if seg[0] == '*' && i != len(segments)-1 {
+			return fmt.Errorf("wildcard %s must end the path", seg)
+		}
+		p := &param{
+			Name: seg,
+			Arg:  seg[1:],
+			Type: "string",
+		}
+		if args[p.Arg] {
+			return fmt.Errorf("argument %s is used twice", p.Arg)
+		}
+		args[p.Arg] = true
+		s.params = append(s.params, p)
+	}
+	for _, q := range s.Query {
+		fields := strings.Fields(q)
+		if len(fields) == 0 || len(fields) > 2 {
+			return fmt.Errorf("malformed query param %q", q)
+		}
+		p := &param{
+			Name: fields[0],
+			Arg:  fields[0],
+			Type: "string",
+		}
+		if i := strings.Index(p.Name, ":"); i >= 0 {
+			p.Name, p.Arg = p.Name[:i], p.Name[i+1:]
+		}
+		if len(fields) == 2 {
+			p.Type = fields[1]
+		}
+		switch p.Type {
+		case "string", "int", "[]string":
+		default:
+			return fmt.Errorf("query param %s has unsupported type %s", p.Name, p.Type)
+		}
+		if len(p.Name) == 0 || len(p.Arg) == 0 {
+			return fmt.Errorf("malformed query param %q", q)
+		} else if args[p.Arg] {
+			return fmt.Errorf("argument %s is used twice", p.Arg)
+		}
+		args[p.Arg] = true
+		s.query = append(s.query, p)
+	}
+	return nil
+}
+
+// funcName is the name of the path builder of the route.
+func (s *spec) funcName() string {
+	name := s.Name
+	if s.Private {
+		name = "Private" + name
+	}
+	r := []rune(name)
+	r[0] = unicode.ToLower(r[0])
+	return string(r) + "Path"
+}
+
+// signature lists arguments of the route with their types, neighbours of a type are grouped.
+func (s *spec) signature() string {
+	all := append(append([]*param{}, s.params...), s.query...)
+	var parts []string
+	for i, p := range all {
+		if i+1 < len(all) && all[i+1].Type == p.Type {
+			parts = append(parts, p.Arg)
+			continue
+		}
+		parts = append(parts, p.Arg+" "+p.Type)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (s *spec) args() string {
+	var args []string
+	for _, p := range s.params {
+		args = append(args, p.Arg)
+	}
+	for _, p := range s.query {
+		args = append(args, p.Arg)
+	}
+	return strings.Join(args, ", ")
+}
+
+// writeDoc writes the doc as a comment wrapped to a readable width.
+func writeDoc(buf *bytes.Buffer, doc string) {
+	line := "//"
+	for _, word := range strings.Fields(doc) {
+		if len(line)+1+len(word) > 92 && line != "//" {
+			fmt.Fprintln(buf, line)
+			line = "//"
+		}
+		line += " " + word
+	}
+	fmt.Fprintln(buf, line)
+}
+
+func generate(specs []*spec) ([]byte, error) {
+	var usesJSON, usesStrconv, usesURL bool
+	body := new(bytes.Buffer)
+	for _, s := range specs {
+		fmt.Fprintln(body)
+		api := "public"
+		if s.Private {
+			api = "private"
+		}
+		fmt.Fprintf(body, "// %s returns the path of %s %s of the %s API.\n", s.funcName(), s.Method, s.Path, api)
+		fmt.Fprintf(body, "func %s(%s) string {\n", s.funcName(), s.signature())
+		if len(s.query) > 0 {
+			usesURL = true
+			fmt.Fprintln(body, "\tq := make(url.Values)")
+			for _, p := range s.query {
+				switch p.Type {
+				case "string":
+					fmt.Fprintf(body, "\tif len(%s) > 0 {\n", p.Arg)
+					fmt.Fprintf(body, "\t\tq.Set(%q, %s)\n", p.Name, p.Arg)
+				case "int":
+					usesStrconv = true
+					fmt.Fprintf(body, "\tif %s != 0 {\n", p.Arg)
+					fmt.Fprintf(body, "\t\tq.Set(%q, strconv.Itoa(%s))\n", p.Name, p.Arg)
+				case "[]string":
+					fmt.Fprintf(body, "\tfor _, v := range %s {\n", p.Arg)
+					fmt.Fprintf(body, "\t\tq.Add(%q, v)\n", p.Name)
+				}
+				fmt.Fprintln(body, "\t}")
+			}
+		}
+		var expr []string
+		var literal string
+		for _, seg := range strings.Split(strings.TrimPrefix(s.Path, "/"), "/") {
+			switch {
+			case strings.HasPrefix(seg, ":"):
+				usesURL = true
+				expr = append(expr, strconv.Quote(literal+"/"), "url.PathEscape("+seg[1:]+")")
+				literal = ""
+			case strings.HasPrefix(seg, "*"):
+				expr = append(expr, strconv.Quote(literal), "escapePath("+seg[1:]+")")
+				literal = ""
+			default:
+				literal += "/" + seg
+			}
+		}
+		if len(literal) > 0 {
+			expr = append(expr, strconv.Quote(literal))
+		}
+		if len(s.query) > 0 {
+			expr = append(expr, "encodeQuery(q)")
+		}
+		fmt.Fprintf(body, "\treturn %s\n", strings.Join(expr, " + "))
+		fmt.Fprintln(body, "}")
+		if len(s.Returns) == 0 {
+			continue
+		}
+
+		recv, cli := "c *Client", "c"
+		if s.Private {
+			recv, cli = "p *PrivateClient", "p.c"
+		}
+		sig := s.signature()
+		if len(sig) > 0 {
+			sig = ", " + sig
+		}
+		fmt.Fprintln(body)
+		writeDoc(body, fmt.Sprintf("%s %s.", s.Name, strings.TrimSuffix(s.Doc, ".")))
+		fmt.Fprintf(body, "func (%s) %s(ctx context.Context%s) (%s, error) {\n", recv, s.Name, sig, s.Returns)
+		switch s.Returns {
+		case "string":
+			fmt.Fprintf(body, "\treturn %s.callString(ctx, %q, %s(%s))\n", cli, s.Method, s.funcName(), s.args())
+		case "json.RawMessage":
+			usesJSON = true
+			fmt.Fprintf(body, "\treturn %s.callRaw(ctx, %q, %s(%s))\n", cli, s.Method, s.funcName(), s.args())
+		default:
+			fmt.Fprintf(body, "\tvar v %s\n", s.Returns)
+			fmt.Fprintf(body, "\tif err := %s.callJSON(ctx, %q, %s(%s), &v); err != nil {\n", cli, s.Method, s.funcName(), s.args())
+			fmt.Fprintln(body, "\t\treturn nil, err")
+			fmt.Fprintln(body, "\t}")
+			fmt.Fprintln(body, "\treturn v, nil")
+		}
+		fmt.Fprintln(body, "}")
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "// Code generated by clientgen from api/routes/routes.go. DO NOT EDIT.")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "package client")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "import (")
+	fmt.Fprintln(buf, "\t\"context\"")
+	if usesJSON {
+		fmt.Fprintln(buf, "\t\"encoding/json\"")
+	}
+	if usesURL {
+		fmt.Fprintln(buf, "\t\"net/url\"")
+	}
+	if usesStrconv {
+		fmt.Fprintln(buf, "\t\"strconv\"")
+	}
+	fmt.Fprintln(buf, ")")
+	buf.Write(body.Bytes())
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %v\n%s", err, strings.TrimSpace(buf.String()))
+	}
+	return src, nil
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// PrivateClient talks to the private API of a node, it's served on the loopback interface
+// at the address the node writes to private-api.json in its state dir, the token is in
+// private-api.token next to it.
+type PrivateClient struct {
+	c *Client
+}
+
+// NewPrivate returns a client of the node's private API at addr authorized with the token.
+func NewPrivate(addr, token string, timeout time.Duration) *PrivateClient {
+	c := New(addr, timeout)
+	c.token = token
+	return &PrivateClient{
+		c: c,
+	}
+}
+
+// NodeStatus mirrors the JSON encoding of the node status. Parts that change with the
+// node version are kept raw.
+type NodeStatus struct {
+	NodeID    string      `json:"node_id"`
+	SessionID string      `json:"session_id"`
+	Version   string      `json:"version"`
+	Network   string      `json:"network"`
+	Protocol  uint16      `json:"protocol_version"`
+	Uptime    string      `json:"uptime"`
+	Ready     bool        `json:"ready"`
+	Peers     int         `json:"peers"`
+	Queues    *QueueStats `json:"queues"`
+	Sync      *SyncStats  `json:"sync"`
+	Beats     *BeatStats  `json:"beats"`
+	// StateDamage is set if the state store runs degraded after a recovery.
+	StateDamage json.RawMessage `json:"state_damage,omitempty"`
+	Reindex     json.RawMessage `json:"reindex,omitempty"`
+	SwarmKey    json.RawMessage `json:"swarm_key,omitempty"`
+	NAT         json.RawMessage `json:"nat,omitempty"`
+}
+
+type QueueStats struct {
+	InboundDepth  int64 `json:"inbound_depth"`
+	OutboundDepth int64 `json:"outbound_depth"`
+}
+
+type SyncStats struct {
+	Running  bool   `json:"running"`
+	Syncs    uint64 `json:"syncs_total"`
+	Failures uint64 `json:"failures_total"`
+}
+
+type BeatStats struct {
+	LastTick     *time.Time `json:"last_tick,omitempty"`
+	LastInfo     *time.Time `json:"last_info,omitempty"`
+	TickInterval string     `json:"tick_interval"`
+}
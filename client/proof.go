@@ -0,0 +1,78 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrProofMismatch = errors.New("proof doesn't lead to the Merkle root")
+
+// ProofEntry is the manifest entry of a record, the leaf of the proof.
+type ProofEntry struct {
+	Path    string `json:"path"`
+	ID      string `json:"id"`
+	Version string `json:"cid"`
+	SHA256  string `json:"sha256"`
+	Size    int64  `json:"size"`
+}
+
+// ProofStep is a sibling on the path from the leaf to the root, Left is set if it's the left child.
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left,omitempty"`
+}
+
+// Proof is the Merkle inclusion proof of a record in a manifest checkpoint of its namespace.
+type Proof struct {
+	Entry       *ProofEntry  `json:"entry"`
+	Checkpoint  string       `json:"checkpoint"`
+	NodeID      string       `json:"node_id"`
+	GeneratedAt time.Time    `json:"generated_at"`
+	Root        string       `json:"merkle_root"`
+	Signature   string       `json:"signature"`
+	Index       int          `json:"leaf_index"`
+	Leaves      int          `json:"leaves"`
+	Steps       []*ProofStep `json:"steps"`
+}
+
+// VerifyProof checks that the entry of the proof is included in the tree with the given root,
+// a hex sha256 the caller trusts, e.g. of a signed or anchored checkpoint. The root served
+// with the proof is not trusted. Content may be checked against SHA256 of the entry then.
+func VerifyProof(proof *Proof, root string) error {
+	if proof == nil || proof.Entry == nil {
+		return errors.New("proof has no entry")
+	}
+	want, err := hex.DecodeString(root)
+	if err != nil {
+		return fmt.Errorf("malformed root: %v", err)
+	}
+	e := proof.Entry
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	fmt.Fprintf(h, "%s\t%s\t%s\t%d", e.Path, e.Version, e.SHA256, e.Size)
+	node := h.Sum(nil)
+	for _, step := range proof.Steps {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return fmt.Errorf("malformed proof step: %v", err)
+		}
+		h := sha256.New()
+		h.Write([]byte{0x01})
+		if step.Left {
+			h.Write(sibling)
+			h.Write(node)
+		} else {
+			h.Write(node)
+			h.Write(sibling)
+		}
+		node = h.Sum(nil)
+	}
+	if !bytes.Equal(node, want) {
+		return ErrProofMismatch
+	}
+	return nil
+}
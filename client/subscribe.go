@@ -0,0 +1,120 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxEventSize limits a line of the event stream.
+const maxEventSize = 1024 * 1024
+
+// RecordChange mirrors the JSON encoding of a record change streamed by the node.
+type RecordChange struct {
+	// Op is the name of the event, create, update or delete.
+	Op              string    `json:"-"`
+	ID              string    `json:"id"`
+	Path            string    `json:"path"`
+	Version         string    `json:"version"`
+	VersionPrevious string    `json:"version_previous,omitempty"`
+	NodeID          string    `json:"node_id"`
+	Time            time.Time `json:"time"`
+	// Changed lists the filters whose selected values differ between the versions.
+	Changed []string `json:"changed,omitempty"`
+}
+
+// Subscription is a stream of record changes, C is closed when the stream ends.
+type Subscription struct {
+	C <-chan *RecordChange
+
+	cancelFn func()
+	errMux   sync.Mutex
+	err      error
+}
+
+// Close ends the stream.
+func (s *Subscription) Close() {
+	s.cancelFn()
+}
+
+// Err returns the error the stream ended with, it's nil while C is open and after Close.
+func (s *Subscription) Err() error {
+	s.errMux.Lock()
+	defer s.errMux.Unlock()
+	return s.err
+}
+
+// Subscribe streams changes of records under any of the prefixes, all records if there are
+// none. Filters are JSONPath expressions of record content, changes list the filters whose
+// values changed. The stream isn't limited by the timeout of the client, it ends with the
+// context, Close or the route timeout of the node.
+func (c *Client) Subscribe(ctx context.Context, prefixes, filters []string) (*Subscription, error) {
+	ctx, cancelFn := context.WithCancel(ctx)
+	cli := &http.Client{
+		Transport: c.cli.Transport,
+	}
+	resp, err := c.send(ctx, cli, "GET", subscribePath(prefixes, filters), nil, nil)
+	if err != nil {
+		cancelFn()
+		return nil, err
+	}
+	changes := make(chan *RecordChange)
+	sub := &Subscription{
+		C:        changes,
+		cancelFn: cancelFn,
+	}
+	go func() {
+		defer close(changes)
+		defer resp.Body.Close()
+		err := readEvents(ctx, resp, changes)
+		if ctx.Err() == nil {
+			sub.errMux.Lock()
+			sub.err = err
+			sub.errMux.Unlock()
+		}
+		cancelFn()
+	}()
+	return sub, nil
+}
+
+// readEvents decodes Server-Sent Events of the response, comments are heartbeats.
+func readEvents(ctx context.Context, resp *http.Response, changes chan<- *RecordChange) error {
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 4096), maxEventSize)
+	var event, data string
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case len(line) == 0:
+			if len(data) == 0 {
+				event = ""
+				continue
+			}
+			change := &RecordChange{}
+			if err := json.Unmarshal([]byte(data), change); err != nil {
+				return fmt.Errorf("failed to decode record change: %v", err)
+			}
+			change.Op = event
+			event, data = "", ""
+			select {
+			case changes <- change:
+			case <-ctx.Done():
+				return nil
+			}
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if len(data) > 0 {
+				data += "\n"
+			}
+			data += strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		}
+	}
+	return sc.Err()
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"os"
-	"strings"
 
 	cli "github.com/jawher/mow.cli"
 	log "github.com/sirupsen/logrus"
@@ -22,11 +21,10 @@ func main() {
 
 func netKeyCmd(c *cli.Cmd) {
 	c.Action = func() {
-		data, err := fs.NewPrivateKey()
+		key, err := fs.NewSwarmKey()
 		if err != nil {
 			log.Fatalln("failed to generate IPFS key:", err)
 		}
-		parts := strings.Split(string(data), "\n")
-		fmt.Println(parts[len(parts)-1])
+		fmt.Println(key)
 	}
 }
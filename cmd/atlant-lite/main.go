@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	cli "github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/client"
+)
+
+var app = cli.App("atlant-lite", "Thin client for a remote ATLANT Node, runs without IPFS and the state store.")
+
+var (
+	remoteAddr = app.String(cli.StringOpt{
+		Name:   "r remote",
+		Desc:   "Address of the remote node's public API.",
+		EnvVar: "AN_REMOTE",
+		Value:  "http://localhost:33780",
+	})
+	timeout = app.String(cli.StringOpt{
+		Name:   "t timeout",
+		Desc:   "Timeout of a single request to the remote node.",
+		EnvVar: "AN_REMOTE_TIMEOUT",
+		Value:  "5m",
+	})
+)
+
+func main() {
+	app.Command("ping", "Check that the remote node is reachable.", pingCmd)
+	app.Command("version", "Show version of the remote node.", versionCmd)
+	app.Command("stats", "Show stats of the remote node.", statsCmd)
+	app.Command("get", "Read content of a record.", getCmd)
+	app.Command("meta", "Show meta of a record.", metaCmd)
+	app.Command("put", "Create or update a record.", putCmd)
+	app.Command("delete", "Delete a record by its ID.", deleteCmd)
+	app.Command("ls", "List records under a prefix.", lsCmd)
+	app.Command("versions", "List versions of a record.", versionsCmd)
+	if err := app.Run(os.Args); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func newClient() *client.Client {
+	dur, err := time.ParseDuration(*timeout)
+	if err != nil {
+		dur = 5 * time.Minute
+	}
+	return client.New(*remoteAddr, dur)
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func pingCmd(c *cli.Cmd) {
+	c.Action = func() {
+		nodeID, err := newClient().Ping(context.Background())
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Println(nodeID)
+	}
+}
+
+func versionCmd(c *cli.Cmd) {
+	c.Action = func() {
+		version, err := newClient().Version(context.Background())
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Println(version)
+	}
+}
+
+func statsCmd(c *cli.Cmd) {
+	c.Action = func() {
+		stats, err := newClient().Stats(context.Background())
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Println(string(stats))
+	}
+}
+
+func getCmd(c *cli.Cmd) {
+	c.Spec = "[--ver] [-o] PATH"
+	version := c.StringOpt("ver", "", "Version of the record to read.")
+	output := c.StringOpt("o output", "", "Write content to a file instead of stdout.")
+	path := c.StringArg("PATH", "", "Path of the record.")
+	c.Action = func() {
+		body, _, err := newClient().Get(context.Background(), *path, *version)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer body.Close()
+		var out io.Writer = os.Stdout
+		if len(*output) > 0 {
+			f, err := os.Create(*output)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer f.Close()
+			out = f
+		}
+		if _, err := io.Copy(out, body); err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+func metaCmd(c *cli.Cmd) {
+	c.Spec = "[--ver] PATH"
+	version := c.StringOpt("ver", "", "Version of the record.")
+	path := c.StringArg("PATH", "", "Path of the record.")
+	c.Action = func() {
+		meta, err := newClient().Meta(context.Background(), *path, *version)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printJSON(meta)
+	}
+}
+
+func putCmd(c *cli.Cmd) {
+	c.Spec = "[--meta] PATH [FILE]"
+	userMeta := c.StringOpt("meta", "", "JSON encoded user meta of the record.")
+	path := c.StringArg("PATH", "", "Path of the record.")
+	file := c.StringArg("FILE", "", "File to upload, stdin is used if omitted.")
+	c.Action = func() {
+		var body io.Reader = os.Stdin
+		var size int64
+		if len(*file) > 0 {
+			f, err := os.Open(*file)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer f.Close()
+			if info, err := f.Stat(); err == nil {
+				size = info.Size()
+			}
+			body = f
+		}
+		if len(*userMeta) > 0 && !json.Valid([]byte(*userMeta)) {
+			log.Fatalln("user meta is not a valid JSON")
+		}
+		meta, err := newClient().Put(context.Background(), *path, body, size, *userMeta)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printJSON(meta)
+	}
+}
+
+func deleteCmd(c *cli.Cmd) {
+	c.Spec = "ID"
+	id := c.StringArg("ID", "", "ID of the record.")
+	c.Action = func() {
+		meta, err := newClient().Delete(context.Background(), *id)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printJSON(meta)
+	}
+}
+
+func lsCmd(c *cli.Cmd) {
+	c.Spec = "[PREFIX]"
+	prefix := c.StringArg("PREFIX", "/", "Path prefix to list.")
+	c.Action = func() {
+		list, err := newClient().ListAll(context.Background(), *prefix)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, dir := range list.Dirs {
+			fmt.Println(dir)
+		}
+		for _, f := range list.Files {
+			fmt.Printf("%s\t%s\t%d\n", f.Path, f.ID, f.Size)
+		}
+	}
+}
+
+func versionsCmd(c *cli.Cmd) {
+	c.Spec = "PATH"
+	path := c.StringArg("PATH", "", "Path of the record.")
+	c.Action = func() {
+		list, err := newClient().ListVersions(context.Background(), *path)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printJSON(list)
+	}
+}
@@ -29,6 +29,66 @@ var (
 		EnvVar: "AN_STATE_DIR",
 		Value:  "var/state",
 	})
+	stateBackend = app.String(cli.StringOpt{
+		Name:   "state-backend",
+		Desc:   "Backend of the state store: badger, bolt or memory (the state is lost on exit).",
+		EnvVar: "AN_STATE_BACKEND",
+		Value:  "badger",
+	})
+	stateMaxValueSize = app.String(cli.StringOpt{
+		Name:   "state-max-value-size",
+		Desc:   "Maximum size of a value in the state store (bytes), larger writes are rejected.",
+		EnvVar: "AN_STATE_MAX_VALUE_SIZE",
+		Value:  "33554432",
+	})
+	stateConflictRetries = app.String(cli.StringOpt{
+		Name:   "state-conflict-retries",
+		Desc:   "Sets how many times a conflicting state transaction is retried before failing.",
+		EnvVar: "AN_STATE_CONFLICT_RETRIES",
+		Value:  "5",
+	})
+	statePessimisticBuckets = app.String(cli.StringOpt{
+		Name:   "state-pessimistic-buckets",
+		Desc:   "Comma-separated state buckets where writes are serialized instead of retried on conflicts, e.g. records,checksums.",
+		EnvVar: "AN_STATE_PESSIMISTIC_BUCKETS",
+		Value:  "",
+	})
+	stateRecover = app.String(cli.StringOpt{
+		Name:   "state-recover",
+		Desc:   "Salvages a damaged badger state store on start and runs degraded instead of failing.",
+		EnvVar: "AN_STATE_RECOVER",
+		Value:  "true",
+	})
+	stateGCInterval = app.String(cli.StringOpt{
+		Name:   "state-gc-interval",
+		Desc:   "Interval of value log GC of the badger state store, which returns space of stale values to the disk. 0 disables it.",
+		EnvVar: "AN_STATE_GC_INTERVAL",
+		Value:  "10m",
+	})
+	stateSpareDir = app.String(cli.StringOpt{
+		Name:   "state-spare-dir",
+		Desc:   "Directory of a warm spare of the badger state store, a damaged store is restored from it on start.",
+		EnvVar: "AN_STATE_SPARE_DIR",
+		Value:  "",
+	})
+	stateSpareInterval = app.String(cli.StringOpt{
+		Name:   "state-spare-interval",
+		Desc:   "Interval of refreshes of the warm spare of the state store.",
+		EnvVar: "AN_STATE_SPARE_INTERVAL",
+		Value:  "15m",
+	})
+	stateChecksums = app.String(cli.StringOpt{
+		Name:   "state-checksums",
+		Desc:   "Write values of the badger state store with checksums, which are verified on reads.",
+		EnvVar: "AN_STATE_CHECKSUMS",
+		Value:  "true",
+	})
+	stateEncryptBuckets = app.String(cli.StringOpt{
+		Name:   "state-encrypt-buckets",
+		Desc:   "Comma-separated state buckets sealed with AES-GCM under a key wrapped with the node identity, * for all of them.",
+		EnvVar: "AN_STATE_ENCRYPT_BUCKETS",
+		Value:  "",
+	})
 	fsDir = app.String(cli.StringOpt{
 		Name:   "F fs-dir",
 		Desc:   "Directory prefix for IPFS filesystem storage.",
@@ -41,6 +101,30 @@ var (
 		EnvVar: "AN_LOG_DIR",
 		Value:  "var/log",
 	})
+	logFormat = app.String(cli.StringOpt{
+		Name:   "log-format",
+		Desc:   "Format of log output: text or json.",
+		EnvVar: "AN_LOG_FORMAT",
+		Value:  "text",
+	})
+	logModules = app.String(cli.StringOpt{
+		Name:   "log-modules",
+		Desc:   "Comma-separated log levels of modules (api, rs, fs, state, contracts, authcenter), e.g. rs=debug,fs=warning.",
+		EnvVar: "AN_LOG_MODULES",
+		Value:  "",
+	})
+	logShip = app.String(cli.StringOpt{
+		Name:   "log-ship",
+		Desc:   "Comma-separated log sinks: syslog (local), syslog://host:514, syslog+tcp://host:601, loki+http://host:3100 or loki+https://host.",
+		EnvVar: "AN_LOG_SHIP",
+		Value:  "",
+	})
+	logShipLevel = app.String(cli.StringOpt{
+		Name:   "log-ship-level",
+		Desc:   "Minimum level of entries shipped to log sinks: debug, info, warning or error.",
+		EnvVar: "AN_LOG_SHIP_LEVEL",
+		Value:  "info",
+	})
 	fsBootstrapPeers = app.Strings(cli.StringsOpt{
 		Name:      "B bootstrap-peers",
 		Desc:      "Append to the list of IPFS bootstrap peers.",
@@ -54,6 +138,12 @@ var (
 		EnvVar: "AN_FS_RELAY_ENABLED",
 		Value:  "true",
 	})
+	fsAutoMigrate = app.String(cli.StringOpt{
+		Name:   "fs-auto-migrate",
+		Desc:   "Migrate an outdated IPFS repo on start with fs-repo-migrations, its config is backed up first.",
+		EnvVar: "AN_FS_AUTO_MIGRATE",
+		Value:  "true",
+	})
 	fsWarmupDur = app.String(cli.StringOpt{
 		Name:   "warmup",
 		Desc:   "Allocate some time for IPFS to warmup and find peers.",
@@ -62,16 +152,322 @@ var (
 	})
 	fsListenAddr = app.String(cli.StringOpt{
 		Name:   "L fs-listen-addr",
-		Desc:   "Sets IPFS listen address to communicate with peers.",
+		Desc:   "Sets IPFS listen address to communicate with peers, e.g. [::]:33770 for IPv6.",
 		EnvVar: "AN_FS_LISTEN_ADDR",
 		Value:  "0.0.0.0:33770",
 	})
 	webListenAddr = app.String(cli.StringOpt{
 		Name:   "W web-listen-addr",
-		Desc:   "Sets webserver listen address for public API.",
+		Desc:   "Sets webserver listen addresses for public API, comma-separated host:port, unix:path or multiaddrs.",
 		EnvVar: "AN_WEB_LISTEN_ADDR",
 		Value:  "0.0.0.0:33780",
 	})
+	metricsListenAddr = app.String(cli.StringOpt{
+		Name:   "metrics-listen-addr",
+		Desc:   "Serves Prometheus metrics of the private API at this address, e.g. 0.0.0.0:33790.",
+		EnvVar: "AN_METRICS_LISTEN_ADDR",
+		Value:  "",
+	})
+	otlpEndpoint = app.String(cli.StringOpt{
+		Name:   "otlp-endpoint",
+		Desc:   "Exports traces of requests, writes and announces to this OTLP/HTTP collector, e.g. http://127.0.0.1:4318.",
+		EnvVar: "AN_OTLP_ENDPOINT",
+		Value:  "",
+	})
+	traceSample = app.String(cli.StringOpt{
+		Name:   "trace-sample",
+		Desc:   "Fraction of traces started by this node that are exported, traces of peers and clients follow their sampling.",
+		EnvVar: "AN_TRACE_SAMPLE",
+		Value:  "1",
+	})
+	grpcListenAddr = app.String(cli.StringOpt{
+		Name:   "grpc-listen-addr",
+		Desc:   "Serves the gRPC API of records and its JSON gateway at this address, e.g. 127.0.0.1:33800.",
+		EnvVar: "AN_GRPC_LISTEN_ADDR",
+		Value:  "",
+	})
+	tlsCertFile = app.String(cli.StringOpt{
+		Name:   "tls-cert",
+		Desc:   "Serves the public API over TLS using this certificate file, it's reloaded once changed.",
+		EnvVar: "AN_TLS_CERT",
+		Value:  "",
+	})
+	tlsKeyFile = app.String(cli.StringOpt{
+		Name:   "tls-key",
+		Desc:   "Private key file of the TLS certificate.",
+		EnvVar: "AN_TLS_KEY",
+		Value:  "",
+	})
+	tlsACMEDomains = app.String(cli.StringOpt{
+		Name:   "tls-acme-domains",
+		Desc:   "Comma-separated domains to obtain TLS certificates for from Let's Encrypt, instead of certificate files.",
+		EnvVar: "AN_TLS_ACME_DOMAINS",
+		Value:  "",
+	})
+	tlsACMEEmail = app.String(cli.StringOpt{
+		Name:   "tls-acme-email",
+		Desc:   "Contact email for Let's Encrypt notifications.",
+		EnvVar: "AN_TLS_ACME_EMAIL",
+		Value:  "",
+	})
+	tlsACMECacheDir = app.String(cli.StringOpt{
+		Name:   "tls-acme-cache",
+		Desc:   "Directory to keep obtained certificates in, defaults to acme in the state dir.",
+		EnvVar: "AN_TLS_ACME_CACHE",
+		Value:  "",
+	})
+	tlsACMEHTTPAddr = app.String(cli.StringOpt{
+		Name:   "tls-acme-http-addr",
+		Desc:   "Serves HTTP-01 challenges of Let's Encrypt at this address, e.g. 0.0.0.0:80.",
+		EnvVar: "AN_TLS_ACME_HTTP_ADDR",
+		Value:  "",
+	})
+	tlsClientAuth = app.String(cli.StringOpt{
+		Name:   "tls-client-auth",
+		Desc:   "Client certificates of the public API. Available: none, optional, require, authority (only nodes listed in the authority).",
+		EnvVar: "AN_TLS_CLIENT_AUTH",
+		Value:  "none",
+	})
+	tlsClientCAFile = app.String(cli.StringOpt{
+		Name:   "tls-client-ca",
+		Desc:   "CA certificates file to verify client certificates with.",
+		EnvVar: "AN_TLS_CLIENT_CA",
+		Value:  "",
+	})
+	apiTimeout = app.String(cli.StringOpt{
+		Name:   "api-timeout",
+		Desc:   "Sets the default timeout of API requests, 0 means no timeout. Requests are cancelled anyway once the client goes away.",
+		EnvVar: "AN_API_TIMEOUT",
+		Value:  "0",
+	})
+	apiRouteTimeouts = app.String(cli.StringOpt{
+		Name:   "api-route-timeouts",
+		Desc:   "Comma-separated per-route timeouts by path prefix, e.g. /api/v1/put=10m,/api/v1/content=30m.",
+		EnvVar: "AN_API_ROUTE_TIMEOUTS",
+		Value:  "",
+	})
+	apiProxyRoutes = app.String(cli.StringOpt{
+		Name:   "api-proxy-routes",
+		Desc:   "Comma-separated path prefixes of the public API to forward to legacy services, e.g. /kyc=http://10.0.0.5:8080. Routes of the node take precedence.",
+		EnvVar: "AN_API_PROXY_ROUTES",
+		Value:  "",
+	})
+	apiClientHeader = app.String(cli.StringOpt{
+		Name:   "api-client-header",
+		Desc:   "Header identifying clients for rate limits of namespaces, e.g. X-Real-IP. Set it only behind a trusted proxy, the remote address is used otherwise.",
+		EnvVar: "AN_API_CLIENT_HEADER",
+		Value:  "",
+	})
+	corsOrigins = app.String(cli.StringOpt{
+		Name:   "cors-origins",
+		Desc:   "Comma-separated origins of browser apps allowed to call the public API, e.g. https://app.example.com, * allows any. CORS is off if empty.",
+		EnvVar: "AN_CORS_ORIGINS",
+		Value:  "",
+	})
+	corsMethods = app.String(cli.StringOpt{
+		Name:   "cors-methods",
+		Desc:   "Comma-separated methods allowed to the CORS origins.",
+		EnvVar: "AN_CORS_METHODS",
+		Value:  "GET,HEAD,POST,PUT,DELETE",
+	})
+	corsHeaders = app.String(cli.StringOpt{
+		Name:   "cors-headers",
+		Desc:   "Comma-separated request headers allowed to the CORS origins, the ones the public API reads by default.",
+		EnvVar: "AN_CORS_HEADERS",
+		Value:  "",
+	})
+	corsCredentials = app.String(cli.StringOpt{
+		Name:   "cors-credentials",
+		Desc:   "Allows the CORS origins to send cookies and the Authorization header of the browser.",
+		EnvVar: "AN_CORS_CREDENTIALS",
+		Value:  "false",
+	})
+	corsMaxAge = app.String(cli.StringOpt{
+		Name:   "cors-max-age",
+		Desc:   "How long browsers may cache answers of preflight requests.",
+		EnvVar: "AN_CORS_MAX_AGE",
+		Value:  "10m",
+	})
+	apiRate = app.String(cli.StringOpt{
+		Name:   "api-rate",
+		Desc:   "Requests per second a client address may make to the public API, 0 means no limit.",
+		EnvVar: "AN_API_RATE",
+		Value:  "0",
+	})
+	apiBurst = app.String(cli.StringOpt{
+		Name:   "api-burst",
+		Desc:   "Requests a client address may make at once above --api-rate, twice the rate by default.",
+		EnvVar: "AN_API_BURST",
+		Value:  "0",
+	})
+	apiMaxStreams = app.String(cli.StringOpt{
+		Name:   "api-max-streams",
+		Desc:   "Requests of a client address the public API serves at once, including subscriptions, 0 means no limit.",
+		EnvVar: "AN_API_MAX_STREAMS",
+		Value:  "0",
+	})
+	apiMaxTokenStreams = app.String(cli.StringOpt{
+		Name:   "api-max-token-streams",
+		Desc:   "Requests of a token (bearer token or X-Eth-Address) the public API serves at once, 0 means no limit.",
+		EnvVar: "AN_API_MAX_TOKEN_STREAMS",
+		Value:  "0",
+	})
+	apiMaxSubscriptions = app.String(cli.StringOpt{
+		Name:   "api-max-subscriptions",
+		Desc:   "Event streams and WebSockets a client address may keep open, 0 means no limit.",
+		EnvVar: "AN_API_MAX_SUBSCRIPTIONS",
+		Value:  "0",
+	})
+	apiMaxTokenSubscriptions = app.String(cli.StringOpt{
+		Name:   "api-max-token-subscriptions",
+		Desc:   "Event streams and WebSockets a token may keep open, 0 means no limit.",
+		EnvVar: "AN_API_MAX_TOKEN_SUBSCRIPTIONS",
+		Value:  "0",
+	})
+	apiMaxBody = app.String(cli.StringOpt{
+		Name:   "api-max-body",
+		Desc:   "Max size of request bodies of the public API in bytes, 0 means no limit.",
+		EnvVar: "AN_API_MAX_BODY",
+		Value:  "0",
+	})
+	apiWriteQuota = app.String(cli.StringOpt{
+		Name:   "api-write-quota",
+		Desc:   "Writes an Ethereum address (X-Eth-Address header, or the client address) may make, e.g. 1000/24h.",
+		EnvVar: "AN_API_WRITE_QUOTA",
+		Value:  "",
+	})
+	writeMinBalance = app.String(cli.StringOpt{
+		Name:   "write-min-atl",
+		Desc:   "ATL balance the Ethereum address of a public write (X-Eth-Address header) must hold.",
+		EnvVar: "AN_WRITE_MIN_ATL",
+		Value:  "",
+	})
+	writeRequireKYC = app.String(cli.StringOpt{
+		Name:   "write-require-kyc",
+		Desc:   "Accept public writes of Ethereum addresses approved by the KYC registry only.",
+		EnvVar: "AN_WRITE_REQUIRE_KYC",
+		Value:  "false",
+	})
+	writeGateTTL = app.String(cli.StringOpt{
+		Name:   "write-gate-ttl",
+		Desc:   "How long on-chain checks of writers are kept, admissions and refusals, e.g. 10m,1m.",
+		EnvVar: "AN_WRITE_GATE_TTL",
+		Value:  "10m,1m",
+	})
+	shadowTarget = app.String(cli.StringOpt{
+		Name:   "shadow-target",
+		Desc:   "Base URL of the public API of a staging node to mirror a sample of read requests to.",
+		EnvVar: "AN_SHADOW_TARGET",
+		Value:  "",
+	})
+	shadowFraction = app.String(cli.StringOpt{
+		Name:   "shadow-fraction",
+		Desc:   "Fraction of read requests mirrored to the staging node, between 0 and 1.",
+		EnvVar: "AN_SHADOW_FRACTION",
+		Value:  "0.01",
+	})
+	shadowTimeout = app.String(cli.StringOpt{
+		Name:   "shadow-timeout",
+		Desc:   "Timeout of requests mirrored to the staging node.",
+		EnvVar: "AN_SHADOW_TIMEOUT",
+		Value:  "30s",
+	})
+	ingestNodes = app.String(cli.StringOpt{
+		Name:   "ingest-nodes",
+		Desc:   "Comma-separated node IDs that accept writes, other nodes forward write requests to them over the swarm.",
+		EnvVar: "AN_INGEST_NODES",
+		Value:  "",
+	})
+	uploadDir = app.String(cli.StringOpt{
+		Name:   "upload-dir",
+		Desc:   "Dir to stage resumable uploads in, a dir in the system temp dir by default.",
+		EnvVar: "AN_UPLOAD_DIR",
+		Value:  "",
+	})
+	sri = app.String(cli.StringOpt{
+		Name:   "sri",
+		Desc:   "Serve HTML records with subresource integrity attributes on scripts and stylesheets hosted in records.",
+		EnvVar: "AN_SRI",
+		Value:  "false",
+	})
+	networkStats = app.String(cli.StringOpt{
+		Name:   "network-stats",
+		Desc:   "Serve anonymized stats of the network at /network and /api/v1/network of the public API.",
+		EnvVar: "AN_NETWORK_STATS",
+		Value:  "false",
+	})
+	presignedURLs = app.String(cli.StringOpt{
+		Name:   "presigned-urls",
+		Desc:   "Serve record content to holders of pre-signed URLs issued over the private API, without any other auth.",
+		EnvVar: "AN_PRESIGNED_URLS",
+		Value:  "true",
+	})
+	readyMinPeers = app.String(cli.StringOpt{
+		Name:   "ready-min-peers",
+		Desc:   "Sets how many swarm peers the node needs to report itself ready at /readyz.",
+		EnvVar: "AN_READY_MIN_PEERS",
+		Value:  "1",
+	})
+	drainTimeout = app.String(cli.StringOpt{
+		Name:   "drain-timeout",
+		Desc:   "Bounds how long the node drains on SIGTERM: writes are refused while queued announces are published.",
+		EnvVar: "AN_DRAIN_TIMEOUT",
+		Value:  "2m",
+	})
+	pluginsDir = app.String(cli.StringOpt{
+		Name:   "plugins-dir",
+		Desc:   "Dir of plugins discovered at startup: Go plugins (.so) and executables, see the plugins command.",
+		EnvVar: "AN_PLUGINS_DIR",
+		Value:  defaultPluginsDir,
+	})
+	serviceMode = app.String(cli.StringOpt{
+		Name:   "service",
+		Desc:   "Run under a service manager: notify systemd of readiness and ping its watchdog, or run as a Windows service.",
+		EnvVar: "AN_SERVICE",
+		Value:  "false",
+	})
+	lbMaxQueueDepth = app.String(cli.StringOpt{
+		Name:   "lb-max-queue-depth",
+		Desc:   "Drains load balancer traffic at /lb-health while more announces are queued, 0 means no limit.",
+		EnvVar: "AN_LB_MAX_QUEUE_DEPTH",
+		Value:  "0",
+	})
+	lbMaxLatency = app.String(cli.StringOpt{
+		Name:   "lb-max-latency",
+		Desc:   "Drains load balancer traffic at /lb-health while the p95 latency of public requests is higher, e.g. 2s. Empty means no limit.",
+		EnvVar: "AN_LB_MAX_LATENCY",
+		Value:  "",
+	})
+	lbMaxMemory = app.String(cli.StringOpt{
+		Name:   "lb-max-memory",
+		Desc:   "Drains load balancer traffic at /lb-health while the heap in use is larger, in bytes, 0 means no limit.",
+		EnvVar: "AN_LB_MAX_MEMORY",
+		Value:  "0",
+	})
+	lbRecover = app.String(cli.StringOpt{
+		Name:   "lb-recover",
+		Desc:   "Fraction of /lb-health thresholds all signals must fall below to take traffic again.",
+		EnvVar: "AN_LB_RECOVER",
+		Value:  "0.8",
+	})
+	lbRecoverHold = app.String(cli.StringOpt{
+		Name:   "lb-recover-hold",
+		Desc:   "How long signals must stay below the recover thresholds before /lb-health passes again.",
+		EnvVar: "AN_LB_RECOVER_HOLD",
+		Value:  "30s",
+	})
+	idempotencyTTL = app.String(cli.StringOpt{
+		Name:   "idempotency-ttl",
+		Desc:   "Sets how long outcomes of writes with an Idempotency-Key are kept for retries, 0 disables keys.",
+		EnvVar: "AN_IDEMPOTENCY_TTL",
+		Value:  "24h",
+	})
+	policyFile = app.String(cli.StringOpt{
+		Name:   "policy",
+		Desc:   "Starlark file with an admission policy for read, write and admin API requests.",
+		EnvVar: "AN_POLICY",
+		Value:  "",
+	})
 	clusterEnabled = app.String(cli.StringOpt{
 		Name:   "cluster-enabled",
 		Desc:   "Enable cluster discovery (experimental).",
@@ -90,6 +486,523 @@ var (
 		EnvVar: "AN_FS_NETWORK_PROFILE",
 		Value:  "default",
 	})
+	fsNamespaces = app.Strings(cli.StringsOpt{
+		Name:   "namespaces",
+		Desc:   "Follow record announcements only for the listed namespaces, all namespaces are followed by default.",
+		EnvVar: "AN_NAMESPACES",
+		Value:  nil,
+	})
+	ntpServer = app.String(cli.StringOpt{
+		Name:   "ntp-server",
+		Desc:   "NTP server used to check the local clock, peers are used to estimate skew if empty.",
+		EnvVar: "AN_NTP_SERVER",
+		Value:  "pool.ntp.org",
+	})
+	clockSkewWarn = app.String(cli.StringOpt{
+		Name:   "clock-skew-warn",
+		Desc:   "Clock skew that is reported as a warning.",
+		EnvVar: "AN_CLOCK_SKEW_WARN",
+		Value:  "2s",
+	})
+	clockSkewMax = app.String(cli.StringOpt{
+		Name:   "clock-skew-max",
+		Desc:   "Clock skew that is considered severe.",
+		EnvVar: "AN_CLOCK_SKEW_MAX",
+		Value:  "30s",
+	})
+	clockRefuseWrites = app.String(cli.StringOpt{
+		Name:   "clock-refuse-writes",
+		Desc:   "Refuse local writes while the clock skew is severe.",
+		EnvVar: "AN_CLOCK_REFUSE_WRITES",
+		Value:  "false",
+	})
+	vouchThreshold = app.String(cli.StringOpt{
+		Name:   "vouch-threshold",
+		Desc:   "Number of vouches of permission holders that put a node on probation, 0 disables vouches.",
+		EnvVar: "AN_VOUCH_THRESHOLD",
+		Value:  "0",
+	})
+	vouchNamespace = app.String(cli.StringOpt{
+		Name:   "vouch-namespace",
+		Desc:   "Namespace probationary nodes may write to.",
+		EnvVar: "AN_VOUCH_NAMESPACE",
+		Value:  "probation",
+	})
+	vouchProbation = app.String(cli.StringOpt{
+		Name:   "vouch-probation",
+		Desc:   "How long a probation lasts since the vouch that met the threshold.",
+		EnvVar: "AN_VOUCH_PROBATION",
+		Value:  "720h",
+	})
+	readOnly = app.String(cli.StringOpt{
+		Name:   "read-only",
+		Desc:   "Run an observer node that syncs and serves records but refuses local writes and never commits beat reports.",
+		EnvVar: "AN_READ_ONLY",
+		Value:  "false",
+	})
+	dryRun = app.String(cli.StringOpt{
+		Name:   "dry-run",
+		Desc:   "Simulate token distribution and beat report commits, would-be transactions are only logged.",
+		EnvVar: "AN_DRY_RUN",
+		Value:  "false",
+	})
+	retentionInterval = app.String(cli.StringOpt{
+		Name:   "retention-interval",
+		Desc:   "Sets how often namespace retention policies are applied to version history.",
+		EnvVar: "AN_RETENTION_INTERVAL",
+		Value:  "1h",
+	})
+	searchIndex = app.String(cli.StringOpt{
+		Name:   "search-index",
+		Desc:   "Keep a search index of records in the state store and serve queries at /api/v1/search.",
+		EnvVar: "AN_SEARCH_INDEX",
+		Value:  "false",
+	})
+	searchContentMax = app.String(cli.StringOpt{
+		Name:   "search-content-max",
+		Desc:   "Max size in bytes of text records whose content is indexed, 0 indexes paths and user meta only.",
+		EnvVar: "AN_SEARCH_CONTENT_MAX",
+		Value:  "65536",
+	})
+	searchInterval = app.String(cli.StringOpt{
+		Name:   "search-interval",
+		Desc:   "Sets how often the whole store is checked for records missing from the search index.",
+		EnvVar: "AN_SEARCH_INTERVAL",
+		Value:  "6h",
+	})
+	fullResync = app.String(cli.StringOpt{
+		Name:   "full-resync",
+		Desc:   "Fetch all records on sync instead of those changed since the last sync.",
+		EnvVar: "AN_FULL_RESYNC",
+		Value:  "false",
+	})
+	syncMargin = app.String(cli.StringOpt{
+		Name:   "sync-margin",
+		Desc:   "Sets how far back from the last sync changed records are fetched on sync.",
+		EnvVar: "AN_SYNC_MARGIN",
+		Value:  "1h",
+	})
+	lifecycleTTL = app.String(cli.StringOpt{
+		Name:   "lifecycle-ttl",
+		Desc:   "Sets how long lifecycle events of nodes are kept in the state store.",
+		EnvVar: "AN_LIFECYCLE_TTL",
+		Value:  "168h",
+	})
+	inboundWorkers = app.String(cli.StringOpt{
+		Name:   "inbound-workers",
+		Desc:   "Number of announces from the network handled in parallel, announces of a record are handled in order.",
+		EnvVar: "AN_INBOUND_WORKERS",
+		Value:  "8",
+	})
+	webhookAttempts = app.String(cli.StringOpt{
+		Name:   "webhook-attempts",
+		Desc:   "Sets how many times a delivery to a change webhook is tried before it's dead-lettered.",
+		EnvVar: "AN_WEBHOOK_ATTEMPTS",
+		Value:  "8",
+	})
+	webhookDeadTTL = app.String(cli.StringOpt{
+		Name:   "webhook-dead-ttl",
+		Desc:   "Sets how long failed webhook deliveries are kept as dead letters.",
+		EnvVar: "AN_WEBHOOK_DEAD_TTL",
+		Value:  "168h",
+	})
+	gcInterval = app.String(cli.StringOpt{
+		Name:   "gc-interval",
+		Desc:   "Sets how often unreferenced and stale content is unpinned and collected, 0 disables it.",
+		EnvVar: "AN_GC_INTERVAL",
+		Value:  "24h",
+	})
+	shardRepairInterval = app.String(cli.StringOpt{
+		Name:   "shard-repair-interval",
+		Desc:   "Sets how often shards of erasure coded objects are checked and repaired, 0 disables it.",
+		EnvVar: "AN_SHARD_REPAIR_INTERVAL",
+		Value:  "1h",
+	})
+	repinInterval = app.String(cli.StringOpt{
+		Name:   "repin-interval",
+		Desc:   "Sets how often pinned versions are checked for missing blocks to refetch from peers, 0 disables it.",
+		EnvVar: "AN_REPIN_INTERVAL",
+		Value:  "6h",
+	})
+	mirrorPrefixes = app.String(cli.StringOpt{
+		Name:   "mirror-prefixes",
+		Desc:   "Comma-separated path prefixes of records whose content the node pins, used until a mirror policy is set over the private API.",
+		EnvVar: "AN_MIRROR_PREFIXES",
+		Value:  "",
+	})
+	mirrorNamespaces = app.String(cli.StringOpt{
+		Name:   "mirror-namespaces",
+		Desc:   "Comma-separated namespaces whose content the node pins, see --mirror-prefixes.",
+		EnvVar: "AN_MIRROR_NAMESPACES",
+		Value:  "",
+	})
+	mirrorInterval = app.String(cli.StringOpt{
+		Name:   "mirror-interval",
+		Desc:   "Sets how often the mirror policy is applied besides after syncs and changes of it, 0 means only then.",
+		EnvVar: "AN_MIRROR_INTERVAL",
+		Value:  "6h",
+	})
+	popularitySample = app.String(cli.StringOpt{
+		Name:   "popularity-sample",
+		Desc:   "Share of client reads counted in popularity of records, between 0 and 1, 0 turns counting off.",
+		EnvVar: "AN_POPULARITY_SAMPLE",
+		Value:  "0.1",
+	})
+	beatInterval = app.String(cli.StringOpt{
+		Name:   "beat-interval",
+		Desc:   "Sets how often beat ticks are sent, adaptive ticks go back to it once the network is active.",
+		EnvVar: "AN_BEAT_INTERVAL",
+		Value:  "10m",
+	})
+	beatInfoInterval = app.String(cli.StringOpt{
+		Name:   "beat-info-interval",
+		Desc:   "Sets how often beat infos with uptime and work of the node are sent.",
+		EnvVar: "AN_BEAT_INFO_INTERVAL",
+		Value:  "1h",
+	})
+	beatAdaptive = app.String(cli.StringOpt{
+		Name:   "beat-adaptive",
+		Desc:   "Back off beat ticks while the network is quiet and send one soon after peers are reachable again.",
+		EnvVar: "AN_BEAT_ADAPTIVE",
+		Value:  "true",
+	})
+	beatMinInterval = app.String(cli.StringOpt{
+		Name:   "beat-min-interval",
+		Desc:   "Sets the delay of a beat tick after peers are reachable again.",
+		EnvVar: "AN_BEAT_MIN_INTERVAL",
+		Value:  "1m",
+	})
+	beatMaxInterval = app.String(cli.StringOpt{
+		Name:   "beat-max-interval",
+		Desc:   "Bounds the back off of beat ticks while the network is quiet.",
+		EnvVar: "AN_BEAT_MAX_INTERVAL",
+		Value:  "1h",
+	})
+	beatReportInterval = app.String(cli.StringOpt{
+		Name:   "beat-report-interval",
+		Desc:   "Sets how often nodes with write permissions commit beat reports.",
+		EnvVar: "AN_BEAT_REPORT_INTERVAL",
+		Value:  "1h",
+	})
+	coldTierAfter = app.String(cli.StringOpt{
+		Name:   "cold-tier-after",
+		Desc:   "Offloads versions not read for this long to the cold tier bucket, 0 disables offloading.",
+		EnvVar: "AN_COLD_TIER_AFTER",
+		Value:  "720h",
+	})
+	coldTierInterval = app.String(cli.StringOpt{
+		Name:   "cold-tier-interval",
+		Desc:   "Sets how often versions are checked for offloading to the cold tier.",
+		EnvVar: "AN_COLD_TIER_INTERVAL",
+		Value:  "1h",
+	})
+	coldTierMaxOffloads = app.String(cli.StringOpt{
+		Name:   "cold-tier-max-offloads",
+		Desc:   "Limits versions offloaded to the cold tier by a pass, 0 removes the limit.",
+		EnvVar: "AN_COLD_TIER_MAX_OFFLOADS",
+		Value:  "1000",
+	})
+	coldTierBucket = app.String(cli.StringOpt{
+		Name:   "cold-tier-bucket",
+		Desc:   "S3 bucket of the cold tier, the tier is disabled without it.",
+		EnvVar: "AN_COLD_TIER_BUCKET",
+		Value:  "",
+	})
+	coldTierPrefix = app.String(cli.StringOpt{
+		Name:   "cold-tier-prefix",
+		Desc:   "Prefix of keys of offloaded versions in the cold tier bucket, e.g. atlant/.",
+		EnvVar: "AN_COLD_TIER_PREFIX",
+		Value:  "",
+	})
+	coldTierEndpoint = app.String(cli.StringOpt{
+		Name:   "cold-tier-endpoint",
+		Desc:   "Endpoint of an S3-compatible service, e.g. https://minio.local:9000, AWS is used without it.",
+		EnvVar: "AN_COLD_TIER_ENDPOINT",
+		Value:  "",
+	})
+	coldTierRegion = app.String(cli.StringOpt{
+		Name:   "cold-tier-region",
+		Desc:   "Region of the cold tier bucket.",
+		EnvVar: "AN_COLD_TIER_REGION",
+		Value:  "us-east-1",
+	})
+	coldTierAccessKey = app.String(cli.StringOpt{
+		Name:   "cold-tier-access-key",
+		Desc:   "Access key ID of the cold tier bucket.",
+		EnvVar: "AN_COLD_TIER_ACCESS_KEY",
+		Value:  "",
+	})
+	coldTierSecretKey = app.String(cli.StringOpt{
+		Name:   "cold-tier-secret-key",
+		Desc:   "Secret access key of the cold tier bucket, prefer the environment variable.",
+		EnvVar: "AN_COLD_TIER_SECRET_KEY",
+		Value:  "",
+	})
+	reindexRate = app.String(cli.StringOpt{
+		Name:   "reindex-rate",
+		Desc:   "Limits index rebuilds to this many records per second, 0 removes the limit.",
+		EnvVar: "AN_REINDEX_RATE",
+		Value:  "200",
+	})
+	antiEntropyInterval = app.String(cli.StringOpt{
+		Name:   "anti-entropy-interval",
+		Desc:   "Sets how often records are compared with a random peer to repair ones lost by gossip, 0 disables it.",
+		EnvVar: "AN_ANTI_ENTROPY_INTERVAL",
+		Value:  "10m",
+	})
+	antiEntropyRate = app.String(cli.StringOpt{
+		Name:   "anti-entropy-rate",
+		Desc:   "Limits records transferred in an anti-entropy round to this many bytes per second, 0 removes the limit.",
+		EnvVar: "AN_ANTI_ENTROPY_RATE",
+		Value:  "1048576",
+	})
+	antiEntropyMaxBytes = app.String(cli.StringOpt{
+		Name:   "anti-entropy-max-bytes",
+		Desc:   "Limits records transferred in an anti-entropy round to this many bytes, the rest waits for next rounds. 0 removes the limit.",
+		EnvVar: "AN_ANTI_ENTROPY_MAX_BYTES",
+		Value:  "67108864",
+	})
+	gcMaxDiskUsage = app.String(cli.StringOpt{
+		Name:   "gc-max-disk-usage",
+		Desc:   "IPFS repo size in bytes above which the oldest previous versions of records are dropped, 0 means no limit.",
+		EnvVar: "AN_GC_MAX_DISK_USAGE",
+		Value:  "0",
+	})
+	maxDiskUsage = app.String(cli.StringOpt{
+		Name:   "max-disk-usage",
+		Desc:   "Disk budget in bytes of the state store and the IPFS repo together, writes and pins of new content are refused over it. 0 means no limit.",
+		EnvVar: "AN_MAX_DISK_USAGE",
+		Value:  "0",
+	})
+	diskUsageWarnings = app.String(cli.StringOpt{
+		Name:   "disk-usage-warnings",
+		Desc:   "Comma-separated percents of the disk budget, a warning is logged once disk usage crosses one.",
+		EnvVar: "AN_DISK_USAGE_WARNINGS",
+		Value:  "80,90,95",
+	})
+	encryptedNamespaces = app.String(cli.StringOpt{
+		Name:   "encrypted-namespaces",
+		Desc:   "Comma-separated namespaces whose content is encrypted at rest by this node, e.g. kyc,contracts.",
+		EnvVar: "AN_ENCRYPTED_NAMESPACES",
+		Value:  "",
+	})
+	confidentialNamespaces = app.String(cli.StringOpt{
+		Name:   "confidential-namespaces",
+		Desc:   "Comma-separated namespaces whose record updates are sealed in gossip, so peers without the key can't read them.",
+		EnvVar: "AN_CONFIDENTIAL_NAMESPACES",
+		Value:  "",
+	})
+	conflictPolicy = app.String(cli.StringOpt{
+		Name:   "conflict-policy",
+		Desc:   "Resolves concurrent writes of a record: last-writer, signer-priority or manual.",
+		EnvVar: "AN_CONFLICT_POLICY",
+		Value:  "last-writer",
+	})
+	cacheSize = app.String(cli.StringOpt{
+		Name:   "cache-size",
+		Desc:   "Entries kept by the cache of record lookups, 0 disables caching.",
+		EnvVar: "AN_CACHE_SIZE",
+		Value:  "10000",
+	})
+	cacheTTLs = app.String(cli.StringOpt{
+		Name:   "cache-ttls",
+		Desc:   "Comma-separated TTLs of cached lookups, e.g. paths=30s,objects=2h. 0 disables a class.",
+		EnvVar: "AN_CACHE_TTLS",
+		Value:  "",
+	})
+	gcMaxRecordAge = app.String(cli.StringOpt{
+		Name:   "gc-max-record-age",
+		Desc:   "Drops previous versions and deleted records older than that, e.g. 8760h. Empty keeps them forever.",
+		EnvVar: "AN_GC_MAX_RECORD_AGE",
+		Value:  "",
+	})
+	gcBucketTTLs = app.String(cli.StringOpt{
+		Name:   "gc-bucket-ttls",
+		Desc:   "Comma-separated TTLs of state buckets, e.g. beat_infos=720h,checksums=2160h.",
+		EnvVar: "AN_GC_BUCKET_TTLS",
+		Value:  "",
+	})
+	dedupWindow = app.String(cli.StringOpt{
+		Name:   "dedup-window",
+		Desc:   "Sets how long duplicate gossip arrivals of a record announce are dropped.",
+		EnvVar: "AN_DEDUP_WINDOW",
+		Value:  "15m",
+	})
+	bootstrapToken = app.String(cli.StringOpt{
+		Name:      "bootstrap-token",
+		Desc:      "Allow peers presenting this token to bootstrap from a snapshot of this node.",
+		EnvVar:    "AN_BOOTSTRAP_TOKEN",
+		Value:     "",
+		HideValue: true,
+	})
+	manifestInterval = app.String(cli.StringOpt{
+		Name:   "manifest-interval",
+		Desc:   "Sets how often checksum manifests are published by nodes with write permissions.",
+		EnvVar: "AN_MANIFEST_INTERVAL",
+		Value:  "6h",
+	})
+	fsSwarmProxy = app.String(cli.StringOpt{
+		Name:   "swarm-proxy",
+		Desc:   "Dials swarm peers through a proxy, e.g. socks5://127.0.0.1:9050 of Tor or http://proxy:3128 that allows CONNECT.",
+		EnvVar: "AN_SWARM_PROXY",
+		Value:  "",
+	})
+	fsSwarmNoClearnet = app.String(cli.StringOpt{
+		Name:   "swarm-no-clearnet",
+		Desc:   "Listens for swarm peers on loopback only, e.g. behind an onion service. Requires --swarm-proxy.",
+		EnvVar: "AN_SWARM_NO_CLEARNET",
+		Value:  "false",
+	})
+	fsSwarmAnnounce = app.Strings(cli.StringsOpt{
+		Name:   "swarm-announce",
+		Desc:   "Addresses peers dial this node at instead of the detected ones, e.g. /ip4/<public IP>/tcp/33770 of a port forward or /onion/<address>:33770.",
+		EnvVar: "AN_SWARM_ANNOUNCE",
+		Value:  nil,
+	})
+	fsSwarmIPv6 = app.String(cli.StringOpt{
+		Name:   "swarm-ipv6",
+		Desc:   "Listens for swarm peers on IPv6 at the port of --fs-listen-addr as well.",
+		EnvVar: "AN_SWARM_IPV6",
+		Value:  "false",
+	})
+	fsSwarmPortMap = app.String(cli.StringOpt{
+		Name:   "swarm-port-map",
+		Desc:   "Asks the router to forward the swarm port over UPnP or NAT-PMP.",
+		EnvVar: "AN_SWARM_PORT_MAP",
+		Value:  "true",
+	})
+	fsSwarmAutoNAT = app.String(cli.StringOpt{
+		Name:   "swarm-autonat",
+		Desc:   "Asks connected peers to dial the node back to learn whether it's reachable.",
+		EnvVar: "AN_SWARM_AUTONAT",
+		Value:  "true",
+	})
+	fsSwarmAutoNATInterval = app.String(cli.StringOpt{
+		Name:   "swarm-autonat-interval",
+		Desc:   "Sets how often peers are asked to dial the node back.",
+		EnvVar: "AN_SWARM_AUTONAT_INTERVAL",
+		Value:  "15m",
+	})
+	fsSwarmRelayHop = app.String(cli.StringOpt{
+		Name:   "swarm-relay-hop",
+		Desc:   "Relays connections of other nodes, needs --relay-enabled.",
+		EnvVar: "AN_SWARM_RELAY_HOP",
+		Value:  "true",
+	})
+	fsSwarmRelayPeers = app.String(cli.StringOpt{
+		Name:   "swarm-relay-peers",
+		Desc:   "Comma-separated addresses of relays ending with /ipfs/<node ID>, the node stays connected to them while peers can't dial it. Needs --relay-enabled and --swarm-autonat.",
+		EnvVar: "AN_SWARM_RELAY_PEERS",
+		Value:  "",
+	})
+	fsBlocklist = app.Strings(cli.StringsOpt{
+		Name:   "blocklist",
+		Desc:   "Files or URLs of peer ID / IP / CIDR blocklists, connections with listed peers are refused.",
+		EnvVar: "AN_BLOCKLIST",
+		Value:  nil,
+	})
+	fsBlocklistRefresh = app.String(cli.StringOpt{
+		Name:   "blocklist-refresh",
+		Desc:   "Sets how often blocklists are reloaded.",
+		EnvVar: "AN_BLOCKLIST_REFRESH",
+		Value:  "1h",
+	})
+	fsBitswapMaxDebt = app.String(cli.StringOpt{
+		Name:   "bitswap-max-debt",
+		Desc:   "Bytes a peer may receive over what it has sent before it is throttled, 0 disables debt limits.",
+		EnvVar: "AN_BITSWAP_MAX_DEBT",
+		Value:  "0",
+	})
+	fsBitswapDebtRatio = app.String(cli.StringOpt{
+		Name:   "bitswap-debt-ratio",
+		Desc:   "Ratio of bytes sent to a peer to bytes received from it, above which a peer in debt is throttled.",
+		EnvVar: "AN_BITSWAP_DEBT_RATIO",
+		Value:  "10",
+	})
+	fsBitswapDebtCooldown = app.String(cli.StringOpt{
+		Name:   "bitswap-debt-cooldown",
+		Desc:   "Sets how long a throttled peer is refused, repeated throttles double it up to 24h.",
+		EnvVar: "AN_BITSWAP_DEBT_COOLDOWN",
+		Value:  "10m",
+	})
+	fsBitswapDebtExempt = app.String(cli.StringOpt{
+		Name:   "bitswap-debt-exempt",
+		Desc:   "Comma-separated peer IDs that are never throttled, e.g. own nodes that only fetch.",
+		EnvVar: "AN_BITSWAP_DEBT_EXEMPT",
+		Value:  "",
+	})
+	fsPeerExchangeInterval = app.String(cli.StringOpt{
+		Name:   "peer-exchange-interval",
+		Desc:   "Sets how often addresses of connected peers are gossiped to the swarm, 0 disables the peer exchange.",
+		EnvVar: "AN_PEER_EXCHANGE_INTERVAL",
+		Value:  "10m",
+	})
+	fsPeerExchangeTTL = app.String(cli.StringOpt{
+		Name:   "peer-exchange-ttl",
+		Desc:   "Sets how long exchanged peers are kept as bootstrap candidates since they were last reported.",
+		EnvVar: "AN_PEER_EXCHANGE_TTL",
+		Value:  "168h",
+	})
+	fsMmapCacheSize = app.String(cli.StringOpt{
+		Name:   "mmap-cache-size",
+		Desc:   "Size of the cache of memory-mapped hot objects (bytes), 0 disables the cache.",
+		EnvVar: "AN_MMAP_CACHE_SIZE",
+		Value:  "0",
+	})
+	fsMmapMinObjectSize = app.String(cli.StringOpt{
+		Name:   "mmap-min-object-size",
+		Desc:   "Objects smaller than this size (bytes) are not memory-mapped.",
+		EnvVar: "AN_MMAP_MIN_OBJECT_SIZE",
+		Value:  "4194304",
+	})
+	fsMmapHotReads = app.String(cli.StringOpt{
+		Name:   "mmap-hot-reads",
+		Desc:   "Number of reads after which a large local object is memory-mapped.",
+		EnvVar: "AN_MMAP_HOT_READS",
+		Value:  "3",
+	})
+	fsInlineMaxSize = app.String(cli.StringOpt{
+		Name:   "inline-max-size",
+		Desc:   "Objects up to this size (bytes) are kept inline in the state store for fast reads, 0 disables inlining.",
+		EnvVar: "AN_INLINE_MAX_SIZE",
+		Value:  "4096",
+	})
+	authRefresh = app.String(cli.StringOpt{
+		Name:   "auth-refresh",
+		Desc:   "Sets how often DNS auth domains are re-resolved to pick up permission changes.",
+		EnvVar: "AN_AUTH_REFRESH",
+		Value:  "1m",
+	})
+	authProviders = app.String(cli.StringOpt{
+		Name:   "auth-providers",
+		Desc:   "Comma-separated sources of node permissions: dns, file:<signed allowlist path> and contract:<registry address>.",
+		EnvVar: "AN_AUTH_PROVIDERS",
+		Value:  "dns",
+	})
+	authAllowlistSigners = app.String(cli.StringOpt{
+		Name:   "auth-allowlist-signers",
+		Desc:   "Comma-separated Ethereum addresses trusted to sign allowlist files of --auth-providers.",
+		EnvVar: "AN_AUTH_ALLOWLIST_SIGNERS",
+		Value:  "",
+	})
+	authDoH = app.String(cli.StringOpt{
+		Name:   "auth-doh",
+		Desc:   "Comma-separated DNS-over-HTTPS resolvers (JSON API) asked for auth domains when DNS fails, empty disables the fallback.",
+		EnvVar: "AN_AUTH_DOH",
+		Value:  "https://cloudflare-dns.com/dns-query,https://dns.google/resolve",
+	})
+	outboundProxy = app.String(cli.StringOpt{
+		Name:   "proxy",
+		Desc:   "Proxy URL (http, https or socks5) for Ethereum RPC, DNS-over-HTTPS and webhooks, HTTP(S)_PROXY are used if empty.",
+		EnvVar: "AN_PROXY",
+		Value:  "",
+	})
+	outboundNoProxy = app.String(cli.StringOpt{
+		Name:   "no-proxy",
+		Desc:   "Comma-separated hosts, .domain suffixes and CIDRs reached directly when --proxy is set.",
+		EnvVar: "AN_NO_PROXY",
+		Value:  "",
+	})
 	envTestnet = app.Bool(cli.BoolOpt{
 		Name:   "T testnet",
 		Desc:   "Switch node into testing mode, it runs in a seprate testnet environment.",
@@ -120,6 +1033,12 @@ var (
 		Value:     "",
 		HideValue: true,
 	})
+	ethRPC = app.String(cli.StringOpt{
+		Name:   "eth-rpc",
+		Desc:   "Ethereum JSON-RPC endpoint to verify balances against, instead of the default node pool.",
+		EnvVar: "AN_ETH_RPC",
+		Value:  "",
+	})
 )
 
 // use atlant-keygen to generate a custom key
@@ -146,10 +1065,43 @@ func duration(s string, defaults time.Duration) time.Duration {
 	return dur
 }
 
+// toQuota parses quotas like 1000/24h, zero values are returned if s is malformed.
+func toQuota(s string) (int, time.Duration) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0
+	}
+	n := toNatural(parts[0], 0)
+	window := duration(parts[1], 0)
+	if n == 0 || window <= 0 {
+		return 0, 0
+	}
+	return n, window
+}
+
 func toList(s string) []string {
 	return strings.Split(s, ",")
 }
 
+// trimList splits a comma-separated list, dropping spaces and empty items.
+func trimList(s string) []string {
+	var list []string
+	for _, v := range toList(s) {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func toFloat(s string, defaults float64) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return defaults
+	}
+	return f
+}
+
 func toBool(s string) bool {
 	switch strings.ToLower(s) {
 	case "true", "1", "t", "yes":
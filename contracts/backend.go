@@ -0,0 +1,220 @@
+package contracts
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AtlantPlatform/ethfw"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/serialx/hashring"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proxy"
+)
+
+// Backend provides clients of Ethereum nodes to the manager.
+type Backend interface {
+	// Client returns a client along with the address of its node.
+	Client() (cli ethfw.Client, addr string, ok bool)
+	// Fail reports a failed call to the node at addr.
+	Fail(addr string)
+}
+
+const (
+	MainnetChainID = 1
+
+	rpcRetries      = 4
+	rpcRetryBackoff = 500 * time.Millisecond
+	rpcCallTimeout  = 30 * time.Second
+)
+
+// withRetry runs fn until it succeeds, retries are delayed with exponential backoff.
+func withRetry(fn func() error) error {
+	backoff := rpcRetryBackoff
+	var err error
+	for i := 0; i < rpcRetries; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if i < rpcRetries-1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+// poolBackend spreads sessions over a pool of geth nodes, nodes that fail
+// too often are removed from the pool for a while.
+type poolBackend struct {
+	session string
+
+	ring    *hashring.HashRing
+	ringMux *sync.RWMutex
+	fails   map[string]int
+}
+
+// NewPoolBackend creates a backend over the default nodes of the network.
+func NewPoolBackend(session string, testnet bool) Backend {
+	b := &poolBackend{
+		session: session,
+		ringMux: new(sync.RWMutex),
+		fails:   make(map[string]int),
+	}
+	if testnet {
+		b.ring = hashring.New(DefaultTestNodes)
+	} else {
+		b.ring = hashring.New(DefaultMainNodes)
+	}
+	return b
+}
+
+func (b *poolBackend) Client() (cli ethfw.Client, addr string, ok bool) {
+	for {
+		b.ringMux.RLock()
+		addr, ok = b.ring.GetNode(b.session)
+		b.ringMux.RUnlock()
+		if !ok {
+			log.Warningln("no available geth nodes in pool, all dead x_X")
+			return nil, "", false
+		}
+		r, err := dialHTTP(addr)
+		if err == nil {
+			cli = ethfw.NewClient(r)
+			break
+		}
+		log.Warningf("failed to connect to geth node: %v", err)
+		b.Fail(addr)
+		time.Sleep(3 * time.Second)
+	}
+	return cli, addr, ok
+}
+
+func (b *poolBackend) Fail(addr string) {
+	b.ringMux.Lock()
+	defer b.ringMux.Unlock()
+	if b.fails[addr] < 0 {
+		// node been removed
+		return
+	}
+	b.fails[addr]++
+	if b.fails[addr] < 3 {
+		return
+	}
+	b.fails[addr] = -1
+	b.ring = b.ring.RemoveNode(addr)
+	log.Warningf("geth node %s has been removed from pool and will be checked again in 5min", addr)
+	go func() {
+		// schedule a revival
+		time.Sleep(5 * time.Minute)
+		b.reviveNode(addr)
+	}()
+}
+
+func (b *poolBackend) reviveNode(addr string) {
+	b.ringMux.Lock()
+	defer b.ringMux.Unlock()
+	if b.fails[addr] >= 0 {
+		// node been restored
+		return
+	}
+	log.Warningf("geth node %s has been added back into pool", addr)
+	b.ring = b.ring.AddNode(addr)
+	b.fails[addr] = 0
+}
+
+// rpcBackend talks to a single Ethereum JSON-RPC endpoint, e.g. a node of our own
+// infrastructure. The chain ID is detected on the first connection.
+type rpcBackend struct {
+	url     string
+	testnet bool
+
+	mux     *sync.Mutex
+	client  *rpc.Client
+	chainID *big.Int
+}
+
+// NewRPCBackend creates a backend of the endpoint at url, testnet is the network the
+// node runs in, a mismatch with the detected chain is reported.
+func NewRPCBackend(url string, testnet bool) Backend {
+	return &rpcBackend{
+		url:     url,
+		testnet: testnet,
+		mux:     new(sync.Mutex),
+	}
+}
+
+func (b *rpcBackend) Client() (ethfw.Client, string, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.client != nil {
+		return ethfw.NewClient(b.client), b.url, true
+	}
+	var r *rpc.Client
+	var chainID *big.Int
+	if err := withRetry(func() error {
+		var err error
+		if r, err = dialHTTP(b.url); err != nil {
+			return err
+		}
+		if chainID, err = detectChainID(r); err != nil {
+			r.Close()
+			return err
+		}
+		return nil
+	}); err != nil {
+		log.Warningf("failed to connect to Ethereum RPC %s: %v", b.url, err)
+		return nil, "", false
+	}
+	b.client = r
+	b.chainID = chainID
+	logger := log.WithFields(log.Fields{
+		"rpc":      b.url,
+		"chain_id": chainID.String(),
+	})
+	if isMainnet := chainID.Int64() == MainnetChainID; isMainnet == b.testnet {
+		logger.Warningln("Ethereum RPC chain does not match the network of the node")
+	} else {
+		logger.Infoln("connected to Ethereum RPC")
+	}
+	return ethfw.NewClient(r), b.url, true
+}
+
+// Fail drops the connection, so that the next client reconnects and detects the chain again.
+func (b *rpcBackend) Fail(addr string) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.client != nil {
+		b.client.Close()
+		b.client = nil
+	}
+}
+
+// dialHTTP connects to a JSON-RPC endpoint through the outbound proxy, see proxy.Configure.
+func dialHTTP(addr string) (*rpc.Client, error) {
+	return rpc.DialHTTPWithClient(addr, proxy.Client(0))
+}
+
+// detectChainID asks for eth_chainId, falling back to net_version for older nodes.
+func detectChainID(r *rpc.Client) (*big.Int, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), rpcCallTimeout)
+	defer cancelFn()
+	var id hexutil.Big
+	if err := r.CallContext(ctx, &id, "eth_chainId"); err == nil {
+		return (*big.Int)(&id), nil
+	}
+	var version string
+	if err := r.CallContext(ctx, &version, "net_version"); err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return nil, errors.New("unexpected net_version: " + version)
+	}
+	return big.NewInt(n), nil
+}
@@ -6,14 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"sync"
 	"time"
 
 	"github.com/AtlantPlatform/ethfw"
-	"github.com/ethereum/go-ethereum/rpc"
-	"github.com/serialx/hashring"
-	log "github.com/sirupsen/logrus"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 
+	"github.com/AtlantPlatform/atlant-go/authcenter"
 	"github.com/AtlantPlatform/atlant-go/rs"
 )
 
@@ -37,6 +37,15 @@ type ContractConfig struct {
 type Manager interface {
 	TokenManager(typ, name string) (TokenManager, error)
 	KYCManager() (KYCManager, error)
+	// AuthRegistry returns a provider of authority entries kept by the registry contract.
+	AuthRegistry(address string) authcenter.Provider
+	// Distribute plans a distribution round of the pool in wei by the beat reports and logs its
+	// transfers, ErrNoSigner is returned unless the manager runs in dry-run mode.
+	Distribute(ctx context.Context, pool *big.Int) (*DistributionRound, error)
+	// DryRun reports whether transactions are only simulated, SimulatedTransactions lists
+	// the ones logged so far, oldest first.
+	DryRun() bool
+	SimulatedTransactions() []*Transaction
 }
 
 type TokenManager interface {
@@ -63,82 +72,31 @@ var DefaultTestNodes = []string{
 
 var DefaultMainNodes = []string{}
 
-func NewManager(session string, store rs.PlanetaryRecordStore, testnet bool) Manager {
+// NewManager creates a manager that reaches Ethereum nodes through the backend,
+// see NewPoolBackend and NewRPCBackend.
+func NewManager(store rs.PlanetaryRecordStore, backend Backend, opts ...managerOpt) Manager {
 	m := &manager{
-		store:   store,
-		session: session,
-		ringMux: new(sync.RWMutex),
-		fails:   make(map[string]int),
+		store:        store,
+		backend:      backend,
+		simulatedMux: new(sync.Mutex),
 	}
-	if testnet {
-		m.ring = hashring.New(DefaultTestNodes)
-	} else {
-		m.ring = hashring.New(DefaultMainNodes)
+	for _, opt := range opts {
+		opt(m)
 	}
 	return m
 }
 
 type manager struct {
-	session string
 	store   rs.PlanetaryRecordStore
+	backend Backend
+	dryRun  bool
 
-	ring    *hashring.HashRing
-	ringMux *sync.RWMutex
-	fails   map[string]int
+	simulatedMux *sync.Mutex
+	simulated    []*Transaction
 }
 
 func (m *manager) getClient() (cli ethfw.Client, addr string, ok bool) {
-	for {
-		m.ringMux.RLock()
-		addr, ok = m.ring.GetNode(m.session)
-		m.ringMux.RUnlock()
-		if !ok {
-			log.Warningln("no available geth nodes in pool, all dead x_X")
-			return nil, "", false
-		}
-		r, err := rpc.DialHTTP(addr)
-		if err == nil {
-			cli = ethfw.NewClient(r)
-			break
-		}
-		log.Warningf("failed to connect to geth node: %v", err)
-		m.failNode(addr)
-		time.Sleep(3 * time.Second)
-	}
-	return cli, addr, ok
-}
-
-func (m *manager) failNode(addr string) {
-	m.ringMux.Lock()
-	defer m.ringMux.Unlock()
-	if m.fails[addr] < 0 {
-		// node been removed
-		return
-	}
-	m.fails[addr]++
-	if m.fails[addr] < 3 {
-		return
-	}
-	m.fails[addr] = -1
-	m.ring = m.ring.RemoveNode(addr)
-	log.Warningf("geth node %s has been removed from pool and will be checked again in 5min", addr)
-	go func() {
-		// schedule a revival
-		time.Sleep(5 * time.Minute)
-		m.reviveNode(addr)
-	}()
-}
-
-func (m *manager) reviveNode(addr string) {
-	m.ringMux.Lock()
-	defer m.ringMux.Unlock()
-	if m.fails[addr] >= 0 {
-		// node been restored
-		return
-	}
-	log.Warningf("geth node %s has been added back into pool", addr)
-	m.ring = m.ring.AddNode(addr)
-	m.fails[addr] = 0
+	return m.backend.Client()
 }
 
 func (m *manager) TokenManager(typ, name string) (TokenManager, error) {
@@ -203,5 +161,21 @@ func (m *manager) KYCManager() (KYCManager, error) {
 
 type baseContract struct {
 	m        *manager
+	addr     string
 	contract *ethfw.BoundContract
 }
+
+// call runs a contract method with retries, the node is reported to the backend if all fail.
+func (c *baseContract) call(result interface{}, method string, params ...interface{}) error {
+	err := withRetry(func() error {
+		ctx, cancelFn := context.WithTimeout(context.Background(), rpcCallTimeout)
+		defer cancelFn()
+		return c.contract.Call(&bind.CallOpts{
+			Context: ctx,
+		}, result, method, params...)
+	})
+	if err != nil {
+		c.m.backend.Fail(c.addr)
+	}
+	return err
+}
@@ -0,0 +1,204 @@
+package contracts
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// A distribution round shares a pool of ATL tokens between Ethereum accounts of nodes by their
+// uptime hours in beat reports, shares are rounded down and the rest is left as dust. Nodes hold
+// no signing keys, so rounds run in dry-run mode only: transfers are encoded against the ATL
+// contract and logged for review, the same reports and pool always make the same transactions.
+
+var (
+	ErrNoSigner = errors.New("distribution runs in dry-run mode only, the node holds no signing keys")
+	ErrBadPool  = errors.New("pool of the distribution must be a positive amount of wei")
+)
+
+// Transaction is a would-be transaction of a contract operation.
+type Transaction struct {
+	ID       string `json:"id"`
+	Round    string `json:"round"`
+	Token    string `json:"token"`
+	Contract string `json:"contract"`
+	Method   string `json:"method"`
+	To       string `json:"to"`
+	// Amount is in wei, Hours is the uptime the share was counted by.
+	Amount string    `json:"amount"`
+	Hours  uint64    `json:"hours"`
+	Data   string    `json:"data"`
+	At     time.Time `json:"at"`
+}
+
+// DistributionRound lists transfers of a pool between accounts.
+type DistributionRound struct {
+	ID           string         `json:"id"`
+	Pool         string         `json:"pool"`
+	Hours        uint64         `json:"hours_total"`
+	Dust         string         `json:"dust"`
+	Transactions []*Transaction `json:"transactions"`
+}
+
+// maxSimulatedTransactions limits the log, the oldest transactions are dropped first.
+const maxSimulatedTransactions = 1000
+
+type managerOpt func(m *manager)
+
+// DryRunOpt makes contract operations only log the transactions they would send,
+// see SimulatedTransactions.
+func DryRunOpt(dryRun bool) managerOpt {
+	return func(m *manager) {
+		m.dryRun = dryRun
+	}
+}
+
+func (m *manager) DryRun() bool {
+	return m.dryRun
+}
+
+func (m *manager) SimulatedTransactions() []*Transaction {
+	m.simulatedMux.Lock()
+	defer m.simulatedMux.Unlock()
+	list := make([]*Transaction, len(m.simulated))
+	copy(list, m.simulated)
+	return list
+}
+
+func (m *manager) Distribute(ctx context.Context, pool *big.Int) (*DistributionRound, error) {
+	if !m.dryRun {
+		return nil, ErrNoSigner
+	} else if pool == nil || pool.Sign() <= 0 {
+		return nil, ErrBadPool
+	}
+	cfg, err := m.readConfig("/configs/atl/atl.json")
+	if err != nil {
+		return nil, err
+	} else if len(cfg.Address) == 0 {
+		return nil, ErrNoAddress
+	} else if cfg.ABI == nil {
+		return nil, ErrNoABI
+	}
+	token, err := abi.JSON(bytes.NewReader(cfg.ABI))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse contract abi: %v", err)
+	}
+	reports, err := m.store.BeatReports(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hours := make(map[string]uint64, len(reports))
+	addrs := make([]string, 0, len(reports))
+	var total uint64
+	for addr, report := range reports {
+		if !common.IsHexAddress(addr) {
+			log.WithField("account", addr).Warningln("dry run: skipped beat report of a malformed account")
+			continue
+		}
+		var n uint64
+		for _, sess := range report.Sessions {
+			n += uint64(sess.Uptime)
+		}
+		if n == 0 {
+			continue
+		}
+		addr = strings.ToLower(addr)
+		if _, ok := hours[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+		hours[addr] += n
+		total += n
+	}
+	sort.Strings(addrs)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s:%s\n", cfg.Address, pool.String())
+	for _, addr := range addrs {
+		fmt.Fprintf(h, "%s:%d\n", addr, hours[addr])
+	}
+	round := &DistributionRound{
+		ID:    hex.EncodeToString(h.Sum(nil)[:16]),
+		Pool:  pool.String(),
+		Hours: total,
+	}
+	dust := new(big.Int).Set(pool)
+	now := time.Now().UTC()
+	for _, addr := range addrs {
+		amount := new(big.Int).Mul(pool, new(big.Int).SetUint64(hours[addr]))
+		amount.Div(amount, new(big.Int).SetUint64(total))
+		if amount.Sign() == 0 {
+			continue
+		}
+		data, err := token.Pack("transfer", common.HexToAddress(addr), amount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode transfer: %v", err)
+		}
+		dust.Sub(dust, amount)
+		id := sha256.Sum256([]byte(round.ID + ":" + addr + ":" + amount.String()))
+		round.Transactions = append(round.Transactions, &Transaction{
+			ID:       hex.EncodeToString(id[:16]),
+			Round:    round.ID,
+			Token:    TokenATL,
+			Contract: cfg.Address,
+			Method:   "transfer",
+			To:       addr,
+			Amount:   amount.String(),
+			Hours:    hours[addr],
+			Data:     "0x" + hex.EncodeToString(data),
+			At:       now,
+		})
+	}
+	round.Dust = dust.String()
+	for _, tx := range round.Transactions {
+		log.WithFields(log.Fields{
+			"id":     tx.ID,
+			"round":  tx.Round,
+			"to":     tx.To,
+			"amount": tx.Amount,
+			"hours":  tx.Hours,
+		}).Infoln("dry run: skipped transaction")
+	}
+	m.simulate(round.Transactions)
+	return round, nil
+}
+
+func (m *manager) simulate(txs []*Transaction) {
+	m.simulatedMux.Lock()
+	defer m.simulatedMux.Unlock()
+	m.simulated = append(m.simulated, txs...)
+	if n := len(m.simulated) - maxSimulatedTransactions; n > 0 {
+		m.simulated = append(m.simulated[:0], m.simulated[n:]...)
+	}
+}
+
+func (m *manager) readConfig(path string) (*ContractConfig, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 30*time.Second)
+	r, err := m.store.ReadRecord(ctx, path)
+	cancelFn()
+	if err != nil {
+		err = fmt.Errorf("failed to read contract config: %v", err)
+		return nil, err
+	}
+	buf, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	var cfg ContractConfig
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		err = fmt.Errorf("failed to unmarshal contract config: %v", err)
+		return nil, err
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,99 @@
+package contracts
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"github.com/AtlantPlatform/ethfw/sol"
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+)
+
+// A registry contract lists nodes with their permissions on chain, the same tags as the
+// ones of DNS auth domains are comma-separated in a string. A contract is compatible if it
+// implements the following view functions:
+//
+//	function nodeCount() view returns (uint256)
+//	function nodeAt(uint256 index) view returns (string key)
+//	function permissionsOf(string key) view returns (string tags)
+const registryABI = `[
+	{"constant":true,"inputs":[],"name":"nodeCount","outputs":[{"name":"","type":"uint256"}],"type":"function","stateMutability":"view"},
+	{"constant":true,"inputs":[{"name":"index","type":"uint256"}],"name":"nodeAt","outputs":[{"name":"key","type":"string"}],"type":"function","stateMutability":"view"},
+	{"constant":true,"inputs":[{"name":"key","type":"string"}],"name":"permissionsOf","outputs":[{"name":"tags","type":"string"}],"type":"function","stateMutability":"view"}
+]`
+
+// maxRegistryNodes limits nodes read from a registry, so a broken contract can't stall loads.
+const maxRegistryNodes = 10000
+
+// AuthRegistry returns an authority provider that reads the registry contract at address,
+// the contract is bound anew on each load, so nodes of the backend may change in between.
+func (m *manager) AuthRegistry(address string) authcenter.Provider {
+	return &registryProvider{
+		m:       m,
+		address: address,
+	}
+}
+
+type registryProvider struct {
+	m       *manager
+	address string
+}
+
+func (p *registryProvider) Name() string {
+	return "contract:" + p.address
+}
+
+func (p *registryProvider) Load(ctx context.Context) ([]authcenter.Entry, error) {
+	cli, addr, ok := p.m.getClient()
+	if !ok {
+		return nil, ErrNodeUnavailable
+	}
+	boundContract, err := cli.BindContract(&sol.Contract{
+		Address: common.HexToAddress(p.address),
+		ABI:     []byte(registryABI),
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := &baseContract{
+		contract: boundContract,
+		addr:     addr,
+		m:        p.m,
+	}
+	count := new(*big.Int)
+	if err := c.call(count, "nodeCount"); err != nil {
+		return nil, err
+	}
+	n := (*count).Int64()
+	if n > maxRegistryNodes {
+		n = maxRegistryNodes
+	}
+	entries := make([]authcenter.Entry, 0, n)
+	for i := int64(0); i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var key, tags string
+		if err := c.call(&key, "nodeAt", big.NewInt(i)); err != nil {
+			return nil, err
+		} else if len(key) == 0 {
+			continue
+		}
+		if err := c.call(&tags, "permissionsOf", key); err != nil {
+			return nil, err
+		}
+		var list []string
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); len(tag) > 0 {
+				list = append(list, tag)
+			}
+		}
+		entries = append(entries, authcenter.Entry{
+			Key:         key,
+			Permissions: authcenter.ParsePermissions(p.Name(), list),
+		})
+	}
+	return entries, nil
+}
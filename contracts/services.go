@@ -1,9 +1,6 @@
 package contracts
 
 import (
-	"context"
-
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	log "github.com/sirupsen/logrus"
 
@@ -20,7 +17,7 @@ func (m *manager) bindKYC(address string, abi []byte) (KYCManager, error) {
 	} else if abi == nil {
 		return nil, ErrNoABI
 	}
-	cli, _, ok := m.getClient()
+	cli, addr, ok := m.getClient()
 	if !ok {
 		return nil, ErrNodeUnavailable
 	}
@@ -34,24 +31,15 @@ func (m *manager) bindKYC(address string, abi []byte) (KYCManager, error) {
 	return &kycManager{
 		baseContract: baseContract{
 			contract: boundContract,
+			addr:     addr,
 			m:        m,
 		},
 	}, nil
 }
 
 func (k *kycManager) AccountStatus(account string) (KYCStatus, error) {
-	// cli, _, ok := k.m.getClient()
-	// if !ok {
-	// 	// k.m.failNode(addr)
-	// 	return StatusUnknown, ErrNodeUnavailable
-	// }
 	var status uint8
-	opts := &bind.CallOpts{
-		Context: context.TODO(),
-	}
-	err := k.contract.Call(opts, &status, "getStatus", common.HexToAddress(account))
-	if err != nil {
-		// k.m.failNode(addr)
+	if err := k.call(&status, "getStatus", common.HexToAddress(account)); err != nil {
 		return StatusUnknown, ErrNodeUnavailable
 	}
 	switch status {
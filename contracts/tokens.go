@@ -6,7 +6,6 @@ import (
 
 	"github.com/AtlantPlatform/ethfw"
 	"github.com/AtlantPlatform/ethfw/sol"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -23,13 +22,20 @@ func (m *manager) bindETH() TokenManager {
 }
 
 func (c *ethManager) AccountBalance(account string) (float64, error) {
-	cli, _, ok := c.m.getClient()
+	cli, addr, ok := c.m.getClient()
 	if !ok {
 		return 0, ErrNodeUnavailable
 	}
-	bigint, err := cli.BalanceAt(context.TODO(), common.HexToAddress(account), nil)
+	var bigint *big.Int
+	err := withRetry(func() error {
+		ctx, cancelFn := context.WithTimeout(context.Background(), rpcCallTimeout)
+		defer cancelFn()
+		var err error
+		bigint, err = cli.BalanceAt(ctx, common.HexToAddress(account), nil)
+		return err
+	})
 	if err != nil {
-		// m.failNode(addr)
+		c.m.backend.Fail(addr)
 		return 0, err
 	}
 	wei := ethfw.BigWei(bigint)
@@ -47,7 +53,7 @@ func (m *manager) bindATL(address string, abi []byte) (TokenManager, error) {
 	} else if abi == nil {
 		return nil, ErrNoABI
 	}
-	cli, _, ok := m.getClient()
+	cli, addr, ok := m.getClient()
 	if !ok {
 		return nil, ErrNodeUnavailable
 	}
@@ -61,19 +67,15 @@ func (m *manager) bindATL(address string, abi []byte) (TokenManager, error) {
 	return &atlManager{
 		baseContract: baseContract{
 			contract: boundContract,
+			addr:     addr,
 			m:        m,
 		},
 	}, nil
 }
 
 func (c *atlManager) AccountBalance(account string) (float64, error) {
-	opts := &bind.CallOpts{
-		Context: context.TODO(),
-	}
 	balance := new(*big.Int)
-	err := c.contract.Call(opts, balance, "balanceOf", common.HexToAddress(account))
-	if err != nil {
-		// c.m.failNode(addr)
+	if err := c.call(balance, "balanceOf", common.HexToAddress(account)); err != nil {
 		return 0, ErrNodeUnavailable
 	}
 	wei := ethfw.BigWei(*balance)
@@ -90,7 +92,7 @@ func (m *manager) bindPTO(address string, abi []byte) (TokenManager, error) {
 	} else if abi == nil {
 		return nil, ErrNoABI
 	}
-	cli, _, ok := m.getClient()
+	cli, addr, ok := m.getClient()
 	if !ok {
 		return nil, ErrNodeUnavailable
 	}
@@ -104,19 +106,15 @@ func (m *manager) bindPTO(address string, abi []byte) (TokenManager, error) {
 	return &ptoManager{
 		baseContract: baseContract{
 			contract: boundContract,
+			addr:     addr,
 			m:        m,
 		},
 	}, nil
 }
 
 func (c *ptoManager) AccountBalance(account string) (float64, error) {
-	opts := &bind.CallOpts{
-		Context: context.TODO(),
-	}
 	balance := new(*big.Int)
-	err := c.contract.Call(opts, balance, "balanceOf", common.HexToAddress(account))
-	if err != nil {
-		// c.m.failNode(addr)
+	if err := c.call(balance, "balanceOf", common.HexToAddress(account)); err != nil {
 		return 0, ErrNodeUnavailable
 	}
 	wei := ethfw.BigWei(*balance)
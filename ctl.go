@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/api"
+)
+
+// ctl commands manage the node running with the same state dir over its private API,
+// the address and the token are found in the state dir.
+
+func ctlCmd(c *cli.Cmd) {
+	c.Command("status", "Show state, peers and sync of the node.", ctlStatusCmd)
+	c.Command("peers", "List swarm peers of the node.", ctlPeersCmd)
+	c.Command("sync", "Fetch records changed on peers since the last sync.", ctlSyncCmd)
+	c.Command("gc", "Run garbage collection.", gcCmd)
+	c.Command("compact-state", "Run value log GC of the badger state store.", ctlCompactStateCmd)
+	c.Command("shutdown", "Stop the node gracefully.", ctlShutdownCmd)
+	c.Command("join-token", "Mint a token a new testnet node joins with.", ctlJoinTokenCmd)
+	c.Command("log-level", "Show or change log levels of modules.", ctlLogLevelCmd)
+	c.Command("plugins", "List plugins of the node.", ctlPluginsCmd)
+	c.Command("mirror", "Show or change the mirror policy of the node.", ctlMirrorCmd)
+	c.Command("cold-tier", "Show the cold tier of the node or run a pass of it.", ctlColdTierCmd)
+	c.Command("dry-run", "Show transactions simulated in dry-run mode or simulate a distribution round.", ctlDryRunCmd)
+	c.Command("call", "Send a request to any route of the private API.", ctlCallCmd)
+}
+
+// ctlRequest sends a request to the private API of the running node, responses
+// with a status other than 2xx are returned as errors.
+func ctlRequest(method, path string, timeout time.Duration) ([]byte, error) {
+	info, err := readPrivateAPIFile()
+	if err != nil {
+		err = fmt.Errorf("failed to find the running node: %v", err)
+		return nil, err
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", info.Addr, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+info.Token)
+	resp, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024*1024))
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("node responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		return nil, err
+	}
+	return body, nil
+}
+
+func printJSON(data []byte) {
+	var v json.RawMessage
+	if err := json.Unmarshal(data, &v); err != nil {
+		os.Stdout.Write(data)
+		return
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func ctlStatusCmd(c *cli.Cmd) {
+	asJSON := c.Bool(cli.BoolOpt{
+		Name:  "json",
+		Desc:  "Print the status as JSON.",
+		Value: false,
+	})
+	c.Action = func() {
+		body, err := ctlRequest("GET", "/private/v1/status", time.Minute)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if *asJSON {
+			printJSON(body)
+			return
+		}
+		var status api.NodeStatus
+		if err := json.Unmarshal(body, &status); err != nil {
+			log.Fatalln("failed to read status:", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Node ID:\t%s\n", status.NodeID)
+		fmt.Fprintf(w, "Session:\t%s\n", status.SessionID)
+		fmt.Fprintf(w, "Version:\t%s, protocol %d\n", status.Version, status.Protocol)
+		fmt.Fprintf(w, "Network:\t%s\n", status.Network)
+		if k := status.SwarmKey; k != nil {
+			if len(k.Public) > 0 {
+				fmt.Fprintf(w, "Swarm key:\t%s, published %s key\n", k.Fingerprint, k.Public)
+			} else {
+				fmt.Fprintf(w, "Swarm key:\t%s\n", k.Fingerprint)
+			}
+		}
+		fmt.Fprintf(w, "Uptime:\t%s\n", status.Uptime)
+		fmt.Fprintf(w, "Ready:\t%v\n", status.Ready)
+		fmt.Fprintf(w, "Peers:\t%d\n", status.Peers)
+		if n := status.NAT; n != nil {
+			fmt.Fprintf(w, "Reachability:\t%s\n", n.Reachability)
+			for _, addr := range n.ExternalAddrs {
+				fmt.Fprintf(w, "External address:\t%s\n", addr)
+			}
+		}
+		if q := status.Queues; q != nil {
+			fmt.Fprintf(w, "Queued announces:\t%d inbound, %d outbound\n", q.InboundDepth, q.OutboundDepth)
+		}
+		if d := status.StateDamage; d != nil {
+			fmt.Fprintf(w, "State:\tdegraded since %s, %s, %d keys quarantined\n",
+				d.DetectedAt.Format(time.RFC3339), d.Action, d.QuarantinedTotal)
+		}
+		if s := status.Sync; s != nil {
+			fmt.Fprintf(w, "Syncs:\t%d, %d failed\n", s.Syncs, s.Failures)
+			if s.Running {
+				fmt.Fprintf(w, "Sync:\tin progress\n")
+			}
+			if cp := s.Checkpoint; cp != nil {
+				fmt.Fprintf(w, "Last sync:\t%s\n", cp.Time.Format(time.RFC3339))
+			}
+		}
+		if b := status.Beats; b != nil {
+			lastTick, lastInfo := "never", "never"
+			if b.LastTick != nil {
+				lastTick = b.LastTick.Format(time.RFC3339)
+			}
+			if b.LastInfo != nil {
+				lastInfo = b.LastInfo.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "Last beat:\ttick %s, info %s, ticks every %s\n", lastTick, lastInfo, b.TickInterval)
+		}
+		w.Flush()
+	}
+}
+
+func ctlPeersCmd(c *cli.Cmd) {
+	asJSON := c.Bool(cli.BoolOpt{
+		Name:  "json",
+		Desc:  "Print peers as JSON.",
+		Value: false,
+	})
+	c.Action = func() {
+		body, err := ctlRequest("GET", "/private/v1/peers", time.Minute)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if *asJSON {
+			printJSON(body)
+			return
+		}
+		var peers []*api.ClusterPeer
+		if err := json.Unmarshal(body, &peers); err != nil {
+			log.Fatalln("failed to read peers:", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NODE\tLATENCY\tPROTOCOL\tPERMISSIONS\tAGENT")
+		for _, p := range peers {
+			if p.SwarmPeer == nil {
+				continue
+			}
+			protocol := "-"
+			if p.Version != nil {
+				protocol = fmt.Sprint(*p.Version)
+			}
+			perms := make([]string, 0, len(p.Permissions))
+			for _, perm := range p.Permissions {
+				perms = append(perms, string(perm))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.NodeID,
+				time.Duration(p.Latency*float64(time.Second)).Round(time.Millisecond),
+				protocol, strings.Join(perms, ","), p.AgentVersion)
+		}
+		w.Flush()
+	}
+}
+
+func ctlSyncCmd(c *cli.Cmd) {
+	c.Action = func() {
+		log.Println("syncing records, it may take a while")
+		body, err := ctlRequest("POST", "/private/v1/sync", time.Hour)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printJSON(body)
+	}
+}
+
+func ctlCompactStateCmd(c *cli.Cmd) {
+	c.Action = func() {
+		body, err := ctlRequest("POST", "/private/v1/state/maintenance", time.Hour)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printJSON(body)
+	}
+}
+
+func ctlShutdownCmd(c *cli.Cmd) {
+	c.Action = func() {
+		if _, err := ctlRequest("POST", "/private/v1/shutdown", time.Minute); err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("node is shutting down")
+	}
+}
+
+func ctlJoinTokenCmd(c *cli.Cmd) {
+	ttl := c.String(cli.StringOpt{
+		Name:  "ttl",
+		Desc:  "How long the token may be redeemed.",
+		Value: "72h",
+	})
+	c.Action = func() {
+		body, err := ctlRequest("POST", "/private/v1/join-tokens?ttl="+url.QueryEscape(*ttl), time.Minute)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		var token api.JoinTokenResponse
+		if err := json.Unmarshal(body, &token); err != nil {
+			log.Fatalln("failed to read the join token:", err)
+		}
+		log.Printf("join token %s expires at %s, the new node runs:", token.ID, token.ExpiresAt.Format(time.RFC3339))
+		fmt.Printf("atlant-go init --join-token %s\n", token.Token)
+	}
+}
+
+func ctlLogLevelCmd(c *cli.Cmd) {
+	c.Spec = "[--for] [MODULE LEVEL]"
+	period := c.String(cli.StringOpt{
+		Name:  "for",
+		Desc:  "Restore the previous level after this long, e.g. 15m (default: keep the level).",
+		Value: "",
+	})
+	module := c.StringArg("MODULE", "", "Module to change: api, rs, fs, state, contracts, authcenter or default.")
+	level := c.StringArg("LEVEL", "", "New level: a name like debug or 0...5.")
+	c.Action = func() {
+		if len(*module) == 0 {
+			body, err := ctlRequest("GET", "/private/v1/log-levels", time.Minute)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			printJSON(body)
+			return
+		}
+		q := url.Values{}
+		q.Set("level", *level)
+		if len(*period) > 0 {
+			q.Set("for", *period)
+		}
+		body, err := ctlRequest("PUT", "/private/v1/log-levels/"+url.PathEscape(*module)+"?"+q.Encode(), time.Minute)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printJSON(body)
+	}
+}
+
+func ctlMirrorCmd(c *cli.Cmd) {
+	c.Spec = "[--prefixes] [--namespaces] [--full | --apply]"
+	prefixes := c.String(cli.StringOpt{
+		Name:  "prefixes",
+		Desc:  "Comma-separated path prefixes of records to pin, e.g. /docs/de/.",
+		Value: "",
+	})
+	namespaces := c.String(cli.StringOpt{
+		Name:  "namespaces",
+		Desc:  "Comma-separated namespaces of records to pin.",
+		Value: "",
+	})
+	full := c.Bool(cli.BoolOpt{
+		Name:  "full",
+		Desc:  "Drop the policy, the node becomes a full replica.",
+		Value: false,
+	})
+	apply := c.Bool(cli.BoolOpt{
+		Name:  "apply",
+		Desc:  "Run a mirror pass now.",
+		Value: false,
+	})
+	c.Action = func() {
+		method, path := "GET", "/private/v1/mirror"
+		if *apply {
+			method, path = "POST", "/private/v1/mirror/apply"
+		} else if *full || len(*prefixes) > 0 || len(*namespaces) > 0 {
+			q := url.Values{}
+			q.Set("prefixes", *prefixes)
+			q.Set("namespaces", *namespaces)
+			method, path = "PUT", "/private/v1/mirror?"+q.Encode()
+		}
+		body, err := ctlRequest(method, path, time.Hour)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printJSON(body)
+	}
+}
+
+func ctlColdTierCmd(c *cli.Cmd) {
+	c.Spec = "[--apply]"
+	apply := c.Bool(cli.BoolOpt{
+		Name:  "apply",
+		Desc:  "Offload versions not read for a while now.",
+		Value: false,
+	})
+	c.Action = func() {
+		method, path := "GET", "/private/v1/cold-tier"
+		if *apply {
+			method, path = "POST", "/private/v1/cold-tier/apply"
+		}
+		body, err := ctlRequest(method, path, time.Hour)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printJSON(body)
+	}
+}
+
+func ctlDryRunCmd(c *cli.Cmd) {
+	c.Spec = "[--distribute]"
+	pool := c.String(cli.StringOpt{
+		Name: "distribute",
+		Desc: "Simulate a distribution round of the pool, in wei.",
+	})
+	c.Action = func() {
+		method, path := "GET", "/private/v1/dry-run"
+		if len(*pool) > 0 {
+			method, path = "POST", "/private/v1/dry-run/distribution?pool="+url.QueryEscape(*pool)
+		}
+		body, err := ctlRequest(method, path, time.Minute)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printJSON(body)
+	}
+}
+
+func ctlCallCmd(c *cli.Cmd) {
+	c.Spec = "[--method] PATH"
+	method := c.String(cli.StringOpt{
+		Name:  "X method",
+		Desc:  "HTTP method of the request.",
+		Value: "GET",
+	})
+	path := c.StringArg("PATH", "", "Route of the private API, e.g. /private/v1/clock.")
+	c.Action = func() {
+		p := *path
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		body, err := ctlRequest(strings.ToUpper(*method), p, time.Hour)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printJSON(body)
+	}
+}
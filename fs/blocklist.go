@@ -0,0 +1,223 @@
+package fs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	inet "github.com/AtlantPlatform/go-ipfs/go-libp2p-net"
+	ma "github.com/AtlantPlatform/go-ipfs/go-multiaddr"
+)
+
+// Blocklist feeds are plain text files or URLs with one entry per line: a peer ID,
+// an IP address or a CIDR network. Lines starting with # are ignored.
+
+type BlocklistStats struct {
+	Sources     []string  `json:"sources"`
+	Peers       int       `json:"peers"`
+	Networks    int       `json:"networks"`
+	LastRefresh time.Time `json:"last_refresh"`
+	Refused     uint64    `json:"refused_total"`
+	Dropped     uint64    `json:"dropped_total"`
+}
+
+type peerBlocklist struct {
+	sources []string
+	client  *http.Client
+
+	mux         *sync.RWMutex
+	peers       map[string]struct{}
+	networks    []*net.IPNet
+	lastRefresh time.Time
+
+	refused uint64
+	dropped uint64
+}
+
+func newPeerBlocklist(sources []string) *peerBlocklist {
+	return &peerBlocklist{
+		sources: sources,
+		client: &http.Client{
+			Timeout: time.Minute,
+		},
+		mux:   new(sync.RWMutex),
+		peers: make(map[string]struct{}),
+	}
+}
+
+func (b *peerBlocklist) Refresh() error {
+	peers := make(map[string]struct{})
+	var networks []*net.IPNet
+	for _, src := range b.sources {
+		if err := b.load(src, peers, &networks); err != nil {
+			// keep the previous state of the list if a feed is unavailable
+			err = fmt.Errorf("failed to load blocklist %s: %v", src, err)
+			return err
+		}
+	}
+	b.mux.Lock()
+	b.peers = peers
+	b.networks = networks
+	b.lastRefresh = time.Now()
+	b.mux.Unlock()
+	return nil
+}
+
+func (b *peerBlocklist) load(src string, peers map[string]struct{}, networks *[]*net.IPNet) error {
+	var rd io.ReadCloser
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := b.client.Get(src)
+		if err != nil {
+			return err
+		} else if resp.StatusCode != 200 {
+			resp.Body.Close()
+			return fmt.Errorf("feed responded with status %d", resp.StatusCode)
+		}
+		rd = resp.Body
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		rd = f
+	}
+	defer rd.Close()
+	s := bufio.NewScanner(rd)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = strings.TrimSpace(line[:idx])
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(line); err == nil {
+			*networks = append(*networks, ipNet)
+		} else if ip := net.ParseIP(line); ip != nil {
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			*networks = append(*networks, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+		} else {
+			peers[line] = struct{}{}
+		}
+	}
+	return s.Err()
+}
+
+func (b *peerBlocklist) IsBlocked(peerID string, addr ma.Multiaddr) bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	if _, ok := b.peers[peerID]; ok {
+		return true
+	}
+	if addr == nil || len(b.networks) == 0 {
+		return false
+	}
+	var ipStr string
+	if v, err := addr.ValueForProtocol(ma.P_IP4); err == nil {
+		ipStr = v
+	} else if v, err := addr.ValueForProtocol(ma.P_IP6); err == nil {
+		ipStr = v
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range b.networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Enforce closes existing connections to peers that are blocked now.
+func (b *peerBlocklist) Enforce(network inet.Network) {
+	for _, c := range network.Conns() {
+		if b.IsBlocked(c.RemotePeer().Pretty(), c.RemoteMultiaddr()) {
+			atomic.AddUint64(&b.dropped, 1)
+			log.WithField("peer", c.RemotePeer().Pretty()).Infoln("dropping connection to a blocklisted peer")
+			c.Close()
+		}
+	}
+}
+
+// Notifiee refuses new connections with blocked peers right after they are established.
+func (b *peerBlocklist) Notifiee() inet.Notifiee {
+	return &inet.NotifyBundle{
+		ConnectedF: func(n inet.Network, c inet.Conn) {
+			if b.IsBlocked(c.RemotePeer().Pretty(), c.RemoteMultiaddr()) {
+				atomic.AddUint64(&b.refused, 1)
+				log.WithField("peer", c.RemotePeer().Pretty()).Debugln("refusing connection from a blocklisted peer")
+				go c.Close()
+			}
+		},
+	}
+}
+
+func (b *peerBlocklist) Stats() *BlocklistStats {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return &BlocklistStats{
+		Sources:     b.sources,
+		Peers:       len(b.peers),
+		Networks:    len(b.networks),
+		LastRefresh: b.lastRefresh,
+		Refused:     atomic.LoadUint64(&b.refused),
+		Dropped:     atomic.LoadUint64(&b.dropped),
+	}
+}
+
+func (s *ipfsStore) startBlocklist() {
+	if len(s.opts.BlocklistSources) == 0 {
+		return
+	}
+	s.blocklist = newPeerBlocklist(s.opts.BlocklistSources)
+	if err := s.blocklist.Refresh(); err != nil {
+		log.Warningln(err)
+	}
+	network := s.node.PeerHost.Network()
+	network.Notify(s.blocklist.Notifiee())
+	s.blocklist.Enforce(network)
+	refresh := s.opts.BlocklistRefresh
+	if refresh <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(refresh)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.node.Context().Done():
+				return
+			case <-t.C:
+				if err := s.blocklist.Refresh(); err != nil {
+					log.Warningln(err)
+					continue
+				}
+				s.blocklist.Enforce(network)
+			}
+		}
+	}()
+}
+
+func (s *ipfsStore) BlocklistStats() *BlocklistStats {
+	if s.blocklist == nil {
+		return nil
+	}
+	return s.blocklist.Stats()
+}
@@ -0,0 +1,342 @@
+package fs
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	blocks "github.com/AtlantPlatform/go-ipfs/go-block-format"
+	cid "github.com/AtlantPlatform/go-ipfs/go-cid"
+)
+
+// CAR v1 format is used to move raw blocks between nodes: a varint-prefixed dag-cbor
+// header followed by varint-prefixed sections of CID bytes and block data.
+// Blocks keep their CIDs, so object versions stay valid on the importing node.
+// Archives carry data besides objects in raw blocks listed first in the roots, roots
+// of object versions are dag-pb, so importers tell them apart by the codec.
+
+var ErrCarFormat = errors.New("malformed CAR stream")
+
+const maxCarSectionSize = 8 * 1024 * 1024
+
+// MaxArchiveDataSize bounds a data block of archives.
+const MaxArchiveDataSize = maxCarSectionSize - 64
+
+// rawPrefix makes CIDv1 of raw blocks with sha2-256 hashes.
+var rawPrefix = cid.Prefix{
+	Version:  1,
+	Codec:    cid.Raw,
+	MhType:   0x12,
+	MhLength: -1,
+}
+
+func (s *ipfsStore) ExportBlocks(ctx context.Context, versions []string, wr io.Writer) error {
+	return s.ExportArchive(ctx, nil, versions, wr)
+}
+
+func (s *ipfsStore) ExportArchive(ctx context.Context, data [][]byte, versions []string, wr io.Writer) error {
+	roots := make([]*cid.Cid, 0, len(data)+len(versions))
+	dataCids := make([]*cid.Cid, 0, len(data))
+	for _, v := range data {
+		if len(v) > MaxArchiveDataSize {
+			return fmt.Errorf("data block of %d bytes exceeds %d", len(v), MaxArchiveDataSize)
+		}
+		c, err := rawPrefix.Sum(v)
+		if err != nil {
+			return err
+		}
+		dataCids = append(dataCids, c)
+	}
+	roots = append(roots, dataCids...)
+	for _, v := range versions {
+		c, err := cid.Decode(v)
+		if err != nil {
+			err = fmt.Errorf("failed to decode version CID %s: %v", v, err)
+			return err
+		}
+		roots = append(roots, c)
+	}
+	bw := bufio.NewWriter(wr)
+	if err := writeCarSection(bw, carHeader(roots)); err != nil {
+		return err
+	}
+	for i, c := range dataCids {
+		if err := writeCarSection(bw, c.Bytes(), data[i]); err != nil {
+			return err
+		}
+	}
+	seen := make(map[string]struct{})
+	var walk func(c *cid.Cid) error
+	walk = func(c *cid.Cid) error {
+		if _, ok := seen[c.KeyString()]; ok {
+			return nil
+		}
+		seen[c.KeyString()] = struct{}{}
+		node, err := s.node.DAG.Get(ctx, c)
+		if err != nil {
+			err = fmt.Errorf("failed to get DAG node %s: %v", c.String(), err)
+			return err
+		}
+		if err := writeCarSection(bw, c.Bytes(), node.RawData()); err != nil {
+			return err
+		}
+		for _, link := range node.Links() {
+			if err := walk(link.Cid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, c := range roots[len(dataCids):] {
+		if err := walk(c); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ImportBlocks reads a CAR stream and puts every block into the local blockstore,
+// blocks are verified against their CIDs. Imported DAGs must be pinned by the caller.
+func (s *ipfsStore) ImportBlocks(ctx context.Context, rd io.Reader) (int, error) {
+	return s.ImportArchive(ctx, rd, nil)
+}
+
+func (s *ipfsStore) ImportArchive(ctx context.Context, rd io.Reader, fn func(data []byte) error) (int, error) {
+	br := bufio.NewReader(rd)
+	header, err := readCarSection(br)
+	if err != nil {
+		err = fmt.Errorf("failed to read CAR header: %v", err)
+		return 0, err
+	}
+	// raw roots are data blocks, unless the caller doesn't take them
+	dataCids := make(map[string]struct{})
+	if fn != nil {
+		roots, err := carRoots(header)
+		if err != nil {
+			err = fmt.Errorf("failed to read CAR header: %v", err)
+			return 0, err
+		}
+		for _, c := range roots {
+			if c.Type() == cid.Raw {
+				dataCids[c.KeyString()] = struct{}{}
+			}
+		}
+	}
+	var count int
+	for {
+		select {
+		case <-ctx.Done():
+			return count, ctx.Err()
+		default:
+		}
+		section, err := readCarSection(br)
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+		n, err := cidPrefixLen(section)
+		if err != nil {
+			return count, err
+		}
+		c, err := cid.Cast(section[:n])
+		if err != nil {
+			err = fmt.Errorf("failed to decode block CID: %v", err)
+			return count, err
+		}
+		data := section[n:]
+		if sum, err := c.Prefix().Sum(data); err != nil {
+			return count, err
+		} else if !sum.Equals(c) {
+			return count, fmt.Errorf("block data does not match CID %s", c.String())
+		}
+		if _, ok := dataCids[c.KeyString()]; ok {
+			if err := fn(data); err != nil {
+				return count, err
+			}
+			continue
+		}
+		block, err := blocks.NewBlockWithCid(data, c)
+		if err != nil {
+			return count, err
+		}
+		if err := s.node.Blockstore.Put(block); err != nil {
+			err = fmt.Errorf("failed to put block %s: %v", c.String(), err)
+			return count, err
+		}
+		count++
+	}
+}
+
+func writeCarSection(w io.Writer, parts ...[]byte) error {
+	var size int
+	for _, p := range parts {
+		size += len(p)
+	}
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(size))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readCarSection(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	} else if size > maxCarSectionSize {
+		return nil, ErrCarFormat
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// carHeader encodes {"roots": [CID...], "version": 1} as canonical dag-cbor.
+func carHeader(roots []*cid.Cid) []byte {
+	buf := []byte{0xa2, 0x65}
+	buf = append(buf, "roots"...)
+	buf = cborHead(buf, 0x80, uint64(len(roots)))
+	for _, c := range roots {
+		// tag 42 followed by a byte string with the identity multibase prefix
+		data := c.Bytes()
+		buf = append(buf, 0xd8, 0x2a)
+		buf = cborHead(buf, 0x40, uint64(len(data)+1))
+		buf = append(buf, 0x00)
+		buf = append(buf, data...)
+	}
+	buf = append(buf, 0x67)
+	buf = append(buf, "version"...)
+	return append(buf, 0x01)
+}
+
+// carRoots decodes roots of a header written by carHeader, keys of the map may go in any order.
+func carRoots(header []byte) ([]*cid.Cid, error) {
+	major, n, off, err := readCborHead(header, 0)
+	if err != nil {
+		return nil, err
+	} else if major != 0xa0 {
+		return nil, ErrCarFormat
+	}
+	var roots []*cid.Cid
+	for i := uint64(0); i < n; i++ {
+		major, size, next, err := readCborHead(header, off)
+		if err != nil {
+			return nil, err
+		} else if major != 0x60 || next+int(size) > len(header) {
+			return nil, ErrCarFormat
+		}
+		key := string(header[next : next+int(size)])
+		off = next + int(size)
+		if key != "roots" {
+			// the version is a small integer
+			if _, _, off, err = readCborHead(header, off); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		major, count, next, err := readCborHead(header, off)
+		if err != nil {
+			return nil, err
+		} else if major != 0x80 {
+			return nil, ErrCarFormat
+		}
+		off = next
+		for j := uint64(0); j < count; j++ {
+			if off+2 > len(header) || header[off] != 0xd8 || header[off+1] != 0x2a {
+				return nil, ErrCarFormat
+			}
+			major, size, next, err := readCborHead(header, off+2)
+			if err != nil {
+				return nil, err
+			} else if major != 0x40 || size < 1 || next+int(size) > len(header) {
+				return nil, ErrCarFormat
+			}
+			// skip the identity multibase prefix
+			c, err := cid.Cast(header[next+1 : next+int(size)])
+			if err != nil {
+				return nil, err
+			}
+			roots = append(roots, c)
+			off = next + int(size)
+		}
+	}
+	return roots, nil
+}
+
+// readCborHead decodes the head of a CBOR item at off, it returns the major type, the
+// argument and the offset past the head.
+func readCborHead(buf []byte, off int) (byte, uint64, int, error) {
+	if off >= len(buf) {
+		return 0, 0, 0, ErrCarFormat
+	}
+	major, info := buf[off]&0xe0, buf[off]&0x1f
+	off++
+	if info < 24 {
+		return major, uint64(info), off, nil
+	}
+	size := 1 << (info - 24)
+	if info > 27 || off+size > len(buf) {
+		return 0, 0, 0, ErrCarFormat
+	}
+	var n uint64
+	for _, b := range buf[off : off+size] {
+		n = n<<8 | uint64(b)
+	}
+	return major, n, off + size, nil
+}
+
+func cborHead(buf []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(buf, major|byte(n))
+	case n <= 0xff:
+		return append(buf, major|24, byte(n))
+	case n <= 0xffff:
+		return append(buf, major|25, byte(n>>8), byte(n))
+	case n <= 0xffffffff:
+		return append(buf, major|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	default:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], n)
+		return append(append(buf, major|27), b[:]...)
+	}
+}
+
+// cidPrefixLen returns the length of the CID at the beginning of a CAR section.
+func cidPrefixLen(buf []byte) (int, error) {
+	if len(buf) >= 34 && buf[0] == 0x12 && buf[1] == 0x20 {
+		// CIDv0 is a bare sha2-256 multihash
+		return 34, nil
+	}
+	var offset int
+	// version, codec, multihash code, multihash length
+	var values [4]uint64
+	for i := range values {
+		v, n := binary.Uvarint(buf[offset:])
+		if n <= 0 {
+			return 0, ErrCarFormat
+		}
+		values[i] = v
+		offset += n
+	}
+	if values[3] > uint64(len(buf)) {
+		return 0, ErrCarFormat
+	}
+	offset += int(values[3])
+	if offset > len(buf) {
+		return 0, ErrCarFormat
+	}
+	return offset, nil
+}
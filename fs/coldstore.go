@@ -0,0 +1,223 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Cold stores keep objects offloaded from the repo by the cold tier of the record store,
+// the only one implemented is an S3-compatible bucket. Requests are signed with AWS Signature
+// Version 4, bodies of uploads are hashed upfront, so buckets that refuse unsigned payloads
+// accept them as well.
+
+// ColdStore keeps blobs offloaded from the repo by their keys.
+type ColdStore interface {
+	// Put uploads size bytes of r, r is read twice: to sign the request and to send it.
+	Put(ctx context.Context, key string, r io.ReadSeeker, size int64) error
+	// Get returns ErrNotFound if the store has no blob with the key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// String names the store in logs and stats.
+	String() string
+}
+
+var ErrColdStoreConfig = errors.New("cold store needs a bucket, an access key and a secret key")
+
+// S3Config sets the S3-compatible bucket of the cold store. Buckets of AWS are addressed
+// virtual-hosted style when Endpoint is empty, buckets behind custom endpoints, e.g. MinIO,
+// path style.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+}
+
+const (
+	s3Service        = "s3"
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3DateFormat     = "20060102T150405Z"
+	s3DefaultRegion  = "us-east-1"
+	s3RequestTimeout = time.Hour
+)
+
+type s3Store struct {
+	cfg    S3Config
+	base   *url.URL
+	client *http.Client
+}
+
+// NewS3Store returns the cold store of the S3-compatible bucket.
+func NewS3Store(cfg *S3Config) (ColdStore, error) {
+	if cfg == nil || len(cfg.Bucket) == 0 || len(cfg.AccessKey) == 0 || len(cfg.SecretKey) == 0 {
+		return nil, ErrColdStoreConfig
+	}
+	s := &s3Store{
+		cfg: *cfg,
+		client: &http.Client{
+			Timeout: s3RequestTimeout,
+		},
+	}
+	if len(s.cfg.Region) == 0 {
+		s.cfg.Region = s3DefaultRegion
+	}
+	var err error
+	if len(s.cfg.Endpoint) == 0 {
+		s.base, err = url.Parse(fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", s.cfg.Bucket, s.cfg.Region))
+	} else {
+		endpoint := s.cfg.Endpoint
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+		s.base, err = url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + s.cfg.Bucket + "/")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad S3 endpoint: %v", err)
+	}
+	return s, nil
+}
+
+func (s *s3Store) String() string {
+	return "s3://" + s.cfg.Bucket + "/" + s.cfg.Prefix
+}
+
+func (s *s3Store) objectURL(key string) *url.URL {
+	u := *s.base
+	u.Path = u.Path + s.cfg.Prefix + key
+	u.RawPath = s3EscapePath(u.Path)
+	return &u
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, r io.ReadSeeker, size int64) error {
+	h := sha256.New()
+	if _, err := io.CopyN(h, r, size); err != nil {
+		return err
+	} else if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", s.objectURL(key).String(), ioutil.NopCloser(io.LimitReader(r, size)))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := s.do(ctx, req, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, req, s3EmptyHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequest("DELETE", s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, req, s3EmptyHash)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+var s3EmptyHash = hex.EncodeToString(sha256.New().Sum(nil))
+
+// do signs and sends the request, responses other than 2xx are returned as errors.
+func (s *s3Store) do(ctx context.Context, req *http.Request, payloadHash string) (*http.Response, error) {
+	s.sign(req, payloadHash, time.Now().UTC())
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode == 404 {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	} else if resp.StatusCode/100 != 2 {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 responded with %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return resp, nil
+}
+
+// sign adds the Authorization header of AWS Signature Version 4 to the request.
+func (s *s3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format(s3DateFormat)
+	scope := strings.Join([]string{amzDate[:8], s.cfg.Region, s3Service, "aws4_request"}, "/")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+	key := []byte("AWS4" + s.cfg.SecretKey)
+	for _, part := range []string{amzDate[:8], s.cfg.Region, s3Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath escapes all but unreserved characters and slashes, as signatures expect.
+func s3EscapePath(path string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,466 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+
+	"github.com/AtlantPlatform/go-ipfs/core"
+	cid "github.com/AtlantPlatform/go-ipfs/go-cid"
+	"github.com/AtlantPlatform/go-ipfs/go-ipfs-cmdkit/files"
+	ipld "github.com/AtlantPlatform/go-ipfs/go-ipld-format"
+	ipath "github.com/AtlantPlatform/go-ipfs/path"
+	uio "github.com/AtlantPlatform/go-ipfs/unixfs/io"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+)
+
+// Erasure coded objects are split into data and parity shards with Reed-Solomon coding, any
+// DataShards of them restore the content. Shards are added as separate files that the object
+// doesn't link to: the object has the meta and a "shards" file listing their CIDs in place of
+// the content, so pinning an object version doesn't pin its shards and a node may keep only
+// some of them. Rebuilt shards are added the same way, so they get their original CIDs.
+
+var (
+	ErrNotErasure  = errors.New("object is not erasure coded")
+	ErrShardsLost  = errors.New("not enough shards are available to restore the object")
+	errBadShardSet = errors.New("malformed shard set")
+)
+
+const (
+	shardsFileName  = "shards"
+	maxShardSetSize = 1024 * 1024
+	// shardFetchTimeout limits the lookup of a shard on peers, it's considered missing after that.
+	shardFetchTimeout = 30 * time.Second
+)
+
+// ErasureLayout is the number of data and parity shards objects are split into,
+// up to 256 in total.
+type ErasureLayout struct {
+	DataShards   int
+	ParityShards int
+}
+
+// ShardSet lists shards of an erasure coded object, data shards go first.
+type ShardSet struct {
+	DataShards   int `json:"data_shards"`
+	ParityShards int `json:"parity_shards"`
+	// Size is the size of the object content.
+	Size   int64    `json:"size"`
+	Shards []string `json:"shards"`
+}
+
+func (set *ShardSet) validate() error {
+	if set.DataShards < 1 || set.ParityShards < 0 || set.Size < 0 ||
+		len(set.Shards) != set.DataShards+set.ParityShards {
+		return errBadShardSet
+	}
+	return nil
+}
+
+func (s *ipfsStore) PutErasureObject(ctx context.Context, ref ObjectRef, userMeta []byte,
+	body io.ReadCloser, layout ErasureLayout) (*ObjectRef, *ShardSet, error) {
+	defer body.Close()
+	if ref.Size <= 0 {
+		err := errors.New("size of an erasure coded object must be known")
+		return nil, nil, err
+	}
+	enc, err := reedsolomon.NewStream(layout.DataShards, layout.ParityShards)
+	if err != nil {
+		err = fmt.Errorf("bad erasure layout: %v", err)
+		return nil, nil, err
+	}
+	shards, err := newShardFiles(layout.DataShards+layout.ParityShards, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer shards.remove()
+	data, parity := shards[:layout.DataShards], shards[layout.DataShards:]
+	if err := enc.Split(body, data.writers(), ref.Size); err != nil {
+		err = fmt.Errorf("failed to split object into shards: %v", err)
+		return nil, nil, err
+	} else if err := data.rewind(); err != nil {
+		return nil, nil, err
+	}
+	if err := enc.Encode(data.readers(), parity.writers()); err != nil {
+		err = fmt.Errorf("failed to encode parity shards: %v", err)
+		return nil, nil, err
+	} else if err := shards.rewind(); err != nil {
+		return nil, nil, err
+	}
+	set := &ShardSet{
+		DataShards:   layout.DataShards,
+		ParityShards: layout.ParityShards,
+		Size:         ref.Size,
+		Shards:       make([]string, len(shards)),
+	}
+	for i, f := range shards {
+		if set.Shards[i], err = s.addShard(ctx, f); err != nil {
+			err = fmt.Errorf("failed to add shard %d: %v", i, err)
+			return nil, nil, err
+		}
+	}
+	if len(ref.ID) == 0 {
+		ref.ID = proto.NewID()
+	}
+	meta, err := ref.ToProto()
+	if err != nil {
+		err = fmt.Errorf("failed to create object meta: %v", err)
+		return nil, nil, err
+	}
+	meta.SetUserMeta(string(userMeta))
+	file, err := newShardedObjectFile(meta, set)
+	if err != nil {
+		err = fmt.Errorf("failed to create object file: %v", err)
+		return nil, nil, err
+	}
+	version, err := s.addFile(ctx, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	ref.Version = version
+	meta.SetVersion(ref.Version)
+	ref.SetMeta(&meta)
+	return &ref, set, nil
+}
+
+// newShardedObjectFile is the object file of an erasure coded object, it holds
+// the shard set instead of the content.
+func newShardedObjectFile(meta proto.ObjectMeta, set *ShardSet) (files.File, error) {
+	data, err := json.Marshal(set)
+	if err != nil {
+		return nil, err
+	}
+	file, err := NewObjectFile(meta, nil)
+	if err != nil {
+		return nil, err
+	}
+	f := file.(*objectFile)
+	f.files = append(f.files, &contentFile{
+		name: shardsFileName,
+		body: ioutil.NopCloser(bytes.NewReader(data)),
+		size: int64(len(data)),
+	})
+	return f, nil
+}
+
+func (s *ipfsStore) addShard(ctx context.Context, f *os.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return s.addFile(ctx, &contentFile{
+		name: "shard",
+		body: ioutil.NopCloser(f),
+		size: info.Size(),
+	})
+}
+
+func (s *ipfsStore) ObjectShards(ctx context.Context, ref ObjectRef) (*ShardSet, error) {
+	p, err := ipath.ParseCidToPath(ref.Version)
+	if err != nil {
+		err = fmt.Errorf("failed to parse object version CID: %v", err)
+		return nil, err
+	}
+	dagNode, err := core.Resolve(ctx, s.node.Namesys, s.resolv, p)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return s.readShardSet(ctx, dagNode)
+}
+
+func (s *ipfsStore) readShardSet(ctx context.Context, dagNode ipld.Node) (*ShardSet, error) {
+	var setNode ipld.Node
+	for _, link := range dagNode.Links() {
+		if link.Name == shardsFileName {
+			n, err := link.GetNode(ctx, s.node.DAG)
+			if err != nil {
+				err = fmt.Errorf("failed to get object shards node: %v", err)
+				return nil, err
+			}
+			setNode = n
+			break
+		}
+	}
+	if setNode == nil {
+		return nil, ErrNotErasure
+	}
+	reader, err := uio.NewDagReader(ctx, setNode, s.node.DAG)
+	if err != nil {
+		err = fmt.Errorf("failed to read object shards: %v", err)
+		return nil, err
+	}
+	defer reader.Close()
+	var set *ShardSet
+	if err := json.NewDecoder(io.LimitReader(reader, maxShardSetSize)).Decode(&set); err != nil || set == nil {
+		return nil, errBadShardSet
+	} else if err := set.validate(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// fetchShards opens DataShards readers of shards found locally or on peers, data shards are preferred
+// over parity ones. Shards that are missing, skipped or not needed have nil readers.
+func (s *ipfsStore) fetchShards(ctx context.Context, set *ShardSet, skip map[int]bool) ([]io.Reader, []io.Closer, error) {
+	readers := make([]io.Reader, len(set.Shards))
+	var closers []io.Closer
+	var found int
+	open := func(indexes []int) {
+		wg := new(sync.WaitGroup)
+		mux := new(sync.Mutex)
+		for _, i := range indexes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				node, err := s.shardNode(ctx, set.Shards[i])
+				if err != nil {
+					return
+				}
+				reader, err := uio.NewDagReader(ctx, node, s.node.DAG)
+				if err != nil {
+					return
+				}
+				mux.Lock()
+				defer mux.Unlock()
+				if found >= set.DataShards {
+					reader.Close()
+					return
+				}
+				readers[i] = reader
+				closers = append(closers, reader)
+				found++
+			}(i)
+		}
+		wg.Wait()
+	}
+	var data, parity []int
+	for i := range set.Shards {
+		if skip[i] {
+			continue
+		} else if i < set.DataShards {
+			data = append(data, i)
+		} else {
+			parity = append(parity, i)
+		}
+	}
+	open(data)
+	if found < set.DataShards {
+		open(parity)
+	}
+	if found < set.DataShards {
+		closeAll(closers)
+		return nil, nil, ErrShardsLost
+	}
+	return readers, closers, nil
+}
+
+func (s *ipfsStore) shardNode(ctx context.Context, version string) (ipld.Node, error) {
+	c, err := cid.Decode(version)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancelFn := context.WithTimeout(ctx, shardFetchTimeout)
+	defer cancelFn()
+	return s.node.DAG.Get(ctx, c)
+}
+
+// openShards restores the content from the shards, missing data shards are
+// rebuilt into temporary files first.
+func (s *ipfsStore) openShards(ctx context.Context, set *ShardSet) (io.ReadCloser, error) {
+	enc, err := reedsolomon.NewStream(set.DataShards, set.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	readers, closers, err := s.fetchShards(ctx, set, nil)
+	if err != nil {
+		return nil, err
+	}
+	var missing []int
+	for i := 0; i < set.DataShards; i++ {
+		if readers[i] == nil {
+			missing = append(missing, i)
+		}
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		defer closeAll(closers)
+		if len(missing) > 0 {
+			rebuilt, err := newShardFiles(len(set.Shards), missing)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			defer rebuilt.remove()
+			if err := enc.Reconstruct(readers, rebuilt.writers()); err != nil {
+				pw.CloseWithError(fmt.Errorf("failed to rebuild missing shards: %v", err))
+				return
+			} else if err := rebuilt.rewind(); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			for _, i := range missing {
+				readers[i] = rebuilt[i]
+			}
+		}
+		pw.CloseWithError(enc.Join(pw, readers[:set.DataShards], set.Size))
+	}()
+	return pr, nil
+}
+
+func (s *ipfsStore) RepairShards(ctx context.Context, set *ShardSet, indexes []int) error {
+	if err := set.validate(); err != nil {
+		return err
+	}
+	enc, err := reedsolomon.NewStream(set.DataShards, set.ParityShards)
+	if err != nil {
+		return err
+	}
+	skip := make(map[int]bool, len(indexes))
+	for _, i := range indexes {
+		if i < 0 || i >= len(set.Shards) {
+			return fmt.Errorf("no shard %d in the set", i)
+		}
+		skip[i] = true
+	}
+	readers, closers, err := s.fetchShards(ctx, set, skip)
+	if err != nil {
+		return err
+	}
+	defer closeAll(closers)
+	rebuilt, err := newShardFiles(len(set.Shards), indexes)
+	if err != nil {
+		return err
+	}
+	defer rebuilt.remove()
+	if err := enc.Reconstruct(readers, rebuilt.writers()); err != nil {
+		err = fmt.Errorf("failed to rebuild shards: %v", err)
+		return err
+	} else if err := rebuilt.rewind(); err != nil {
+		return err
+	}
+	for _, i := range indexes {
+		version, err := s.addShard(ctx, rebuilt[i])
+		if err != nil {
+			err = fmt.Errorf("failed to add shard %d: %v", i, err)
+			return err
+		} else if version != set.Shards[i] {
+			s.UnpinObject(ObjectRef{Version: version})
+			err = fmt.Errorf("rebuilt shard %d doesn't match its CID", i)
+			return err
+		}
+	}
+	return nil
+}
+
+// PinShard fetches the shard from peers if needed and pins it, unlike PinObject it gives up
+// once the context is done.
+func (s *ipfsStore) PinShard(ctx context.Context, version string) error {
+	node, err := s.shardNode(ctx, version)
+	if err != nil {
+		return err
+	}
+	if err := s.node.Pinning.Pin(ctx, node, true); err != nil {
+		return err
+	}
+	return s.node.Pinning.Flush()
+}
+
+func (s *ipfsStore) FindProviders(ctx context.Context, version string, max int) ([]string, error) {
+	c, err := cid.Decode(version)
+	if err != nil {
+		return nil, err
+	}
+	self := s.node.Identity
+	var providers []string
+	for info := range s.node.Routing.FindProvidersAsync(ctx, c, max+1) {
+		if info.ID == self {
+			continue
+		}
+		providers = append(providers, info.ID.Pretty())
+		if len(providers) == max {
+			break
+		}
+	}
+	return providers, nil
+}
+
+// shardFiles are temporary files of shards, nil files are skipped.
+type shardFiles []*os.File
+
+// newShardFiles creates n files, or only the ones at indexes if they're set.
+func newShardFiles(n int, indexes []int) (shardFiles, error) {
+	if indexes == nil {
+		indexes = make([]int, n)
+		for i := range indexes {
+			indexes[i] = i
+		}
+	}
+	sf := make(shardFiles, n)
+	for _, i := range indexes {
+		f, err := ioutil.TempFile("", "atlant-shard-")
+		if err != nil {
+			sf.remove()
+			err = fmt.Errorf("failed to create shard file: %v", err)
+			return nil, err
+		}
+		sf[i] = f
+	}
+	return sf, nil
+}
+
+func (sf shardFiles) writers() []io.Writer {
+	w := make([]io.Writer, len(sf))
+	for i, f := range sf {
+		if f != nil {
+			w[i] = f
+		}
+	}
+	return w
+}
+
+func (sf shardFiles) readers() []io.Reader {
+	r := make([]io.Reader, len(sf))
+	for i, f := range sf {
+		if f != nil {
+			r[i] = f
+		}
+	}
+	return r
+}
+
+func (sf shardFiles) rewind() error {
+	for _, f := range sf {
+		if f == nil {
+			continue
+		} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sf shardFiles) remove() {
+	for _, f := range sf {
+		if f == nil {
+			continue
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func closeAll(closers []io.Closer) {
+	for _, c := range closers {
+		c.Close()
+	}
+}
@@ -23,22 +23,77 @@ type Object struct {
 type PlanetaryFileStore interface {
 	NodeID() string
 	SignData(peerID string, data []byte) ([]byte, error)
+	// Identity returns the identity the node runs with.
+	Identity() (*Identity, error)
+	// SetIdentity replaces the identity in the repo config, it's used once the node restarts.
+	SetIdentity(id *Identity) error
 
 	PubSub() (PlanetaryPubSub, error)
 	Listener() PlanetaryListener
 	Client() PlanetaryClient
 
 	PinObject(ref ObjectRef) error
+	UnpinObject(ref ObjectRef) error
+	// CollectGarbage removes unpinned blocks, returns the number of bytes reclaimed.
+	CollectGarbage(ctx context.Context) (int64, error)
+	// PinnedObjects lists versions of objects pinned recursively.
+	PinnedObjects() []string
+	// CheckObject verifies that all blocks of the version are local, see ObjectCheck.
+	CheckObject(ctx context.Context, version string, repair bool) (*ObjectCheck, error)
 	PutObject(ctx context.Context, ref ObjectRef, userMeta []byte, body io.ReadCloser) (*ObjectRef, error)
 	DeleteObject(ctx context.Context, ref ObjectRef) (*ObjectRef, error)
 	GetObject(ctx context.Context, ref ObjectRef) (*Object, error)
 	HeadObject(ctx context.Context, ref ObjectRef) (*ObjectRef, error)
 	ListObjects(ctx context.Context, ref ObjectRef) ([]ObjectRef, error)
 
+	// PutErasureObject stores the object as shards of the layout, shards are pinned
+	// but the object doesn't link to them, see ShardSet.
+	PutErasureObject(ctx context.Context, ref ObjectRef, userMeta []byte,
+		body io.ReadCloser, layout ErasureLayout) (*ObjectRef, *ShardSet, error)
+	// ObjectShards returns ErrNotErasure for objects stored as a whole.
+	ObjectShards(ctx context.Context, ref ObjectRef) (*ShardSet, error)
+	// RepairShards rebuilds shards at the indexes from the others and pins them.
+	RepairShards(ctx context.Context, set *ShardSet, indexes []int) error
+	PinShard(ctx context.Context, version string) error
+	// FindProviders returns up to max peers that provide the block, this node is not included.
+	FindProviders(ctx context.Context, version string, max int) ([]string, error)
+
+	// ExportBlocks writes all blocks of the given object versions as a CAR stream.
+	ExportBlocks(ctx context.Context, versions []string, wr io.Writer) error
+	// ImportBlocks reads a CAR stream into the blockstore, returns the number of blocks.
+	ImportBlocks(ctx context.Context, rd io.Reader) (int, error)
+	// ExportArchive writes data blocks, up to MaxArchiveDataSize each, followed by all blocks
+	// of the object versions as a CAR stream. ImportArchive passes data blocks to fn in order
+	// and puts the other blocks into the blockstore, it returns the number of blocks put.
+	ExportArchive(ctx context.Context, data [][]byte, versions []string, wr io.Writer) error
+	ImportArchive(ctx context.Context, rd io.Reader, fn func(data []byte) error) (int, error)
+
 	DiskStats() (*DiskStats, error)
 	BandwidthStats() *BandwidthStats
 	RepoStats() *RepoStats
 	BitswapStats() *BitswapStats
+	// SwarmPeers returns connected peers with their addresses, latencies and agent versions.
+	SwarmPeers() []*SwarmPeer
+	// SwarmAddrs returns addresses peers may dial this node at, ending with /ipfs/<node ID>.
+	SwarmAddrs() []string
+	// NATStats reports whether peers reach the node and its external addresses, nil if the
+	// node is offline.
+	NATStats() *NATStats
+	ConnectPeer(ctx context.Context, addr string) (string, error)
+	DisconnectPeer(nodeID string) error
+	// BitswapLedgers returns bytes exchanged with each connected peer.
+	BitswapLedgers() []*BitswapLedger
+	// DebtLimitStats returns nil if bitswap debt limits are disabled.
+	DebtLimitStats() *DebtLimitStats
+	BlocklistStats() *BlocklistStats
+	// ExchangedPeers returns peers kept by the peer exchange as bootstrap candidates.
+	ExchangedPeers() ([]*ExchangedPeer, error)
+	// PeerExchangeStats returns nil if the peer exchange is disabled.
+	PeerExchangeStats() *PeerExchangeStats
+	// MmapCacheStats returns nil if the mmap cache is disabled.
+	MmapCacheStats() *MmapCacheStats
+	// InlineStats returns nil if small objects are not inlined.
+	InlineStats() *InlineStats
 
 	Close() error
 }
@@ -77,6 +132,8 @@ type ObjectRef struct {
 	Version         string
 	VersionPrevious string
 	VersionOffset   int
+	// ExpiresAt is set on new versions that expire, see proto.ObjectMeta.
+	ExpiresAt time.Time
 
 	muxOnce sync.Once
 	metaMux *sync.RWMutex
@@ -116,6 +173,9 @@ func (o *ObjectRef) ToProto() (proto.ObjectMeta, error) {
 	}
 	meta.SetCreatedAt(time.Now().UnixNano())
 	meta.SetVersionPrevious(o.VersionPrevious)
+	if !o.ExpiresAt.IsZero() {
+		meta.SetExpiresAt(o.ExpiresAt.UnixNano())
+	}
 	return meta, nil
 }
 
@@ -148,6 +208,7 @@ type RepoStats struct {
 	RepoPath   string `json:"repo_path"`
 	Version    string `json:"version"`
 	StorageMax uint64 `json:"storage_max"`
+	NumPins    int    `json:"num_pins"`
 }
 
 type BitswapStats struct {
@@ -0,0 +1,129 @@
+package fs
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	ci "github.com/AtlantPlatform/go-ipfs/go-libp2p-crypto"
+	peer "github.com/AtlantPlatform/go-ipfs/go-libp2p-peer"
+	"github.com/AtlantPlatform/go-ipfs/repo"
+	"github.com/AtlantPlatform/go-ipfs/repo/config"
+	"github.com/AtlantPlatform/go-ipfs/repo/fsrepo"
+)
+
+// The identity of a node is the key pair of its IPFS peer, the node ID is derived from the
+// public key. It's kept in the config of the IPFS repo, so a node moved to new hardware keeps
+// its ID once the identity is written into the new repo.
+
+// Identity is the private key of a node and the node ID derived from it.
+type Identity struct {
+	NodeID string
+	// PrivKey is the key marshaled by libp2p.
+	PrivKey []byte
+}
+
+// NewIdentity generates a key pair for a new node ID.
+func NewIdentity() (*Identity, error) {
+	priv, _, err := ci.GenerateKeyPair(ci.Ed25519, 256)
+	if err != nil {
+		return nil, err
+	}
+	return identityOf(priv)
+}
+
+func identityOf(priv ci.PrivKey) (*Identity, error) {
+	id, err := peer.IDFromEd25519PublicKey(priv.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+	data, err := ci.MarshalPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{
+		NodeID:  id.Pretty(),
+		PrivKey: data,
+	}, nil
+}
+
+// Verify checks that the node ID is derived from the key.
+func (id *Identity) Verify() error {
+	priv, err := ci.UnmarshalPrivateKey(id.PrivKey)
+	if err != nil {
+		return fmt.Errorf("malformed identity key: %v", err)
+	}
+	derived, err := identityOf(priv)
+	if err != nil {
+		return fmt.Errorf("malformed identity key: %v", err)
+	} else if derived.NodeID != id.NodeID {
+		return fmt.Errorf("identity key doesn't match node ID %s", id.NodeID)
+	}
+	return nil
+}
+
+// ReadIdentity reads the identity from the config of the IPFS repo, the repo may be in use.
+func ReadIdentity(prefix string) (*Identity, error) {
+	cfg, err := fsrepo.ConfigAt(prefix)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(cfg.Identity.PrivKey)
+	if err != nil {
+		return nil, fmt.Errorf("malformed identity key: %v", err)
+	}
+	id := &Identity{
+		NodeID:  cfg.Identity.PeerID,
+		PrivKey: key,
+	}
+	if err := id.Verify(); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// WriteIdentity replaces the identity in the config of the IPFS repo, the node must be stopped.
+func WriteIdentity(prefix string, id *Identity) error {
+	if err := id.Verify(); err != nil {
+		return err
+	}
+	locked, err := fsrepo.LockedByOtherProcess(prefix)
+	if err != nil {
+		return err
+	} else if locked {
+		return errors.New("the IPFS repo is in use, stop the node first")
+	}
+	r, err := fsrepo.Open(prefix)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return setRepoIdentity(r, id)
+}
+
+func setRepoIdentity(r repo.Repo, id *Identity) error {
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Identity = config.Identity{
+		PeerID:  id.NodeID,
+		PrivKey: base64.StdEncoding.EncodeToString(id.PrivKey),
+	}
+	return r.SetConfig(cfg)
+}
+
+func (s *ipfsStore) Identity() (*Identity, error) {
+	return identityOf(s.node.PrivateKey)
+}
+
+// SetIdentity writes the identity into the config of the repo, the node runs with its
+// current identity until restarted.
+func (s *ipfsStore) SetIdentity(id *Identity) error {
+	if s.repo == nil {
+		return errors.New("the node has no IPFS repo")
+	} else if err := id.Verify(); err != nil {
+		return err
+	}
+	return setRepoIdentity(s.repo, id)
+}
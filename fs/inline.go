@@ -0,0 +1,243 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Small objects are kept inline in the state store, next to the record metadata. An object
+// whose content is at most the inline threshold is stored along with its meta under its
+// version, reads and heads of such versions are served from the state store without resolving
+// the DAG. Deletion markers are always inlined. Objects received from peers are inlined once
+// pinned, unpinning drops them. The DAG is still added to IPFS, so versions stay CIDs and
+// peers replicate small objects over bitswap as before.
+
+const (
+	inlineHeaderSize = 4
+	inlinePinTimeout = time.Minute
+)
+
+var errInlineMalformed = errors.New("malformed inline object")
+
+type InlineStats struct {
+	MaxSize int64  `json:"max_size"`
+	Stored  uint64 `json:"stored_total"`
+	Hits    uint64 `json:"hits_total"`
+}
+
+type inlineStore struct {
+	ss      state.IndexedStore
+	maxSize int64
+
+	stored uint64
+	hits   uint64
+}
+
+func newInlineStore(ss state.IndexedStore, maxSize int64) *inlineStore {
+	return &inlineStore{
+		ss:      ss,
+		maxSize: maxSize,
+	}
+}
+
+// buffer reads up to the threshold of the body. If the whole body fits, it's returned as data
+// along with a reader of it, otherwise data is nil and the reader yields the full body.
+func (x *inlineStore) buffer(body io.ReadCloser) ([]byte, io.ReadCloser, error) {
+	buf, err := ioutil.ReadAll(io.LimitReader(body, x.maxSize+1))
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	if int64(len(buf)) <= x.maxSize {
+		body.Close()
+		return buf, ioutil.NopCloser(bytes.NewReader(buf)), nil
+	}
+	return nil, &multiReadCloser{
+		Reader: io.MultiReader(bytes.NewReader(buf), body),
+		closer: body,
+	}, nil
+}
+
+// put stores the object, the version must be set in the meta already.
+func (x *inlineStore) put(meta proto.ObjectMeta, body []byte) error {
+	metaBuf := new(bytes.Buffer)
+	if _, err := meta.Segment.WriteToPacked(metaBuf); err != nil {
+		return err
+	}
+	data := make([]byte, inlineHeaderSize, inlineHeaderSize+metaBuf.Len()+len(body))
+	binary.BigEndian.PutUint32(data, uint32(metaBuf.Len()))
+	data = append(data, metaBuf.Bytes()...)
+	data = append(data, body...)
+	if err := x.ss.Update(context.Background(), state.InlineKey(meta.Version()), func(k *state.Key, v []byte) ([]byte, error) {
+		return data, nil
+	}); err != nil {
+		return err
+	}
+	atomic.AddUint64(&x.stored, 1)
+	return nil
+}
+
+// get returns nil ref if the version is not inline.
+func (x *inlineStore) get(version string) (*ObjectRef, []byte, error) {
+	var data []byte
+	if err := x.ss.View(context.Background(), state.InlineKey(version), func(k *state.Key, v []byte) error {
+		data = append([]byte(nil), v...)
+		return nil
+	}); err == state.ErrNotFound {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if len(data) < inlineHeaderSize {
+		return nil, nil, errInlineMalformed
+	}
+	metaLen := int(binary.BigEndian.Uint32(data))
+	if len(data) < inlineHeaderSize+metaLen {
+		return nil, nil, errInlineMalformed
+	}
+	meta, err := readObjectFileMeta(bytes.NewReader(data[inlineHeaderSize : inlineHeaderSize+metaLen]))
+	if err != nil {
+		return nil, nil, err
+	} else if meta.Version() != version {
+		// a collision of truncated hashes
+		return nil, nil, nil
+	}
+	atomic.AddUint64(&x.hits, 1)
+	ref := &ObjectRef{
+		ID:   meta.Id(),
+		Path: meta.Path(),
+		Size: meta.Size(),
+
+		Version:         version,
+		VersionPrevious: meta.VersionPrevious(),
+
+		meta: &meta,
+	}
+	return ref, data[inlineHeaderSize+metaLen:], nil
+}
+
+func (x *inlineStore) has(version string) bool {
+	err := x.ss.View(context.Background(), state.InlineKey(version), func(k *state.Key, v []byte) error {
+		return nil
+	})
+	return err == nil
+}
+
+func (x *inlineStore) remove(version string) error {
+	if err := x.ss.Delete(state.InlineKey(version)); err != nil && err != state.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func (x *inlineStore) Stats() *InlineStats {
+	return &InlineStats{
+		MaxSize: x.maxSize,
+		Stored:  atomic.LoadUint64(&x.stored),
+		Hits:    atomic.LoadUint64(&x.hits),
+	}
+}
+
+// inlineObject returns nil if the version is not inline, or the inline store is disabled.
+func (s *ipfsStore) inlineObject(ref ObjectRef) *Object {
+	if s.inline == nil || ref.VersionOffset != 0 || len(ref.Version) == 0 {
+		return nil
+	}
+	normRef, body, err := s.inline.get(ref.Version)
+	if err != nil {
+		log.WithField("version", ref.Version).Warningf("failed to read inline object: %v", err)
+		return nil
+	} else if normRef == nil {
+		return nil
+	}
+	obj := &Object{
+		ObjectRef: *normRef,
+		Meta:      normRef.Meta(),
+	}
+	if !obj.Meta.IsDeleted() {
+		obj.Body = &inlineReader{bytes.NewReader(body)}
+	}
+	return obj
+}
+
+// inlinePinned inlines a small object pinned after it was fetched from peers.
+func (s *ipfsStore) inlinePinned(version string) {
+	if s.inline == nil || s.inline.has(version) {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(s.node.Context(), inlinePinTimeout)
+	defer cancelFn()
+	obj, err := s.GetObject(ctx, ObjectRef{
+		Version: version,
+	})
+	if err != nil {
+		log.WithField("version", version).Debugf("failed to inline pinned object: %v", err)
+		return
+	} else if obj.Meta.Size() > s.inline.maxSize {
+		if obj.Body != nil {
+			obj.Body.Close()
+		}
+		return
+	}
+	var data []byte
+	if obj.Body != nil {
+		buf, err := s.inline.bufferFull(obj.Body)
+		if err != nil || buf == nil {
+			return
+		}
+		data = buf
+	}
+	meta := *obj.Meta
+	meta.SetVersion(version)
+	if err := s.inline.put(meta, data); err != nil {
+		log.WithField("version", version).Warningf("failed to inline pinned object: %v", err)
+	}
+}
+
+// bufferFull reads and closes the body, returns nil data if it's over the threshold.
+func (x *inlineStore) bufferFull(body io.ReadCloser) ([]byte, error) {
+	defer body.Close()
+	buf, err := ioutil.ReadAll(io.LimitReader(body, x.maxSize+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(buf)) > x.maxSize {
+		return nil, nil
+	}
+	return buf, nil
+}
+
+func (s *ipfsStore) InlineStats() *InlineStats {
+	if s.inline == nil {
+		return nil
+	}
+	return s.inline.Stats()
+}
+
+// inlineReader serves ranges of inline objects as well.
+type inlineReader struct {
+	*bytes.Reader
+}
+
+func (r *inlineReader) Close() error {
+	return nil
+}
+
+type multiReadCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r *multiReadCloser) Close() error {
+	return r.closer.Close()
+}
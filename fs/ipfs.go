@@ -12,11 +12,14 @@ import (
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/AtlantPlatform/go-ipfs/core"
 	"github.com/AtlantPlatform/go-ipfs/core/corerepo"
 	"github.com/AtlantPlatform/go-ipfs/core/coreunix"
 	"github.com/AtlantPlatform/go-ipfs/exchange/bitswap"
+	cid "github.com/AtlantPlatform/go-ipfs/go-cid"
+	"github.com/AtlantPlatform/go-ipfs/go-ipfs-cmdkit/files"
 	ipld "github.com/AtlantPlatform/go-ipfs/go-ipld-format"
 	ipnet "github.com/AtlantPlatform/go-ipfs/go-libp2p-interface-pnet"
 	peer "github.com/AtlantPlatform/go-ipfs/go-libp2p-peer"
@@ -31,6 +34,7 @@ import (
 
 	"github.com/AtlantPlatform/atlant-go/logging"
 	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/tracing"
 )
 
 func init() {
@@ -52,6 +56,13 @@ type ipfsStore struct {
 	listenerOnce sync.Once
 	client       *p2pClient
 	clientOnce   sync.Once
+
+	blocklist *peerBlocklist
+	debts     *debtLimiter
+	mmap      *mmapCache
+	pex       *peerExchange
+	inline    *inlineStore
+	nat       *natService
 }
 
 func (s *ipfsStore) NodeID() string {
@@ -62,7 +73,10 @@ var ErrNotFound = errors.New("not found")
 
 func (s *ipfsStore) PutObject(ctx context.Context, ref ObjectRef,
 	userMeta []byte, body io.ReadCloser) (*ObjectRef, error) {
-	return s.putObject(ctx, ref, userMeta, body, false)
+	ctx, span := tracing.Start(ctx, "fs.PutObject", attribute.String("path", ref.Path))
+	obj, err := s.putObject(ctx, ref, userMeta, body, false)
+	tracing.End(span, err)
+	return obj, err
 }
 
 func (s *ipfsStore) DeleteObject(ctx context.Context, ref ObjectRef) (*ObjectRef, error) {
@@ -72,11 +86,6 @@ func (s *ipfsStore) DeleteObject(ctx context.Context, ref ObjectRef) (*ObjectRef
 
 func (s *ipfsStore) putObject(ctx context.Context, ref ObjectRef,
 	userMeta []byte, body io.ReadCloser, isDelete bool) (*ObjectRef, error) {
-	fileAdder, err := coreunix.NewAdder(ctx, s.node.Pinning, s.node.Blockstore, s.node.DAG)
-	if err != nil {
-		err = fmt.Errorf("failed to init IPFS file adder: %v", err)
-		return nil, err
-	}
 	if len(ref.ID) == 0 {
 		ref.ID = proto.NewID()
 	}
@@ -89,35 +98,76 @@ func (s *ipfsStore) putObject(ctx context.Context, ref ObjectRef,
 		meta.SetIsDeleted(true)
 	}
 	meta.SetUserMeta(string(userMeta))
+	var inlineBody []byte
+	keepInline := s.inline != nil && body == nil
+	if s.inline != nil && body != nil {
+		if inlineBody, body, err = s.inline.buffer(body); err != nil {
+			err = fmt.Errorf("failed to read object body: %v", err)
+			return nil, err
+		}
+		keepInline = inlineBody != nil
+	}
 	file, err := NewObjectFile(meta, body)
 	if err != nil {
 		err = fmt.Errorf("failed to create object file: %v", err)
 		return nil, err
 	}
+	version, err := s.addFile(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	ref.Version = version
+	meta.SetVersion(ref.Version)
+	ref.SetMeta(&meta)
+	if keepInline {
+		if err := s.inline.put(meta, inlineBody); err != nil {
+			log.WithField("version", version).Warningf("failed to inline object, it's read from IPFS: %v", err)
+		}
+	}
+	return &ref, nil
+}
+
+// addFile adds the file to the DAG and pins it, returns the CID of its root node.
+func (s *ipfsStore) addFile(ctx context.Context, file files.File) (string, error) {
+	fileAdder, err := coreunix.NewAdder(ctx, s.node.Pinning, s.node.Blockstore, s.node.DAG)
+	if err != nil {
+		err = fmt.Errorf("failed to init IPFS file adder: %v", err)
+		return "", err
+	}
 	if err := fileAdder.AddFile(file); err != nil {
 		err = fmt.Errorf("failed to add object file to DAG: %v", err)
-		return nil, err
+		return "", err
 	}
 	if _, err := fileAdder.Finalize(); err != nil {
 		err = fmt.Errorf("failed to finalize DAG node: %v", err)
-		return nil, err
+		return "", err
 	}
 	if err := fileAdder.PinRoot(); err != nil {
 		err = fmt.Errorf("failed to pin object file, it will be soon collected by GC: %v", err)
-		return nil, err
+		return "", err
 	}
 	node, err := fileAdder.RootNode()
 	if err != nil {
 		err = fmt.Errorf("failed to get root node for the pinned object: %v", err)
-		return nil, err
+		return "", err
 	}
-	ref.Version = node.Cid().String()
-	meta.SetVersion(ref.Version)
-	ref.SetMeta(&meta)
-	return &ref, nil
+	return node.Cid().String(), nil
 }
 
 func (s *ipfsStore) HeadObject(ctx context.Context, ref ObjectRef) (*ObjectRef, error) {
+	ctx, span := tracing.Start(ctx, "fs.HeadObject", attribute.String("version", ref.Version))
+	obj, err := s.headObject(ctx, ref)
+	tracing.End(span, err)
+	return obj, err
+}
+
+func (s *ipfsStore) headObject(ctx context.Context, ref ObjectRef) (*ObjectRef, error) {
+	if obj := s.inlineObject(ref); obj != nil {
+		if obj.Body != nil {
+			obj.Body.Close()
+		}
+		return &obj.ObjectRef, nil
+	}
 	normRef := s.resolveObjectVersion(ctx, ref)
 	if normRef == nil || normRef.Meta() == nil {
 		normRef = s.cidToObjectRef(ctx, normRef.Version)
@@ -132,7 +182,19 @@ func (s *ipfsStore) HeadObject(ctx context.Context, ref ObjectRef) (*ObjectRef,
 	return normRef, nil
 }
 
+// GetObject is traced up to the start of the read of the content, fetches of blocks
+// while the body is read are not.
 func (s *ipfsStore) GetObject(ctx context.Context, ref ObjectRef) (*Object, error) {
+	ctx, span := tracing.Start(ctx, "fs.GetObject", attribute.String("version", ref.Version))
+	obj, err := s.getObject(ctx, ref)
+	tracing.End(span, err)
+	return obj, err
+}
+
+func (s *ipfsStore) getObject(ctx context.Context, ref ObjectRef) (*Object, error) {
+	if obj := s.inlineObject(ref); obj != nil {
+		return obj, nil
+	}
 	normRef := s.resolveObjectVersion(ctx, ref)
 	if normRef == nil || normRef.Meta() == nil {
 		normRef = s.cidToObjectRef(ctx, normRef.Version)
@@ -170,13 +232,32 @@ func (s *ipfsStore) GetObject(ctx context.Context, ref ObjectRef) (*Object, erro
 		}
 	}
 	if contentNode == nil {
-		return obj, ErrNotFound
+		// erasure coded objects keep a list of shards in place of the content
+		set, err := s.readShardSet(ctx, dagNode)
+		if err != nil {
+			return obj, ErrNotFound
+		}
+		body, err := s.openShards(ctx, set)
+		if err != nil {
+			return obj, err
+		}
+		obj.Body = body
+		return obj, nil
+	}
+	if s.mmap != nil {
+		if body := s.mmap.open(normRef.Version); body != nil {
+			obj.Body = body
+			return obj, nil
+		}
 	}
 	reader, err := uio.NewDagReader(ctx, contentNode, s.node.DAG)
 	if err != nil {
 		err = fmt.Errorf("failed to read node content: %v", err)
 		return obj, err
 	}
+	if s.mmap != nil && s.mmap.touch(normRef.Version, int64(reader.Size())) {
+		go s.cacheObject(normRef.Version, contentNode)
+	}
 	obj.Body = reader
 	return obj, nil
 }
@@ -268,9 +349,61 @@ func (s *ipfsStore) PinObject(ref ObjectRef) error {
 	if err := s.node.Pinning.Pin(s.node.Context(), dagNode, true); err != nil {
 		return err
 	}
+	if err := s.node.Pinning.Flush(); err != nil {
+		return err
+	}
+	s.inlinePinned(ref.Version)
+	return nil
+}
+
+// UnpinObject removes the recursive pin of an object version, its blocks are
+// removed by the next garbage collection unless referenced by other pins.
+func (s *ipfsStore) UnpinObject(ref ObjectRef) error {
+	c, err := cid.Decode(ref.Version)
+	if err != nil {
+		log.WithFields(logging.WithFn()).Errorln("failed to parse object CID:", err)
+		return err
+	}
+	if err := s.node.Pinning.Unpin(s.node.Context(), c, true); err != nil {
+		return err
+	}
+	if s.mmap != nil {
+		s.mmap.remove(ref.Version)
+	}
+	if s.inline != nil {
+		if err := s.inline.remove(ref.Version); err != nil {
+			log.WithField("version", ref.Version).Warningf("failed to drop inline object: %v", err)
+		}
+	}
 	return s.node.Pinning.Flush()
 }
 
+// CollectGarbage removes unpinned blocks from the repo and returns the number of bytes reclaimed.
+func (s *ipfsStore) CollectGarbage(ctx context.Context) (int64, error) {
+	before, err := corerepo.RepoStat(s.node, ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := corerepo.GarbageCollect(s.node, ctx); err != nil {
+		err = fmt.Errorf("garbage collection failed: %v", err)
+		return 0, err
+	}
+	after, err := corerepo.RepoStat(s.node, ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int64(before.RepoSize) - int64(after.RepoSize), nil
+}
+
+func (s *ipfsStore) PinnedObjects() []string {
+	keys := s.node.Pinning.RecursiveKeys()
+	versions := make([]string, 0, len(keys))
+	for _, c := range keys {
+		versions = append(versions, c.String())
+	}
+	return versions
+}
+
 func (s *ipfsStore) cidToObjectRef(ctx context.Context, cid string) *ObjectRef {
 	p, err := ipath.ParseCidToPath(cid)
 	if err != nil {
@@ -401,6 +534,8 @@ func newIpfsStore(prefix string, needInit bool, opts ...ipfsOpt) (*ipfsStore, er
 			if err := initializeIpnsKeyspace(prefix); err != nil {
 				return nil, err
 			}
+		} else if err := checkRepoVersion(prefix, s.opts.AutoMigrate); err != nil {
+			return nil, err
 		}
 		r, err := s.openRepo(prefix)
 		if err != nil {
@@ -413,6 +548,17 @@ func newIpfsStore(prefix string, needInit bool, opts ...ipfsOpt) (*ipfsStore, er
 		cfg.Permanent = false
 		cfg.NilRepo = true
 	}
+	if s.opts.Proxy != nil {
+		host, err := proxyHostOption(s.opts.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Host = host
+		log.WithFields(log.Fields{
+			"proxy":       redactedProxy(s.opts.Proxy.URL),
+			"no_clearnet": s.opts.Proxy.NoClearnet,
+		}).Println("dialing swarm peers through the proxy")
+	}
 
 	n, err := core.NewNode(context.Background(), cfg)
 	if err != nil {
@@ -423,6 +569,28 @@ func newIpfsStore(prefix string, needInit bool, opts ...ipfsOpt) (*ipfsStore, er
 		DAG:         n.DAG,
 		ResolveOnce: uio.ResolveUnixfsOnce,
 	}
+	if s.opts.StoreEnabled {
+		s.checkPins()
+	}
+	if n.PeerHost != nil {
+		s.startNAT()
+		s.startBlocklist()
+		s.startDebtLimiter()
+		s.startPeerExchange()
+	}
+	if s.opts.InlineStore != nil && s.opts.InlineMaxSize > 0 && s.opts.StoreEnabled {
+		s.inline = newInlineStore(s.opts.InlineStore, s.opts.InlineMaxSize)
+	}
+	if s.opts.MmapCacheSize > 0 && s.opts.StoreEnabled {
+		cache, err := newMmapCache(path.Join(prefix, mmapDir),
+			s.opts.MmapCacheSize, s.opts.MmapMinObjectSize, s.opts.MmapHotReads)
+		if err != nil {
+			err = fmt.Errorf("failed to init mmap cache: %v", err)
+			n.Close()
+			return nil, err
+		}
+		s.mmap = cache
+	}
 	return s, nil
 }
 
@@ -454,7 +622,7 @@ func (s *ipfsStore) applyConfig(cfg *config.Config) error {
 	}
 	if s.opts.RelayEnabled {
 		cfg.Swarm.DisableRelay = false
-		cfg.Swarm.EnableRelayHop = true
+		cfg.Swarm.EnableRelayHop = s.opts.NAT.RelayHop
 	} else {
 		cfg.Swarm.DisableRelay = true
 		cfg.Swarm.EnableRelayHop = false
@@ -462,8 +630,23 @@ func (s *ipfsStore) applyConfig(cfg *config.Config) error {
 	cfg.Experimental.Libp2pStreamMounting = true
 	cfg.Swarm.DisableBandwidthMetrics = false
 	cfg.SetBootstrapPeers(s.opts.BootstrapPeers)
-	cfg.Addresses.Swarm = []string{
-		fmt.Sprintf("/ip4/%s/tcp/%d", s.opts.ListenHost, s.opts.ListenPort),
+	cfg.Swarm.DisableNatPortMap = !s.opts.NAT.PortMap
+	cfg.Addresses.Swarm = s.swarmListenAddrs()
+	cfg.Addresses.Announce = announceAddrs(s.opts.NAT.Announce)
+	cfg.Addresses.NoAnnounce = nil
+	if p := s.opts.Proxy; p != nil {
+		// local discovery would reveal the node on the network it hides from
+		cfg.Discovery.MDNS.Enabled = false
+		if p.NoClearnet {
+			cfg.Addresses.Swarm = []string{
+				fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", s.opts.ListenPort),
+			}
+		}
+		cfg.Addresses.Announce = announceAddrs(p.Announce)
+		if p.NoClearnet && len(p.Announce) == 0 {
+			// nothing to announce, peers can't dial the node but it dials them
+			cfg.Addresses.NoAnnounce = cfg.Addresses.Swarm
+		}
 	}
 	// disable extra IPFS networking
 	cfg.Addresses.API = ""
@@ -494,6 +677,9 @@ func (s *ipfsStore) openRepo(prefix string) (repo.Repo, error) {
 }
 
 func (s *ipfsStore) Close() error {
+	if s.mmap != nil {
+		s.mmap.Close()
+	}
 	if err := s.node.Close(); err != nil {
 		log.Errorf("IPFS node shutdown failed: %v", err)
 	}
@@ -608,6 +794,7 @@ func (s *ipfsStore) RepoStats() *RepoStats {
 		RepoPath:   stats.RepoPath,
 		Version:    stats.Version,
 		StorageMax: stats.StorageMax,
+		NumPins:    len(s.node.Pinning.RecursiveKeys()) + len(s.node.Pinning.DirectKeys()),
 	}
 }
 
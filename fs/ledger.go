@@ -0,0 +1,266 @@
+package fs
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/go-ipfs/exchange/bitswap"
+	inet "github.com/AtlantPlatform/go-ipfs/go-libp2p-net"
+	peer "github.com/AtlantPlatform/go-ipfs/go-libp2p-peer"
+)
+
+// Debt limits discourage peers that fetch blocks but never serve any. The bitswap engine
+// has no hook to slow down sending to a single peer, so a peer in debt beyond the limits
+// is disconnected and refused for a cooldown, which doubles each time it's throttled again.
+
+const (
+	debtCheckInterval  = 30 * time.Second
+	maxDebtCooldown    = 24 * time.Hour
+	defaultDebtRatio   = 10
+	defaultDebtTimeout = 10 * time.Minute
+)
+
+// BitswapLedger is the exchange with a peer as seen by this node.
+type BitswapLedger struct {
+	Peer string `json:"peer"`
+	// Sent and Recv are bytes sent to and received from the peer.
+	Sent      uint64  `json:"sent"`
+	Recv      uint64  `json:"recv"`
+	Exchanged uint64  `json:"exchanged"`
+	DebtRatio float64 `json:"debt_ratio"`
+
+	ThrottledUntil time.Time `json:"throttled_until,omitempty"`
+	Exempt         bool      `json:"exempt,omitempty"`
+}
+
+type DebtLimitStats struct {
+	MaxDebt        int64            `json:"max_debt"`
+	MaxRatio       float64          `json:"max_ratio"`
+	Cooldown       string           `json:"cooldown"`
+	Throttled      map[string]int64 `json:"throttled"`
+	ThrottledTotal uint64           `json:"throttled_total"`
+	Refused        uint64           `json:"refused_total"`
+}
+
+type debtThrottle struct {
+	until    time.Time
+	cooldown time.Duration
+}
+
+type debtLimiter struct {
+	maxDebt  int64
+	maxRatio float64
+	cooldown time.Duration
+	exempt   map[string]bool
+
+	mux       *sync.RWMutex
+	throttled map[string]*debtThrottle
+
+	throttledTotal uint64
+	refused        uint64
+}
+
+func newDebtLimiter(maxDebt int64, maxRatio float64, cooldown time.Duration, exempt []string) *debtLimiter {
+	if maxRatio <= 0 {
+		maxRatio = defaultDebtRatio
+	}
+	if cooldown <= 0 {
+		cooldown = defaultDebtTimeout
+	}
+	l := &debtLimiter{
+		maxDebt:   maxDebt,
+		maxRatio:  maxRatio,
+		cooldown:  cooldown,
+		exempt:    make(map[string]bool, len(exempt)),
+		mux:       new(sync.RWMutex),
+		throttled: make(map[string]*debtThrottle),
+	}
+	for _, id := range exempt {
+		l.exempt[id] = true
+	}
+	return l
+}
+
+// inDebt tells whether the peer got more than the limits allow for what it has served.
+func (l *debtLimiter) inDebt(sent, recv uint64) bool {
+	if sent <= recv || int64(sent-recv) <= l.maxDebt {
+		return false
+	}
+	return float64(sent)/float64(recv+1) > l.maxRatio
+}
+
+func (l *debtLimiter) isThrottled(peerID string, now time.Time) bool {
+	return !l.throttledUntil(peerID, now).IsZero()
+}
+
+// throttledUntil returns the end of the current cooldown of the peer, zero if there is none.
+func (l *debtLimiter) throttledUntil(peerID string, now time.Time) time.Time {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	if t, ok := l.throttled[peerID]; ok && now.Before(t.until) {
+		return t.until
+	}
+	return time.Time{}
+}
+
+// throttle starts a cooldown of the peer, a repeated throttle within twice the previous
+// cooldown doubles it.
+func (l *debtLimiter) throttle(peerID string, now time.Time) time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	cooldown := l.cooldown
+	if t, ok := l.throttled[peerID]; ok && now.Before(t.until.Add(t.cooldown)) {
+		if cooldown = 2 * t.cooldown; cooldown > maxDebtCooldown {
+			cooldown = maxDebtCooldown
+		}
+	}
+	l.throttled[peerID] = &debtThrottle{
+		until:    now.Add(cooldown),
+		cooldown: cooldown,
+	}
+	atomic.AddUint64(&l.throttledTotal, 1)
+	return cooldown
+}
+
+// forget drops throttles that ended long enough ago not to be escalated.
+func (l *debtLimiter) forget(now time.Time) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for id, t := range l.throttled {
+		if now.After(t.until.Add(t.cooldown)) {
+			delete(l.throttled, id)
+		}
+	}
+}
+
+// Enforce disconnects peers that are in debt and not throttled yet.
+func (l *debtLimiter) Enforce(b *bitswap.Bitswap, network inet.Network) {
+	now := time.Now()
+	l.forget(now)
+	for _, c := range network.Conns() {
+		p := c.RemotePeer()
+		id := p.Pretty()
+		if l.exempt[id] || l.isThrottled(id, now) {
+			continue
+		}
+		r := b.LedgerForPeer(p)
+		if r == nil || !l.inDebt(r.Sent, r.Recv) {
+			continue
+		}
+		cooldown := l.throttle(id, now)
+		log.WithFields(log.Fields{
+			"peer":     id,
+			"sent":     r.Sent,
+			"recv":     r.Recv,
+			"cooldown": cooldown,
+		}).Infoln("throttling a peer in bitswap debt")
+		network.ClosePeer(p)
+	}
+}
+
+// Notifiee refuses connections of throttled peers.
+func (l *debtLimiter) Notifiee() inet.Notifiee {
+	return &inet.NotifyBundle{
+		ConnectedF: func(n inet.Network, c inet.Conn) {
+			if l.isThrottled(c.RemotePeer().Pretty(), time.Now()) {
+				atomic.AddUint64(&l.refused, 1)
+				go c.Close()
+			}
+		},
+	}
+}
+
+func (l *debtLimiter) Stats() *DebtLimitStats {
+	now := time.Now()
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	stats := &DebtLimitStats{
+		MaxDebt:        l.maxDebt,
+		MaxRatio:       l.maxRatio,
+		Cooldown:       l.cooldown.String(),
+		Throttled:      make(map[string]int64),
+		ThrottledTotal: atomic.LoadUint64(&l.throttledTotal),
+		Refused:        atomic.LoadUint64(&l.refused),
+	}
+	for id, t := range l.throttled {
+		if now.Before(t.until) {
+			stats.Throttled[id] = int64(t.until.Sub(now).Seconds())
+		}
+	}
+	return stats
+}
+
+func (s *ipfsStore) startDebtLimiter() {
+	b, ok := s.node.Exchange.(*bitswap.Bitswap)
+	if !ok || s.opts.BitswapMaxDebt <= 0 {
+		return
+	}
+	s.debts = newDebtLimiter(s.opts.BitswapMaxDebt, s.opts.BitswapDebtRatio,
+		s.opts.BitswapDebtCooldown, s.opts.BitswapDebtExempt)
+	network := s.node.PeerHost.Network()
+	network.Notify(s.debts.Notifiee())
+	go func() {
+		t := time.NewTicker(debtCheckInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.node.Context().Done():
+				return
+			case <-t.C:
+				s.debts.Enforce(b, network)
+			}
+		}
+	}()
+}
+
+// BitswapLedgers returns ledgers of connected peers, the biggest debtors first.
+func (s *ipfsStore) BitswapLedgers() []*BitswapLedger {
+	b, ok := s.node.Exchange.(*bitswap.Bitswap)
+	if !ok {
+		return nil
+	}
+	stats, err := b.Stat()
+	if err != nil {
+		log.Warningf("failed to read bitswap stats: %v", err)
+		return nil
+	}
+	now := time.Now()
+	ledgers := make([]*BitswapLedger, 0, len(stats.Peers))
+	for _, id := range stats.Peers {
+		p, err := peer.IDB58Decode(id)
+		if err != nil {
+			continue
+		}
+		r := b.LedgerForPeer(p)
+		if r == nil {
+			continue
+		}
+		ledger := &BitswapLedger{
+			Peer:      id,
+			Sent:      r.Sent,
+			Recv:      r.Recv,
+			Exchanged: r.Exchanged,
+			DebtRatio: float64(r.Sent) / float64(r.Recv+1),
+		}
+		if s.debts != nil {
+			ledger.Exempt = s.debts.exempt[id]
+			ledger.ThrottledUntil = s.debts.throttledUntil(id, now)
+		}
+		ledgers = append(ledgers, ledger)
+	}
+	sort.Slice(ledgers, func(i, j int) bool {
+		return int64(ledgers[i].Sent)-int64(ledgers[i].Recv) > int64(ledgers[j].Sent)-int64(ledgers[j].Recv)
+	})
+	return ledgers
+}
+
+func (s *ipfsStore) DebtLimitStats() *DebtLimitStats {
+	if s.debts == nil {
+		return nil
+	}
+	return s.debts.Stats()
+}
@@ -0,0 +1,299 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	ipld "github.com/AtlantPlatform/go-ipfs/go-ipld-format"
+	uio "github.com/AtlantPlatform/go-ipfs/unixfs/io"
+)
+
+// Large objects that are read often are copied out of the blockstore into files mapped
+// into memory, so they are served straight from the page cache instead of being decoded
+// block by block through buffers. Versions are immutable, so entries never go stale,
+// they are only evicted when the cache is full or the version is unpinned.
+
+const (
+	mmapDir = "mmap"
+	// mmapLoadTimeout limits copying of an object into the cache.
+	mmapLoadTimeout = 5 * time.Minute
+	// mmapMaxTracked limits the number of versions whose reads are counted.
+	mmapMaxTracked = 10000
+)
+
+type MmapCacheStats struct {
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	MaxBytes  int64  `json:"max_bytes"`
+	Hits      uint64 `json:"hits_total"`
+	Loads     uint64 `json:"loads_total"`
+	Evictions uint64 `json:"evictions_total"`
+}
+
+type mmapEntry struct {
+	data     []byte
+	refs     int
+	evicted  bool
+	lastUsed time.Time
+}
+
+type mmapCache struct {
+	dir      string
+	maxBytes int64
+	minSize  int64
+	hotReads int
+
+	mux     *sync.Mutex
+	entries map[string]*mmapEntry
+	reads   map[string]int
+	loading map[string]struct{}
+	used    int64
+
+	hits      uint64
+	loads     uint64
+	evictions uint64
+}
+
+func newMmapCache(dir string, maxBytes, minSize int64, hotReads int) (*mmapCache, error) {
+	// mapped files are unlinked once mapped, but a crash may leave partial copies behind
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if hotReads < 1 {
+		hotReads = 1
+	}
+	return &mmapCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		minSize:  minSize,
+		hotReads: hotReads,
+		mux:      new(sync.Mutex),
+		entries:  make(map[string]*mmapEntry),
+		reads:    make(map[string]int),
+		loading:  make(map[string]struct{}),
+	}, nil
+}
+
+// open returns a reader of the cached version or nil if it's not cached.
+func (c *mmapCache) open(version string) io.ReadCloser {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	e, ok := c.entries[version]
+	if !ok {
+		return nil
+	}
+	e.refs++
+	e.lastUsed = time.Now()
+	atomic.AddUint64(&c.hits, 1)
+	return &mmapReader{
+		Reader: bytes.NewReader(e.data),
+		release: func() {
+			c.release(e)
+		},
+	}
+}
+
+// touch counts a read of the version, it returns true once the version
+// became hot and should be loaded into the cache.
+func (c *mmapCache) touch(version string, size int64) bool {
+	if size < c.minSize || size > c.maxBytes {
+		return false
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if _, ok := c.loading[version]; ok {
+		return false
+	}
+	if len(c.reads) >= mmapMaxTracked {
+		c.reads = make(map[string]int)
+	}
+	c.reads[version]++
+	if c.reads[version] < c.hotReads {
+		return false
+	}
+	delete(c.reads, version)
+	c.loading[version] = struct{}{}
+	return true
+}
+
+// loaded marks the end of loading the version, whether it succeeded or not.
+func (c *mmapCache) loaded(version string) {
+	c.mux.Lock()
+	delete(c.loading, version)
+	c.mux.Unlock()
+}
+
+// load copies the object into a file and maps it, the file is removed right away.
+func (c *mmapCache) load(version string, rd io.Reader, size int64) error {
+	f, err := ioutil.TempFile(c.dir, "obj")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if n, err := io.Copy(f, rd); err != nil {
+		return err
+	} else if n != size {
+		return fmt.Errorf("object size mismatch: %d != %d", n, size)
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("mmap failed: %v", err)
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.evictFor(size)
+	c.entries[version] = &mmapEntry{
+		data:     data,
+		lastUsed: time.Now(),
+	}
+	c.used += size
+	atomic.AddUint64(&c.loads, 1)
+	return nil
+}
+
+// evictFor evicts the least recently used entries until size bytes fit,
+// must be called with the lock held.
+func (c *mmapCache) evictFor(size int64) {
+	for c.used+size > c.maxBytes && len(c.entries) > 0 {
+		var oldest string
+		var oldestUsed time.Time
+		for version, e := range c.entries {
+			if len(oldest) == 0 || e.lastUsed.Before(oldestUsed) {
+				oldest = version
+				oldestUsed = e.lastUsed
+			}
+		}
+		c.evict(oldest)
+	}
+}
+
+// evict must be called with the lock held, mappings still being read
+// are unmapped once the last reader is closed.
+func (c *mmapCache) evict(version string) {
+	e, ok := c.entries[version]
+	if !ok {
+		return
+	}
+	delete(c.entries, version)
+	c.used -= int64(len(e.data))
+	e.evicted = true
+	atomic.AddUint64(&c.evictions, 1)
+	if e.refs == 0 {
+		unmap(e)
+	}
+}
+
+func (c *mmapCache) release(e *mmapEntry) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	e.refs--
+	if e.evicted && e.refs == 0 {
+		unmap(e)
+	}
+}
+
+func unmap(e *mmapEntry) {
+	if err := syscall.Munmap(e.data); err != nil {
+		log.Warningf("failed to unmap cached object: %v", err)
+	}
+	e.data = nil
+}
+
+func (c *mmapCache) remove(version string) {
+	c.mux.Lock()
+	c.evict(version)
+	c.mux.Unlock()
+}
+
+func (c *mmapCache) Stats() *MmapCacheStats {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return &MmapCacheStats{
+		Entries:   len(c.entries),
+		Bytes:     c.used,
+		MaxBytes:  c.maxBytes,
+		Hits:      atomic.LoadUint64(&c.hits),
+		Loads:     atomic.LoadUint64(&c.loads),
+		Evictions: atomic.LoadUint64(&c.evictions),
+	}
+}
+
+func (c *mmapCache) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for version := range c.entries {
+		c.evict(version)
+	}
+	return nil
+}
+
+// mmapReader reads a mapped object, it supports seeking so ranges are served as well.
+type mmapReader struct {
+	*bytes.Reader
+
+	once    sync.Once
+	release func()
+}
+
+func (r *mmapReader) Close() error {
+	r.once.Do(r.release)
+	return nil
+}
+
+// cacheObject loads the content of a version into the mmap cache if all its blocks
+// are local, objects being fetched from peers are left to bitswap.
+func (s *ipfsStore) cacheObject(version string, contentNode ipld.Node) {
+	ctx, cancel := context.WithTimeout(s.node.Context(), mmapLoadTimeout)
+	defer cancel()
+	defer s.mmap.loaded(version)
+	logger := log.WithField("version", version)
+	if local, err := s.isLocal(ctx, contentNode); err != nil || !local {
+		return
+	}
+	reader, err := uio.NewDagReader(ctx, contentNode, s.node.DAG)
+	if err != nil {
+		logger.Debugf("failed to read object for the mmap cache: %v", err)
+		return
+	}
+	defer reader.Close()
+	if err := s.mmap.load(version, reader, int64(reader.Size())); err != nil {
+		logger.Debugf("failed to load object into the mmap cache: %v", err)
+	}
+}
+
+// isLocal checks that all blocks of the DAG are in the local blockstore.
+func (s *ipfsStore) isLocal(ctx context.Context, n ipld.Node) (bool, error) {
+	for _, link := range n.Links() {
+		if has, err := s.node.Blockstore.Has(link.Cid); err != nil || !has {
+			return false, err
+		}
+		child, err := s.node.DAG.Get(ctx, link.Cid)
+		if err != nil {
+			return false, err
+		}
+		if local, err := s.isLocal(ctx, child); err != nil || !local {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (s *ipfsStore) MmapCacheStats() *MmapCacheStats {
+	if s.mmap == nil {
+		return nil
+	}
+	return s.mmap.Stats()
+}
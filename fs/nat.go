@@ -0,0 +1,417 @@
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	inet "github.com/AtlantPlatform/go-ipfs/go-libp2p-net"
+	peer "github.com/AtlantPlatform/go-ipfs/go-libp2p-peer"
+	ma "github.com/AtlantPlatform/go-ipfs/go-multiaddr"
+)
+
+// Nodes behind NATs get reachable in three ways. The swarm port is forwarded by the router over
+// UPnP or NAT-PMP if it allows. Connected peers are asked to dial the node back at the address
+// they see it at, which tells whether the node is reachable, as AutoNAT does: peers open a plain
+// TCP connection to the observed IP and the listen ports of the node, never to other hosts.
+// While no peer reaches the node, it stays connected to the configured relays, so that peers
+// reach it over circuit addresses of the relays, which are reported as external addresses.
+// The libp2p of the node speaks circuit relay v1, relays must have --relay-hop enabled.
+
+const (
+	ReachabilityUnknown = "unknown"
+	ReachabilityPublic  = "public"
+	ReachabilityPrivate = "private"
+
+	dialbackProto     = "/atlant/dialback/1.0.0"
+	dialbackTimeout   = 15 * time.Second
+	dialbackPeers     = 3
+	dialbackMaxPorts  = 4
+	dialbackRateLimit = time.Minute
+	maxDialbackPeers  = 10000
+)
+
+// NATConfig sets how the node gets reachable behind a NAT.
+type NATConfig struct {
+	// PortMap asks the router to forward the swarm port over UPnP or NAT-PMP.
+	PortMap bool
+	// RelayHop relays connections of other nodes, if relays are enabled.
+	RelayHop bool
+	// AutoNAT asks connected peers every AutoNATInterval to dial the node back.
+	AutoNAT         bool
+	AutoNATInterval time.Duration
+	// RelayPeers are addresses of relays, ending with /ipfs/<node ID>, the node stays
+	// connected to while it's not reachable.
+	RelayPeers []string
+	// Announce are addresses peers dial the node at instead of the detected ones.
+	Announce []string
+}
+
+// DefaultNATConfig maps the swarm port and relays for others, as IPFS does by default.
+func DefaultNATConfig() *NATConfig {
+	return &NATConfig{
+		PortMap:         true,
+		RelayHop:        true,
+		AutoNAT:         true,
+		AutoNATInterval: 15 * time.Minute,
+	}
+}
+
+// NATStats reports whether the node is reachable and the addresses peers may dial it at.
+type NATStats struct {
+	Reachability string    `json:"reachability"`
+	PortMap      bool      `json:"port_map"`
+	AutoNAT      bool      `json:"autonat"`
+	LastCheck    time.Time `json:"last_check,omitempty"`
+	ListenAddrs  []string  `json:"listen_addrs"`
+	// ExternalAddrs end with /ipfs/<node ID>, circuit addresses of connected relays are
+	// added while the node is not reachable.
+	ExternalAddrs []string `json:"external_addrs"`
+	// Observed are addresses peers saw the node at in the last check.
+	Observed []string `json:"observed,omitempty"`
+	Relays   []string `json:"relays,omitempty"`
+	// DialBacks counts dial-backs served to peers.
+	DialBacks uint64 `json:"dial_backs_total"`
+}
+
+type dialbackRequest struct {
+	Ports []int `json:"ports"`
+}
+
+type dialbackResponse struct {
+	Observed  string `json:"observed"`
+	Reachable bool   `json:"reachable"`
+}
+
+type natService struct {
+	served uint64
+
+	mux          *sync.Mutex
+	reachability string
+	lastCheck    time.Time
+	observed     []string
+	relays       []string
+	// servedAt are times of the last dial-backs served to peers
+	servedAt map[peer.ID]time.Time
+}
+
+func newNATService() *natService {
+	return &natService{
+		mux:          new(sync.Mutex),
+		reachability: ReachabilityUnknown,
+		servedAt:     make(map[peer.ID]time.Time),
+	}
+}
+
+// allowDialback limits dial-backs to one per peer every dialbackRateLimit.
+func (n *natService) allowDialback(id peer.ID) bool {
+	now := time.Now()
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if last, ok := n.servedAt[id]; ok && now.Sub(last) < dialbackRateLimit {
+		return false
+	}
+	if len(n.servedAt) >= maxDialbackPeers {
+		for id, last := range n.servedAt {
+			if now.Sub(last) >= dialbackRateLimit {
+				delete(n.servedAt, id)
+			}
+		}
+		if len(n.servedAt) >= maxDialbackPeers {
+			return false
+		}
+	}
+	n.servedAt[id] = now
+	return true
+}
+
+// swarmListenAddr returns the multiaddr of the swarm listener at host and port.
+func swarmListenAddr(host string, port int) string {
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return fmt.Sprintf("/ip6/%s/tcp/%d", host, port)
+	}
+	return fmt.Sprintf("/ip4/%s/tcp/%d", host, port)
+}
+
+func (s *ipfsStore) swarmListenAddrs() []string {
+	addrs := []string{swarmListenAddr(s.opts.ListenHost, s.opts.ListenPort)}
+	if ip := net.ParseIP(s.opts.ListenHost); s.opts.IPv6 && (ip == nil || ip.To4() != nil) {
+		addrs = append(addrs, swarmListenAddr("::", s.opts.ListenPort))
+	}
+	return addrs
+}
+
+// addrIP returns the IP of an /ip4 or /ip6 multiaddr, nil for others, e.g. circuits.
+func addrIP(addr ma.Multiaddr) net.IP {
+	if v, err := addr.ValueForProtocol(ma.P_IP4); err == nil {
+		return net.ParseIP(v)
+	} else if v, err := addr.ValueForProtocol(ma.P_IP6); err == nil {
+		return net.ParseIP(v)
+	}
+	return nil
+}
+
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		networks = append(networks, n)
+	}
+	return networks
+}()
+
+// isPublicIP tells whether hosts outside of local networks may dial the IP.
+func isPublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *ipfsStore) startNAT() {
+	s.nat = newNATService()
+	if s.opts.Proxy == nil {
+		// nodes behind a proxy don't dial peers directly, nor back
+		s.node.PeerHost.SetStreamHandler(dialbackProto, s.serveDialback)
+	}
+	if !s.opts.NAT.AutoNAT || s.opts.NAT.AutoNATInterval <= 0 {
+		return
+	}
+	go func() {
+		// let the node connect to peers first
+		t := time.NewTimer(time.Minute)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.node.Context().Done():
+				return
+			case <-t.C:
+				s.checkReachability(s.node.Context())
+				t.Reset(s.opts.NAT.AutoNATInterval)
+			}
+		}
+	}()
+}
+
+// serveDialback dials the peer back at the IP its connection comes from.
+func (s *ipfsStore) serveDialback(st inet.Stream) {
+	defer st.Close()
+	timer := time.AfterFunc(dialbackTimeout, func() {
+		st.Close()
+	})
+	defer timer.Stop()
+	if !s.nat.allowDialback(st.Conn().RemotePeer()) {
+		return
+	}
+	var req dialbackRequest
+	if err := json.NewDecoder(io.LimitReader(st, 1024)).Decode(&req); err != nil {
+		return
+	}
+	remote := st.Conn().RemoteMultiaddr()
+	resp := &dialbackResponse{
+		Observed: remote.String(),
+	}
+	if ip := addrIP(remote); ip != nil {
+		for i, port := range req.Ports {
+			if i == dialbackMaxPorts {
+				break
+			} else if port <= 0 || port > 65535 {
+				continue
+			}
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)), dialbackTimeout/3)
+			if err == nil {
+				conn.Close()
+				resp.Reachable = true
+				break
+			}
+		}
+	}
+	atomic.AddUint64(&s.nat.served, 1)
+	json.NewEncoder(st).Encode(resp)
+}
+
+// listenPorts returns TCP ports the swarm listens on.
+func (s *ipfsStore) listenPorts() []int {
+	seen := make(map[int]bool)
+	var ports []int
+	for _, addr := range s.node.PeerHost.Network().ListenAddresses() {
+		v, err := addr.ValueForProtocol(ma.P_TCP)
+		if err != nil {
+			continue
+		}
+		if port, err := strconv.Atoi(v); err == nil && !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+func (s *ipfsStore) askDialback(ctx context.Context, nodeID string, ports []int) (*dialbackResponse, error) {
+	id, err := peer.IDB58Decode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dialbackTimeout)
+	defer cancel()
+	st, err := s.node.PeerHost.NewStream(ctx, id, dialbackProto)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+	go func() {
+		<-ctx.Done()
+		st.Close()
+	}()
+	if err := json.NewEncoder(st).Encode(&dialbackRequest{
+		Ports: ports,
+	}); err != nil {
+		return nil, err
+	}
+	var resp dialbackResponse
+	if err := json.NewDecoder(io.LimitReader(st, 1024)).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// checkReachability asks a few connected peers to dial the node back, and connects to
+// relays while none of them reaches it.
+func (s *ipfsStore) checkReachability(ctx context.Context) {
+	ports := s.listenPorts()
+	peers := s.SwarmPeers()
+	for i := range peers {
+		j := rand.Intn(i + 1)
+		peers[i], peers[j] = peers[j], peers[i]
+	}
+	var reached, failed int
+	var observed []string
+	for _, p := range peers {
+		if reached+failed == dialbackPeers {
+			break
+		}
+		resp, err := s.askDialback(ctx, p.NodeID, ports)
+		if err != nil {
+			// older nodes don't serve dial-backs
+			log.WithField("peer", p.NodeID).Debugf("dial-back failed: %v", err)
+			continue
+		}
+		observed = append(observed, resp.Observed)
+		if resp.Reachable {
+			reached++
+		} else {
+			failed++
+		}
+	}
+	reachability := ReachabilityUnknown
+	if reached > 0 {
+		reachability = ReachabilityPublic
+	} else if failed > 0 {
+		reachability = ReachabilityPrivate
+	}
+	var relays []string
+	if reachability == ReachabilityPrivate {
+		for _, addr := range s.opts.NAT.RelayPeers {
+			nodeID, err := s.ConnectPeer(ctx, addr)
+			if err != nil {
+				log.WithField("relay", addr).Warningf("failed to connect to relay: %v", err)
+				continue
+			}
+			relays = append(relays, nodeID)
+		}
+	}
+	sort.Strings(observed)
+	s.nat.mux.Lock()
+	if reachability != s.nat.reachability && reachability != ReachabilityUnknown {
+		log.WithFields(log.Fields{
+			"reachability": reachability,
+			"relays":       len(relays),
+		}).Infoln("swarm reachability changed")
+	}
+	if reachability != ReachabilityUnknown {
+		s.nat.reachability = reachability
+	}
+	s.nat.lastCheck = time.Now().UTC()
+	s.nat.observed = observed
+	s.nat.relays = relays
+	s.nat.mux.Unlock()
+}
+
+// circuitAddrs returns addresses of the node over connected relays while it isn't reachable.
+func (s *ipfsStore) circuitAddrs() []string {
+	if s.nat == nil {
+		return nil
+	}
+	s.nat.mux.Lock()
+	relays := s.nat.relays
+	private := s.nat.reachability == ReachabilityPrivate
+	s.nat.mux.Unlock()
+	if !private || len(relays) == 0 {
+		return nil
+	}
+	connected := make(map[string]bool)
+	for _, p := range s.SwarmPeers() {
+		connected[p.NodeID] = true
+	}
+	self := "/ipfs/" + s.NodeID()
+	var addrs []string
+	for _, nodeID := range relays {
+		if connected[nodeID] {
+			addrs = append(addrs, "/ipfs/"+nodeID+"/p2p-circuit"+self)
+		}
+	}
+	return addrs
+}
+
+// externalAddrs returns public addresses of the swarm and circuit addresses of relays.
+func (s *ipfsStore) externalAddrs() []string {
+	host := s.node.PeerHost
+	var addrs []string
+	for _, a := range host.Addrs() {
+		if isPublicIP(addrIP(a)) {
+			addrs = append(addrs, a.String()+"/ipfs/"+host.ID().Pretty())
+		}
+	}
+	addrs = append(addrs, s.circuitAddrs()...)
+	sort.Strings(addrs)
+	return addrs
+}
+
+func (s *ipfsStore) NATStats() *NATStats {
+	if s.node.PeerHost == nil || s.nat == nil {
+		return nil
+	}
+	stats := &NATStats{
+		PortMap:       s.opts.NAT.PortMap,
+		AutoNAT:       s.opts.NAT.AutoNAT,
+		ExternalAddrs: s.externalAddrs(),
+		DialBacks:     atomic.LoadUint64(&s.nat.served),
+	}
+	for _, addr := range s.node.PeerHost.Network().ListenAddresses() {
+		stats.ListenAddrs = append(stats.ListenAddrs, addr.String())
+	}
+	s.nat.mux.Lock()
+	defer s.nat.mux.Unlock()
+	stats.Reachability = s.nat.reachability
+	stats.LastCheck = s.nat.lastCheck
+	stats.Observed = s.nat.observed
+	stats.Relays = s.nat.relays
+	return stats
+}
@@ -2,9 +2,12 @@ package fs
 
 import (
 	"strconv"
+	"time"
 
 	"github.com/AtlantPlatform/go-ipfs/repo/config"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/state"
 )
 
 type PlanetaryCache interface{}
@@ -17,7 +20,32 @@ type ipfsOptions struct {
 	BootstrapPeers []config.BootstrapPeer
 	ListenHost     string
 	ListenPort     int
+	IPv6           bool
+	NAT            *NATConfig
 	Cache          PlanetaryCache
+
+	BlocklistSources []string
+	BlocklistRefresh time.Duration
+
+	MmapCacheSize     int64
+	MmapMinObjectSize int64
+	MmapHotReads      int
+
+	BitswapMaxDebt      int64
+	BitswapDebtRatio    float64
+	BitswapDebtCooldown time.Duration
+	BitswapDebtExempt   []string
+
+	PeerExchangeStore    state.IndexedStore
+	PeerExchangeInterval time.Duration
+	PeerExchangeTTL      time.Duration
+
+	InlineStore   state.IndexedStore
+	InlineMaxSize int64
+
+	AutoMigrate bool
+
+	Proxy *ProxyConfig
 }
 
 type ipfsOpt func(o *ipfsOptions)
@@ -31,6 +59,8 @@ func defaultIpfsOptions() *ipfsOptions {
 		BootstrapPeers: []config.BootstrapPeer{},
 		ListenHost:     "0.0.0.0",
 		ListenPort:     33770,
+		NAT:            DefaultNATConfig(),
+		AutoMigrate:    true,
 	}
 }
 
@@ -111,6 +141,22 @@ func ListenPortOpt(v string) ipfsOpt {
 	}
 }
 
+// UseIPv6Opt listens on IPv6 at the swarm port as well, unless the listen host is IPv6 already.
+func UseIPv6Opt(v bool) ipfsOpt {
+	return func(o *ipfsOptions) {
+		o.IPv6 = v
+	}
+}
+
+// UseNATOpt sets how the node gets reachable behind a NAT, see NATConfig.
+func UseNATOpt(cfg *NATConfig) ipfsOpt {
+	return func(o *ipfsOptions) {
+		if cfg != nil {
+			o.NAT = cfg
+		}
+	}
+}
+
 type NetworkProfile string
 
 const (
@@ -135,3 +181,63 @@ const (
 	// NetworkNoModify skips settings network profile for existing IPFS repos.
 	NetworkNoModify NetworkProfile = "no-modify"
 )
+
+// UseBlocklistOpt sets blocklist feeds (files or URLs) of peers and IP networks
+// the node refuses to connect with, feeds are reloaded every refresh interval.
+func UseBlocklistOpt(sources []string, refresh time.Duration) ipfsOpt {
+	return func(o *ipfsOptions) {
+		o.BlocklistSources = sources
+		o.BlocklistRefresh = refresh
+	}
+}
+
+// UseDebtLimitOpt throttles peers that received more than maxDebt bytes over what they
+// have sent and whose sent to received ratio exceeds maxRatio. Such peers are disconnected
+// for the cooldown. Zero maxDebt disables the limits, exempt peers are never throttled.
+func UseDebtLimitOpt(maxDebt int64, maxRatio float64, cooldown time.Duration, exempt []string) ipfsOpt {
+	return func(o *ipfsOptions) {
+		o.BitswapMaxDebt = maxDebt
+		o.BitswapDebtRatio = maxRatio
+		o.BitswapDebtCooldown = cooldown
+		o.BitswapDebtExempt = exempt
+	}
+}
+
+// UseMmapCacheOpt enables serving of large hot objects from memory-mapped files.
+// Objects of at least minSize bytes are mapped after hotReads reads, the cache holds
+// up to size bytes. Zero size disables the cache.
+func UseMmapCacheOpt(size, minSize int64, hotReads int) ipfsOpt {
+	return func(o *ipfsOptions) {
+		o.MmapCacheSize = size
+		o.MmapMinObjectSize = minSize
+		o.MmapHotReads = hotReads
+	}
+}
+
+// UsePeerExchangeOpt gossips addresses of connected peers every interval and keeps the ones
+// received in the state store for ttl, kept peers are dialed on start. Zero interval or
+// a nil store disables the exchange.
+func UsePeerExchangeOpt(ss state.IndexedStore, interval, ttl time.Duration) ipfsOpt {
+	return func(o *ipfsOptions) {
+		o.PeerExchangeStore = ss
+		o.PeerExchangeInterval = interval
+		o.PeerExchangeTTL = ttl
+	}
+}
+
+// UseAutoMigrateOpt runs migrations of an outdated IPFS repo on open, otherwise
+// such repo fails to open.
+func UseAutoMigrateOpt(v bool) ipfsOpt {
+	return func(o *ipfsOptions) {
+		o.AutoMigrate = v
+	}
+}
+
+// UseInlineOpt keeps objects of up to maxSize bytes inline in the state store, reads of them
+// don't resolve the DAG. Zero maxSize or a nil store disables inlining.
+func UseInlineOpt(ss state.IndexedStore, maxSize int64) ipfsOpt {
+	return func(o *ipfsOptions) {
+		o.InlineStore = ss
+		o.InlineMaxSize = maxSize
+	}
+}
@@ -0,0 +1,117 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	peer "github.com/AtlantPlatform/go-ipfs/go-libp2p-peer"
+	pstore "github.com/AtlantPlatform/go-ipfs/go-libp2p-peerstore"
+	ma "github.com/AtlantPlatform/go-ipfs/go-multiaddr"
+	"github.com/AtlantPlatform/go-ipfs/repo/config"
+)
+
+var ErrNoPeerHost = errors.New("IPFS node is offline")
+
+// SwarmPeer is a peer connected to the swarm of this node.
+type SwarmPeer struct {
+	NodeID string   `json:"node_id"`
+	Addrs  []string `json:"addrs"`
+	// Latency is the moving average of round trips to the peer, zero if not measured yet.
+	Latency         float64 `json:"latency_seconds"`
+	AgentVersion    string  `json:"agent_version,omitempty"`
+	ProtocolVersion string  `json:"protocol_version,omitempty"`
+}
+
+func (s *ipfsStore) SwarmPeers() []*SwarmPeer {
+	if s.node.PeerHost == nil {
+		return nil
+	}
+	host := s.node.PeerHost
+	byID := make(map[peer.ID]*SwarmPeer)
+	for _, c := range host.Network().Conns() {
+		id := c.RemotePeer()
+		p, ok := byID[id]
+		if !ok {
+			p = &SwarmPeer{
+				NodeID:  id.Pretty(),
+				Latency: host.Peerstore().LatencyEWMA(id).Seconds(),
+			}
+			if v, err := host.Peerstore().Get(id, "AgentVersion"); err == nil {
+				p.AgentVersion, _ = v.(string)
+			}
+			if v, err := host.Peerstore().Get(id, "ProtocolVersion"); err == nil {
+				p.ProtocolVersion, _ = v.(string)
+			}
+			byID[id] = p
+		}
+		p.Addrs = append(p.Addrs, c.RemoteMultiaddr().String())
+	}
+	peers := make([]*SwarmPeer, 0, len(byID))
+	for _, p := range byID {
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].NodeID < peers[j].NodeID
+	})
+	return peers
+}
+
+func (s *ipfsStore) SwarmAddrs() []string {
+	if s.node.PeerHost == nil {
+		return nil
+	}
+	host := s.node.PeerHost
+	var addrs []string
+	for _, a := range host.Addrs() {
+		addr := a.String()
+		if strings.HasPrefix(addr, "/ip4/127.") || strings.HasPrefix(addr, "/ip6/::1/") {
+			continue
+		}
+		addrs = append(addrs, addr+"/ipfs/"+host.ID().Pretty())
+	}
+	addrs = append(addrs, s.circuitAddrs()...)
+	sort.Strings(addrs)
+	return addrs
+}
+
+// ConnectPeer connects to the peer at addr, which must end with /ipfs/<node ID>.
+// It returns the node ID of the peer.
+func (s *ipfsStore) ConnectPeer(ctx context.Context, addr string) (string, error) {
+	if s.node.PeerHost == nil {
+		return "", ErrNoPeerHost
+	}
+	a, err := config.ParseBootstrapPeer(addr)
+	if err != nil {
+		return "", err
+	}
+	if s.blocklist != nil && s.blocklist.IsBlocked(a.ID().Pretty(), a.Transport()) {
+		return "", errors.New("peer is blocklisted")
+	}
+	if err := s.node.PeerHost.Connect(ctx, pstore.PeerInfo{
+		ID:    a.ID(),
+		Addrs: []ma.Multiaddr{a.Transport()},
+	}); err != nil {
+		return "", err
+	}
+	return a.ID().Pretty(), nil
+}
+
+// DisconnectPeer closes all connections with the peer, it may connect again later.
+func (s *ipfsStore) DisconnectPeer(nodeID string) error {
+	if s.node.PeerHost == nil {
+		return ErrNoPeerHost
+	}
+	id, err := peer.IDB58Decode(nodeID)
+	if err != nil {
+		return err
+	}
+	return s.node.PeerHost.Network().ClosePeer(id)
+}
+
+// IsValidNodeID tells whether nodeID is a well-formed peer ID.
+func IsValidNodeID(nodeID string) bool {
+	_, err := peer.IDB58Decode(nodeID)
+	return err == nil
+}
@@ -0,0 +1,313 @@
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	peer "github.com/AtlantPlatform/go-ipfs/go-libp2p-peer"
+
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Peer exchange lets nodes bootstrap where the DHT bootstrap fails, e.g. in networks that
+// allow only a few outbound connections. Nodes periodically publish addresses of the peers
+// they are connected to on the peer exchange topic of pubsub, the channel announces of the
+// record store go through. Addresses received from others and of own peers are kept in the
+// state store, on start the node dials them in addition to the bootstrap peers. Peers that
+// nobody reported for the TTL expire from the store.
+
+const (
+	PeerExchangeTopic = "peer-exchange"
+
+	pexMaxPeers        = 32
+	pexMaxAddrs        = 8
+	pexDialTimeout     = 15 * time.Second
+	pexDialConcurrency = 8
+)
+
+type PeerExchangeStats struct {
+	Known         int       `json:"known"`
+	Dialed        uint64    `json:"dialed_total"`
+	Connected     uint64    `json:"connected_total"`
+	Received      uint64    `json:"received_total"`
+	Published     uint64    `json:"published_total"`
+	LastPublished time.Time `json:"last_published"`
+}
+
+// ExchangedPeer is a peer kept as a bootstrap candidate.
+type ExchangedPeer struct {
+	NodeID string    `json:"node_id"`
+	Addrs  []string  `json:"addrs"`
+	Source string    `json:"source,omitempty"`
+	SeenAt time.Time `json:"seen_at"`
+}
+
+type pexMessage struct {
+	Peers []*ExchangedPeer `json:"peers"`
+}
+
+type peerExchange struct {
+	ss       state.IndexedStore
+	interval time.Duration
+	ttl      time.Duration
+
+	dialed    uint64
+	connected uint64
+	received  uint64
+	published uint64
+
+	mux           *sync.Mutex
+	lastPublished time.Time
+}
+
+func newPeerExchange(ss state.IndexedStore, interval, ttl time.Duration) *peerExchange {
+	return &peerExchange{
+		ss:       ss,
+		interval: interval,
+		ttl:      ttl,
+		mux:      new(sync.Mutex),
+	}
+}
+
+func (x *peerExchange) store(p *ExchangedPeer) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	k := state.PeersKey(p.NodeID)
+	k.TTL = x.ttl
+	return x.ss.Update(context.Background(), k, func(k *state.Key, v []byte) ([]byte, error) {
+		return data, nil
+	})
+}
+
+func (x *peerExchange) known() ([]*ExchangedPeer, error) {
+	var peers []*ExchangedPeer
+	b := state.NewBucket(state.BucketPeers)
+	if _, err := x.ss.RangePeek(context.Background(), b, func(k *state.Key, v []byte) error {
+		var p ExchangedPeer
+		if err := json.Unmarshal(v, &p); err != nil {
+			log.Debugf("skipping malformed exchanged peer: %v", err)
+			return nil
+		}
+		peers = append(peers, &p)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+func (s *ipfsStore) startPeerExchange() {
+	if s.opts.PeerExchangeStore == nil || s.opts.PeerExchangeInterval <= 0 {
+		return
+	}
+	s.pex = newPeerExchange(s.opts.PeerExchangeStore,
+		s.opts.PeerExchangeInterval, s.opts.PeerExchangeTTL)
+	if sub, err := s.PubSub(); err != nil {
+		log.Warningf("peer exchange is not gossiped: %v", err)
+	} else if err := sub.Subscribe(s.receivePeers, PeerExchangeTopic); err != nil {
+		log.Warningf("failed to subscribe to peer exchange: %v", err)
+	}
+	go func() {
+		s.dialKnownPeers(s.node.Context())
+		t := time.NewTicker(s.pex.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.node.Context().Done():
+				return
+			case <-t.C:
+				s.publishPeers()
+			}
+		}
+	}()
+}
+
+// dialKnownPeers connects to the kept peers, these are bootstrap candidates along with the
+// configured bootstrap peers.
+func (s *ipfsStore) dialKnownPeers(ctx context.Context) {
+	peers, err := s.pex.known()
+	if err != nil {
+		log.Warningf("failed to load exchanged peers: %v", err)
+		return
+	}
+	self := s.NodeID()
+	sem := make(chan struct{}, pexDialConcurrency)
+	wg := new(sync.WaitGroup)
+	for _, p := range peers {
+		if p.NodeID == self {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(p *ExchangedPeer) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			for _, addr := range p.Addrs {
+				atomic.AddUint64(&s.pex.dialed, 1)
+				dialCtx, cancelFn := context.WithTimeout(ctx, pexDialTimeout)
+				_, err := s.ConnectPeer(dialCtx, addr)
+				cancelFn()
+				if err == nil {
+					atomic.AddUint64(&s.pex.connected, 1)
+					return
+				}
+				log.WithField("addr", addr).Debugf("failed to dial exchanged peer: %v", err)
+			}
+		}(p)
+	}
+	wg.Wait()
+	log.WithField("connected", atomic.LoadUint64(&s.pex.connected)).Debugln("dialed exchanged peers")
+}
+
+// goodPeers returns connected peers with a measured latency and the addresses they listen on,
+// remote addresses of inbound connections have ephemeral ports.
+func (s *ipfsStore) goodPeers() []*ExchangedPeer {
+	if s.node.PeerHost == nil {
+		return nil
+	}
+	pstore := s.node.PeerHost.Peerstore()
+	var peers []*ExchangedPeer
+	now := time.Now().UTC()
+	for _, sp := range s.SwarmPeers() {
+		if sp.Latency <= 0 {
+			continue
+		}
+		id, err := peer.IDB58Decode(sp.NodeID)
+		if err != nil {
+			continue
+		}
+		p := &ExchangedPeer{
+			NodeID: sp.NodeID,
+			SeenAt: now,
+		}
+		for _, a := range pstore.Addrs(id) {
+			addr := a.String()
+			if isLoopbackAddr(addr) {
+				continue
+			} else if len(p.Addrs) == pexMaxAddrs {
+				break
+			}
+			p.Addrs = append(p.Addrs, addr+"/ipfs/"+sp.NodeID)
+		}
+		if len(p.Addrs) > 0 {
+			peers = append(peers, p)
+		}
+		if len(peers) == pexMaxPeers {
+			break
+		}
+	}
+	return peers
+}
+
+func isLoopbackAddr(addr string) bool {
+	return strings.HasPrefix(addr, "/ip4/127.") || strings.HasPrefix(addr, "/ip6/::1/")
+}
+
+func (s *ipfsStore) publishPeers() {
+	peers := s.goodPeers()
+	if len(peers) == 0 {
+		return
+	}
+	for _, p := range peers {
+		if err := s.pex.store(p); err != nil {
+			log.Warningf("failed to keep exchanged peer: %v", err)
+		}
+	}
+	sub, err := s.PubSub()
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(&pexMessage{
+		Peers: peers,
+	})
+	if err != nil {
+		log.Warningf("failed to encode peer exchange: %v", err)
+		return
+	} else if err := sub.Publish(PeerExchangeTopic, data); err != nil {
+		log.Warningf("failed to publish peer exchange: %v", err)
+		return
+	}
+	atomic.AddUint64(&s.pex.published, 1)
+	s.pex.mux.Lock()
+	s.pex.lastPublished = time.Now()
+	s.pex.mux.Unlock()
+}
+
+func (s *ipfsStore) receivePeers(m *Message) error {
+	if m.From == s.NodeID() {
+		return nil
+	}
+	var msg pexMessage
+	if err := json.Unmarshal(m.Data, &msg); err != nil {
+		log.WithField("from", m.From).Debugf("skipping malformed peer exchange: %v", err)
+		return nil
+	}
+	atomic.AddUint64(&s.pex.received, 1)
+	now := time.Now().UTC()
+	self := s.NodeID()
+	for i, p := range msg.Peers {
+		if i == pexMaxPeers {
+			break
+		} else if p == nil || p.NodeID == self || !IsValidNodeID(p.NodeID) {
+			continue
+		}
+		var addrs []string
+		for _, addr := range p.Addrs {
+			if len(addrs) == pexMaxAddrs {
+				break
+			} else if !strings.HasSuffix(addr, "/ipfs/"+p.NodeID) || isLoopbackAddr(addr) {
+				continue
+			}
+			addrs = append(addrs, addr)
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		if err := s.pex.store(&ExchangedPeer{
+			NodeID: p.NodeID,
+			Addrs:  addrs,
+			Source: m.From,
+			SeenAt: now,
+		}); err != nil {
+			log.Warningf("failed to keep exchanged peer: %v", err)
+		}
+	}
+	return nil
+}
+
+// ExchangedPeers returns peers kept as bootstrap candidates.
+func (s *ipfsStore) ExchangedPeers() ([]*ExchangedPeer, error) {
+	if s.pex == nil {
+		return nil, nil
+	}
+	return s.pex.known()
+}
+
+func (s *ipfsStore) PeerExchangeStats() *PeerExchangeStats {
+	if s.pex == nil {
+		return nil
+	}
+	stats := &PeerExchangeStats{
+		Dialed:    atomic.LoadUint64(&s.pex.dialed),
+		Connected: atomic.LoadUint64(&s.pex.connected),
+		Received:  atomic.LoadUint64(&s.pex.received),
+		Published: atomic.LoadUint64(&s.pex.published),
+	}
+	s.pex.mux.Lock()
+	stats.LastPublished = s.pex.lastPublished
+	s.pex.mux.Unlock()
+	if peers, err := s.pex.known(); err == nil {
+		stats.Known = len(peers)
+	}
+	return stats
+}
@@ -0,0 +1,309 @@
+package fs
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/proxy"
+
+	"github.com/AtlantPlatform/go-ipfs/core"
+	libp2p "github.com/AtlantPlatform/go-ipfs/go-libp2p"
+	p2phost "github.com/AtlantPlatform/go-ipfs/go-libp2p-host"
+	peer "github.com/AtlantPlatform/go-ipfs/go-libp2p-peer"
+	pstore "github.com/AtlantPlatform/go-ipfs/go-libp2p-peerstore"
+	tpt "github.com/AtlantPlatform/go-ipfs/go-libp2p-transport"
+	tptu "github.com/AtlantPlatform/go-ipfs/go-libp2p-transport-upgrader"
+	ma "github.com/AtlantPlatform/go-ipfs/go-multiaddr"
+	manet "github.com/AtlantPlatform/go-ipfs/go-multiaddr-net"
+	tcp "github.com/AtlantPlatform/go-ipfs/go-tcp-transport"
+)
+
+// Swarm dials may go through a SOCKS5 proxy, e.g. the SOCKS port of Tor, or an HTTP proxy
+// that allows CONNECT. The proxy transport is registered for the network protocols of
+// addresses and claims to be a proxy, so the swarm picks it for every dial, while listeners
+// are still served by the TCP transport. Host names of /dns4 and /dns6 addresses and onion
+// addresses are resolved by the proxy. Without clearnet the node only listens on loopback,
+// e.g. behind an onion service, and announces the given addresses instead of its own.
+
+const proxyDialTimeout = time.Minute
+
+// ProxyConfig routes swarm dials through a proxy.
+type ProxyConfig struct {
+	// URL of the proxy, socks5://[user:pass@]host:port or http://[user:pass@]host:port.
+	URL *url.URL
+	// NoClearnet keeps the node from listening anywhere but on loopback.
+	NoClearnet bool
+	// Announce lists addresses peers dial this node at, e.g. /onion/<address>:33770.
+	Announce []string
+}
+
+// ParseProxyURL checks the URL of a swarm proxy.
+func ParseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %s, use socks5 or http", u.Scheme)
+	}
+	if len(u.Host) == 0 {
+		return nil, errors.New("proxy URL has no host")
+	}
+	return u, nil
+}
+
+// UseProxyOpt routes swarm dials through the proxy, nil config dials peers directly.
+func UseProxyOpt(cfg *ProxyConfig) ipfsOpt {
+	return func(o *ipfsOptions) {
+		o.Proxy = cfg
+	}
+}
+
+type proxyDialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+func newProxyDialer(u *url.URL) (proxyDialer, error) {
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			pass, _ := u.User.Password()
+			auth = &proxy.Auth{
+				User:     u.User.Username(),
+				Password: pass,
+			}
+		}
+		// host names are passed to the proxy as is, so they're resolved on its side
+		return proxy.SOCKS5("tcp", u.Host, auth, &net.Dialer{
+			Timeout: proxyDialTimeout,
+		})
+	case "http":
+		return &connectDialer{
+			addr: u.Host,
+			user: u.User,
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %s", u.Scheme)
+}
+
+// connectDialer tunnels connections through an HTTP proxy with CONNECT.
+type connectDialer struct {
+	addr string
+	user *url.Userinfo
+}
+
+func (d *connectDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", d.addr, proxyDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if d.user != nil {
+		pass, _ := d.user.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(d.user.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	conn.SetDeadline(time.Now().Add(proxyDialTimeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused to connect to %s: %s", addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return &bufferedConn{
+		Conn: conn,
+		rd:   br,
+	}, nil
+}
+
+// bufferedConn keeps bytes the peer sent right after the proxy response.
+type bufferedConn struct {
+	net.Conn
+	rd *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.rd.Read(p)
+}
+
+// proxyAddr returns the host and port of an address the proxy connects to.
+func proxyAddr(addr ma.Multiaddr) (string, error) {
+	protos := addr.Protocols()
+	if len(protos) == 0 {
+		return "", fmt.Errorf("empty address")
+	}
+	switch protos[0].Code {
+	case ma.P_ONION:
+		v, err := addr.ValueForProtocol(ma.P_ONION)
+		if err != nil {
+			return "", err
+		}
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("malformed onion address %s", addr)
+		}
+		return parts[0] + ".onion:" + parts[1], nil
+	case ma.P_IP4, ma.P_IP6, ma.P_DNS4, ma.P_DNS6:
+		if len(protos) < 2 || protos[1].Code != ma.P_TCP {
+			return "", fmt.Errorf("proxy dials TCP addresses only: %s", addr)
+		}
+		host, err := addr.ValueForProtocol(protos[0].Code)
+		if err != nil {
+			return "", err
+		}
+		port, err := addr.ValueForProtocol(ma.P_TCP)
+		if err != nil {
+			return "", err
+		}
+		return net.JoinHostPort(host, port), nil
+	}
+	return "", fmt.Errorf("proxy can't dial %s", addr)
+}
+
+// proxyTransport dials peers through the proxy, it doesn't listen.
+type proxyTransport struct {
+	upgrader *tptu.Upgrader
+	dialer   proxyDialer
+}
+
+var _ tpt.Transport = (*proxyTransport)(nil)
+
+func (t *proxyTransport) CanDial(addr ma.Multiaddr) bool {
+	_, err := proxyAddr(addr)
+	return err == nil
+}
+
+func (t *proxyTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (tpt.Conn, error) {
+	hostPort, err := proxyAddr(raddr)
+	if err != nil {
+		return nil, err
+	}
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	resC := make(chan dialResult, 1)
+	go func() {
+		conn, err := t.dialer.Dial("tcp", hostPort)
+		resC <- dialResult{conn, err}
+	}()
+	var conn net.Conn
+	select {
+	case res := <-resC:
+		if res.err != nil {
+			return nil, fmt.Errorf("proxy dial of %s failed: %v", raddr, res.err)
+		}
+		conn = res.conn
+	case <-ctx.Done():
+		go func() {
+			if res := <-resC; res.conn != nil {
+				res.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+	laddr, err := manet.FromNetAddr(conn.LocalAddr())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return t.upgrader.UpgradeOutbound(ctx, t, &proxyConn{
+		Conn:  conn,
+		laddr: laddr,
+		raddr: raddr,
+	}, p)
+}
+
+func (t *proxyTransport) Listen(laddr ma.Multiaddr) (tpt.Listener, error) {
+	return nil, errors.New("proxy transport doesn't listen")
+}
+
+func (t *proxyTransport) Protocols() []int {
+	return []int{ma.P_IP4, ma.P_IP6, ma.P_DNS4, ma.P_DNS6, ma.P_ONION}
+}
+
+func (t *proxyTransport) Proxy() bool {
+	return true
+}
+
+// proxyConn reports the address of the peer instead of the one of the proxy.
+type proxyConn struct {
+	net.Conn
+	laddr ma.Multiaddr
+	raddr ma.Multiaddr
+}
+
+func (c *proxyConn) LocalMultiaddr() ma.Multiaddr {
+	return c.laddr
+}
+
+func (c *proxyConn) RemoteMultiaddr() ma.Multiaddr {
+	return c.raddr
+}
+
+// proxyHostOption builds the libp2p host with the proxy transport next to the TCP one.
+func proxyHostOption(cfg *ProxyConfig) (core.HostOption, error) {
+	dialer, err := newProxyDialer(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, id peer.ID, ps pstore.Peerstore, options ...libp2p.Option) (p2phost.Host, error) {
+		options = append(options,
+			libp2p.Transport(tcp.NewTCPTransport),
+			libp2p.Transport(func(u *tptu.Upgrader) *proxyTransport {
+				return &proxyTransport{
+					upgrader: u,
+					dialer:   dialer,
+				}
+			}),
+		)
+		return core.DefaultHostOption(ctx, id, ps, options...)
+	}, nil
+}
+
+// announceAddrs skips malformed addresses to announce.
+func announceAddrs(list []string) []string {
+	addrs := make([]string, 0, len(list))
+	for _, v := range list {
+		if _, err := ma.NewMultiaddr(v); err != nil {
+			log.Warningf("skipping malformed address to announce %s: %v", v, err)
+			continue
+		}
+		addrs = append(addrs, v)
+	}
+	return addrs
+}
+
+// redactedProxy returns the proxy URL without credentials for logs.
+func redactedProxy(u *url.URL) string {
+	v := *u
+	v.User = nil
+	return v.String()
+}
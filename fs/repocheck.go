@@ -0,0 +1,216 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	cid "github.com/AtlantPlatform/go-ipfs/go-cid"
+	"github.com/AtlantPlatform/go-ipfs/repo/fsrepo"
+	"github.com/AtlantPlatform/go-ipfs/repo/fsrepo/migrations"
+)
+
+// The IPFS repo is checked on open. A repo of an older version is migrated with the
+// fs-repo-migrations tool of go-ipfs, after its config, version and datastore spec have
+// been backed up into the repo dir. A repo of a newer version, or one without a version,
+// is refused, as its layout is unknown. Pins whose root blocks are missing are reported,
+// CheckObject verifies all blocks of a pinned object and fetches missing ones from peers.
+
+const (
+	repoVersionFile  = "version"
+	repoBackupPrefix = "backup-v"
+	fetchTimeout     = 5 * time.Minute
+)
+
+// repoBackupFiles are small files of the repo that migrations rewrite.
+var repoBackupFiles = []string{"config", repoVersionFile, "datastore_spec"}
+
+var ErrRepoNoVersion = errors.New("IPFS repo has no version file, it's corrupted or not initialized")
+
+// RepoTooNewError is returned for repos written by a newer release of IPFS.
+type RepoTooNewError struct {
+	Version   int
+	Supported int
+}
+
+func (e *RepoTooNewError) Error() string {
+	return fmt.Sprintf("IPFS repo version %d is newer than %d supported by this release, upgrade the node",
+		e.Version, e.Supported)
+}
+
+// ReadRepoVersion returns the version of the IPFS repo in the dir.
+func ReadRepoVersion(prefix string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(prefix, repoVersionFile))
+	if os.IsNotExist(err) {
+		return 0, ErrRepoNoVersion
+	} else if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("malformed IPFS repo version: %v", err)
+	}
+	return v, nil
+}
+
+// checkRepoVersion migrates an outdated repo if migrate is set, the repo must not be locked.
+func checkRepoVersion(prefix string, migrate bool) error {
+	version, err := ReadRepoVersion(prefix)
+	if err != nil {
+		return err
+	} else if version == fsrepo.RepoVersion {
+		return nil
+	} else if version > fsrepo.RepoVersion {
+		return &RepoTooNewError{
+			Version:   version,
+			Supported: fsrepo.RepoVersion,
+		}
+	} else if !migrate {
+		return fmt.Errorf("IPFS repo version %d needs a migration to %d, enable auto migration or run fs-repo-migrations",
+			version, fsrepo.RepoVersion)
+	}
+	fields := log.Fields{
+		"dir":  prefix,
+		"from": version,
+		"to":   fsrepo.RepoVersion,
+	}
+	backupDir, err := backupRepoFiles(prefix, version)
+	if err != nil {
+		return fmt.Errorf("failed to back up the IPFS repo before migration: %v", err)
+	}
+	log.WithFields(fields).Println("migrating the IPFS repo, it may take a while")
+	// fs-repo-migrations finds the repo by IPFS_PATH
+	if err := os.Setenv("IPFS_PATH", prefix); err != nil {
+		return err
+	}
+	if err := migrations.RunMigration(fsrepo.RepoVersion); err != nil {
+		err = fmt.Errorf("IPFS repo migration failed, files of version %d are kept in %s: %v",
+			version, backupDir, err)
+		return err
+	}
+	if version, err = ReadRepoVersion(prefix); err != nil {
+		return err
+	} else if version != fsrepo.RepoVersion {
+		return fmt.Errorf("IPFS repo is of version %d after migration, want %d", version, fsrepo.RepoVersion)
+	}
+	log.WithFields(fields).Println("IPFS repo migrated")
+	return nil
+}
+
+func backupRepoFiles(prefix string, version int) (string, error) {
+	dir := filepath.Join(prefix, repoBackupPrefix+strconv.Itoa(version))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	for _, name := range repoBackupFiles {
+		data, err := ioutil.ReadFile(filepath.Join(prefix, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// checkPins reports pinned objects whose root blocks are missing from the blockstore.
+func (s *ipfsStore) checkPins() {
+	var missing int
+	keys := s.node.Pinning.RecursiveKeys()
+	for _, c := range keys {
+		if has, err := s.node.Blockstore.Has(c); err != nil {
+			log.Warningf("failed to check the pinned object %s: %v", c.String(), err)
+			return
+		} else if !has {
+			missing++
+		}
+	}
+	if missing > 0 {
+		log.Warningf("%d of %d pinned objects miss blocks, run atlant-go fsck --repair to fetch them",
+			missing, len(keys))
+	}
+}
+
+// ObjectCheck is the result of checking blocks of an object version.
+type ObjectCheck struct {
+	Version string `json:"version"`
+	Blocks  int    `json:"blocks"`
+	// Missing blocks were not found locally and, on repair, not fetched from peers.
+	Missing  int  `json:"missing"`
+	Fetched  int  `json:"fetched"`
+	Pinned   bool `json:"pinned"`
+	Repinned bool `json:"repinned,omitempty"`
+}
+
+// CheckObject checks that all blocks of the object version are local and that it's pinned.
+// With repair set missing blocks are fetched from peers and a complete object is pinned.
+func (s *ipfsStore) CheckObject(ctx context.Context, version string, repair bool) (*ObjectCheck, error) {
+	root, err := cid.Decode(version)
+	if err != nil {
+		err = fmt.Errorf("failed to decode version CID %s: %v", version, err)
+		return nil, err
+	}
+	check := &ObjectCheck{
+		Version: version,
+	}
+	_, check.Pinned, err = s.node.Pinning.IsPinned(root)
+	if err != nil {
+		return nil, err
+	}
+	seen := cid.NewSet()
+	var walk func(c *cid.Cid) error
+	walk = func(c *cid.Cid) error {
+		if !seen.Visit(c) {
+			return nil
+		}
+		has, err := s.node.Blockstore.Has(c)
+		if err != nil {
+			return err
+		} else if !has && !repair {
+			check.Missing++
+			return nil
+		}
+		getCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+		node, err := s.node.DAG.Get(getCtx, c)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			check.Missing++
+			return nil
+		}
+		check.Blocks++
+		if !has {
+			check.Fetched++
+		}
+		for _, link := range node.Links() {
+			if err := walk(link.Cid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(root); err != nil {
+		return check, err
+	}
+	if repair && !check.Pinned && check.Missing == 0 {
+		if err := s.PinObject(ObjectRef{Version: version}); err != nil {
+			return check, err
+		}
+		check.Pinned = true
+		check.Repinned = true
+	}
+	return check, nil
+}
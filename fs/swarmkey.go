@@ -0,0 +1,64 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Swarm keys are pre-shared keys of private IPFS swarms, 32 bytes written as hex. Nodes
+// with different keys can't connect, so a swarm is as private as its key: a key typed by
+// hand, e.g. a repeated pattern, or a key published elsewhere lets strangers in.
+
+const (
+	swarmKeySize = 32
+	// minSwarmKeyBytes is the least number of distinct bytes a key must have, 32 random
+	// bytes have about 30 of them and almost never fewer than 20.
+	minSwarmKeyBytes = 20
+)
+
+var ErrWeakSwarmKey = errors.New("swarm key is weak")
+
+// NewSwarmKey returns a hex key of a new random pre-shared key.
+func NewSwarmKey() (string, error) {
+	data, err := NewPrivateKey()
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	return lines[len(lines)-1], nil
+}
+
+// ValidateSwarmKey checks that the hex key has the size of a pre-shared key and looks random.
+func ValidateSwarmKey(key string) error {
+	data, err := hex.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("swarm key must be hex encoded: %v", err)
+	} else if len(data) != swarmKeySize {
+		return fmt.Errorf("swarm key must be %d bytes long, it's %d", swarmKeySize, len(data))
+	}
+	distinct := make(map[byte]struct{}, len(data))
+	for _, b := range data {
+		distinct[b] = struct{}{}
+	}
+	if len(distinct) < minSwarmKeyBytes {
+		return fmt.Errorf("%v: %d distinct bytes of %d, generate it with atlant-keygen net",
+			ErrWeakSwarmKey, len(distinct), swarmKeySize)
+	}
+	step := data[1] - data[0]
+	for i := 2; i < len(data); i++ {
+		if data[i]-data[i-1] != step {
+			return nil
+		}
+	}
+	return fmt.Errorf("%v: bytes are a sequence, generate it with atlant-keygen net", ErrWeakSwarmKey)
+}
+
+// SwarmKeyFingerprint identifies the hex key without disclosing it, nodes of a swarm
+// report the same fingerprint.
+func SwarmKeyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(key))))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
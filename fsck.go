@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+	"github.com/xlab/closer"
+
+	"github.com/AtlantPlatform/atlant-go/node"
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+// fsck verifies that objects of records are pinned with all their blocks. The running node
+// is asked to do it over the private API, as it holds the IPFS repo, otherwise the stores
+// are opened by the command itself.
+
+func fsckCmd(c *cli.Cmd) {
+	repair := c.Bool(cli.BoolOpt{
+		Name:  "repair",
+		Desc:  "Fetch missing blocks from peers and pin complete objects again.",
+		Value: false,
+	})
+	c.Action = func() {
+		log.Println("checking objects of records, it may take a while")
+		if _, err := readPrivateAPIFile(); err == nil {
+			body, err := ctlRequest("POST", fmt.Sprintf("/private/v1/fsck?repair=%v", *repair), 24*time.Hour)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			var report *rs.FsckReport
+			if err := json.Unmarshal(body, &report); err != nil {
+				log.Fatalln(err)
+			}
+			printFsckReport(report)
+			return
+		}
+		initEnvironment()
+		runWithPlanetaryContext(func(ctx node.Context) {
+			store, err := rs.NewPlanetaryRecordStore(ctx.NodeID(), ctx.FileStore(), ctx.StateStore())
+			if err != nil {
+				closer.Fatalln(err)
+			}
+			defer store.Close()
+			if *repair {
+				time.Sleep(duration(*fsWarmupDur, 5*time.Second))
+			}
+			report, err := store.Fsck(context.Background(), *repair)
+			if err != nil {
+				closer.Fatalln(err)
+			}
+			printFsckReport(report)
+		})
+	}
+}
+
+func printFsckReport(report *rs.FsckReport) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	if len(report.Damaged) > 0 {
+		if !report.Repair {
+			log.Warningln("some objects are damaged, run with --repair to fetch missing blocks")
+		} else {
+			log.Warningln("some objects are still damaged, their blocks were not found on peers")
+		}
+	}
+}
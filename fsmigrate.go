@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+)
+
+// Migration moves the IPFS repo to a new dir, e.g. on a bigger disk, while the node keeps
+// serving. The repo is copied as is, then copied again to catch up with files changed in
+// the meantime, each pass copies less. For the switch the node is stopped, the last pass
+// copies what's left and the fs dir is replaced with a link to the new dir, so the node is
+// restarted from it with the same config.
+
+const (
+	fsMigrateMarker   = ".atlant-migrate"
+	fsMigratePasses   = 5
+	fsMigrateStopWait = 2 * time.Minute
+)
+
+// fsMigrateSkip are files of a running repo that must not be copied.
+var fsMigrateSkip = map[string]bool{
+	"repo.lock": true,
+	"api":       true,
+}
+
+type dirSyncStats struct {
+	Files   int
+	Bytes   int64
+	Removed int
+}
+
+func fsCmd(c *cli.Cmd) {
+	c.Command("migrate", "Move the IPFS repo to a new dir while the node keeps serving.", fsMigrateCmd)
+}
+
+func fsMigrateCmd(c *cli.Cmd) {
+	c.Spec = "--to [--stop] [--link]"
+	to := c.String(cli.StringOpt{
+		Name: "to",
+		Desc: "New dir of the IPFS repo, it must be empty or hold an unfinished migration.",
+	})
+	stop := c.Bool(cli.BoolOpt{
+		Name:  "stop",
+		Desc:  "Stop the running node for the switch, otherwise the repo is only copied.",
+		Value: false,
+	})
+	link := c.Bool(cli.BoolOpt{
+		Name:  "link",
+		Desc:  "Replace the fs dir with a link to the new dir, so that the node starts from it with the same config.",
+		Value: true,
+	})
+	c.Action = func() {
+		src, err := filepath.EvalSymlinks(*fsDir)
+		if err == nil {
+			src, err = filepath.Abs(src)
+		}
+		if err != nil {
+			log.Fatalln("failed to find the fs dir:", err)
+		} else if !fileNotEmpty(filepath.Join(src, ipfsConfigFile)) {
+			log.Fatalln("no IPFS repo found in", src)
+		}
+		dst, err := filepath.Abs(*to)
+		if err != nil {
+			log.Fatalln(err)
+		} else if dst == src || isSubdir(dst, src) || isSubdir(src, dst) {
+			log.Fatalln("the new dir and the fs dir must not contain each other")
+		}
+		if err := prepareMigrationDir(dst); err != nil {
+			log.Fatalln(err)
+		}
+		info, err := readPrivateAPIFile()
+		running := err == nil
+
+		log.WithField("to", dst).Infoln("copying the IPFS repo, it may take a while")
+		stats, err := syncDir(src, dst)
+		if err != nil {
+			log.Fatalln("failed to copy the IPFS repo:", err)
+		}
+		logSyncPass(1, stats)
+		for pass := 2; running && pass <= fsMigratePasses && stats.Files > 0; pass++ {
+			if stats, err = syncDir(src, dst); err != nil {
+				log.Fatalln("failed to copy the IPFS repo:", err)
+			}
+			logSyncPass(pass, stats)
+		}
+		if running {
+			if !*stop {
+				log.Infoln("the repo is copied, run again with --stop to switch to it, only the changes will be copied")
+				return
+			}
+			log.Infoln("stopping the node for the switch")
+			if err := stopNode(info); err != nil {
+				log.Fatalln("failed to stop the node:", err)
+			}
+			if stats, err = syncDir(src, dst); err != nil {
+				log.Fatalln("failed to copy the IPFS repo:", err)
+			}
+			logSyncPass(0, stats)
+		}
+		if err := os.Remove(filepath.Join(dst, fsMigrateMarker)); err != nil {
+			log.Fatalln(err)
+		}
+		if !*link {
+			log.Infof("the repo is moved, start the node with --fs-dir %s", dst)
+			return
+		}
+		old, err := linkFSDir(*fsDir, dst)
+		if err != nil {
+			log.Fatalln("failed to link the fs dir:", err)
+		}
+		log.WithField("old", old).Infof("the repo is moved, %s links to %s now; remove the old repo once the node runs fine", *fsDir, dst)
+	}
+}
+
+func isSubdir(dir, parent string) bool {
+	return strings.HasPrefix(dir, strings.TrimSuffix(parent, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func logSyncPass(pass int, stats *dirSyncStats) {
+	fields := log.Fields{
+		"files":   stats.Files,
+		"bytes":   stats.Bytes,
+		"removed": stats.Removed,
+	}
+	if pass == 0 {
+		log.WithFields(fields).Infoln("final pass done")
+		return
+	}
+	log.WithFields(fields).Infof("pass %d done", pass)
+}
+
+// prepareMigrationDir creates the dir, an existing one must be empty or marked by a previous run.
+func prepareMigrationDir(dir string) error {
+	marker := filepath.Join(dir, fsMigrateMarker)
+	if empty, err := dirEmpty(dir); err != nil {
+		return err
+	} else if !empty {
+		if _, err := os.Stat(marker); err != nil {
+			return fmt.Errorf("dir %s is not empty", dir)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(marker, []byte(time.Now().Format(time.RFC3339)), 0600)
+}
+
+// syncDir makes dst a copy of src. Files are compared by size and modification time, files
+// removed from src while it's walked are skipped, the next pass removes them from dst.
+func syncDir(src, dst string) (*dirSyncStats, error) {
+	stats := new(dirSyncStats)
+	seen := make(map[string]bool)
+	if err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		} else if fsMigrateSkip[rel] {
+			return nil
+		}
+		seen[rel] = true
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			return copySymlink(p, target)
+		case info.Mode().IsRegular():
+			if t, err := os.Lstat(target); err == nil && t.Size() == info.Size() && t.ModTime().Equal(info.ModTime()) {
+				return nil
+			}
+			n, err := copyFile(p, target, info)
+			if os.IsNotExist(err) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			stats.Files++
+			stats.Bytes += n
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	err := filepath.Walk(dst, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dst, p)
+		if err != nil {
+			return err
+		} else if seen[rel] || rel == fsMigrateMarker {
+			return nil
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		stats.Removed++
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// copyFile copies the file through a temporary one, so that the target is never partial.
+func copyFile(src, dst string, info os.FileInfo) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	tmp := dst + ".migrating"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return n, os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+func copySymlink(src, dst string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if prev, err := os.Readlink(dst); err == nil && prev == target {
+		return nil
+	}
+	os.Remove(dst)
+	return os.Symlink(target, dst)
+}
+
+// stopNode asks the running node to shut down and waits until its private API is gone,
+// which happens once the IPFS repo is closed and the process exits.
+func stopNode(info *privateAPIInfo) error {
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/private/v1/shutdown", info.Addr), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+info.Token)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	if resp.StatusCode != 202 {
+		return fmt.Errorf("node responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	deadline := time.Now().Add(fsMigrateStopWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(500 * time.Millisecond)
+		resp, err := client.Get(fmt.Sprintf("http://%s/private/v1/ping", info.Addr))
+		if err != nil {
+			return nil
+		}
+		resp.Body.Close()
+	}
+	return fmt.Errorf("node did not stop within %s", fsMigrateStopWait)
+}
+
+// linkFSDir replaces the fs dir with a link to the new dir, an fs dir that is a real dir
+// is kept next to it. It returns the path of the old repo.
+func linkFSDir(fsDir, dst string) (string, error) {
+	info, err := os.Lstat(fsDir)
+	if err != nil {
+		return "", err
+	}
+	var old string
+	if info.Mode()&os.ModeSymlink != 0 {
+		// migrated before, the link is replaced and the repo it points to is left in place
+		if old, err = filepath.EvalSymlinks(fsDir); err != nil {
+			return "", err
+		} else if err := os.Remove(fsDir); err != nil {
+			return "", err
+		}
+	} else {
+		old = strings.TrimSuffix(fsDir, string(filepath.Separator)) + ".migrated"
+		if _, err := os.Lstat(old); err == nil {
+			return "", fmt.Errorf("%s exists already", old)
+		} else if err := os.Rename(fsDir, old); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Symlink(dst, fsDir); err != nil {
+		return "", err
+	}
+	return old, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+)
+
+func gcCmd(c *cli.Cmd) {
+	c.Action = func() {
+		info, err := readPrivateAPIFile()
+		if err != nil {
+			log.Fatalln("failed to find the running node:", err)
+		}
+		log.Println("running garbage collection, it may take a while")
+		req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/private/v1/gc", info.Addr), nil)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+info.Token)
+		resp, err := (&http.Client{Timeout: time.Hour}).Do(req)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		var report json.RawMessage
+		if err := json.Unmarshal(body, &report); err != nil {
+			log.Fatalf("node responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		if resp.StatusCode != 200 {
+			log.Fatalln("garbage collection failed:", resp.Status)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/rs"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// The identity key of a node may be exported, encrypted with a passphrase, and imported into
+// the repo of a node initialized on another machine, so the node keeps its ID on new hardware.
+// A node that can't trust its old key anymore rotates to a new identity instead, the running
+// node links its ID to the new one, so permissions and usage history pass to the new ID.
+
+const (
+	identityExportFormat = 1
+	identityScryptN      = 1 << 15
+	identityScryptR      = 8
+	identityScryptP      = 1
+)
+
+type identityExport struct {
+	Format  int    `json:"format"`
+	NodeID  string `json:"node_id"`
+	KDF     string `json:"kdf"`
+	N       int    `json:"scrypt_n"`
+	R       int    `json:"scrypt_r"`
+	P       int    `json:"scrypt_p"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Key     []byte `json:"key"`
+	Created string `json:"created_at"`
+}
+
+func identityCmd(c *cli.Cmd) {
+	c.Command("export", "Write the identity key of the node encrypted with a passphrase.", identityExportCmd)
+	c.Command("import", "Replace the identity of the stopped node with an exported one.", identityImportCmd)
+	c.Command("rotate", "Switch the running node to a new identity linked to the current one.", identityRotateCmd)
+}
+
+func passphraseOpts(c *cli.Cmd) func() ([]byte, error) {
+	passphrase := c.String(cli.StringOpt{
+		Name:      "passphrase",
+		Desc:      "Passphrase of the identity export.",
+		EnvVar:    "AN_IDENTITY_PASSPHRASE",
+		HideValue: true,
+	})
+	passphraseFile := c.String(cli.StringOpt{
+		Name: "passphrase-file",
+		Desc: "File with the passphrase of the identity export, the first line is used.",
+	})
+	return func() ([]byte, error) {
+		if len(*passphraseFile) > 0 {
+			data, err := ioutil.ReadFile(*passphraseFile)
+			if err != nil {
+				return nil, err
+			}
+			*passphrase = strings.SplitN(string(data), "\n", 2)[0]
+		}
+		if len(*passphrase) < 8 {
+			return nil, errors.New("passphrase of at least 8 chars is required, set --passphrase-file or AN_IDENTITY_PASSPHRASE")
+		}
+		return []byte(*passphrase), nil
+	}
+}
+
+func identityExportCmd(c *cli.Cmd) {
+	c.Spec = "[--out] [--passphrase | --passphrase-file]"
+	out := c.String(cli.StringOpt{
+		Name:  "o out",
+		Desc:  "Output file of the export, - writes to stdout.",
+		Value: "-",
+	})
+	readPassphrase := passphraseOpts(c)
+	c.Action = func() {
+		passphrase, err := readPassphrase()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		id, err := fs.ReadIdentity(*fsDir)
+		if err != nil {
+			log.Fatalln("failed to read the identity:", err)
+		}
+		data, err := sealIdentity(id, passphrase)
+		if err != nil {
+			log.Fatalln("failed to encrypt the identity:", err)
+		}
+		var w io.Writer = os.Stdout
+		if *out != "-" {
+			f, err := os.OpenFile(*out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+			if err != nil {
+				log.Fatalln("failed to create export file:", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if _, err := w.Write(data); err != nil {
+			log.Fatalln("failed to write export:", err)
+		}
+		log.WithField("node", id.NodeID).Println("identity exported, keep the file and the passphrase apart")
+	}
+}
+
+func identityImportCmd(c *cli.Cmd) {
+	c.Spec = "[--passphrase | --passphrase-file] FILE"
+	file := c.StringArg("FILE", "", "File written by identity export.")
+	readPassphrase := passphraseOpts(c)
+	c.Action = func() {
+		if _, err := readPrivateAPIFile(); err == nil {
+			log.Fatalln("node seems to be running, stop it before importing an identity")
+		}
+		passphrase, err := readPassphrase()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		data, err := ioutil.ReadFile(*file)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		id, err := openIdentity(data, passphrase)
+		if err != nil {
+			log.Fatalln("failed to decrypt the identity:", err)
+		}
+		if prev, err := fs.ReadIdentity(*fsDir); err == nil {
+			if prev.NodeID == id.NodeID {
+				log.Println("the node has this identity already")
+				return
+			}
+			log.WithField("node", prev.NodeID).Warningln("replacing the identity of the node")
+			// the data key of encrypted state buckets must open with the imported identity
+			if err := state.RewrapDataKey(*stateDir, prev.PrivKey, id.PrivKey); err != nil {
+				log.Fatalln("failed to wrap the state data key with the identity:", err)
+			}
+		}
+		if err := fs.WriteIdentity(*fsDir, id); err != nil {
+			log.Fatalln("failed to write the identity:", err)
+		}
+		log.WithField("node", id.NodeID).Println("identity imported, never run two nodes with the same identity")
+	}
+}
+
+func identityRotateCmd(c *cli.Cmd) {
+	c.Action = func() {
+		body, err := ctlRequest("POST", "/private/v1/identity/rotate", 10*time.Minute)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		var rotation rs.IdentityRotation
+		if err := json.Unmarshal(body, &rotation); err != nil {
+			log.Fatalln("failed to read the rotation:", err)
+		}
+		log.WithFields(log.Fields{
+			"old_id": rotation.OldID,
+			"new_id": rotation.NewID,
+		}).Println("identity rotated, restart the node to use the new one")
+	}
+}
+
+func identityKey(passphrase, salt []byte, n, r, p int) (*[32]byte, error) {
+	derived, err := scrypt.Key(passphrase, salt, n, r, p, 32)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], derived)
+	return &key, nil
+}
+
+func sealIdentity(id *fs.Identity, passphrase []byte) ([]byte, error) {
+	exp := &identityExport{
+		Format:  identityExportFormat,
+		NodeID:  id.NodeID,
+		KDF:     "scrypt",
+		N:       identityScryptN,
+		R:       identityScryptR,
+		P:       identityScryptP,
+		Salt:    make([]byte, 32),
+		Nonce:   make([]byte, 24),
+		Created: time.Now().UTC().Format(time.RFC3339),
+	}
+	if _, err := rand.Read(exp.Salt); err != nil {
+		return nil, err
+	} else if _, err := rand.Read(exp.Nonce); err != nil {
+		return nil, err
+	}
+	key, err := identityKey(passphrase, exp.Salt, exp.N, exp.R, exp.P)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	copy(nonce[:], exp.Nonce)
+	exp.Key = secretbox.Seal(nil, id.PrivKey, &nonce, key)
+	return json.MarshalIndent(exp, "", "  ")
+}
+
+func openIdentity(data, passphrase []byte) (*fs.Identity, error) {
+	var exp identityExport
+	if err := json.Unmarshal(data, &exp); err != nil {
+		return nil, fmt.Errorf("malformed identity export: %v", err)
+	} else if exp.Format != identityExportFormat || exp.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported identity export format %d", exp.Format)
+	} else if len(exp.Nonce) != 24 {
+		return nil, errors.New("malformed identity export: bad nonce")
+	}
+	key, err := identityKey(passphrase, exp.Salt, exp.N, exp.R, exp.P)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	copy(nonce[:], exp.Nonce)
+	priv, ok := secretbox.Open(nil, exp.Key, &nonce, key)
+	if !ok {
+		return nil, errors.New("wrong passphrase or damaged export")
+	}
+	id := &fs.Identity{
+		NodeID:  exp.NodeID,
+		PrivKey: priv,
+	}
+	if err := id.Verify(); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/logging"
 )
 
 var logger = &rotatingLogger{
@@ -78,6 +80,9 @@ func (l *rotatingLogger) Levels() []log.Level {
 }
 
 func (l *rotatingLogger) Fire(entry *log.Entry) error {
+	if !logging.Allowed(entry) {
+		return nil
+	}
 	l.fileMux.Lock()
 	defer l.fileMux.Unlock()
 	if l.file == nil {
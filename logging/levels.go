@@ -0,0 +1,287 @@
+package logging
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Modules of the node are logged at their own levels, so that one of them can be debugged
+// without the others flooding the logs. All packages log through the standard logger, the
+// module of an entry is told by the package that logged it, code outside of the modules is
+// logged at the default level. The standard logger runs at the most verbose of the levels,
+// entries above the level of their module are dropped by its formatter and skipped by hooks
+// that check Allowed.
+
+// Modules lists modules with their own log level.
+var Modules = []string{"api", "rs", "fs", "state", "contracts", "authcenter"}
+
+// DefaultModule names the level of code outside of the modules.
+const DefaultModule = "default"
+
+const modulePrefix = "github.com/AtlantPlatform/atlant-go/"
+
+type moduleLevels struct {
+	mux     *sync.RWMutex
+	logger  *log.Logger
+	def     log.Level
+	levels  map[string]log.Level
+	uniform bool
+	// reverts restore levels changed for a while, by module
+	reverts map[string]*levelRevert
+	// modules keeps modules of callers by PC, see callerModule
+	modules *sync.Map
+}
+
+var levels = &moduleLevels{
+	mux:     new(sync.RWMutex),
+	def:     log.InfoLevel,
+	levels:  make(map[string]log.Level),
+	uniform: true,
+	reverts: make(map[string]*levelRevert),
+	modules: new(sync.Map),
+}
+
+// ParseLevel parses a level name, e.g. debug, or a number from 0 (panic) to 5 (debug).
+func ParseLevel(s string) (log.Level, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < int(log.PanicLevel) || n > int(log.DebugLevel) {
+			return 0, fmt.Errorf("bad log level %d: use 0...5", n)
+		}
+		return log.Level(n), nil
+	}
+	return log.ParseLevel(s)
+}
+
+// ParseModuleLevels parses levels of modules given as module=level pairs separated by comma.
+func ParseModuleLevels(s string) (map[string]log.Level, error) {
+	result := make(map[string]log.Level)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad module log level: %s", pair)
+		}
+		module := strings.TrimSpace(parts[0])
+		if !isModule(module) {
+			return nil, fmt.Errorf("bad module log level: %s: unknown module", pair)
+		}
+		level, err := ParseLevel(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad module log level: %s: %v", pair, err)
+		}
+		result[module] = level
+	}
+	return result, nil
+}
+
+func isModule(name string) bool {
+	for _, m := range Modules {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Configure sets the default level and levels of modules, modules not listed are logged
+// at the default level. The formatter of the logger must be set beforehand.
+func Configure(logger *log.Logger, def log.Level, modules map[string]log.Level) {
+	levels.mux.Lock()
+	defer levels.mux.Unlock()
+	levels.logger = logger
+	levels.def = def
+	levels.levels = make(map[string]log.Level, len(modules))
+	for module, level := range modules {
+		levels.levels[module] = level
+	}
+	if _, ok := logger.Formatter.(*moduleFormatter); !ok {
+		logger.Formatter = &moduleFormatter{
+			Formatter: logger.Formatter,
+		}
+	}
+	levels.apply()
+}
+
+// SetLevel changes the level of the module at runtime, DefaultModule sets the default level.
+func SetLevel(module string, level log.Level) error {
+	return SetLevelFor(module, level, 0)
+}
+
+// SetLevelFor changes the level of the module for a while and then restores the previous one,
+// so that debug logs left on by accident don't fill the disk. Zero duration keeps the level.
+func SetLevelFor(module string, level log.Level, d time.Duration) error {
+	levels.mux.Lock()
+	defer levels.mux.Unlock()
+	if module != DefaultModule && !isModule(module) {
+		return fmt.Errorf("unknown module %s", module)
+	}
+	r := &levelRevert{
+		module: module,
+	}
+	if pending, ok := levels.reverts[module]; ok {
+		// a pending revert keeps the level from before the first change
+		pending.timer.Stop()
+		delete(levels.reverts, module)
+		r.prev, r.hadLevel = pending.prev, pending.hadLevel
+	} else if module == DefaultModule {
+		r.prev, r.hadLevel = levels.def, true
+	} else {
+		r.prev, r.hadLevel = levels.levels[module]
+	}
+	levels.set(module, level)
+	if d > 0 {
+		r.timer = time.AfterFunc(d, r.revert)
+		levels.reverts[module] = r
+	}
+	return nil
+}
+
+type levelRevert struct {
+	module   string
+	prev     log.Level
+	hadLevel bool
+	timer    *time.Timer
+}
+
+func (r *levelRevert) revert() {
+	levels.mux.Lock()
+	defer levels.mux.Unlock()
+	if levels.reverts[r.module] != r {
+		return
+	}
+	delete(levels.reverts, r.module)
+	if r.hadLevel {
+		levels.set(r.module, r.prev)
+		return
+	}
+	delete(levels.levels, r.module)
+	levels.apply()
+}
+
+// set must be called with the lock held.
+func (m *moduleLevels) set(module string, level log.Level) {
+	if module == DefaultModule {
+		m.def = level
+	} else {
+		m.levels[module] = level
+	}
+	m.apply()
+}
+
+// Levels returns the level of each module and the default one, by name.
+func Levels() map[string]string {
+	levels.mux.RLock()
+	defer levels.mux.RUnlock()
+	result := make(map[string]string, len(Modules)+1)
+	result[DefaultModule] = levels.def.String()
+	for _, module := range Modules {
+		result[module] = levels.levelOf(module).String()
+	}
+	return result
+}
+
+// apply must be called with the lock held.
+func (m *moduleLevels) apply() {
+	max := m.def
+	m.uniform = true
+	for _, level := range m.levels {
+		if level > max {
+			max = level
+		}
+		if level != m.def {
+			m.uniform = false
+		}
+	}
+	if m.logger != nil {
+		m.logger.SetLevel(max)
+	}
+}
+
+// levelOf must be called with the lock held.
+func (m *moduleLevels) levelOf(module string) log.Level {
+	if level, ok := m.levels[module]; ok {
+		return level
+	}
+	return m.def
+}
+
+// Allowed tells whether the entry is within the level of the module that logged it,
+// it must be called from a formatter or a hook of the logger.
+func Allowed(entry *log.Entry) bool {
+	if entry.Level <= log.FatalLevel {
+		return true
+	}
+	levels.mux.RLock()
+	uniform, def := levels.uniform, levels.def
+	levels.mux.RUnlock()
+	if uniform {
+		return entry.Level <= def
+	}
+	module := levels.callerModule()
+	levels.mux.RLock()
+	defer levels.mux.RUnlock()
+	return entry.Level <= levels.levelOf(module)
+}
+
+// callerModule finds the module of the code that called into logrus, it's the first
+// frame after the frames of logrus, formatters and hooks are called by logrus.
+func (m *moduleLevels) callerModule() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	inLogrus := false
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, "github.com/sirupsen/logrus.") {
+			inLogrus = true
+		} else if inLogrus {
+			if v, ok := m.modules.Load(frame.PC); ok {
+				return v.(string)
+			}
+			module := moduleOf(frame.Function)
+			m.modules.Store(frame.PC, module)
+			return module
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// moduleOf returns the module of a function, e.g. rs of atlant-go/rs.(*recordStore).Sync,
+// subpackages belong to the module of their parent.
+func moduleOf(function string) string {
+	if !strings.HasPrefix(function, modulePrefix) {
+		return ""
+	}
+	name := function[len(modulePrefix):]
+	if i := strings.IndexAny(name, "./"); i > 0 {
+		name = name[:i]
+	}
+	if !isModule(name) {
+		return ""
+	}
+	return name
+}
+
+// moduleFormatter drops entries above the level of their module.
+type moduleFormatter struct {
+	log.Formatter
+}
+
+func (f *moduleFormatter) Format(entry *log.Entry) ([]byte, error) {
+	if !Allowed(entry) {
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
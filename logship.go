@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/logging"
+)
+
+// Log shipping sends entries to central sinks as JSON lines, so logs of a cluster can be
+// aggregated without scraping files. Sinks never block logging: entries are queued and
+// dropped if a sink falls behind.
+
+const (
+	logShipQueueSize     = 10000
+	logShipBatchSize     = 500
+	logShipFlushInterval = time.Second
+	logShipTimeout       = 10 * time.Second
+)
+
+type logShipHook interface {
+	log.Hook
+	// Close flushes queued entries and releases the sink.
+	Close() error
+}
+
+// newLogShipHook creates a hook for the sink spec, see the --log-ship option.
+func newLogShipHook(spec string, minLevel log.Level) (logShipHook, error) {
+	if spec == "syslog" {
+		return newSyslogHook("", "", minLevel)
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	} else if len(u.Host) == 0 {
+		return nil, fmt.Errorf("log sink %s has no host", spec)
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+udp":
+		return newSyslogHook("udp", u.Host, minLevel)
+	case "syslog+tcp":
+		return newSyslogHook("tcp", u.Host, minLevel)
+	case "loki+http", "loki+https":
+		u.Scheme = strings.TrimPrefix(u.Scheme, "loki+")
+		if len(u.Path) == 0 || u.Path == "/" {
+			u.Path = "/loki/api/v1/push"
+		}
+		return newLokiHook(u.String(), minLevel), nil
+	default:
+		return nil, fmt.Errorf("unknown log sink scheme %s", u.Scheme)
+	}
+}
+
+func shipLevels(minLevel log.Level) []log.Level {
+	var levels []log.Level
+	for _, lvl := range log.AllLevels {
+		if lvl <= minLevel {
+			levels = append(levels, lvl)
+		}
+	}
+	return levels
+}
+
+// logShipQueue decouples hooks from the sink, entries are formatted at Fire time.
+type logShipQueue struct {
+	entries  chan []byte
+	dropped  uint64
+	dropMux  *sync.Mutex
+	done     chan struct{}
+	closeMux *sync.Once
+}
+
+func newLogShipQueue() *logShipQueue {
+	return &logShipQueue{
+		entries:  make(chan []byte, logShipQueueSize),
+		dropMux:  new(sync.Mutex),
+		done:     make(chan struct{}),
+		closeMux: new(sync.Once),
+	}
+}
+
+func (q *logShipQueue) push(line []byte) {
+	select {
+	case q.entries <- line:
+	default:
+		q.dropMux.Lock()
+		q.dropped++
+		q.dropMux.Unlock()
+	}
+}
+
+// takeDropped returns the number of entries dropped since the last call.
+func (q *logShipQueue) takeDropped() uint64 {
+	q.dropMux.Lock()
+	defer q.dropMux.Unlock()
+	n := q.dropped
+	q.dropped = 0
+	return n
+}
+
+// run calls flush with batches of entries until the queue is closed.
+func (q *logShipQueue) run(flush func(lines [][]byte) error) {
+	t := time.NewTicker(logShipFlushInterval)
+	defer t.Stop()
+	defer close(q.done)
+	var batch [][]byte
+	var failing bool
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		// logging errors through logrus would loop back into the sink
+		if err := flush(batch); err != nil && !failing {
+			failing = true
+			fmt.Fprintf(os.Stderr, "log shipping failed, entries are dropped until the sink recovers: %v\n", err)
+		} else if err == nil && failing {
+			failing = false
+			fmt.Fprintln(os.Stderr, "log shipping recovered")
+		}
+		if n := q.takeDropped(); n > 0 {
+			fmt.Fprintf(os.Stderr, "log shipping dropped %d entries\n", n)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case line, ok := <-q.entries:
+			if !ok {
+				send()
+				return
+			}
+			if batch = append(batch, line); len(batch) >= logShipBatchSize {
+				send()
+			}
+		case <-t.C:
+			send()
+		}
+	}
+}
+
+// close flushes the queued entries, waiting for them no longer than logShipTimeout.
+func (q *logShipQueue) close() {
+	q.closeMux.Do(func() {
+		close(q.entries)
+	})
+	select {
+	case <-q.done:
+	case <-time.After(logShipTimeout):
+	}
+}
+
+type syslogHook struct {
+	levels    []log.Level
+	formatter *log.JSONFormatter
+	writer    *syslog.Writer
+	queue     *logShipQueue
+}
+
+func newSyslogHook(network, addr string, minLevel log.Level) (*syslogHook, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, path.Base(os.Args[0]))
+	if err != nil {
+		return nil, err
+	}
+	h := &syslogHook{
+		levels:    shipLevels(minLevel),
+		formatter: new(log.JSONFormatter),
+		writer:    w,
+		queue:     newLogShipQueue(),
+	}
+	go h.queue.run(h.flush)
+	return h, nil
+}
+
+func (h *syslogHook) Levels() []log.Level {
+	return h.levels
+}
+
+func (h *syslogHook) Fire(entry *log.Entry) error {
+	if !logging.Allowed(entry) {
+		return nil
+	}
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	// the severity is kept as the first byte, so that the queue holds plain lines
+	h.queue.push(append([]byte{byte(entry.Level)}, bytes.TrimSpace(line)...))
+	return nil
+}
+
+func (h *syslogHook) flush(lines [][]byte) error {
+	var lastErr error
+	for _, line := range lines {
+		msg := string(line[1:])
+		var err error
+		switch log.Level(line[0]) {
+		case log.PanicLevel, log.FatalLevel:
+			err = h.writer.Crit(msg)
+		case log.ErrorLevel:
+			err = h.writer.Err(msg)
+		case log.WarnLevel:
+			err = h.writer.Warning(msg)
+		case log.InfoLevel:
+			err = h.writer.Info(msg)
+		default:
+			err = h.writer.Debug(msg)
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (h *syslogHook) Close() error {
+	h.queue.close()
+	return h.writer.Close()
+}
+
+// lokiHook pushes entries to Loki, labeled with the host and level.
+type lokiHook struct {
+	levels    []log.Level
+	formatter *log.JSONFormatter
+	url       string
+	labels    map[string]string
+	client    *http.Client
+	queue     *logShipQueue
+}
+
+func newLokiHook(pushURL string, minLevel log.Level) *lokiHook {
+	host, _ := os.Hostname()
+	h := &lokiHook{
+		levels:    shipLevels(minLevel),
+		formatter: new(log.JSONFormatter),
+		url:       pushURL,
+		labels: map[string]string{
+			"job":  "atlant",
+			"host": host,
+		},
+		client: &http.Client{
+			Timeout: logShipTimeout,
+		},
+		queue: newLogShipQueue(),
+	}
+	go h.queue.run(h.flush)
+	return h
+}
+
+func (h *lokiHook) Levels() []log.Level {
+	return h.levels
+}
+
+type lokiEntry struct {
+	Timestamp int64  `json:"ts"`
+	Level     string `json:"level"`
+	Line      string `json:"line"`
+}
+
+func (h *lokiHook) Fire(entry *log.Entry) error {
+	if !logging.Allowed(entry) {
+		return nil
+	}
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(lokiEntry{
+		Timestamp: entry.Time.UnixNano(),
+		Level:     entry.Level.String(),
+		Line:      string(bytes.TrimSpace(line)),
+	})
+	h.queue.push(data)
+	return nil
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (h *lokiHook) flush(lines [][]byte) error {
+	streams := make(map[string]*lokiStream)
+	for _, data := range lines {
+		var e lokiEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			continue
+		}
+		s, ok := streams[e.Level]
+		if !ok {
+			labels := map[string]string{
+				"level": e.Level,
+			}
+			for k, v := range h.labels {
+				labels[k] = v
+			}
+			s = &lokiStream{
+				Stream: labels,
+			}
+			streams[e.Level] = s
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(e.Timestamp, 10), e.Line})
+	}
+	req := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, s := range streams {
+		req.Streams = append(req.Streams, s)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New("loki responded with " + resp.Status)
+	}
+	return nil
+}
+
+func (h *lokiHook) Close() error {
+	h.queue.close()
+	return nil
+}
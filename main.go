@@ -2,14 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"net"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -20,56 +23,105 @@ import (
 
 	"github.com/AtlantPlatform/atlant-go/api"
 	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/cache"
 	"github.com/AtlantPlatform/atlant-go/contracts"
 	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/logging"
+	"github.com/AtlantPlatform/atlant-go/node"
+	"github.com/AtlantPlatform/atlant-go/plugins"
+	"github.com/AtlantPlatform/atlant-go/proxy"
 	"github.com/AtlantPlatform/atlant-go/rs"
 	"github.com/AtlantPlatform/atlant-go/state"
+	"github.com/AtlantPlatform/atlant-go/tracing"
 )
 
 var app = cli.App("atlant-go", "ATLANT Node")
 var appVersion = "1.0.0-rc1"
 
+// gitCommit and buildDate are set at build time, see the build target of the Makefile.
+var (
+	gitCommit = "unknown"
+	buildDate = "unknown"
+)
+
 var (
 	ipfsConfigFile    = "config"
 	ipfsKeyFile       = "swarm.key"
 	ipfsKeyDataPrefix = "/key/swarm/psk/1.0.0/\n/base16/\n"
+	joinTokenFile     = "join-token"
 )
 var (
-	testingCommands []testingCmd
+	// testingCommands are added by builds with -tags testing, as commands of the test plugin
+	testingCommands []plugins.Command
 )
 
-type testingCmd struct {
-	Name string
-	Desc string
-	Init cli.CmdInitializer
-}
-
 func main() {
 	app.Command("init", "Initialize node and its IPFS repo.", nodeInitCmd)
 	app.Command("version", "Show version info.", versionCmd)
-	for _, cmd := range testingCommands {
-		if len(cmd.Name) == 0 {
-			panic("found an unnamed testing command")
-		} else if !strings.HasPrefix(cmd.Name, "test-") {
-			panic("found a testing command that has incorrect name: " + cmd.Name)
+	app.Command("status", "Show state, sync, peers and beats of the running node.", ctlStatusCmd)
+	app.Command("bootstrap-from", "Build node state from a snapshot of a trusted peer.", bootstrapFromCmd)
+	app.Command("support-bundle", "Collect diagnostics of the node for bug reports.", supportBundleCmd)
+	app.Command("gc", "Run garbage collection on the running node.", gcCmd)
+	app.Command("private-token", "Print or rotate the private API token.", privateTokenCmd)
+	app.Command("backup", "Write a backup of the node state and keys.", backupCmd)
+	app.Command("restore", "Restore the node state and keys from backups.", restoreCmd)
+	app.Command("restore-plan", "Check archives of a coordinated backup and import their blocks.", restorePlanCmd)
+	app.Command("fs", "Manage the IPFS repo of the node.", fsCmd)
+	app.Command("stats", "Show bytes served, bytes pinned and uptime reported by nodes.", statsCmd)
+	app.Command("sync-dir", "Mirror a local directory into records under a prefix.", syncDirCmd)
+	app.Command("ctl", "Manage the running node over its private API.", ctlCmd)
+	app.Command("migrate", "Run migrations of the state store, the node must be stopped.", migrateCmd)
+	app.Command("state", "Verify the state store of the stopped node.", stateCmd)
+	app.Command("fsck", "Verify that objects of records are pinned with all their blocks.", fsckCmd)
+	app.Command("rs", "Manage records of the running node.", rsCmd)
+	app.Command("records", "Export and import archives of records with their objects.", recordsCmd)
+	app.Command("sign-allowlist", "Sign an allowlist of node permissions with an Ethereum key.", signAllowlistCmd)
+	app.Command("identity", "Export, import or rotate the identity key of the node.", identityCmd)
+	app.Command("plugins", "List plugins and the commands they add.", pluginsCmd)
+	if len(testingCommands) > 0 {
+		plugins.Register(&plugins.Plugin{
+			Name:     "test",
+			Desc:     "Tests of the node and its dependencies.",
+			Commands: testingCommands,
+		})
+	}
+	loadPlugins()
+	for _, p := range plugins.List() {
+		for _, cmd := range p.Commands {
+			app.Command(cmd.Name, cmd.Desc, cmd.Init)
 		}
-		app.Command(cmd.Name, cmd.Desc, cmd.Init)
 	}
 	logLevel = app.String(cli.StringOpt{
 		Name:   "l log-level",
-		Desc:   "Logging verbosity (0 = minimum, 1...4, 5 = debug).",
+		Desc:   "Default logging verbosity (0 = minimum, 1...4, 5 = debug), see --log-modules.",
 		EnvVar: "AN_LOG_LEVEL",
 		Value:  defaultLogLevel,
 	})
 
 	app.Before = func() {
-		log.SetLevel(log.Level(toNatural(*logLevel, 4)))
-		if log.GetLevel() <= log.InfoLevel {
+		switch *logFormat {
+		case "json":
+			log.SetFormatter(new(log.JSONFormatter))
+		case "text":
+		default:
+			log.Warningln("unknown log format:", *logFormat)
+		}
+		defLevel, err := logging.ParseLevel(*logLevel)
+		if err != nil {
+			log.Warningln("bad log level, using info:", err)
+			defLevel = log.InfoLevel
+		}
+		moduleLevels, err := logging.ParseModuleLevels(*logModules)
+		if err != nil {
+			log.Warningln(err)
+		}
+		logging.Configure(log.StandardLogger(), defLevel, moduleLevels)
+		if defLevel <= log.InfoLevel {
 			gin.SetMode(gin.DebugMode)
 		} else {
 			gin.SetMode(gin.ReleaseMode)
 		}
-		log.Debugf("set app logging to %v", log.GetLevel())
+		log.Debugf("set app logging to %v", defLevel)
 		procs := runtime.GOMAXPROCS(toNatural(*goMaxProcs, 128))
 		log.Debugf("set GOMAXPROCS to %d", procs)
 
@@ -87,167 +139,512 @@ func main() {
 				})
 			}
 		}
+		if len(*logShip) > 0 {
+			shipLevel, err := log.ParseLevel(*logShipLevel)
+			if err != nil {
+				log.Warningln("bad log ship level, using info:", err)
+				shipLevel = log.InfoLevel
+			}
+			for _, spec := range toList(*logShip) {
+				hook, err := newLogShipHook(strings.TrimSpace(spec), shipLevel)
+				if err != nil {
+					log.Warningf("failed to init log sink %s: %v", spec, err)
+					continue
+				}
+				log.AddHook(hook)
+				closer.Bind(func() {
+					hook.Close()
+				})
+			}
+		}
 	}
 	app.Action = func() {
-		var hasTestnetMark bool
-		if info, err := os.Stat(filepath.Join(*fsDir, "testnet")); err == nil && !info.IsDir() {
-			hasTestnetMark = true
+		closer.Init(closer.Config{
+			ExitCodeErr: 1,
+			// SIGHUP rebinds the public API, see rebindOnHangup
+			ExitSignals: []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGABRT},
+		})
+		initEnvironment()
+		defer closer.Close()
+		defer catcher.Catch(catcher.RecvWrite(logger, true))
+		closer.Bind(func() {
+			log.Println("atlant-go node is shut down. Bye!")
+		})
+		var service serviceManager = noService{}
+		if toBool(*serviceMode) {
+			service = startService(closer.Close)
 		}
-		if hasTestnetMark {
-			*envTestnet = true
+		// cleanups run in reverse order: the service is told of the drain before it starts
+		closer.Bind(service.Stopped)
+		log.Println("atlant-go node is starting")
+		cfg := nodeConfig()
+		cfg.SupportConfig = redactedConfig()
+		cfg.Shutdown = closer.Close
+		n, err := node.New(cfg)
+		if err != nil {
+			closer.Fatalln(err)
 		}
-		if *envTestnet {
-			if !hasTestnetMark {
-				log.Fatalln("refusing to start in a testnet mode: not initialized for testnet.")
-			}
-			if *envTestnetKey != testKey {
-				log.Warningln("overriding testnet key works only upon initialization, no effect now.")
-			}
-			domains := append(*envTestnetDomains, authcenter.DefaultTestDomains...)
-			authcenter.InitWithDomains(domains)
-			log.Println("ATLANT TestNet welcomes you!")
-		} else {
-			if len(*envTestnetDomains) > 0 {
-				log.Warningln("overriding DNS auth domains works only within testnet, no effect now.")
+		closer.Bind(func() {
+			if err := n.Stop(); err != nil {
+				log.Warningln(err)
 			}
-			if *envTestnetKey != testKey {
-				log.Warningln("overriding testnet key works only within testnet, no effect now.")
-			}
-			log.Println("ATLANT MainNet welcomes you!")
+		})
+		closer.Bind(func() {
+			service.Stopping(cfg.DrainTimeout)
+		})
+		if len(*clusterName) == 0 {
+			*clusterName = n.SessionID()
 		}
-		runWithPlanetaryContext(func(ctx PlanetaryContext) {
-			defer catcher.Catch(catcher.RecvWrite(logger, true))
-			log.Println("Node ID:", ctx.NodeID())
-			log.Println("Session ID:", ctx.SessionID())
-			if len(*clusterName) == 0 {
-				*clusterName = ctx.SessionID()
+		if err := n.Start(); err != nil {
+			log.Errorln(err)
+			closer.Fatalln(err)
+		}
+		writePrivateAPIFile(n.PrivateAddr())
+		go rebindOnHangup(n)
+		service.Ready()
+		closer.Hold()
+	}
+	if err := app.Run(os.Args); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// initEnvironment selects MainNet or TestNet and initializes the authority accordingly.
+func initEnvironment() {
+	if err := proxy.Configure(*outboundProxy, toList(*outboundNoProxy)); err != nil {
+		log.Fatalln(err)
+	} else if len(*outboundProxy) > 0 {
+		log.Println("outbound proxy:", proxy.Describe())
+	}
+	authcenter.UseDoHResolvers(toList(*authDoH))
+	var hasTestnetMark bool
+	if info, err := os.Stat(filepath.Join(*fsDir, "testnet")); err == nil && !info.IsDir() {
+		hasTestnetMark = true
+	}
+	if hasTestnetMark {
+		*envTestnet = true
+	}
+	if *envTestnet {
+		if !hasTestnetMark {
+			log.Fatalln("refusing to start in a testnet mode: not initialized for testnet.")
+		}
+		if *envTestnetKey != testKey {
+			log.Warningln("overriding testnet key works only upon initialization, no effect now.")
+		}
+		domains := append(*envTestnetDomains, authcenter.DefaultTestDomains...)
+		initAuthority(domains)
+		log.Println("ATLANT TestNet welcomes you!")
+	} else {
+		if len(*envTestnetDomains) > 0 {
+			log.Warningln("overriding DNS auth domains works only within testnet, no effect now.")
+		}
+		if *envTestnetKey != testKey {
+			log.Warningln("overriding testnet key works only within testnet, no effect now.")
+		}
+		initAuthority(authcenter.DefaultMainDomains)
+		log.Println("ATLANT MainNet welcomes you!")
+	}
+}
+
+// initAuthority combines sources of permissions set by --auth-providers, the DNS source
+// resolves the given domains.
+func initAuthority(domains []string) {
+	refresh := duration(*authRefresh, time.Minute)
+	var signers []string
+	for _, addr := range toList(*authAllowlistSigners) {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			signers = append(signers, addr)
+		}
+	}
+	var dns authcenter.Auth
+	var providers []authcenter.Provider
+	for _, spec := range toList(*authProviders) {
+		spec = strings.TrimSpace(spec)
+		switch {
+		case len(spec) == 0:
+			continue
+		case spec == "dns":
+			if dns == nil {
+				dns = authcenter.NewDNSAuth(domains, refresh)
 			}
-			store, err := rs.NewPlanetaryRecordStore(ctx.NodeID(), ctx.FileStore(), ctx.StateStore())
+		case strings.HasPrefix(spec, "file:"):
+			p, err := authcenter.NewFileProvider(strings.TrimPrefix(spec, "file:"), signers)
 			if err != nil {
 				log.Fatalln(err)
 			}
-
-			closer.Bind(func() {
-				log.Debugln("closing record store")
-				if err := store.Close(); err != nil {
-					log.Warningln(err)
-				}
-				log.Debugln("waiting for queues")
-				wg := new(sync.WaitGroup)
-				wg.Add(2)
-				go func() {
-					defer wg.Done()
-					store.WaitInbound(2 * time.Minute)
-				}()
-				go func() {
-					defer wg.Done()
-					store.WaitOutbound(2 * time.Minute)
-				}()
-				wg.Wait()
-			})
-
-			*ethAddress = strings.ToLower(*ethAddress)
-			mgr := contracts.NewManager(ctx.SessionID(), store, *envTestnet)
-			apiCtx := api.NewContext(ctx, store, mgr, *ethAddress, *logDir)
-			privateServer := api.NewPrivateServer()
-			privateServer.RouteAPI(apiCtx)
-			privAddr, err := privateServer.Listen("127.0.0.1:0")
-			if err != nil {
-				log.Fatalln(err)
+			providers = append(providers, p)
+		case strings.HasPrefix(spec, "contract:"):
+			addr := strings.TrimPrefix(spec, "contract:")
+			if !strings.HasPrefix(addr, "0x") || len(addr) != 42 {
+				log.Fatalf("invalid auth registry address %s", addr)
 			}
-			host, port, _ := net.SplitHostPort(privAddr)
-			privMultiAddr := fmt.Sprintf("/ip4/%s/tcp/%s", host, port)
-			if err := ctx.FileStore().Listener().Listen(privMultiAddr); err != nil {
-				log.Fatalln(err)
+			backend := contracts.NewPoolBackend("authority", *envTestnet)
+			if len(*ethRPC) > 0 {
+				backend = contracts.NewRPCBackend(*ethRPC, *envTestnet)
 			}
+			// the registry doesn't read contract configs of records, so there is no store
+			providers = append(providers, contracts.NewManager(nil, backend).AuthRegistry(addr))
+		default:
+			log.Fatalf("unknown auth provider %s, expected dns, file:<path> or contract:<address>", spec)
+		}
+	}
+	if len(providers) == 0 {
+		if dns == nil {
+			log.Fatalln("no auth providers configured")
+		}
+		authcenter.Init(dns)
+		return
+	}
+	authcenter.Init(authcenter.NewAuth(dns, providers, refresh))
+}
 
-			time.Sleep(duration(*fsWarmupDur, 5*time.Second))
-			if err := store.Sync(); err != nil {
-				log.Errorln(err)
-				closer.Fatalln(err)
-			}
-			if len(*ethAddress) > 0 && len(*ethAddress) < 64 {
-				go store.SendBeats(ctx, 10*time.Minute, 60*time.Minute, *ethAddress)
-			}
-			if authcenter.Default.HasPermissions(ctx.NodeID(), authcenter.RecordWritePermission) {
-				log.Infoln("this node has interplanetary write permissions")
-				go store.CommitBeatReports(ctx, 60*time.Minute)
-			}
+// nodeConfig returns settings of the node set by flags, malformed ones are fatal.
+func nodeConfig() *node.Config {
+	var joinToken *rs.JoinToken
+	var swarmKey string
+	if *envTestnet {
+		if *envTestnetKey == testKey {
+			*fsBootstrapPeers = append(*fsBootstrapPeers, testBootstrapPeers...)
+		}
+		joinToken = readJoinToken()
+		if joinToken != nil {
+			*fsBootstrapPeers = append(*fsBootstrapPeers, joinToken.Bootstrap...)
+		}
+		swarmKey = readSwarmKey()
+	} else {
+		*fsBootstrapPeers = append(*fsBootstrapPeers, mainBootstrapPeers...)
+	}
+	cfg := &node.Config{
+		Testnet: *envTestnet,
+		Version: appVersion,
 
-			publicServer := api.NewPublicServer()
-			publicServer.RouteAPI(apiCtx)
-			go func() {
-				if err := publicServer.ListenAndServe(*webListenAddr); err != nil {
-					log.Fatalln(err)
-				}
-			}()
+		StateDir:                *stateDir,
+		StateBackend:            *stateBackend,
+		StateMaxValueSize:       toNatural(*stateMaxValueSize, 32*1024*1024),
+		StateConflictRetries:    toNatural(*stateConflictRetries, 5),
+		StatePessimisticBuckets: toList(*statePessimisticBuckets),
+		StateRecover:            toBool(*stateRecover),
+		StateGCInterval:         duration(*stateGCInterval, 10*time.Minute),
+		StateSpareDir:           *stateSpareDir,
+		StateSpareInterval:      duration(*stateSpareInterval, 15*time.Minute),
+		StateChecksums:          toBool(*stateChecksums),
+		StateEncryptBuckets:     trimList(*stateEncryptBuckets),
 
-			closer.Hold()
-		})
+		FSDir:          *fsDir,
+		FSListenAddr:   *fsListenAddr,
+		BootstrapPeers: *fsBootstrapPeers,
+		RelayEnabled:   toBool(*fsRelayEnabled),
+		AutoMigrate:    toBool(*fsAutoMigrate),
+		NetworkProfile: *fsNetworkProfile,
+		Warmup:         duration(*fsWarmupDur, 5*time.Second),
+
+		Blocklist:            *fsBlocklist,
+		BlocklistRefresh:     duration(*fsBlocklistRefresh, time.Hour),
+		MmapCacheSize:        int64(toNatural(*fsMmapCacheSize, 0)),
+		MmapMinObjectSize:    int64(toNatural(*fsMmapMinObjectSize, 4*1024*1024)),
+		MmapHotReads:         toNatural(*fsMmapHotReads, 3),
+		InlineMaxSize:        int64(toNatural(*fsInlineMaxSize, 4*1024)),
+		BitswapMaxDebt:       int64(toNatural(*fsBitswapMaxDebt, 0)),
+		BitswapDebtRatio:     toFloat(*fsBitswapDebtRatio, 10),
+		BitswapDebtCooldown:  duration(*fsBitswapDebtCooldown, 10*time.Minute),
+		BitswapDebtExempt:    toList(*fsBitswapDebtExempt),
+		PeerExchangeInterval: duration(*fsPeerExchangeInterval, 10*time.Minute),
+		PeerExchangeTTL:      duration(*fsPeerExchangeTTL, 7*24*time.Hour),
+
+		Namespaces:        *fsNamespaces,
+		ReadOnly:          toBool(*readOnly),
+		DryRun:            toBool(*dryRun),
+		DedupWindow:       duration(*dedupWindow, 15*time.Minute),
+		NTPServer:         *ntpServer,
+		ClockSkewWarn:     duration(*clockSkewWarn, 2*time.Second),
+		ClockSkewMax:      duration(*clockSkewMax, 30*time.Second),
+		ClockRefuseWrites: toBool(*clockRefuseWrites),
+		Vouch: &rs.VouchPolicy{
+			Threshold: toNatural(*vouchThreshold, 0),
+			Namespace: *vouchNamespace,
+			Duration:  duration(*vouchProbation, 30*24*time.Hour),
+		},
+		LifecycleTTL: duration(*lifecycleTTL, 7*24*time.Hour),
+		FullResync:   toBool(*fullResync),
+		SyncMargin:   duration(*syncMargin, time.Hour),
+
+		InboundWorkers:  toNatural(*inboundWorkers, 8),
+		WebhookAttempts: toNatural(*webhookAttempts, 8),
+		WebhookDeadTTL:  duration(*webhookDeadTTL, 7*24*time.Hour),
+
+		ManifestInterval:    duration(*manifestInterval, 6*time.Hour),
+		RetentionInterval:   duration(*retentionInterval, time.Hour),
+		SearchInterval:      duration(*searchInterval, 6*time.Hour),
+		ShardRepairInterval: duration(*shardRepairInterval, time.Hour),
+		RepinInterval:       duration(*repinInterval, 6*time.Hour),
+		MirrorInterval:      duration(*mirrorInterval, 6*time.Hour),
+		ReindexRate:         toNatural(*reindexRate, 200),
+		GCInterval:          duration(*gcInterval, 24*time.Hour),
+		AntiEntropy: &rs.AntiEntropyPolicy{
+			Interval: duration(*antiEntropyInterval, 10*time.Minute),
+			Rate:     toNatural(*antiEntropyRate, 1<<20),
+			MaxBytes: int64(toNatural(*antiEntropyMaxBytes, 64<<20)),
+		},
+		PopularitySample: toFloat(*popularitySample, 0.1),
+		Beats: &rs.BeatPolicy{
+			TickInterval:    duration(*beatInterval, 10*time.Minute),
+			InfoInterval:    duration(*beatInfoInterval, time.Hour),
+			Adaptive:        toBool(*beatAdaptive),
+			MinTickInterval: duration(*beatMinInterval, time.Minute),
+			MaxTickInterval: duration(*beatMaxInterval, time.Hour),
+		},
+		BeatReportInterval: duration(*beatReportInterval, time.Hour),
+		ColdTier: &rs.ColdTierPolicy{
+			After:       duration(*coldTierAfter, 30*24*time.Hour),
+			Interval:    duration(*coldTierInterval, time.Hour),
+			MaxOffloads: toNatural(*coldTierMaxOffloads, 1000),
+		},
+
+		EthAddress: *ethAddress,
+		EthRPC:     *ethRPC,
+
+		WebListenAddrs:    webListenAddrs(),
+		MetricsListenAddr: *metricsListenAddr,
+		GRPCListenAddr:    *grpcListenAddr,
+		PrivateListenAddr: "127.0.0.1:0",
+		PeerListenAddr:    "127.0.0.1:0",
+		TLS:               publicTLSConfig(),
+		LogDir:            *logDir,
+		UploadDir:         *uploadDir,
+		PrivateTokenFile:  privateTokenPath(),
+		BootstrapToken:    *bootstrapToken,
+		SwarmKey:          swarmKey,
+		JoinToken:         joinToken,
+		ClientHeader:      *apiClientHeader,
+		SRI:               toBool(*sri),
+		NetworkStats:      toBool(*networkStats),
+		PresignedURLs:     toBool(*presignedURLs),
+		ReadyMinPeers:     toNatural(*readyMinPeers, 1),
+		IdempotencyTTL:    duration(*idempotencyTTL, 24*time.Hour),
+		DrainTimeout:      duration(*drainTimeout, 2*time.Minute),
+		Plugins:           plugins.APIPlugins(),
 	}
-	if err := app.Run(os.Args); err != nil {
+	if len(*fsSwarmProxy) > 0 {
+		proxyURL, err := fs.ParseProxyURL(*fsSwarmProxy)
+		if err != nil {
+			log.Fatalf("invalid swarm proxy: %v", err)
+		}
+		cfg.SwarmProxy = &fs.ProxyConfig{
+			URL:        proxyURL,
+			NoClearnet: toBool(*fsSwarmNoClearnet),
+			Announce:   *fsSwarmAnnounce,
+		}
+	} else if toBool(*fsSwarmNoClearnet) {
+		log.Fatalln("--swarm-no-clearnet requires --swarm-proxy, the node would dial peers directly")
+	}
+	cfg.SwarmIPv6 = toBool(*fsSwarmIPv6)
+	cfg.NAT = &fs.NATConfig{
+		PortMap:         toBool(*fsSwarmPortMap),
+		RelayHop:        toBool(*fsSwarmRelayHop),
+		AutoNAT:         toBool(*fsSwarmAutoNAT),
+		AutoNATInterval: duration(*fsSwarmAutoNATInterval, 15*time.Minute),
+		RelayPeers:      toList(*fsSwarmRelayPeers),
+		Announce:        *fsSwarmAnnounce,
+	}
+	if len(cfg.NAT.RelayPeers) > 0 && !cfg.RelayEnabled {
+		log.Fatalln("--swarm-relay-peers requires --relay-enabled")
+	}
+	bucketTTLs, err := state.ParseBucketTTLs(*gcBucketTTLs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	cfg.GC = &rs.GCPolicy{
+		MaxDiskUsage: uint64(toNatural(*gcMaxDiskUsage, 0)),
+		MaxRecordAge: duration(*gcMaxRecordAge, 0),
+		BucketTTLs:   bucketTTLs,
+	}
+	cfg.DiskBudget = &rs.DiskBudget{
+		MaxUsage: uint64(toNatural(*maxDiskUsage, 0)),
+	}
+	for _, v := range toList(*diskUsageWarnings) {
+		if percent := toFloat(v, 0); percent > 0 {
+			cfg.DiskBudget.Warnings = append(cfg.DiskBudget.Warnings, percent/100)
+		}
+	}
+	encrypted, confidential := toList(*encryptedNamespaces), toList(*confidentialNamespaces)
+	if len(encrypted) > 0 || len(confidential) > 0 {
+		cfg.Encryption = &rs.EncryptionPolicy{
+			Namespaces:   encrypted,
+			Confidential: confidential,
+		}
+	}
+	if !rs.ValidConflictStrategy(*conflictPolicy) {
+		log.Fatalf("unknown conflict policy %s", *conflictPolicy)
+	}
+	cfg.Conflicts = &rs.ConflictPolicy{
+		Strategy: *conflictPolicy,
+	}
+	if len(*otlpEndpoint) > 0 {
+		if _, err := tracing.ParseEndpoint(*otlpEndpoint); err != nil {
+			log.Fatalln(err)
+		}
+		cfg.Tracing = &tracing.Config{
+			Endpoint:    *otlpEndpoint,
+			SampleRatio: toFloat(*traceSample, tracing.DefaultSampleRatio),
+		}
+	}
+	lookupTTLs, err := cache.ParseTTLs(*cacheTTLs)
+	if err != nil {
 		log.Fatalln(err)
 	}
+	cfg.Cache = &cache.Config{
+		Size: toNatural(*cacheSize, cache.DefaultSize),
+		TTLs: lookupTTLs,
+	}
+	if toBool(*searchIndex) {
+		cfg.Search = &rs.SearchPolicy{
+			ContentMax: int64(toNatural(*searchContentMax, 64*1024)),
+		}
+	}
+	routeTimeouts, err := api.ParseRouteTimeouts(*apiRouteTimeouts)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	cfg.RouteTimeouts = &api.RouteTimeouts{
+		Default: duration(*apiTimeout, 0),
+		Routes:  routeTimeouts,
+	}
+	if cfg.ProxyRoutes, err = api.ParseProxyRoutes(*apiProxyRoutes); err != nil {
+		log.Fatalln(err)
+	}
+	if key := readSwarmKey(); len(key) > 0 {
+		cfg.SwarmKeyInfo = &api.SwarmKeyInfo{
+			Fingerprint: fs.SwarmKeyFingerprint(key),
+			Public:      publicSwarmKey(key),
+		}
+	}
+	if origins := trimList(*corsOrigins); len(origins) > 0 {
+		cfg.CORS = &api.CORSConfig{
+			Origins:     origins,
+			Methods:     trimList(*corsMethods),
+			Headers:     trimList(*corsHeaders),
+			Credentials: toBool(*corsCredentials),
+			MaxAge:      duration(*corsMaxAge, 10*time.Minute),
+		}
+	}
+	throttles := &api.ThrottleConfig{
+		Rate:       toFloat(*apiRate, 0),
+		Burst:      toNatural(*apiBurst, 0),
+		MaxStreams: toNatural(*apiMaxStreams, 0),
+		MaxBody:    int64(toNatural(*apiMaxBody, 0)),
+
+		MaxTokenStreams:       toNatural(*apiMaxTokenStreams, 0),
+		MaxSubscriptions:      toNatural(*apiMaxSubscriptions, 0),
+		MaxTokenSubscriptions: toNatural(*apiMaxTokenSubscriptions, 0),
+	}
+	if len(*apiWriteQuota) > 0 {
+		if throttles.WriteQuota, throttles.QuotaWindow = toQuota(*apiWriteQuota); throttles.WriteQuota == 0 {
+			log.Fatalf("malformed write quota: %s", *apiWriteQuota)
+		}
+	}
+	if throttles.Rate > 0 || throttles.MaxBody > 0 || throttles.WriteQuota > 0 || throttles.CapsConns() {
+		cfg.Throttles = throttles
+	}
+	loadShed := &api.LoadShedConfig{
+		MaxQueueDepth: int64(toNatural(*lbMaxQueueDepth, 0)),
+		MaxLatency:    duration(*lbMaxLatency, 0),
+		MaxMemory:     uint64(toNatural(*lbMaxMemory, 0)),
+		Recover:       toFloat(*lbRecover, 0.8),
+		Hold:          duration(*lbRecoverHold, 30*time.Second),
+	}
+	if loadShed.MaxQueueDepth > 0 || loadShed.MaxLatency > 0 || loadShed.MaxMemory > 0 {
+		cfg.LoadShed = loadShed
+	}
+	if len(*shadowTarget) > 0 {
+		target, err := url.Parse(*shadowTarget)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+			log.Fatalf("shadow target must be an http(s) URL: %s", *shadowTarget)
+		}
+		fraction := toFloat(*shadowFraction, 0.01)
+		if fraction > 1 {
+			fraction = 1
+		}
+		cfg.Shadow = &api.ShadowConfig{
+			Target:   target,
+			Fraction: fraction,
+			Timeout:  duration(*shadowTimeout, 30*time.Second),
+		}
+	}
+	if len(*ingestNodes) > 0 {
+		for _, nodeID := range toList(*ingestNodes) {
+			if nodeID = strings.TrimSpace(nodeID); len(nodeID) > 0 {
+				cfg.IngestNodes = append(cfg.IngestNodes, nodeID)
+			}
+		}
+	}
+	gating := &api.GatingConfig{
+		MinBalance: toFloat(*writeMinBalance, 0),
+		RequireKYC: toBool(*writeRequireKYC),
+	}
+	if ttls := toList(*writeGateTTL); len(ttls) > 0 {
+		gating.TTL = duration(strings.TrimSpace(ttls[0]), 10*time.Minute)
+		gating.DenyTTL = gating.TTL
+		if len(ttls) > 1 {
+			gating.DenyTTL = duration(strings.TrimSpace(ttls[1]), time.Minute)
+		}
+	}
+	if gating.Enabled() {
+		cfg.Gating = gating
+	}
+	mirror := &rs.MirrorPolicy{
+		Prefixes:   toList(*mirrorPrefixes),
+		Namespaces: toList(*mirrorNamespaces),
+	}
+	if mirror.Enabled() {
+		cfg.Mirror = mirror
+	}
+	if len(*coldTierBucket) > 0 {
+		cfg.ColdStore = &fs.S3Config{
+			Endpoint:  *coldTierEndpoint,
+			Region:    *coldTierRegion,
+			Bucket:    *coldTierBucket,
+			Prefix:    *coldTierPrefix,
+			AccessKey: *coldTierAccessKey,
+			SecretKey: *coldTierSecretKey,
+		}
+	}
+	if len(*policyFile) > 0 {
+		policy, err := api.LoadPolicy(*policyFile)
+		if err != nil {
+			log.Fatalf("failed to load policy: %v", err)
+		}
+		cfg.Policy = policy
+	}
+	return cfg
 }
 
-func runWithPlanetaryContext(fn func(ctx PlanetaryContext)) {
+// runWithPlanetaryContext opens the stores of the node for commands that don't serve APIs.
+func runWithPlanetaryContext(fn func(ctx node.Context)) {
 	defer closer.Close()
 	closer.Bind(func() {
 		log.Println("atlant-go node is shut down. Bye!")
 	})
 	log.Println("atlant-go node is starting")
 
-	fsHost, fsPort, err := net.SplitHostPort(*fsListenAddr)
-	if err != nil {
-		log.Warningf("failed to parse specified listen addr %s: %v", *fsListenAddr, err)
-	}
-	log.Debugf("using %s as state dir", *stateDir)
-	if err := os.MkdirAll(*stateDir, 0700); err != nil {
-		log.Fatalln("failed to create state dir:", err)
-	}
-	log.Debugf("using %s as fs dir", *fsDir)
-	if err := os.MkdirAll(*fsDir, 0700); err != nil {
-		log.Fatalln("failed to create fs dir:", err)
-	}
-	if !fileNotEmpty(filepath.Join(*fsDir, ipfsKeyFile)) ||
-		!fileNotEmpty(filepath.Join(*fsDir, ipfsConfigFile)) {
-		log.Fatalln("fs dir is not initialized, please run atlant-go init")
-	}
-	log.WithFields(log.Fields{
-		"Dir":     *fsDir,
-		"Host":    fsHost,
-		"Port":    fsPort,
-		"Profile": *fsNetworkProfile,
-	}).Println("IPFS node warmup in progress")
-	if *envTestnet {
-		if *envTestnetKey == testKey {
-			*fsBootstrapPeers = append(*fsBootstrapPeers, testBootstrapPeers...)
-		}
-	} else {
-		*fsBootstrapPeers = append(*fsBootstrapPeers, mainBootstrapPeers...)
-	}
-	fileStore, err := fs.NewPlanetaryFileStore(*fsDir,
-		fs.UseBootstrapPeersOpt(*fsBootstrapPeers),
-		fs.UseRelayOpt(toBool(*fsRelayEnabled)),
-		fs.ListenHostOpt(fsHost),
-		fs.ListenPortOpt(fsPort),
-		fs.UseNetworkProfileOpt(fs.NetworkProfile(*fsNetworkProfile)),
-	)
+	cfg := nodeConfig()
+	// the state store is opened first, it keeps peers of the peer exchange and is closed
+	// after the IPFS node
+	stateStore, err := node.OpenStateStore(cfg)
 	if err != nil {
-		closer.Fatalln("NewPlanetaryFileStore failed:", err)
+		closer.Fatalln(err)
 	}
 	closer.Bind(func() {
-		if err := fileStore.Close(); err != nil {
-			log.Warningf("failed to close IPFS store: %v", err)
+		if err := stateStore.Close(); err != nil {
+			log.Warningf("failed to close the state store: %v", err)
 		}
 	})
-	stateStore, err := state.NewIndexedStoreBadger(*stateDir)
+	fileStore, err := node.OpenFileStore(cfg, stateStore)
 	if err != nil {
-		closer.Fatalln("NewIndexedStoreBadger failed:", err)
+		closer.Fatalln(err)
 	}
 	closer.Bind(func() {
-		if err := stateStore.Close(); err != nil {
-			log.Warningf("failed to close the state store: %v", err)
+		if err := fileStore.Close(); err != nil {
+			log.Warningf("failed to close IPFS store: %v", err)
 		}
 	})
 	if err := func() (err error) {
@@ -256,7 +653,7 @@ func runWithPlanetaryContext(fn func(ctx PlanetaryContext)) {
 		if *envTestnet {
 			env = "test"
 		}
-		ctx := NewPlanetaryContext(context.Background(), env, appVersion, fileStore, stateStore)
+		ctx := node.NewContext(context.Background(), env, appVersion, fileStore, stateStore)
 		fn(ctx)
 		return
 	}(); err != nil {
@@ -264,9 +661,43 @@ func runWithPlanetaryContext(fn func(ctx PlanetaryContext)) {
 	}
 }
 
+// VersionInfo describes the build of the binary.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	// Protocol is the gossip protocol version the node speaks, records it produces
+	// are readable by nodes of RecordFormat and newer.
+	Protocol     uint16 `json:"protocol_version"`
+	RecordFormat uint16 `json:"record_format_version"`
+}
+
 func versionCmd(c *cli.Cmd) {
+	asJSON := c.Bool(cli.BoolOpt{
+		Name:  "json",
+		Desc:  "Print version info as JSON.",
+		Value: false,
+	})
 	c.Action = func() {
-		fmt.Fprintf(os.Stdout, "atlant-go version %s\n", appVersion)
+		info := &VersionInfo{
+			Version:      appVersion,
+			GitCommit:    gitCommit,
+			BuildDate:    buildDate,
+			GoVersion:    runtime.Version(),
+			Protocol:     rs.ProtocolVersion,
+			RecordFormat: rs.RecordFormatVersion,
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(info)
+			return
+		}
+		fmt.Fprintf(os.Stdout, "atlant-go version %s\n", info.Version)
+		fmt.Fprintf(os.Stdout, "commit %s, built %s with %s\n", info.GitCommit, info.BuildDate, info.GoVersion)
+		fmt.Fprintf(os.Stdout, "protocol version %d, records readable by protocol %d and newer\n",
+			info.Protocol, info.RecordFormat)
 		fmt.Fprintln(os.Stderr, `atlant-go Copyright (C) 2018 ATLANT
     This program comes with ABSOLUTELY NO WARRANTY; for details see LICENSE.
     This is free software, and you are welcome to redistribute it
@@ -274,9 +705,79 @@ func versionCmd(c *cli.Cmd) {
 	}
 }
 
+func bootstrapFromCmd(c *cli.Cmd) {
+	c.Spec = "[--token] PEER"
+	peerID := c.StringArg("PEER", "", "Node ID of a trusted peer that serves the snapshot.")
+	token := c.String(cli.StringOpt{
+		Name:      "t token",
+		Desc:      "Bootstrap token configured on the peer.",
+		EnvVar:    "AN_BOOTSTRAP_TOKEN",
+		HideValue: true,
+	})
+	c.Action = func() {
+		initEnvironment()
+		runWithPlanetaryContext(func(ctx node.Context) {
+			log.Println("Node ID:", ctx.NodeID())
+			store, err := rs.NewPlanetaryRecordStore(ctx.NodeID(), ctx.FileStore(), ctx.StateStore())
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer store.Close()
+
+			time.Sleep(duration(*fsWarmupDur, 5*time.Second))
+			log.WithField("peer", *peerID).Println("bootstrapping from the peer snapshot")
+			bootCtx, cancelFn := context.WithTimeout(ctx, 6*time.Hour)
+			defer cancelFn()
+			if err := store.BootstrapFrom(bootCtx, *peerID, *token); err != nil {
+				closer.Fatalln(err)
+			}
+			log.Println("bootstrap done, the node can be started now")
+		})
+	}
+}
+
 func nodeInitCmd(c *cli.Cmd) {
+	joinTokenOpt := c.String(cli.StringOpt{
+		Name:      "join-token",
+		Desc:      "Join the testnet with a token minted by a node of it, sets the swarm key and bootstrap peers.",
+		EnvVar:    "AN_JOIN_TOKEN",
+		HideValue: true,
+	})
+	strict := c.Bool(cli.BoolOpt{
+		Name:   "strict",
+		Desc:   "Refuse the published testnet key, a testnet must have a key of its own.",
+		EnvVar: "AN_INIT_STRICT",
+		Value:  false,
+	})
+	generateKey := c.Bool(cli.BoolOpt{
+		Name:  "generate-key",
+		Desc:  "Generate a new random testnet key, pass it to other nodes of the testnet with --testnet-key.",
+		Value: false,
+	})
 	c.Action = func() {
 		log.Println("atlant-go init")
+		var joinToken *rs.JoinToken
+		if len(*joinTokenOpt) > 0 {
+			token, err := rs.ParseJoinToken(*joinTokenOpt)
+			if err != nil {
+				log.Fatalln(err)
+			} else if time.Now().After(token.ExpiresAt) {
+				log.Fatalln("the join token has expired, ask its issuer for a new one")
+			}
+			joinToken = token
+			*envTestnet = true
+		}
+		if *generateKey {
+			if joinToken != nil {
+				log.Fatalln("--generate-key can't be used with a join token, the token brings the key of the testnet")
+			}
+			key, err := fs.NewSwarmKey()
+			if err != nil {
+				log.Fatalln("failed to generate testnet key:", err)
+			}
+			*envTestnetKey = key
+			*envTestnet = true
+		}
 
 		log.Debugf("using %s as state dir", *stateDir)
 		if err := os.MkdirAll(*stateDir, 0700); err != nil {
@@ -286,6 +787,10 @@ func nodeInitCmd(c *cli.Cmd) {
 		if err := os.MkdirAll(*fsDir, 0700); err != nil {
 			log.Fatalln("failed to create fs dir:", err)
 		}
+		// an existing token is kept, use private-token --rotate to replace it
+		if _, err := api.LoadPrivateToken(privateTokenPath()); err != nil {
+			log.Fatalln("failed to create private API token:", err)
+		}
 		var skipInit bool
 		configPath := filepath.Join(*fsDir, ipfsConfigFile)
 		if fileNotEmpty(configPath) {
@@ -305,6 +810,16 @@ func nodeInitCmd(c *cli.Cmd) {
 				"File": keyPath,
 			}).Warnln("overwriting IPFS swarm key file")
 		}
+		swarmKey := mainKey
+		if *envTestnet {
+			swarmKey = *envTestnetKey
+			if joinToken != nil {
+				swarmKey = joinToken.SwarmKey
+			}
+			if err := checkSwarmKey(swarmKey, *strict); err != nil {
+				log.Fatalln(err)
+			}
+		}
 		ipfsKeyData := []byte(ipfsKeyDataPrefix + mainKey)
 		if *envTestnet {
 			log.Println("initilizing within ATLANT Node TestNet")
@@ -313,6 +828,17 @@ func nodeInitCmd(c *cli.Cmd) {
 			if err != nil {
 				log.Fatalf("failed to create a testnet mark file: %v", err)
 			}
+			if joinToken != nil {
+				ipfsKeyData = []byte(ipfsKeyDataPrefix + joinToken.SwarmKey)
+				tokenPath := filepath.Join(*fsDir, joinTokenFile)
+				if err := ioutil.WriteFile(tokenPath, []byte(joinToken.Encode()), 0600); err != nil {
+					log.Fatalf("failed to write the join token: %v", err)
+				}
+				log.WithFields(log.Fields{
+					"Issuer":    joinToken.Issuer,
+					"Bootstrap": len(joinToken.Bootstrap),
+				}).Println("joining with a token, the issuer vouches for the node once it starts")
+			}
 		} else {
 			log.Println("initilizing within ATLANT Node MainNet")
 		}
@@ -322,9 +848,14 @@ func nodeInitCmd(c *cli.Cmd) {
 			}).Fatalln("failed to write private key for IPFS swarm:", err)
 		} else {
 			log.WithFields(log.Fields{
-				"File": keyPath,
+				"File":        keyPath,
+				"Fingerprint": fs.SwarmKeyFingerprint(swarmKey),
 			}).Println("generated new private key for IPFS swarm")
 		}
+		if *generateKey {
+			log.Println("initialize other nodes of the testnet with the generated key:")
+			fmt.Fprintf(os.Stderr, "export AN_TESTNET_KEY=%s\n", swarmKey)
+		}
 		log.WithFields(log.Fields{
 			"Dir":      *fsDir,
 			"SwarmKey": keyPath,
@@ -340,6 +871,58 @@ func nodeInitCmd(c *cli.Cmd) {
 	}
 }
 
+// readJoinToken returns the token the node was initialized with, nil if there is none.
+func readJoinToken() *rs.JoinToken {
+	data, err := ioutil.ReadFile(filepath.Join(*fsDir, joinTokenFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		log.Warningf("failed to read the join token: %v", err)
+		return nil
+	}
+	token, err := rs.ParseJoinToken(string(data))
+	if err != nil {
+		log.Warningf("failed to read the join token: %v", err)
+		return nil
+	}
+	return token
+}
+
+// readSwarmKey returns the hex key of the IPFS swarm key file.
+func readSwarmKey() string {
+	data, err := ioutil.ReadFile(filepath.Join(*fsDir, ipfsKeyFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(string(data), ipfsKeyDataPrefix))
+}
+
+// publicSwarmKey names the key if it's one of the keys published with the node, empty otherwise.
+func publicSwarmKey(key string) string {
+	switch strings.ToLower(strings.TrimSpace(key)) {
+	case mainKey:
+		return "mainnet"
+	case testKey:
+		return "public testnet"
+	}
+	return ""
+}
+
+// checkSwarmKey validates a testnet key, the published testnet key is refused in strict mode.
+func checkSwarmKey(key string, strict bool) error {
+	switch publicSwarmKey(key) {
+	case "mainnet":
+		return errors.New("testnet can't use the mainnet swarm key")
+	case "public testnet":
+		if strict {
+			return errors.New("refusing the published testnet key in strict mode, use --generate-key or --testnet-key")
+		}
+		log.Warningln("using the published testnet key, anyone may join the testnet, see init --generate-key")
+		return nil
+	}
+	return fs.ValidateSwarmKey(key)
+}
+
 func fileNotEmpty(path string) bool {
 	info, err := os.Stat(path)
 	if os.IsNotExist(err) {
@@ -357,3 +940,52 @@ func fileNotEmpty(path string) bool {
 	}
 	return false
 }
+
+// webListenAddrs returns listen addresses of the public API, see api.ParseListenAddr.
+func webListenAddrs() []string {
+	var addrs []string
+	for _, addr := range toList(*webListenAddr) {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// rebindOnHangup re-creates Unix sockets of the public API and retries addresses that
+// failed to bind on SIGHUP, see Node.Rebind.
+func rebindOnHangup(n *node.Node) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		log.Infoln("rebinding the public API on SIGHUP")
+		if err := n.Rebind(); err != nil {
+			log.Warningln(err)
+		}
+	}
+}
+
+// publicTLSConfig returns TLS settings of the public API, or nil to serve plain HTTP.
+func publicTLSConfig() *api.TLSConfig {
+	var domains []string
+	if len(*tlsACMEDomains) > 0 {
+		domains = toList(*tlsACMEDomains)
+	}
+	if len(*tlsCertFile) == 0 && len(domains) == 0 {
+		return nil
+	}
+	cacheDir := *tlsACMECacheDir
+	if len(cacheDir) == 0 {
+		cacheDir = filepath.Join(*stateDir, "acme")
+	}
+	return &api.TLSConfig{
+		CertFile:     *tlsCertFile,
+		KeyFile:      *tlsKeyFile,
+		ACMEDomains:  domains,
+		ACMEEmail:    *tlsACMEEmail,
+		ACMECacheDir: cacheDir,
+		ACMEHTTPAddr: *tlsACMEHTTPAddr,
+		ClientAuth:   api.ClientAuthMode(*tlsClientAuth),
+		ClientCAFile: *tlsClientCAFile,
+	}
+}
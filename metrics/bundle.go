@@ -0,0 +1,396 @@
+package metrics
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Alert is a Prometheus alerting rule.
+type Alert struct {
+	Name        string
+	Expr        string
+	For         time.Duration
+	Severity    string
+	Summary     string
+	Description string
+}
+
+// Alerts are curated rules for the metrics of the catalog.
+var Alerts = []*Alert{
+	{
+		Name:     "AtlantNodeDown",
+		Expr:     `up{job="atlant"} == 0`,
+		For:      5 * time.Minute,
+		Severity: "critical",
+		Summary:  "ATLANT Node {{ $labels.instance }} is down",
+	},
+	{
+		Name:        "AtlantClockSkew",
+		Expr:        fmt.Sprintf(`abs(%s) > 2`, NodeClockSkew),
+		For:         15 * time.Minute,
+		Severity:    "warning",
+		Summary:     "Clock of {{ $labels.instance }} is off by {{ $value }}s",
+		Description: "Record ordering and TTLs break with bad clocks, check time synchronization of the host.",
+	},
+	{
+		Name:        "AtlantDiskAlmostFull",
+		Expr:        fmt.Sprintf(`%s / %s < 0.1`, FSDiskFree, FSDiskTotal),
+		For:         15 * time.Minute,
+		Severity:    "warning",
+		Summary:     "Less than 10% of disk space left on {{ $labels.instance }}",
+		Description: "The node stops accepting content once the disk is full, free space or extend the volume.",
+	},
+	{
+		Name:        "AtlantDiskBudgetExceeded",
+		Expr:        fmt.Sprintf(`%s >= %s and %s > 0`, RSDiskUsage, RSDiskBudget, RSDiskBudget),
+		For:         5 * time.Minute,
+		Severity:    "critical",
+		Summary:     "ATLANT Node {{ $labels.instance }} is over its disk budget",
+		Description: "Local writes and pins are refused, run GC with a lower gc-max-disk-usage or raise max-disk-usage.",
+	},
+	{
+		Name:        "AtlantRepoNearStorageMax",
+		Expr:        fmt.Sprintf(`%s / %s > 0.9 and %s > 0`, FSRepoSize, FSRepoStorageMax, FSRepoStorageMax),
+		For:         15 * time.Minute,
+		Severity:    "warning",
+		Summary:     "IPFS repo of {{ $labels.instance }} is above 90% of its storage max",
+		Description: "Consider retention policies for namespaces with long version history.",
+	},
+	{
+		Name:        "AtlantNoPeers",
+		Expr:        fmt.Sprintf(`%s == 0`, FSPeers),
+		For:         10 * time.Minute,
+		Severity:    "critical",
+		Summary:     "ATLANT Node {{ $labels.instance }} has no IPFS peers",
+		Description: "Records are neither published nor received, check the network and the blocklist.",
+	},
+	{
+		Name:        "AtlantCircuitOpen",
+		Expr:        fmt.Sprintf(`%s == 1`, RSCircuitOpen),
+		For:         5 * time.Minute,
+		Severity:    "warning",
+		Summary:     "Circuit {{ $labels.circuit }} is open on {{ $labels.instance }}",
+		Description: "Reads from the underlying store keep failing or timing out.",
+	},
+	{
+		Name:     "AtlantInboundBacklog",
+		Expr:     fmt.Sprintf(`%s > 512`, RSInboundQueueDepth),
+		For:      10 * time.Minute,
+		Severity: "warning",
+		Summary:  "Inbound announces pile up on {{ $labels.instance }}",
+	},
+	{
+		Name:     "AtlantOutboundBacklog",
+		Expr:     fmt.Sprintf(`%s > 512`, RSOutboundQueueDepth),
+		For:      10 * time.Minute,
+		Severity: "warning",
+		Summary:  "Outbound announces pile up on {{ $labels.instance }}",
+	},
+	{
+		Name:     "AtlantSyncFailing",
+		Expr:     fmt.Sprintf(`increase(%s[30m]) > 0`, RSSyncFailures),
+		Severity: "warning",
+		Summary:  "Record store sync fails on {{ $labels.instance }}",
+	},
+	{
+		Name:     "AtlantSyncSlow",
+		Expr:     fmt.Sprintf(`histogram_quantile(0.9, sum by (instance, le) (rate(%s_bucket[30m]))) > 300`, RSSyncDuration),
+		For:      30 * time.Minute,
+		Severity: "info",
+		Summary:  "Record store sync takes more than 5 minutes on {{ $labels.instance }}",
+	},
+	{
+		Name:        "AtlantWriteBlocked",
+		Expr:        fmt.Sprintf(`%s == 1`, RSProtocolWriteBlocked),
+		For:         5 * time.Minute,
+		Severity:    "critical",
+		Summary:     "ATLANT Node {{ $labels.instance }} refuses writes",
+		Description: "Protocol versions in the cluster are incompatible, see /private/v1/protocol.",
+	},
+	{
+		Name:     "AtlantWatchDrops",
+		Expr:     fmt.Sprintf(`rate(%s[10m]) > 0`, RSWatchDropped),
+		For:      10 * time.Minute,
+		Severity: "info",
+		Summary:  "Record watchers on {{ $labels.instance }} are too slow, changes are dropped",
+	},
+	{
+		Name:        "AtlantGCFailing",
+		Expr:        fmt.Sprintf(`increase(%s[2d]) > 0 and increase(%s[2d]) == increase(%s[2d])`, RSGCFailures, RSGCFailures, RSGCRuns),
+		Severity:    "warning",
+		Summary:     "Garbage collection keeps failing on {{ $labels.instance }}",
+		Description: "Unneeded content is not unpinned and the repo keeps growing, see /private/v1/gc.",
+	},
+	{
+		Name:        "AtlantSLOBreached",
+		Expr:        fmt.Sprintf(`%s == 1`, RSSLOBreached),
+		For:         5 * time.Minute,
+		Severity:    "warning",
+		Summary:     "Namespace {{ $labels.namespace }} misses its {{ $labels.sli }} objective on {{ $labels.instance }}",
+		Description: "SLIs and objectives of the namespace are reported at /api/v1/slo.",
+	},
+	{
+		Name:        "AtlantStateValuesRejected",
+		Expr:        fmt.Sprintf(`increase(%s[1h]) > 0 or increase(%s[1h]) > 0`, StateRejectedValues, StateRejectedKeys),
+		Severity:    "warning",
+		Summary:     "State writes exceeding size limits on {{ $labels.instance }}",
+		Description: "Some writes were refused, see state_stats of /api/v1/stats.",
+	},
+	{
+		Name:        "AtlantStateConflictsExhausted",
+		Expr:        fmt.Sprintf(`increase(%s[15m]) > 0`, StateConflictsExhausted),
+		Severity:    "warning",
+		Summary:     "State writes to {{ $labels.bucket }} fail with conflicts on {{ $labels.instance }}",
+		Description: "Consider more retries or the pessimistic mode for the bucket, see --state-pessimistic-buckets.",
+	},
+}
+
+// AlertRules renders the alerts as a Prometheus rules file.
+func AlertRules() []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "# Alerting rules for ATLANT Node, generated from the node's metric catalog.")
+	fmt.Fprintln(buf, "groups:")
+	fmt.Fprintln(buf, "  - name: atlant")
+	fmt.Fprintln(buf, "    rules:")
+	for _, a := range Alerts {
+		fmt.Fprintf(buf, "      - alert: %s\n", a.Name)
+		fmt.Fprintf(buf, "        expr: %s\n", yamlQuote(a.Expr))
+		if a.For > 0 {
+			fmt.Fprintf(buf, "        for: %s\n", promDuration(a.For))
+		}
+		fmt.Fprintln(buf, "        labels:")
+		fmt.Fprintf(buf, "          severity: %s\n", a.Severity)
+		fmt.Fprintln(buf, "        annotations:")
+		fmt.Fprintf(buf, "          summary: %s\n", yamlQuote(a.Summary))
+		if len(a.Description) > 0 {
+			fmt.Fprintf(buf, "          description: %s\n", yamlQuote(a.Description))
+		}
+	}
+	return buf.Bytes()
+}
+
+func yamlQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func promDuration(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	} else if d%time.Minute == 0 {
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
+
+type dashboard struct {
+	Title         string              `json:"title"`
+	UID           string              `json:"uid"`
+	Tags          []string            `json:"tags"`
+	Timezone      string              `json:"timezone"`
+	SchemaVersion int                 `json:"schemaVersion"`
+	Refresh       string              `json:"refresh"`
+	Time          map[string]string   `json:"time"`
+	Templating    map[string][]*templ `json:"templating"`
+	Panels        []*panel            `json:"panels"`
+}
+
+type templ struct {
+	Name       string            `json:"name"`
+	Label      string            `json:"label,omitempty"`
+	Type       string            `json:"type"`
+	Query      string            `json:"query"`
+	Datasource map[string]string `json:"datasource,omitempty"`
+	Multi      bool              `json:"multi,omitempty"`
+	IncludeAll bool              `json:"includeAll,omitempty"`
+	Refresh    int               `json:"refresh,omitempty"`
+}
+
+type panel struct {
+	ID          int                    `json:"id"`
+	Type        string                 `json:"type"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description,omitempty"`
+	GridPos     map[string]int         `json:"gridPos"`
+	Datasource  map[string]string      `json:"datasource,omitempty"`
+	Targets     []*target              `json:"targets,omitempty"`
+	FieldConfig map[string]interface{} `json:"fieldConfig,omitempty"`
+}
+
+type target struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+}
+
+var subsystemTitles = map[Subsystem]string{
+	SubsystemNode:        "Node",
+	SubsystemAPI:         "Public API",
+	SubsystemRecordStore: "Record Store",
+	SubsystemFileStore:   "File Store (IPFS)",
+	SubsystemStateStore:  "State Store",
+}
+
+// Dashboard renders a Grafana dashboard with a panel per metric of the catalog.
+func Dashboard() []byte {
+	datasource := map[string]string{
+		"type": "prometheus",
+		"uid":  "${datasource}",
+	}
+	d := &dashboard{
+		Title:         "ATLANT Node",
+		UID:           "atlant-node",
+		Tags:          []string{"atlant"},
+		Timezone:      "browser",
+		SchemaVersion: 36,
+		Refresh:       "30s",
+		Time: map[string]string{
+			"from": "now-6h",
+			"to":   "now",
+		},
+		Templating: map[string][]*templ{
+			"list": {{
+				Name:  "datasource",
+				Label: "Data source",
+				Type:  "datasource",
+				Query: "prometheus",
+			}, {
+				Name:       "instance",
+				Label:      "Instance",
+				Type:       "query",
+				Query:      fmt.Sprintf("label_values(%s, instance)", NodeUptime),
+				Datasource: datasource,
+				Multi:      true,
+				IncludeAll: true,
+				Refresh:    2,
+			}},
+		},
+	}
+	var id, y, col int
+	var current Subsystem
+	for _, desc := range Catalog {
+		if desc.Name == NodeInfo {
+			continue
+		}
+		if desc.Subsystem != current {
+			current = desc.Subsystem
+			if col > 0 {
+				y += 8
+				col = 0
+			}
+			id++
+			d.Panels = append(d.Panels, &panel{
+				ID:      id,
+				Type:    "row",
+				Title:   subsystemTitles[current],
+				GridPos: map[string]int{"h": 1, "w": 24, "x": 0, "y": y},
+			})
+			y++
+		}
+		id++
+		p := &panel{
+			ID:          id,
+			Type:        "timeseries",
+			Title:       desc.Name,
+			Description: desc.Help,
+			Datasource:  datasource,
+			GridPos:     map[string]int{"h": 8, "w": 12, "x": 12 * col, "y": y},
+			Targets: []*target{{
+				RefID:        "A",
+				Expr:         panelExpr(desc),
+				LegendFormat: legendFormat(desc),
+			}},
+		}
+		if len(desc.Unit) > 0 {
+			p.FieldConfig = map[string]interface{}{
+				"defaults": map[string]interface{}{
+					"unit": grafanaUnit(desc),
+				},
+				"overrides": []interface{}{},
+			}
+		}
+		d.Panels = append(d.Panels, p)
+		if col++; col == 2 {
+			y += 8
+			col = 0
+		}
+	}
+	data, _ := json.MarshalIndent(d, "", "  ")
+	return data
+}
+
+func panelExpr(desc *Desc) string {
+	selector := `{instance=~"$instance"}`
+	switch desc.Type {
+	case Counter:
+		return fmt.Sprintf("rate(%s%s[5m])", desc.Name, selector)
+	case Histogram:
+		return fmt.Sprintf("histogram_quantile(0.9, sum by (instance, le) (rate(%s_bucket%s[5m])))", desc.Name, selector)
+	default:
+		return desc.Name + selector
+	}
+}
+
+func legendFormat(desc *Desc) string {
+	legend := "{{instance}}"
+	for _, l := range desc.Labels {
+		legend += " {{" + l + "}}"
+	}
+	return legend
+}
+
+func grafanaUnit(desc *Desc) string {
+	switch {
+	case desc.Unit == "bytes" && desc.Type == Counter:
+		return "Bps"
+	case desc.Type == Counter:
+		return "ops"
+	default:
+		return desc.Unit
+	}
+}
+
+// WriteBundle writes a gzipped tarball with the alerting rules and the dashboard.
+func WriteBundle(w io.Writer, version string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"atlant-alerts.yml", AlertRules()},
+		{"atlant-dashboard.json", Dashboard()},
+		{"README.txt", []byte(fmt.Sprintf(bundleReadme, version))},
+	}
+	now := time.Now()
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    f.name,
+			Mode:    0644,
+			Size:    int64(len(f.data)),
+			ModTime: now,
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+const bundleReadme = `Monitoring bundle for ATLANT Node %s.
+
+atlant-alerts.yml      Prometheus alerting rules, add it to rule_files of prometheus.yml.
+atlant-dashboard.json  Grafana dashboard, import it and pick the Prometheus data source.
+
+Rules and panels expect the node to be scraped with job="atlant".
+`
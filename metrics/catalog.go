@@ -0,0 +1,312 @@
+// Package metrics describes the metrics of ATLANT Node. The catalog is the single
+// source of metric names and labels, monitoring configs shipped with the node are
+// generated from it so they never drift from what the node exposes.
+package metrics
+
+type Type string
+
+const (
+	Counter   Type = "counter"
+	Gauge     Type = "gauge"
+	Histogram Type = "histogram"
+)
+
+// Subsystem groups metrics on dashboards.
+type Subsystem string
+
+const (
+	SubsystemNode        Subsystem = "node"
+	SubsystemAPI         Subsystem = "api"
+	SubsystemRecordStore Subsystem = "rs"
+	SubsystemFileStore   Subsystem = "fs"
+	SubsystemStateStore  Subsystem = "state"
+)
+
+type Desc struct {
+	Name      string
+	Help      string
+	Type      Type
+	Subsystem Subsystem
+	Labels    []string
+	// Unit is used by dashboards to format values, e.g. "bytes" or "s".
+	Unit string
+}
+
+const (
+	NodeUptime    = "atlant_node_uptime_seconds"
+	NodeInfo      = "atlant_node_info"
+	NodeClockSkew = "atlant_node_clock_skew_seconds"
+
+	CacheHits      = "atlant_cache_hits_total"
+	CacheMisses    = "atlant_cache_misses_total"
+	CacheEvictions = "atlant_cache_evictions_total"
+	CacheEntries   = "atlant_cache_entries"
+
+	APIRequestsInFlight = "atlant_api_requests_in_flight"
+	APISubscriptions    = "atlant_api_subscriptions"
+	APIThrottled        = "atlant_api_throttled_total"
+	APIShadowed         = "atlant_api_shadowed_total"
+	APILoadDraining     = "atlant_api_load_draining"
+
+	RSInboundQueueDepth     = "atlant_rs_inbound_queue_depth"
+	RSOutboundQueueDepth    = "atlant_rs_outbound_queue_depth"
+	RSOutboundJournaled     = "atlant_rs_outbound_journaled"
+	RSInboundBusyWorkers    = "atlant_rs_inbound_busy_workers"
+	RSInboundMaxDepth       = "atlant_rs_inbound_max_worker_depth"
+	RSInboundFailed         = "atlant_rs_inbound_failed_total"
+	RSInboundWait           = "atlant_rs_inbound_wait_seconds"
+	RSSyncDuration          = "atlant_rs_sync_duration_seconds"
+	RSSyncFailures          = "atlant_rs_sync_failures_total"
+	RSCircuitOpen           = "atlant_rs_circuit_open"
+	RSWatchers              = "atlant_rs_watchers"
+	RSWatchDelivered        = "atlant_rs_watch_delivered_total"
+	RSWatchDropped          = "atlant_rs_watch_dropped_total"
+	RSWebhookQueued         = "atlant_rs_webhook_queued"
+	RSWebhookDelivered      = "atlant_rs_webhook_delivered_total"
+	RSWebhookFailed         = "atlant_rs_webhook_failed_total"
+	RSWebhookDeadLetters    = "atlant_rs_webhook_dead_letters_total"
+	RSDedupChecked          = "atlant_rs_dedup_checked_total"
+	RSDedupDuplicates       = "atlant_rs_dedup_duplicates_total"
+	RSProtocolWriteBlocked  = "atlant_rs_protocol_write_blocked"
+	RSRetentionDropped      = "atlant_rs_retention_versions_dropped_total"
+	RSRetentionReclaimed    = "atlant_rs_retention_reclaimed_bytes_total"
+	RSGCRuns                = "atlant_rs_gc_runs_total"
+	RSGCFailures            = "atlant_rs_gc_failures_total"
+	RSGCVersionsDropped     = "atlant_rs_gc_versions_dropped_total"
+	RSGCOrphansUnpinned     = "atlant_rs_gc_orphans_unpinned_total"
+	RSGCReclaimed           = "atlant_rs_gc_reclaimed_bytes_total"
+	RSDiskUsage             = "atlant_rs_disk_usage_bytes"
+	RSDiskBudget            = "atlant_rs_disk_budget_bytes"
+	RSDiskWritesRefused     = "atlant_rs_disk_writes_refused_total"
+	RSDiskPinsSkipped       = "atlant_rs_disk_pins_skipped_total"
+	RSRepinPending          = "atlant_rs_repin_pending"
+	RSRepinRepaired         = "atlant_rs_repin_repaired_total"
+	RSRepinFetched          = "atlant_rs_repin_fetched_blocks_total"
+	RSRepinLost             = "atlant_rs_repin_lost"
+	RSAntiEntropyRounds     = "atlant_rs_anti_entropy_rounds_total"
+	RSAntiEntropyRepaired   = "atlant_rs_anti_entropy_repaired_total"
+	RSAntiEntropyBytes      = "atlant_rs_anti_entropy_bytes_total"
+	RSRateLimited           = "atlant_rs_rate_limited_total"
+	RSSLOAvailability       = "atlant_rs_slo_availability_ratio"
+	RSSLOLatencyP99         = "atlant_rs_slo_read_latency_p99_seconds"
+	RSSLOReplicationLagP99  = "atlant_rs_slo_replication_lag_p99_seconds"
+	RSSLOBreached           = "atlant_rs_slo_breached"
+	FSPeers                 = "atlant_fs_peers"
+	FSPins                  = "atlant_fs_pins"
+	FSBandwidthIn           = "atlant_fs_bandwidth_in_bytes_total"
+	FSBandwidthOut          = "atlant_fs_bandwidth_out_bytes_total"
+	FSRepoSize              = "atlant_fs_repo_size_bytes"
+	FSRepoStorageMax        = "atlant_fs_repo_storage_max_bytes"
+	FSRepoObjects           = "atlant_fs_repo_objects"
+	FSDiskTotal             = "atlant_fs_disk_total_bytes"
+	FSDiskFree              = "atlant_fs_disk_free_bytes"
+	FSBlocklistRefused      = "atlant_fs_blocklist_refused_total"
+	FSBitswapThrottled      = "atlant_fs_bitswap_throttled_peers"
+	FSBitswapThrottles      = "atlant_fs_bitswap_throttles_total"
+	FSMmapCacheBytes        = "atlant_fs_mmap_cache_bytes"
+	FSMmapCacheHits         = "atlant_fs_mmap_cache_hits_total"
+	FSMmapCacheEvictions    = "atlant_fs_mmap_cache_evictions_total"
+	FSExchangedPeers        = "atlant_fs_exchanged_peers"
+	FSInlineStored          = "atlant_fs_inline_stored_total"
+	FSInlineHits            = "atlant_fs_inline_hits_total"
+	StateKeys               = "atlant_state_keys"
+	StateRejectedKeys       = "atlant_state_rejected_keys_total"
+	StateRejectedValues     = "atlant_state_rejected_values_total"
+	StateBadgerBlockedPuts  = "atlant_state_badger_blocked_puts_total"
+	StateBadgerWrittenBytes = "atlant_state_badger_written_bytes_total"
+	StateWriteAttempts      = "atlant_state_write_attempts_total"
+	StateWriteConflicts     = "atlant_state_write_conflicts_total"
+	StateConflictsExhausted = "atlant_state_write_conflicts_exhausted_total"
+	StateGCRuns             = "atlant_state_gc_runs_total"
+	StateGCFailures         = "atlant_state_gc_failures_total"
+	StateGCRewrites         = "atlant_state_gc_rewrites_total"
+	StateGCReclaimedBytes   = "atlant_state_gc_reclaimed_bytes_total"
+)
+
+// Catalog lists all metrics of the node in the order they appear on dashboards.
+var Catalog = []*Desc{
+	{Name: NodeUptime, Type: Gauge, Subsystem: SubsystemNode, Unit: "s",
+		Help: "Seconds since the node has been started."},
+	{Name: NodeInfo, Type: Gauge, Subsystem: SubsystemNode, Labels: []string{"node_id", "version"},
+		Help: "Always 1, labels carry the node ID and version."},
+	{Name: NodeClockSkew, Type: Gauge, Subsystem: SubsystemNode, Unit: "s",
+		Help: "How much the local clock is ahead of NTP or peers, negative if behind."},
+	{Name: CacheHits, Type: Counter, Subsystem: SubsystemNode, Labels: []string{"class"},
+		Help: "Lookups answered by the cache of hot lookups per class."},
+	{Name: CacheMisses, Type: Counter, Subsystem: SubsystemNode, Labels: []string{"class"},
+		Help: "Lookups the cache had no fresh entry for per class."},
+	{Name: CacheEvictions, Type: Counter, Subsystem: SubsystemNode, Labels: []string{"class"},
+		Help: "Entries evicted from the full cache per class."},
+	{Name: CacheEntries, Type: Gauge, Subsystem: SubsystemNode, Labels: []string{"class"},
+		Help: "Entries kept by the cache per class."},
+
+	{Name: APIRequestsInFlight, Type: Gauge, Subsystem: SubsystemAPI,
+		Help: "Requests of the public API in flight, subscriptions included."},
+	{Name: APISubscriptions, Type: Gauge, Subsystem: SubsystemAPI,
+		Help: "Event streams and WebSockets open on the public API."},
+	{Name: APIThrottled, Type: Counter, Subsystem: SubsystemAPI, Labels: []string{"reason"},
+		Help: "Public API requests refused by throttles per reason."},
+	{Name: APIShadowed, Type: Counter, Subsystem: SubsystemAPI, Labels: []string{"result"},
+		Help: "Read requests mirrored to the staging node per result of the comparison."},
+	{Name: APILoadDraining, Type: Gauge, Subsystem: SubsystemAPI,
+		Help: "1 while /lb-health tells load balancers to drain the overloaded node."},
+
+	{Name: RSInboundQueueDepth, Type: Gauge, Subsystem: SubsystemRecordStore,
+		Help: "Announces received from the network and waiting to be handled."},
+	{Name: RSOutboundQueueDepth, Type: Gauge, Subsystem: SubsystemRecordStore,
+		Help: "Announces produced locally and waiting to be published."},
+	{Name: RSOutboundJournaled, Type: Gauge, Subsystem: SubsystemRecordStore,
+		Help: "Announces of local writes kept in the outbound journal until published."},
+	{Name: RSInboundBusyWorkers, Type: Gauge, Subsystem: SubsystemRecordStore,
+		Help: "Inbound workers handling an announce."},
+	{Name: RSInboundMaxDepth, Type: Gauge, Subsystem: SubsystemRecordStore,
+		Help: "Announces waiting in the queue of the most loaded inbound worker."},
+	{Name: RSInboundFailed, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Inbound announces that failed to be handled."},
+	{Name: RSInboundWait, Type: Histogram, Subsystem: SubsystemRecordStore, Unit: "s",
+		Help: "Time inbound announces waited in queues before being handled."},
+	{Name: RSSyncDuration, Type: Histogram, Subsystem: SubsystemRecordStore, Unit: "s",
+		Help: "Duration of record store syncs with the network."},
+	{Name: RSSyncFailures, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Record store syncs that failed."},
+	{Name: RSCircuitOpen, Type: Gauge, Subsystem: SubsystemRecordStore, Labels: []string{"circuit"},
+		Help: "1 if the circuit breaker is open and reads are failing fast."},
+	{Name: RSWatchers, Type: Gauge, Subsystem: SubsystemRecordStore,
+		Help: "Active record watchers."},
+	{Name: RSWatchDelivered, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Record changes delivered to watchers."},
+	{Name: RSWatchDropped, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Record changes dropped because a watcher or the watch queue was full."},
+	{Name: RSWebhookQueued, Type: Gauge, Subsystem: SubsystemRecordStore,
+		Help: "Deliveries of record changes waiting to be sent to webhooks."},
+	{Name: RSWebhookDelivered, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Record changes delivered to webhooks."},
+	{Name: RSWebhookFailed, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Failed attempts to deliver record changes to webhooks."},
+	{Name: RSWebhookDeadLetters, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Webhook deliveries moved to dead letters after all attempts failed."},
+	{Name: RSDedupChecked, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Inbound announces checked for duplicates."},
+	{Name: RSDedupDuplicates, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Inbound announces dropped as duplicates."},
+	{Name: RSProtocolWriteBlocked, Type: Gauge, Subsystem: SubsystemRecordStore,
+		Help: "1 if the node refuses writes due to protocol version incompatibility."},
+	{Name: RSRetentionDropped, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Record versions dropped by retention policies."},
+	{Name: RSRetentionReclaimed, Type: Counter, Subsystem: SubsystemRecordStore, Unit: "bytes",
+		Help: "Bytes reclaimed from the repo by retention policies."},
+	{Name: RSGCRuns, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Garbage collections run, periodic and manual."},
+	{Name: RSGCFailures, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Garbage collections that failed."},
+	{Name: RSGCVersionsDropped, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Record versions dropped by garbage collection due to age or disk usage."},
+	{Name: RSGCOrphansUnpinned, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Pins removed because no record referred to them."},
+	{Name: RSGCReclaimed, Type: Counter, Subsystem: SubsystemRecordStore, Unit: "bytes",
+		Help: "Bytes reclaimed from the repo by garbage collection."},
+	{Name: RSDiskUsage, Type: Gauge, Subsystem: SubsystemRecordStore, Unit: "bytes",
+		Help: "Disk space taken by the state store and the IPFS repo together."},
+	{Name: RSDiskBudget, Type: Gauge, Subsystem: SubsystemRecordStore, Unit: "bytes",
+		Help: "Disk budget of the node, zero when it's disabled."},
+	{Name: RSDiskWritesRefused, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Local writes refused over the disk budget."},
+	{Name: RSDiskPinsSkipped, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Pins of record content skipped over the disk budget."},
+	{Name: RSRepinPending, Type: Gauge, Subsystem: SubsystemRecordStore,
+		Help: "Pinned versions with missing blocks waiting to be refetched."},
+	{Name: RSRepinRepaired, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Pinned versions restored by refetching their missing blocks."},
+	{Name: RSRepinFetched, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Missing blocks of pinned versions refetched from peers."},
+	{Name: RSRepinLost, Type: Gauge, Subsystem: SubsystemRecordStore,
+		Help: "Pinned versions still missing blocks after all refetch attempts."},
+	{Name: RSAntiEntropyRounds, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Anti-entropy rounds run with random peers."},
+	{Name: RSAntiEntropyRepaired, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Records pulled from or pushed to peers by anti-entropy rounds."},
+	{Name: RSAntiEntropyBytes, Type: Counter, Subsystem: SubsystemRecordStore, Unit: "bytes",
+		Help: "Bytes of records transferred by anti-entropy rounds."},
+	{Name: RSRateLimited, Type: Counter, Subsystem: SubsystemRecordStore,
+		Help: "Public API requests refused by rate limits of namespaces."},
+	{Name: RSSLOAvailability, Type: Gauge, Subsystem: SubsystemRecordStore, Labels: []string{"namespace"},
+		Help: "Share of successful reads of the namespace within its SLO window."},
+	{Name: RSSLOLatencyP99, Type: Gauge, Subsystem: SubsystemRecordStore, Unit: "s", Labels: []string{"namespace"},
+		Help: "99th percentile of read latency of the namespace within its SLO window."},
+	{Name: RSSLOReplicationLagP99, Type: Gauge, Subsystem: SubsystemRecordStore, Unit: "s", Labels: []string{"namespace"},
+		Help: "99th percentile of the delay between a write and its arrival on the node."},
+	{Name: RSSLOBreached, Type: Gauge, Subsystem: SubsystemRecordStore, Labels: []string{"namespace", "sli"},
+		Help: "Whether the namespace misses its objective for the SLI."},
+
+	{Name: FSPeers, Type: Gauge, Subsystem: SubsystemFileStore,
+		Help: "Connected IPFS peers."},
+	{Name: FSPins, Type: Gauge, Subsystem: SubsystemFileStore,
+		Help: "Objects pinned in the IPFS repo."},
+	{Name: FSBandwidthIn, Type: Counter, Subsystem: SubsystemFileStore, Unit: "bytes",
+		Help: "Bytes received from IPFS peers."},
+	{Name: FSBandwidthOut, Type: Counter, Subsystem: SubsystemFileStore, Unit: "bytes",
+		Help: "Bytes sent to IPFS peers."},
+	{Name: FSRepoSize, Type: Gauge, Subsystem: SubsystemFileStore, Unit: "bytes",
+		Help: "Size of the IPFS repo."},
+	{Name: FSRepoStorageMax, Type: Gauge, Subsystem: SubsystemFileStore, Unit: "bytes",
+		Help: "Configured maximum size of the IPFS repo."},
+	{Name: FSRepoObjects, Type: Gauge, Subsystem: SubsystemFileStore,
+		Help: "Objects stored in the IPFS repo."},
+	{Name: FSDiskTotal, Type: Gauge, Subsystem: SubsystemFileStore, Unit: "bytes",
+		Help: "Size of the disk holding the node data."},
+	{Name: FSDiskFree, Type: Gauge, Subsystem: SubsystemFileStore, Unit: "bytes",
+		Help: "Free space on the disk holding the node data."},
+	{Name: FSBlocklistRefused, Type: Counter, Subsystem: SubsystemFileStore,
+		Help: "Peer connections refused by the blocklist."},
+	{Name: FSBitswapThrottled, Type: Gauge, Subsystem: SubsystemFileStore,
+		Help: "Peers refused for exceeding bitswap debt limits."},
+	{Name: FSBitswapThrottles, Type: Counter, Subsystem: SubsystemFileStore,
+		Help: "Times peers were throttled for exceeding bitswap debt limits."},
+	{Name: FSMmapCacheBytes, Type: Gauge, Subsystem: SubsystemFileStore, Unit: "bytes",
+		Help: "Size of objects held in the mmap cache."},
+	{Name: FSMmapCacheHits, Type: Counter, Subsystem: SubsystemFileStore,
+		Help: "Object reads served from the mmap cache."},
+	{Name: FSMmapCacheEvictions, Type: Counter, Subsystem: SubsystemFileStore,
+		Help: "Objects evicted from the mmap cache."},
+	{Name: FSExchangedPeers, Type: Gauge, Subsystem: SubsystemFileStore,
+		Help: "Peers kept by the peer exchange as bootstrap candidates."},
+	{Name: FSInlineStored, Type: Counter, Subsystem: SubsystemFileStore,
+		Help: "Small objects stored inline in the state store."},
+	{Name: FSInlineHits, Type: Counter, Subsystem: SubsystemFileStore,
+		Help: "Object reads served inline from the state store."},
+
+	{Name: StateKeys, Type: Gauge, Subsystem: SubsystemStateStore, Labels: []string{"bucket"},
+		Help: "Keys stored in the state store per bucket."},
+	{Name: StateRejectedKeys, Type: Counter, Subsystem: SubsystemStateStore,
+		Help: "State writes rejected because the key exceeded the size limit."},
+	{Name: StateRejectedValues, Type: Counter, Subsystem: SubsystemStateStore,
+		Help: "State writes rejected because the value exceeded the size limit."},
+	{Name: StateBadgerBlockedPuts, Type: Counter, Subsystem: SubsystemStateStore,
+		Help: "Badger writes blocked by compactions."},
+	{Name: StateBadgerWrittenBytes, Type: Counter, Subsystem: SubsystemStateStore, Unit: "bytes",
+		Help: "Bytes written to disk by badger."},
+	{Name: StateWriteAttempts, Type: Counter, Subsystem: SubsystemStateStore, Labels: []string{"bucket"},
+		Help: "Write transactions attempted per bucket, including retries."},
+	{Name: StateWriteConflicts, Type: Counter, Subsystem: SubsystemStateStore, Labels: []string{"bucket"},
+		Help: "Write transactions that failed with a conflict per bucket."},
+	{Name: StateConflictsExhausted, Type: Counter, Subsystem: SubsystemStateStore, Labels: []string{"bucket"},
+		Help: "Writes that kept conflicting after all retries per bucket."},
+	{Name: StateGCRuns, Type: Counter, Subsystem: SubsystemStateStore,
+		Help: "Value log GC runs of the badger state store."},
+	{Name: StateGCFailures, Type: Counter, Subsystem: SubsystemStateStore,
+		Help: "Value log GC runs of the badger state store that failed."},
+	{Name: StateGCRewrites, Type: Counter, Subsystem: SubsystemStateStore,
+		Help: "Value log files rewritten by GC of the badger state store."},
+	{Name: StateGCReclaimedBytes, Type: Counter, Subsystem: SubsystemStateStore, Unit: "bytes",
+		Help: "Disk space reclaimed by value log GC of the badger state store."},
+}
+
+// Lookup returns the description of a metric by its name.
+func Lookup(name string) (*Desc, bool) {
+	for _, d := range Catalog {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return nil, false
+}
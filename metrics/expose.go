@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HistogramCounter counts observations into buckets by upper bounds, safe for concurrent use.
+type HistogramCounter struct {
+	mux    *sync.Mutex
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func NewHistogramCounter(bounds ...float64) *HistogramCounter {
+	bounds = append([]float64(nil), bounds...)
+	sort.Float64s(bounds)
+	return &HistogramCounter{
+		mux:    new(sync.Mutex),
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *HistogramCounter) Observe(v float64) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// HistogramSnapshot holds cumulative bucket counts, as Prometheus expects them.
+type HistogramSnapshot struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+func (h *HistogramCounter) Snapshot() *HistogramSnapshot {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	s := &HistogramSnapshot{
+		Bounds: append([]float64(nil), h.bounds...),
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    h.sum,
+		Count:  h.count,
+	}
+	var total uint64
+	for i, c := range h.counts {
+		total += c
+		s.Counts[i] = total
+	}
+	return s
+}
+
+// Encoder writes metrics in the Prometheus text exposition format. Only metrics
+// of the catalog can be written, samples of a metric must be written together.
+type Encoder struct {
+	buf     *bytes.Buffer
+	written map[string]bool
+	err     error
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{
+		buf:     new(bytes.Buffer),
+		written: make(map[string]bool),
+	}
+}
+
+func (e *Encoder) header(name string, typ Type, labelValues []string) (*Desc, bool) {
+	desc, ok := Lookup(name)
+	if !ok {
+		e.fail(fmt.Errorf("metric %s is not in the catalog", name))
+		return nil, false
+	} else if desc.Type != typ {
+		e.fail(fmt.Errorf("metric %s is a %s, not a %s", name, desc.Type, typ))
+		return nil, false
+	} else if len(labelValues) != len(desc.Labels) {
+		e.fail(fmt.Errorf("metric %s expects labels %v", name, desc.Labels))
+		return nil, false
+	}
+	if !e.written[name] {
+		e.written[name] = true
+		fmt.Fprintf(e.buf, "# HELP %s %s\n", name, escapeHelp(desc.Help))
+		fmt.Fprintf(e.buf, "# TYPE %s %s\n", name, desc.Type)
+	}
+	return desc, true
+}
+
+func (e *Encoder) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+// Gauge writes a sample of a gauge, label values follow the order of the catalog.
+func (e *Encoder) Gauge(name string, v float64, labelValues ...string) {
+	if desc, ok := e.header(name, Gauge, labelValues); ok {
+		e.sample(name, desc.Labels, labelValues, v)
+	}
+}
+
+// Counter writes a sample of a counter, label values follow the order of the catalog.
+func (e *Encoder) Counter(name string, v float64, labelValues ...string) {
+	if desc, ok := e.header(name, Counter, labelValues); ok {
+		e.sample(name, desc.Labels, labelValues, v)
+	}
+}
+
+// Histogram writes buckets, sum and count of a histogram.
+func (e *Encoder) Histogram(name string, h *HistogramSnapshot, labelValues ...string) {
+	desc, ok := e.header(name, Histogram, labelValues)
+	if !ok {
+		return
+	}
+	labels := append(append([]string(nil), desc.Labels...), "le")
+	for i, bound := range h.Bounds {
+		values := append(append([]string(nil), labelValues...), formatFloat(bound))
+		e.sample(name+"_bucket", labels, values, float64(h.Counts[i]))
+	}
+	values := append(append([]string(nil), labelValues...), "+Inf")
+	e.sample(name+"_bucket", labels, values, float64(h.Count))
+	e.sample(name+"_sum", desc.Labels, labelValues, h.Sum)
+	e.sample(name+"_count", desc.Labels, labelValues, float64(h.Count))
+}
+
+func (e *Encoder) sample(name string, labels, values []string, v float64) {
+	e.buf.WriteString(name)
+	if len(labels) > 0 {
+		e.buf.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				e.buf.WriteByte(',')
+			}
+			fmt.Fprintf(e.buf, "%s=\"%s\"", l, escapeLabel(values[i]))
+		}
+		e.buf.WriteByte('}')
+	}
+	e.buf.WriteByte(' ')
+	e.buf.WriteString(formatFloat(v))
+	e.buf.WriteByte('\n')
+}
+
+// Err returns the first misuse of the catalog, such samples are skipped.
+func (e *Encoder) Err() error {
+	return e.err
+}
+
+func (e *Encoder) WriteTo(w io.Writer) (int64, error) {
+	return e.buf.WriteTo(w)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Migrations of the state store run on start of the node, the command runs them ahead
+// of an upgrade or shows what they would change. The node must be stopped.
+
+func migrateCmd(c *cli.Cmd) {
+	c.Spec = "[--dry-run] [--json]"
+	dryRun := c.Bool(cli.BoolOpt{
+		Name:  "dry-run",
+		Desc:  "Only count keys that migrations would change.",
+		Value: false,
+	})
+	asJSON := c.Bool(cli.BoolOpt{
+		Name:  "json",
+		Desc:  "Print reports of migrations as JSON.",
+		Value: false,
+	})
+	c.Action = func() {
+		if _, err := readPrivateAPIFile(); err == nil {
+			log.Warningln("node seems to be running, stop it before migrating the state store")
+		}
+		store, err := openStateStore()
+		if err != nil {
+			log.Fatalln("failed to open the state store:", err)
+		}
+		defer store.Close()
+		version, err := state.SchemaVersion(store)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		reports, err := state.Migrate(store, rs.Migrations(), *dryRun)
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(reports)
+		} else if len(reports) == 0 && err == nil {
+			fmt.Printf("state schema version %d is up to date\n", version)
+		} else {
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tNAME\tCHANGED KEYS\tDURATION")
+			for _, r := range reports {
+				fmt.Fprintf(w, "%d\t%s\t%d\t%s\n", r.Version, r.Name, r.Changed, r.Duration)
+			}
+			w.Flush()
+		}
+		if err != nil {
+			store.Close()
+			log.Fatalln(err)
+		} else if *dryRun && len(reports) > 0 {
+			log.Printf("dry run, state schema version %d is kept", version)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package node
+
+import (
+	"time"
+
+	"github.com/AtlantPlatform/atlant-go/api"
+	"github.com/AtlantPlatform/atlant-go/cache"
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/rs"
+	"github.com/AtlantPlatform/atlant-go/tracing"
+)
+
+// Config holds settings of a node. Settings left zero in a config returned by DefaultConfig
+// disable the feature they belong to, e.g. an empty MetricsListenAddr serves no metrics.
+type Config struct {
+	// Testnet selects the testnet environment, the fs dir must be initialized for it.
+	Testnet bool
+	// Version is reported in lifecycle events of the node.
+	Version string
+
+	StateDir                string
+	StateBackend            string
+	StateMaxValueSize       int
+	StateConflictRetries    int
+	StatePessimisticBuckets []string
+	StateRecover            bool
+	StateGCInterval         time.Duration
+	StateSpareDir           string
+	StateSpareInterval      time.Duration
+	// StateChecksums frames values of the badger store with checksums, values of
+	// StateEncryptBuckets are sealed with a key wrapped with the identity of the node.
+	StateChecksums      bool
+	StateEncryptBuckets []string
+
+	FSDir          string
+	FSListenAddr   string
+	BootstrapPeers []string
+	RelayEnabled   bool
+	NetworkProfile string
+	// AutoMigrate migrates an outdated IPFS repo on open, see fs.UseAutoMigrateOpt.
+	AutoMigrate bool
+	// Warmup is the time given to IPFS to find peers before the first sync.
+	Warmup time.Duration
+
+	// SwarmProxy routes swarm dials through a proxy, see fs.UseProxyOpt.
+	SwarmProxy *fs.ProxyConfig
+	// SwarmIPv6 listens on IPv6 at the port of FSListenAddr as well.
+	SwarmIPv6 bool
+	// NAT sets how the node gets reachable behind a NAT, see fs.NATConfig.
+	NAT *fs.NATConfig
+
+	Blocklist            []string
+	BlocklistRefresh     time.Duration
+	MmapCacheSize        int64
+	MmapMinObjectSize    int64
+	MmapHotReads         int
+	InlineMaxSize        int64
+	BitswapMaxDebt       int64
+	BitswapDebtRatio     float64
+	BitswapDebtCooldown  time.Duration
+	BitswapDebtExempt    []string
+	PeerExchangeInterval time.Duration
+	PeerExchangeTTL      time.Duration
+
+	Namespaces        []string
+	ReadOnly          bool
+	DryRun            bool
+	DedupWindow       time.Duration
+	NTPServer         string
+	ClockSkewWarn     time.Duration
+	ClockSkewMax      time.Duration
+	ClockRefuseWrites bool
+	GC                *rs.GCPolicy
+	DiskBudget        *rs.DiskBudget
+	Encryption        *rs.EncryptionPolicy
+	Conflicts         *rs.ConflictPolicy
+	Cache             *cache.Config
+	Tracing           *tracing.Config
+	Vouch             *rs.VouchPolicy
+	Search            *rs.SearchPolicy
+	LifecycleTTL      time.Duration
+	FullResync        bool
+	SyncMargin        time.Duration
+	InboundWorkers    int
+	WebhookAttempts   int
+	WebhookDeadTTL    time.Duration
+
+	ManifestInterval    time.Duration
+	RetentionInterval   time.Duration
+	SearchInterval      time.Duration
+	ShardRepairInterval time.Duration
+	RepinInterval       time.Duration
+	MirrorInterval      time.Duration
+	GCInterval          time.Duration
+	// ReindexRate limits index rebuilds in records per second, zero removes the limit.
+	ReindexRate int
+	// AntiEntropy configures repair rounds with random peers, a zero interval disables them.
+	AntiEntropy *rs.AntiEntropyPolicy
+	// Mirror pins content of the selected records only, unless a policy was set at runtime.
+	Mirror *rs.MirrorPolicy
+	// PopularitySample is the share of client reads counted in popularity of records, zero
+	// turns counting off.
+	PopularitySample float64
+	// Beats sets how often beats are sent, BeatReportInterval how often beat reports are
+	// committed by nodes with write permissions.
+	Beats              *rs.BeatPolicy
+	BeatReportInterval time.Duration
+	// ColdTier offloads versions not read for a while to the bucket of ColdStore, the tier
+	// is disabled without one.
+	ColdTier  *rs.ColdTierPolicy
+	ColdStore *fs.S3Config
+
+	EthAddress string
+	EthRPC     string
+
+	// WebListenAddrs are addresses of the public API, see api.ParseListenAddr.
+	WebListenAddrs    []string
+	MetricsListenAddr string
+	// GRPCListenAddr serves the gRPC API and its JSON gateway, see api.GRPCServer.
+	GRPCListenAddr string
+	// PrivateListenAddr and PeerListenAddr are addresses of the private API,
+	// the local one and the one served to peers over libp2p.
+	PrivateListenAddr string
+	PeerListenAddr    string
+	TLS               *api.TLSConfig
+	LogDir            string
+	UploadDir         string
+	// PrivateTokenFile keeps the token of the private API, private-api.token in the state dir by default.
+	PrivateTokenFile string
+	PresignedURLs    bool
+	// PresignKeyFile keeps the key pre-signed URLs are signed with, presign.key in the state dir by default.
+	PresignKeyFile string
+	BootstrapToken string
+	// SwarmKey of the testnet is bundled into join tokens minted by the node, it's set within testnet only.
+	SwarmKey string
+	// SwarmKeyInfo identifies the swarm key of the node in status.
+	SwarmKeyInfo *api.SwarmKeyInfo
+	// JoinToken the node was initialized with, it's redeemed at the issuer after start.
+	JoinToken      *rs.JoinToken
+	RouteTimeouts  *api.RouteTimeouts
+	ProxyRoutes    api.ProxyRoutes
+	CORS           *api.CORSConfig
+	ClientHeader   string
+	SRI            bool
+	NetworkStats   bool
+	ReadyMinPeers  int
+	IdempotencyTTL time.Duration
+	Throttles      *api.ThrottleConfig
+	Shadow         *api.ShadowConfig
+	LoadShed       *api.LoadShedConfig
+	IngestNodes    []string
+	Policy         *api.Policy
+	Gating         *api.GatingConfig
+	// Plugins serve their routes on the private API, see package plugins.
+	Plugins []api.Plugin
+	// SupportConfig is included into support bundles, it must be redacted already.
+	SupportConfig interface{}
+	// Shutdown is called when a local tool asks the node to stop, it stops the node by default.
+	Shutdown func()
+	// DrainTimeout bounds how long Stop waits for queued announces to be published and
+	// for inbound workers to finish, writes are refused meanwhile.
+	DrainTimeout time.Duration
+}
+
+// DefaultConfig returns the settings the node binary runs with when no flags are given.
+func DefaultConfig() *Config {
+	return &Config{
+		StateDir:             "var/state",
+		StateBackend:         "badger",
+		StateMaxValueSize:    32 * 1024 * 1024,
+		StateConflictRetries: 5,
+		StateRecover:         true,
+		StateChecksums:       true,
+		StateGCInterval:      10 * time.Minute,
+
+		FSDir:          "var/fs",
+		FSListenAddr:   "0.0.0.0:33770",
+		NAT:            fs.DefaultNATConfig(),
+		RelayEnabled:   true,
+		AutoMigrate:    true,
+		NetworkProfile: "default",
+		Warmup:         5 * time.Second,
+
+		BlocklistRefresh:     time.Hour,
+		MmapMinObjectSize:    4 * 1024 * 1024,
+		MmapHotReads:         3,
+		InlineMaxSize:        4 * 1024,
+		BitswapDebtRatio:     10,
+		BitswapDebtCooldown:  10 * time.Minute,
+		PeerExchangeInterval: 10 * time.Minute,
+		PeerExchangeTTL:      7 * 24 * time.Hour,
+
+		DedupWindow:   15 * time.Minute,
+		ClockSkewWarn: 2 * time.Second,
+		ClockSkewMax:  30 * time.Second,
+		GC:            &rs.GCPolicy{},
+		DiskBudget: &rs.DiskBudget{
+			Warnings: []float64{0.8, 0.9, 0.95},
+		},
+		Vouch: &rs.VouchPolicy{
+			Namespace: "probation",
+			Duration:  30 * 24 * time.Hour,
+		},
+		LifecycleTTL: 7 * 24 * time.Hour,
+		SyncMargin:   time.Hour,
+		Cache: &cache.Config{
+			Size: cache.DefaultSize,
+		},
+
+		InboundWorkers:  8,
+		WebhookAttempts: 8,
+		WebhookDeadTTL:  7 * 24 * time.Hour,
+
+		ManifestInterval:    6 * time.Hour,
+		RetentionInterval:   time.Hour,
+		SearchInterval:      6 * time.Hour,
+		ShardRepairInterval: time.Hour,
+		RepinInterval:       6 * time.Hour,
+		MirrorInterval:      6 * time.Hour,
+		ReindexRate:         200,
+		GCInterval:          24 * time.Hour,
+		AntiEntropy: &rs.AntiEntropyPolicy{
+			Interval: 10 * time.Minute,
+			Rate:     1 << 20,
+			MaxBytes: 64 << 20,
+		},
+		PopularitySample:   0.1,
+		Beats:              rs.DefaultBeatPolicy(),
+		BeatReportInterval: time.Hour,
+		ColdTier:           rs.DefaultColdTierPolicy(),
+
+		WebListenAddrs:    []string{"0.0.0.0:33780"},
+		PrivateListenAddr: "127.0.0.1:0",
+		PeerListenAddr:    "127.0.0.1:0",
+		LogDir:            "var/log",
+		RouteTimeouts:     &api.RouteTimeouts{},
+		PresignedURLs:     true,
+		ReadyMinPeers:     1,
+		IdempotencyTTL:    24 * time.Hour,
+
+		DrainTimeout: 2 * time.Minute,
+	}
+}
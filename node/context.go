@@ -0,0 +1,63 @@
+package node
+
+import (
+	"context"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Context carries the stores and the identity of a node, the API context is derived from it.
+type Context struct {
+	context.Context
+}
+
+func NewContext(ctx context.Context, env, ver string,
+	fileStore fs.PlanetaryFileStore, stateStore state.IndexedStore) Context {
+	ctx = context.WithValue(ctx, "env", env)
+	ctx = context.WithValue(ctx, "ver", ver)
+	ctx = context.WithValue(ctx, "node_id", fileStore.NodeID())
+	ctx = context.WithValue(ctx, "session_id", proto.NewID())
+	ctx = context.WithValue(ctx, "fs", fileStore)
+	ctx = context.WithValue(ctx, "ss", stateStore)
+	return Context{ctx}
+}
+
+func (c Context) FileStore() fs.PlanetaryFileStore {
+	return c.Value("fs").(fs.PlanetaryFileStore)
+}
+
+func (c Context) StateStore() state.IndexedStore {
+	return c.Value("ss").(state.IndexedStore)
+}
+
+// SwarmPeers returns peers the node is connected to.
+func (c Context) SwarmPeers() []*fs.SwarmPeer {
+	return c.FileStore().SwarmPeers()
+}
+
+// ConnectPeer connects to the peer at a multiaddr ending with /ipfs/<node ID>.
+func (c Context) ConnectPeer(addr string) (string, error) {
+	return c.FileStore().ConnectPeer(c, addr)
+}
+
+func (c Context) DisconnectPeer(nodeID string) error {
+	return c.FileStore().DisconnectPeer(nodeID)
+}
+
+func (c Context) Env() string {
+	return c.Value("env").(string)
+}
+
+func (c Context) Ver() string {
+	return c.Value("ver").(string)
+}
+
+func (c Context) NodeID() string {
+	return c.Value("node_id").(string)
+}
+
+func (c Context) SessionID() string {
+	return c.Value("session_id").(string)
+}
@@ -0,0 +1,601 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/api"
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/cache"
+	"github.com/AtlantPlatform/atlant-go/contracts"
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/rs"
+	"github.com/AtlantPlatform/atlant-go/state"
+	"github.com/AtlantPlatform/atlant-go/tracing"
+)
+
+// A node can be embedded into other Go programs, e.g. to drive a few nodes from integration
+// tests. New opens the stores of the node, Start syncs it and serves the APIs, Stop drains
+// it and closes everything in reverse order. The authority must be initialized beforehand,
+// see authcenter.InitWithDomains, and the fs dir must be initialized with atlant-go init.
+// Outbound requests to external services go through proxy settings of the process, see
+// proxy.Configure.
+
+const (
+	privateTokenFile = "private-api.token"
+	presignKeyFile   = "presign.key"
+)
+
+var errNotInitialized = errors.New("fs dir is not initialized, please run atlant-go init")
+
+// Node is a handle of a node running in the process.
+type Node struct {
+	cfg *Config
+	ctx Context
+
+	store  rs.PlanetaryRecordStore
+	apiCtx api.APIContext
+
+	privateServer *api.PrivateServer
+	publicServer  *api.PublicServer
+	grpcServer    *api.GRPCServer
+	privateAddr   string
+
+	shutdown  func()
+	cancelFn  context.CancelFunc
+	startOnce *sync.Once
+	stopOnce  *sync.Once
+
+	// stopTracing flushes spans once the APIs are closed
+	stopTracing func(ctx context.Context) error
+}
+
+// New opens the stores of a node and migrates its state, the node is started by Start.
+func New(cfg *Config) (*Node, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	if len(cfg.PrivateTokenFile) == 0 {
+		cfg.PrivateTokenFile = filepath.Join(cfg.StateDir, privateTokenFile)
+	}
+	if len(cfg.PresignKeyFile) == 0 {
+		cfg.PresignKeyFile = filepath.Join(cfg.StateDir, presignKeyFile)
+	}
+	if cfg.DrainTimeout <= 0 {
+		cfg.DrainTimeout = 2 * time.Minute
+	}
+	stateStore, err := OpenStateStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	fileStore, err := OpenFileStore(cfg, stateStore)
+	if err != nil {
+		stateStore.Close()
+		return nil, err
+	}
+	env := "main"
+	if cfg.Testnet {
+		env = "test"
+	}
+	n := &Node{
+		cfg:       cfg,
+		ctx:       NewContext(context.Background(), env, cfg.Version, fileStore, stateStore),
+		startOnce: new(sync.Once),
+		stopOnce:  new(sync.Once),
+	}
+	if err := n.init(); err != nil {
+		fileStore.Close()
+		stateStore.Close()
+		return nil, err
+	}
+	return n, nil
+}
+
+// OpenStateStore opens the state store of the node and runs its migrations.
+func OpenStateStore(cfg *Config) (state.IndexedStore, error) {
+	log.Debugf("using %s as state dir", cfg.StateDir)
+	if err := os.MkdirAll(cfg.StateDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create state dir: %v", err)
+	}
+	var secret []byte
+	if len(cfg.StateEncryptBuckets) > 0 {
+		id, err := fs.ReadIdentity(cfg.FSDir)
+		if err != nil {
+			return nil, fmt.Errorf("encryption of the state store needs the node identity: %v", err)
+		}
+		secret = id.PrivKey
+	}
+	stateStore, err := state.NewIndexedStore(cfg.StateBackend, cfg.StateDir,
+		state.MaxValueSizeOpt(cfg.StateMaxValueSize),
+		state.ConflictRetriesOpt(cfg.StateConflictRetries),
+		state.PessimisticBucketsOpt(cfg.StatePessimisticBuckets...),
+		state.RecoverOpt(cfg.StateRecover),
+		state.MaintenanceIntervalOpt(cfg.StateGCInterval),
+		state.SpareOpt(cfg.StateSpareDir, cfg.StateSpareInterval),
+		state.ChecksumsOpt(cfg.StateChecksums),
+		state.EncryptionOpt(secret, cfg.StateEncryptBuckets...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("NewIndexedStore failed: %v", err)
+	}
+	if _, err := state.Migrate(stateStore, rs.Migrations(), false); err != nil {
+		stateStore.Close()
+		return nil, err
+	}
+	return stateStore, nil
+}
+
+// OpenFileStore starts the IPFS node, the state store keeps peers of the peer exchange
+// and must be closed after the file store.
+func OpenFileStore(cfg *Config, stateStore state.IndexedStore) (fs.PlanetaryFileStore, error) {
+	fsHost, fsPort, err := net.SplitHostPort(cfg.FSListenAddr)
+	if err != nil {
+		log.Warningf("failed to parse specified listen addr %s: %v", cfg.FSListenAddr, err)
+	}
+	log.Debugf("using %s as fs dir", cfg.FSDir)
+	if err := os.MkdirAll(cfg.FSDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create fs dir: %v", err)
+	}
+	if !fileNotEmpty(filepath.Join(cfg.FSDir, "swarm.key")) ||
+		!fileNotEmpty(filepath.Join(cfg.FSDir, "config")) {
+		return nil, errNotInitialized
+	}
+	log.WithFields(log.Fields{
+		"Dir":     cfg.FSDir,
+		"Host":    fsHost,
+		"Port":    fsPort,
+		"Profile": cfg.NetworkProfile,
+	}).Println("IPFS node warmup in progress")
+	fileStore, err := fs.NewPlanetaryFileStore(cfg.FSDir,
+		fs.UseBootstrapPeersOpt(cfg.BootstrapPeers),
+		fs.UseRelayOpt(cfg.RelayEnabled),
+		fs.UseAutoMigrateOpt(cfg.AutoMigrate),
+		fs.ListenHostOpt(fsHost),
+		fs.ListenPortOpt(fsPort),
+		fs.UseNetworkProfileOpt(fs.NetworkProfile(cfg.NetworkProfile)),
+		fs.UseBlocklistOpt(cfg.Blocklist, cfg.BlocklistRefresh),
+		fs.UseProxyOpt(cfg.SwarmProxy),
+		fs.UseIPv6Opt(cfg.SwarmIPv6),
+		fs.UseNATOpt(cfg.NAT),
+		fs.UseMmapCacheOpt(cfg.MmapCacheSize, cfg.MmapMinObjectSize, cfg.MmapHotReads),
+		fs.UseInlineOpt(stateStore, cfg.InlineMaxSize),
+		fs.UseDebtLimitOpt(cfg.BitswapMaxDebt, cfg.BitswapDebtRatio,
+			cfg.BitswapDebtCooldown, cfg.BitswapDebtExempt),
+		fs.UsePeerExchangeOpt(stateStore, cfg.PeerExchangeInterval, cfg.PeerExchangeTTL),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("NewPlanetaryFileStore failed: %v", err)
+	}
+	return fileStore, nil
+}
+
+// init creates the record store and the API context of the node.
+func (n *Node) init() error {
+	cfg := n.cfg
+	log.Println("Node ID:", n.ctx.NodeID())
+	log.Println("Session ID:", n.ctx.SessionID())
+	if cfg.Tracing != nil {
+		cfg.Tracing.NodeID = n.ctx.NodeID()
+		cfg.Tracing.ServiceVersion = cfg.Version
+	}
+	stopTracing, err := tracing.Init(cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	n.stopTracing = stopTracing
+	lookups := cache.New(cfg.Cache)
+	coldTier := cfg.ColdTier
+	if cfg.ColdStore != nil && coldTier != nil {
+		coldStore, err := fs.NewS3Store(cfg.ColdStore)
+		if err != nil {
+			return fmt.Errorf("failed to set up the cold tier: %v", err)
+		}
+		policy := *coldTier
+		policy.Store = coldStore
+		coldTier = &policy
+	}
+	store, err := rs.NewPlanetaryRecordStore(n.ctx.NodeID(), n.ctx.FileStore(), n.ctx.StateStore(),
+		rs.NamespacesOpt(cfg.Namespaces),
+		rs.DedupWindowOpt(cfg.DedupWindow),
+		rs.ClockOpt(cfg.NTPServer, cfg.ClockSkewWarn, cfg.ClockSkewMax, cfg.ClockRefuseWrites),
+		rs.GCOpt(cfg.GC),
+		rs.DiskBudgetOpt(cfg.DiskBudget),
+		rs.EncryptionOpt(cfg.Encryption),
+		rs.ConflictPolicyOpt(cfg.Conflicts),
+		rs.ReadOnlyOpt(cfg.ReadOnly),
+		rs.DryRunOpt(cfg.DryRun),
+		rs.VouchOpt(cfg.Vouch),
+		rs.SearchOpt(cfg.Search),
+		rs.LifecycleTTLOpt(cfg.LifecycleTTL),
+		rs.DeltaSyncOpt(cfg.FullResync, cfg.SyncMargin),
+		rs.WebhooksOpt(cfg.WebhookAttempts, cfg.WebhookDeadTTL),
+		rs.InboundWorkersOpt(cfg.InboundWorkers),
+		rs.CacheOpt(lookups),
+		rs.MirrorOpt(cfg.Mirror),
+		rs.PopularityOpt(cfg.PopularitySample),
+		rs.BeatPolicyOpt(cfg.Beats),
+		rs.ColdTierOpt(coldTier),
+	)
+	if err != nil {
+		return err
+	}
+	n.store = store
+	store.PublishLifecycle(n.ctx.SessionID(), rs.LifecycleStarting, cfg.Version)
+
+	cfg.EthAddress = strings.ToLower(cfg.EthAddress)
+	ethBackend := contracts.NewPoolBackend(n.ctx.SessionID(), cfg.Testnet)
+	if len(cfg.EthRPC) > 0 {
+		ethBackend = contracts.NewRPCBackend(cfg.EthRPC, cfg.Testnet)
+	}
+	mgr := contracts.NewManager(store, ethBackend, contracts.DryRunOpt(cfg.DryRun))
+	apiCtx := api.NewContext(n.ctx, store, mgr, cfg.EthAddress, cfg.LogDir)
+	apiCtx = apiCtx.WithBootstrapToken(cfg.BootstrapToken)
+	if cfg.Testnet && len(cfg.SwarmKey) > 0 {
+		apiCtx = apiCtx.WithJoinIssuer(&api.JoinIssuer{
+			SwarmKey:  cfg.SwarmKey,
+			Bootstrap: cfg.BootstrapPeers,
+		})
+	}
+	apiCtx = apiCtx.WithRouteTimeouts(cfg.RouteTimeouts)
+	apiCtx = apiCtx.WithProxyRoutes(cfg.ProxyRoutes)
+	apiCtx = apiCtx.WithClientHeader(cfg.ClientHeader)
+	apiCtx = apiCtx.WithUploadDir(cfg.UploadDir)
+	apiCtx = apiCtx.WithSRI(cfg.SRI)
+	apiCtx = apiCtx.WithNetworkStats(cfg.NetworkStats)
+	apiCtx = apiCtx.WithReadyMinPeers(cfg.ReadyMinPeers)
+	apiCtx = apiCtx.WithIdempotencyTTL(cfg.IdempotencyTTL)
+	apiCtx = apiCtx.WithDrain()
+	apiCtx = apiCtx.WithPlugins(cfg.Plugins)
+	if lookups != nil {
+		apiCtx = apiCtx.WithCache(lookups)
+	}
+	if cfg.Throttles != nil {
+		apiCtx = apiCtx.WithThrottles(cfg.Throttles)
+	}
+	if cfg.LoadShed != nil {
+		apiCtx = apiCtx.WithLoadShed(cfg.LoadShed)
+	}
+	if cfg.CORS != nil {
+		apiCtx = apiCtx.WithCORS(cfg.CORS)
+	}
+	if cfg.SwarmKeyInfo != nil {
+		apiCtx = apiCtx.WithSwarmKeyInfo(cfg.SwarmKeyInfo)
+	}
+	if cfg.Shadow != nil {
+		apiCtx = apiCtx.WithShadow(cfg.Shadow)
+		log.WithFields(log.Fields{
+			"target":   cfg.Shadow.Target.String(),
+			"fraction": cfg.Shadow.Fraction,
+		}).Println("mirroring read requests to the staging node")
+	}
+	if len(cfg.IngestNodes) > 0 {
+		apiCtx = apiCtx.WithIngestNodes(cfg.IngestNodes)
+	}
+	if cfg.Policy != nil {
+		apiCtx = apiCtx.WithPolicy(cfg.Policy)
+	}
+	if cfg.Gating != nil {
+		apiCtx = apiCtx.WithGating(cfg.Gating)
+	}
+	if folders, err := rs.NewSharedFolders(n.ctx.NodeID(), store, n.ctx.StateStore()); err != nil {
+		log.Warningln(err)
+	} else {
+		apiCtx = apiCtx.WithSharedFolders(folders)
+	}
+	privateToken, err := api.LoadPrivateToken(cfg.PrivateTokenFile)
+	if err != nil {
+		store.Close()
+		return fmt.Errorf("failed to load private API token: %v", err)
+	}
+	apiCtx = apiCtx.WithPrivateToken(privateToken)
+	if cfg.PresignedURLs {
+		presignKey, err := api.LoadPrivateToken(cfg.PresignKeyFile)
+		if err != nil {
+			store.Close()
+			return fmt.Errorf("failed to load the key of pre-signed URLs: %v", err)
+		}
+		apiCtx = apiCtx.WithURLSigner(api.NewURLSigner(presignKey))
+	}
+	apiCtx = apiCtx.WithSupport(cfg.SupportConfig)
+	n.shutdown = cfg.Shutdown
+	if n.shutdown == nil {
+		n.shutdown = func() {
+			go n.Stop()
+		}
+	}
+	n.apiCtx = apiCtx.WithShutdown(n.shutdown)
+	return nil
+}
+
+// Start serves the private API, syncs the node with peers, starts its background tasks
+// and then serves the public API. A node is started once.
+func (n *Node) Start() error {
+	err := errors.New("node has been started already")
+	n.startOnce.Do(func() {
+		err = n.start()
+	})
+	return err
+}
+
+func (n *Node) start() error {
+	cfg := n.cfg
+	n.privateServer = api.NewPrivateServer()
+	n.privateServer.RouteAPI(n.apiCtx)
+	privAddr, err := n.privateServer.Listen(cfg.PrivateListenAddr)
+	if err != nil {
+		return err
+	}
+	n.privateAddr = privAddr
+	peerAddr, err := n.privateServer.ListenPeers(cfg.PeerListenAddr)
+	if err != nil {
+		return err
+	}
+	if len(cfg.MetricsListenAddr) > 0 {
+		go func() {
+			err := n.privateServer.ListenMetrics(cfg.MetricsListenAddr)
+			if err != nil && err != http.ErrServerClosed {
+				log.Errorln("failed to serve metrics:", err)
+			}
+		}()
+	}
+	host, port, _ := net.SplitHostPort(peerAddr)
+	privMultiAddr := fmt.Sprintf("/ip4/%s/tcp/%s", host, port)
+	if err := n.ctx.FileStore().Listener().Listen(privMultiAddr); err != nil {
+		return err
+	}
+
+	time.Sleep(cfg.Warmup)
+	if replayed, err := n.store.ReplayOutbound(n.ctx); err != nil {
+		log.Warningf("failed to replay outbound journal: %v", err)
+	} else if replayed > 0 {
+		log.Infof("replaying %d unpublished record announces", replayed)
+	}
+	if err := n.store.Sync(); err != nil {
+		return err
+	}
+	ctx, cancelFn := context.WithCancel(n.ctx)
+	n.cancelFn = cancelFn
+	n.runTasks(ctx)
+
+	n.publicServer = api.NewPublicServer()
+	n.publicServer.RouteAPI(n.apiCtx)
+	go func() {
+		var err error
+		if cfg.TLS != nil {
+			log.Infoln("serving public API over TLS")
+			err = n.publicServer.ListenAndServeTLS(cfg.WebListenAddrs, cfg.TLS)
+		} else {
+			err = n.publicServer.ListenAndServe(cfg.WebListenAddrs)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorln("failed to serve public API:", err)
+			n.shutdown()
+		}
+	}()
+	if len(cfg.GRPCListenAddr) > 0 {
+		n.grpcServer = api.NewGRPCServer(n.apiCtx)
+		go func() {
+			err := n.grpcServer.ListenAndServe(cfg.GRPCListenAddr)
+			if err != nil && err != http.ErrServerClosed {
+				log.Errorln("failed to serve gRPC API:", err)
+			}
+		}()
+	}
+	n.store.PublishLifecycle(n.ctx.SessionID(), rs.LifecycleReady, cfg.Version)
+	return nil
+}
+
+// runTasks starts background tasks of the record store, they stop once ctx is done.
+func (n *Node) runTasks(ctx context.Context) {
+	cfg := n.cfg
+	store := n.store
+	if len(cfg.EthAddress) > 0 && len(cfg.EthAddress) < 64 {
+		go store.SendBeats(ctx, cfg.EthAddress)
+	}
+	// permissions of rotated identities pass through their links
+	if err := store.LoadIdentityLinks(ctx); err != nil {
+		log.Warningf("failed to load identity links: %v", err)
+	}
+	if cfg.ReadOnly {
+		log.Infoln("this node is read-only, local writes are refused")
+	} else if authcenter.HasPermissions(n.ctx.NodeID(), authcenter.RecordWritePermission) {
+		log.Infoln("this node has interplanetary write permissions")
+		go store.CommitBeatReports(ctx, cfg.BeatReportInterval)
+		go store.PublishManifests(ctx, cfg.ManifestInterval)
+	}
+	if cfg.DryRun {
+		log.Infoln("this node runs in dry-run mode, contract operations are only simulated")
+	}
+	go store.WatchPermissions(ctx, time.Minute)
+	go store.WatchVouches(ctx, 5*time.Minute)
+	go store.WatchTransfers(ctx, 5*time.Minute)
+	go store.WatchExpiries(ctx, time.Minute)
+	go store.WatchIdentityLinks(ctx, 5*time.Minute)
+	if cfg.JoinToken != nil {
+		go func() {
+			if err := store.Join(ctx, cfg.JoinToken); err != nil && ctx.Err() == nil {
+				log.WithField("issuer", cfg.JoinToken.Issuer).Warningf("failed to redeem join token: %v", err)
+			}
+		}()
+	}
+	go store.WatchWORM(ctx, 5*time.Minute)
+	go store.NegotiateProtocol(ctx, 10*time.Minute)
+	go store.MonitorClock(ctx, 15*time.Minute)
+	go store.EnforceRetention(ctx, cfg.RetentionInterval)
+	go store.ShareRateLimits(ctx, 5*time.Minute)
+	go store.TrackSLOs(ctx, 5*time.Minute)
+	go store.TrackPopularity(ctx)
+	go store.IndexRecords(ctx, cfg.SearchInterval)
+	go store.DeliverWebhooks(ctx)
+	go store.ScheduleTasks(ctx)
+	if cfg.ShardRepairInterval > 0 {
+		go store.RepairShards(ctx, cfg.ShardRepairInterval)
+	} else {
+		log.Warningln("shard repair is disabled, storage classes of namespaces are loaded on their changes only")
+	}
+	if cfg.GCInterval > 0 {
+		go store.RunGC(ctx, cfg.GCInterval)
+	}
+	if cfg.DiskBudget != nil && cfg.DiskBudget.MaxUsage > 0 {
+		go store.TrackDiskUsage(ctx, time.Minute)
+	}
+	go store.RunReindexJobs(ctx, cfg.ReindexRate)
+	if cfg.AntiEntropy != nil && cfg.AntiEntropy.Interval > 0 {
+		go store.RunAntiEntropy(ctx, cfg.AntiEntropy)
+	}
+	if cfg.RepinInterval > 0 {
+		go store.RepinLost(ctx, cfg.RepinInterval)
+	}
+	go store.RunMirror(ctx, cfg.MirrorInterval)
+	go store.RunColdTier(ctx)
+}
+
+// Stop drains the node and closes its servers and stores, it's safe to call more than once.
+func (n *Node) Stop() error {
+	var err error
+	n.stopOnce.Do(func() {
+		err = n.stop()
+	})
+	return err
+}
+
+func (n *Node) stop() error {
+	sessionID := n.ctx.SessionID()
+	deadline := time.Now().Add(n.cfg.DrainTimeout)
+	n.store.PublishLifecycle(sessionID, rs.LifecycleDraining, n.cfg.Version)
+	// writes are refused first, reads are served until announces of accepted writes are out
+	n.apiCtx.Drain()
+	log.WithField("timeout", n.cfg.DrainTimeout).Infoln("draining the node")
+	n.flushOutbound(deadline)
+	if n.publicServer != nil {
+		if err := n.publicServer.Close(); err != nil {
+			log.Warningln("failed to close public API:", err)
+		}
+	}
+	if n.grpcServer != nil {
+		if err := n.grpcServer.Close(); err != nil {
+			log.Warningln("failed to close gRPC API:", err)
+		}
+	}
+	log.Debugln("closing record store")
+	if err := n.store.Close(); err != nil {
+		log.Warningln(err)
+	}
+	log.Debugln("waiting for queues")
+	// workers get what's left of the drain timeout, but at least a moment to stop
+	wait := time.Until(deadline)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	wg := new(sync.WaitGroup)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		n.store.WaitInbound(wait)
+	}()
+	go func() {
+		defer wg.Done()
+		n.store.WaitOutbound(wait)
+	}()
+	wg.Wait()
+	n.store.PublishLifecycle(sessionID, rs.LifecycleStopped, n.cfg.Version)
+	if n.cancelFn != nil {
+		n.cancelFn()
+	}
+	if n.privateServer != nil {
+		if err := n.privateServer.Close(); err != nil {
+			log.Warningln("failed to close private API:", err)
+		}
+	}
+	if n.stopTracing != nil {
+		ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := n.stopTracing(ctx); err != nil {
+			log.Warningln("failed to flush traces:", err)
+		}
+		cancelFn()
+	}
+	var lastErr error
+	if err := n.ctx.FileStore().Close(); err != nil {
+		lastErr = fmt.Errorf("failed to close IPFS store: %v", err)
+		log.Warningln(lastErr)
+	}
+	if err := n.ctx.StateStore().Close(); err != nil {
+		lastErr = fmt.Errorf("failed to close the state store: %v", err)
+		log.Warningln(lastErr)
+	}
+	return lastErr
+}
+
+// flushOutbound waits until queued announces are published or the deadline passes,
+// announces left in the queue are kept in the outbound journal for the next start.
+func (n *Node) flushOutbound(deadline time.Time) {
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	for {
+		depth := n.store.QueueStats().OutboundDepth
+		if depth == 0 {
+			return
+		} else if time.Now().After(deadline) {
+			log.Warningf("drain timed out with %d announces queued, they're published on next start", depth)
+			return
+		}
+		<-t.C
+	}
+}
+
+// RecordStore returns the record store of the node.
+func (n *Node) RecordStore() rs.PlanetaryRecordStore {
+	return n.store
+}
+
+// APIContext returns the context the APIs of the node are served with.
+func (n *Node) APIContext() api.APIContext {
+	return n.apiCtx
+}
+
+func (n *Node) Context() Context {
+	return n.ctx
+}
+
+func (n *Node) NodeID() string {
+	return n.ctx.NodeID()
+}
+
+func (n *Node) SessionID() string {
+	return n.ctx.SessionID()
+}
+
+// Rebind re-creates Unix sockets of the public API and listens at its addresses that
+// failed to bind on start, see api.PublicServer.Rebind.
+func (n *Node) Rebind() error {
+	if n.publicServer == nil {
+		return nil
+	}
+	return n.publicServer.Rebind()
+}
+
+// PrivateAddr returns the address of the private API, it's empty until the node is started.
+func (n *Node) PrivateAddr() string {
+	return n.privateAddr
+}
+
+func fileNotEmpty(path string) bool {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false
+	} else if err != nil {
+		// unknown error, maybe exists
+		return true
+	}
+	return info.IsDir() || info.Size() > 0
+}
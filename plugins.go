@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/plugins"
+)
+
+const defaultPluginsDir = "var/plugins"
+
+// loadPlugins discovers plugins before the CLI is parsed, since they add commands to it,
+// so the dir is taken from the arguments or the environment directly.
+func loadPlugins() {
+	for _, err := range plugins.Load(pluginsDirArg(os.Args[1:])) {
+		log.Warningln(err)
+	}
+}
+
+func pluginsDirArg(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		} else if strings.HasPrefix(arg, "--plugins-dir=") {
+			return strings.TrimPrefix(arg, "--plugins-dir=")
+		} else if arg == "--plugins-dir" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	if dir := os.Getenv("AN_PLUGINS_DIR"); len(dir) > 0 {
+		return dir
+	}
+	return defaultPluginsDir
+}
+
+func pluginsCmd(c *cli.Cmd) {
+	asJSON := c.Bool(cli.BoolOpt{
+		Name:  "json",
+		Desc:  "Print plugins as JSON.",
+		Value: false,
+	})
+	c.Action = func() {
+		list := plugins.List()
+		if *asJSON {
+			data, err := json.Marshal(list)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			printJSON(data)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tKIND\tROUTES\tCOMMANDS\tPATH")
+		for _, p := range list {
+			names := make([]string, 0, len(p.Commands))
+			for _, cmd := range p.Commands {
+				names = append(names, cmd.Name)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%s\n", p.Name, p.Kind, p.HasRoutes, strings.Join(names, ","), p.Path)
+		}
+		w.Flush()
+	}
+}
+
+func ctlPluginsCmd(c *cli.Cmd) {
+	c.Action = func() {
+		body, err := ctlRequest("GET", "/private/v1/plugins", time.Minute)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printJSON(body)
+	}
+}
@@ -0,0 +1,108 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/cgi"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/api"
+)
+
+// Subprocess plugins are executables in the plugins dir that take a verb:
+//
+//	describe               prints the manifest of the plugin as JSON
+//	run <command> [ARGS]   runs a command, stdio and the exit code pass through
+//	serve                  serves a request of the private API over CGI (RFC 3875)
+//
+// Each request is served by a new process, so routes of subprocess plugins suit management
+// calls rather than traffic. The token of the private API is not passed to plugins.
+
+const describeTimeout = 5 * time.Second
+
+type manifest struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Desc     string `json:"desc"`
+	Commands []struct {
+		Name string `json:"name"`
+		Desc string `json:"desc"`
+	} `json:"commands"`
+	Routes bool `json:"routes"`
+}
+
+func loadExec(path string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), describeTimeout)
+	defer cancelFn()
+	out, err := exec.CommandContext(ctx, path, "describe").Output()
+	if err != nil {
+		return fmt.Errorf("describe failed: %v", err)
+	}
+	var m manifest
+	if err := json.Unmarshal(out, &m); err != nil {
+		return fmt.Errorf("bad manifest: %v", err)
+	}
+	p := &Plugin{
+		Name:    m.Name,
+		Version: m.Version,
+		Desc:    m.Desc,
+		Kind:    KindExec,
+		Path:    path,
+	}
+	for _, cmd := range m.Commands {
+		p.Commands = append(p.Commands, Command{
+			Name: cmd.Name,
+			Desc: cmd.Desc,
+			Init: execCommand(path, cmd.Name),
+		})
+	}
+	if m.Routes {
+		p.Routes = execRoutes(path, m.Name)
+	}
+	return register(p)
+}
+
+func execCommand(path, name string) cli.CmdInitializer {
+	return func(c *cli.Cmd) {
+		c.Spec = "[ARGS...]"
+		args := c.StringsArg("ARGS", nil, "Arguments of the command, options go after --.")
+		c.Action = func() {
+			cmd := exec.Command(path, append([]string{"run", name}, *args...)...)
+			cmd.Stdin = os.Stdin
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					os.Exit(exitErr.ExitCode())
+				}
+				log.Fatalln(err)
+			}
+		}
+	}
+}
+
+func execRoutes(path, name string) func(r gin.IRoutes, ctx api.APIContext) {
+	return func(r gin.IRoutes, ctx api.APIContext) {
+		h := &cgi.Handler{
+			Path: path,
+			Args: []string{"serve"},
+			Root: "/private/v1/plugins/" + name,
+			Env: []string{
+				"AN_NODE_ID=" + ctx.NodeID(),
+				"AN_SESSION_ID=" + ctx.SessionID(),
+			},
+		}
+		r.Any("/*path", func(c *gin.Context) {
+			req := c.Request.Clone(c.Request.Context())
+			req.Header.Del("Authorization")
+			h.ServeHTTP(c.Writer, req)
+		})
+	}
+}
@@ -0,0 +1,197 @@
+// Package plugins lets features ship without forking the node: a plugin adds commands to
+// the CLI and routes to the private API. Plugins are compiled in and register themselves
+// in init, like the testing commands do, or they are discovered at startup in the plugins
+// dir: Go plugins (.so) built against the same tree register themselves the same way once
+// opened, and executables are run as subprocess plugins, see exec.go.
+//
+// Commands of a plugin are named <plugin>-<command>, so they never shadow the commands of
+// the node, and its routes are served under /private/v1/plugins/<plugin>/ behind the token
+// of the private API.
+package plugins
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jawher/mow.cli"
+
+	"github.com/AtlantPlatform/atlant-go/api"
+)
+
+const (
+	KindBuiltin = "builtin"
+	KindShared  = "so"
+	KindExec    = "exec"
+)
+
+// Command is a CLI command of a plugin.
+type Command struct {
+	Name string `json:"name"`
+	Desc string `json:"desc"`
+	Init cli.CmdInitializer `json:"-"`
+}
+
+// Plugin bundles commands and routes, either may be empty.
+type Plugin struct {
+	Name     string    `json:"name"`
+	Version  string    `json:"version,omitempty"`
+	Desc     string    `json:"desc,omitempty"`
+	Kind     string    `json:"kind"`
+	Path     string    `json:"path,omitempty"`
+	Commands []Command `json:"commands,omitempty"`
+	// Routes mounts handlers of the plugin, paths are relative to /private/v1/plugins/<name>.
+	Routes func(r gin.IRoutes, ctx api.APIContext) `json:"-"`
+	// HasRoutes is set on registration, for listings.
+	HasRoutes bool `json:"routes"`
+}
+
+// PluginName implements api.Plugin.
+func (p *Plugin) PluginName() string {
+	return p.Name
+}
+
+// MountRoutes implements api.Plugin.
+func (p *Plugin) MountRoutes(r gin.IRoutes, ctx api.APIContext) {
+	if p.Routes != nil {
+		p.Routes(r, ctx)
+	}
+}
+
+var nameRx = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+var registry = struct {
+	mux     *sync.Mutex
+	plugins map[string]*Plugin
+	// kind and path are assigned to plugins registered while a file is opened
+	kind string
+	path string
+}{
+	mux:     new(sync.Mutex),
+	plugins: make(map[string]*Plugin),
+	kind:    KindBuiltin,
+}
+
+// Register adds a plugin, it's called from init of packages and Go plugins. It panics if
+// the plugin is invalid or its name is taken, as database/sql drivers do.
+func Register(p *Plugin) {
+	if err := register(p); err != nil {
+		panic(err)
+	}
+}
+
+func register(p *Plugin) error {
+	if err := validate(p); err != nil {
+		return err
+	}
+	registry.mux.Lock()
+	defer registry.mux.Unlock()
+	if prev, ok := registry.plugins[p.Name]; ok {
+		return fmt.Errorf("plugin %s is registered already by %s", p.Name, describe(prev))
+	}
+	if len(p.Kind) == 0 {
+		p.Kind = registry.kind
+		p.Path = registry.path
+	}
+	p.HasRoutes = p.Routes != nil
+	registry.plugins[p.Name] = p
+	return nil
+}
+
+func validate(p *Plugin) error {
+	if !nameRx.MatchString(p.Name) {
+		return fmt.Errorf("plugin name %q is invalid: use lowercase letters and digits", p.Name)
+	}
+	seen := make(map[string]bool, len(p.Commands))
+	for _, cmd := range p.Commands {
+		if !strings.HasPrefix(cmd.Name, p.Name+"-") || len(cmd.Name) == len(p.Name)+1 {
+			return fmt.Errorf("plugin %s has command %q not named %s-<command>", p.Name, cmd.Name, p.Name)
+		} else if seen[cmd.Name] {
+			return fmt.Errorf("plugin %s has command %s twice", p.Name, cmd.Name)
+		} else if cmd.Init == nil {
+			return fmt.Errorf("plugin %s has command %s without init", p.Name, cmd.Name)
+		}
+		seen[cmd.Name] = true
+	}
+	return nil
+}
+
+func describe(p *Plugin) string {
+	if len(p.Path) > 0 {
+		return p.Path
+	}
+	return p.Kind
+}
+
+// List returns registered plugins by name.
+func List() []*Plugin {
+	registry.mux.Lock()
+	defer registry.mux.Unlock()
+	list := make([]*Plugin, 0, len(registry.plugins))
+	for _, p := range registry.plugins {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// APIPlugins returns registered plugins for api.APIContext.WithPlugins.
+func APIPlugins() []api.Plugin {
+	list := List()
+	result := make([]api.Plugin, 0, len(list))
+	for _, p := range list {
+		result = append(result, p)
+	}
+	return result
+}
+
+// Load discovers plugins in dir: Go plugins are opened and executables are described, a
+// missing dir has no plugins. Plugins that fail to load are reported and skipped.
+func Load(dir string) []error {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		if filepath.Ext(path) == ".so" {
+			if err := loadShared(path); err != nil {
+				errs = append(errs, fmt.Errorf("failed to load plugin %s: %v", path, err))
+			}
+			continue
+		} else if info.Mode()&0111 == 0 {
+			continue
+		}
+		if err := loadExec(path); err != nil {
+			errs = append(errs, fmt.Errorf("failed to load plugin %s: %v", path, err))
+		}
+	}
+	return errs
+}
+
+// loadShared opens a Go plugin, plugins it registers in init are marked as loaded from path.
+func loadShared(path string) error {
+	registry.mux.Lock()
+	registry.kind, registry.path = KindShared, path
+	registry.mux.Unlock()
+	defer func() {
+		registry.mux.Lock()
+		registry.kind, registry.path = KindBuiltin, ""
+		registry.mux.Unlock()
+	}()
+	return openShared(path)
+}
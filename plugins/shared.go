@@ -0,0 +1,12 @@
+//+build cgo
+//+build linux darwin
+
+package plugins
+
+import "plugin"
+
+// openShared opens a Go plugin, it registers itself in init.
+func openShared(path string) error {
+	_, err := plugin.Open(path)
+	return err
+}
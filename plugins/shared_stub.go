@@ -0,0 +1,9 @@
+//+build !cgo !linux,!darwin
+
+package plugins
+
+import "errors"
+
+func openShared(path string) error {
+	return errors.New("Go plugins are not supported by this build")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/api"
+)
+
+func privateTokenCmd(c *cli.Cmd) {
+	rotate := c.Bool(cli.BoolOpt{
+		Name:  "rotate",
+		Desc:  "Replace the token, tools using the old one are refused.",
+		Value: false,
+	})
+	c.Action = func() {
+		if !*rotate {
+			token, err := api.LoadPrivateToken(privateTokenPath())
+			if err != nil {
+				log.Fatalln("failed to load private API token:", err)
+			}
+			fmt.Fprintln(os.Stdout, token.String())
+			return
+		}
+		token, err := rotateNodeToken()
+		if err != nil {
+			log.Warningln("failed to rotate the token of the running node, rotating the file:", err)
+			t, err := api.LoadPrivateToken(privateTokenPath())
+			if err != nil {
+				log.Fatalln("failed to load private API token:", err)
+			}
+			if token, err = t.Rotate(); err != nil {
+				log.Fatalln("failed to rotate private API token:", err)
+			}
+		}
+		fmt.Fprintln(os.Stdout, token)
+	}
+}
+
+// rotateNodeToken asks the running node to rotate its token, so it applies right away.
+func rotateNodeToken() (string, error) {
+	info, err := readPrivateAPIFile()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/private/v1/token/rotate", info.Addr), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+info.Token)
+	resp, err := (&http.Client{Timeout: time.Minute}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	} else if resp.StatusCode != 200 {
+		return "", fmt.Errorf("node responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", err
+	}
+	return result.Token, nil
+}
@@ -248,3 +248,12 @@ func UnpackEnvelopeRecordUpdate(data []byte) (EnvelopeRecordUpdate, error) {
 	v := ReadRootEnvelopeRecordUpdate(seg)
 	return v, nil
 }
+
+func UnpackEnvelopeNodeLifecycle(data []byte) (EnvelopeNodeLifecycle, error) {
+	seg, err := capn.ReadFromPackedStream(bytes.NewReader(data), nil)
+	if err != nil {
+		return EnvelopeNodeLifecycle{}, err
+	}
+	v := ReadRootEnvelopeNodeLifecycle(seg)
+	return v, nil
+}
@@ -0,0 +1,102 @@
+//+build gofuzz testing
+
+package proto
+
+import (
+	"bytes"
+	"io"
+
+	capn "github.com/glycerine/go-capnproto"
+)
+
+// Fuzz entry points follow go-fuzz: they return 1 if the input is worth keeping in the
+// corpus, 0 otherwise, and panic on crashers. They're built with -tags gofuzz for go-fuzz
+// and with -tags testing for the test-fuzz command.
+
+// FuzzAnnounce decodes a packed announce the way gossip messages are decoded, along
+// with the envelope of its type.
+func FuzzAnnounce(data []byte) int {
+	ann, err := UnpackAnnounce(data)
+	if err != nil {
+		return 0
+	}
+	if _, err := ann.MarshalJSON(); err != nil {
+		return 0
+	}
+	envelope := ann.Envelope()
+	switch ann.Type() {
+	case ANNOUNCETYPE_BEATTICK:
+		v, err := UnpackEnvelopeBeatTick(envelope)
+		if err != nil {
+			return 0
+		}
+		_, err = v.MarshalJSON()
+	case ANNOUNCETYPE_BEATINFO:
+		v, err := UnpackEnvelopeBeatInfo(envelope)
+		if err != nil {
+			return 0
+		}
+		_, err = v.MarshalJSON()
+	case ANNOUNCETYPE_RECORDUPDATE:
+		v, err := UnpackEnvelopeRecordUpdate(envelope)
+		if err != nil {
+			return 0
+		}
+		_, err = v.MarshalJSON()
+	case ANNOUNCETYPE_NODELIFECYCLE:
+		v, err := UnpackEnvelopeNodeLifecycle(envelope)
+		if err != nil {
+			return 0
+		}
+		_, err = v.MarshalJSON()
+	default:
+		return 0
+	}
+	if err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzRecord decodes a stream of records the way anti-entropy sync reads them from peers.
+func FuzzRecord(data []byte) int {
+	rd := bytes.NewReader(data)
+	var read int
+	for {
+		seg, err := capn.ReadFromStream(rd, nil)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0
+		}
+		record := ReadRootRecord(seg)
+		if _, err := record.MarshalJSON(); err != nil {
+			return 0
+		}
+		for _, ver := range record.Previous().ToArray() {
+			ver.Version()
+			ver.Announce().Envelope()
+		}
+		if _, err := record.AnnounceEnvelope(); err != nil {
+			return 0
+		}
+		read++
+	}
+	if read == 0 {
+		return 0
+	}
+	return 1
+}
+
+// FuzzObjectMeta decodes packed object metadata the way it's read from IPFS.
+func FuzzObjectMeta(data []byte) int {
+	seg, err := capn.ReadFromPackedStream(bytes.NewReader(data), nil)
+	if err != nil {
+		return 0
+	}
+	meta := ReadRootObjectMeta(seg)
+	if _, err := meta.MarshalJSON(); err != nil {
+		return 0
+	}
+	return 1
+}
@@ -99,3 +99,11 @@ func AppendRecordVersion(list RecordVersion_List, ver RecordVersion) RecordVersi
 	newList.Set(list.Len(), ver)
 	return newList
 }
+
+func NewRecordVersionListFrom(versions []RecordVersion) RecordVersion_List {
+	newList := NewRecordVersionList(capn.NewBuffer(nil), len(versions))
+	for i := range versions {
+		newList.Set(i, versions[i])
+	}
+	return newList
+}
@@ -423,25 +423,30 @@ func (s RecordVersion_List) Set(i int, item RecordVersion) { C.PointerList(s).Se
 
 type Announce C.Struct
 
-func NewAnnounce(s *C.Segment) Announce      { return Announce(s.NewStruct(16, 4)) }
-func NewRootAnnounce(s *C.Segment) Announce  { return Announce(s.NewRootStruct(16, 4)) }
-func AutoNewAnnounce(s *C.Segment) Announce  { return Announce(s.NewStructAR(16, 4)) }
-func ReadRootAnnounce(s *C.Segment) Announce { return Announce(s.Root(0).ToStruct()) }
-func (s Announce) Id() string                { return C.Struct(s).GetObject(0).ToText() }
-func (s Announce) IdBytes() []byte           { return C.Struct(s).GetObject(0).ToDataTrimLastByte() }
-func (s Announce) SetId(v string)            { C.Struct(s).SetObject(0, s.Segment.NewText(v)) }
-func (s Announce) NodeID() string            { return C.Struct(s).GetObject(1).ToText() }
-func (s Announce) NodeIDBytes() []byte       { return C.Struct(s).GetObject(1).ToDataTrimLastByte() }
-func (s Announce) SetNodeID(v string)        { C.Struct(s).SetObject(1, s.Segment.NewText(v)) }
-func (s Announce) Signature() string         { return C.Struct(s).GetObject(2).ToText() }
-func (s Announce) SignatureBytes() []byte    { return C.Struct(s).GetObject(2).ToDataTrimLastByte() }
-func (s Announce) SetSignature(v string)     { C.Struct(s).SetObject(2, s.Segment.NewText(v)) }
-func (s Announce) Timestamp() int64          { return int64(C.Struct(s).Get64(0)) }
-func (s Announce) SetTimestamp(v int64)      { C.Struct(s).Set64(0, uint64(v)) }
-func (s Announce) Type() AnnounceType        { return AnnounceType(C.Struct(s).Get16(8)) }
-func (s Announce) SetType(v AnnounceType)    { C.Struct(s).Set16(8, uint16(v)) }
-func (s Announce) Envelope() []byte          { return C.Struct(s).GetObject(3).ToData() }
-func (s Announce) SetEnvelope(v []byte)      { C.Struct(s).SetObject(3, s.Segment.NewData(v)) }
+func NewAnnounce(s *C.Segment) Announce        { return Announce(s.NewStruct(16, 5)) }
+func NewRootAnnounce(s *C.Segment) Announce    { return Announce(s.NewRootStruct(16, 5)) }
+func AutoNewAnnounce(s *C.Segment) Announce    { return Announce(s.NewStructAR(16, 5)) }
+func ReadRootAnnounce(s *C.Segment) Announce   { return Announce(s.Root(0).ToStruct()) }
+func (s Announce) Id() string                  { return C.Struct(s).GetObject(0).ToText() }
+func (s Announce) IdBytes() []byte             { return C.Struct(s).GetObject(0).ToDataTrimLastByte() }
+func (s Announce) SetId(v string)              { C.Struct(s).SetObject(0, s.Segment.NewText(v)) }
+func (s Announce) NodeID() string              { return C.Struct(s).GetObject(1).ToText() }
+func (s Announce) NodeIDBytes() []byte         { return C.Struct(s).GetObject(1).ToDataTrimLastByte() }
+func (s Announce) SetNodeID(v string)          { C.Struct(s).SetObject(1, s.Segment.NewText(v)) }
+func (s Announce) Signature() string           { return C.Struct(s).GetObject(2).ToText() }
+func (s Announce) SignatureBytes() []byte      { return C.Struct(s).GetObject(2).ToDataTrimLastByte() }
+func (s Announce) SetSignature(v string)       { C.Struct(s).SetObject(2, s.Segment.NewText(v)) }
+func (s Announce) Timestamp() int64            { return int64(C.Struct(s).Get64(0)) }
+func (s Announce) SetTimestamp(v int64)        { C.Struct(s).Set64(0, uint64(v)) }
+func (s Announce) Type() AnnounceType          { return AnnounceType(C.Struct(s).Get16(8)) }
+func (s Announce) SetType(v AnnounceType)      { C.Struct(s).Set16(8, uint16(v)) }
+func (s Announce) Envelope() []byte            { return C.Struct(s).GetObject(3).ToData() }
+func (s Announce) SetEnvelope(v []byte)        { C.Struct(s).SetObject(3, s.Segment.NewData(v)) }
+func (s Announce) ProtocolVersion() uint16     { return C.Struct(s).Get16(10) }
+func (s Announce) SetProtocolVersion(v uint16) { C.Struct(s).Set16(10, v) }
+func (s Announce) TraceParent() string         { return C.Struct(s).GetObject(4).ToText() }
+func (s Announce) TraceParentBytes() []byte    { return C.Struct(s).GetObject(4).ToDataTrimLastByte() }
+func (s Announce) SetTraceParent(v string)     { C.Struct(s).SetObject(4, s.Segment.NewText(v)) }
 func (s Announce) WriteJSON(w io.Writer) error {
 	b := bufio.NewWriter(w)
 	var err error
@@ -557,6 +562,44 @@ func (s Announce) WriteJSON(w io.Writer) error {
 			return err
 		}
 	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"protocolVersion\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ProtocolVersion()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"traceParent\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.TraceParent()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte('}')
 	if err != nil {
 		return err
@@ -684,6 +727,44 @@ func (s Announce) WriteCapLit(w io.Writer) error {
 			return err
 		}
 	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("protocolVersion = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ProtocolVersion()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("traceParent = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.TraceParent()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte(')')
 	if err != nil {
 		return err
@@ -700,7 +781,7 @@ func (s Announce) MarshalCapLit() ([]byte, error) {
 type Announce_List C.PointerList
 
 func NewAnnounceList(s *C.Segment, sz int) Announce_List {
-	return Announce_List(s.NewCompositeList(16, 4, sz))
+	return Announce_List(s.NewCompositeList(16, 5, sz))
 }
 func (s Announce_List) Len() int          { return C.PointerList(s).Len() }
 func (s Announce_List) At(i int) Announce { return Announce(C.PointerList(s).At(i).ToStruct()) }
@@ -717,10 +798,11 @@ func (s Announce_List) Set(i int, item Announce) { C.PointerList(s).Set(i, C.Obj
 type AnnounceType uint16
 
 const (
-	ANNOUNCETYPE_UNKNOWN      AnnounceType = 0
-	ANNOUNCETYPE_BEATTICK     AnnounceType = 1
-	ANNOUNCETYPE_BEATINFO     AnnounceType = 2
-	ANNOUNCETYPE_RECORDUPDATE AnnounceType = 3
+	ANNOUNCETYPE_UNKNOWN       AnnounceType = 0
+	ANNOUNCETYPE_BEATTICK      AnnounceType = 1
+	ANNOUNCETYPE_BEATINFO      AnnounceType = 2
+	ANNOUNCETYPE_RECORDUPDATE  AnnounceType = 3
+	ANNOUNCETYPE_NODELIFECYCLE AnnounceType = 4
 )
 
 func (c AnnounceType) String() string {
@@ -733,6 +815,8 @@ func (c AnnounceType) String() string {
 		return "beatInfo"
 	case ANNOUNCETYPE_RECORDUPDATE:
 		return "recordUpdate"
+	case ANNOUNCETYPE_NODELIFECYCLE:
+		return "nodeLifecycle"
 	default:
 		return ""
 	}
@@ -748,6 +832,8 @@ func AnnounceTypeFromString(c string) AnnounceType {
 		return ANNOUNCETYPE_BEATINFO
 	case "recordUpdate":
 		return ANNOUNCETYPE_RECORDUPDATE
+	case "nodeLifecycle":
+		return ANNOUNCETYPE_NODELIFECYCLE
 	default:
 		return 0
 	}
@@ -960,12 +1046,12 @@ func (s EnvelopeBeatTick_List) Set(i int, item EnvelopeBeatTick) {
 
 type EnvelopeBeatInfo C.Struct
 
-func NewEnvelopeBeatInfo(s *C.Segment) EnvelopeBeatInfo { return EnvelopeBeatInfo(s.NewStruct(24, 3)) }
+func NewEnvelopeBeatInfo(s *C.Segment) EnvelopeBeatInfo { return EnvelopeBeatInfo(s.NewStruct(40, 3)) }
 func NewRootEnvelopeBeatInfo(s *C.Segment) EnvelopeBeatInfo {
-	return EnvelopeBeatInfo(s.NewRootStruct(24, 3))
+	return EnvelopeBeatInfo(s.NewRootStruct(40, 3))
 }
 func AutoNewEnvelopeBeatInfo(s *C.Segment) EnvelopeBeatInfo {
-	return EnvelopeBeatInfo(s.NewStructAR(24, 3))
+	return EnvelopeBeatInfo(s.NewStructAR(40, 3))
 }
 func ReadRootEnvelopeBeatInfo(s *C.Segment) EnvelopeBeatInfo {
 	return EnvelopeBeatInfo(s.Root(0).ToStruct())
@@ -987,6 +1073,10 @@ func (s EnvelopeBeatInfo) InboundWork() uint64      { return C.Struct(s).Get64(8
 func (s EnvelopeBeatInfo) SetInboundWork(v uint64)  { C.Struct(s).Set64(8, v) }
 func (s EnvelopeBeatInfo) OutboundWork() uint64     { return C.Struct(s).Get64(16) }
 func (s EnvelopeBeatInfo) SetOutboundWork(v uint64) { C.Struct(s).Set64(16, v) }
+func (s EnvelopeBeatInfo) BytesServed() uint64      { return C.Struct(s).Get64(24) }
+func (s EnvelopeBeatInfo) SetBytesServed(v uint64)  { C.Struct(s).Set64(24, v) }
+func (s EnvelopeBeatInfo) BytesPinned() uint64      { return C.Struct(s).Get64(32) }
+func (s EnvelopeBeatInfo) SetBytesPinned(v uint64)  { C.Struct(s).Set64(32, v) }
 func (s EnvelopeBeatInfo) WriteJSON(w io.Writer) error {
 	b := bufio.NewWriter(w)
 	var err error
@@ -1106,6 +1196,44 @@ func (s EnvelopeBeatInfo) WriteJSON(w io.Writer) error {
 			return err
 		}
 	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"bytesServed\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.BytesServed()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"bytesPinned\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.BytesPinned()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte('}')
 	if err != nil {
 		return err
@@ -1237,6 +1365,44 @@ func (s EnvelopeBeatInfo) WriteCapLit(w io.Writer) error {
 			return err
 		}
 	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("bytesServed = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.BytesServed()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("bytesPinned = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.BytesPinned()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte(')')
 	if err != nil {
 		return err
@@ -1253,7 +1419,7 @@ func (s EnvelopeBeatInfo) MarshalCapLit() ([]byte, error) {
 type EnvelopeBeatInfo_List C.PointerList
 
 func NewEnvelopeBeatInfoList(s *C.Segment, sz int) EnvelopeBeatInfo_List {
-	return EnvelopeBeatInfo_List(s.NewCompositeList(24, 3, sz))
+	return EnvelopeBeatInfo_List(s.NewCompositeList(40, 3, sz))
 }
 func (s EnvelopeBeatInfo_List) Len() int { return C.PointerList(s).Len() }
 func (s EnvelopeBeatInfo_List) At(i int) EnvelopeBeatInfo {
@@ -1467,3 +1633,283 @@ func (s EnvelopeRecordUpdate_List) ToArray() []EnvelopeRecordUpdate {
 func (s EnvelopeRecordUpdate_List) Set(i int, item EnvelopeRecordUpdate) {
 	C.PointerList(s).Set(i, C.Object(item))
 }
+
+type EnvelopeNodeLifecycle C.Struct
+
+func NewEnvelopeNodeLifecycle(s *C.Segment) EnvelopeNodeLifecycle {
+	return EnvelopeNodeLifecycle(s.NewStruct(8, 4))
+}
+func NewRootEnvelopeNodeLifecycle(s *C.Segment) EnvelopeNodeLifecycle {
+	return EnvelopeNodeLifecycle(s.NewRootStruct(8, 4))
+}
+func AutoNewEnvelopeNodeLifecycle(s *C.Segment) EnvelopeNodeLifecycle {
+	return EnvelopeNodeLifecycle(s.NewStructAR(8, 4))
+}
+func ReadRootEnvelopeNodeLifecycle(s *C.Segment) EnvelopeNodeLifecycle {
+	return EnvelopeNodeLifecycle(s.Root(0).ToStruct())
+}
+func (s EnvelopeNodeLifecycle) Id() string      { return C.Struct(s).GetObject(0).ToText() }
+func (s EnvelopeNodeLifecycle) IdBytes() []byte { return C.Struct(s).GetObject(0).ToDataTrimLastByte() }
+func (s EnvelopeNodeLifecycle) SetId(v string)  { C.Struct(s).SetObject(0, s.Segment.NewText(v)) }
+func (s EnvelopeNodeLifecycle) Session() string { return C.Struct(s).GetObject(1).ToText() }
+func (s EnvelopeNodeLifecycle) SessionBytes() []byte {
+	return C.Struct(s).GetObject(1).ToDataTrimLastByte()
+}
+func (s EnvelopeNodeLifecycle) SetSession(v string) { C.Struct(s).SetObject(1, s.Segment.NewText(v)) }
+func (s EnvelopeNodeLifecycle) State() string       { return C.Struct(s).GetObject(2).ToText() }
+func (s EnvelopeNodeLifecycle) StateBytes() []byte {
+	return C.Struct(s).GetObject(2).ToDataTrimLastByte()
+}
+func (s EnvelopeNodeLifecycle) SetState(v string) { C.Struct(s).SetObject(2, s.Segment.NewText(v)) }
+func (s EnvelopeNodeLifecycle) Version() string   { return C.Struct(s).GetObject(3).ToText() }
+func (s EnvelopeNodeLifecycle) VersionBytes() []byte {
+	return C.Struct(s).GetObject(3).ToDataTrimLastByte()
+}
+func (s EnvelopeNodeLifecycle) SetVersion(v string)   { C.Struct(s).SetObject(3, s.Segment.NewText(v)) }
+func (s EnvelopeNodeLifecycle) UptimeUnix() int64     { return int64(C.Struct(s).Get64(0)) }
+func (s EnvelopeNodeLifecycle) SetUptimeUnix(v int64) { C.Struct(s).Set64(0, uint64(v)) }
+func (s EnvelopeNodeLifecycle) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"id\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Id()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"session\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Session()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"state\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.State()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"version\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"uptimeUnix\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.UptimeUnix()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s EnvelopeNodeLifecycle) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s EnvelopeNodeLifecycle) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("id = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Id()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("session = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Session()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("state = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.State()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("version = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("uptimeUnix = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.UptimeUnix()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s EnvelopeNodeLifecycle) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type EnvelopeNodeLifecycle_List C.PointerList
+
+func NewEnvelopeNodeLifecycleList(s *C.Segment, sz int) EnvelopeNodeLifecycle_List {
+	return EnvelopeNodeLifecycle_List(s.NewCompositeList(8, 4, sz))
+}
+func (s EnvelopeNodeLifecycle_List) Len() int { return C.PointerList(s).Len() }
+func (s EnvelopeNodeLifecycle_List) At(i int) EnvelopeNodeLifecycle {
+	return EnvelopeNodeLifecycle(C.PointerList(s).At(i).ToStruct())
+}
+func (s EnvelopeNodeLifecycle_List) ToArray() []EnvelopeNodeLifecycle {
+	n := s.Len()
+	a := make([]EnvelopeNodeLifecycle, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s EnvelopeNodeLifecycle_List) Set(i int, item EnvelopeNodeLifecycle) {
+	C.PointerList(s).Set(i, C.Object(item))
+}
@@ -0,0 +1,151 @@
+// Package proxy routes outbound HTTP requests of the node to external services: Ethereum RPC,
+// DNS-over-HTTPS lookups of the authority and webhook deliveries. Requests honor HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY of the environment unless a proxy is configured explicitly.
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	mux       = new(sync.RWMutex)
+	transport = newTransport(http.ProxyFromEnvironment)
+	current   = "environment"
+)
+
+// Configure routes outbound requests through the proxy at proxyURL, an http, https or socks5
+// URL. Hosts matching noProxy entries are reached directly, an entry is a host name, a domain
+// suffix like .internal or a CIDR. An empty proxyURL restores proxy settings of the environment.
+func Configure(proxyURL string, noProxy []string) error {
+	if len(proxyURL) == 0 {
+		mux.Lock()
+		transport = newTransport(http.ProxyFromEnvironment)
+		current = "environment"
+		mux.Unlock()
+		return nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil || len(u.Host) == 0 {
+		return fmt.Errorf("malformed proxy URL: %s", proxyURL)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+	bypass := parseNoProxy(noProxy)
+	fn := func(req *http.Request) (*url.URL, error) {
+		if bypass.match(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return u, nil
+	}
+	mux.Lock()
+	transport = newTransport(fn)
+	current = redact(u)
+	mux.Unlock()
+	return nil
+}
+
+// Describe returns the configured proxy without credentials, or "environment".
+func Describe() string {
+	mux.RLock()
+	defer mux.RUnlock()
+	return current
+}
+
+// Transport returns a round tripper that follows the proxy settings, including later changes.
+func Transport() http.RoundTripper {
+	return roundTripper{}
+}
+
+// Client returns a client of external services with the given timeout, zero means none.
+func Client(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: Transport(),
+		Timeout:   timeout,
+	}
+}
+
+type roundTripper struct{}
+
+func (roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	mux.RLock()
+	t := transport
+	mux.RUnlock()
+	return t.RoundTrip(req)
+}
+
+// newTransport has the settings of http.DefaultTransport.
+func newTransport(proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+func redact(u *url.URL) string {
+	c := *u
+	if c.User != nil {
+		c.User = url.User("xxx")
+	}
+	return c.String()
+}
+
+type noProxyList struct {
+	hosts    map[string]struct{}
+	suffixes []string
+	nets     []*net.IPNet
+}
+
+func parseNoProxy(entries []string) *noProxyList {
+	l := &noProxyList{
+		hosts: make(map[string]struct{}),
+	}
+	for _, e := range entries {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if len(e) == 0 {
+			continue
+		} else if _, n, err := net.ParseCIDR(e); err == nil {
+			l.nets = append(l.nets, n)
+		} else if strings.HasPrefix(e, ".") {
+			l.suffixes = append(l.suffixes, e)
+		} else {
+			l.hosts[e] = struct{}{}
+		}
+	}
+	return l
+}
+
+func (l *noProxyList) match(host string) bool {
+	host = strings.ToLower(host)
+	if _, ok := l.hosts[host]; ok {
+		return true
+	}
+	for _, s := range l.suffixes {
+		if strings.HasSuffix(host, s) {
+			return true
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, n := range l.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
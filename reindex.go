@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+func rsCmd(c *cli.Cmd) {
+	c.Command("reindex", "Rebuild secondary indexes of records on the running node.", rsReindexCmd)
+}
+
+func rsReindexCmd(c *cli.Cmd) {
+	c.Spec = "[--index...] [--wait]"
+	indexes := c.Strings(cli.StringsOpt{
+		Name:  "i index",
+		Desc:  "Index to rebuild: namespace or search, all indexes by default.",
+		Value: nil,
+	})
+	wait := c.Bool(cli.BoolOpt{
+		Name:  "w wait",
+		Desc:  "Print progress until the rebuilds are done.",
+		Value: false,
+	})
+	c.Action = func() {
+		q := url.Values{}
+		for _, index := range *indexes {
+			q.Add("index", index)
+		}
+		body, err := ctlRequest("POST", "/private/v1/reindex?"+q.Encode(), time.Minute)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		var jobs []*rs.ReindexJob
+		if err := json.Unmarshal(body, &jobs); err != nil {
+			log.Fatalln("failed to read rebuild jobs:", err)
+		}
+		started := make(map[string]bool, len(jobs))
+		for _, job := range jobs {
+			started[job.Index] = true
+			log.Printf("rebuilding the %s index of %d records", job.Index, job.Total)
+		}
+		if !*wait {
+			log.Println("rebuilds run in the background, see atlant-go ctl status")
+			return
+		}
+		for {
+			time.Sleep(5 * time.Second)
+			body, err := ctlRequest("GET", "/private/v1/reindex", time.Minute)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if err := json.Unmarshal(body, &jobs); err != nil {
+				log.Fatalln("failed to read rebuild jobs:", err)
+			}
+			var running bool
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			for _, job := range jobs {
+				if !started[job.Index] {
+					continue
+				}
+				running = running || job.Running
+				fmt.Fprintf(w, "%s\t%s\t%d/%d records\t%d dropped\t%s\n",
+					job.Index, job.Phase, job.Processed, job.Total, job.Dropped, job.Error)
+			}
+			w.Flush()
+			if !running {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package rs
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Beat infos carry usage counters of the session: bytes of blocks served to peers and the
+// size of the IPFS repo. Accepted infos are kept per session in the node usage bucket along
+// with the ID of the reporting node, so that storage providers can be accounted per node.
+// Counters start over with every session, totals of a node add up bytes served of all its
+// sessions and take pinned bytes from the latest one. Sessions of IDs a node was rotated from
+// are accounted to the node, see authcenter.Successor.
+
+// SessionUsage is the last usage a node reported for one of its sessions.
+type SessionUsage struct {
+	NodeID       string    `json:"node_id"`
+	SessionID    string    `json:"session_id"`
+	EthereumAddr string    `json:"eth_addr"`
+	Uptime       int64     `json:"uptime_seconds"`
+	BytesServed  uint64    `json:"bytes_served"`
+	BytesPinned  uint64    `json:"bytes_pinned"`
+	ReportedAt   time.Time `json:"reported_at"`
+}
+
+// NodeUsage sums up usage of the sessions of a node.
+type NodeUsage struct {
+	NodeID       string          `json:"node_id"`
+	EthereumAddr string          `json:"eth_addr"`
+	Uptime       int64           `json:"uptime_seconds"`
+	BytesServed  uint64          `json:"bytes_served"`
+	BytesPinned  uint64          `json:"bytes_pinned"`
+	ReportedAt   time.Time       `json:"reported_at"`
+	Sessions     []*SessionUsage `json:"sessions"`
+}
+
+// usageCounters returns bytes served to peers and bytes stored by this node.
+func (r *recordStore) usageCounters() (served, pinned uint64) {
+	if bitswap := r.fs.BitswapStats(); bitswap != nil {
+		served = bitswap.DataSent
+	}
+	if repo := r.fs.RepoStats(); repo != nil {
+		pinned = repo.RepoSize
+	}
+	return served, pinned
+}
+
+// recordUsage keeps the usage of a beat info, infos of a session with less uptime are ignored.
+func (r *recordStore) recordUsage(nodeID string, info proto.EnvelopeBeatInfo) {
+	usage := &SessionUsage{
+		NodeID:       nodeID,
+		SessionID:    info.Session(),
+		EthereumAddr: info.EthereumAddr(),
+		Uptime:       info.UptimeUnix(),
+		BytesServed:  info.BytesServed(),
+		BytesPinned:  info.BytesPinned(),
+		ReportedAt:   time.Now().UTC(),
+	}
+	k := state.NodeUsageKey(info.SessionBytes())
+	k.TTL = defaultBeatInfoTTL
+	if err := r.ss.Update(context.Background(), k, func(k *state.Key, v []byte) ([]byte, error) {
+		if v != nil {
+			var prev SessionUsage
+			if err := json.Unmarshal(v, &prev); err == nil &&
+				(prev.NodeID != nodeID || prev.Uptime > usage.Uptime) {
+				return nil, state.ErrNoUpdate
+			}
+		}
+		return json.Marshal(usage)
+	}); err != nil {
+		log.Warningf("failed to write node usage: %v", err)
+	}
+}
+
+// NodeUsage returns usage reported by nodes, of a single node if nodeID is set.
+func (r *recordStore) NodeUsage(ctx context.Context, nodeID string) ([]*NodeUsage, error) {
+	nodes := make(map[string]*NodeUsage)
+	b := state.NewBucket(state.BucketNodeUsage)
+	if _, err := r.ss.RangePeek(ctx, b, func(k *state.Key, v []byte) error {
+		var s SessionUsage
+		if err := json.Unmarshal(v, &s); err != nil {
+			log.Debugf("skipping malformed node usage: %v", err)
+			return nil
+		}
+		owner := authcenter.Successor(s.NodeID)
+		if len(nodeID) > 0 && owner != nodeID {
+			return nil
+		}
+		n, ok := nodes[owner]
+		if !ok {
+			n = &NodeUsage{
+				NodeID: owner,
+			}
+			nodes[owner] = n
+		}
+		n.Sessions = append(n.Sessions, &s)
+		n.Uptime += s.Uptime
+		n.BytesServed += s.BytesServed
+		if !s.ReportedAt.Before(n.ReportedAt) {
+			n.ReportedAt = s.ReportedAt
+			n.EthereumAddr = s.EthereumAddr
+			n.BytesPinned = s.BytesPinned
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	usage := make([]*NodeUsage, 0, len(nodes))
+	for _, n := range nodes {
+		sort.Slice(n.Sessions, func(i, j int) bool {
+			return n.Sessions[i].ReportedAt.Before(n.Sessions[j].ReportedAt)
+		})
+		usage = append(usage, n)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].NodeID < usage[j].NodeID
+	})
+	return usage, nil
+}
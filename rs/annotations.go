@@ -0,0 +1,140 @@
+package rs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Annotations are lightweight metadata attached to records aside of their content, like
+// a review status, a quality score or references to external systems. Unlike the user meta
+// they are changed without writing a new version, so the version history is not polluted
+// by metadata-only edits. Annotations are kept in the state store of the node under the
+// record ID and are not gossiped, they are dropped once the record is deleted.
+
+const (
+	maxAnnotationKeySize = 128
+	maxAnnotationsSize   = 64 * 1024
+)
+
+var (
+	ErrAnnotationKey       = errors.New("annotation key is empty or too long")
+	ErrAnnotationsTooLarge = fmt.Errorf("annotations exceed %d bytes", maxAnnotationsSize)
+)
+
+// Annotations map keys to JSON values.
+type Annotations map[string]json.RawMessage
+
+type RecordAnnotations struct {
+	ID          string      `json:"id"`
+	Path        string      `json:"path"`
+	Annotations Annotations `json:"annotations"`
+	UpdatedAt   time.Time   `json:"updated_at,omitempty"`
+}
+
+func annotationsKey(id string) *state.Key {
+	return state.AnnotationsKey(id)
+}
+
+func (r *recordStore) Annotations(ctx context.Context, path string) (*RecordAnnotations, error) {
+	rec, err := r.ReadRecord(ctx, path, ReadOptions{NoContent: true})
+	if err != nil {
+		return nil, err
+	}
+	res := &RecordAnnotations{
+		ID:          rec.Id(),
+		Path:        rec.Path(),
+		Annotations: Annotations{},
+	}
+	if err := r.ss.View(ctx, annotationsKey(rec.Id()), func(k *state.Key, v []byte) error {
+		return json.Unmarshal(v, res)
+	}); err != nil && err != state.ErrNotFound {
+		return nil, err
+	}
+	res.ID = rec.Id()
+	res.Path = rec.Path()
+	return res, nil
+}
+
+func (r *recordStore) Annotate(ctx context.Context, path string,
+	set Annotations, remove []string) (*RecordAnnotations, error) {
+	if r.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	for key, value := range set {
+		if len(key) == 0 || len(key) > maxAnnotationKeySize {
+			return nil, ErrAnnotationKey
+		} else if !json.Valid(value) {
+			return nil, fmt.Errorf("annotation %s is not valid json", key)
+		}
+	}
+	rec, err := r.ReadRecord(ctx, path, ReadOptions{NoContent: true})
+	if err != nil {
+		return nil, err
+	}
+	var res *RecordAnnotations
+	if err := r.ss.Update(ctx, annotationsKey(rec.Id()), func(k *state.Key, v []byte) ([]byte, error) {
+		res = &RecordAnnotations{}
+		if len(v) > 0 {
+			if err := json.Unmarshal(v, res); err != nil {
+				return nil, err
+			}
+		}
+		if res.Annotations == nil {
+			res.Annotations = Annotations{}
+		}
+		for _, key := range remove {
+			delete(res.Annotations, key)
+		}
+		for key, value := range set {
+			if string(value) == "null" {
+				delete(res.Annotations, key)
+				continue
+			}
+			res.Annotations[key] = value
+		}
+		res.ID = rec.Id()
+		res.Path = rec.Path()
+		res.UpdatedAt = time.Now().UTC()
+		data, err := json.Marshal(res)
+		if err != nil {
+			return nil, err
+		} else if len(data) > maxAnnotationsSize {
+			return nil, ErrAnnotationsTooLarge
+		}
+		return data, nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(res.Annotations) == 0 {
+		r.dropAnnotations(rec.Id())
+	}
+	return res, nil
+}
+
+func (r *recordStore) AnnotationsOf(id string) Annotations {
+	var res RecordAnnotations
+	if err := r.ss.View(context.Background(), annotationsKey(id), func(k *state.Key, v []byte) error {
+		return json.Unmarshal(v, &res)
+	}); err != nil {
+		if err != state.ErrNotFound {
+			log.WithField("id", id).Warningf("failed to read annotations: %v", err)
+		}
+		return nil
+	} else if len(res.Annotations) == 0 {
+		return nil
+	}
+	return res.Annotations
+}
+
+func (r *recordStore) dropAnnotations(id string) {
+	if err := r.ss.Delete(annotationsKey(id)); err != nil && err != state.ErrNotFound {
+		log.WithField("id", id).Warningf("failed to drop annotations: %v", err)
+	}
+}
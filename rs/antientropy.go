@@ -0,0 +1,593 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	capn "github.com/glycerine/go-capnproto"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Gossip pushes each change once, an announce dropped on the way is never sent again and
+// sync on start only fetches records changed since the last one. Anti-entropy rounds repair
+// what gossip lost: every interval the node picks a random peer among sync candidates and
+// compares digests of their records. Records are hashed into antiEntropyBuckets buckets by
+// ID, the digest of a bucket is the XOR of hashes of the current versions of its records,
+// so it's computed in a single pass in any order. For buckets that differ the nodes compare
+// versions of each record, then the node pulls records it lacks and pushes the ones the
+// peer lacks, records held in different versions go both ways and either side keeps the
+// newer version as in sync. Bytes of records transferred in a round are limited in rate and in total,
+// the rest is left to the next rounds.
+
+const (
+	antiEntropyBuckets = 256
+	antiEntropyWarmup  = 5 * time.Minute
+	// antiEntropyRoundTimeout bounds a round regardless of the transfer caps.
+	antiEntropyRoundTimeout = 30 * time.Minute
+)
+
+var (
+	ErrAntiEntropyDisabled = errors.New("anti-entropy is disabled on this node")
+	ErrNoSharedNamespaces  = errors.New("nodes follow no common namespaces")
+)
+
+// AntiEntropyPolicy configures anti-entropy rounds, see RunAntiEntropy.
+type AntiEntropyPolicy struct {
+	// Interval between rounds, zero disables them.
+	Interval time.Duration
+	// Rate limits bytes of records transferred in a round per second, zero removes the limit.
+	Rate int
+	// MaxBytes limits bytes of records transferred in a round, zero removes the limit.
+	MaxBytes int64
+}
+
+// AntiEntropyDigest summarizes the records a node holds, see DigestRecords.
+type AntiEntropyDigest struct {
+	// Namespaces the digest covers, all of them if empty.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Buckets are hex encoded digests of the buckets of records.
+	Buckets []string `json:"buckets"`
+	Records int      `json:"records"`
+}
+
+// AntiEntropyEntry is the current version of a record the node holds.
+type AntiEntropyEntry struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	// Chain is the number of previous versions, longer chains of the same version win.
+	Chain int `json:"chain"`
+}
+
+// AntiEntropyRound is the outcome of a round with a peer.
+type AntiEntropyRound struct {
+	Peer       string    `json:"peer,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Mismatched int       `json:"mismatched_buckets"`
+	Pulled     int       `json:"pulled"`
+	Pushed     int       `json:"pushed"`
+	Bytes      int64     `json:"bytes"`
+	// Capped is set if the round stopped at the byte limit, the rest is left to next rounds.
+	Capped bool   `json:"capped,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type AntiEntropyStatus struct {
+	Enabled  bool              `json:"enabled"`
+	Interval string            `json:"interval,omitempty"`
+	Rounds   uint64            `json:"rounds_total"`
+	Repaired uint64            `json:"repaired_total"`
+	Bytes    uint64            `json:"bytes_total"`
+	Last     *AntiEntropyRound `json:"last,omitempty"`
+}
+
+type antiEntropyState struct {
+	mux      *sync.Mutex
+	policy   *AntiEntropyPolicy
+	rounds   uint64
+	repaired uint64
+	bytes    uint64
+	last     *AntiEntropyRound
+}
+
+func newAntiEntropyState() *antiEntropyState {
+	return &antiEntropyState{
+		mux: new(sync.Mutex),
+	}
+}
+
+func (x *antiEntropyState) setPolicy(policy *AntiEntropyPolicy) {
+	x.mux.Lock()
+	x.policy = policy
+	x.mux.Unlock()
+}
+
+func (x *antiEntropyState) observe(round *AntiEntropyRound) {
+	x.mux.Lock()
+	x.rounds++
+	x.repaired += uint64(round.Pulled + round.Pushed)
+	x.bytes += uint64(round.Bytes)
+	x.last = round
+	x.mux.Unlock()
+}
+
+// RunAntiEntropy runs anti-entropy rounds with random peers every interval of the policy.
+func (r *recordStore) RunAntiEntropy(ctx context.Context, policy *AntiEntropyPolicy) {
+	r.antiEntropy.setPolicy(policy)
+	defer r.antiEntropy.setPolicy(nil)
+	t := time.NewTimer(antiEntropyWarmup)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			round := r.antiEntropyRound(ctx, policy)
+			r.antiEntropy.observe(round)
+			logger := log.WithField("peer", round.Peer)
+			if len(round.Error) > 0 {
+				logger.Warningf("anti-entropy round failed: %s", round.Error)
+			} else if round.Pulled+round.Pushed > 0 {
+				logger.Infof("anti-entropy round repaired %d records, pulled %d and pushed %d",
+					round.Pulled+round.Pushed, round.Pulled, round.Pushed)
+			}
+			t.Reset(policy.Interval)
+		}
+	}
+}
+
+func (r *recordStore) AntiEntropyStatus() *AntiEntropyStatus {
+	r.antiEntropy.mux.Lock()
+	defer r.antiEntropy.mux.Unlock()
+	status := &AntiEntropyStatus{
+		Enabled:  r.antiEntropy.policy != nil,
+		Rounds:   r.antiEntropy.rounds,
+		Repaired: r.antiEntropy.repaired,
+		Bytes:    r.antiEntropy.bytes,
+		Last:     r.antiEntropy.last,
+	}
+	if r.antiEntropy.policy != nil {
+		status.Interval = r.antiEntropy.policy.Interval.String()
+	}
+	return status
+}
+
+func (r *recordStore) antiEntropyRound(ctx context.Context, policy *AntiEntropyPolicy) *AntiEntropyRound {
+	ctx, cancelFn := context.WithTimeout(ctx, antiEntropyRoundTimeout)
+	defer cancelFn()
+	round := &AntiEntropyRound{
+		StartedAt: time.Now().UTC(),
+	}
+	if err := r.reconcile(ctx, policy, round); err != nil {
+		round.Error = err.Error()
+	}
+	round.FinishedAt = time.Now().UTC()
+	return round
+}
+
+func (r *recordStore) reconcile(ctx context.Context, policy *AntiEntropyPolicy, round *AntiEntropyRound) error {
+	candidates := r.syncCandidates()
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	rnd.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	for _, nodeID := range candidates {
+		if r.pingNode(ctx, nodeID) == stateAlive {
+			round.Peer = nodeID
+			break
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if len(round.Peer) == 0 {
+		return errors.New("no alive peers found")
+	}
+	q := url.Values{}
+	q.Set("namespaces", strings.Join(r.opts.Namespaces, ","))
+	var remote AntiEntropyDigest
+	if err := r.antiEntropyRequest(ctx, round.Peer, "GET", "digest?"+q.Encode(), nil, &remote); err != nil {
+		return fmt.Errorf("failed to get digest: %v", err)
+	}
+	local, err := r.DigestRecords(ctx, remote.Namespaces)
+	if err != nil {
+		return err
+	}
+	var buckets []int
+	for i := range local.Buckets {
+		if i >= len(remote.Buckets) || local.Buckets[i] != remote.Buckets[i] {
+			buckets = append(buckets, i)
+		}
+	}
+	round.Mismatched = len(buckets)
+	if len(buckets) == 0 {
+		return nil
+	}
+	q.Set("namespaces", strings.Join(remote.Namespaces, ","))
+	q.Set("buckets", joinInts(buckets))
+	var remoteEntries []*AntiEntropyEntry
+	if err := r.antiEntropyRequest(ctx, round.Peer, "GET", "entries?"+q.Encode(), nil, &remoteEntries); err != nil {
+		return fmt.Errorf("failed to get entries: %v", err)
+	}
+	localEntries, err := r.RecordEntries(ctx, remote.Namespaces, buckets)
+	if err != nil {
+		return err
+	}
+	have := make(map[string]*AntiEntropyEntry, len(localEntries))
+	for _, e := range localEntries {
+		have[e.ID] = e
+	}
+	var pull, push []string
+	for _, e := range remoteEntries {
+		if l, ok := have[e.ID]; !ok {
+			pull = append(pull, e.ID)
+		} else if *l != *e {
+			pull = append(pull, e.ID)
+			push = append(push, e.ID)
+		}
+		delete(have, e.ID)
+	}
+	for id := range have {
+		push = append(push, id)
+	}
+	throttle := &transferThrottle{
+		ctx:     ctx,
+		started: time.Now(),
+		rate:    policy.Rate,
+	}
+	defer func() {
+		round.Bytes = throttle.total()
+		round.Capped = policy.MaxBytes > 0 && round.Bytes >= policy.MaxBytes
+	}()
+	if len(pull) > 0 {
+		n, err := r.pullRecords(ctx, round.Peer, pull, policy.MaxBytes, throttle)
+		round.Pulled = n
+		if err != nil {
+			return fmt.Errorf("failed to pull records: %v", err)
+		}
+	}
+	// pushes get what's left of the limit
+	maxBytes := policy.MaxBytes
+	if maxBytes > 0 {
+		if maxBytes -= throttle.total(); maxBytes <= 0 {
+			return nil
+		}
+	}
+	if len(push) > 0 {
+		n, err := r.pushRecords(ctx, round.Peer, push, maxBytes, throttle)
+		round.Pushed = n
+		if err != nil {
+			return fmt.Errorf("failed to push records: %v", err)
+		}
+	}
+	return nil
+}
+
+// syncCandidates returns nodes with the write permission, records are fetched from them.
+func (r *recordStore) syncCandidates() []string {
+	var candidates []string
+	for _, e := range authcenter.Default.Entries() {
+		// rotated IDs are served by their successors
+		key := authcenter.Successor(e.Key)
+		if key == r.nodeID {
+			continue
+		} else if e.HasPermissions(authcenter.RecordWritePermission) {
+			candidates = append(candidates, key)
+		}
+	}
+	return candidates
+}
+
+func (r *recordStore) pullRecords(ctx context.Context, nodeID string, ids []string, maxBytes int64, throttle *transferThrottle) (int, error) {
+	body, _ := json.Marshal(&AntiEntropyPull{
+		IDs:      ids,
+		MaxBytes: maxBytes,
+	})
+	resp, err := r.antiEntropyResponse(ctx, nodeID, "POST", "records", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return r.ImportRecords(ctx, &throttledReader{
+		Reader:   resp.Body,
+		throttle: throttle,
+	})
+}
+
+func (r *recordStore) pushRecords(ctx context.Context, nodeID string, ids []string, maxBytes int64, throttle *transferThrottle) (int, error) {
+	pr, pw := io.Pipe()
+	var pushed int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n, err := r.ExportRecordsByID(ctx, &throttledWriter{
+			Writer:   pw,
+			throttle: throttle,
+		}, ids, maxBytes)
+		pushed = n
+		pw.CloseWithError(err)
+	}()
+	resp, err := r.antiEntropyResponse(ctx, nodeID, "POST", "push", pr)
+	// closing the reader stops the export if the request failed early
+	pr.Close()
+	<-done
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return pushed, nil
+}
+
+func (r *recordStore) antiEntropyRequest(ctx context.Context, nodeID, method, path string, body io.Reader, v interface{}) error {
+	resp, err := r.antiEntropyResponse(ctx, nodeID, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (r *recordStore) antiEntropyResponse(ctx context.Context, nodeID, method, path string, body io.Reader) (*http.Response, error) {
+	u := fmt.Sprintf("http://%s/private/v1/anti-entropy/%s", nodeID, path)
+	req, _ := http.NewRequest(method, u, body)
+	req = req.WithContext(ctx)
+	r.outboundWork()
+	resp, err := r.fs.Client().Do(req)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode != 200 {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if len(body) == 0 {
+			return nil, fmt.Errorf("error %d: %s", resp.StatusCode, resp.Status)
+		}
+		return nil, fmt.Errorf("%s", string(body))
+	}
+	return resp, nil
+}
+
+// AntiEntropyPull is a request of records by their IDs.
+type AntiEntropyPull struct {
+	IDs []string `json:"ids"`
+	// MaxBytes stops the export once that many bytes are written, zero removes the limit.
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+}
+
+// DigestRecords computes the digest of records in the namespaces shared with a peer that
+// follows the given ones, ErrNoSharedNamespaces if there are none.
+func (r *recordStore) DigestRecords(ctx context.Context, namespaces []string) (*AntiEntropyDigest, error) {
+	defer r.inboundWork()
+	shared, ok := sharedNamespaces(r.opts.Namespaces, namespaces)
+	if !ok {
+		return nil, ErrNoSharedNamespaces
+	}
+	var digests [antiEntropyBuckets][sha256.Size]byte
+	var count int
+	err := r.rangeEntries(ctx, shared, func(bucket int, h [sha256.Size]byte, e *AntiEntropyEntry) {
+		for i := range h {
+			digests[bucket][i] ^= h[i]
+		}
+		count++
+	})
+	if err != nil {
+		return nil, err
+	}
+	digest := &AntiEntropyDigest{
+		Namespaces: shared,
+		Buckets:    make([]string, antiEntropyBuckets),
+		Records:    count,
+	}
+	for i := range digests {
+		digest.Buckets[i] = hex.EncodeToString(digests[i][:])
+	}
+	return digest, nil
+}
+
+// RecordEntries lists current versions of records in the buckets, see DigestRecords.
+func (r *recordStore) RecordEntries(ctx context.Context, namespaces []string, buckets []int) ([]*AntiEntropyEntry, error) {
+	defer r.inboundWork()
+	shared, ok := sharedNamespaces(r.opts.Namespaces, namespaces)
+	if !ok {
+		return nil, ErrNoSharedNamespaces
+	}
+	wanted := make(map[int]bool, len(buckets))
+	for _, b := range buckets {
+		wanted[b] = true
+	}
+	var entries []*AntiEntropyEntry
+	err := r.rangeEntries(ctx, shared, func(bucket int, h [sha256.Size]byte, e *AntiEntropyEntry) {
+		if wanted[bucket] {
+			entries = append(entries, e)
+		}
+	})
+	return entries, err
+}
+
+func (r *recordStore) rangeEntries(ctx context.Context, namespaces []string,
+	fn func(bucket int, h [sha256.Size]byte, e *AntiEntropyEntry)) error {
+	b := state.NewBucket(state.BucketRecords, &state.RangeOptions{
+		Prefetch: 100,
+	})
+	return r.ss.RangePeek(ctx, b, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		if len(namespaces) > 0 && !contains(namespaces, NamespaceOf(v.Path())) {
+			return nil
+		}
+		e := &AntiEntropyEntry{
+			ID:      v.Id(),
+			Version: v.Current().Version(),
+			Chain:   v.Previous().Len(),
+		}
+		idHash := sha256.Sum256([]byte(e.ID))
+		fn(int(idHash[0]), sha256.Sum256([]byte(e.ID+"\x00"+e.Version+"\x00"+strconv.Itoa(e.Chain))), e)
+		return nil
+	}))
+}
+
+// ExportRecordsByID writes the records, missing ones are skipped. With maxBytes set the
+// export stops once that many bytes are written.
+func (r *recordStore) ExportRecordsByID(ctx context.Context, wr io.Writer, ids []string, maxBytes int64) (int, error) {
+	defer r.inboundWork()
+	var written int64
+	var count int
+	for _, id := range ids {
+		if maxBytes > 0 && written >= maxBytes {
+			break
+		}
+		err := r.ss.View(ctx, state.RecordsKey([]byte(id)), func(k *state.Key, v []byte) error {
+			n, err := wr.Write(v)
+			written += int64(n)
+			return err
+		})
+		if err == state.ErrNotFound {
+			continue
+		} else if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// ImportRecords reads a stream of records and imports them as in sync, keeping local
+// records that are newer. Records read before an error are imported.
+func (r *recordStore) ImportRecords(ctx context.Context, rd io.Reader) (int, error) {
+	var batch []*proto.Record
+	var count int
+	flush := func() error {
+		if err := r.importRecords(batch); err != nil {
+			return err
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+		seg, err := capn.ReadFromStream(rd, nil)
+		if err == io.EOF {
+			return count, flush()
+		} else if err != nil {
+			if ferr := flush(); ferr != nil {
+				return count, ferr
+			}
+			return count, fmt.Errorf("failed to read segment: %v", err)
+		}
+		record := proto.ReadRootRecord(seg)
+		batch = append(batch, &record)
+		if len(batch) >= syncBatchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+}
+
+// AcceptPush imports records pushed by a peer in an anti-entropy round.
+func (r *recordStore) AcceptPush(ctx context.Context, rd io.Reader) (int, error) {
+	r.antiEntropy.mux.Lock()
+	enabled := r.antiEntropy.policy != nil
+	r.antiEntropy.mux.Unlock()
+	if !enabled {
+		return 0, ErrAntiEntropyDisabled
+	}
+	return r.ImportRecords(ctx, rd)
+}
+
+// sharedNamespaces returns namespaces followed by both nodes, empty if both follow all.
+func sharedNamespaces(own, other []string) ([]string, bool) {
+	if len(own) == 0 {
+		return other, true
+	} else if len(other) == 0 {
+		return own, true
+	}
+	var shared []string
+	for _, ns := range own {
+		if contains(other, ns) {
+			shared = append(shared, ns)
+		}
+	}
+	return shared, len(shared) > 0
+}
+
+func joinInts(list []int) string {
+	parts := make([]string, len(list))
+	for i, v := range list {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// transferThrottle limits bytes transferred since it started to the rate.
+type transferThrottle struct {
+	ctx     context.Context
+	started time.Time
+	rate    int
+	mux     sync.Mutex
+	n       int64
+}
+
+func (t *transferThrottle) wait(n int) {
+	t.mux.Lock()
+	t.n += int64(n)
+	total := t.n
+	t.mux.Unlock()
+	if t.rate <= 0 || n == 0 {
+		return
+	}
+	wait := time.Duration(float64(total)/float64(t.rate)*float64(time.Second)) - time.Since(t.started)
+	if wait <= 0 {
+		return
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-t.ctx.Done():
+	case <-timer.C:
+	}
+}
+
+func (t *transferThrottle) total() int64 {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.n
+}
+
+type throttledReader struct {
+	io.Reader
+	throttle *transferThrottle
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.Reader.Read(p)
+	t.throttle.wait(n)
+	return n, err
+}
+
+type throttledWriter struct {
+	io.Writer
+	throttle *transferThrottle
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	t.throttle.wait(len(p))
+	return t.Writer.Write(p)
+}
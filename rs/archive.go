@@ -0,0 +1,340 @@
+package rs
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	capn "github.com/glycerine/go-capnproto"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Record archives carry a filtered set of records with the blocks of their objects, so a new
+// node may be seeded offline and records may be moved between networks without a connection.
+// An archive is either a CAR stream, where the manifest and the records are raw data blocks
+// listed first in the roots, or a tar of the manifest, the records and a CAR of the blocks.
+// Records are imported as in sync, local records that are newer are kept, and their objects
+// are pinned as the mirror policy and the disk budget allow.
+
+var ErrArchiveFormat = errors.New("unsupported record archive format")
+
+const (
+	ArchiveCAR = "car"
+	ArchiveTar = "tar"
+
+	archiveVersion       = 1
+	archiveManifestFile  = "manifest.json"
+	archiveRecordsFile   = "records"
+	archiveBlocksFile    = "blocks.car"
+	archiveRecordsChunk  = 1024 * 1024
+	archiveSpoolFilename = "atlant-archive"
+)
+
+// ArchiveQuery selects records of an archive, an empty query selects all.
+type ArchiveQuery struct {
+	Prefix     string    `json:"prefix,omitempty"`
+	Namespaces []string  `json:"namespaces,omitempty"`
+	Since      time.Time `json:"since,omitempty"`
+	// History adds objects of previous versions, only current ones are added otherwise.
+	History bool `json:"history,omitempty"`
+}
+
+func (q *ArchiveQuery) matches(v *proto.Record) bool {
+	if !strings.HasPrefix(v.Path(), q.Prefix) {
+		return false
+	} else if len(q.Namespaces) > 0 && !contains(q.Namespaces, NamespaceOf(v.Path())) {
+		return false
+	} else if !q.Since.IsZero() && v.Current().Announce().Timestamp() < q.Since.UnixNano() {
+		return false
+	}
+	return true
+}
+
+// ArchiveManifest describes a record archive.
+type ArchiveManifest struct {
+	Version   int           `json:"version"`
+	NodeID    string        `json:"node_id"`
+	CreatedAt time.Time     `json:"created_at"`
+	Query     *ArchiveQuery `json:"query"`
+	Records   int           `json:"records"`
+	Objects   int           `json:"objects"`
+}
+
+// ArchiveImport reports records and blocks imported from an archive.
+type ArchiveImport struct {
+	Manifest *ArchiveManifest `json:"manifest"`
+	Records  int              `json:"records"`
+	Blocks   int              `json:"blocks"`
+	Pinned   int              `json:"pinned"`
+	Failed   int              `json:"failed"`
+	// Skipped counts pins skipped by the mirror policy or the disk budget.
+	Skipped int `json:"skipped"`
+}
+
+// archiveRecords collects records the query selects in chunks of whole records, and
+// versions of their objects.
+func (r *recordStore) archiveRecords(ctx context.Context, q *ArchiveQuery) (chunks [][]byte, versions []string, count int, err error) {
+	var buf bytes.Buffer
+	mux := new(sync.Mutex)
+	selected := proto.RecordPeek(func(_ *state.Key, v *proto.Record) error {
+		if !q.matches(v) {
+			return errRecordUnchanged
+		}
+		recVersions := []string{v.Current().Version()}
+		if q.History {
+			recVersions = recordVersions(v)
+		}
+		mux.Lock()
+		versions = append(versions, recVersions...)
+		mux.Unlock()
+		return nil
+	})
+	b := state.NewBucket(state.BucketRecords, &state.RangeOptions{
+		Prefetch: 100,
+	})
+	if err = state.Stream(ctx, r.ss, b, 0, func(k *state.Key, v []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		} else if err := selected(k, v); err == errRecordUnchanged {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		if buf.Len() > 0 && buf.Len()+len(v) > archiveRecordsChunk {
+			chunks = append(chunks, append([]byte(nil), buf.Bytes()...))
+			buf.Reset()
+		}
+		buf.Write(v)
+		count++
+		return nil
+	}); err != nil {
+		return nil, nil, 0, err
+	}
+	if buf.Len() > 0 {
+		chunks = append(chunks, buf.Bytes())
+	}
+	sort.Strings(versions)
+	return chunks, versions, count, nil
+}
+
+func (r *recordStore) ExportArchive(ctx context.Context, q *ArchiveQuery, format string, wr io.Writer) (*ArchiveManifest, error) {
+	if format != ArchiveCAR && format != ArchiveTar {
+		return nil, ErrArchiveFormat
+	}
+	chunks, versions, count, err := r.archiveRecords(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list records: %v", err)
+	}
+	manifest := &ArchiveManifest{
+		Version:   archiveVersion,
+		NodeID:    r.nodeID,
+		CreatedAt: time.Now().UTC(),
+		Query:     q,
+		Records:   count,
+		Objects:   len(versions),
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if format == ArchiveCAR {
+		data := append([][]byte{manifestData}, chunks...)
+		return manifest, r.fs.ExportArchive(ctx, data, versions, wr)
+	}
+	// tar needs sizes upfront, blocks are spooled to disk
+	spool, err := ioutil.TempFile("", archiveSpoolFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	if err := r.fs.ExportBlocks(ctx, versions, spool); err != nil {
+		return nil, err
+	}
+	blocksSize, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	} else if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	records := make([]io.Reader, 0, len(chunks))
+	var recordsSize int64
+	for _, chunk := range chunks {
+		records = append(records, bytes.NewReader(chunk))
+		recordsSize += int64(len(chunk))
+	}
+	tw := tar.NewWriter(wr)
+	files := []struct {
+		name string
+		size int64
+		r    io.Reader
+	}{
+		{archiveManifestFile, int64(len(manifestData)), bytes.NewReader(manifestData)},
+		{archiveRecordsFile, recordsSize, io.MultiReader(records...)},
+		{archiveBlocksFile, blocksSize, spool},
+	}
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    f.name,
+			Mode:    0600,
+			Size:    f.size,
+			ModTime: manifest.CreatedAt,
+		}); err != nil {
+			return nil, err
+		} else if _, err := io.CopyN(tw, f.r, f.size); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, tw.Close()
+}
+
+// archiveImport imports records and blocks of an archive, then pins the objects.
+type archiveImport struct {
+	r       *recordStore
+	ctx     context.Context
+	result  *ArchiveImport
+	history bool
+	// versions of imported records by their paths
+	versions map[string][]string
+}
+
+func (a *archiveImport) readManifest(data []byte) error {
+	var manifest ArchiveManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("bad archive manifest: %v", err)
+	} else if manifest.Version != archiveVersion {
+		return fmt.Errorf("unsupported record archive version %d", manifest.Version)
+	}
+	a.result.Manifest = &manifest
+	if manifest.Query != nil {
+		a.history = manifest.Query.History
+	}
+	return nil
+}
+
+func (a *archiveImport) importRecords(data []byte) error {
+	if a.result.Manifest == nil {
+		return errors.New("archive manifest is missing")
+	}
+	rd := bytes.NewReader(data)
+	for {
+		seg, err := capn.ReadFromStream(rd, nil)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read records: %v", err)
+		}
+		v := proto.ReadRootRecord(seg)
+		if a.history {
+			a.versions[v.Path()] = recordVersions(&v)
+		} else {
+			a.versions[v.Path()] = []string{v.Current().Version()}
+		}
+	}
+	count, err := a.r.ImportRecords(a.ctx, bytes.NewReader(data))
+	a.result.Records += count
+	return err
+}
+
+func (a *archiveImport) pin() {
+	policy := a.r.mirror.get()
+	for path, versions := range a.versions {
+		for _, ver := range versions {
+			if !policy.Matches(path) || !a.r.budget.allowPin() {
+				a.result.Skipped++
+				continue
+			}
+			if err := a.r.fs.PinObject(fs.ObjectRef{
+				Version: ver,
+			}); err != nil {
+				log.WithField("version", ver).Debugf("failed to pin archived object: %v", err)
+				a.result.Failed++
+				continue
+			}
+			a.result.Pinned++
+		}
+	}
+}
+
+func (r *recordStore) ImportArchive(ctx context.Context, format string, rd io.Reader) (*ArchiveImport, error) {
+	a := &archiveImport{
+		r:        r,
+		ctx:      ctx,
+		result:   &ArchiveImport{},
+		versions: make(map[string][]string),
+	}
+	switch format {
+	case ArchiveCAR:
+		count, err := r.fs.ImportArchive(ctx, rd, func(data []byte) error {
+			if a.result.Manifest == nil {
+				return a.readManifest(data)
+			}
+			return a.importRecords(data)
+		})
+		a.result.Blocks = count
+		if err != nil {
+			return nil, err
+		}
+	case ArchiveTar:
+		tr := tar.NewReader(rd)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			switch hdr.Name {
+			case archiveManifestFile, archiveRecordsFile:
+				data, err := ioutil.ReadAll(tr)
+				if err != nil {
+					return nil, err
+				}
+				if hdr.Name == archiveManifestFile {
+					err = a.readManifest(data)
+				} else {
+					err = a.importRecords(data)
+				}
+				if err != nil {
+					return nil, err
+				}
+			case archiveBlocksFile:
+				count, err := r.fs.ImportBlocks(ctx, tr)
+				a.result.Blocks += count
+				if err != nil {
+					return nil, fmt.Errorf("failed to import blocks: %v", err)
+				}
+			default:
+				log.Warningln("skipping unknown file of record archive:", hdr.Name)
+			}
+		}
+	default:
+		return nil, ErrArchiveFormat
+	}
+	if a.result.Manifest == nil {
+		return nil, errors.New("archive manifest is missing")
+	}
+	a.pin()
+	log.WithFields(log.Fields{
+		"records": a.result.Records,
+		"blocks":  a.result.Blocks,
+		"pinned":  a.result.Pinned,
+	}).Infoln("imported record archive")
+	return a.result, nil
+}
@@ -0,0 +1,275 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Nodes of a cluster replicate the same objects, so backups of every node with their blocks
+// would keep N copies of the same data. A coordinated backup splits object versions between
+// members instead: a plan lists members and the number of replicas of each version, and every
+// member computes the owners of a version by rendezvous hashing over the plan alone, so members
+// agree on ownership without talking to each other. Each member backs up blocks of the versions
+// it owns along with the index, the list of all versions and their owners, which is the same on
+// every synced member. A restore planner merges indexes of the archives and checks that owners
+// of every version are among them, before blocks of each archive are imported.
+
+var (
+	ErrNotBackupMember = errors.New("node is not a member of the backup plan")
+	ErrBadBackupPlan   = errors.New("bad backup plan")
+)
+
+// MaxBackupReplicas bounds owners of a version in a backup plan.
+const MaxBackupReplicas = 5
+
+// BackupPlan assigns object versions to members of a coordinated backup.
+type BackupPlan struct {
+	// ID identifies the plan, members of the same plan compute the same owners.
+	ID       string   `json:"id"`
+	Members  []string `json:"members"`
+	Replicas int      `json:"replicas"`
+}
+
+// NewBackupPlan creates a plan of the member node IDs, the order of members doesn't matter.
+func NewBackupPlan(members []string, replicas int) (*BackupPlan, error) {
+	seen := make(map[string]struct{}, len(members))
+	var list []string
+	for _, nodeID := range members {
+		if nodeID = strings.TrimSpace(nodeID); len(nodeID) == 0 {
+			continue
+		} else if _, ok := seen[nodeID]; ok {
+			continue
+		}
+		seen[nodeID] = struct{}{}
+		list = append(list, nodeID)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("%v: no members", ErrBadBackupPlan)
+	}
+	if replicas <= 0 {
+		replicas = 1
+	} else if replicas > MaxBackupReplicas || replicas > len(list) {
+		return nil, fmt.Errorf("%v: %d replicas of %d members", ErrBadBackupPlan, replicas, len(list))
+	}
+	sort.Strings(list)
+	sum := sha256.Sum256([]byte(strconv.Itoa(replicas) + "/" + strings.Join(list, ",")))
+	return &BackupPlan{
+		ID:       hex.EncodeToString(sum[:8]),
+		Members:  list,
+		Replicas: replicas,
+	}, nil
+}
+
+// Owners returns members that back up blocks of the version.
+func (p *BackupPlan) Owners(version string) []string {
+	return rendezvousOwners(p.Members, version, p.Replicas)
+}
+
+// Owns tells whether the member backs up blocks of the version.
+func (p *BackupPlan) Owns(nodeID, version string) bool {
+	for _, owner := range p.Owners(version) {
+		if owner == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMember tells whether the node is a member of the plan.
+func (p *BackupPlan) IsMember(nodeID string) bool {
+	for _, v := range p.Members {
+		if v == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// rendezvousOwners picks n of the nodes with the highest hashes of the key, see shardOwner.
+func rendezvousOwners(nodes []string, key string, n int) []string {
+	type weighted struct {
+		node string
+		sum  []byte
+	}
+	list := make([]weighted, 0, len(nodes))
+	for _, node := range nodes {
+		sum := sha256.Sum256([]byte(node + "/" + key))
+		list = append(list, weighted{node, sum[:]})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(list[i].sum, list[j].sum) > 0
+	})
+	if n > len(list) {
+		n = len(list)
+	}
+	owners := make([]string, 0, n)
+	for _, v := range list[:n] {
+		owners = append(owners, v.node)
+	}
+	return owners
+}
+
+// BackupIndex lists object versions of records and their owners in a plan, it's the shared
+// part of archives of a coordinated backup.
+type BackupIndex struct {
+	Plan      *BackupPlan     `json:"plan"`
+	NodeID    string          `json:"node_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Objects   []*BackupObject `json:"objects"`
+	// Counts are numbers of versions each member owns.
+	Counts map[string]int `json:"counts"`
+}
+
+// BackupObject is an object version in the index.
+type BackupObject struct {
+	Version string   `json:"version"`
+	Owners  []string `json:"owners"`
+}
+
+// BackupImport reports blocks imported from an archive of a coordinated backup.
+type BackupImport struct {
+	Owner  string `json:"owner"`
+	Blocks int    `json:"blocks"`
+	Pinned uint64 `json:"pinned"`
+	Failed uint64 `json:"failed"`
+	// Skipped counts pins skipped by the mirror policy or the disk budget.
+	Skipped uint64 `json:"skipped"`
+}
+
+// backupVersions lists object versions of all records, each version once and in order.
+func (r *recordStore) backupVersions(ctx context.Context) ([]string, error) {
+	seen := make(map[string]struct{})
+	seenMux := new(sync.Mutex)
+	b := state.NewBucket(state.BucketRecords)
+	if err := state.Stream(ctx, r.ss, b, 0, proto.RecordPeek(func(_ *state.Key, v *proto.Record) error {
+		recVersions := recordVersions(v)
+		seenMux.Lock()
+		for _, ver := range recVersions {
+			seen[ver] = struct{}{}
+		}
+		seenMux.Unlock()
+		return nil
+	})); err != nil {
+		return nil, fmt.Errorf("failed to list record versions: %v", err)
+	}
+	versions := make([]string, 0, len(seen))
+	for ver := range seen {
+		versions = append(versions, ver)
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+func (r *recordStore) BackupIndex(ctx context.Context, plan *BackupPlan) (*BackupIndex, error) {
+	if !plan.IsMember(r.nodeID) {
+		return nil, ErrNotBackupMember
+	}
+	versions, err := r.backupVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	index := &BackupIndex{
+		Plan:      plan,
+		NodeID:    r.nodeID,
+		CreatedAt: time.Now().UTC(),
+		Objects:   make([]*BackupObject, 0, len(versions)),
+		Counts:    make(map[string]int, len(plan.Members)),
+	}
+	for _, ver := range versions {
+		owners := plan.Owners(ver)
+		for _, owner := range owners {
+			index.Counts[owner]++
+		}
+		index.Objects = append(index.Objects, &BackupObject{
+			Version: ver,
+			Owners:  owners,
+		})
+	}
+	return index, nil
+}
+
+// ExportBackupShard writes blocks of object versions the node owns in the plan as a CAR
+// stream, missing blocks are fetched from peers. It returns the number of versions.
+func (r *recordStore) ExportBackupShard(ctx context.Context, plan *BackupPlan, wr io.Writer) (int, error) {
+	if !plan.IsMember(r.nodeID) {
+		return 0, ErrNotBackupMember
+	}
+	versions, err := r.backupVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	owned := versions[:0]
+	for _, ver := range versions {
+		if plan.Owns(r.nodeID, ver) {
+			owned = append(owned, ver)
+		}
+	}
+	if err := r.fs.ExportBlocks(ctx, owned, wr); err != nil {
+		return 0, err
+	}
+	return len(owned), nil
+}
+
+// ImportBackupShard reads blocks of the archive of the owner into the blockstore, then pins
+// versions of records the owner backed up, as the mirror policy and the disk budget allow.
+func (r *recordStore) ImportBackupShard(ctx context.Context, plan *BackupPlan,
+	owner string, rd io.Reader) (*BackupImport, error) {
+	if !plan.IsMember(owner) {
+		return nil, fmt.Errorf("%v: %s", ErrNotBackupMember, owner)
+	}
+	count, err := r.fs.ImportBlocks(ctx, rd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import blocks: %v", err)
+	}
+	result := &BackupImport{
+		Owner:  owner,
+		Blocks: count,
+	}
+	b := state.NewBucket(state.BucketRecords)
+	if err := state.Stream(ctx, r.ss, b, 0, proto.RecordPeek(func(_ *state.Key, v *proto.Record) error {
+		mirrored := r.mirror.get().Matches(v.Path())
+		for _, ver := range recordVersions(v) {
+			if !plan.Owns(owner, ver) {
+				continue
+			} else if !mirrored || !r.budget.allowPin() {
+				atomic.AddUint64(&result.Skipped, 1)
+				continue
+			}
+			if err := r.fs.PinObject(fs.ObjectRef{
+				Version: ver,
+			}); err != nil {
+				log.WithField("version", ver).Debugf("failed to pin restored object: %v", err)
+				atomic.AddUint64(&result.Failed, 1)
+				continue
+			}
+			atomic.AddUint64(&result.Pinned, 1)
+		}
+		return nil
+	})); err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"owner":  owner,
+		"blocks": result.Blocks,
+		"pinned": result.Pinned,
+		"failed": result.Failed,
+	}).Infoln("imported blocks of a coordinated backup")
+	return result, nil
+}
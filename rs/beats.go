@@ -0,0 +1,290 @@
+package rs
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Nodes send beat ticks to tell peers they are alive and beat infos with their uptime and work,
+// which back beat reports. Adaptive ticks back off, doubling the interval up to MaxTickInterval,
+// while no announces come from the network, and go back to TickInterval once they come again.
+// When peers become reachable after the node had none, a tick is sent after MinTickInterval, so
+// they learn about the node quickly. Times of the last beats sent are kept in the state store,
+// so beats after a restart are sent when they are due instead of full intervals later.
+// Ticks received from peers make up their liveness: the score is 1 while a peer beats within
+// the intervals it was seen beating at, and falls towards 0 as its next tick gets overdue.
+
+// BeatPolicy sets how often beats are sent.
+type BeatPolicy struct {
+	TickInterval time.Duration
+	InfoInterval time.Duration
+	Adaptive     bool
+	// MinTickInterval is the delay of a tick after a reconnect, MaxTickInterval bounds
+	// the back off of a quiet network.
+	MinTickInterval time.Duration
+	MaxTickInterval time.Duration
+}
+
+// DefaultBeatPolicy sends ticks every 10 minutes and infos every hour.
+func DefaultBeatPolicy() *BeatPolicy {
+	return &BeatPolicy{
+		TickInterval:    10 * time.Minute,
+		InfoInterval:    time.Hour,
+		Adaptive:        true,
+		MinTickInterval: time.Minute,
+		MaxTickInterval: time.Hour,
+	}
+}
+
+const (
+	beatPeersCheck = 30 * time.Second
+	// livenessGrace is the share of the observed interval a tick may be late by.
+	livenessGrace = 1.5
+	// livenessWeight is the weight of the last interval in the observed one.
+	livenessWeight   = 0.3
+	maxLivenessPeers = 10000
+)
+
+func beatStateKey() *state.Key {
+	return state.SyncStateKey("beats")
+}
+
+// sentBeats are times of the last beats sent, kept across restarts.
+type sentBeats struct {
+	LastTick time.Time `json:"last_tick,omitempty"`
+	LastInfo time.Time `json:"last_info,omitempty"`
+}
+
+// PeerLiveness tells how alive a peer is by its beat ticks.
+type PeerLiveness struct {
+	NodeID   string    `json:"node_id"`
+	LastBeat time.Time `json:"last_beat"`
+	Beats    uint64    `json:"beats"`
+	// Interval is the observed interval of ticks of the peer.
+	Interval string  `json:"interval"`
+	Score    float64 `json:"score"`
+}
+
+type peerBeats struct {
+	last     time.Time
+	beats    uint64
+	interval time.Duration
+}
+
+type beatState struct {
+	policy *BeatPolicy
+	// interval is the current tick interval in nanoseconds
+	interval int64
+
+	mux   *sync.Mutex
+	peers map[string]*peerBeats
+}
+
+func newBeatState(policy *BeatPolicy) *beatState {
+	if policy == nil {
+		policy = DefaultBeatPolicy()
+	}
+	return &beatState{
+		policy:   policy,
+		interval: int64(policy.TickInterval),
+		mux:      new(sync.Mutex),
+		peers:    make(map[string]*peerBeats),
+	}
+}
+
+func (b *beatState) observe(nodeID string, at time.Time) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	p, ok := b.peers[nodeID]
+	if !ok {
+		if len(b.peers) >= maxLivenessPeers {
+			return
+		}
+		p = &peerBeats{}
+		b.peers[nodeID] = p
+	} else if d := at.Sub(p.last); d > 0 {
+		if p.interval == 0 {
+			p.interval = d
+		} else {
+			p.interval = time.Duration(livenessWeight*float64(d) + (1-livenessWeight)*float64(p.interval))
+		}
+	}
+	p.last = at
+	p.beats++
+}
+
+func (b *beatState) liveness(now time.Time) []*PeerLiveness {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	list := make([]*PeerLiveness, 0, len(b.peers))
+	for nodeID, p := range b.peers {
+		interval := p.interval
+		if interval == 0 {
+			interval = b.policy.TickInterval
+		}
+		score := 1.0
+		if late := now.Sub(p.last); late > 0 {
+			score = math.Min(1, livenessGrace*float64(interval)/float64(late))
+		}
+		list = append(list, &PeerLiveness{
+			NodeID:   nodeID,
+			LastBeat: p.last,
+			Beats:    p.beats,
+			Interval: interval.Round(time.Second).String(),
+			Score:    math.Round(score*100) / 100,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].NodeID < list[j].NodeID
+	})
+	return list
+}
+
+// PeerLiveness lists peers that sent beat ticks since the node started.
+func (r *recordStore) PeerLiveness() []*PeerLiveness {
+	return r.beats.liveness(time.Now())
+}
+
+func (r *recordStore) loadSentBeats(ctx context.Context) *sentBeats {
+	sent := &sentBeats{}
+	if err := r.ss.View(ctx, beatStateKey(), func(_ *state.Key, v []byte) error {
+		return json.Unmarshal(v, sent)
+	}); err != nil && err != state.ErrNotFound {
+		log.Warningf("failed to load times of the last beats: %v", err)
+	}
+	if !sent.LastTick.IsZero() {
+		atomic.StoreInt64(&r.lastBeatTick, sent.LastTick.UnixNano())
+	}
+	if !sent.LastInfo.IsZero() {
+		atomic.StoreInt64(&r.lastBeatInfo, sent.LastInfo.UnixNano())
+	}
+	return sent
+}
+
+func (r *recordStore) saveSentBeats(ctx context.Context, sent *sentBeats) {
+	data, err := json.Marshal(sent)
+	if err != nil {
+		return
+	}
+	if err := r.ss.Update(ctx, beatStateKey(), func(_ *state.Key, _ []byte) ([]byte, error) {
+		return data, nil
+	}); err != nil {
+		log.Debugf("failed to save times of the last beats: %v", err)
+	}
+}
+
+// beatDue returns the delay of a beat last sent at the time, beats never sent are due
+// a full interval after the start.
+func beatDue(last time.Time, interval time.Duration) time.Duration {
+	if last.IsZero() {
+		return interval
+	} else if d := time.Until(last.Add(interval)); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// SendBeats sends beat ticks and infos as the beat policy of the store sets.
+func (r *recordStore) SendBeats(ctx context.Context, ethAddr string) {
+	policy := r.beats.policy
+	start := time.Now()
+	session := ctx.Value("session_id").(string)
+	sent := r.loadSentBeats(ctx)
+	tickDelay := beatDue(sent.LastTick, policy.TickInterval)
+	if tickDelay < policy.MinTickInterval {
+		// let peers connect first
+		tickDelay = policy.MinTickInterval
+	}
+	// peers accept infos of sessions they have ticks of
+	infoDelay := beatDue(sent.LastInfo, policy.InfoInterval)
+	if infoDelay <= tickDelay {
+		infoDelay = tickDelay + policy.MinTickInterval
+	}
+	tickTimer := time.NewTimer(tickDelay)
+	infoTimer := time.NewTimer(infoDelay)
+	var peersC <-chan time.Time
+	if policy.Adaptive {
+		t := time.NewTicker(beatPeersCheck)
+		defer t.Stop()
+		peersC = t.C
+	}
+	interval := policy.TickInterval
+	hadPeers := len(r.fs.SwarmPeers()) > 0
+	lastInbound := atomic.LoadUint64(&r.inboundWorkCounter)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-peersC:
+			hasPeers := len(r.fs.SwarmPeers()) > 0
+			if hasPeers && !hadPeers {
+				log.Debugln("peers are reachable again, sending a beat tick soon")
+				interval = policy.TickInterval
+				atomic.StoreInt64(&r.beats.interval, int64(interval))
+				resetTimer(tickTimer, policy.MinTickInterval)
+			}
+			hadPeers = hasPeers
+		case <-tickTimer.C:
+			ann := r.newBeatTickAnnounce(session)
+			r.EmitEventAnnounce(&EventAnnounce{
+				Type:     EventBeatTick,
+				Announce: *ann,
+			})
+			sent.LastTick = time.Now().UTC()
+			atomic.StoreInt64(&r.lastBeatTick, sent.LastTick.UnixNano())
+			r.saveSentBeats(ctx, sent)
+			if policy.Adaptive {
+				inbound := atomic.LoadUint64(&r.inboundWorkCounter)
+				if inbound == lastInbound {
+					// nothing came from the network since the last tick
+					if interval *= 2; interval > policy.MaxTickInterval {
+						interval = policy.MaxTickInterval
+					}
+				} else {
+					interval = policy.TickInterval
+				}
+				lastInbound = inbound
+				atomic.StoreInt64(&r.beats.interval, int64(interval))
+			}
+			tickTimer.Reset(interval)
+		case <-infoTimer.C:
+			uptimeUnix := time.Since(start).Seconds()
+			outboundWork := atomic.LoadUint64(&r.outboundWorkCounter)
+			inboundWork := atomic.LoadUint64(&r.inboundWorkCounter)
+			served, pinned := r.usageCounters()
+			ann := r.newBeatInfoAnnounce(session, ethAddr, int64(uptimeUnix), outboundWork, inboundWork, served, pinned)
+			r.EmitEventAnnounce(&EventAnnounce{
+				Type:     EventBeatInfo,
+				Announce: *ann,
+			})
+			if info, err := proto.UnpackEnvelopeBeatInfo(ann.Envelope()); err == nil {
+				// own reports are accounted right away, peers may not echo them back
+				r.recordUsage(r.nodeID, info)
+			}
+			sent.LastInfo = time.Now().UTC()
+			atomic.StoreInt64(&r.lastBeatInfo, sent.LastInfo.UnixNano())
+			r.saveSentBeats(ctx, sent)
+			infoTimer.Reset(policy.InfoInterval)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package rs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+var ErrStoreNotEmpty = errors.New("record store is not empty")
+
+// BootstrapTokenHeader carries the shared secret that authorizes snapshot requests
+// on the serving peer. The peer itself is authenticated by libp2p when dialing its node ID.
+const BootstrapTokenHeader = "X-Bootstrap-Token"
+
+// ExportSnapshot writes all object versions known to the store as a CAR stream.
+func (r *recordStore) ExportSnapshot(ctx context.Context, wr io.Writer) error {
+	var versions []string
+	versionsMux := new(sync.Mutex)
+	b := state.NewBucket(state.BucketRecords)
+	if err := state.Stream(ctx, r.ss, b, 0, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		recVersions := recordVersions(v)
+		versionsMux.Lock()
+		versions = append(versions, recVersions...)
+		versionsMux.Unlock()
+		return nil
+	})); err != nil {
+		err = fmt.Errorf("failed to list record versions: %v", err)
+		return err
+	}
+	return r.fs.ExportBlocks(ctx, versions, wr)
+}
+
+func recordVersions(v *proto.Record) []string {
+	versions := []string{v.Current().Version()}
+	for _, ver := range v.Previous().ToArray() {
+		versions = append(versions, ver.Version())
+	}
+	return versions
+}
+
+// BootstrapFrom builds the local store from a snapshot of a trusted peer: object
+// blocks are imported from its CAR export, then records are imported and pinned.
+func (r *recordStore) BootstrapFrom(ctx context.Context, nodeID, token string) error {
+	var empty = true
+	b := state.NewBucket(state.BucketRecords)
+	if _, err := r.ss.RangeKeys(ctx, b, func(k *state.Key) error {
+		empty = false
+		return state.ErrRangeStop
+	}); err != nil {
+		return err
+	} else if !empty {
+		return ErrStoreNotEmpty
+	}
+	if st := r.pingNode(ctx, nodeID); st != stateAlive {
+		return fmt.Errorf("peer %s is not reachable", nodeID)
+	}
+	resp, err := r.snapshotRequest(ctx, nodeID, "/private/v1/snapshot/blocks", token)
+	if err != nil {
+		err = fmt.Errorf("failed to request blocks snapshot: %v", err)
+		return err
+	}
+	count, err := r.fs.ImportBlocks(ctx, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		err = fmt.Errorf("failed to import blocks: %v", err)
+		return err
+	}
+	log.WithField("nodeID", nodeID).Infof("imported %d blocks", count)
+
+	rC := make(chan *proto.Record, 100)
+	errC := make(chan error, 1)
+	go func() {
+		defer close(rC)
+		errC <- r.getNodeRecords(ctx, nodeID, rC)
+	}()
+	if err := r.startSync(ctx, rC); err != nil {
+		err = fmt.Errorf("failed to import records: %v", err)
+		return err
+	} else if err := <-errC; err != nil {
+		err = fmt.Errorf("failed to get records snapshot: %v", err)
+		return err
+	}
+	var pinned, failed, skipped uint64
+	if err := state.Stream(ctx, r.ss, b, 0, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		for _, ver := range recordVersions(v) {
+			if !r.budget.allowPin() {
+				atomic.AddUint64(&skipped, 1)
+				continue
+			}
+			if err := r.fs.PinObject(fs.ObjectRef{
+				Version: ver,
+			}); err != nil {
+				log.WithField("version", ver).Warningf("failed to pin object: %v", err)
+				atomic.AddUint64(&failed, 1)
+				continue
+			}
+			atomic.AddUint64(&pinned, 1)
+		}
+		return nil
+	})); err != nil {
+		return err
+	}
+	log.WithField("nodeID", nodeID).Infof("pinned %d object versions, %d failed", pinned, failed)
+	if skipped > 0 {
+		log.WithField("nodeID", nodeID).Warningf("skipped pins of %d object versions over the disk budget", skipped)
+	}
+	return nil
+}
+
+func (r *recordStore) snapshotRequest(ctx context.Context, nodeID, path, token string) (*http.Response, error) {
+	u := fmt.Sprintf("http://%s%s", nodeID, path)
+	req, _ := http.NewRequest("GET", u, nil)
+	req.Header.Set(BootstrapTokenHeader, token)
+	req = req.WithContext(ctx)
+	resp, err := r.fs.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if len(body) == 0 {
+			return nil, fmt.Errorf("error %d: %s", resp.StatusCode, resp.Status)
+		}
+		return nil, fmt.Errorf("%s", string(body))
+	}
+	return resp, nil
+}
@@ -0,0 +1,219 @@
+package rs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+var (
+	ErrCircuitOpen = errors.New("circuit is open")
+	ErrReadTimeout = errors.New("read timed out")
+)
+
+// CircuitError is returned when a read was not served because the underlying
+// subsystem is unhealthy. RetryAfter hints when the caller may try again.
+type CircuitError struct {
+	Subsystem  string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (c *CircuitError) Error() string {
+	return fmt.Sprintf("%s: %v (retry after %s)", c.Subsystem, c.Err, c.RetryAfter)
+}
+
+type breakerState int
+
+const (
+	breakerClosed   breakerState = 0
+	breakerOpen     breakerState = 1
+	breakerHalfOpen breakerState = 2
+)
+
+func (b breakerState) String() string {
+	switch b {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker guards calls into a subsystem that may hang. After threshold
+// consecutive failures the circuit opens and calls fail fast until cooldown passes,
+// then a single trial call is let through to probe the subsystem.
+type circuitBreaker struct {
+	name      string
+	timeout   time.Duration
+	threshold int
+	cooldown  time.Duration
+	// isFailure reports whether an error returned by the call is a subsystem failure.
+	isFailure func(err error) bool
+	// discard releases results of calls that completed after their timeout.
+	discard func(v interface{})
+
+	mux      *sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func newCircuitBreaker(name string, timeout time.Duration, threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		name:      name,
+		timeout:   timeout,
+		threshold: threshold,
+		cooldown:  cooldown,
+		isFailure: func(err error) bool {
+			return err != nil
+		},
+		discard: func(v interface{}) {},
+		mux:     new(sync.Mutex),
+	}
+}
+
+type breakerResult struct {
+	v   interface{}
+	err error
+}
+
+func (b *circuitBreaker) Do(ctx context.Context, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	parent := ctx
+	ctx, cancelFn := context.WithTimeout(parent, b.timeout)
+	resC := make(chan breakerResult, 1)
+	go func() {
+		v, err := fn(ctx)
+		resC <- breakerResult{v, err}
+	}()
+	select {
+	case res := <-resC:
+		cancelFn()
+		b.report(b.isFailure(res.err))
+		return res.v, res.err
+	case <-ctx.Done():
+		cancelFn()
+		go func() {
+			// the call is abandoned, make sure it won't leak resources once it returns
+			if res := <-resC; res.v != nil {
+				b.discard(res.v)
+			}
+		}()
+		if err := parent.Err(); err != nil {
+			// the caller has gone away, this says nothing about the subsystem health
+			b.release()
+			return nil, err
+		}
+		b.report(true)
+		return nil, &CircuitError{
+			Subsystem:  b.name,
+			RetryAfter: b.cooldown,
+			Err:        ErrReadTimeout,
+		}
+	}
+}
+
+func (b *circuitBreaker) allow() error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if wait := b.cooldown - time.Since(b.openedAt); wait > 0 {
+			return &CircuitError{
+				Subsystem:  b.name,
+				RetryAfter: wait,
+				Err:        ErrCircuitOpen,
+			}
+		}
+		b.state = breakerHalfOpen
+		b.trial = true
+		return nil
+	case breakerHalfOpen:
+		if b.trial {
+			return &CircuitError{
+				Subsystem:  b.name,
+				RetryAfter: time.Second,
+				Err:        ErrCircuitOpen,
+			}
+		}
+		b.trial = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (b *circuitBreaker) report(failed bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.trial = false
+	if !failed {
+		if b.state != breakerClosed {
+			log.WithField("subsystem", b.name).Infoln("circuit closed")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		if b.state != breakerOpen {
+			log.WithField("subsystem", b.name).Warningf("circuit opened after %d failures", b.failures)
+		}
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *circuitBreaker) release() {
+	b.mux.Lock()
+	b.trial = false
+	b.mux.Unlock()
+}
+
+func (b *circuitBreaker) State() string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.state.String()
+}
+
+const (
+	defaultFileReadTimeout  = 30 * time.Second
+	defaultStateReadTimeout = 5 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+func newFileStoreBreaker() *circuitBreaker {
+	b := newCircuitBreaker("fs", defaultFileReadTimeout, defaultBreakerThreshold, defaultBreakerCooldown)
+	b.isFailure = func(err error) bool {
+		return err != nil && err != fs.ErrNotFound
+	}
+	b.discard = func(v interface{}) {
+		if obj, ok := v.(*fs.Object); ok && obj != nil && obj.Body != nil {
+			obj.Body.Close()
+		}
+	}
+	return b
+}
+
+func newStateStoreBreaker() *circuitBreaker {
+	b := newCircuitBreaker("state", defaultStateReadTimeout, defaultBreakerThreshold, defaultBreakerCooldown)
+	b.isFailure = func(err error) bool {
+		return err != nil && err != ErrRecordNotFound && err != state.ErrNotFound
+	}
+	return b
+}
@@ -0,0 +1,173 @@
+package rs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DiskBudget limits the disk space taken by the state store and the IPFS repo together.
+// Over the budget local writes are refused and content of records announced by peers is
+// not pinned, a node that runs out of disk may corrupt value logs of badger. Deletes and
+// dropping versions are still allowed, so space can be reclaimed with GC.
+type DiskBudget struct {
+	// MaxUsage is the budget in bytes, zero disables it.
+	MaxUsage uint64
+	// Warnings are shares of the budget, e.g. 0.9, a warning is logged once usage crosses one.
+	Warnings []float64
+}
+
+// DiskBudgetError is returned by writes while the node is over its disk budget.
+type DiskBudgetError struct {
+	Usage    uint64
+	MaxUsage uint64
+}
+
+func (d *DiskBudgetError) Error() string {
+	return fmt.Sprintf("disk usage of %d bytes is over the budget of %d bytes, writes are refused until space is reclaimed",
+		d.Usage, d.MaxUsage)
+}
+
+type DiskUsageStatus struct {
+	MaxUsage  uint64    `json:"max_usage,omitempty"`
+	Warnings  []float64 `json:"warnings,omitempty"`
+	Usage     uint64    `json:"usage"`
+	StateSize uint64    `json:"state_size"`
+	RepoSize  uint64    `json:"repo_size"`
+	// Warning is the highest warning share crossed by the usage.
+	Warning    float64   `json:"warning,omitempty"`
+	OverBudget bool      `json:"over_budget"`
+	MeasuredAt time.Time `json:"measured_at,omitempty"`
+
+	WritesRefused uint64 `json:"writes_refused_total"`
+	PinsSkipped   uint64 `json:"pins_skipped_total"`
+}
+
+type diskBudgetState struct {
+	policy *DiskBudget
+	over   int32
+
+	refused uint64
+	skipped uint64
+
+	mux    *sync.RWMutex
+	status DiskUsageStatus
+}
+
+func newDiskBudgetState(policy *DiskBudget) *diskBudgetState {
+	if policy == nil {
+		policy = &DiskBudget{}
+	}
+	warnings := append([]float64(nil), policy.Warnings...)
+	sort.Float64s(warnings)
+	return &diskBudgetState{
+		policy: &DiskBudget{
+			MaxUsage: policy.MaxUsage,
+			Warnings: warnings,
+		},
+		mux: new(sync.RWMutex),
+	}
+}
+
+// CheckProduce returns a *DiskBudgetError if the last measurement was over the budget.
+func (d *diskBudgetState) CheckProduce() error {
+	if atomic.LoadInt32(&d.over) == 0 {
+		return nil
+	}
+	atomic.AddUint64(&d.refused, 1)
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	return &DiskBudgetError{
+		Usage:    d.status.Usage,
+		MaxUsage: d.policy.MaxUsage,
+	}
+}
+
+// allowPin tells whether content of records may be pinned, skipped pins are counted.
+func (d *diskBudgetState) allowPin() bool {
+	if atomic.LoadInt32(&d.over) == 0 {
+		return true
+	}
+	atomic.AddUint64(&d.skipped, 1)
+	return false
+}
+
+func (d *diskBudgetState) update(stateSize, repoSize uint64) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	prev := d.status
+	d.status.StateSize = stateSize
+	d.status.RepoSize = repoSize
+	d.status.Usage = stateSize + repoSize
+	d.status.MeasuredAt = time.Now()
+	if d.policy.MaxUsage == 0 {
+		return
+	}
+	share := float64(d.status.Usage) / float64(d.policy.MaxUsage)
+	d.status.Warning = 0
+	for _, w := range d.policy.Warnings {
+		if share >= w {
+			d.status.Warning = w
+		}
+	}
+	d.status.OverBudget = d.status.Usage >= d.policy.MaxUsage
+	fields := log.Fields{
+		"usage":      d.status.Usage,
+		"state_size": stateSize,
+		"repo_size":  repoSize,
+		"max_usage":  d.policy.MaxUsage,
+	}
+	switch {
+	case d.status.OverBudget && !prev.OverBudget:
+		atomic.StoreInt32(&d.over, 1)
+		log.WithFields(fields).Errorln("disk usage is over the budget, refusing writes and pins of new content")
+	case !d.status.OverBudget && prev.OverBudget:
+		atomic.StoreInt32(&d.over, 0)
+		log.WithFields(fields).Warningln("disk usage is back within the budget, accepting writes")
+	case d.status.Warning > prev.Warning:
+		log.WithFields(fields).Warningf("disk usage is at %.0f%% of the budget", share*100)
+	}
+}
+
+// TrackDiskUsage periodically measures the state store and the IPFS repo against the disk budget.
+func (r *recordStore) TrackDiskUsage(ctx context.Context, interval time.Duration) {
+	t := time.NewTimer(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			r.measureDiskUsage()
+			t.Reset(interval)
+		}
+	}
+}
+
+func (r *recordStore) measureDiskUsage() {
+	var stateSize, repoSize uint64
+	if stats := r.ss.Stats(); stats != nil && stats.DiskUsage > 0 {
+		stateSize = uint64(stats.DiskUsage)
+	}
+	if stats := r.fs.RepoStats(); stats != nil {
+		repoSize = stats.RepoSize
+	} else {
+		log.Warningln("failed to get IPFS repo stats, disk usage is measured with the state store only")
+	}
+	r.budget.update(stateSize, repoSize)
+}
+
+func (r *recordStore) DiskUsageStatus() *DiskUsageStatus {
+	r.budget.mux.RLock()
+	status := r.budget.status
+	r.budget.mux.RUnlock()
+	status.MaxUsage = r.budget.policy.MaxUsage
+	status.Warnings = r.budget.policy.Warnings
+	status.WritesRefused = atomic.LoadUint64(&r.budget.refused)
+	status.PinsSkipped = atomic.LoadUint64(&r.budget.skipped)
+	return &status
+}
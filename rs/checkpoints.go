@@ -0,0 +1,81 @@
+package rs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+var (
+	ErrCheckpointNotFound = errors.New("checkpoint not found")
+	ErrCheckpointName     = errors.New("checkpoint name must be 1 to 26 bytes long")
+)
+
+// Checkpoint is a named point in time of the local index, it can be used
+// to read records as they were current at that moment.
+type Checkpoint struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+func (r *recordStore) CreateCheckpoint(name string) (*Checkpoint, error) {
+	if len(name) == 0 || len(name) > 26 {
+		return nil, ErrCheckpointName
+	}
+	cp := &Checkpoint{
+		Name: name,
+		Time: time.Now().UTC(),
+	}
+	k := state.CheckpointsKey(name)
+	if err := r.ss.Update(context.Background(), k, func(k *state.Key, v []byte) ([]byte, error) {
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint64(buf, uint64(cp.Time.UnixNano()))
+		return buf, nil
+	}); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (r *recordStore) GetCheckpoint(name string) (*Checkpoint, error) {
+	if len(name) == 0 || len(name) > 26 {
+		return nil, ErrCheckpointName
+	}
+	var cp *Checkpoint
+	k := state.CheckpointsKey(name)
+	if err := r.ss.View(context.Background(), k, func(k *state.Key, v []byte) error {
+		if len(v) != 8 {
+			return nil
+		}
+		cp = &Checkpoint{
+			Name: name,
+			Time: time.Unix(0, int64(binary.BigEndian.Uint64(v))).UTC(),
+		}
+		return nil
+	}); err == state.ErrNotFound {
+		return nil, ErrCheckpointNotFound
+	} else if err != nil {
+		return nil, err
+	} else if cp == nil {
+		return nil, ErrCheckpointNotFound
+	}
+	return cp, nil
+}
+
+// versionAsOf returns the record version that was current at the given time.
+func versionAsOf(rec *Record, asOf time.Time) string {
+	ts := asOf.UnixNano()
+	if cur := rec.Current(); cur.Announce().Timestamp() <= ts {
+		return cur.Version()
+	}
+	prev := rec.Previous()
+	for i := prev.Len() - 1; i >= 0; i-- {
+		if ver := prev.At(i); ver.Announce().Timestamp() <= ts {
+			return ver.Version()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,297 @@
+package rs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+)
+
+// NodeTimeHeader carries the local time of a node in ping responses, in Unix nanoseconds.
+const NodeTimeHeader = "X-Node-Time"
+
+const (
+	defaultClockSkewWarn = 2 * time.Second
+	defaultClockSkewMax  = 30 * time.Second
+	// clockPeerTTL is how long an offset observed from a peer is considered.
+	clockPeerTTL = time.Hour
+	// clockMinPeers is the number of peers required to estimate skew without NTP.
+	clockMinPeers  = 3
+	ntpTimeout     = 5 * time.Second
+	ntpEpochOffset = 2208988800
+)
+
+// ClockSkewError is returned by writes when the local clock is off too much,
+// records produced with a bad clock would break ordering of versions and TTLs.
+type ClockSkewError struct {
+	Skew   time.Duration
+	Max    time.Duration
+	Source string
+}
+
+func (c *ClockSkewError) Error() string {
+	return fmt.Sprintf("local clock is off by %s according to %s, max allowed skew is %s", c.Skew, c.Source, c.Max)
+}
+
+type ClockStatus struct {
+	NTPServer   string            `json:"ntp_server,omitempty"`
+	NTPOffset   string            `json:"ntp_offset,omitempty"`
+	NTPError    string            `json:"ntp_error,omitempty"`
+	PeerOffsets map[string]string `json:"peer_offsets"`
+	PeerMedian  string            `json:"peer_median,omitempty"`
+	// Skew is how much the local clock is ahead (positive) or behind, according to Source.
+	Skew         string    `json:"skew"`
+	Source       string    `json:"source"`
+	WarnSkew     string    `json:"warn_skew"`
+	MaxSkew      string    `json:"max_skew"`
+	Warning      bool      `json:"warning"`
+	Severe       bool      `json:"severe"`
+	RefuseWrites bool      `json:"refuse_writes"`
+	CheckedAt    time.Time `json:"checked_at,omitempty"`
+}
+
+type peerOffset struct {
+	offset time.Duration
+	seenAt time.Time
+}
+
+// clockMonitor estimates skew of the local clock using NTP and offsets observed
+// from peers in ping responses. NTP is preferred, peers are used if it's unavailable.
+type clockMonitor struct {
+	ntpServer    string
+	warnSkew     time.Duration
+	maxSkew      time.Duration
+	refuseWrites bool
+
+	mux       *sync.RWMutex
+	peers     map[string]peerOffset
+	ntpOffset time.Duration
+	ntpErr    error
+	ntpAt     time.Time
+	checkedAt time.Time
+}
+
+func newClockMonitor(opts *storeOptions) *clockMonitor {
+	return &clockMonitor{
+		ntpServer:    opts.NTPServer,
+		warnSkew:     opts.ClockSkewWarn,
+		maxSkew:      opts.ClockSkewMax,
+		refuseWrites: opts.ClockRefuseWrites,
+		mux:          new(sync.RWMutex),
+		peers:        make(map[string]peerOffset),
+	}
+}
+
+// ObservePing records the offset of a peer clock, assuming the peer has read its clock
+// halfway through the round trip. Local clock is ahead when the offset is negative.
+func (c *clockMonitor) ObservePing(nodeID string, resp *http.Response, sentAt, receivedAt time.Time) {
+	nanos, err := strconv.ParseInt(resp.Header.Get(NodeTimeHeader), 10, 64)
+	if err != nil {
+		return
+	}
+	midpoint := sentAt.Add(receivedAt.Sub(sentAt) / 2)
+	c.mux.Lock()
+	c.peers[nodeID] = peerOffset{
+		offset: time.Unix(0, nanos).Sub(midpoint),
+		seenAt: time.Now(),
+	}
+	c.mux.Unlock()
+}
+
+// skew returns how much the local clock is ahead of the reference and the reference used.
+func (c *clockMonitor) skew() (time.Duration, string, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	if len(c.ntpServer) > 0 && c.ntpErr == nil && !c.ntpAt.IsZero() {
+		return -c.ntpOffset, "ntp", true
+	}
+	offsets := c.peerOffsets()
+	if len(offsets) < clockMinPeers {
+		return 0, "none", false
+	}
+	return -median(offsets), "peers", true
+}
+
+func (c *clockMonitor) peerOffsets() []time.Duration {
+	var offsets []time.Duration
+	for _, p := range c.peers {
+		if time.Since(p.seenAt) < clockPeerTTL {
+			offsets = append(offsets, p.offset)
+		}
+	}
+	return offsets
+}
+
+func median(list []time.Duration) time.Duration {
+	if len(list) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), list...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func (c *clockMonitor) CheckProduce() error {
+	if !c.refuseWrites {
+		return nil
+	}
+	skew, source, ok := c.skew()
+	if ok && abs(skew) > c.maxSkew {
+		return &ClockSkewError{
+			Skew:   skew,
+			Max:    c.maxSkew,
+			Source: source,
+		}
+	}
+	return nil
+}
+
+func (c *clockMonitor) check() {
+	if len(c.ntpServer) > 0 {
+		offset, err := queryNTP(c.ntpServer, ntpTimeout)
+		c.mux.Lock()
+		c.ntpOffset, c.ntpErr = offset, err
+		if err == nil {
+			c.ntpAt = time.Now()
+		}
+		c.mux.Unlock()
+		if err != nil {
+			log.WithField("server", c.ntpServer).Debugf("failed to query NTP: %v", err)
+		}
+	}
+	c.mux.Lock()
+	c.checkedAt = time.Now()
+	c.mux.Unlock()
+	skew, source, ok := c.skew()
+	if !ok {
+		return
+	}
+	switch {
+	case abs(skew) > c.maxSkew:
+		log.WithField("alert", "clock-skew").Errorf("local clock is off by %s according to %s, fix time synchronization", skew, source)
+	case abs(skew) > c.warnSkew:
+		log.WithField("alert", "clock-skew").Warningf("local clock is off by %s according to %s", skew, source)
+	}
+}
+
+func (c *clockMonitor) Status() *ClockStatus {
+	skew, source, ok := c.skew()
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	status := &ClockStatus{
+		NTPServer:    c.ntpServer,
+		PeerOffsets:  make(map[string]string, len(c.peers)),
+		Skew:         skew.String(),
+		Source:       source,
+		WarnSkew:     c.warnSkew.String(),
+		MaxSkew:      c.maxSkew.String(),
+		Warning:      ok && abs(skew) > c.warnSkew,
+		Severe:       ok && abs(skew) > c.maxSkew,
+		RefuseWrites: c.refuseWrites,
+		CheckedAt:    c.checkedAt,
+	}
+	if c.ntpErr != nil {
+		status.NTPError = c.ntpErr.Error()
+	} else if !c.ntpAt.IsZero() {
+		status.NTPOffset = c.ntpOffset.String()
+	}
+	for nodeID, p := range c.peers {
+		if time.Since(p.seenAt) < clockPeerTTL {
+			status.PeerOffsets[nodeID] = p.offset.String()
+		}
+	}
+	if offsets := c.peerOffsets(); len(offsets) > 0 {
+		status.PeerMedian = median(offsets).String()
+	}
+	return status
+}
+
+var errNTPResponse = errors.New("bad NTP response")
+
+// queryNTP returns the offset of the server clock relative to the local one, using SNTP.
+func queryNTP(server string, timeout time.Duration) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	req := make([]byte, 48)
+	// LI = 0, version = 3, mode = 3 (client)
+	req[0] = 0x1b
+	sentAt := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	if n, err := conn.Read(resp); err != nil {
+		return 0, err
+	} else if n < 48 || resp[1] == 0 {
+		// stratum 0 is a kiss-of-death packet
+		return 0, errNTPResponse
+	}
+	receivedAt := time.Now()
+	serverReceived := ntpTime(resp[32:40])
+	serverSent := ntpTime(resp[40:48])
+	return (serverReceived.Sub(sentAt) + serverSent.Sub(receivedAt)) / 2, nil
+}
+
+func ntpTime(b []byte) time.Time {
+	sec := binary.BigEndian.Uint32(b[0:4])
+	frac := binary.BigEndian.Uint32(b[4:8])
+	nsec := (uint64(frac) * 1e9) >> 32
+	return time.Unix(int64(sec)-ntpEpochOffset, int64(nsec))
+}
+
+// MonitorClock periodically checks skew of the local clock against NTP and the nodes
+// listed by authorities.
+func (r *recordStore) MonitorClock(ctx context.Context, interval time.Duration) {
+	t := time.NewTimer(time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			var nodeIDs []string
+			for key := range authcenter.Default.Entries() {
+				if key != r.nodeID {
+					nodeIDs = append(nodeIDs, key)
+				}
+			}
+			// pingNode records clock offsets of the nodes that respond
+			r.aliveNodes(ctx, nodeIDs)
+			r.clock.check()
+			t.Reset(interval)
+		}
+	}
+}
+
+func (r *recordStore) ClockStatus() *ClockStatus {
+	return r.clock.Status()
+}
@@ -0,0 +1,480 @@
+package rs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// The cold tier lets archive nodes keep the full history of records without disks to match:
+// versions not read for a while are written as CAR streams to a cold store, e.g. an S3 bucket,
+// and unpinned, so garbage collection of the repo frees their blocks. Offloaded versions are
+// mapped to their keys in the cold_objects state bucket, and a read of one imports its blocks
+// back and pins them before the object is served. Rehydrated versions keep their copy in the
+// cold store, so offloading them again unpins them only. Copies of versions no record refers
+// to anymore are deleted by the next pass. Peers can't fetch offloaded content from the node.
+// Reads are tracked in memory, versions are never offloaded sooner than After since the start.
+
+var ErrColdTierRunning = errors.New("cold tier pass is running already")
+
+const (
+	coldSpoolFilename = "atlant-cold"
+	coldObjectSuffix  = ".car"
+)
+
+// ColdTierPolicy sets when versions are offloaded to the store, a nil store disables the tier.
+type ColdTierPolicy struct {
+	Store fs.ColdStore
+	// After is the time since the last read, or the announce if it wasn't read, a version
+	// stays pinned for.
+	After    time.Duration
+	Interval time.Duration
+	// MaxOffloads bounds versions offloaded by a pass.
+	MaxOffloads int
+}
+
+// DefaultColdTierPolicy offloads versions not read for 30 days, checked every hour.
+func DefaultColdTierPolicy() *ColdTierPolicy {
+	return &ColdTierPolicy{
+		After:       30 * 24 * time.Hour,
+		Interval:    time.Hour,
+		MaxOffloads: 1000,
+	}
+}
+
+// Enabled tells whether the policy has a store to offload versions to.
+func (p *ColdTierPolicy) Enabled() bool {
+	return p != nil && p.Store != nil && p.After > 0
+}
+
+// ColdObject maps a version to its copy in the cold store.
+type ColdObject struct {
+	Version      string    `json:"version"`
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	OffloadedAt  time.Time `json:"offloaded_at"`
+	RehydratedAt time.Time `json:"rehydrated_at,omitempty"`
+}
+
+// Offloaded tells whether the version was unpinned since it was rehydrated last.
+func (o *ColdObject) Offloaded() bool {
+	return o.OffloadedAt.After(o.RehydratedAt)
+}
+
+// ColdTierStatus reports the cold tier and its passes.
+type ColdTierStatus struct {
+	Enabled    bool      `json:"enabled"`
+	Store      string    `json:"store,omitempty"`
+	After      string    `json:"after,omitempty"`
+	Running    bool      `json:"running"`
+	LastPassAt time.Time `json:"last_pass_at,omitempty"`
+	// Objects are versions offloaded now, Bytes is the size of their copies.
+	Objects    int    `json:"objects"`
+	Bytes      int64  `json:"bytes"`
+	Offloaded  uint64 `json:"offloaded_total"`
+	Rehydrated uint64 `json:"rehydrated_total"`
+	Deleted    uint64 `json:"deleted_total"`
+	Failures   uint64 `json:"failures_total"`
+	LastError  string `json:"last_error,omitempty"`
+}
+
+type coldTierState struct {
+	policy     *ColdTierPolicy
+	started    time.Time
+	running    int32
+	offloads   uint64
+	rehydrated uint64
+	deleted    uint64
+	failures   uint64
+
+	mux *sync.Mutex
+	// accessed are times of the last reads of versions since the start
+	accessed map[string]time.Time
+	// offloaded are sizes of copies of versions offloaded now
+	offloaded map[string]int64
+	// pending are rehydrations in progress, closed once they end
+	pending  map[string]chan struct{}
+	lastPass time.Time
+	lastErr  string
+}
+
+func newColdTierState(policy *ColdTierPolicy) *coldTierState {
+	return &coldTierState{
+		policy:    policy,
+		started:   time.Now(),
+		mux:       new(sync.Mutex),
+		accessed:  make(map[string]time.Time),
+		offloaded: make(map[string]int64),
+		pending:   make(map[string]chan struct{}),
+	}
+}
+
+func (c *coldTierState) enabled() bool {
+	return c.policy.Enabled()
+}
+
+func (c *coldTierState) touch(version string) {
+	if !c.enabled() {
+		return
+	}
+	c.mux.Lock()
+	c.accessed[version] = time.Now()
+	c.mux.Unlock()
+}
+
+// isOffloaded tells whether the version has to be rehydrated before it's read.
+func (c *coldTierState) isOffloaded(version string) bool {
+	if !c.enabled() {
+		return false
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	_, ok := c.offloaded[version]
+	return ok
+}
+
+// lastAccess returns the time of the last read of the version, of its announce at ts
+// if it's not read since the start, but never earlier than the start.
+func (c *coldTierState) lastAccess(version string, ts int64) time.Time {
+	last := c.started
+	if announced := time.Unix(0, ts); announced.After(last) {
+		last = announced
+	}
+	if accessed, ok := c.accessed[version]; ok && accessed.After(last) {
+		last = accessed
+	}
+	return last
+}
+
+func coldObjectKey(version string) string {
+	return version + coldObjectSuffix
+}
+
+func (r *recordStore) loadColdObject(ctx context.Context, version string) (*ColdObject, error) {
+	var obj *ColdObject
+	if err := r.ss.View(ctx, state.ColdObjectsKey(version), func(_ *state.Key, v []byte) error {
+		return json.Unmarshal(v, &obj)
+	}); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (r *recordStore) saveColdObject(ctx context.Context, obj *ColdObject) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return r.ss.Update(ctx, state.ColdObjectsKey(obj.Version), func(_ *state.Key, _ []byte) ([]byte, error) {
+		return data, nil
+	})
+}
+
+// loadColdObjects reads versions offloaded by the previous run, so their reads rehydrate them.
+func (r *recordStore) loadColdObjects() {
+	if !r.cold.enabled() {
+		return
+	}
+	b := state.NewBucket(state.BucketColdObjects)
+	if _, err := r.ss.RangePeek(context.Background(), b, func(_ *state.Key, v []byte) error {
+		var obj ColdObject
+		if err := json.Unmarshal(v, &obj); err != nil {
+			log.Debugf("skipping malformed cold object: %v", err)
+			return nil
+		} else if obj.Offloaded() {
+			r.cold.mux.Lock()
+			r.cold.offloaded[obj.Version] = obj.Size
+			r.cold.mux.Unlock()
+		}
+		return nil
+	}); err != nil {
+		log.Warningf("failed to load versions offloaded to the cold tier: %v", err)
+	}
+}
+
+// rehydrate imports blocks of an offloaded version from the cold store and pins them,
+// concurrent reads of the version wait for the first one.
+func (r *recordStore) rehydrate(ctx context.Context, version string) error {
+	if !r.cold.isOffloaded(version) {
+		return nil
+	}
+	r.cold.mux.Lock()
+	if done, ok := r.cold.pending[version]; ok {
+		r.cold.mux.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			return nil
+		}
+	}
+	done := make(chan struct{})
+	r.cold.pending[version] = done
+	r.cold.mux.Unlock()
+	defer func() {
+		r.cold.mux.Lock()
+		delete(r.cold.pending, version)
+		r.cold.mux.Unlock()
+		close(done)
+	}()
+	obj, err := r.loadColdObject(ctx, version)
+	if err != nil {
+		return err
+	}
+	body, err := r.cold.policy.Store.Get(ctx, obj.Key)
+	if err != nil {
+		atomic.AddUint64(&r.cold.failures, 1)
+		return err
+	}
+	defer body.Close()
+	blocks, err := r.fs.ImportBlocks(ctx, body)
+	if err != nil {
+		atomic.AddUint64(&r.cold.failures, 1)
+		return err
+	} else if err := r.fs.PinObject(fs.ObjectRef{
+		Version: version,
+	}); err != nil {
+		atomic.AddUint64(&r.cold.failures, 1)
+		return err
+	}
+	obj.RehydratedAt = time.Now().UTC()
+	if err := r.saveColdObject(ctx, obj); err != nil {
+		return err
+	}
+	r.cold.mux.Lock()
+	delete(r.cold.offloaded, version)
+	r.cold.accessed[version] = time.Now()
+	r.cold.mux.Unlock()
+	atomic.AddUint64(&r.cold.rehydrated, 1)
+	log.WithFields(log.Fields{
+		"version": version,
+		"blocks":  blocks,
+	}).Debugln("rehydrated version from the cold tier")
+	return nil
+}
+
+// offload writes blocks of the version to the cold store, unless a copy is there already,
+// then maps the version to the copy and unpins it.
+func (r *recordStore) offload(ctx context.Context, version string) error {
+	obj, err := r.loadColdObject(ctx, version)
+	if err == state.ErrNotFound {
+		spool, err := ioutil.TempFile("", coldSpoolFilename)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		if err := r.fs.ExportBlocks(ctx, []string{version}, spool); err != nil {
+			return err
+		}
+		size, err := spool.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		} else if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		obj = &ColdObject{
+			Version: version,
+			Key:     coldObjectKey(version),
+			Size:    size,
+		}
+		if err := r.cold.policy.Store.Put(ctx, obj.Key, spool, size); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	obj.OffloadedAt = time.Now().UTC()
+	if err := r.saveColdObject(ctx, obj); err != nil {
+		return err
+	}
+	r.cold.mux.Lock()
+	r.cold.offloaded[version] = obj.Size
+	delete(r.cold.accessed, version)
+	r.cold.mux.Unlock()
+	r.unpinVersions([]string{version})
+	return nil
+}
+
+// RunColdTier offloads versions not read for the After of the policy every its Interval.
+func (r *recordStore) RunColdTier(ctx context.Context) {
+	if !r.cold.enabled() {
+		return
+	}
+	log.WithFields(log.Fields{
+		"store": r.cold.policy.Store.String(),
+		"after": r.cold.policy.After,
+	}).Infoln("cold tier is enabled")
+	var tickC <-chan time.Time
+	if r.cold.policy.Interval > 0 {
+		t := time.NewTicker(r.cold.policy.Interval)
+		defer t.Stop()
+		tickC = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tickC:
+		}
+		if !r.IsReady() {
+			continue
+		}
+		if err := r.ApplyColdTier(ctx); err != nil && err != ErrColdTierRunning {
+			log.Warningf("cold tier pass failed: %v", err)
+		}
+	}
+}
+
+// ApplyColdTier runs a cold tier pass now, ErrColdTierRunning is returned if one is in progress.
+func (r *recordStore) ApplyColdTier(ctx context.Context) (err error) {
+	if !r.cold.enabled() {
+		return nil
+	} else if !atomic.CompareAndSwapInt32(&r.cold.running, 0, 1) {
+		return ErrColdTierRunning
+	}
+	defer atomic.StoreInt32(&r.cold.running, 0)
+	defer func() {
+		r.cold.mux.Lock()
+		r.cold.lastPass = time.Now().UTC()
+		r.cold.lastErr = ""
+		if err != nil {
+			r.cold.lastErr = err.Error()
+		}
+		r.cold.mux.Unlock()
+	}()
+	pinned := make(map[string]struct{})
+	for _, version := range r.fs.PinnedObjects() {
+		pinned[version] = struct{}{}
+	}
+	cutoff := time.Now().Add(-r.cold.policy.After)
+	live := make(map[string]struct{})
+	var offloads []string
+	mux := new(sync.Mutex)
+	b := state.NewBucket(state.BucketRecords)
+	if err := state.Stream(ctx, r.ss, b, 0, proto.RecordPeek(func(_ *state.Key, v *proto.Record) error {
+		mux.Lock()
+		defer mux.Unlock()
+		r.cold.mux.Lock()
+		defer r.cold.mux.Unlock()
+		for _, ver := range append([]proto.RecordVersion{v.Current()}, v.Previous().ToArray()...) {
+			version := ver.Version()
+			live[version] = struct{}{}
+			if _, ok := pinned[version]; !ok {
+				continue
+			} else if r.cold.lastAccess(version, ver.Announce().Timestamp()).After(cutoff) {
+				continue
+			}
+			// versions shared by records are offloaded once
+			delete(pinned, version)
+			offloads = append(offloads, version)
+		}
+		return nil
+	})); err != nil {
+		return err
+	}
+	if max := r.cold.policy.MaxOffloads; max > 0 && len(offloads) > max {
+		log.Debugf("cold tier pass offloads %d of %d versions", max, len(offloads))
+		offloads = offloads[:max]
+	}
+	var offloaded int
+	for _, version := range offloads {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := r.offload(ctx, version); err != nil {
+			atomic.AddUint64(&r.cold.failures, 1)
+			log.WithField("version", version).Warningf("failed to offload version to the cold tier: %v", err)
+			continue
+		}
+		offloaded++
+	}
+	atomic.AddUint64(&r.cold.offloads, uint64(offloaded))
+	deleted := r.dropColdObjects(ctx, live)
+	r.cold.mux.Lock()
+	for version, accessed := range r.cold.accessed {
+		if accessed.Before(cutoff) {
+			delete(r.cold.accessed, version)
+		}
+	}
+	r.cold.mux.Unlock()
+	if offloaded > 0 || deleted > 0 {
+		log.WithFields(log.Fields{
+			"offloaded": offloaded,
+			"deleted":   deleted,
+		}).Infoln("cold tier applied")
+	}
+	return nil
+}
+
+// dropColdObjects deletes copies of versions no record refers to anymore.
+func (r *recordStore) dropColdObjects(ctx context.Context, live map[string]struct{}) int {
+	var drops []*ColdObject
+	b := state.NewBucket(state.BucketColdObjects)
+	if _, err := r.ss.RangePeek(ctx, b, func(_ *state.Key, v []byte) error {
+		var obj ColdObject
+		if err := json.Unmarshal(v, &obj); err != nil {
+			return nil
+		} else if _, ok := live[obj.Version]; !ok {
+			drops = append(drops, &obj)
+		}
+		return nil
+	}); err != nil {
+		log.Warningf("failed to list versions of the cold tier: %v", err)
+		return 0
+	}
+	var deleted int
+	for _, obj := range drops {
+		if err := r.cold.policy.Store.Delete(ctx, obj.Key); err != nil {
+			atomic.AddUint64(&r.cold.failures, 1)
+			log.WithField("version", obj.Version).Debugf("failed to delete cold copy: %v", err)
+			continue
+		} else if err := r.ss.Delete(state.ColdObjectsKey(obj.Version)); err != nil {
+			log.WithField("version", obj.Version).Debugf("failed to delete cold object: %v", err)
+			continue
+		}
+		r.cold.mux.Lock()
+		delete(r.cold.offloaded, obj.Version)
+		r.cold.mux.Unlock()
+		deleted++
+	}
+	atomic.AddUint64(&r.cold.deleted, uint64(deleted))
+	return deleted
+}
+
+func (r *recordStore) ColdTierStatus() *ColdTierStatus {
+	status := &ColdTierStatus{
+		Enabled:    r.cold.enabled(),
+		Running:    atomic.LoadInt32(&r.cold.running) == 1,
+		Offloaded:  atomic.LoadUint64(&r.cold.offloads),
+		Rehydrated: atomic.LoadUint64(&r.cold.rehydrated),
+		Deleted:    atomic.LoadUint64(&r.cold.deleted),
+		Failures:   atomic.LoadUint64(&r.cold.failures),
+	}
+	if !status.Enabled {
+		return status
+	}
+	status.Store = r.cold.policy.Store.String()
+	status.After = r.cold.policy.After.String()
+	r.cold.mux.Lock()
+	defer r.cold.mux.Unlock()
+	status.LastPassAt = r.cold.lastPass
+	status.LastError = r.cold.lastErr
+	status.Objects = len(r.cold.offloaded)
+	for _, size := range r.cold.offloaded {
+		status.Bytes += size
+	}
+	return status
+}
@@ -0,0 +1,111 @@
+package rs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Record updates of confidential namespaces are sealed before they're announced, so peers
+// that relay the announce or don't follow the namespace can't read the record ID and the
+// version, hence can't look up the path, size or writer of the object either. The sealed
+// envelope replaces the packed EnvelopeRecordUpdate in the announce:
+//
+//	"ANENV1\n" | header length, uint32 | JSON envelopeHeader | nonce | secretbox
+//
+// The envelope key is random and wrapped with the key of the namespace like data keys of
+// encrypted content. The announce is signed over the sealed envelope, so relays still check
+// its signature. The namespace itself is not hidden, it routes the announce to its topic.
+
+const (
+	envelopeMagic     = "ANENV1\n"
+	envelopeMaxHeader = 1024
+)
+
+// ErrSealedEnvelope is returned for malformed sealed envelopes.
+var ErrSealedEnvelope = errors.New("sealed record update is damaged")
+
+type envelopeHeader struct {
+	Namespace  string `json:"namespace"`
+	KeyID      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+func isSealedEnvelope(data []byte) bool {
+	return len(data) >= len(envelopeMagic) && string(data[:len(envelopeMagic)]) == envelopeMagic
+}
+
+// sealEnvelope returns the envelope sealed if the namespace is confidential.
+func (r *recordStore) sealEnvelope(ctx context.Context, ns string, data []byte) ([]byte, error) {
+	if !r.encryption.confidential[ns] {
+		return data, nil
+	}
+	envKey := new([32]byte)
+	if _, err := rand.Read(envKey[:]); err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := r.encryption.keys.WrapKey(ctx, ns, envKey[:])
+	if err != nil {
+		return nil, err
+	}
+	head, err := json.Marshal(&envelopeHeader{
+		Namespace:  ns,
+		KeyID:      keyID,
+		WrappedKey: wrapped,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(envelopeMagic)+4+len(head)+len(nonce)+len(data)+secretbox.Overhead)
+	out = append(out, envelopeMagic...)
+	out = append(out, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(out[len(envelopeMagic):], uint32(len(head)))
+	out = append(out, head...)
+	out = append(out, nonce[:]...)
+	return secretbox.Seal(out, data, &nonce, envKey), nil
+}
+
+// openEnvelope returns the packed envelope of a sealed one, other envelopes are returned as is.
+// ErrNoNamespaceKey is returned if this node doesn't hold the key of the namespace.
+func (r *recordStore) openEnvelope(ctx context.Context, data []byte) ([]byte, error) {
+	if !isSealedEnvelope(data) {
+		return data, nil
+	}
+	data = data[len(envelopeMagic):]
+	if len(data) < 4 {
+		return nil, ErrSealedEnvelope
+	}
+	n := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if n == 0 || n > envelopeMaxHeader || int(n)+24 > len(data) {
+		return nil, ErrSealedEnvelope
+	}
+	var hdr envelopeHeader
+	if err := json.Unmarshal(data[:n], &hdr); err != nil {
+		return nil, ErrSealedEnvelope
+	}
+	data = data[n:]
+	envKey, err := r.encryption.keys.UnwrapKey(ctx, hdr.Namespace, hdr.KeyID, hdr.WrappedKey)
+	if err == ErrSealedDamaged || err == nil && len(envKey) != 32 {
+		return nil, ErrSealedEnvelope
+	} else if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	copy(nonce[:], data[:24])
+	var key [32]byte
+	copy(key[:], envKey)
+	plain, ok := secretbox.Open(nil, data[24:], &nonce, &key)
+	if !ok {
+		return nil, ErrSealedEnvelope
+	}
+	return plain, nil
+}
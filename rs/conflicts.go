@@ -0,0 +1,298 @@
+package rs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Writes of a record conflict when a node writes a version on top of one that is no longer
+// current elsewhere, i.e. two nodes wrote the record without seeing each other's version.
+// Announces and synced records whose previous version is already superseded locally are
+// resolved by the conflict policy of the node, the losing version stays in the history of
+// the record. Updates on top of a version this node hasn't seen yet are applied as before,
+// the missing version arrives later and is just history then.
+//
+// Conflicts are kept in the state store, automatically resolved ones for conflictTTL and
+// unresolved ones until an operator dismisses them, see ListConflicts.
+
+const (
+	// ConflictLastWriter makes the version announced last current, ties are broken by version.
+	ConflictLastWriter = "last-writer"
+	// ConflictSignerPriority makes the version of the writer with the highest priority in the
+	// authority current, see authcenter.Priority, ties are broken like ConflictLastWriter.
+	ConflictSignerPriority = "signer-priority"
+	// ConflictManual keeps the local version current and leaves conflicts to an operator.
+	ConflictManual = "manual"
+)
+
+const conflictTTL = 30 * 24 * time.Hour
+
+var (
+	// ErrConflictUnresolved is returned by a ConflictResolver that leaves the conflict to an operator.
+	ErrConflictUnresolved = errors.New("conflict is left to an operator")
+	ErrConflictNotFound   = errors.New("conflict not found")
+)
+
+// ConflictVersion is one of the versions of a record written concurrently.
+type ConflictVersion struct {
+	Version string    `json:"version"`
+	NodeID  string    `json:"node_id"`
+	Time    time.Time `json:"time"`
+	// Local is set for the version that was current on this node.
+	Local bool `json:"local,omitempty"`
+}
+
+// Conflict is a record written concurrently by a few nodes.
+type Conflict struct {
+	ID       string             `json:"id"`
+	RecordID string             `json:"record_id"`
+	Path     string             `json:"path"`
+	Versions []*ConflictVersion `json:"versions"`
+	Strategy string             `json:"strategy"`
+	// Winner is the version made current, it's empty while the conflict is unresolved.
+	Winner     string     `json:"winner,omitempty"`
+	Resolved   bool       `json:"resolved"`
+	Error      string     `json:"error,omitempty"`
+	DetectedAt time.Time  `json:"detected_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// ConflictResolver picks the version of a conflict made current. It's called while the
+// record is updated, so it must not access the record store. ErrConflictUnresolved keeps
+// the local version current until an operator resolves the conflict.
+type ConflictResolver func(c *Conflict) (*ConflictVersion, error)
+
+// ConflictPolicy picks the current version when writes of a record conflict.
+type ConflictPolicy struct {
+	// Strategy is ConflictLastWriter, ConflictSignerPriority or ConflictManual,
+	// ConflictLastWriter by default.
+	Strategy string
+	// Resolve replaces the strategy if set.
+	Resolve ConflictResolver
+}
+
+// ValidConflictStrategy tells whether the strategy is known.
+func ValidConflictStrategy(strategy string) bool {
+	switch strategy {
+	case ConflictLastWriter, ConflictSignerPriority, ConflictManual:
+		return true
+	}
+	return false
+}
+
+type conflictState struct {
+	ss       state.IndexedStore
+	strategy string
+	resolve  ConflictResolver
+}
+
+func newConflictState(ss state.IndexedStore, policy *ConflictPolicy) *conflictState {
+	c := &conflictState{
+		ss:       ss,
+		strategy: ConflictLastWriter,
+		resolve:  resolveLastWriter,
+	}
+	if policy == nil {
+		return c
+	}
+	switch policy.Strategy {
+	case ConflictSignerPriority:
+		c.strategy, c.resolve = ConflictSignerPriority, resolveSignerPriority
+	case ConflictManual:
+		c.strategy, c.resolve = ConflictManual, resolveManual
+	}
+	if policy.Resolve != nil {
+		c.strategy, c.resolve = "custom", policy.Resolve
+	}
+	return c
+}
+
+func conflictVersion(ver proto.RecordVersion) *ConflictVersion {
+	ann := ver.Announce()
+	return &ConflictVersion{
+		Version: ver.Version(),
+		NodeID:  ann.NodeID(),
+		Time:    time.Unix(0, ann.Timestamp()).UTC(),
+	}
+}
+
+// recordHasVersion tells whether the version is the current or a previous one of the record.
+func recordHasVersion(v *proto.Record, version string) bool {
+	if v.Current().Version() == version {
+		return true
+	}
+	list := v.Previous()
+	for i := 0; i < list.Len(); i++ {
+		if list.At(i).Version() == version {
+			return true
+		}
+	}
+	return false
+}
+
+// conflictID is the same on every node that detects the conflict.
+func conflictID(recordID string, versions []*ConflictVersion) string {
+	list := make([]string, 0, len(versions)+1)
+	for _, v := range versions {
+		list = append(list, v.Version)
+	}
+	sort.Strings(list)
+	sum := sha256.Sum256([]byte(recordID + "\n" + strings.Join(list, "\n")))
+	return hex.EncodeToString(sum[:12])
+}
+
+// decide resolves the conflict of the local version with the incoming one, it returns true
+// if the incoming version wins. Unresolved conflicts keep the local version.
+func (c *conflictState) decide(recordID, path string, local, incoming *ConflictVersion) (*Conflict, bool) {
+	local.Local = true
+	conflict := &Conflict{
+		RecordID:   recordID,
+		Path:       path,
+		Versions:   []*ConflictVersion{local, incoming},
+		Strategy:   c.strategy,
+		DetectedAt: time.Now().UTC(),
+	}
+	conflict.ID = conflictID(recordID, conflict.Versions)
+	winner, err := c.resolve(conflict)
+	if err == nil && winner == nil {
+		err = ErrConflictUnresolved
+	}
+	if err != nil {
+		if err != ErrConflictUnresolved {
+			conflict.Error = err.Error()
+		}
+		return conflict, false
+	}
+	conflict.Winner = winner.Version
+	conflict.Resolved = true
+	conflict.ResolvedAt = &conflict.DetectedAt
+	return conflict, winner.Version == incoming.Version
+}
+
+// save keeps the conflict, a conflict detected again by sync keeps its first resolution.
+func (c *conflictState) save(ctx context.Context, conflict *Conflict) {
+	fields := log.Fields{
+		"record":   conflict.RecordID,
+		"path":     conflict.Path,
+		"strategy": conflict.Strategy,
+	}
+	if conflict.Resolved {
+		log.WithFields(fields).Infoln("resolved write conflict, version", conflict.Winner, "is current")
+	} else {
+		log.WithFields(fields).Warningln("unresolved write conflict, the local version is kept")
+	}
+	k := state.ConflictsKey(conflict.ID)
+	if conflict.Resolved {
+		k.TTL = conflictTTL
+	}
+	data, err := json.Marshal(conflict)
+	if err != nil {
+		return
+	}
+	if err := c.ss.Update(ctx, k, func(_ *state.Key, v []byte) ([]byte, error) {
+		if len(v) > 0 {
+			return nil, state.ErrNoUpdate
+		}
+		return data, nil
+	}); err != nil && err != state.ErrNoUpdate {
+		log.Warningf("failed to save write conflict: %v", err)
+	}
+}
+
+func resolveLastWriter(c *Conflict) (*ConflictVersion, error) {
+	winner := c.Versions[0]
+	for _, v := range c.Versions[1:] {
+		if v.Time.After(winner.Time) || v.Time.Equal(winner.Time) && v.Version > winner.Version {
+			winner = v
+		}
+	}
+	return winner, nil
+}
+
+func resolveSignerPriority(c *Conflict) (*ConflictVersion, error) {
+	var top []*ConflictVersion
+	max := -1
+	for _, v := range c.Versions {
+		if p := authcenter.Priority(v.NodeID); p > max {
+			top, max = []*ConflictVersion{v}, p
+		} else if p == max {
+			top = append(top, v)
+		}
+	}
+	return resolveLastWriter(&Conflict{
+		Versions: top,
+	})
+}
+
+func resolveManual(c *Conflict) (*ConflictVersion, error) {
+	return nil, ErrConflictUnresolved
+}
+
+// ListConflicts lists write conflicts detected by this node, unresolved ones only unless
+// all is set, newest first.
+func (r *recordStore) ListConflicts(ctx context.Context, all bool) ([]*Conflict, error) {
+	var list []*Conflict
+	b := state.NewBucket(state.BucketConflicts)
+	if _, err := r.ss.RangePeek(ctx, b, func(_ *state.Key, v []byte) error {
+		var c Conflict
+		if err := json.Unmarshal(v, &c); err != nil {
+			log.Debugf("skipping malformed conflict: %v", err)
+			return nil
+		}
+		if all || !c.Resolved {
+			list = append(list, &c)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].DetectedAt.After(list[j].DetectedAt)
+	})
+	return list, nil
+}
+
+// DismissConflict marks an unresolved conflict resolved by an operator, e.g. once the
+// record has been written over with the merged content.
+func (r *recordStore) DismissConflict(ctx context.Context, id string) (*Conflict, error) {
+	if len(id) == 0 || len(id) > state.MaxKeySize {
+		return nil, ErrConflictNotFound
+	}
+	var conflict *Conflict
+	k := state.ConflictsKey(id)
+	k.TTL = conflictTTL
+	err := r.ss.Update(ctx, k, func(_ *state.Key, v []byte) ([]byte, error) {
+		if len(v) == 0 {
+			return nil, ErrConflictNotFound
+		}
+		conflict = new(Conflict)
+		if err := json.Unmarshal(v, conflict); err != nil {
+			return nil, fmt.Errorf("malformed conflict: %v", err)
+		} else if conflict.Resolved {
+			return nil, state.ErrNoUpdate
+		}
+		now := time.Now().UTC()
+		conflict.Resolved = true
+		conflict.ResolvedAt = &now
+		return json.Marshal(conflict)
+	})
+	if err == state.ErrNotFound {
+		return nil, ErrConflictNotFound
+	} else if err != nil && err != state.ErrNoUpdate {
+		return nil, err
+	}
+	return conflict, nil
+}
@@ -0,0 +1,163 @@
+package rs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TaskSchedule tells when a scheduled task runs next.
+type TaskSchedule interface {
+	// Next returns the first time after t the task is due.
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule parses a cron expression of 5 fields (minute, hour, day of month, month,
+// day of week) in UTC, e.g. "*/15 * * * *" or "0 3 * * 1-5", as well as the shortcuts
+// @hourly, @daily, @weekly and @monthly, and "@every <duration>" of at least a minute.
+func ParseSchedule(spec string) (TaskSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	}
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("malformed schedule %q: %v", spec, err)
+		} else if d < time.Minute {
+			return nil, fmt.Errorf("malformed schedule %q: tasks run at most once a minute", spec)
+		}
+		return everySchedule(d), nil
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("malformed schedule %q: expected 5 fields", spec)
+	}
+	s := new(cronSchedule)
+	bounds := []struct {
+		set      *uint64
+		min, max int
+	}{
+		{&s.minutes, 0, 59},
+		{&s.hours, 0, 23},
+		{&s.days, 1, 31},
+		{&s.months, 1, 12},
+		{&s.weekdays, 0, 7},
+	}
+	for i, f := range fields {
+		set, err := parseCronField(f, bounds[i].min, bounds[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("malformed schedule %q: %v", spec, err)
+		}
+		*bounds[i].set = set
+	}
+	// both 0 and 7 are Sunday
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+	s.anyDay = fields[2] == "*"
+	s.anyWeekday = fields[4] == "*"
+	return s, nil
+}
+
+// parseCronField returns a bit set of values of a field like "*", "1,15", "1-5" or "*/10".
+func parseCronField(f string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("bad range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			lo = n
+			if step == 1 {
+				hi = n
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+
+	anyDay, anyWeekday bool
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+	// as in cron, a day matches either field if both are restricted
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	// a schedule that never matches, e.g. "0 0 31 2 *", gives up after 5 years
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		} else if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		} else if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		} else if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+type everySchedule time.Duration
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
@@ -0,0 +1,88 @@
+package rs
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+const defaultDedupWindow = 15 * time.Minute
+
+type DedupStats struct {
+	Window     string  `json:"window"`
+	Checked    uint64  `json:"checked_total"`
+	Duplicates uint64  `json:"duplicates_total"`
+	HitRate    float64 `json:"hit_rate"`
+}
+
+// inboundDedup remembers IDs of announces handled recently, so duplicate gossip
+// arrivals are dropped before signature checks, object lookups and state writes.
+// Entries expire with the TTL of the state key.
+type inboundDedup struct {
+	ss     state.IndexedStore
+	window time.Duration
+
+	checked    uint64
+	duplicates uint64
+}
+
+func newInboundDedup(ss state.IndexedStore, window time.Duration) *inboundDedup {
+	if window <= 0 {
+		window = defaultDedupWindow
+	}
+	return &inboundDedup{
+		ss:     ss,
+		window: window,
+	}
+}
+
+// Seen marks the announce as handled and reports whether it has been seen within the window.
+func (d *inboundDedup) Seen(announceID []byte) bool {
+	atomic.AddUint64(&d.checked, 1)
+	k := state.InboundSeenKey(announceID)
+	k.TTL = d.window
+	var seen bool
+	if err := d.ss.Update(context.Background(), k, func(k *state.Key, v []byte) ([]byte, error) {
+		if v != nil {
+			seen = true
+			return nil, state.ErrNoUpdate
+		}
+		return []byte{1}, nil
+	}); err != nil {
+		// better handle a duplicate than lose an announce
+		log.Debugf("failed to check inbound announce: %v", err)
+		return false
+	}
+	if seen {
+		atomic.AddUint64(&d.duplicates, 1)
+	}
+	return seen
+}
+
+// Forget allows the announce to be handled again, e.g. after a transient failure.
+func (d *inboundDedup) Forget(announceID []byte) {
+	k := state.InboundSeenKey(announceID)
+	if err := d.ss.Delete(k); err != nil && err != state.ErrNotFound {
+		log.Debugf("failed to forget inbound announce: %v", err)
+	}
+}
+
+func (d *inboundDedup) Stats() *DedupStats {
+	stats := &DedupStats{
+		Window:     d.window.String(),
+		Checked:    atomic.LoadUint64(&d.checked),
+		Duplicates: atomic.LoadUint64(&d.duplicates),
+	}
+	if stats.Checked > 0 {
+		stats.HitRate = float64(stats.Duplicates) / float64(stats.Checked)
+	}
+	return stats
+}
+
+func (r *recordStore) DedupStats() *DedupStats {
+	return r.dedup.Stats()
+}
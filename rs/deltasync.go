@@ -0,0 +1,78 @@
+package rs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Sync on start fetches only records that changed since the checkpoint of the last completed
+// sync, peers export records whose current version was announced after it. The checkpoint is
+// moved back by the sync margin, records may reach peers a while after they were announced and
+// clocks of nodes differ. Records that reached peers later than that are fetched by a full resync
+// only, it's forced with --full-resync. Peers that don't know the since param export all records.
+
+const defaultSyncMargin = time.Hour
+
+func syncCheckpointKey() *state.Key {
+	return state.SyncStateKey("checkpoint")
+}
+
+var errRecordUnchanged = errors.New("record unchanged since")
+
+// SyncCheckpoint is the last completed sync.
+type SyncCheckpoint struct {
+	// Time is when the sync started, records announced later are fetched next time.
+	Time time.Time `json:"time"`
+	// Since is the time records were fetched from, zero if all records were.
+	Since time.Time `json:"since"`
+	Peers []string  `json:"peers"`
+}
+
+func (r *recordStore) syncCheckpoint() (*SyncCheckpoint, error) {
+	var cp *SyncCheckpoint
+	if err := r.ss.View(context.Background(), syncCheckpointKey(), func(k *state.Key, v []byte) error {
+		return json.Unmarshal(v, &cp)
+	}); err == state.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (r *recordStore) saveSyncCheckpoint(cp *SyncCheckpoint) {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		log.Warningf("failed to encode sync checkpoint: %v", err)
+		return
+	}
+	if err := r.ss.Update(context.Background(), syncCheckpointKey(), func(k *state.Key, v []byte) ([]byte, error) {
+		return data, nil
+	}); err != nil {
+		log.Warningf("failed to save sync checkpoint: %v", err)
+	}
+}
+
+// syncSince returns the time records are fetched from, zero means all records.
+func (r *recordStore) syncSince() time.Time {
+	if r.opts.FullResync {
+		log.Infoln("full resync of records is forced")
+		return time.Time{}
+	}
+	cp, err := r.syncCheckpoint()
+	if err != nil {
+		log.Warningf("failed to load sync checkpoint, syncing all records: %v", err)
+		return time.Time{}
+	} else if cp == nil {
+		return time.Time{}
+	}
+	since := cp.Time.Add(-r.opts.SyncMargin)
+	log.WithField("since", since.Format(time.RFC3339)).Infoln("syncing records changed since the last sync")
+	return since
+}
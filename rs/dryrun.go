@@ -0,0 +1,173 @@
+package rs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// WritePlan describes a write that passed validation in dry-run mode.
+type WritePlan struct {
+	Op              string          `json:"op"`
+	ID              string          `json:"id,omitempty"`
+	Path            string          `json:"path"`
+	VersionPrevious string          `json:"version_previous,omitempty"`
+	Size            int64           `json:"size"`
+	UserMeta        json.RawMessage `json:"user_meta,omitempty"`
+	// Hooks lists pre-commit hooks that have accepted the write.
+	Hooks []string `json:"hooks,omitempty"`
+}
+
+func (r *recordStore) DryRunWrite(ctx context.Context, op WriteOp,
+	path string, body io.ReadCloser, opts ...CreateOptions) (*WritePlan, error) {
+	if body != nil {
+		defer body.Close()
+	}
+	if err := r.checkProduce(path); err != nil {
+		return nil, err
+	} else if op != WriteDelete {
+		if err := r.budget.CheckProduce(); err != nil {
+			return nil, err
+		}
+	}
+	var size int64
+	var userMeta []byte
+	if len(opts) > 0 {
+		size = opts[0].Size
+		userMeta = opts[0].UserMeta
+	}
+	plan := &WritePlan{
+		Op:   op.String(),
+		Path: path,
+	}
+	id, err := r.findRecordID(ctx, path, "")
+	if op == WriteCreate {
+		if len(id) > 0 {
+			return nil, ErrRecordExists
+		} else if err != ErrRecordNotFound {
+			return nil, err
+		}
+	} else {
+		if err != nil {
+			return nil, err
+		}
+		k := state.RecordsKey([]byte(id))
+		if err := r.ss.View(ctx, k, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+			if v == nil {
+				return ErrRecordNotFound
+			}
+			plan.ID = v.Id()
+			plan.Path = v.Path()
+			plan.VersionPrevious = v.Current().Version()
+			return nil
+		})); err != nil {
+			return nil, err
+		}
+	}
+	req := &WriteRequest{
+		Op:       op,
+		NodeID:   r.nodeID,
+		Path:     plan.Path,
+		UserMeta: userMeta,
+		DryRun:   true,
+	}
+	if op == WriteDelete {
+		req.UserMeta = nil
+		body, size = nil, 0
+	}
+	out, err := r.hooks.Apply(ctx, req, body)
+	if err != nil {
+		return nil, err
+	} else if out != nil {
+		out.Close()
+	}
+	if req.Body != nil {
+		size = int64(len(req.Body))
+	}
+	plan.Size = size
+	plan.UserMeta = req.UserMeta
+	for _, h := range r.hooks.matching(plan.Path) {
+		plan.Hooks = append(plan.Hooks, h.name)
+	}
+	return plan, nil
+}
+
+// SimulatedWrite is a write of a contract operation the store skipped in dry-run mode. IDs
+// derive from the path and the content, so the same write gets the same ID in every run.
+type SimulatedWrite struct {
+	ID     string    `json:"id"`
+	Kind   string    `json:"kind"`
+	Path   string    `json:"path"`
+	Size   int64     `json:"size"`
+	Digest string    `json:"digest"`
+	At     time.Time `json:"at"`
+}
+
+// maxSimulatedWrites limits the log, the oldest writes are dropped first.
+const maxSimulatedWrites = 1000
+
+type simulatedLog struct {
+	mux    *sync.Mutex
+	writes []*SimulatedWrite
+}
+
+func newSimulatedLog(dryRun bool) *simulatedLog {
+	if !dryRun {
+		return nil
+	}
+	return &simulatedLog{
+		mux: new(sync.Mutex),
+	}
+}
+
+// simulate logs a write of data to the path instead of making it.
+func (r *recordStore) simulate(kind, path string, data []byte) {
+	digest := sha256.Sum256(data)
+	id := sha256.Sum256([]byte(kind + "\x00" + path + "\x00" + hex.EncodeToString(digest[:])))
+	w := &SimulatedWrite{
+		ID:     hex.EncodeToString(id[:16]),
+		Kind:   kind,
+		Path:   path,
+		Size:   int64(len(data)),
+		Digest: hex.EncodeToString(digest[:]),
+		At:     time.Now().UTC(),
+	}
+	log.WithFields(log.Fields{
+		"id":     w.ID,
+		"kind":   w.Kind,
+		"path":   w.Path,
+		"size":   w.Size,
+		"digest": w.Digest,
+	}).Infoln("dry run: skipped write")
+	l := r.simulated
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if len(l.writes) >= maxSimulatedWrites {
+		l.writes = append(l.writes[:0], l.writes[1:]...)
+	}
+	l.writes = append(l.writes, w)
+}
+
+func (r *recordStore) DryRun() bool {
+	return r.simulated != nil
+}
+
+func (r *recordStore) SimulatedWrites() []*SimulatedWrite {
+	if r.simulated == nil {
+		return nil
+	}
+	r.simulated.mux.Lock()
+	defer r.simulated.mux.Unlock()
+	list := make([]*SimulatedWrite, len(r.simulated.writes))
+	copy(list, r.simulated.writes)
+	return list
+}
@@ -0,0 +1,577 @@
+package rs
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	capn "github.com/glycerine/go-capnproto"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/nacl/secretbox"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Content of records in encrypted namespaces is sealed before it enters the file store. Each
+// version gets a random data key, the data key is wrapped with the key of the namespace and
+// kept in the header of the sealed content:
+//
+//	"ANSEAL1\n" | header length, uint32 | JSON sealHeader | sealed chunks
+//
+// Chunks of up to sealChunkSize bytes are sealed with secretbox, the nonce is the prefix from
+// the header followed by the chunk counter, the last chunk has the top bit of the counter set,
+// so truncated content fails to open. Reads open the content transparently on nodes that hold
+// the key of the namespace, other nodes pin and serve sealed content as is to peers only.
+
+const (
+	sealMagic     = "ANSEAL1\n"
+	sealChunkSize = 64 * 1024
+	sealMaxHeader = 4096
+	sealLastChunk = uint64(1) << 63
+)
+
+var (
+	ErrNoNamespaceKey  = errors.New("no key of the namespace, encrypted content can't be opened on this node")
+	ErrSealedDamaged   = errors.New("encrypted content is damaged or truncated")
+	ErrExternalKeys    = errors.New("namespace keys are managed by an external key service")
+	ErrNamespaceKeyBad = errors.New("malformed namespace key")
+)
+
+// KeyWrapper wraps data keys of sealed content with keys of namespaces, e.g. with an external
+// KMS. Keys of the state store are used if EncryptionPolicy has no wrapper.
+type KeyWrapper interface {
+	// WrapKey encrypts the data key with the current key of the namespace, keyID is kept with
+	// the content and passed to UnwrapKey.
+	WrapKey(ctx context.Context, ns string, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts the data key, ErrNoNamespaceKey is returned for unknown keys.
+	UnwrapKey(ctx context.Context, ns, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// EncryptionPolicy lists namespaces whose content or record updates are encrypted by this node.
+type EncryptionPolicy struct {
+	Namespaces []string
+	// Confidential namespaces get record updates sealed in gossip with the keys of the
+	// namespaces, see sealEnvelope. Their content isn't encrypted unless listed in Namespaces.
+	Confidential []string
+	// Keys wraps data keys, nil keeps namespace keys in the state store.
+	Keys KeyWrapper
+}
+
+// NamespaceKey is a key of an encrypted namespace kept in the state store. The key itself
+// is only set in exports, so it may be imported by other nodes serving the namespace.
+type NamespaceKey struct {
+	ID        string    `json:"id"`
+	Namespace string    `json:"namespace"`
+	CreatedAt time.Time `json:"created_at"`
+	Key       []byte    `json:"key,omitempty"`
+}
+
+type sealHeader struct {
+	Namespace  string `json:"namespace"`
+	KeyID      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Nonce      []byte `json:"nonce"`
+	ChunkSize  int    `json:"chunk_size"`
+	// Size of the plaintext, -1 if it was unknown at write time.
+	Size int64 `json:"size"`
+}
+
+type encryptionState struct {
+	namespaces   map[string]bool
+	confidential map[string]bool
+	keys         KeyWrapper
+	external     bool
+}
+
+func newEncryptionState(ss state.IndexedStore, policy *EncryptionPolicy) *encryptionState {
+	e := &encryptionState{
+		namespaces:   make(map[string]bool),
+		confidential: make(map[string]bool),
+		keys:         &stateKeyring{ss: ss},
+	}
+	if policy == nil {
+		return e
+	}
+	for _, ns := range policy.Namespaces {
+		if len(ns) > 0 {
+			e.namespaces[ns] = true
+		}
+	}
+	for _, ns := range policy.Confidential {
+		if len(ns) > 0 {
+			e.confidential[ns] = true
+		}
+	}
+	if policy.Keys != nil {
+		e.keys = policy.Keys
+		e.external = true
+	}
+	return e
+}
+
+// sealObject returns the body sealed if the namespace of the path is encrypted, along with
+// the size of the sealed content.
+func (r *recordStore) sealObject(ctx context.Context, path string, size int64, body io.ReadCloser) (io.ReadCloser, int64, error) {
+	ns := NamespaceOf(path)
+	if body == nil || !r.encryption.namespaces[ns] {
+		return body, size, nil
+	}
+	dataKey := new([32]byte)
+	if _, err := rand.Read(dataKey[:]); err != nil {
+		return nil, 0, err
+	}
+	keyID, wrapped, err := r.encryption.keys.WrapKey(ctx, ns, dataKey[:])
+	if err != nil {
+		err = fmt.Errorf("failed to wrap data key: %v", err)
+		return nil, 0, err
+	}
+	hdr := &sealHeader{
+		Namespace:  ns,
+		KeyID:      keyID,
+		WrappedKey: wrapped,
+		Nonce:      make([]byte, 16),
+		ChunkSize:  sealChunkSize,
+		Size:       -1,
+	}
+	if size > 0 {
+		hdr.Size = size
+	}
+	if _, err := rand.Read(hdr.Nonce); err != nil {
+		return nil, 0, err
+	}
+	head, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, 0, err
+	}
+	out := make([]byte, 0, len(sealMagic)+4+len(head))
+	out = append(out, sealMagic...)
+	out = append(out, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(out[len(sealMagic):], uint32(len(head)))
+	out = append(out, head...)
+	var sealedSize int64
+	if size > 0 {
+		chunks := (size + sealChunkSize - 1) / sealChunkSize
+		sealedSize = int64(len(out)) + size + chunks*secretbox.Overhead
+	}
+	s := &sealReader{
+		src:    bufio.NewReaderSize(body, sealChunkSize),
+		closer: body,
+		key:    dataKey,
+		plain:  make([]byte, sealChunkSize),
+		out:    out,
+	}
+	copy(s.prefix[:], hdr.Nonce)
+	return s, sealedSize, nil
+}
+
+// openObject replaces the body of the object with the opened one if the content is sealed.
+// The meta of an opened object reports the size of the plaintext.
+func (r *recordStore) openObject(ctx context.Context, obj *fs.Object) error {
+	if obj.Body == nil || obj.Meta == nil || obj.Meta.Size() > 0 && obj.Meta.Size() < int64(len(sealMagic)) {
+		return nil
+	}
+	hdr, body, err := readSealHeader(obj.Body)
+	if err != nil {
+		obj.Body.Close()
+		return err
+	}
+	obj.Body = body
+	if hdr == nil {
+		return nil
+	}
+	dataKey, err := r.encryption.keys.UnwrapKey(ctx, hdr.Namespace, hdr.KeyID, hdr.WrappedKey)
+	if err != nil {
+		body.Close()
+		return err
+	} else if len(dataKey) != 32 || len(hdr.Nonce) != 16 || hdr.ChunkSize <= 0 {
+		body.Close()
+		return ErrSealedDamaged
+	}
+	o := &openReader{
+		src:    bufio.NewReaderSize(body, hdr.ChunkSize+secretbox.Overhead),
+		closer: body,
+		key:    new([32]byte),
+		frame:  make([]byte, hdr.ChunkSize+secretbox.Overhead),
+	}
+	copy(o.key[:], dataKey)
+	copy(o.prefix[:], hdr.Nonce)
+	obj.Body = o
+	meta := metaWithSize(obj.Meta, hdr.Size)
+	obj.Meta = meta
+	obj.ObjectRef.SetMeta(meta)
+	return nil
+}
+
+// readSealHeader reads the header of sealed content, the returned body continues after it.
+// Plain content gets no header and a body that reads it from the start.
+func readSealHeader(body io.ReadCloser) (*sealHeader, io.ReadCloser, error) {
+	magic := make([]byte, len(sealMagic))
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		n, err := io.ReadFull(body, magic)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, nil, err
+		}
+		if string(magic[:n]) != sealMagic {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, nil, err
+			}
+			// seekable bodies stay seekable, so ranges of plain content are served as before
+			return nil, body, nil
+		}
+		return parseSealHeader(body, body)
+	}
+	rd := bufio.NewReader(body)
+	if peek, _ := rd.Peek(len(sealMagic)); string(peek) != sealMagic {
+		return nil, &readCloser{Reader: rd, Closer: body}, nil
+	}
+	rd.Discard(len(sealMagic))
+	return parseSealHeader(rd, body)
+}
+
+func parseSealHeader(rd io.Reader, body io.ReadCloser) (*sealHeader, io.ReadCloser, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(rd, size[:]); err != nil {
+		return nil, nil, ErrSealedDamaged
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n == 0 || n > sealMaxHeader {
+		return nil, nil, ErrSealedDamaged
+	}
+	head := make([]byte, n)
+	if _, err := io.ReadFull(rd, head); err != nil {
+		return nil, nil, ErrSealedDamaged
+	}
+	var hdr sealHeader
+	if err := json.Unmarshal(head, &hdr); err != nil {
+		return nil, nil, ErrSealedDamaged
+	}
+	return &hdr, &readCloser{Reader: rd, Closer: body}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func chunkNonce(prefix *[16]byte, counter uint64, last bool) *[24]byte {
+	var nonce [24]byte
+	copy(nonce[:16], prefix[:])
+	if last {
+		counter |= sealLastChunk
+	}
+	binary.BigEndian.PutUint64(nonce[16:], counter)
+	return &nonce
+}
+
+// sealReader reads sealed content of the plaintext in src.
+type sealReader struct {
+	src     *bufio.Reader
+	closer  io.Closer
+	key     *[32]byte
+	prefix  [16]byte
+	counter uint64
+	plain   []byte
+	out     []byte
+	done    bool
+}
+
+func (s *sealReader) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		} else if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+func (s *sealReader) next() error {
+	n, err := io.ReadFull(s.src, s.plain)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		s.done = true
+	} else if err != nil {
+		return err
+	} else if _, err := s.src.Peek(1); err == io.EOF {
+		s.done = true
+	} else if err != nil {
+		return err
+	}
+	s.out = secretbox.Seal(s.out[:0], s.plain[:n], chunkNonce(&s.prefix, s.counter, s.done), s.key)
+	s.counter++
+	return nil
+}
+
+func (s *sealReader) Close() error {
+	return s.closer.Close()
+}
+
+// openReader reads the plaintext of sealed chunks in src.
+type openReader struct {
+	src     *bufio.Reader
+	closer  io.Closer
+	key     *[32]byte
+	prefix  [16]byte
+	counter uint64
+	frame   []byte
+	out     []byte
+	done    bool
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.out) == 0 {
+		if o.done {
+			return 0, io.EOF
+		} else if err := o.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.out)
+	o.out = o.out[n:]
+	return n, nil
+}
+
+func (o *openReader) next() error {
+	n, err := io.ReadFull(o.src, o.frame)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		o.done = true
+	} else if err != nil {
+		return err
+	} else if _, err := o.src.Peek(1); err == io.EOF {
+		o.done = true
+	} else if err != nil {
+		return err
+	}
+	var ok bool
+	o.out, ok = secretbox.Open(o.out[:0], o.frame[:n], chunkNonce(&o.prefix, o.counter, o.done), o.key)
+	if !ok {
+		return ErrSealedDamaged
+	}
+	o.counter++
+	return nil
+}
+
+func (o *openReader) Close() error {
+	return o.closer.Close()
+}
+
+func metaWithSize(meta *proto.ObjectMeta, size int64) *proto.ObjectMeta {
+	m := proto.AutoNewObjectMeta(capn.NewBuffer(nil))
+	m.SetId(meta.Id())
+	m.SetPath(meta.Path())
+	m.SetCreatedAt(meta.CreatedAt())
+	m.SetVersion(meta.Version())
+	m.SetVersionPrevious(meta.VersionPrevious())
+	m.SetIsDeleted(meta.IsDeleted())
+	m.SetSize(size)
+	m.SetUserMeta(meta.UserMeta())
+	m.SetExpiresAt(meta.ExpiresAt())
+	return &m
+}
+
+// stateKeyring keeps keys of namespaces in the state store, a key is created on the first
+// write to the namespace. The newest key of a namespace wraps data keys of new versions.
+type stateKeyring struct {
+	ss state.IndexedStore
+}
+
+func namespaceKeyKey(id string) *state.Key {
+	return state.NamespaceKeysKey(id)
+}
+
+func (k *stateKeyring) list(ctx context.Context) ([]*NamespaceKey, error) {
+	var keys []*NamespaceKey
+	b := state.NewBucket(state.BucketNamespaceKeys)
+	if _, err := k.ss.RangePeek(ctx, b, func(_ *state.Key, v []byte) error {
+		var key NamespaceKey
+		if err := json.Unmarshal(v, &key); err != nil {
+			log.Debugf("skipping malformed namespace key: %v", err)
+			return nil
+		}
+		keys = append(keys, &key)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (k *stateKeyring) get(ctx context.Context, id string) (*NamespaceKey, error) {
+	var key *NamespaceKey
+	if err := k.ss.View(ctx, namespaceKeyKey(id), func(_ *state.Key, v []byte) error {
+		key = new(NamespaceKey)
+		return json.Unmarshal(v, key)
+	}); err == state.ErrNotFound {
+		return nil, ErrNoNamespaceKey
+	} else if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (k *stateKeyring) put(ctx context.Context, key *NamespaceKey) error {
+	if len(key.ID) == 0 || len(key.ID) > state.MaxKeySize || len(key.Namespace) == 0 || len(key.Key) != 32 {
+		return ErrNamespaceKeyBad
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return k.ss.Update(ctx, namespaceKeyKey(key.ID), func(_ *state.Key, v []byte) ([]byte, error) {
+		if len(v) > 0 {
+			var prev NamespaceKey
+			if err := json.Unmarshal(v, &prev); err == nil && prev.Namespace != key.Namespace {
+				return nil, fmt.Errorf("key %s belongs to namespace %s", key.ID, prev.Namespace)
+			}
+		}
+		return data, nil
+	})
+}
+
+func (k *stateKeyring) create(ctx context.Context, ns string) (*NamespaceKey, error) {
+	id := make([]byte, 8)
+	key := &NamespaceKey{
+		Namespace: ns,
+		CreatedAt: time.Now().UTC(),
+		Key:       make([]byte, 32),
+	}
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	} else if _, err := rand.Read(key.Key); err != nil {
+		return nil, err
+	}
+	key.ID = hex.EncodeToString(id)
+	if err := k.put(ctx, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// current returns the newest key of the namespace, one is created if there are none.
+func (k *stateKeyring) current(ctx context.Context, ns string) (*NamespaceKey, error) {
+	keys, err := k.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		if keys[i].Namespace == ns {
+			return keys[i], nil
+		}
+	}
+	key, err := k.create(ctx, ns)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"namespace": ns,
+		"key_id":    key.ID,
+	}).Warningln("created a key of the encrypted namespace, import it on other nodes that serve the namespace")
+	return key, nil
+}
+
+func (k *stateKeyring) WrapKey(ctx context.Context, ns string, dataKey []byte) (string, []byte, error) {
+	key, err := k.current(ctx, ns)
+	if err != nil {
+		return "", nil, err
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", nil, err
+	}
+	var secret [32]byte
+	copy(secret[:], key.Key)
+	return key.ID, secretbox.Seal(nonce[:], dataKey, &nonce, &secret), nil
+}
+
+func (k *stateKeyring) UnwrapKey(ctx context.Context, ns, keyID string, wrapped []byte) ([]byte, error) {
+	key, err := k.get(ctx, keyID)
+	if err != nil {
+		return nil, err
+	} else if key.Namespace != ns || len(key.Key) != 32 {
+		return nil, ErrNoNamespaceKey
+	} else if len(wrapped) < 24 {
+		return nil, ErrSealedDamaged
+	}
+	var nonce [24]byte
+	copy(nonce[:], wrapped[:24])
+	var secret [32]byte
+	copy(secret[:], key.Key)
+	dataKey, ok := secretbox.Open(nil, wrapped[24:], &nonce, &secret)
+	if !ok {
+		return nil, ErrSealedDamaged
+	}
+	return dataKey, nil
+}
+
+func (r *recordStore) keyring() (*stateKeyring, error) {
+	if r.encryption.external {
+		return nil, ErrExternalKeys
+	}
+	return r.encryption.keys.(*stateKeyring), nil
+}
+
+// ListNamespaceKeys lists keys of encrypted namespaces held by this node, without the keys.
+func (r *recordStore) ListNamespaceKeys(ctx context.Context) ([]*NamespaceKey, error) {
+	kr, err := r.keyring()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := kr.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		key.Key = nil
+	}
+	return keys, nil
+}
+
+// RotateNamespaceKey creates a new key of the namespace for new versions, older versions
+// are still opened with the previous keys.
+func (r *recordStore) RotateNamespaceKey(ctx context.Context, ns string) (*NamespaceKey, error) {
+	kr, err := r.keyring()
+	if err != nil {
+		return nil, err
+	} else if len(ns) == 0 {
+		return nil, ErrNamespaceKeyBad
+	}
+	key, err := kr.create(ctx, ns)
+	if err != nil {
+		return nil, err
+	}
+	key.Key = nil
+	return key, nil
+}
+
+// ExportNamespaceKey returns the key with its secret, so it may be imported by another node.
+func (r *recordStore) ExportNamespaceKey(ctx context.Context, id string) (*NamespaceKey, error) {
+	kr, err := r.keyring()
+	if err != nil {
+		return nil, err
+	}
+	return kr.get(ctx, id)
+}
+
+// ImportNamespaceKey adds a key exported by another node.
+func (r *recordStore) ImportNamespaceKey(ctx context.Context, key *NamespaceKey) error {
+	kr, err := r.keyring()
+	if err != nil {
+		return err
+	}
+	return kr.put(ctx, key)
+}
@@ -0,0 +1,562 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Namespaces of the erasure storage class keep large objects as data and parity shards instead
+// of full replicas, any DataShards of them restore the content, see fs.ShardSet. Every shard is
+// placed on one node of the swarm by rendezvous hashing over this node and its peers, nodes pin
+// the object itself and only the shards placed on them. The writer pins all shards until their
+// nodes provide them. The repair job checks that every shard has a provider, fetches shards
+// placed on the node, rebuilds lost ones while enough are left and releases shards of others.
+// Placement follows the swarm, so all nodes of the swarm should follow erasure namespaces.
+
+var ErrRepairRunning = errors.New("shard repair is already running")
+
+const (
+	StorageReplicated = "replicated"
+	StorageErasure    = "erasure"
+
+	defaultDataShards      = 4
+	defaultParityShards    = 2
+	defaultErasureMinSize  = 1024 * 1024
+	maxErasureShards       = 256
+	shardPinTimeout        = 10 * time.Minute
+	shardProvidersTimeout  = 30 * time.Second
+	maxShardProviders      = 20
+	maxUnhealthyReported   = 100
+	erasureRepairLogPeriod = 100
+)
+
+// StorageClass is how a namespace keeps content of its records. Objects already stored
+// keep their class, a change applies to versions written after it.
+type StorageClass struct {
+	// Class is "replicated", the default, or "erasure".
+	Class        string `json:"class"`
+	DataShards   int    `json:"data_shards,omitempty"`
+	ParityShards int    `json:"parity_shards,omitempty"`
+	// MinSize is the content size in bytes below which objects are replicated anyway.
+	MinSize int64 `json:"min_size,omitempty"`
+}
+
+func (c *StorageClass) validate() error {
+	switch c.Class {
+	case "", StorageReplicated:
+		return nil
+	case StorageErasure:
+	default:
+		return fmt.Errorf("unknown storage class %q", c.Class)
+	}
+	if c.DataShards == 0 {
+		c.DataShards = defaultDataShards
+	}
+	if c.ParityShards == 0 {
+		c.ParityShards = defaultParityShards
+	}
+	if c.MinSize == 0 {
+		c.MinSize = defaultErasureMinSize
+	}
+	if c.DataShards < 1 || c.ParityShards < 1 {
+		return fmt.Errorf("data_shards and parity_shards must be positive")
+	} else if c.DataShards+c.ParityShards > maxErasureShards {
+		return fmt.Errorf("up to %d shards are supported", maxErasureShards)
+	} else if c.MinSize < 0 {
+		return fmt.Errorf("min_size must not be negative")
+	}
+	return nil
+}
+
+// ShardHealth describes an erasure coded object that is missing shards.
+type ShardHealth struct {
+	Path      string `json:"path"`
+	Version   string `json:"version"`
+	Available int    `json:"available"`
+	Total     int    `json:"total"`
+	Required  int    `json:"required"`
+	Missing   []int  `json:"missing"`
+}
+
+type ErasureStatus struct {
+	Classes   map[string]*StorageClass `json:"classes"`
+	Running   bool                     `json:"running"`
+	LastRun   time.Time                `json:"last_run,omitempty"`
+	Duration  string                   `json:"duration,omitempty"`
+	LastError string                   `json:"last_error,omitempty"`
+	// Objects is the number of erasure coded objects checked by the last run.
+	Objects  int `json:"objects"`
+	Healthy  int `json:"healthy"`
+	Degraded int `json:"degraded"`
+	Lost     int `json:"lost"`
+	// ShardsHeld is the number of shards pinned by this node.
+	ShardsHeld     int    `json:"shards_held"`
+	ShardsFetched  uint64 `json:"shards_fetched_total"`
+	ShardsRebuilt  uint64 `json:"shards_rebuilt_total"`
+	ShardsReleased uint64 `json:"shards_released_total"`
+	// Unhealthy lists objects missing shards, lost ones first.
+	Unhealthy []*ShardHealth `json:"unhealthy,omitempty"`
+}
+
+type erasureState struct {
+	mux     *sync.RWMutex
+	running int32
+	classes map[string]*StorageClass
+	status  ErasureStatus
+}
+
+func newErasureState() *erasureState {
+	return &erasureState{
+		mux:     new(sync.RWMutex),
+		classes: make(map[string]*StorageClass),
+	}
+}
+
+// erasureClass returns the storage class of the record at path if it's erasure coded.
+func (r *recordStore) erasureClass(path string) *StorageClass {
+	r.erasure.mux.RLock()
+	defer r.erasure.mux.RUnlock()
+	return r.erasure.classes[NamespaceOf(path)]
+}
+
+func (r *recordStore) setStorageClass(ns string, class *StorageClass) {
+	if class != nil {
+		if err := class.validate(); err != nil {
+			log.WithField("namespace", ns).Warningf("invalid storage class: %v", err)
+			return
+		} else if class.Class != StorageErasure {
+			class = nil
+		}
+	}
+	r.erasure.mux.Lock()
+	defer r.erasure.mux.Unlock()
+	if class == nil {
+		delete(r.erasure.classes, ns)
+		return
+	}
+	r.erasure.classes[ns] = class
+}
+
+// loadStorageClasses reads storage classes from settings of all namespaces.
+func (r *recordStore) loadStorageClasses(ctx context.Context) error {
+	configs, err := r.namespaceConfigs(ctx)
+	if err != nil {
+		return err
+	}
+	classes := make(map[string]*StorageClass)
+	for ns, cfg := range configs {
+		if cfg.Storage == nil {
+			continue
+		} else if err := cfg.Storage.validate(); err != nil {
+			log.WithField("namespace", ns).Warningf("invalid storage class: %v", err)
+			continue
+		} else if cfg.Storage.Class == StorageErasure {
+			classes[ns] = cfg.Storage
+		}
+	}
+	r.erasure.mux.Lock()
+	r.erasure.classes = classes
+	r.erasure.mux.Unlock()
+	return nil
+}
+
+// putObject stores the object as shards if it's large enough and its namespace is erasure
+// coded, as a whole otherwise.
+func (r *recordStore) putObject(ctx context.Context, ref fs.ObjectRef, userMeta []byte, body io.ReadCloser) (*fs.ObjectRef, error) {
+	body, size, err := r.sealObject(ctx, ref.Path, ref.Size, body)
+	if err != nil {
+		return nil, err
+	}
+	ref.Size = size
+	class := r.erasureClass(ref.Path)
+	if class == nil || body == nil || ref.Size <= 0 || ref.Size < class.MinSize {
+		return r.fs.PutObject(ctx, ref, userMeta, body)
+	}
+	obj, set, err := r.fs.PutErasureObject(ctx, ref, userMeta, body, fs.ErasureLayout{
+		DataShards:   class.DataShards,
+		ParityShards: class.ParityShards,
+	})
+	if err != nil {
+		return nil, err
+	}
+	pins := &shardPins{
+		Version: obj.Version,
+		Shards:  make(map[int]string, len(set.Shards)),
+	}
+	for i, shard := range set.Shards {
+		pins.Shards[i] = shard
+	}
+	r.saveShardPins(pins)
+	return obj, nil
+}
+
+// shardPins are shards of an object version pinned by this node.
+type shardPins struct {
+	Version string         `json:"version"`
+	Shards  map[int]string `json:"shards"`
+}
+
+func (r *recordStore) loadShardPins(version string) *shardPins {
+	pins := &shardPins{
+		Version: version,
+		Shards:  make(map[int]string),
+	}
+	if err := r.ss.View(context.Background(), state.ShardsKey(version), func(k *state.Key, v []byte) error {
+		return json.Unmarshal(v, pins)
+	}); err != nil && err != state.ErrNotFound {
+		log.WithField("version", version).Warningf("failed to load pinned shards: %v", err)
+	}
+	if pins.Shards == nil {
+		pins.Shards = make(map[int]string)
+	}
+	return pins
+}
+
+func (r *recordStore) saveShardPins(pins *shardPins) {
+	k := state.ShardsKey(pins.Version)
+	if len(pins.Shards) == 0 {
+		if err := r.ss.Delete(k); err != nil && err != state.ErrNotFound {
+			log.WithField("version", pins.Version).Warningf("failed to save pinned shards: %v", err)
+		}
+		return
+	}
+	data, err := json.Marshal(pins)
+	if err != nil {
+		log.WithField("version", pins.Version).Warningf("failed to encode pinned shards: %v", err)
+		return
+	}
+	if err := r.ss.Update(context.Background(), k, func(k *state.Key, v []byte) ([]byte, error) {
+		return data, nil
+	}); err != nil {
+		log.WithField("version", pins.Version).Warningf("failed to save pinned shards: %v", err)
+	}
+}
+
+// releaseShards unpins shards of the object version held by this node.
+func (r *recordStore) releaseShards(version string) {
+	pins := r.loadShardPins(version)
+	if len(pins.Shards) == 0 {
+		return
+	}
+	for _, shard := range pins.Shards {
+		if err := r.fs.UnpinObject(fs.ObjectRef{
+			Version: shard,
+		}); err != nil {
+			log.WithField("shard", shard).Debugf("failed to unpin shard: %v", err)
+		}
+	}
+	pins.Shards = nil
+	r.saveShardPins(pins)
+}
+
+// heldShards adds shards pinned for the referenced versions to the set.
+func (r *recordStore) heldShards(ctx context.Context, referenced map[string]struct{}) error {
+	b := state.NewBucket(state.BucketShards)
+	_, err := r.ss.RangePeek(ctx, b, func(k *state.Key, v []byte) error {
+		var pins shardPins
+		if err := json.Unmarshal(v, &pins); err != nil {
+			return nil
+		} else if _, ok := referenced[pins.Version]; !ok {
+			return nil
+		}
+		for _, shard := range pins.Shards {
+			referenced[shard] = struct{}{}
+		}
+		return nil
+	})
+	return err
+}
+
+// placementNodes are this node and its swarm peers in order.
+func (r *recordStore) placementNodes() []string {
+	nodes := []string{r.nodeID}
+	for _, p := range r.fs.SwarmPeers() {
+		nodes = append(nodes, p.NodeID)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// shardOwner picks the node a shard is placed on by rendezvous hashing, so
+// a node joining or leaving moves only shards placed on it.
+func shardOwner(nodes []string, shard string) string {
+	var owner string
+	var best []byte
+	for _, node := range nodes {
+		sum := sha256.Sum256([]byte(node + "/" + shard))
+		if best == nil || bytes.Compare(sum[:], best) > 0 {
+			owner, best = node, sum[:]
+		}
+	}
+	return owner
+}
+
+// RepairShards periodically checks shards of erasure coded objects, storage classes of
+// namespaces are reloaded on every run.
+func (r *recordStore) RepairShards(ctx context.Context, interval time.Duration) {
+	t := time.NewTimer(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := r.CheckShards(ctx); err != nil && err != ErrRepairRunning {
+				log.Warningf("failed to repair shards: %v", err)
+			}
+			t.Reset(interval)
+		}
+	}
+}
+
+func (r *recordStore) CheckShards(ctx context.Context) (*ErasureStatus, error) {
+	if !atomic.CompareAndSwapInt32(&r.erasure.running, 0, 1) {
+		return nil, ErrRepairRunning
+	}
+	defer atomic.StoreInt32(&r.erasure.running, 0)
+	run := &erasureRun{
+		startedAt: time.Now(),
+	}
+	err := r.checkShards(ctx, run)
+	r.erasure.finish(run, err)
+	return r.ErasureStatus(), err
+}
+
+type erasureRun struct {
+	startedAt time.Time
+	objects   int
+	healthy   int
+	degraded  int
+	lost      int
+	held      int
+	fetched   int
+	rebuilt   int
+	released  int
+	unhealthy []*ShardHealth
+}
+
+type erasureObject struct {
+	path    string
+	version string
+}
+
+func (r *recordStore) checkShards(ctx context.Context, run *erasureRun) error {
+	if err := r.loadStorageClasses(ctx); err != nil {
+		return err
+	}
+	var objects []erasureObject
+	b := state.NewBucket(state.BucketRecords)
+	if _, err := r.ss.RangePeek(ctx, b, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		objects = append(objects, erasureObject{
+			path:    v.Path(),
+			version: v.Current().Version(),
+		})
+		return nil
+	})); err != nil {
+		return err
+	}
+	nodes := r.placementNodes()
+	for _, obj := range objects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		set, err := r.fs.ObjectShards(ctx, fs.ObjectRef{
+			Version: obj.version,
+		})
+		if err == fs.ErrNotErasure || err == fs.ErrNotFound {
+			continue
+		} else if err != nil {
+			log.WithField("version", obj.version).Debugf("failed to read shards of object: %v", err)
+			continue
+		}
+		run.objects++
+		r.checkObjectShards(ctx, obj, set, nodes, run)
+		if run.objects%erasureRepairLogPeriod == 0 {
+			log.Debugf("checked shards of %d erasure coded objects", run.objects)
+		}
+	}
+	return nil
+}
+
+// checkObjectShards pins shards placed on this node, rebuilds the missing ones if there are
+// enough shards left and unpins shards that nodes they're placed on provide already.
+func (r *recordStore) checkObjectShards(ctx context.Context, obj erasureObject, set *fs.ShardSet, nodes []string, run *erasureRun) {
+	pins := r.loadShardPins(obj.version)
+	providers := r.shardProviders(ctx, set)
+	var available int
+	var missing, rebuild []int
+	for i, shard := range set.Shards {
+		owner := shardOwner(nodes, shard)
+		_, held := pins.Shards[i]
+		if owner == r.nodeID && !held {
+			pinCtx, cancelFn := context.WithTimeout(ctx, shardPinTimeout)
+			err := r.fs.PinShard(pinCtx, shard)
+			cancelFn()
+			if err == nil {
+				pins.Shards[i] = shard
+				held = true
+				run.fetched++
+			} else {
+				log.WithField("shard", shard).Debugf("failed to fetch shard: %v", err)
+			}
+		} else if owner != r.nodeID && held && contains(providers[i], owner) {
+			if err := r.fs.UnpinObject(fs.ObjectRef{
+				Version: shard,
+			}); err == nil {
+				delete(pins.Shards, i)
+				run.released++
+			}
+		}
+		switch {
+		case held || len(providers[i]) > 0:
+			available++
+		case owner == r.nodeID:
+			rebuild = append(rebuild, i)
+			missing = append(missing, i)
+		default:
+			missing = append(missing, i)
+		}
+	}
+	if len(rebuild) > 0 && available >= set.DataShards {
+		if err := r.fs.RepairShards(ctx, set, rebuild); err != nil {
+			log.WithFields(log.Fields{
+				"path":    obj.path,
+				"version": obj.version,
+			}).Warningf("failed to rebuild shards: %v", err)
+		} else {
+			for _, i := range rebuild {
+				pins.Shards[i] = set.Shards[i]
+			}
+			available += len(rebuild)
+			missing = missingAfter(missing, rebuild)
+			run.rebuilt += len(rebuild)
+		}
+	}
+	r.saveShardPins(pins)
+	run.held += len(pins.Shards)
+	switch {
+	case available == len(set.Shards):
+		run.healthy++
+		return
+	case available >= set.DataShards:
+		run.degraded++
+	default:
+		run.lost++
+		log.WithFields(log.Fields{
+			"path":    obj.path,
+			"version": obj.version,
+		}).Warningf("erasure coded object has %d of %d required shards", available, set.DataShards)
+	}
+	run.unhealthy = append(run.unhealthy, &ShardHealth{
+		Path:      obj.path,
+		Version:   obj.version,
+		Available: available,
+		Total:     len(set.Shards),
+		Required:  set.DataShards,
+		Missing:   missing,
+	})
+}
+
+// shardProviders looks up peers providing each shard.
+func (r *recordStore) shardProviders(ctx context.Context, set *fs.ShardSet) [][]string {
+	providers := make([][]string, len(set.Shards))
+	wg := new(sync.WaitGroup)
+	for i, shard := range set.Shards {
+		wg.Add(1)
+		go func(i int, shard string) {
+			defer wg.Done()
+			findCtx, cancelFn := context.WithTimeout(ctx, shardProvidersTimeout)
+			defer cancelFn()
+			providers[i], _ = r.fs.FindProviders(findCtx, shard, maxShardProviders)
+		}(i, shard)
+	}
+	wg.Wait()
+	return providers
+}
+
+// missingAfter returns shards that are still missing once the rebuilt ones are back.
+func missingAfter(missing, rebuilt []int) []int {
+	var left []int
+	for _, i := range missing {
+		var found bool
+		for _, j := range rebuilt {
+			if i == j {
+				found = true
+				break
+			}
+		}
+		if !found {
+			left = append(left, i)
+		}
+	}
+	return left
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *erasureState) finish(run *erasureRun, err error) {
+	sort.SliceStable(run.unhealthy, func(i, j int) bool {
+		return run.unhealthy[i].Available-run.unhealthy[i].Required <
+			run.unhealthy[j].Available-run.unhealthy[j].Required
+	})
+	if len(run.unhealthy) > maxUnhealthyReported {
+		run.unhealthy = run.unhealthy[:maxUnhealthyReported]
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.status.LastRun = run.startedAt
+	s.status.Duration = time.Since(run.startedAt).String()
+	s.status.LastError = ""
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	s.status.Objects = run.objects
+	s.status.Healthy = run.healthy
+	s.status.Degraded = run.degraded
+	s.status.Lost = run.lost
+	s.status.ShardsHeld = run.held
+	s.status.ShardsFetched += uint64(run.fetched)
+	s.status.ShardsRebuilt += uint64(run.rebuilt)
+	s.status.ShardsReleased += uint64(run.released)
+	s.status.Unhealthy = run.unhealthy
+	if run.fetched > 0 || run.rebuilt > 0 || run.released > 0 {
+		log.Infof("shards of %d objects checked: %d fetched, %d rebuilt, %d released",
+			run.objects, run.fetched, run.rebuilt, run.released)
+	}
+}
+
+func (r *recordStore) ErasureStatus() *ErasureStatus {
+	r.erasure.mux.RLock()
+	defer r.erasure.mux.RUnlock()
+	status := r.erasure.status
+	status.Running = atomic.LoadInt32(&r.erasure.running) == 1
+	status.Classes = make(map[string]*StorageClass, len(r.erasure.classes))
+	for ns, class := range r.erasure.classes {
+		status.Classes[ns] = class
+	}
+	return &status
+}
@@ -1,6 +1,9 @@
 package rs
 
 import (
+	"strings"
+	"time"
+
 	"github.com/AtlantPlatform/atlant-go/proto"
 )
 
@@ -11,6 +14,7 @@ const (
 	EventBeatTick     EventType = EventType(proto.ANNOUNCETYPE_BEATTICK)
 	EventBeatInfo     EventType = EventType(proto.ANNOUNCETYPE_BEATINFO)
 	EventRecordUpdate EventType = EventType(proto.ANNOUNCETYPE_RECORDUPDATE)
+	EventLifecycle    EventType = EventType(proto.ANNOUNCETYPE_NODELIFECYCLE)
 	EventStopAnnounce EventType = 999
 )
 
@@ -22,6 +26,8 @@ func (e EventType) String() string {
 		return "beat-info"
 	case EventRecordUpdate:
 		return "record-update"
+	case EventLifecycle:
+		return "node-lifecycle"
 	case EventStopAnnounce:
 		return "stop-announce"
 	default:
@@ -29,7 +35,16 @@ func (e EventType) String() string {
 	}
 }
 
+// NamespaceTopic returns a pubsub topic that carries events of the given type
+// scoped to a single namespace.
+func NamespaceTopic(e EventType, namespace string) string {
+	return e.String() + "/" + namespace
+}
+
 func EventFromTopic(topic string) EventType {
+	if idx := strings.IndexByte(topic, '/'); idx > 0 {
+		topic = topic[:idx]
+	}
 	switch topic {
 	case EventBeatTick.String():
 		return EventBeatTick
@@ -37,6 +52,8 @@ func EventFromTopic(topic string) EventType {
 		return EventBeatInfo
 	case EventRecordUpdate.String():
 		return EventRecordUpdate
+	case EventLifecycle.String():
+		return EventLifecycle
 	default:
 		return EventUnknown
 	}
@@ -45,4 +62,11 @@ func EventFromTopic(topic string) EventType {
 type EventAnnounce struct {
 	Type     EventType      `json:"type"`
 	Announce proto.Announce `json:"announce"`
+	// Namespace is used to route record announces to namespace topics,
+	// it is known only to the emitting node and is not sent over the wire.
+	Namespace string `json:"namespace,omitempty"`
+	// queuedAt is when the announce was queued, inbound or outbound, see inboundPool.
+	queuedAt time.Time
+	// journaled is set once the announce is kept in the outbound journal.
+	journaled bool
 }
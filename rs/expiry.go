@@ -0,0 +1,241 @@
+package rs
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/cache"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Writers may set an expiry time on a version of a record, it's kept in the object meta
+// and travels with the version. Once the time has passed, reads of the version are served
+// as not found on every node. The node that wrote the version deletes the record then and
+// the tombstone is gossiped as usual, other nodes allowed to write the path delete it too
+// if no tombstone arrived within expiryGrace, e.g. because the writer is gone. Nodes drop
+// expired versions that are no longer current from the history of the record and unpin them.
+//
+// Versions that expire are scheduled in the state store as they are stored, by ID of the
+// version, see WatchExpiries.
+
+const (
+	expiryGrace       = 10 * time.Minute
+	expiryKeep        = 30 * 24 * time.Hour
+	expiryQueueSize   = 1024
+	expiryHeadTimeout = 30 * time.Second
+)
+
+// Expiry is a version of a record scheduled to expire.
+type Expiry struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Version   string    `json:"version"`
+	NodeID    string    `json:"node_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// versionExpired tells whether the version of the meta has expired.
+func versionExpired(meta *proto.ObjectMeta) bool {
+	if meta == nil || meta.ExpiresAt() == 0 {
+		return false
+	}
+	return time.Now().UnixNano() >= meta.ExpiresAt()
+}
+
+type expiryState struct {
+	queue chan *RecordChange
+}
+
+func newExpiryState() *expiryState {
+	return &expiryState{
+		queue: make(chan *RecordChange, expiryQueueSize),
+	}
+}
+
+// track queues the stored version to check whether it expires, tombstones never do.
+func (e *expiryState) track(change *RecordChange) {
+	if change.Op == WriteDelete {
+		return
+	}
+	select {
+	case e.queue <- change:
+	default:
+		log.WithField("version", change.Version).Debugln("expiry queue is full, skipping version")
+	}
+}
+
+// scheduleExpiry keeps the version if it expires.
+func (r *recordStore) scheduleExpiry(ctx context.Context, change *RecordChange) {
+	headCtx, cancelFn := context.WithTimeout(ctx, expiryHeadTimeout)
+	ref, err := r.headVersion(headCtx, change.Version)
+	cancelFn()
+	if err != nil {
+		log.WithField("version", change.Version).Debugf("failed to check expiry of version: %v", err)
+		return
+	}
+	meta := ref.Meta()
+	if meta == nil || meta.ExpiresAt() == 0 || meta.IsDeleted() {
+		return
+	}
+	e := &Expiry{
+		ID:        change.ID,
+		Path:      change.Path,
+		Version:   change.Version,
+		NodeID:    change.NodeID,
+		ExpiresAt: time.Unix(0, meta.ExpiresAt()).UTC(),
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	k := state.ExpiriesKey(e.Version)
+	k.TTL = time.Until(e.ExpiresAt) + expiryKeep
+	if err := r.ss.Update(ctx, k, func(_ *state.Key, _ []byte) ([]byte, error) {
+		return data, nil
+	}); err != nil {
+		log.WithField("version", e.Version).Warningf("failed to schedule expiry of version: %v", err)
+	}
+}
+
+// WatchExpiries schedules versions that expire and expires them once they are due.
+func (r *recordStore) WatchExpiries(ctx context.Context, interval time.Duration) {
+	t := time.NewTimer(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change := <-r.expiry.queue:
+			r.scheduleExpiry(ctx, change)
+		case <-t.C:
+			if err := r.expireDue(ctx); err != nil {
+				log.Warningf("failed to expire records: %v", err)
+			}
+			t.Reset(interval)
+		}
+	}
+}
+
+// Expiries lists versions scheduled to expire, the earliest first.
+func (r *recordStore) Expiries(ctx context.Context) ([]*Expiry, error) {
+	var list []*Expiry
+	b := state.NewBucket(state.BucketExpiries)
+	if _, err := r.ss.RangePeek(ctx, b, func(_ *state.Key, v []byte) error {
+		var e Expiry
+		if err := json.Unmarshal(v, &e); err != nil {
+			log.Debugf("skipping malformed expiry: %v", err)
+			return nil
+		}
+		list = append(list, &e)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ExpiresAt.Before(list[j].ExpiresAt)
+	})
+	return list, nil
+}
+
+func (r *recordStore) expireDue(ctx context.Context) error {
+	list, err := r.Expiries(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, e := range list {
+		if e.ExpiresAt.After(now) {
+			break
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		r.expire(ctx, e)
+	}
+	return nil
+}
+
+// expire deletes the record if the expired version is still current and this node may
+// write it, then drops the version from the history once it's superseded.
+func (r *recordStore) expire(ctx context.Context, e *Expiry) {
+	fields := log.Fields{
+		"record":  e.ID,
+		"path":    e.Path,
+		"version": e.Version,
+	}
+	var current string
+	if err := r.ss.View(ctx, state.RecordsKey([]byte(e.ID)), proto.RecordPeek(func(_ *state.Key, v *proto.Record) error {
+		if v == nil {
+			return ErrRecordNotFound
+		}
+		current = v.Current().Version()
+		return nil
+	})); err == ErrRecordNotFound || err == state.ErrNotFound {
+		r.forgetExpiry(e)
+		return
+	} else if err != nil {
+		log.WithFields(fields).Warningf("failed to expire record: %v", err)
+		return
+	}
+	if current == e.Version {
+		if r.opts.ReadOnly || !r.isWriteAllowed(r.nodeID, e.Path) {
+			// reads are refused already, the tombstone comes from the writer
+			return
+		} else if e.NodeID != r.nodeID && time.Since(e.ExpiresAt) < expiryGrace {
+			return
+		}
+		if _, err := r.DeleteRecord(ctx, e.Path); err == ErrWORMNamespace {
+			log.WithFields(fields).Warningln("expired record of a write-once namespace can't be deleted")
+			r.forgetExpiry(e)
+			return
+		} else if err != nil && err != ErrRecordNotFound {
+			log.WithFields(fields).Warningf("failed to delete expired record: %v", err)
+			return
+		}
+		log.WithFields(fields).Infoln("deleted expired record")
+	}
+	if r.dropVersion(ctx, e.ID, e.Version) {
+		r.unpinVersions([]string{e.Version})
+	}
+	r.cache.Invalidate(cache.Objects, e.Version)
+	r.forgetExpiry(e)
+}
+
+// dropVersion removes a previous version from the history of the record.
+func (r *recordStore) dropVersion(ctx context.Context, id, version string) bool {
+	var dropped bool
+	k := state.RecordsKey([]byte(id))
+	if err := r.ss.Update(ctx, k, proto.RecordModify(func(_ *state.Key, v *proto.Record) (*proto.Record, error) {
+		dropped = false
+		if v == nil || v.Current().Version() == version {
+			return nil, state.ErrNoUpdate
+		}
+		previous := v.Previous().ToArray()
+		kept := make([]proto.RecordVersion, 0, len(previous))
+		for _, ver := range previous {
+			if ver.Version() != version {
+				kept = append(kept, ver)
+			}
+		}
+		if len(kept) == len(previous) {
+			return nil, state.ErrNoUpdate
+		}
+		dropped = true
+		v.SetPrevious(proto.NewRecordVersionListFrom(kept))
+		return v, nil
+	})); err != nil && err != state.ErrNoUpdate {
+		log.WithField("version", version).Warningf("failed to drop expired version: %v", err)
+		return false
+	}
+	return dropped
+}
+
+func (r *recordStore) forgetExpiry(e *Expiry) {
+	if err := r.ss.Delete(state.ExpiriesKey(e.Version)); err != nil && err != state.ErrNotFound {
+		log.WithField("version", e.Version).Warningf("failed to forget expiry of version: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package rs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FenceError is returned for local writes while the node is fenced after
+// its write permission has been revoked by the authority.
+type FenceError struct {
+	Since time.Time
+}
+
+func (f *FenceError) Error() string {
+	return fmt.Sprintf("writes are fenced since %s: write permission of this node has been revoked, "+
+		"peers would drop its records", f.Since.UTC().Format(time.RFC3339))
+}
+
+type writeFence struct {
+	mux    *sync.RWMutex
+	fenced bool
+	since  time.Time
+}
+
+func newWriteFence() *writeFence {
+	return &writeFence{
+		mux: new(sync.RWMutex),
+	}
+}
+
+func (w *writeFence) Err() error {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	if !w.fenced {
+		return nil
+	}
+	return &FenceError{
+		Since: w.since,
+	}
+}
+
+func (w *writeFence) set(fenced bool) (changed bool) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.fenced == fenced {
+		return false
+	}
+	w.fenced = fenced
+	w.since = time.Now()
+	return true
+}
+
+// WatchPermissions polls the authority for the write permission of this node. Once the
+// permission is revoked, local writes are fenced and pending record announces are dropped.
+func (r *recordStore) WatchPermissions(ctx context.Context, interval time.Duration) {
+	hadPermission := isPublishAllowed(r.nodeID)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			allowed := isPublishAllowed(r.nodeID)
+			if hadPermission && !allowed {
+				if r.fence.set(true) {
+					log.WithFields(log.Fields{
+						"alert":  "write-permission-revoked",
+						"nodeID": r.nodeID,
+					}).Errorln("write permission revoked, local writes are fenced and announces stopped")
+				}
+			} else if allowed && r.fence.set(false) {
+				log.WithField("nodeID", r.nodeID).Warningln("write permission restored, lifting the write fence")
+			}
+			hadPermission = allowed
+		}
+	}
+}
+
+func (r *recordStore) WriteFence() error {
+	return r.fence.Err()
+}
@@ -0,0 +1,478 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// SharedFolder is a folder whose contents are encrypted with a symmetric folder key,
+// the key is wrapped for each member separately using their X25519 folder identity.
+// Each membership change rotates the key, older content is re-encrypted lazily on read.
+type SharedFolder struct {
+	Name       string            `json:"name"`
+	Owner      string            `json:"owner"`
+	Members    map[string]string `json:"members"`
+	KeyVersion int               `json:"key_version"`
+	// KeyRing maps key version to member node ID to the sealed folder key.
+	KeyRing map[string]map[string]string `json:"key_ring"`
+	// RevokedKeys are compromised key versions, content encrypted with them is no longer served.
+	RevokedKeys []int `json:"revoked_keys,omitempty"`
+}
+
+type SharedFolders interface {
+	// PublicKey returns the hex-encoded X25519 key other members use to wrap folder keys for this node.
+	PublicKey() string
+
+	CreateFolder(ctx context.Context, name string) (*SharedFolder, error)
+	GetFolder(ctx context.Context, name string) (*SharedFolder, error)
+	AddMember(ctx context.Context, name, nodeID, publicKey string) (*SharedFolder, error)
+	RemoveMember(ctx context.Context, name, nodeID string) (*SharedFolder, error)
+
+	PutFile(ctx context.Context, name, filePath string, body io.Reader) (*Record, error)
+	GetFile(ctx context.Context, name, filePath string) (*Record, []byte, error)
+
+	// ReencryptFolder rotates the folder key and starts a background job that re-encrypts
+	// all content with the new key, then revokes the previous key versions.
+	ReencryptFolder(ctx context.Context, name string) (*ReencryptJob, error)
+	// ReencryptStatus returns progress of the last re-encryption job of the folder.
+	ReencryptStatus(name string) (*ReencryptJob, bool)
+}
+
+var (
+	ErrFolderExists    = errors.New("shared folder exists")
+	ErrFolderNotFound  = errors.New("shared folder not found")
+	ErrFolderName      = errors.New("shared folder name is not valid")
+	ErrNotFolderMember = errors.New("node is not a member of the shared folder")
+	ErrFolderCipher    = errors.New("shared folder content cannot be decrypted")
+	ErrFolderTooLarge  = errors.New("shared folder object is too large")
+	ErrFolderRevoked   = errors.New("shared folder content is encrypted with a revoked key")
+)
+
+const (
+	folderRoot           = "/folders"
+	folderDescriptorName = ".folder"
+	folderCipherMagic    = "AFE1"
+	maxFolderObjectSize  = 64 * 1024 * 1024
+
+	defaultFolderRewrapTimeout = 5 * time.Minute
+)
+
+func NewSharedFolders(nodeID string, store PlanetaryRecordStore, stateStore state.IndexedStore) (SharedFolders, error) {
+	pub, priv, err := loadFolderIdentity(stateStore)
+	if err != nil {
+		err = fmt.Errorf("failed to load folder identity: %v", err)
+		return nil, err
+	}
+	return &sharedFolders{
+		nodeID: nodeID,
+		store:  store,
+		pub:    pub,
+		priv:   priv,
+		keys:   make(map[string]*[32]byte),
+		keyMux: new(sync.RWMutex),
+		jobs:   make(map[string]*ReencryptJob),
+		jobMux: new(sync.RWMutex),
+	}, nil
+}
+
+type sharedFolders struct {
+	nodeID string
+	store  PlanetaryRecordStore
+	pub    *[32]byte
+	priv   *[32]byte
+
+	keys   map[string]*[32]byte
+	keyMux *sync.RWMutex
+
+	jobs   map[string]*ReencryptJob
+	jobMux *sync.RWMutex
+}
+
+func loadFolderIdentity(ss state.IndexedStore) (pub, priv *[32]byte, err error) {
+	k := state.FolderKeysKey("identity")
+	err = ss.Update(context.Background(), k, func(k *state.Key, v []byte) ([]byte, error) {
+		pub, priv = new([32]byte), new([32]byte)
+		if len(v) == 64 {
+			copy(pub[:], v[:32])
+			copy(priv[:], v[32:])
+			return nil, state.ErrNoUpdate
+		}
+		p, s, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		pub, priv = p, s
+		buf := make([]byte, 64)
+		copy(buf[:32], pub[:])
+		copy(buf[32:], priv[:])
+		return buf, nil
+	})
+	return pub, priv, err
+}
+
+func (f *sharedFolders) PublicKey() string {
+	return hex.EncodeToString(f.pub[:])
+}
+
+func folderDescriptorPath(name string) string {
+	return path.Join(folderRoot, name, folderDescriptorName)
+}
+
+func folderFilePath(name, filePath string) string {
+	return path.Join(folderRoot, name, path.Clean("/"+filePath))
+}
+
+func validFolderName(name string) bool {
+	return len(name) > 0 && !strings.ContainsAny(name, "/\\") && name != "." && name != ".."
+}
+
+func (f *sharedFolders) CreateFolder(ctx context.Context, name string) (*SharedFolder, error) {
+	if !validFolderName(name) {
+		return nil, ErrFolderName
+	}
+	if _, err := f.GetFolder(ctx, name); err == nil {
+		return nil, ErrFolderExists
+	} else if err != ErrFolderNotFound {
+		return nil, err
+	}
+	folder := &SharedFolder{
+		Name:  name,
+		Owner: f.nodeID,
+		Members: map[string]string{
+			f.nodeID: f.PublicKey(),
+		},
+	}
+	if err := f.rotateKey(folder); err != nil {
+		return nil, err
+	}
+	if err := f.saveFolder(ctx, folder); err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+func (f *sharedFolders) GetFolder(ctx context.Context, name string) (*SharedFolder, error) {
+	if !validFolderName(name) {
+		return nil, ErrFolderName
+	}
+	r, err := f.store.ReadRecord(ctx, folderDescriptorPath(name))
+	if err == ErrRecordNotFound {
+		return nil, ErrFolderNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	var folder *SharedFolder
+	if err := json.NewDecoder(r.Body).Decode(&folder); err != nil {
+		err = fmt.Errorf("failed to decode folder descriptor: %v", err)
+		return nil, err
+	}
+	return folder, nil
+}
+
+func (f *sharedFolders) AddMember(ctx context.Context, name, nodeID, publicKey string) (*SharedFolder, error) {
+	folder, err := f.GetFolder(ctx, name)
+	if err != nil {
+		return nil, err
+	} else if _, ok := folder.Members[f.nodeID]; !ok {
+		return nil, ErrNotFolderMember
+	}
+	if _, err := parseFolderPublicKey(publicKey); err != nil {
+		return nil, err
+	}
+	folder.Members[nodeID] = publicKey
+	if err := f.rotateKey(folder); err != nil {
+		return nil, err
+	}
+	if err := f.saveFolder(ctx, folder); err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+func (f *sharedFolders) RemoveMember(ctx context.Context, name, nodeID string) (*SharedFolder, error) {
+	folder, err := f.GetFolder(ctx, name)
+	if err != nil {
+		return nil, err
+	} else if _, ok := folder.Members[f.nodeID]; !ok {
+		return nil, ErrNotFolderMember
+	} else if _, ok := folder.Members[nodeID]; !ok {
+		return folder, nil
+	}
+	delete(folder.Members, nodeID)
+	// the removed member must not be able to unwrap any key version from now on
+	for _, sealed := range folder.KeyRing {
+		delete(sealed, nodeID)
+	}
+	if err := f.rotateKey(folder); err != nil {
+		return nil, err
+	}
+	if err := f.saveFolder(ctx, folder); err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+// rotateKey generates a new folder key and wraps it for every current member.
+// Previous key versions stay in the key ring so that older content remains readable.
+func (f *sharedFolders) rotateKey(folder *SharedFolder) error {
+	key := new([32]byte)
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return err
+	}
+	if folder.KeyRing == nil {
+		folder.KeyRing = make(map[string]map[string]string)
+	}
+	folder.KeyVersion++
+	sealed := make(map[string]string, len(folder.Members))
+	for nodeID, pubHex := range folder.Members {
+		pub, err := parseFolderPublicKey(pubHex)
+		if err != nil {
+			log.WithField("nodeID", nodeID).Warningf("skipping folder member: %v", err)
+			continue
+		}
+		s, err := sealFolderKey(key, pub)
+		if err != nil {
+			return err
+		}
+		sealed[nodeID] = s
+	}
+	folder.KeyRing[strconv.Itoa(folder.KeyVersion)] = sealed
+	f.keyMux.Lock()
+	f.keys[folderKeyID(folder.Name, folder.KeyVersion)] = key
+	f.keyMux.Unlock()
+	return nil
+}
+
+func (f *sharedFolders) saveFolder(ctx context.Context, folder *SharedFolder) error {
+	data, err := json.Marshal(folder)
+	if err != nil {
+		return err
+	}
+	p := folderDescriptorPath(folder.Name)
+	_, err = f.store.CreateRecord(ctx, p, ioutil.NopCloser(bytes.NewReader(data)), CreateOptions{
+		Size: int64(len(data)),
+	})
+	if err == ErrRecordExists {
+		_, err = f.store.UpdateRecord(ctx, p, ioutil.NopCloser(bytes.NewReader(data)), UpdateOptions{
+			Size: int64(len(data)),
+		})
+	}
+	return err
+}
+
+func folderKeyID(name string, version int) string {
+	return name + "/" + strconv.Itoa(version)
+}
+
+func (f *sharedFolders) folderKey(folder *SharedFolder, version int) (*[32]byte, error) {
+	for _, v := range folder.RevokedKeys {
+		if v == version {
+			return nil, ErrFolderRevoked
+		}
+	}
+	id := folderKeyID(folder.Name, version)
+	f.keyMux.RLock()
+	key, ok := f.keys[id]
+	f.keyMux.RUnlock()
+	if ok {
+		return key, nil
+	}
+	sealed, ok := folder.KeyRing[strconv.Itoa(version)][f.nodeID]
+	if !ok {
+		return nil, ErrNotFolderMember
+	}
+	key, err := openFolderKey(sealed, f.pub, f.priv)
+	if err != nil {
+		return nil, err
+	}
+	f.keyMux.Lock()
+	f.keys[id] = key
+	f.keyMux.Unlock()
+	return key, nil
+}
+
+func (f *sharedFolders) PutFile(ctx context.Context, name, filePath string, body io.Reader) (*Record, error) {
+	folder, err := f.GetFolder(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if path.Base(folderFilePath(name, filePath)) == folderDescriptorName {
+		return nil, ErrFolderName
+	}
+	plain, err := ioutil.ReadAll(io.LimitReader(body, maxFolderObjectSize+1))
+	if err != nil {
+		return nil, err
+	} else if len(plain) > maxFolderObjectSize {
+		return nil, ErrFolderTooLarge
+	}
+	return f.writeFile(ctx, folder, filePath, plain)
+}
+
+func (f *sharedFolders) writeFile(ctx context.Context, folder *SharedFolder, filePath string, plain []byte) (*Record, error) {
+	key, err := f.folderKey(folder, folder.KeyVersion)
+	if err != nil {
+		return nil, err
+	}
+	data, err := encryptFolderObject(key, folder.KeyVersion, plain)
+	if err != nil {
+		return nil, err
+	}
+	userMeta, _ := json.Marshal(map[string]interface{}{
+		"folder":      folder.Name,
+		"key_version": folder.KeyVersion,
+	})
+	p := folderFilePath(folder.Name, filePath)
+	r, err := f.store.CreateRecord(ctx, p, ioutil.NopCloser(bytes.NewReader(data)), CreateOptions{
+		Size:     int64(len(data)),
+		UserMeta: userMeta,
+	})
+	if err == ErrRecordExists {
+		r, err = f.store.UpdateRecord(ctx, p, ioutil.NopCloser(bytes.NewReader(data)), UpdateOptions{
+			Size:     int64(len(data)),
+			UserMeta: userMeta,
+		})
+	}
+	return r, err
+}
+
+func (f *sharedFolders) GetFile(ctx context.Context, name, filePath string) (*Record, []byte, error) {
+	folder, err := f.GetFolder(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := f.store.ReadRecord(ctx, folderFilePath(name, filePath))
+	if err != nil {
+		return r, nil, err
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return r, nil, err
+	}
+	version, err := folderObjectVersion(data)
+	if err != nil {
+		return r, nil, err
+	}
+	key, err := f.folderKey(folder, version)
+	if err != nil {
+		return r, nil, err
+	}
+	plain, err := decryptFolderObject(key, data)
+	if err != nil {
+		return r, nil, err
+	}
+	if version < folder.KeyVersion && isPublishAllowed(f.nodeID) {
+		// lazy re-encryption with the current folder key
+		go func() {
+			ctx, cancelFn := context.WithTimeout(context.Background(), defaultFolderRewrapTimeout)
+			defer cancelFn()
+			if _, err := f.writeFile(ctx, folder, filePath, plain); err != nil {
+				log.WithField("path", r.Path()).Warningf("failed to re-encrypt folder object: %v", err)
+			}
+		}()
+	}
+	return r, plain, nil
+}
+
+func parseFolderPublicKey(s string) (*[32]byte, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) != 32 {
+		return nil, errors.New("folder public key must be 32 bytes hex-encoded")
+	}
+	pub := new([32]byte)
+	copy(pub[:], data)
+	return pub, nil
+}
+
+// sealFolderKey wraps the folder key for a member using an ephemeral sender key.
+func sealFolderKey(key, memberPub *[32]byte) (string, error) {
+	ephPub, ephPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return "", err
+	}
+	out := make([]byte, 0, 32+24+32+box.Overhead)
+	out = append(out, ephPub[:]...)
+	out = append(out, nonce[:]...)
+	out = box.Seal(out, key[:], &nonce, memberPub, ephPriv)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+func openFolderKey(sealed string, pub, priv *[32]byte) (*[32]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) != 32+24+32+box.Overhead {
+		return nil, ErrFolderCipher
+	}
+	var ephPub [32]byte
+	var nonce [24]byte
+	copy(ephPub[:], data[:32])
+	copy(nonce[:], data[32:56])
+	plain, ok := box.Open(nil, data[56:], &nonce, &ephPub, priv)
+	if !ok || len(plain) != 32 {
+		return nil, ErrFolderCipher
+	}
+	key := new([32]byte)
+	copy(key[:], plain)
+	return key, nil
+}
+
+// encryptFolderObject produces magic | key version | nonce | secretbox(plain).
+func encryptFolderObject(key *[32]byte, version int, plain []byte) ([]byte, error) {
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(folderCipherMagic)+4+24+len(plain)+secretbox.Overhead)
+	out = append(out, folderCipherMagic...)
+	var ver [4]byte
+	binary.BigEndian.PutUint32(ver[:], uint32(version))
+	out = append(out, ver[:]...)
+	out = append(out, nonce[:]...)
+	return secretbox.Seal(out, plain, &nonce, key), nil
+}
+
+func folderObjectVersion(data []byte) (int, error) {
+	if len(data) < len(folderCipherMagic)+4+24 || string(data[:len(folderCipherMagic)]) != folderCipherMagic {
+		return 0, ErrFolderCipher
+	}
+	offset := len(folderCipherMagic)
+	return int(binary.BigEndian.Uint32(data[offset : offset+4])), nil
+}
+
+func decryptFolderObject(key *[32]byte, data []byte) ([]byte, error) {
+	if _, err := folderObjectVersion(data); err != nil {
+		return nil, err
+	}
+	offset := len(folderCipherMagic) + 4
+	var nonce [24]byte
+	copy(nonce[:], data[offset:offset+24])
+	plain, ok := secretbox.Open(nil, data[offset+24:], &nonce, key)
+	if !ok {
+		return nil, ErrFolderCipher
+	}
+	return plain, nil
+}
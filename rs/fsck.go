@@ -0,0 +1,131 @@
+package rs
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+var ErrFsckRunning = errors.New("fsck is already running")
+
+// maxFsckDamaged limits versions listed in a report, the counts cover all of them.
+const maxFsckDamaged = 100
+
+type FsckReport struct {
+	Repair    bool      `json:"repair"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	Versions  int       `json:"versions"`
+	Blocks    int       `json:"blocks"`
+	Missing   int       `json:"missing_blocks"`
+	Fetched   int       `json:"fetched_blocks"`
+	Unpinned  int       `json:"unpinned"`
+	Repinned  int       `json:"repinned"`
+	// Damaged are versions that still miss blocks or pins.
+	Damaged []string `json:"damaged,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Fsck verifies that objects of all versions the records refer to are pinned and have all
+// their blocks locally. With repair set, missing blocks are fetched from peers and complete
+// objects are pinned again.
+func (r *recordStore) Fsck(ctx context.Context, repair bool) (*FsckReport, error) {
+	if !atomic.CompareAndSwapInt32(&r.fscking, 0, 1) {
+		return nil, ErrFsckRunning
+	}
+	defer atomic.StoreInt32(&r.fscking, 0)
+	report := &FsckReport{
+		Repair:    repair,
+		StartedAt: time.Now(),
+	}
+	err := r.runFsck(ctx, repair, report)
+	report.Duration = time.Since(report.StartedAt).String()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	log.WithFields(log.Fields{
+		"repair":   repair,
+		"versions": report.Versions,
+		"missing":  report.Missing,
+		"fetched":  report.Fetched,
+		"repinned": report.Repinned,
+		"duration": report.Duration,
+	}).Infof("fsck found %d damaged versions", len(report.Damaged))
+	return report, err
+}
+
+func (r *recordStore) runFsck(ctx context.Context, repair bool, report *FsckReport) error {
+	versions, err := r.referencedVersions(ctx)
+	if err != nil {
+		return err
+	}
+	var damaged int
+	for _, version := range versions {
+		if r.cold.isOffloaded(version) {
+			// blocks are in the cold store until the version is read
+			continue
+		}
+		check, err := r.fs.CheckObject(ctx, version, repair)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.WithField("version", version).Warningf("fsck failed to check the object: %v", err)
+			check = &fs.ObjectCheck{
+				Version: version,
+				Missing: 1,
+			}
+		}
+		report.Versions++
+		report.Blocks += check.Blocks
+		report.Missing += check.Missing
+		report.Fetched += check.Fetched
+		if !check.Pinned {
+			report.Unpinned++
+		}
+		if check.Repinned {
+			report.Repinned++
+		}
+		if check.Missing > 0 || !check.Pinned {
+			damaged++
+			if len(report.Damaged) < maxFsckDamaged {
+				report.Damaged = append(report.Damaged, version)
+			}
+		}
+	}
+	if damaged > len(report.Damaged) {
+		log.Warningf("fsck lists %d of %d damaged versions", len(report.Damaged), damaged)
+	}
+	return nil
+}
+
+// referencedVersions returns current and previous versions of all records.
+func (r *recordStore) referencedVersions(ctx context.Context) ([]string, error) {
+	referenced := make(map[string]struct{})
+	var versions []string
+	refer := func(version string) {
+		if _, ok := referenced[version]; ok || len(version) == 0 {
+			return
+		}
+		referenced[version] = struct{}{}
+		versions = append(versions, version)
+	}
+	b := state.NewBucket(state.BucketRecords)
+	if _, err := r.ss.RangePeek(ctx, b, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		refer(v.Current().Version())
+		for _, ver := range v.Previous().ToArray() {
+			refer(ver.Version())
+		}
+		return nil
+	})); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
@@ -0,0 +1,455 @@
+package rs
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/cache"
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+const (
+	// gcOrphanGrace is how long a pin must stay unreferenced by records before it's
+	// removed, so objects of writes in progress are not collected.
+	gcOrphanGrace = time.Hour
+	// gcDiskBatch is the number of versions dropped at once while over the disk usage limit.
+	gcDiskBatch   = 100
+	gcHeadTimeout = time.Minute
+)
+
+var ErrGCRunning = errors.New("garbage collection is already running")
+
+// GCPolicy limits content kept by the node. Pins of objects that no record refers
+// to are removed regardless of the policy, the current version of a live record is
+// never dropped.
+type GCPolicy struct {
+	// MaxDiskUsage is the IPFS repo size in bytes above which the oldest previous
+	// versions of records are dropped, those of the least read records first.
+	MaxDiskUsage uint64
+	// MaxRecordAge drops previous versions and deleted records older than that.
+	MaxRecordAge time.Duration
+	// BucketTTLs expire keys of state buckets, keys written since the last
+	// collection get their TTL on the next one.
+	BucketTTLs map[state.BucketID]time.Duration
+}
+
+type GCReport struct {
+	Manual          bool           `json:"manual"`
+	StartedAt       time.Time      `json:"started_at"`
+	Duration        string         `json:"duration"`
+	ExpiredKeys     map[string]int `json:"expired_keys,omitempty"`
+	RecordsPurged   int            `json:"records_purged"`
+	VersionsDropped int            `json:"versions_dropped"`
+	OrphansUnpinned int            `json:"orphans_unpinned"`
+	// OrphansPending are unreferenced pins that are removed once the grace period passes.
+	OrphansPending int    `json:"orphans_pending"`
+	BytesReclaimed int64  `json:"bytes_reclaimed"`
+	RepoSize       uint64 `json:"repo_size"`
+	Error          string `json:"error,omitempty"`
+}
+
+type GCStatus struct {
+	MaxDiskUsage    uint64            `json:"max_disk_usage,omitempty"`
+	MaxRecordAge    string            `json:"max_record_age,omitempty"`
+	BucketTTLs      map[string]string `json:"bucket_ttls,omitempty"`
+	Running         bool              `json:"running"`
+	LastRun         *GCReport         `json:"last_run,omitempty"`
+	Runs            uint64            `json:"runs_total"`
+	Failures        uint64            `json:"failures_total"`
+	VersionsDropped uint64            `json:"versions_dropped_total"`
+	OrphansUnpinned uint64            `json:"orphans_unpinned_total"`
+	BytesReclaimed  int64             `json:"bytes_reclaimed_total"`
+}
+
+type gcState struct {
+	policy  *GCPolicy
+	running int32
+
+	mux     *sync.RWMutex
+	orphans map[string]time.Time
+	last    *GCReport
+	totals  GCStatus
+}
+
+func newGCState(policy *GCPolicy) *gcState {
+	if policy == nil {
+		policy = &GCPolicy{}
+	}
+	return &gcState{
+		policy:  policy,
+		mux:     new(sync.RWMutex),
+		orphans: make(map[string]time.Time),
+	}
+}
+
+// RunGC periodically collects content that is no longer needed.
+func (r *recordStore) RunGC(ctx context.Context, interval time.Duration) {
+	t := time.NewTimer(interval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := r.collectGarbage(ctx, false); err != nil && err != ErrGCRunning {
+				log.Warningf("garbage collection failed: %v", err)
+			}
+			t.Reset(interval)
+		}
+	}
+}
+
+// CollectGarbage runs a collection right away and reports what has been collected.
+func (r *recordStore) CollectGarbage(ctx context.Context) (*GCReport, error) {
+	return r.collectGarbage(ctx, true)
+}
+
+func (r *recordStore) collectGarbage(ctx context.Context, manual bool) (*GCReport, error) {
+	if !atomic.CompareAndSwapInt32(&r.gc.running, 0, 1) {
+		return nil, ErrGCRunning
+	}
+	defer atomic.StoreInt32(&r.gc.running, 0)
+	report := &GCReport{
+		Manual:      manual,
+		StartedAt:   time.Now(),
+		ExpiredKeys: make(map[string]int),
+	}
+	err := r.runGC(ctx, report)
+	report.Duration = time.Since(report.StartedAt).String()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	r.gc.finish(report)
+	log.WithFields(log.Fields{
+		"manual":   manual,
+		"purged":   report.RecordsPurged,
+		"dropped":  report.VersionsDropped,
+		"orphans":  report.OrphansUnpinned,
+		"duration": report.Duration,
+	}).Infof("garbage collection reclaimed %d bytes", report.BytesReclaimed)
+	return report, err
+}
+
+type gcVersion struct {
+	id        string
+	timestamp int64
+	// reads of the record within popularityRetention
+	reads uint64
+}
+
+func (r *recordStore) runGC(ctx context.Context, report *GCReport) error {
+	policy := r.gc.policy
+	for id, ttl := range policy.BucketTTLs {
+		n, err := r.ss.Expire(id, ttl)
+		if err != nil {
+			return err
+		}
+		report.ExpiredKeys[id.String()] = n
+	}
+
+	now := time.Now()
+	var threshold int64
+	if policy.MaxRecordAge > 0 {
+		threshold = now.Add(-policy.MaxRecordAge).UnixNano()
+	}
+	var aged, stale []string
+	b := state.NewBucket(state.BucketRecords)
+	if _, err := r.ss.RangePeek(ctx, b, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		if threshold == 0 {
+			return nil
+		}
+		if v.Current().Announce().Timestamp() < threshold {
+			stale = append(stale, v.Id())
+		}
+		if previous := v.Previous(); previous.Len() > 0 && previous.At(0).Announce().Timestamp() < threshold {
+			aged = append(aged, v.Id())
+		}
+		return nil
+	})); err != nil {
+		return err
+	}
+	maxAge := &RetentionPolicy{maxAge: policy.MaxRecordAge}
+	dropped := r.trimRecords(aged, func(v *proto.Record) ([]proto.RecordVersion, bool) {
+		return maxAge.keep(v.Previous().ToArray(), now), true
+	})
+	for _, id := range stale {
+		versions, err := r.purgeDeleted(ctx, id)
+		if err != nil {
+			log.WithField("id", id).Warningf("failed to purge deleted record: %v", err)
+			continue
+		} else if len(versions) > 0 {
+			report.RecordsPurged++
+			dropped = append(dropped, versions...)
+		}
+	}
+	report.VersionsDropped += len(dropped)
+	r.unpinVersions(dropped)
+
+	orphans, pending, err := r.collectOrphans(ctx)
+	if err != nil {
+		return err
+	}
+	report.OrphansUnpinned = orphans
+	report.OrphansPending = pending
+	if len(dropped) > 0 || orphans > 0 {
+		reclaimed, err := r.fs.CollectGarbage(ctx)
+		if err != nil {
+			return err
+		}
+		report.BytesReclaimed += reclaimed
+	}
+	if policy.MaxDiskUsage > 0 {
+		if err := r.pruneToDiskUsage(ctx, policy.MaxDiskUsage, report); err != nil {
+			return err
+		}
+	}
+	if stats := r.fs.RepoStats(); stats != nil {
+		report.RepoSize = stats.RepoSize
+	}
+	return r.ss.Compact()
+}
+
+// purgeDeleted removes the record from the state if its current version is a delete,
+// returns all versions of the removed record.
+func (r *recordStore) purgeDeleted(ctx context.Context, id string) ([]string, error) {
+	k := state.RecordsKey([]byte(id))
+	var versions []string
+	if err := r.ss.View(ctx, k, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		versions = append(versions, v.Current().Version())
+		for _, ver := range v.Previous().ToArray() {
+			versions = append(versions, ver.Version())
+		}
+		return nil
+	})); err != nil {
+		return nil, err
+	}
+	headCtx, cancelFn := context.WithTimeout(ctx, gcHeadTimeout)
+	ref, err := r.fs.HeadObject(headCtx, fs.ObjectRef{
+		Version: versions[0],
+	})
+	cancelFn()
+	if err != nil {
+		return nil, err
+	} else if !ref.Meta().IsDeleted() {
+		return nil, nil
+	}
+	if err := r.ss.Delete(k); err != nil {
+		return nil, err
+	}
+	r.unindexNamespace(id, ref.Path)
+	r.cache.Invalidate(cache.Paths, ref.Path)
+	for _, version := range versions {
+		r.cache.Invalidate(cache.Objects, version)
+	}
+	return versions, nil
+}
+
+// collectOrphans unpins objects that no record refers to for longer than the grace period.
+func (r *recordStore) collectOrphans(ctx context.Context) (unpinned, pending int, err error) {
+	referenced := make(map[string]struct{})
+	b := state.NewBucket(state.BucketRecords)
+	if _, err := r.ss.RangePeek(ctx, b, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		referenced[v.Current().Version()] = struct{}{}
+		for _, ver := range v.Previous().ToArray() {
+			referenced[ver.Version()] = struct{}{}
+		}
+		return nil
+	})); err != nil {
+		return 0, 0, err
+	}
+	if err := r.heldShards(ctx, referenced); err != nil {
+		return 0, 0, err
+	}
+	var expired []string
+	r.gc.mux.Lock()
+	seen := make(map[string]time.Time)
+	for _, version := range r.fs.PinnedObjects() {
+		if _, ok := referenced[version]; ok {
+			continue
+		}
+		firstSeen, ok := r.gc.orphans[version]
+		if !ok {
+			firstSeen = time.Now()
+		}
+		if time.Since(firstSeen) >= gcOrphanGrace {
+			expired = append(expired, version)
+			continue
+		}
+		seen[version] = firstSeen
+	}
+	r.gc.orphans = seen
+	r.gc.mux.Unlock()
+	r.unpinVersions(expired)
+	return len(expired), len(seen), nil
+}
+
+// pruneToDiskUsage drops the oldest previous versions across all records until
+// the repo fits the limit or only current versions are left.
+func (r *recordStore) pruneToDiskUsage(ctx context.Context, limit uint64, report *GCReport) error {
+	var versions []gcVersion
+	b := state.NewBucket(state.BucketRecords)
+	if _, err := r.ss.RangePeek(ctx, b, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		previous := v.Previous().ToArray()
+		if len(previous) == 0 {
+			return nil
+		}
+		reads := r.popularity.reads(v.Path(), popularityRetention)
+		for _, ver := range previous {
+			versions = append(versions, gcVersion{
+				id:        v.Id(),
+				timestamp: ver.Announce().Timestamp(),
+				reads:     reads,
+			})
+		}
+		return nil
+	})); err != nil {
+		return err
+	}
+	// history of records nobody reads goes first
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].reads != versions[j].reads {
+			return versions[i].reads < versions[j].reads
+		}
+		return versions[i].timestamp < versions[j].timestamp
+	})
+	for {
+		stats := r.fs.RepoStats()
+		if stats == nil || stats.RepoSize <= limit {
+			return nil
+		} else if len(versions) == 0 {
+			log.Warningf("IPFS repo size %d is above the limit of %d with current versions only", stats.RepoSize, limit)
+			return nil
+		}
+		batch := versions
+		if len(batch) > gcDiskBatch {
+			batch = batch[:gcDiskBatch]
+		}
+		versions = versions[len(batch):]
+		counts := make(map[string]int)
+		var ids []string
+		for _, ver := range batch {
+			if counts[ver.id] == 0 {
+				ids = append(ids, ver.id)
+			}
+			counts[ver.id]++
+		}
+		dropped := r.trimRecords(ids, func(v *proto.Record) ([]proto.RecordVersion, bool) {
+			previous := v.Previous().ToArray()
+			n := counts[v.Id()]
+			if n > len(previous) {
+				n = len(previous)
+			}
+			return previous[n:], true
+		})
+		report.VersionsDropped += len(dropped)
+		r.unpinVersions(dropped)
+		reclaimed, err := r.fs.CollectGarbage(ctx)
+		if err != nil {
+			return err
+		}
+		report.BytesReclaimed += reclaimed
+	}
+}
+
+// trimRecords replaces previous versions of the records with the ones returned by keep,
+// that must be the newest of them. Returns versions that have been dropped.
+func (r *recordStore) trimRecords(ids []string, keep func(v *proto.Record) ([]proto.RecordVersion, bool)) []string {
+	var dropped []string
+	for _, id := range ids {
+		var trimmed []string
+		k := state.RecordsKey([]byte(id))
+		if err := r.ss.Update(context.Background(), k, proto.RecordModify(func(k *state.Key, v *proto.Record) (*proto.Record, error) {
+			trimmed = nil
+			if v == nil {
+				return nil, state.ErrNoUpdate
+			}
+			previous := v.Previous().ToArray()
+			kept, ok := keep(v)
+			if !ok || len(kept) == len(previous) {
+				return nil, state.ErrNoUpdate
+			}
+			for _, ver := range previous[:len(previous)-len(kept)] {
+				trimmed = append(trimmed, ver.Version())
+			}
+			v.SetPrevious(proto.NewRecordVersionListFrom(kept))
+			return v, nil
+		})); err != nil {
+			log.WithField("id", id).Warningf("failed to trim record versions: %v", err)
+			continue
+		}
+		for _, version := range trimmed {
+			r.cache.Invalidate(cache.Objects, version)
+		}
+		dropped = append(dropped, trimmed...)
+	}
+	return dropped
+}
+
+// DropVersions removes previous versions from the history of the record at path and unpins
+// them, the current version is kept. Blocks are removed by the next garbage collection.
+func (r *recordStore) DropVersions(ctx context.Context, path string) (int, error) {
+	if err := r.checkProduce(path); err != nil {
+		return 0, err
+	}
+	id, err := r.findRecordID(ctx, path, "")
+	if err != nil {
+		return 0, err
+	}
+	dropped := r.trimRecords([]string{id}, func(v *proto.Record) ([]proto.RecordVersion, bool) {
+		return nil, true
+	})
+	r.unpinVersions(dropped)
+	return len(dropped), nil
+}
+
+// unpinVersions unpins objects and shards of them held by this node, their blocks
+// are removed by the next garbage collection.
+func (r *recordStore) unpinVersions(versions []string) {
+	for _, ver := range versions {
+		r.releaseShards(ver)
+		if err := r.fs.UnpinObject(fs.ObjectRef{
+			Version: ver,
+		}); err != nil {
+			log.WithField("version", ver).Debugf("failed to unpin object: %v", err)
+		}
+	}
+}
+
+func (s *gcState) finish(report *GCReport) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.last = report
+	s.totals.Runs++
+	if len(report.Error) > 0 {
+		s.totals.Failures++
+	}
+	s.totals.VersionsDropped += uint64(report.VersionsDropped)
+	s.totals.OrphansUnpinned += uint64(report.OrphansUnpinned)
+	s.totals.BytesReclaimed += report.BytesReclaimed
+}
+
+func (r *recordStore) GCStatus() *GCStatus {
+	r.gc.mux.RLock()
+	defer r.gc.mux.RUnlock()
+	status := r.gc.totals
+	status.Running = atomic.LoadInt32(&r.gc.running) == 1
+	status.LastRun = r.gc.last
+	status.MaxDiskUsage = r.gc.policy.MaxDiskUsage
+	if r.gc.policy.MaxRecordAge > 0 {
+		status.MaxRecordAge = r.gc.policy.MaxRecordAge.String()
+	}
+	if len(r.gc.policy.BucketTTLs) > 0 {
+		status.BucketTTLs = make(map[string]string, len(r.gc.policy.BucketTTLs))
+		for id, ttl := range r.gc.policy.BucketTTLs {
+			status.BucketTTLs[id.String()] = ttl.String()
+		}
+	}
+	return &status
+}
@@ -0,0 +1,78 @@
+package rs
+
+import (
+	"context"
+	"time"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// RecordHistory is the version log of a record, the current version first.
+type RecordHistory struct {
+	ID       string          `json:"id"`
+	Path     string          `json:"path"`
+	Versions []*HistoryEntry `json:"versions"`
+}
+
+// HistoryEntry is a version of a record, Previous links to the version it replaced.
+type HistoryEntry struct {
+	Version   string    `json:"version"`
+	Previous  string    `json:"previous,omitempty"`
+	NodeID    string    `json:"node_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// RecordHistory returns the version log of the record with the given ID or path. Unlike
+// ReadRecord it's served from the state store only, so versions are not checked to exist
+// in the file store.
+func (r *recordStore) RecordHistory(ctx context.Context, idOrPath string) (*RecordHistory, error) {
+	defer r.inboundWork()
+	v, err := r.ssBreaker.Do(ctx, func(ctx context.Context) (interface{}, error) {
+		id, err := r.findRecordID(ctx, idOrPath, "")
+		if err != nil {
+			return nil, err
+		}
+		var history *RecordHistory
+		k := state.RecordsKey([]byte(id))
+		if err := r.ss.View(ctx, k, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+			if v == nil {
+				return ErrRecordNotFound
+			}
+			history = recordHistory(v)
+			return nil
+		})); err != nil {
+			return nil, err
+		}
+		return history, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*RecordHistory), nil
+}
+
+func recordHistory(rec *proto.Record) *RecordHistory {
+	prev := rec.Previous()
+	history := &RecordHistory{
+		ID:       rec.Id(),
+		Path:     rec.Path(),
+		Versions: make([]*HistoryEntry, 0, prev.Len()+1),
+	}
+	add := func(ver proto.RecordVersion) {
+		if n := len(history.Versions); n > 0 {
+			history.Versions[n-1].Previous = ver.Version()
+		}
+		history.Versions = append(history.Versions, &HistoryEntry{
+			Version:   ver.Version(),
+			NodeID:    ver.Announce().NodeID(),
+			Timestamp: time.Unix(0, ver.Announce().Timestamp()),
+		})
+	}
+	add(rec.Current())
+	// previous versions are kept oldest first
+	for i := prev.Len() - 1; i >= 0; i-- {
+		add(prev.At(i))
+	}
+	return history
+}
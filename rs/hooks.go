@@ -0,0 +1,324 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proxy"
+)
+
+type WriteOp int
+
+const (
+	WriteCreate WriteOp = 0
+	WriteUpdate WriteOp = 1
+	WriteDelete WriteOp = 2
+)
+
+func (w WriteOp) String() string {
+	switch w {
+	case WriteCreate:
+		return "create"
+	case WriteUpdate:
+		return "update"
+	case WriteDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// WriteRequest describes a pending local write. Pre-commit hooks may change UserMeta
+// and Body in order to transform the write before it is committed and gossiped,
+// changes of Path are ignored.
+type WriteRequest struct {
+	Op       WriteOp         `json:"op"`
+	NodeID   string          `json:"node_id"`
+	Path     string          `json:"path"`
+	UserMeta json.RawMessage `json:"user_meta,omitempty"`
+	Body     []byte          `json:"body,omitempty"`
+	// DryRun is set if the write is only validated and will not be committed.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+func (w *WriteRequest) clone() *WriteRequest {
+	c := *w
+	c.UserMeta = append(json.RawMessage(nil), w.UserMeta...)
+	c.Body = append([]byte(nil), w.Body...)
+	return &c
+}
+
+// PreCommitHook validates or transforms a write. Returning a *HookRejection always
+// rejects the write, any other error is treated according to the hook's failure policy.
+type PreCommitHook func(ctx context.Context, req *WriteRequest) error
+
+type HookPolicy int
+
+const (
+	// HookFailClosed rejects the write if the hook fails or times out.
+	HookFailClosed HookPolicy = 0
+	// HookFailOpen lets the write through unchanged if the hook fails or times out.
+	HookFailOpen HookPolicy = 1
+)
+
+func (h HookPolicy) String() string {
+	if h == HookFailOpen {
+		return "fail-open"
+	}
+	return "fail-closed"
+}
+
+func HookPolicyFromString(s string) (HookPolicy, error) {
+	switch s {
+	case "fail-closed", "closed", "":
+		return HookFailClosed, nil
+	case "fail-open", "open":
+		return HookFailOpen, nil
+	default:
+		return HookFailClosed, fmt.Errorf("unknown hook policy: %s", s)
+	}
+}
+
+type HookOptions struct {
+	// Prefix limits the hook to records under the given path prefix.
+	Prefix  string
+	Timeout time.Duration
+	Policy  HookPolicy
+}
+
+type HookInfo struct {
+	Name    string `json:"name"`
+	Prefix  string `json:"prefix"`
+	Timeout string `json:"timeout"`
+	Policy  string `json:"policy"`
+}
+
+// HookRejection is returned by hooks that explicitly refuse a write.
+type HookRejection struct {
+	Hook   string
+	Reason string
+}
+
+func (h *HookRejection) Error() string {
+	if len(h.Hook) > 0 {
+		return fmt.Sprintf("write rejected by hook %s: %s", h.Hook, h.Reason)
+	}
+	return fmt.Sprintf("write rejected: %s", h.Reason)
+}
+
+// RejectWrite can be returned by a PreCommitHook to refuse the write.
+func RejectWrite(reason string) error {
+	return &HookRejection{
+		Reason: reason,
+	}
+}
+
+var (
+	ErrHookExists    = errors.New("hook with the same name exists")
+	ErrHookBodyLimit = errors.New("record body is too large for pre-commit hooks")
+)
+
+const (
+	defaultHookTimeout = 5 * time.Second
+	maxHookBodySize    = 64 * 1024 * 1024
+)
+
+type registeredHook struct {
+	name string
+	hook PreCommitHook
+	opts HookOptions
+}
+
+type hookRegistry struct {
+	mux   *sync.RWMutex
+	hooks []*registeredHook
+}
+
+func newHookRegistry() *hookRegistry {
+	return &hookRegistry{
+		mux: new(sync.RWMutex),
+	}
+}
+
+func (h *hookRegistry) Register(name string, hook PreCommitHook, opts HookOptions) error {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultHookTimeout
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for _, r := range h.hooks {
+		if r.name == name {
+			return ErrHookExists
+		}
+	}
+	h.hooks = append(h.hooks, &registeredHook{
+		name: name,
+		hook: hook,
+		opts: opts,
+	})
+	return nil
+}
+
+func (h *hookRegistry) Unregister(name string) bool {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for i, r := range h.hooks {
+		if r.name == name {
+			h.hooks = append(h.hooks[:i], h.hooks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (h *hookRegistry) List() []HookInfo {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	list := make([]HookInfo, 0, len(h.hooks))
+	for _, r := range h.hooks {
+		list = append(list, HookInfo{
+			Name:    r.name,
+			Prefix:  r.opts.Prefix,
+			Timeout: r.opts.Timeout.String(),
+			Policy:  r.opts.Policy.String(),
+		})
+	}
+	return list
+}
+
+func (h *hookRegistry) matching(path string) []*registeredHook {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	var list []*registeredHook
+	for _, r := range h.hooks {
+		if strings.HasPrefix(path, r.opts.Prefix) {
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+// Apply runs all hooks matching the request path in registration order. Each hook
+// sees the request as transformed by the previous ones. The body is buffered only
+// when at least one hook applies, in that case the returned body replaces the original.
+func (h *hookRegistry) Apply(ctx context.Context, req *WriteRequest, body io.ReadCloser) (io.ReadCloser, error) {
+	hooks := h.matching(req.Path)
+	if len(hooks) == 0 {
+		return body, nil
+	}
+	if body != nil {
+		data, err := ioutil.ReadAll(io.LimitReader(body, maxHookBodySize+1))
+		body.Close()
+		if err != nil {
+			err = fmt.Errorf("failed to read record body: %v", err)
+			return nil, err
+		} else if len(data) > maxHookBodySize {
+			return nil, ErrHookBodyLimit
+		}
+		req.Body = data
+	}
+	for _, r := range hooks {
+		out, err := r.run(ctx, req)
+		if rej, ok := err.(*HookRejection); ok {
+			rej.Hook = r.name
+			return nil, rej
+		} else if err != nil {
+			if r.opts.Policy == HookFailOpen {
+				log.WithField("hook", r.name).Warningf("pre-commit hook failed, allowing write: %v", err)
+				continue
+			}
+			return nil, &HookRejection{
+				Hook:   r.name,
+				Reason: err.Error(),
+			}
+		}
+		out.Path = req.Path
+		out.Path = req.Path
+		*req = *out
+	}
+	if body == nil {
+		return nil, nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(req.Body)), nil
+}
+
+func (r *registeredHook) run(ctx context.Context, req *WriteRequest) (*WriteRequest, error) {
+	ctx, cancelFn := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancelFn()
+	// hook works on a copy, so a late hook cannot race with the commit
+	out := req.clone()
+	errC := make(chan error, 1)
+	go func() {
+		errC <- r.hook(ctx, out)
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("hook timed out after %s", r.opts.Timeout)
+	case err := <-errC:
+		if err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+}
+
+type webhookResponse struct {
+	Allow    bool            `json:"allow"`
+	Reason   string          `json:"reason"`
+	Path     *string         `json:"path"`
+	UserMeta json.RawMessage `json:"user_meta"`
+	Body     []byte          `json:"body"`
+}
+
+// NewWebhook creates a hook that POSTs the write request as JSON to an out-of-process
+// application. The application replies with {"allow": bool, "reason": string} and may
+// also return replacement user_meta or body fields to transform the write.
+func NewWebhook(url string) PreCommitHook {
+	client := proxy.Client(0)
+	return func(ctx context.Context, req *WriteRequest) error {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		hreq, err := http.NewRequest("POST", url, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		hreq.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(hreq.WithContext(ctx))
+		if err != nil {
+			err = fmt.Errorf("webhook request failed: %v", err)
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+		}
+		var wr *webhookResponse
+		if err := json.NewDecoder(resp.Body).Decode(&wr); err != nil {
+			err = fmt.Errorf("failed to decode webhook response: %v", err)
+			return err
+		}
+		if !wr.Allow {
+			return RejectWrite(wr.Reason)
+		}
+		if len(wr.UserMeta) > 0 && string(wr.UserMeta) != "null" {
+			req.UserMeta = wr.UserMeta
+		}
+		if wr.Body != nil {
+			req.Body = wr.Body
+		}
+		return nil
+	}
+}
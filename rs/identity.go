@@ -0,0 +1,160 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// A node rotates its identity by writing /identity-links/<own node ID>/<new node ID> before
+// it restarts with the new key. The record is signed as any other, so the old ID is the author
+// of its current version. Links written by holders of permissions are passed to the authority,
+// see authcenter.SetLinks: the new ID holds permissions of the old one, takes its place among
+// sync candidates, and usage reported by the old ID is accounted to the new one.
+
+const identityLinkRoot = "/identity-links/"
+
+var ErrIdentityRotated = errors.New("the identity of the node was rotated already, restart the node to use the new one")
+
+// IdentityRotation is the result of RotateIdentity.
+type IdentityRotation struct {
+	OldID     string    `json:"old_id"`
+	NewID     string    `json:"new_id"`
+	RotatedAt time.Time `json:"rotated_at"`
+}
+
+// RotateIdentity generates a new identity of the node and links the current node ID to it.
+// The new identity is written into the IPFS repo config and used once the node restarts.
+func (r *recordStore) RotateIdentity(ctx context.Context) (*IdentityRotation, error) {
+	if r.opts.ReadOnly {
+		return nil, ErrReadOnly
+	} else if !isPublishAllowed(r.nodeID) {
+		return nil, ErrNotAuthorized
+	} else if authcenter.Retired(r.nodeID) {
+		return nil, ErrIdentityRotated
+	}
+	if linked, err := r.identityLinked(ctx); err != nil {
+		return nil, err
+	} else if linked {
+		return nil, ErrIdentityRotated
+	}
+	prev, err := r.fs.Identity()
+	if err != nil {
+		return nil, err
+	}
+	next, err := fs.NewIdentity()
+	if err != nil {
+		return nil, err
+	}
+	// the key is saved first, so a published link never points to a lost key
+	if err := r.fs.SetIdentity(next); err != nil {
+		return nil, err
+	}
+	// the data key of encrypted state buckets must open with the next identity
+	if err := state.RewrapStoreKey(r.ss, prev.PrivKey, next.PrivKey); err != nil {
+		if err := r.fs.SetIdentity(prev); err != nil {
+			log.Errorf("failed to restore the identity after a failed rotation: %v", err)
+		}
+		return nil, err
+	}
+	rotation := &IdentityRotation{
+		OldID:     r.nodeID,
+		NewID:     next.NodeID,
+		RotatedAt: time.Now().UTC(),
+	}
+	body, _ := json.Marshal(rotation)
+	p := identityLinkRoot + r.nodeID + "/" + next.NodeID
+	if _, err := r.CreateRecord(ctx, p, ioutil.NopCloser(bytes.NewReader(body))); err != nil {
+		if err := r.fs.SetIdentity(prev); err != nil {
+			log.Errorf("failed to restore the identity after a failed rotation: %v", err)
+		} else if err := state.RewrapStoreKey(r.ss, next.PrivKey, prev.PrivKey); err != nil {
+			log.Errorf("failed to restore the state data key after a failed rotation: %v", err)
+		}
+		return nil, err
+	}
+	log.WithField("new_id", next.NodeID).Warningln("identity rotated, restart the node to use the new one")
+	return rotation, nil
+}
+
+// identityLinked tells whether this node has linked its ID to another one already.
+func (r *recordStore) identityLinked(ctx context.Context) (bool, error) {
+	prefix := identityLinkRoot + r.nodeID + "/"
+	var linked bool
+	err := r.WalkRecords(ctx, "", func(p string, rec *Record) error {
+		if strings.HasPrefix(p, prefix) {
+			linked = true
+			return ErrWalkStop
+		}
+		return nil
+	})
+	return linked, err
+}
+
+// LoadIdentityLinks reads identity links from records and passes them to the authority.
+func (r *recordStore) LoadIdentityLinks(ctx context.Context) error {
+	var links []*authcenter.Link
+	if err := r.WalkRecords(ctx, "", func(p string, rec *Record) error {
+		if !strings.HasPrefix(p, identityLinkRoot) {
+			return nil
+		}
+		from, to := path.Split(strings.TrimPrefix(p, identityLinkRoot))
+		from = strings.TrimSuffix(from, "/")
+		ann := rec.Current().Announce()
+		// only the old ID may link itself
+		if len(from) == 0 || strings.Contains(from, "/") || from == to ||
+			ann.NodeID() != from || !fs.IsValidNodeID(to) {
+			return nil
+		}
+		links = append(links, &authcenter.Link{
+			From:  from,
+			To:    to,
+			Since: time.Unix(0, ann.Timestamp()),
+		})
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Since.Before(links[j].Since)
+	})
+	// IDs rotated before hold permissions through the links accepted so far
+	var accepted []*authcenter.Link
+	linked := make(map[string]bool)
+	for _, l := range links {
+		if !authcenter.Default.HasPermissions(l.From, authcenter.RecordWritePermission) && !linked[l.From] {
+			continue
+		}
+		accepted = append(accepted, l)
+		linked[l.To] = true
+	}
+	authcenter.SetLinks(accepted)
+	return nil
+}
+
+// WatchIdentityLinks periodically reloads identity links.
+func (r *recordStore) WatchIdentityLinks(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := r.LoadIdentityLinks(ctx); err != nil {
+				log.Warningf("failed to load identity links: %v", err)
+			}
+		}
+	}
+}
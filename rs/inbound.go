@@ -0,0 +1,171 @@
+package rs
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/metrics"
+	"github.com/AtlantPlatform/atlant-go/proto"
+)
+
+// Inbound announces are handled by a pool of workers. Each announce is routed to a worker
+// by its key, the record ID for record updates and the owner node for other events, so that
+// changes of a record are applied in the order they were received while unrelated ones are
+// applied in parallel. Queues of workers are unbounded and receiving never blocks pubsub,
+// instead the depth of queues and the time announces wait in them are reported: a growing
+// wait means the node falls behind the swarm and needs more workers. On close, announces
+// still queued are handled with a short timeout, or dropped if the store never got ready,
+// the sync on the next start fetches them.
+
+const (
+	defaultInboundWorkers = 8
+	inboundDrainTimeout   = 10 * time.Second
+)
+
+// inboundWaitBuckets are upper bounds of the queue wait histogram, in seconds.
+var inboundWaitBuckets = []float64{0.01, 0.1, 0.5, 1, 5, 15, 60, 300}
+
+type InboundStats struct {
+	Workers int   `json:"workers"`
+	Busy    int64 `json:"busy"`
+	// MaxDepth is the depth of the most loaded worker queue, a hot record shows up here.
+	MaxDepth int64                      `json:"max_depth"`
+	Handled  uint64                     `json:"handled_total"`
+	Failed   uint64                     `json:"failed_total"`
+	Wait     *metrics.HistogramSnapshot `json:"wait"`
+}
+
+type inboundWorker struct {
+	pump  chan *EventAnnounce
+	queue chan *EventAnnounce
+	depth int64
+}
+
+type inboundPool struct {
+	workers []*inboundWorker
+	wg      *sync.WaitGroup
+	wait    *metrics.HistogramCounter
+
+	queued  int64
+	busy    int64
+	handled uint64
+	failed  uint64
+	closing int32
+}
+
+func newInboundPool(workers int) *inboundPool {
+	if workers <= 0 {
+		workers = defaultInboundWorkers
+	}
+	p := &inboundPool{
+		workers: make([]*inboundWorker, workers),
+		wg:      new(sync.WaitGroup),
+		wait:    metrics.NewHistogramCounter(inboundWaitBuckets...),
+	}
+	for i := range p.workers {
+		queue := make(chan *EventAnnounce, 64)
+		p.workers[i] = &inboundWorker{
+			pump:  pumpEventAnnounces(queue),
+			queue: queue,
+		}
+	}
+	return p
+}
+
+// inboundKey returns the key announces are ordered by. Sealed record updates are ordered
+// by their writer, the record is unknown until they're opened.
+func inboundKey(ev *EventAnnounce) string {
+	if ev.Type == EventRecordUpdate {
+		if update, err := proto.UnpackEnvelopeRecordUpdate(ev.Announce.Envelope()); err == nil {
+			return update.Id()
+		}
+	}
+	return ev.Announce.NodeID()
+}
+
+func (p *inboundPool) push(ev *EventAnnounce) {
+	h := fnv.New32a()
+	h.Write([]byte(inboundKey(ev)))
+	w := p.workers[int(h.Sum32()%uint32(len(p.workers)))]
+	ev.queuedAt = time.Now()
+	atomic.AddInt64(&p.queued, 1)
+	atomic.AddInt64(&w.depth, 1)
+	w.pump <- ev
+}
+
+// close stops the pumps, workers exit once their queues are drained.
+func (p *inboundPool) close() {
+	atomic.StoreInt32(&p.closing, 1)
+	for _, w := range p.workers {
+		w.pump <- &EventAnnounce{
+			Type: EventStopAnnounce,
+		}
+	}
+}
+
+func (p *inboundPool) isClosing() bool {
+	return atomic.LoadInt32(&p.closing) == 1
+}
+
+func (p *inboundPool) dequeued(w *inboundWorker) {
+	atomic.AddInt64(&p.queued, -1)
+	atomic.AddInt64(&w.depth, -1)
+}
+
+func (p *inboundPool) Stats() *InboundStats {
+	stats := &InboundStats{
+		Workers: len(p.workers),
+		Busy:    atomic.LoadInt64(&p.busy),
+		Handled: atomic.LoadUint64(&p.handled),
+		Failed:  atomic.LoadUint64(&p.failed),
+		Wait:    p.wait.Snapshot(),
+	}
+	for _, w := range p.workers {
+		if depth := atomic.LoadInt64(&w.depth); depth > stats.MaxDepth {
+			stats.MaxDepth = depth
+		}
+	}
+	return stats
+}
+
+func (r *recordStore) processInbound(timeout time.Duration) {
+	p := r.inbound
+	for _, w := range p.workers {
+		p.wg.Add(1)
+		go func(w *inboundWorker) {
+			defer p.wg.Done()
+
+			for !r.IsReady() {
+				if p.isClosing() {
+					for range w.queue {
+						p.dequeued(w)
+					}
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			for ev := range w.queue {
+				p.dequeued(w)
+				p.wait.Observe(time.Since(ev.queuedAt).Seconds())
+				handleTimeout := timeout
+				if p.isClosing() {
+					handleTimeout = inboundDrainTimeout
+				}
+				atomic.AddInt64(&p.busy, 1)
+				err := r.handleEvent(ev, handleTimeout)
+				atomic.AddInt64(&p.busy, -1)
+				if err != nil {
+					atomic.AddUint64(&p.failed, 1)
+					log.Warningln("error handling event:", err)
+					continue
+				}
+				atomic.AddUint64(&p.handled, 1)
+				r.inboundWork()
+			}
+		}(w)
+	}
+}
@@ -0,0 +1,309 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Join tokens onboard new testnet nodes in one step. A permission holder mints a token that
+// bundles the swarm key of the testnet, addresses of bootstrap peers, the issuer node ID and
+// a one-time secret. A new node initialized with the token joins the swarm and redeems the
+// token at the issuer over the peer API, the issuer vouches for the node then, see Vouch, so
+// the node is put on probation once the vouch threshold is met. Issued tokens are kept in the
+// state store of the issuer by the hash of their secret, each may be redeemed by one node.
+
+const (
+	JoinTokenPrefix     = "atlj1."
+	DefaultJoinTokenTTL = 72 * time.Hour
+	maxJoinTokenTTL     = 30 * 24 * time.Hour
+	joinRetryInterval   = 30 * time.Second
+)
+
+var (
+	ErrJoinToken         = errors.New("join token is unknown, expired, revoked or used by another node")
+	ErrJoinTokenDisabled = errors.New("join tokens require vouches enabled on the node")
+)
+
+// JoinToken is what a new node needs to join the testnet.
+type JoinToken struct {
+	ID        string    `json:"id"`
+	Secret    string    `json:"secret"`
+	Issuer    string    `json:"issuer"`
+	SwarmKey  string    `json:"swarm_key"`
+	Bootstrap []string  `json:"bootstrap"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Encode returns the token as a single string to pass to init --join-token.
+func (t *JoinToken) Encode() string {
+	data, _ := json.Marshal(t)
+	return JoinTokenPrefix + base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseJoinToken decodes a token encoded by Encode.
+func ParseJoinToken(s string) (*JoinToken, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, JoinTokenPrefix) {
+		return nil, errors.New("not a join token")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, JoinTokenPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("malformed join token: %v", err)
+	}
+	var t JoinToken
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("malformed join token: %v", err)
+	}
+	if len(t.ID) == 0 || len(t.Secret) == 0 {
+		return nil, errors.New("join token has no ID or secret")
+	} else if !fs.IsValidNodeID(t.Issuer) {
+		return nil, fmt.Errorf("join token has invalid issuer %s", t.Issuer)
+	} else if key, err := hex.DecodeString(t.SwarmKey); err != nil || len(key) != 32 {
+		return nil, errors.New("join token has invalid swarm key")
+	}
+	return &t, nil
+}
+
+// JoinGrant is a token issued by this node, as listed to operators.
+type JoinGrant struct {
+	ID         string    `json:"id"`
+	SecretHash string    `json:"secret_hash,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Revoked    bool      `json:"revoked,omitempty"`
+	RedeemedBy string    `json:"redeemed_by,omitempty"`
+	RedeemedAt time.Time `json:"redeemed_at,omitempty"`
+}
+
+// JoinRequest is sent by a new node to the issuer of its token.
+type JoinRequest struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+	NodeID string `json:"node_id"`
+}
+
+func joinGrantKey(id string) *state.Key {
+	return state.JoinTokensKey(id)
+}
+
+func hashJoinSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *recordStore) joinEnabled() bool {
+	return r.opts.Vouch != nil && r.opts.Vouch.Threshold > 0
+}
+
+func (r *recordStore) MintJoinToken(swarmKey string, bootstrap []string, ttl time.Duration) (*JoinToken, error) {
+	if !isPublishAllowed(r.nodeID) {
+		return nil, ErrNotAuthorized
+	} else if !r.joinEnabled() {
+		return nil, ErrJoinTokenDisabled
+	} else if len(swarmKey) == 0 {
+		return nil, errors.New("no swarm key to issue")
+	}
+	if ttl <= 0 {
+		ttl = DefaultJoinTokenTTL
+	} else if ttl > maxJoinTokenTTL {
+		ttl = maxJoinTokenTTL
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	token := &JoinToken{
+		ID:        proto.NewID(),
+		Secret:    hex.EncodeToString(buf),
+		Issuer:    r.nodeID,
+		SwarmKey:  swarmKey,
+		ExpiresAt: now.Add(ttl),
+	}
+	// the issuer is dialed first, the node must reach it to redeem the token
+	seen := make(map[string]bool)
+	for _, addr := range append(r.fs.SwarmAddrs(), bootstrap...) {
+		if len(addr) > 0 && !seen[addr] {
+			seen[addr] = true
+			token.Bootstrap = append(token.Bootstrap, addr)
+		}
+	}
+	data, _ := json.Marshal(&JoinGrant{
+		ID:         token.ID,
+		SecretHash: hashJoinSecret(token.Secret),
+		CreatedAt:  now,
+		ExpiresAt:  token.ExpiresAt,
+	})
+	if err := r.ss.Update(context.Background(), joinGrantKey(token.ID), func(k *state.Key, v []byte) ([]byte, error) {
+		return data, nil
+	}); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// claimJoinGrant marks the grant redeemed by the node, it's idempotent for the same node.
+func (r *recordStore) claimJoinGrant(req *JoinRequest) error {
+	return r.ss.Update(context.Background(), joinGrantKey(req.ID), func(k *state.Key, v []byte) ([]byte, error) {
+		var grant JoinGrant
+		if len(v) == 0 {
+			return nil, ErrJoinToken
+		} else if err := json.Unmarshal(v, &grant); err != nil {
+			return nil, err
+		}
+		hash := hashJoinSecret(req.Secret)
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(grant.SecretHash)) != 1 ||
+			grant.Revoked || time.Now().After(grant.ExpiresAt) {
+			return nil, ErrJoinToken
+		} else if len(grant.RedeemedBy) > 0 && grant.RedeemedBy != req.NodeID {
+			return nil, ErrJoinToken
+		}
+		grant.RedeemedBy = req.NodeID
+		grant.RedeemedAt = time.Now().UTC()
+		return json.Marshal(&grant)
+	})
+}
+
+func (r *recordStore) RedeemJoinToken(ctx context.Context, req *JoinRequest) error {
+	if !r.joinEnabled() {
+		return ErrJoinTokenDisabled
+	} else if !fs.IsValidNodeID(req.NodeID) || req.NodeID == r.nodeID {
+		return fmt.Errorf("can't redeem a join token for node %s", req.NodeID)
+	}
+	if err := r.claimJoinGrant(req); err != nil {
+		return err
+	}
+	if _, err := r.Vouch(ctx, req.NodeID); err != nil {
+		// the token stays usable if the vouch failed
+		r.ss.Update(context.Background(), joinGrantKey(req.ID), func(k *state.Key, v []byte) ([]byte, error) {
+			var grant JoinGrant
+			if err := json.Unmarshal(v, &grant); err != nil {
+				return nil, err
+			}
+			grant.RedeemedBy = ""
+			grant.RedeemedAt = time.Time{}
+			return json.Marshal(&grant)
+		})
+		return err
+	}
+	log.WithField("node", req.NodeID).Infof("vouched for a node that redeemed join token %s", req.ID)
+	return nil
+}
+
+func (r *recordStore) ListJoinTokens(ctx context.Context) ([]*JoinGrant, error) {
+	var grants []*JoinGrant
+	b := state.NewBucket(state.BucketJoinTokens)
+	if _, err := r.ss.RangePeek(ctx, b, func(k *state.Key, v []byte) error {
+		var grant JoinGrant
+		if err := json.Unmarshal(v, &grant); err != nil {
+			log.Debugf("skipping malformed join token: %v", err)
+			return nil
+		}
+		grant.SecretHash = ""
+		grants = append(grants, &grant)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].CreatedAt.Before(grants[j].CreatedAt)
+	})
+	return grants, nil
+}
+
+func (r *recordStore) RevokeJoinToken(id string) error {
+	return r.ss.Update(context.Background(), joinGrantKey(id), func(k *state.Key, v []byte) ([]byte, error) {
+		var grant JoinGrant
+		if len(v) == 0 {
+			return nil, ErrJoinToken
+		} else if err := json.Unmarshal(v, &grant); err != nil {
+			return nil, err
+		}
+		grant.Revoked = true
+		return json.Marshal(&grant)
+	})
+}
+
+// errJoinRefused is returned when the issuer refused the token, retries won't help.
+type errJoinRefused struct {
+	msg string
+}
+
+func (e errJoinRefused) Error() string {
+	return e.msg
+}
+
+// Join redeems the token at its issuer, retrying until it's done, refused or expired.
+// Nodes that were vouched for by the issuer already skip the redemption.
+func (r *recordStore) Join(ctx context.Context, token *JoinToken) error {
+	logger := log.WithField("issuer", token.Issuer)
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		if _, err := r.ReadRecord(ctx, vouchRoot+r.nodeID+"/"+token.Issuer,
+			ReadOptions{NoContent: true}); err == nil {
+			return nil
+		} else if time.Now().After(token.ExpiresAt) {
+			return errors.New("join token expired before it was redeemed")
+		}
+		err := r.joinRequest(ctx, token)
+		if err == nil {
+			logger.Infoln("join token redeemed, the node will be on probation once vouches are counted")
+			return nil
+		} else if _, ok := err.(errJoinRefused); ok {
+			return err
+		}
+		logger.Debugf("failed to redeem join token, retrying: %v", err)
+		t.Reset(joinRetryInterval)
+	}
+}
+
+func (r *recordStore) joinRequest(ctx context.Context, token *JoinToken) error {
+	body, _ := json.Marshal(&JoinRequest{
+		ID:     token.ID,
+		Secret: token.Secret,
+		NodeID: r.nodeID,
+	})
+	u := fmt.Sprintf("http://%s/private/v1/join", token.Issuer)
+	req, _ := http.NewRequest("POST", u, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(ctx)
+	resp, err := r.fs.Client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 200 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("error %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	if resp.StatusCode == 403 || resp.StatusCode == 400 {
+		return errJoinRefused{err.Error()}
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	capn "github.com/glycerine/go-capnproto"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Announces of local writes are kept in the outbound journal of the state store as they
+// are queued, and removed once outbound workers have published them. If the node crashes
+// or is killed in between, the write is stored locally but the network has never heard of
+// it, so announces left in the journal are queued again on start before the store syncs.
+// Peers drop duplicates of announces they have handled already.
+
+// outboundJournalKeep limits how long announces that fail to publish are retried.
+const outboundJournalKeep = 7 * 24 * time.Hour
+
+type journalEntry struct {
+	Namespace string    `json:"namespace,omitempty"`
+	Announce  []byte    `json:"announce"`
+	QueuedAt  time.Time `json:"queued_at"`
+}
+
+type JournalStats struct {
+	// Pending is the number of journaled announces not published yet.
+	Pending  int64  `json:"pending"`
+	Replayed uint64 `json:"replayed_total"`
+	Acked    uint64 `json:"acked_total"`
+	// Failures counts announces that couldn't be journaled, these are published as usual.
+	Failures uint64 `json:"failures_total"`
+}
+
+type outboundJournal struct {
+	ss state.IndexedStore
+
+	pending  int64
+	replayed uint64
+	acked    uint64
+	failures uint64
+}
+
+func newOutboundJournal(ss state.IndexedStore) *outboundJournal {
+	return &outboundJournal{
+		ss: ss,
+	}
+}
+
+// add keeps the announce until it's acknowledged, it's called before the announce is queued.
+func (j *outboundJournal) add(ev *EventAnnounce) {
+	buf := new(bytes.Buffer)
+	if _, err := ev.Announce.Segment.WriteToPacked(buf); err != nil {
+		atomic.AddUint64(&j.failures, 1)
+		log.Warningf("failed to journal announce: %v", err)
+		return
+	}
+	data, err := json.Marshal(&journalEntry{
+		Namespace: ev.Namespace,
+		Announce:  buf.Bytes(),
+		QueuedAt:  time.Now(),
+	})
+	if err != nil {
+		atomic.AddUint64(&j.failures, 1)
+		return
+	}
+	k := state.OutboundJournalKey(ev.Announce.Id())
+	k.TTL = outboundJournalKeep
+	if err := j.ss.Update(context.Background(), k, func(_ *state.Key, _ []byte) ([]byte, error) {
+		return data, nil
+	}); err != nil {
+		atomic.AddUint64(&j.failures, 1)
+		log.WithField("announce", ev.Announce.Id()).Warningf("failed to journal announce: %v", err)
+		return
+	}
+	ev.journaled = true
+	atomic.AddInt64(&j.pending, 1)
+}
+
+// ack removes the published announce from the journal.
+func (j *outboundJournal) ack(ev *EventAnnounce) {
+	if !ev.journaled {
+		return
+	}
+	k := state.OutboundJournalKey(ev.Announce.Id())
+	if err := j.ss.Delete(k); err != nil && err != state.ErrNotFound {
+		log.WithField("announce", ev.Announce.Id()).Warningf("failed to acknowledge journaled announce: %v", err)
+		return
+	}
+	atomic.AddUint64(&j.acked, 1)
+	atomic.AddInt64(&j.pending, -1)
+}
+
+func (j *outboundJournal) Stats() *JournalStats {
+	return &JournalStats{
+		Pending:  atomic.LoadInt64(&j.pending),
+		Replayed: atomic.LoadUint64(&j.replayed),
+		Acked:    atomic.LoadUint64(&j.acked),
+		Failures: atomic.LoadUint64(&j.failures),
+	}
+}
+
+func (r *recordStore) ReplayOutbound(ctx context.Context) (int, error) {
+	var (
+		events    []*EventAnnounce
+		malformed []*state.Key
+	)
+	b := state.NewBucket(state.BucketOutboundJournal)
+	if _, err := r.ss.RangePeek(ctx, b, func(k *state.Key, v []byte) error {
+		var entry journalEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			log.Debugf("skipping malformed journal entry: %v", err)
+			malformed = append(malformed, k)
+			return nil
+		}
+		seg, err := capn.ReadFromPackedStream(bytes.NewReader(entry.Announce), nil)
+		if err != nil {
+			log.Debugf("skipping malformed journal entry: %v", err)
+			malformed = append(malformed, k)
+			return nil
+		}
+		events = append(events, &EventAnnounce{
+			Type:      EventRecordUpdate,
+			Announce:  proto.ReadRootAnnounce(seg),
+			Namespace: entry.Namespace,
+			queuedAt:  entry.QueuedAt,
+			journaled: true,
+		})
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	for _, k := range malformed {
+		if err := r.ss.Delete(k); err != nil && err != state.ErrNotFound {
+			log.Debugf("failed to delete malformed journal entry: %v", err)
+		}
+	}
+	// announces are published in the order of writes
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].queuedAt.Before(events[j].queuedAt)
+	})
+	for _, ev := range events {
+		r.EmitEventAnnounce(ev)
+	}
+	atomic.AddInt64(&r.journal.pending, int64(len(events)))
+	atomic.AddUint64(&r.journal.replayed, uint64(len(events)))
+	return len(events), nil
+}
@@ -0,0 +1,132 @@
+package rs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONPath is a compiled subset of JSONPath expressions that is enough to select fields
+// of JSON records: $.a.b, $.a[0], $.a[*].b, $['a b'] and $.a.* are supported.
+type JSONPath struct {
+	expr  string
+	steps []pathStep
+}
+
+type pathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+var ErrJSONPath = errors.New("malformed JSON path")
+
+func ParseJSONPath(expr string) (*JSONPath, error) {
+	p := &JSONPath{
+		expr: expr,
+	}
+	s := strings.TrimSpace(expr)
+	s = strings.TrimPrefix(s, "$")
+	if len(s) > 0 && s[0] != '.' && s[0] != '[' {
+		// allow a bare a.b.c form
+		s = "." + s
+	}
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			name := s[:end]
+			s = s[end:]
+			if len(name) == 0 {
+				return nil, fmt.Errorf("%v: empty field name in %s", ErrJSONPath, expr)
+			} else if name == "*" {
+				p.steps = append(p.steps, pathStep{wildcard: true})
+				continue
+			}
+			p.steps = append(p.steps, pathStep{key: name})
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%v: unclosed bracket in %s", ErrJSONPath, expr)
+			}
+			sel := strings.TrimSpace(s[1:end])
+			s = s[end+1:]
+			switch {
+			case sel == "*":
+				p.steps = append(p.steps, pathStep{wildcard: true})
+			case len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0]:
+				p.steps = append(p.steps, pathStep{key: sel[1 : len(sel)-1]})
+			default:
+				idx, err := strconv.Atoi(sel)
+				if err != nil {
+					return nil, fmt.Errorf("%v: bad index %q in %s", ErrJSONPath, sel, expr)
+				}
+				p.steps = append(p.steps, pathStep{index: idx, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("%v: unexpected %q in %s", ErrJSONPath, s[0], expr)
+		}
+	}
+	return p, nil
+}
+
+func (p *JSONPath) String() string {
+	return p.expr
+}
+
+// Select returns all values of a decoded JSON document matched by the path.
+func (p *JSONPath) Select(doc interface{}) []interface{} {
+	values := []interface{}{doc}
+	for _, step := range p.steps {
+		var next []interface{}
+		for _, v := range values {
+			next = step.apply(v, next)
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		values = next
+	}
+	return values
+}
+
+func (s pathStep) apply(v interface{}, out []interface{}) []interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		if s.wildcard {
+			// keep a stable order, so selections of two versions can be compared
+			keys := make([]string, 0, len(vv))
+			for k := range vv {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				out = append(out, vv[k])
+			}
+		} else if !s.isIndex {
+			if item, ok := vv[s.key]; ok {
+				out = append(out, item)
+			}
+		}
+	case []interface{}:
+		if s.wildcard {
+			out = append(out, vv...)
+		} else if s.isIndex {
+			idx := s.index
+			if idx < 0 {
+				idx += len(vv)
+			}
+			if idx >= 0 && idx < len(vv) {
+				out = append(out, vv[idx])
+			}
+		}
+	}
+	return out
+}
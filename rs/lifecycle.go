@@ -0,0 +1,150 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	capn "github.com/glycerine/go-capnproto"
+	"github.com/oklog/ulid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Nodes publish signed lifecycle events when they start, become ready, start draining on
+// shutdown and stop, so that peers and monitoring see fleet state changes without polling
+// every node. Events are published to the swarm directly, the outbound queue is not
+// processed before the store syncs and is not drained after it's closed. Events of all nodes,
+// own ones included, are kept in the lifecycle bucket and expire after the lifecycle TTL.
+
+type LifecycleState string
+
+const (
+	LifecycleStarting LifecycleState = "starting"
+	LifecycleReady    LifecycleState = "ready"
+	LifecycleDraining LifecycleState = "draining"
+	LifecycleStopped  LifecycleState = "stopped"
+)
+
+const defaultLifecycleTTL = 7 * 24 * time.Hour
+
+// LifecycleEvent is a state change published by a node.
+type LifecycleEvent struct {
+	ID        string         `json:"id"`
+	NodeID    string         `json:"node_id"`
+	SessionID string         `json:"session_id"`
+	State     LifecycleState `json:"state"`
+	Version   string         `json:"version"`
+	Uptime    int64          `json:"uptime_seconds"`
+	Time      time.Time      `json:"time"`
+}
+
+func (r *recordStore) PublishLifecycle(session string, state LifecycleState, version string) {
+	ann := r.newLifecycleAnnounce(session, state, version)
+	if e, err := proto.UnpackEnvelopeNodeLifecycle(ann.Envelope()); err == nil {
+		r.recordLifecycle(r.nodeID, e)
+	}
+	if err := r.emitEvent(&EventAnnounce{
+		Type:     EventLifecycle,
+		Announce: *ann,
+	}, time.Minute); err != nil {
+		log.Warningf("failed to publish lifecycle event: %v", err)
+		return
+	}
+	log.WithField("state", state).Debugln("published lifecycle event")
+}
+
+func (r *recordStore) newLifecycleAnnounce(session string, state LifecycleState, version string) *proto.Announce {
+	e := proto.AutoNewEnvelopeNodeLifecycle(capn.NewBuffer(nil))
+	e.SetId(proto.NewID())
+	e.SetSession(session)
+	e.SetState(string(state))
+	e.SetVersion(version)
+	e.SetUptimeUnix(int64(time.Since(r.startedAt).Seconds()))
+	buf := new(bytes.Buffer)
+	if _, err := e.Segment.WriteToPacked(buf); err != nil {
+		panic(fmt.Sprintf("failed to pack data: %v", err))
+	}
+	sig, err := r.fs.SignData(r.nodeID, buf.Bytes())
+	if err != nil {
+		panic(fmt.Sprintf("failed to use FS signer: %v", err))
+	}
+	a := proto.AutoNewAnnounce(capn.NewBuffer(nil))
+	a.SetId(proto.NewID())
+	a.SetType(proto.ANNOUNCETYPE_NODELIFECYCLE)
+	a.SetEnvelope(buf.Bytes())
+	a.SetSignature(hex.EncodeToString(sig))
+	a.SetTimestamp(time.Now().UnixNano())
+	a.SetNodeID(r.nodeID)
+	a.SetProtocolVersion(ProtocolVersion)
+	return &a
+}
+
+// recordLifecycle keeps the event, its time is taken from the signed ID.
+func (r *recordStore) recordLifecycle(nodeID string, e proto.EnvelopeNodeLifecycle) {
+	u, err := ulid.Parse(e.Id())
+	if err != nil {
+		log.Warningf("skipping lifecycle event with malformed ID: %v", err)
+		return
+	}
+	switch s := LifecycleState(e.State()); s {
+	case LifecycleStarting, LifecycleReady, LifecycleDraining, LifecycleStopped:
+	default:
+		log.Debugf("skipping lifecycle event with unknown state: %q", s)
+		return
+	}
+	event := &LifecycleEvent{
+		ID:        e.Id(),
+		NodeID:    nodeID,
+		SessionID: e.Session(),
+		State:     LifecycleState(e.State()),
+		Version:   e.Version(),
+		Uptime:    e.UptimeUnix(),
+		Time:      time.Unix(0, int64(u.Time())*int64(time.Millisecond)).UTC(),
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Warningf("failed to encode lifecycle event: %v", err)
+		return
+	}
+	k := state.LifecycleKey(e.IdBytes())
+	k.TTL = r.opts.LifecycleTTL
+	if err := r.ss.Update(context.Background(), k, func(k *state.Key, v []byte) ([]byte, error) {
+		if v != nil {
+			return nil, state.ErrNoUpdate
+		}
+		return data, nil
+	}); err != nil {
+		log.Warningf("failed to write lifecycle event: %v", err)
+	}
+}
+
+func (r *recordStore) LifecycleEvents(ctx context.Context, nodeID string, since time.Time) ([]*LifecycleEvent, error) {
+	var events []*LifecycleEvent
+	b := state.NewBucket(state.BucketLifecycle)
+	if _, err := r.ss.RangePeek(ctx, b, func(k *state.Key, v []byte) error {
+		var e LifecycleEvent
+		if err := json.Unmarshal(v, &e); err != nil {
+			log.Debugf("skipping malformed lifecycle event: %v", err)
+			return nil
+		} else if len(nodeID) > 0 && e.NodeID != nodeID {
+			return nil
+		} else if e.Time.Before(since) {
+			return nil
+		}
+		events = append(events, &e)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+	return events, nil
+}
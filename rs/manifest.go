@@ -0,0 +1,325 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Manifests allow external systems to verify their mirrors against the network:
+// for each namespace a signed index lists signed pages of path → CID → sha256 → size.
+const (
+	manifestRoot     = "/manifests"
+	manifestPageSize = 1000
+	manifestRootName = "_root"
+)
+
+type ManifestEntry struct {
+	Path    string `json:"path"`
+	ID      string `json:"id"`
+	Version string `json:"cid"`
+	SHA256  string `json:"sha256"`
+	Size    int64  `json:"size"`
+}
+
+type ManifestPage struct {
+	Prefix      string           `json:"prefix"`
+	Page        int              `json:"page"`
+	NodeID      string           `json:"node_id"`
+	GeneratedAt time.Time        `json:"generated_at"`
+	Entries     []*ManifestEntry `json:"entries"`
+	Signature   string           `json:"signature,omitempty"`
+}
+
+type ManifestIndex struct {
+	Prefix      string    `json:"prefix"`
+	NodeID      string    `json:"node_id"`
+	GeneratedAt time.Time `json:"generated_at"`
+	// Digest is sha256 of all entries, it changes only when the content changes.
+	Digest string `json:"digest"`
+	// MerkleRoot is the root of the Merkle tree of entries, see RecordProof.
+	MerkleRoot string          `json:"merkle_root,omitempty"`
+	Pages      []*ManifestLink `json:"pages"`
+	Signature  string          `json:"signature,omitempty"`
+}
+
+type ManifestLink struct {
+	Path string `json:"path"`
+	// Version is the CID of the page as of the index, pages are updated in place.
+	Version string `json:"cid,omitempty"`
+	SHA256  string `json:"sha256"`
+	Count   int    `json:"count"`
+}
+
+// PublishManifests periodically publishes checksum manifests for every namespace.
+func (r *recordStore) PublishManifests(ctx context.Context, dur time.Duration) {
+	t := time.NewTimer(dur)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if r.opts.ReadOnly || !isPublishAllowed(r.nodeID) || r.fence.Err() != nil {
+				t.Reset(dur)
+				continue
+			}
+			entries, err := r.collectManifestEntries(ctx)
+			if err != nil {
+				log.Warningf("failed to collect manifest entries: %v", err)
+				t.Reset(dur)
+				continue
+			}
+			for ns, list := range entries {
+				if err := r.publishManifest(ctx, ns, list); err != nil {
+					log.WithField("namespace", ns).Warningf("failed to publish manifest: %v", err)
+				}
+			}
+			t.Reset(dur)
+		}
+	}
+}
+
+func (r *recordStore) collectManifestEntries(ctx context.Context) (map[string][]*ManifestEntry, error) {
+	type recordRef struct {
+		id, path, version string
+	}
+	var refs []recordRef
+	if err := r.WalkRecords(ctx, "", func(path string, rec *Record) error {
+		if strings.HasPrefix(path, manifestRoot+"/") {
+			return nil
+		}
+		refs = append(refs, recordRef{
+			id:      rec.Id(),
+			path:    path,
+			version: rec.Current().Version(),
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	entries := make(map[string][]*ManifestEntry)
+	for _, ref := range refs {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		sum, size, err := r.objectChecksum(ctx, ref.id, ref.version)
+		if err != nil {
+			log.WithField("path", ref.path).Debugf("skipping manifest entry: %v", err)
+			continue
+		} else if len(sum) == 0 {
+			// deleted
+			continue
+		}
+		ns := NamespaceOf(ref.path)
+		entries[ns] = append(entries[ns], &ManifestEntry{
+			Path:    ref.path,
+			ID:      ref.id,
+			Version: ref.version,
+			SHA256:  sum,
+			Size:    size,
+		})
+	}
+	for _, list := range entries {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Path < list[j].Path
+		})
+	}
+	return entries, nil
+}
+
+// objectChecksum returns sha256 of the object content, results are cached per record
+// version in the state store. An empty checksum is returned for deleted objects.
+func (r *recordStore) objectChecksum(ctx context.Context, id, version string) (string, int64, error) {
+	k := state.ChecksumsKey(id)
+	var sum string
+	var size int64
+	var cached bool
+	if err := r.ss.View(ctx, k, func(k *state.Key, v []byte) error {
+		parts := strings.Split(string(v), "\n")
+		if len(parts) == 3 && parts[0] == version {
+			sum = parts[1]
+			size, _ = strconv.ParseInt(parts[2], 10, 64)
+			cached = true
+		}
+		return nil
+	}); err != nil && err != state.ErrNotFound {
+		return "", 0, err
+	}
+	if cached {
+		return sum, size, nil
+	}
+	obj, err := r.fs.GetObject(ctx, fs.ObjectRef{
+		Version: version,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	if !obj.Meta.IsDeleted() && obj.Body != nil {
+		h := sha256.New()
+		size, err = io.Copy(h, obj.Body)
+		obj.Body.Close()
+		if err != nil {
+			return "", 0, err
+		}
+		sum = hex.EncodeToString(h.Sum(nil))
+	}
+	if err := r.ss.Update(ctx, k, func(k *state.Key, v []byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s\n%s\n%d", version, sum, size)), nil
+	}); err != nil {
+		log.Warningf("failed to cache object checksum: %v", err)
+	}
+	return sum, size, nil
+}
+
+func (r *recordStore) RecordChecksum(ctx context.Context, path, version string) (*ManifestEntry, error) {
+	rec, err := r.ReadRecord(ctx, path, ReadOptions{
+		Version:   version,
+		NoContent: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	meta := rec.Object.Meta()
+	sum, size, err := r.objectChecksum(ctx, meta.Id(), meta.Version())
+	if err != nil {
+		return nil, err
+	} else if len(sum) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return &ManifestEntry{
+		Path:    meta.Path(),
+		ID:      meta.Id(),
+		Version: meta.Version(),
+		SHA256:  sum,
+		Size:    size,
+	}, nil
+}
+
+func manifestDir(ns string) string {
+	if len(ns) == 0 {
+		ns = manifestRootName
+	}
+	return manifestRoot + "/" + ns
+}
+
+func (r *recordStore) publishManifest(ctx context.Context, ns string, entries []*ManifestEntry) error {
+	dir := manifestDir(ns)
+	digest := sha256.New()
+	for _, e := range entries {
+		fmt.Fprintf(digest, "%s\t%s\t%s\t%d\n", e.Path, e.Version, e.SHA256, e.Size)
+	}
+	index := &ManifestIndex{
+		Prefix:      "/" + ns,
+		NodeID:      r.nodeID,
+		GeneratedAt: time.Now().UTC(),
+		Digest:      hex.EncodeToString(digest.Sum(nil)),
+		MerkleRoot:  hex.EncodeToString(merkleRoot(manifestLeaves(entries))),
+	}
+	if prev, err := r.readManifestIndex(ctx, dir+"/index.json"); err == nil &&
+		prev.Digest == index.Digest && prev.MerkleRoot == index.MerkleRoot {
+		// nothing has changed since the last publish
+		return nil
+	}
+	for offset, page := 0, 1; offset < len(entries); offset, page = offset+manifestPageSize, page+1 {
+		end := offset + manifestPageSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		p := &ManifestPage{
+			Prefix:      index.Prefix,
+			Page:        page,
+			NodeID:      r.nodeID,
+			GeneratedAt: index.GeneratedAt,
+			Entries:     entries[offset:end],
+		}
+		if err := r.signManifest(p, &p.Signature); err != nil {
+			return err
+		}
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		path := fmt.Sprintf("%s/page-%04d.json", dir, page)
+		version, err := r.putManifestRecord(ctx, path, data)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		index.Pages = append(index.Pages, &ManifestLink{
+			Path:    path,
+			Version: version,
+			SHA256:  hex.EncodeToString(sum[:]),
+			Count:   end - offset,
+		})
+	}
+	if err := r.signManifest(index, &index.Signature); err != nil {
+		return err
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	_, err = r.putManifestRecord(ctx, dir+"/index.json", data)
+	return err
+}
+
+// signManifest signs JSON encoding of v with the signature field left empty.
+func (r *recordStore) signManifest(v interface{}, signature *string) error {
+	*signature = ""
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sig, err := r.fs.SignData(r.nodeID, data)
+	if err != nil {
+		err = fmt.Errorf("failed to sign manifest: %v", err)
+		return err
+	}
+	*signature = hex.EncodeToString(sig)
+	return nil
+}
+
+func (r *recordStore) readManifestIndex(ctx context.Context, path string, opts ...ReadOptions) (*ManifestIndex, error) {
+	rec, err := r.ReadRecord(ctx, path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer rec.Body.Close()
+	var index *ManifestIndex
+	if err := json.NewDecoder(rec.Body).Decode(&index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// putManifestRecord writes the record and returns its new version.
+func (r *recordStore) putManifestRecord(ctx context.Context, path string, data []byte) (string, error) {
+	rec, err := r.CreateRecord(ctx, path, ioutil.NopCloser(bytes.NewReader(data)), CreateOptions{
+		Size: int64(len(data)),
+	})
+	if err == ErrRecordExists {
+		rec, err = r.UpdateRecord(ctx, path, ioutil.NopCloser(bytes.NewReader(data)), UpdateOptions{
+			Size: int64(len(data)),
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+	return rec.Current().Version(), nil
+}
@@ -0,0 +1,13 @@
+package rs
+
+import "github.com/AtlantPlatform/atlant-go/state"
+
+// Migrations returns migrations of the state layout of record store buckets, see state.Migrate.
+// New migrations are appended with the next version, released ones are never changed.
+func Migrations() []*state.Migration {
+	return []*state.Migration{{
+		Version: 1,
+		Name:    "namespace_index",
+		Apply:   migrateNamespaceIndex,
+	}}
+}
@@ -0,0 +1,312 @@
+package rs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// A mirror policy makes the node a partial replica: it keeps records of the whole network
+// it follows, but fetches and pins content of records under the given path prefixes and
+// namespaces only. Content of other records is fetched from peers on reads. The policy is
+// kept in the state store and may be changed at runtime, then a mirror pass pins current
+// versions of records it selects and unpins versions of records it no longer selects, except
+// those written by this node, so that peers may still fetch them. Passes also run after each
+// sync, which imports records without pinning them. A node without a policy pins everything
+// announced, as a full replica.
+
+var ErrMirrorRunning = errors.New("mirror pass is running already")
+
+func mirrorPolicyKey() *state.Key {
+	return state.SyncStateKey("mirror")
+}
+
+// MirrorPolicy selects records whose content the node pins, it's empty for a full replica.
+type MirrorPolicy struct {
+	// Prefixes are path prefixes of records, e.g. /docs/de/.
+	Prefixes []string `json:"prefixes,omitempty"`
+	// Namespaces select all records of the namespaces.
+	Namespaces []string  `json:"namespaces,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at,omitempty"`
+}
+
+// Enabled tells whether the policy selects some records only.
+func (p *MirrorPolicy) Enabled() bool {
+	return p != nil && (len(p.Prefixes) > 0 || len(p.Namespaces) > 0)
+}
+
+// Matches tells whether content of the record at path is pinned.
+func (p *MirrorPolicy) Matches(path string) bool {
+	if !p.Enabled() {
+		return true
+	}
+	for _, prefix := range p.Prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	ns := NamespaceOf(path)
+	for _, v := range p.Namespaces {
+		if v == ns {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *MirrorPolicy) validate() error {
+	for _, prefix := range p.Prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("mirror prefix must start with /: %s", prefix)
+		}
+	}
+	for _, ns := range p.Namespaces {
+		if len(ns) == 0 || strings.Contains(ns, "/") {
+			return fmt.Errorf("bad mirror namespace: %q", ns)
+		}
+	}
+	return nil
+}
+
+// MirrorStatus reports the mirror policy and passes that apply it.
+type MirrorStatus struct {
+	Policy     *MirrorPolicy `json:"policy"`
+	Running    bool          `json:"running"`
+	LastPassAt time.Time     `json:"last_pass_at,omitempty"`
+	Pinned     uint64        `json:"pinned_total"`
+	Unpinned   uint64        `json:"unpinned_total"`
+	// Skipped counts pins of announced records skipped by the policy.
+	Skipped   uint64 `json:"skipped_total"`
+	Failures  uint64 `json:"failures_total"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+type mirrorState struct {
+	running  int32
+	pinned   uint64
+	unpinned uint64
+	skipped  uint64
+	failures uint64
+	kick     chan struct{}
+
+	mux      *sync.RWMutex
+	policy   *MirrorPolicy
+	lastPass time.Time
+	lastErr  string
+}
+
+func newMirrorState(policy *MirrorPolicy) *mirrorState {
+	return &mirrorState{
+		kick:   make(chan struct{}, 1),
+		mux:    new(sync.RWMutex),
+		policy: policy,
+	}
+}
+
+func (m *mirrorState) get() *MirrorPolicy {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.policy
+}
+
+func (m *mirrorState) schedule() {
+	select {
+	case m.kick <- struct{}{}:
+	default:
+	}
+}
+
+// mirrorsContent tells whether content of the record at path is pinned, skips are counted.
+func (r *recordStore) mirrorsContent(path string) bool {
+	if r.mirror.get().Matches(path) {
+		return true
+	}
+	atomic.AddUint64(&r.mirror.skipped, 1)
+	return false
+}
+
+// loadMirrorPolicy reads the policy set at runtime, the one of options is stored
+// if there's none yet.
+func (r *recordStore) loadMirrorPolicy() {
+	var policy *MirrorPolicy
+	if err := r.ss.View(context.Background(), mirrorPolicyKey(), func(_ *state.Key, v []byte) error {
+		var p MirrorPolicy
+		if err := json.Unmarshal(v, &p); err != nil {
+			return err
+		}
+		policy = &p
+		return nil
+	}); err == state.ErrNotFound {
+		if policy = r.mirror.get(); policy.Enabled() {
+			if err := r.saveMirrorPolicy(policy); err != nil {
+				log.Warningf("failed to save mirror policy: %v", err)
+			}
+		}
+	} else if err != nil {
+		log.Warningf("failed to load mirror policy, using the configured one: %v", err)
+		return
+	}
+	r.mirror.mux.Lock()
+	r.mirror.policy = policy
+	r.mirror.mux.Unlock()
+}
+
+func (r *recordStore) saveMirrorPolicy(policy *MirrorPolicy) error {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return r.ss.Update(context.Background(), mirrorPolicyKey(), func(_ *state.Key, _ []byte) ([]byte, error) {
+		return data, nil
+	})
+}
+
+func (r *recordStore) MirrorPolicy() *MirrorPolicy {
+	return r.mirror.get()
+}
+
+func (r *recordStore) SetMirrorPolicy(ctx context.Context, policy *MirrorPolicy) error {
+	if policy == nil {
+		policy = &MirrorPolicy{}
+	}
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	policy.UpdatedAt = time.Now().UTC()
+	if err := r.saveMirrorPolicy(policy); err != nil {
+		return err
+	}
+	r.mirror.mux.Lock()
+	r.mirror.policy = policy
+	r.mirror.mux.Unlock()
+	log.WithFields(log.Fields{
+		"prefixes":   policy.Prefixes,
+		"namespaces": policy.Namespaces,
+	}).Infoln("mirror policy changed")
+	r.mirror.schedule()
+	return nil
+}
+
+func (r *recordStore) MirrorStatus() *MirrorStatus {
+	r.mirror.mux.RLock()
+	defer r.mirror.mux.RUnlock()
+	return &MirrorStatus{
+		Policy:     r.mirror.policy,
+		Running:    atomic.LoadInt32(&r.mirror.running) == 1,
+		LastPassAt: r.mirror.lastPass,
+		Pinned:     atomic.LoadUint64(&r.mirror.pinned),
+		Unpinned:   atomic.LoadUint64(&r.mirror.unpinned),
+		Skipped:    atomic.LoadUint64(&r.mirror.skipped),
+		Failures:   atomic.LoadUint64(&r.mirror.failures),
+		LastError:  r.mirror.lastErr,
+	}
+}
+
+// RunMirror applies the mirror policy when it changes, after syncs and every interval
+// if it's positive.
+func (r *recordStore) RunMirror(ctx context.Context, interval time.Duration) {
+	var tickC <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tickC = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tickC:
+		case <-r.mirror.kick:
+		}
+		if !r.IsReady() {
+			continue
+		}
+		if err := r.ApplyMirror(ctx); err != nil && err != ErrMirrorRunning {
+			log.Warningf("mirror pass failed: %v", err)
+		}
+	}
+}
+
+// ApplyMirror runs a mirror pass now, ErrMirrorRunning is returned if one is in progress.
+func (r *recordStore) ApplyMirror(ctx context.Context) (err error) {
+	if !atomic.CompareAndSwapInt32(&r.mirror.running, 0, 1) {
+		return ErrMirrorRunning
+	}
+	defer atomic.StoreInt32(&r.mirror.running, 0)
+	defer func() {
+		r.mirror.mux.Lock()
+		r.mirror.lastPass = time.Now().UTC()
+		r.mirror.lastErr = ""
+		if err != nil {
+			r.mirror.lastErr = err.Error()
+		}
+		r.mirror.mux.Unlock()
+	}()
+	policy := r.mirror.get()
+	if !policy.Enabled() {
+		// a full replica pins what's announced, synced records are fetched on reads
+		return nil
+	}
+	pinned := make(map[string]struct{})
+	for _, version := range r.fs.PinnedObjects() {
+		pinned[version] = struct{}{}
+	}
+	var pins, unpins []string
+	b := state.NewBucket(state.BucketRecords)
+	if err := state.Stream(ctx, r.ss, b, 0, proto.RecordPeek(func(_ *state.Key, v *proto.Record) error {
+		if policy.Matches(v.Path()) {
+			if _, ok := pinned[v.Current().Version()]; !ok && !r.cold.isOffloaded(v.Current().Version()) {
+				pins = append(pins, v.Current().Version())
+			}
+			return nil
+		}
+		for _, ver := range append([]proto.RecordVersion{v.Current()}, v.Previous().ToArray()...) {
+			if _, ok := pinned[ver.Version()]; !ok {
+				continue
+			} else if ver.Announce().NodeID() == r.nodeID {
+				// peers may have nothing but the copy of this node
+				continue
+			}
+			unpins = append(unpins, ver.Version())
+		}
+		return nil
+	})); err != nil {
+		return err
+	}
+	for i, version := range pins {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if !r.budget.allowPin() {
+			log.Warningf("mirror pass stopped over the disk budget with %d pins left", len(pins)-i)
+			break
+		}
+		if err := r.fs.PinObject(fs.ObjectRef{
+			Version: version,
+		}); err != nil {
+			atomic.AddUint64(&r.mirror.failures, 1)
+			log.WithField("version", version).Debugf("failed to pin mirrored object: %v", err)
+			continue
+		}
+		atomic.AddUint64(&r.mirror.pinned, 1)
+	}
+	r.unpinVersions(unpins)
+	atomic.AddUint64(&r.mirror.unpinned, uint64(len(unpins)))
+	if len(pins) > 0 || len(unpins) > 0 {
+		log.WithFields(log.Fields{
+			"pinned":   len(pins),
+			"unpinned": len(unpins),
+		}).Infoln("mirror policy applied")
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package rs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+)
+
+// NamespaceOf returns the namespace of a record path, which is its first path segment.
+// Records stored at the root level have an empty namespace.
+func NamespaceOf(path string) string {
+	path = strings.TrimPrefix(path, "/")
+	idx := strings.IndexByte(path, '/')
+	if idx < 0 {
+		return ""
+	}
+	return path[:idx]
+}
+
+// ErrNamespaceNotAllowed is returned for writes of a node that has write permissions
+// for some namespaces only, outside of them.
+var ErrNamespaceNotAllowed = errors.New("node may write to records of its namespaces only")
+
+// isTenant tells whether the node has write permissions limited to namespaces,
+// see authcenter.NamespaceWritePermission.
+func isTenant(nodeID string) bool {
+	for _, p := range authcenter.AllPermissions(nodeID) {
+		if _, ok := p.Namespace(); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// followsNamespace reports whether the node keeps records of the namespace,
+// nodes with no namespaces configured follow the whole network.
+func (r *recordStore) followsNamespace(ns string) bool {
+	// ownership of followed records depends on transfers
+	if len(r.opts.Namespaces) == 0 || ns == transferNamespace {
+		return true
+	}
+	for _, v := range r.opts.Namespaces {
+		if v == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// Namespace settings are stored as records at /namespaces/<name>.json, so they are
+// replicated like any other record and apply on every node that follows the namespace.
+const namespaceConfigRoot = "/namespaces/"
+
+type NamespaceConfig struct {
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+	RateLimit *RateLimitPolicy `json:"rate_limit,omitempty"`
+	SLO       *SLOPolicy       `json:"slo,omitempty"`
+	// WORM makes records of the namespace immutable after the first write, for good.
+	WORM    bool          `json:"worm,omitempty"`
+	Storage *StorageClass `json:"storage,omitempty"`
+}
+
+func namespaceConfigPath(ns string) string {
+	return namespaceConfigRoot + ns + ".json"
+}
+
+// namespaceOfConfig returns the namespace of the settings at path, ok is false if it's
+// not a path of namespace settings.
+func namespaceOfConfig(path string) (ns string, ok bool) {
+	if !strings.HasPrefix(path, namespaceConfigRoot) || !strings.HasSuffix(path, ".json") {
+		return "", false
+	}
+	ns = strings.TrimSuffix(strings.TrimPrefix(path, namespaceConfigRoot), ".json")
+	if len(ns) == 0 || strings.Contains(ns, "/") {
+		return "", false
+	}
+	return ns, true
+}
+
+// namespaceConfigs reads settings of all namespaces that have them, malformed settings are skipped.
+func (r *recordStore) namespaceConfigs(ctx context.Context) (map[string]*NamespaceConfig, error) {
+	var paths []string
+	if err := r.WalkRecords(ctx, "", func(path string, rec *Record) error {
+		if _, ok := namespaceOfConfig(path); ok {
+			paths = append(paths, path)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	configs := make(map[string]*NamespaceConfig, len(paths))
+	for _, path := range paths {
+		ns, _ := namespaceOfConfig(path)
+		cfg, err := r.readNamespaceConfig(ctx, path)
+		if err == errMalformedNamespaceConfig {
+			log.WithField("namespace", ns).Warningf("malformed namespace config")
+			continue
+		} else if err != nil {
+			log.WithField("namespace", ns).Debugf("failed to read namespace config: %v", err)
+			continue
+		}
+		configs[ns] = cfg
+	}
+	return configs, nil
+}
+
+// checkNamespaceConfig applies the WORM flag and the storage class of changed namespace settings.
+func (r *recordStore) checkNamespaceConfig(path string) {
+	ns, ok := namespaceOfConfig(path)
+	if !ok {
+		return
+	}
+	cfg, err := r.readNamespaceConfig(context.Background(), path)
+	if err != nil {
+		log.WithField("namespace", ns).Debugf("failed to read namespace config: %v", err)
+		return
+	}
+	if cfg.WORM {
+		r.markWORM(ns)
+	}
+	r.setStorageClass(ns, cfg.Storage)
+}
+
+var errMalformedNamespaceConfig = errors.New("malformed namespace config")
+
+func (r *recordStore) readNamespaceConfig(ctx context.Context, path string) (*NamespaceConfig, error) {
+	rec, err := r.ReadRecord(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rec.Body.Close()
+	var cfg *NamespaceConfig
+	if err := json.NewDecoder(io.LimitReader(rec.Body, maxNamespaceConfigSize)).Decode(&cfg); err != nil || cfg == nil {
+		return nil, errMalformedNamespaceConfig
+	}
+	return cfg, nil
+}
+
+const maxNamespaceConfigSize = 64 * 1024
@@ -0,0 +1,178 @@
+package rs
+
+import (
+	"context"
+	"crypto/sha256"
+
+	"github.com/oklog/ulid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Records of every namespace are indexed under a key prefix of their own, a digest of the
+// namespace name followed by the binary record ID, so records of a namespace are listed
+// without a walk over the whole record space. Keys are kept in ID order, that is the order
+// of WalkRecordsPage, so cursors of both walks are record IDs. The index of records stored
+// before it existed is built by the state migration of version 1.
+
+const (
+	namespacePrefixSize = 8
+	namespacePageSize   = 1000
+)
+
+func namespacePrefix(ns string) []byte {
+	sum := sha256.Sum256([]byte(ns))
+	return sum[:namespacePrefixSize]
+}
+
+// namespaceKey returns the index key of the record, ok is false for IDs that aren't ULIDs.
+func namespaceKey(ns, id string) (key []byte, ok bool) {
+	u, err := ulid.Parse(id)
+	if err != nil {
+		return nil, false
+	}
+	return append(namespacePrefix(ns), u[:]...), true
+}
+
+// setNamespaceKey adds the record to the index, changed is false if it's there already.
+func setNamespaceKey(ss state.IndexedStore, id, path string, dryRun bool) (changed bool, err error) {
+	key, ok := namespaceKey(NamespaceOf(path), id)
+	if !ok {
+		return false, nil
+	}
+	k := state.NamespaceRecordsKey(key)
+	err = ss.Update(context.Background(), k, func(k *state.Key, v []byte) ([]byte, error) {
+		if v != nil {
+			return nil, state.ErrNoUpdate
+		}
+		changed = true
+		if dryRun {
+			return nil, state.ErrNoUpdate
+		}
+		return []byte(id), nil
+	})
+	if err == state.ErrNoUpdate {
+		err = nil
+	}
+	return changed, err
+}
+
+func (r *recordStore) indexNamespace(id, path string) {
+	if _, err := setNamespaceKey(r.ss, id, path, false); err != nil {
+		log.WithField("id", id).Warningf("failed to index record of namespace: %v", err)
+	}
+}
+
+func (r *recordStore) unindexNamespace(id, path string) {
+	key, ok := namespaceKey(NamespaceOf(path), id)
+	if !ok {
+		return
+	}
+	if err := r.ss.Delete(state.NamespaceRecordsKey(key)); err != nil && err != state.ErrNotFound {
+		log.WithField("id", id).Warningf("failed to drop record from namespace index: %v", err)
+	}
+}
+
+// migrateNamespaceIndex indexes records stored before the namespace index existed.
+func migrateNamespaceIndex(ss state.IndexedStore, dryRun bool) (int, error) {
+	type recordPath struct {
+		id, path string
+	}
+	var changed int
+	opts := &state.RangeOptions{
+		Limit: namespacePageSize,
+	}
+	for opts != nil {
+		var page []recordPath
+		next, err := ss.RangePeek(context.Background(), state.NewBucket(state.BucketRecords, opts),
+			proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+				page = append(page, recordPath{v.Id(), v.Path()})
+				return nil
+			}))
+		if err != nil {
+			return changed, err
+		}
+		for _, rp := range page {
+			ok, err := setNamespaceKey(ss, rp.id, rp.path, dryRun)
+			if err != nil {
+				return changed, err
+			} else if ok {
+				changed++
+			}
+		}
+		opts = next
+	}
+	return changed, nil
+}
+
+// WalkNamespacePage walks records of the namespace like WalkRecordsPage does.
+func (r *recordStore) WalkNamespacePage(ctx context.Context, ns, cursor string,
+	limit int, fn RecordWalkFunc) (string, error) {
+	defer r.inboundWork()
+	opts := &state.RangeOptions{
+		Prefix: namespacePrefix(ns),
+		Limit:  namespacePageSize,
+	}
+	if len(cursor) > 0 {
+		offset, ok := namespaceKey(ns, cursor)
+		if !ok {
+			return "", ErrRecordNotFound
+		}
+		opts.Offset = offset
+	}
+	var visited int
+	for opts != nil {
+		var ids []string
+		next, err := r.ss.RangePeek(ctx, state.NewBucket(state.BucketNamespaceRecords, opts), func(k *state.Key, v []byte) error {
+			ids = append(ids, string(v))
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		// the cursor is the record that follows the last one visited
+		cursorAt := func(i int) string {
+			if i < len(ids) {
+				return ids[i]
+			} else if next != nil && len(next.Offset) >= namespacePrefixSize+16 {
+				var u ulid.ULID
+				copy(u[:], next.Offset[namespacePrefixSize:])
+				return u.String()
+			}
+			return ""
+		}
+		for i, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return "", err
+			} else if limit > 0 && visited >= limit {
+				return cursorAt(i), nil
+			}
+			var rec *Record
+			err := r.ss.View(ctx, state.RecordsKey([]byte(id)), proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+				rec = &Record{
+					Record: *v,
+				}
+				return nil
+			}))
+			if err == state.ErrNotFound {
+				// the record has been purged since
+				if key, ok := namespaceKey(ns, id); ok {
+					r.ss.Delete(state.NamespaceRecordsKey(key))
+				}
+				continue
+			} else if err != nil {
+				return "", err
+			}
+			visited++
+			if err := fn(rec.Path(), rec); err == ErrWalkStop {
+				return cursorAt(i + 1), nil
+			} else if err != nil {
+				return "", err
+			}
+		}
+		opts = next
+	}
+	return "", nil
+}
@@ -0,0 +1,224 @@
+package rs
+
+import (
+	"time"
+
+	"github.com/AtlantPlatform/atlant-go/cache"
+)
+
+type storeOptions struct {
+	// Namespaces limits record announcements this node subscribes to,
+	// an empty list means the node follows the whole network.
+	Namespaces []string
+	// DedupWindow is how long IDs of handled announces are remembered.
+	DedupWindow time.Duration
+	// NTPServer is used to check the local clock, peers are used if it's empty.
+	NTPServer         string
+	ClockSkewWarn     time.Duration
+	ClockSkewMax      time.Duration
+	ClockRefuseWrites bool
+	// GC limits content kept by the node.
+	GC *GCPolicy
+	// Encryption seals content of namespaces before it enters the file store.
+	Encryption *EncryptionPolicy
+	// Conflicts picks the current version when writes of a record conflict.
+	Conflicts *ConflictPolicy
+	// DiskBudget refuses writes and pins while the node takes too much disk space.
+	DiskBudget *DiskBudget
+	// ReadOnly refuses local writes and beat commits regardless of permissions.
+	ReadOnly bool
+	// Vouch puts nodes vouched for by permission holders on probation.
+	Vouch *VouchPolicy
+	// Search enables the search index, nil disables it.
+	Search *SearchPolicy
+	// LifecycleTTL is how long lifecycle events of nodes are kept.
+	LifecycleTTL time.Duration
+	// FullResync fetches all records on sync regardless of the sync checkpoint.
+	FullResync bool
+	// SyncMargin moves the sync checkpoint back, see SyncCheckpoint.
+	SyncMargin time.Duration
+	// WebhookAttempts is how many times a webhook delivery is tried before it's dead-lettered.
+	WebhookAttempts int
+	// WebhookDeadTTL is how long dead letters are kept.
+	WebhookDeadTTL time.Duration
+	// InboundWorkers is the number of workers that handle inbound announces.
+	InboundWorkers int
+	// Cache keeps results of record lookups, nil disables caching.
+	Cache *cache.Cache
+	// Mirror is the mirror policy used until one is set at runtime, nil makes a full replica.
+	Mirror *MirrorPolicy
+	// PopularitySample is the share of client reads counted in popularity of records.
+	PopularitySample float64
+	// Beats sets how often beats are sent, nil uses DefaultBeatPolicy.
+	Beats *BeatPolicy
+	// ColdTier offloads versions not read for a while to a cold store, nil disables it.
+	ColdTier *ColdTierPolicy
+	// DryRun simulates writes of contract operations, e.g. beat reports, instead of committing them.
+	DryRun bool
+}
+
+type storeOpt func(o *storeOptions)
+
+func defaultStoreOptions() *storeOptions {
+	return &storeOptions{
+		DedupWindow:   defaultDedupWindow,
+		ClockSkewWarn: defaultClockSkewWarn,
+		ClockSkewMax:  defaultClockSkewMax,
+		LifecycleTTL:  defaultLifecycleTTL,
+		SyncMargin:    defaultSyncMargin,
+
+		WebhookAttempts: defaultWebhookAttempts,
+		WebhookDeadTTL:  defaultWebhookDeadTTL,
+		InboundWorkers:  defaultInboundWorkers,
+	}
+}
+
+func NamespacesOpt(namespaces []string) storeOpt {
+	return func(o *storeOptions) {
+		o.Namespaces = namespaces
+	}
+}
+
+// DedupWindowOpt sets how long duplicate gossip arrivals of an announce are dropped.
+func DedupWindowOpt(window time.Duration) storeOpt {
+	return func(o *storeOptions) {
+		o.DedupWindow = window
+	}
+}
+
+// ClockOpt configures the check of the local clock. Skew above warn is logged, above max
+// is reported as severe and writes are refused if refuseWrites is set.
+func ClockOpt(ntpServer string, warn, max time.Duration, refuseWrites bool) storeOpt {
+	return func(o *storeOptions) {
+		o.NTPServer = ntpServer
+		o.ClockSkewWarn = warn
+		o.ClockSkewMax = max
+		o.ClockRefuseWrites = refuseWrites
+	}
+}
+
+// GCOpt sets the policy of garbage collection.
+func GCOpt(policy *GCPolicy) storeOpt {
+	return func(o *storeOptions) {
+		o.GC = policy
+	}
+}
+
+// EncryptionOpt sets namespaces whose content is encrypted, see EncryptionPolicy.
+func EncryptionOpt(policy *EncryptionPolicy) storeOpt {
+	return func(o *storeOptions) {
+		o.Encryption = policy
+	}
+}
+
+// ConflictPolicyOpt sets how write conflicts of records are resolved, see ConflictPolicy.
+func ConflictPolicyOpt(policy *ConflictPolicy) storeOpt {
+	return func(o *storeOptions) {
+		o.Conflicts = policy
+	}
+}
+
+// DiskBudgetOpt sets the disk budget of the node, see DiskBudget.
+func DiskBudgetOpt(budget *DiskBudget) storeOpt {
+	return func(o *storeOptions) {
+		o.DiskBudget = budget
+	}
+}
+
+// ReadOnlyOpt makes an observer node that syncs and serves records but never writes any.
+func ReadOnlyOpt(readOnly bool) storeOpt {
+	return func(o *storeOptions) {
+		o.ReadOnly = readOnly
+	}
+}
+
+// VouchOpt sets the policy of vouches for new nodes.
+func VouchOpt(policy *VouchPolicy) storeOpt {
+	return func(o *storeOptions) {
+		o.Vouch = policy
+	}
+}
+
+// SearchOpt enables the search index of records, nil disables it.
+func SearchOpt(policy *SearchPolicy) storeOpt {
+	return func(o *storeOptions) {
+		o.Search = policy
+	}
+}
+
+// LifecycleTTLOpt sets how long lifecycle events of nodes are kept in the state store.
+func LifecycleTTLOpt(ttl time.Duration) storeOpt {
+	return func(o *storeOptions) {
+		o.LifecycleTTL = ttl
+	}
+}
+
+// DeltaSyncOpt configures sync on start: records changed since the last completed sync
+// minus the margin are fetched, all of them if full is set.
+func DeltaSyncOpt(full bool, margin time.Duration) storeOpt {
+	return func(o *storeOptions) {
+		o.FullResync = full
+		o.SyncMargin = margin
+	}
+}
+
+// WebhooksOpt sets how many times deliveries to change webhooks are tried and
+// how long the ones that failed are kept as dead letters.
+func WebhooksOpt(attempts int, deadTTL time.Duration) storeOpt {
+	return func(o *storeOptions) {
+		o.WebhookAttempts = attempts
+		o.WebhookDeadTTL = deadTTL
+	}
+}
+
+// InboundWorkersOpt sets how many inbound announces are handled in parallel, announces
+// of the same record are always handled in order.
+func InboundWorkersOpt(workers int) storeOpt {
+	return func(o *storeOptions) {
+		o.InboundWorkers = workers
+	}
+}
+
+// MirrorOpt makes the node pin content of the selected records only, the policy applies
+// unless another one was set at runtime, see MirrorPolicy.
+func MirrorOpt(policy *MirrorPolicy) storeOpt {
+	return func(o *storeOptions) {
+		o.Mirror = policy
+	}
+}
+
+// PopularityOpt counts the given share of client reads of records, between 0 and 1,
+// zero turns counting off.
+func PopularityOpt(sample float64) storeOpt {
+	return func(o *storeOptions) {
+		o.PopularitySample = sample
+	}
+}
+
+// BeatPolicyOpt sets how often beats are sent, see BeatPolicy.
+func BeatPolicyOpt(policy *BeatPolicy) storeOpt {
+	return func(o *storeOptions) {
+		o.Beats = policy
+	}
+}
+
+// ColdTierOpt offloads versions to the store of the policy, see ColdTierPolicy.
+func ColdTierOpt(policy *ColdTierPolicy) storeOpt {
+	return func(o *storeOptions) {
+		o.ColdTier = policy
+	}
+}
+
+// DryRunOpt makes beat report commits only log the writes they would make, see SimulatedWrites.
+func DryRunOpt(dryRun bool) storeOpt {
+	return func(o *storeOptions) {
+		o.DryRun = dryRun
+	}
+}
+
+// CacheOpt shares the cache of hot lookups with the record store, nil disables caching.
+func CacheOpt(c *cache.Cache) storeOpt {
+	return func(o *storeOptions) {
+		o.Cache = c
+	}
+}
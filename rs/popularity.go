@@ -0,0 +1,322 @@
+package rs
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Reads of records served to clients are counted by path, so publishers learn what's consumed
+// and garbage collection drops history of records nobody reads first. Counting is sampled: a read
+// is counted with the probability set by PopularityOpt and counts are scaled back when reported.
+// Nothing about clients is kept, only reads per hour of the last day and per day of the last week,
+// and the hour of the last read counted. Counts are kept in memory and flushed to the
+// record_access state bucket every popularityFlush, entries of records not read for
+// popularityRetention expire.
+
+const (
+	popularityHours     = 24
+	popularityDays      = 7
+	popularityRetention = popularityDays * 24 * time.Hour
+	popularityFlush     = 5 * time.Minute
+	// maxPopularityPaths bounds paths tracked in memory, reads of new paths are not counted
+	// once it's reached.
+	maxPopularityPaths = 100000
+	// popularMinReads hides records read less often from reports, so that reads of a single
+	// client can't be told apart.
+	popularMinReads = 5
+
+	DefaultPopularLimit = 100
+	MaxPopularLimit     = 1000
+)
+
+// RecordAccess reports reads of a record within the window of a query.
+type RecordAccess struct {
+	Path string `json:"path"`
+	// Reads is an estimate, counts are scaled back by the sample rate.
+	Reads uint64 `json:"reads"`
+	// LastAccess is truncated to the hour.
+	LastAccess time.Time `json:"last_access"`
+}
+
+// PopularQuery selects the most read records under a prefix within a window of up to a week.
+type PopularQuery struct {
+	Prefix string
+	Window time.Duration
+	Limit  int
+}
+
+// PopularRecords lists the most read records first.
+type PopularRecords struct {
+	Window  string          `json:"window"`
+	Sample  float64         `json:"sample"`
+	Records []*RecordAccess `json:"records"`
+}
+
+type accessCounts struct {
+	Path string `json:"path"`
+	// Hours[i] counts reads of the hour Hour-i, in hours since the epoch, Days of days alike.
+	Hour       int64     `json:"hour"`
+	Hours      []uint32  `json:"hours"`
+	Day        int64     `json:"day"`
+	Days       []uint32  `json:"days"`
+	LastAccess time.Time `json:"last_access"`
+
+	dirty bool
+}
+
+func newAccessCounts(path string, now time.Time) *accessCounts {
+	return &accessCounts{
+		Path:  path,
+		Hour:  now.Unix() / 3600,
+		Hours: make([]uint32, popularityHours),
+		Day:   now.Unix() / 86400,
+		Days:  make([]uint32, popularityDays),
+	}
+}
+
+// shiftCounts moves counts by n slots towards the past, the oldest ones drop out.
+func shiftCounts(counts []uint32, n int64) {
+	if n <= 0 {
+		return
+	} else if n >= int64(len(counts)) {
+		for i := range counts {
+			counts[i] = 0
+		}
+		return
+	}
+	copy(counts[n:], counts[:int64(len(counts))-n])
+	for i := int64(0); i < n; i++ {
+		counts[i] = 0
+	}
+}
+
+func (a *accessCounts) advance(now time.Time) {
+	hour, day := now.Unix()/3600, now.Unix()/86400
+	shiftCounts(a.Hours, hour-a.Hour)
+	shiftCounts(a.Days, day-a.Day)
+	a.Hour, a.Day = hour, day
+}
+
+// reads returns counted reads within the window, windows of a day or less are counted
+// by hours, longer ones by days.
+func (a *accessCounts) reads(window time.Duration) uint64 {
+	counts, slot := a.Hours, time.Hour
+	if window > popularityHours*time.Hour {
+		counts, slot = a.Days, 24*time.Hour
+	}
+	n := int((window + slot - 1) / slot)
+	if n > len(counts) {
+		n = len(counts)
+	}
+	var sum uint64
+	for _, v := range counts[:n] {
+		sum += uint64(v)
+	}
+	return sum
+}
+
+type popularityTracker struct {
+	sample float64
+
+	mux   *sync.Mutex
+	paths map[string]*accessCounts
+}
+
+func newPopularityTracker(sample float64) *popularityTracker {
+	if sample > 1 {
+		sample = 1
+	}
+	return &popularityTracker{
+		sample: sample,
+		mux:    new(sync.Mutex),
+		paths:  make(map[string]*accessCounts),
+	}
+}
+
+func (t *popularityTracker) observe(path string) {
+	if t.sample <= 0 || (t.sample < 1 && rand.Float64() >= t.sample) {
+		return
+	}
+	now := time.Now()
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	a, ok := t.paths[path]
+	if !ok {
+		if len(t.paths) >= maxPopularityPaths {
+			return
+		}
+		a = newAccessCounts(path, now)
+		t.paths[path] = a
+	}
+	a.advance(now)
+	a.Hours[0]++
+	a.Days[0]++
+	a.LastAccess = now.UTC().Truncate(time.Hour)
+	a.dirty = true
+}
+
+// estimate scales counted reads back by the sample rate.
+func (t *popularityTracker) estimate(n uint64) uint64 {
+	if t.sample <= 0 || n == 0 {
+		return 0
+	}
+	return uint64(float64(n)/t.sample + 0.5)
+}
+
+// reads returns estimated reads of the record at path within the window.
+func (t *popularityTracker) reads(path string, window time.Duration) uint64 {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	a, ok := t.paths[path]
+	if !ok {
+		return 0
+	}
+	a.advance(time.Now())
+	return t.estimate(a.reads(window))
+}
+
+func (t *popularityTracker) popular(q *PopularQuery) []*RecordAccess {
+	now := time.Now()
+	var list []*RecordAccess
+	t.mux.Lock()
+	for path, a := range t.paths {
+		if !strings.HasPrefix(path, q.Prefix) {
+			continue
+		}
+		a.advance(now)
+		if reads := t.estimate(a.reads(q.Window)); reads >= popularMinReads {
+			list = append(list, &RecordAccess{
+				Path:       path,
+				Reads:      reads,
+				LastAccess: a.LastAccess,
+			})
+		}
+	}
+	t.mux.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Reads == list[j].Reads {
+			return list[i].Path < list[j].Path
+		}
+		return list[i].Reads > list[j].Reads
+	})
+	if len(list) > q.Limit {
+		list = list[:q.Limit]
+	}
+	return list
+}
+
+// ObserveRead accounts a read of the record at path served to a client in SLIs of its
+// namespace, reads that found no record are successful, and in popularity if it was found.
+func (r *recordStore) ObserveRead(path string, d time.Duration, err error) {
+	failed := err != nil && err != ErrRecordNotFound
+	r.slo.observeRead(NamespaceOf(path), d, failed)
+	if err == nil {
+		r.popularity.observe(path)
+	}
+}
+
+func (r *recordStore) PopularRecords(ctx context.Context, q *PopularQuery) (*PopularRecords, error) {
+	if q.Window <= 0 {
+		q.Window = popularityHours * time.Hour
+	} else if q.Window > popularityRetention {
+		q.Window = popularityRetention
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultPopularLimit
+	} else if q.Limit > MaxPopularLimit {
+		q.Limit = MaxPopularLimit
+	}
+	return &PopularRecords{
+		Window:  q.Window.String(),
+		Sample:  r.popularity.sample,
+		Records: r.popularity.popular(q),
+	}, nil
+}
+
+// TrackPopularity loads read counts kept by the previous run and flushes them every
+// popularityFlush.
+func (r *recordStore) TrackPopularity(ctx context.Context) {
+	if r.popularity.sample <= 0 {
+		return
+	}
+	r.loadPopularity(ctx)
+	t := time.NewTicker(popularityFlush)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			r.flushPopularity(ctx)
+		}
+	}
+}
+
+func (r *recordStore) loadPopularity(ctx context.Context) {
+	now := time.Now()
+	b := state.NewBucket(state.BucketRecordAccess)
+	if _, err := r.ss.RangePeek(ctx, b, func(_ *state.Key, v []byte) error {
+		var a accessCounts
+		if err := json.Unmarshal(v, &a); err != nil {
+			log.Debugf("skipping malformed record access: %v", err)
+			return nil
+		} else if len(a.Hours) != popularityHours || len(a.Days) != popularityDays {
+			return nil
+		}
+		a.advance(now)
+		r.popularity.mux.Lock()
+		defer r.popularity.mux.Unlock()
+		if len(r.popularity.paths) >= maxPopularityPaths {
+			return state.ErrRangeStop
+		} else if _, ok := r.popularity.paths[a.Path]; !ok {
+			r.popularity.paths[a.Path] = &a
+		}
+		return nil
+	}); err != nil {
+		log.Warningf("failed to load record access counts: %v", err)
+	}
+}
+
+// flushPopularity writes counts changed since the last flush and forgets paths not
+// read within popularityRetention, their keys expire in the state store.
+func (r *recordStore) flushPopularity(ctx context.Context) {
+	var dirty []*accessCounts
+	expireBefore := time.Now().Add(-popularityRetention)
+	r.popularity.mux.Lock()
+	for path, a := range r.popularity.paths {
+		if a.LastAccess.Before(expireBefore) {
+			delete(r.popularity.paths, path)
+			continue
+		} else if !a.dirty {
+			continue
+		}
+		a.dirty = false
+		copied := *a
+		copied.Hours = append([]uint32(nil), a.Hours...)
+		copied.Days = append([]uint32(nil), a.Days...)
+		dirty = append(dirty, &copied)
+	}
+	r.popularity.mux.Unlock()
+	for _, a := range dirty {
+		data, err := json.Marshal(a)
+		if err != nil {
+			continue
+		}
+		k := state.RecordAccessKey(a.Path)
+		k.TTL = popularityRetention
+		if err := r.ss.Update(ctx, k, func(_ *state.Key, _ []byte) ([]byte, error) {
+			return data, nil
+		}); err != nil {
+			log.WithField("path", a.Path).Debugf("failed to save record access counts: %v", err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package rs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Manifest indexes are checkpoints of namespaces: the index commits to the Merkle root of its
+// entries, so an inclusion proof of a record lets clients check it against a root they trust,
+// e.g. the root of an index signed by a node with permissions or anchored on chain, without
+// fetching the whole manifest. Leaves are entries in path order, a leaf is sha256 of 0x00 and
+// "path\tcid\tsha256\tsize", an inner node is sha256 of 0x01 and both children. The last node
+// of an odd level is carried to the next level as is.
+
+var (
+	ErrNoMerkleRoot = errors.New("checkpoint has no Merkle root, it was published by an older node")
+	ErrNotInIndex   = errors.New("record is not in the checkpoint")
+)
+
+// ProofStep is a sibling on the path from the leaf to the root.
+type ProofStep struct {
+	Hash string `json:"hash"`
+	// Left is set if the sibling is the left child.
+	Left bool `json:"left,omitempty"`
+}
+
+type RecordProof struct {
+	Entry *ManifestEntry `json:"entry"`
+	// Checkpoint is the CID of the manifest index the proof is against.
+	Checkpoint  string       `json:"checkpoint"`
+	NodeID      string       `json:"node_id"`
+	GeneratedAt time.Time    `json:"generated_at"`
+	Root        string       `json:"merkle_root"`
+	Signature   string       `json:"signature"`
+	Index       int          `json:"leaf_index"`
+	Leaves      int          `json:"leaves"`
+	Steps       []*ProofStep `json:"steps"`
+}
+
+func manifestLeaf(e *ManifestEntry) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	fmt.Fprintf(h, "%s\t%s\t%s\t%d", e.Path, e.Version, e.SHA256, e.Size)
+	return h.Sum(nil)
+}
+
+func manifestLeaves(entries []*ManifestEntry) [][]byte {
+	leaves := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		leaves = append(leaves, manifestLeaf(e))
+	}
+	return leaves
+}
+
+func merkleNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// merkleLevel hashes pairs of nodes of the level.
+func merkleLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, merkleNode(level[i], level[i+1]))
+	}
+	return next
+}
+
+// merkleRoot returns the root of the tree, sha256 of nothing for no leaves.
+func merkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	}
+	level := leaves
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+	return level[0]
+}
+
+func merkleProof(leaves [][]byte, idx int) []*ProofStep {
+	var steps []*ProofStep
+	level := leaves
+	for len(level) > 1 {
+		if sibling := idx ^ 1; sibling < len(level) {
+			steps = append(steps, &ProofStep{
+				Hash: hex.EncodeToString(level[sibling]),
+				Left: sibling < idx,
+			})
+		}
+		level = merkleLevel(level)
+		idx /= 2
+	}
+	return steps
+}
+
+// RecordProof returns the inclusion proof of the record at path in a manifest index of its
+// namespace, checkpoint is the CID of the index or empty for the current one.
+func (r *recordStore) RecordProof(ctx context.Context, path, checkpoint string) (*RecordProof, error) {
+	indexPath := manifestDir(NamespaceOf(path)) + "/index.json"
+	rec, err := r.ReadRecord(ctx, indexPath, ReadOptions{
+		Version:   checkpoint,
+		NoContent: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	version := rec.Object.Meta().Version()
+	index, err := r.readManifestIndex(ctx, indexPath, ReadOptions{
+		Version: version,
+	})
+	if err != nil {
+		return nil, err
+	} else if len(index.MerkleRoot) == 0 {
+		return nil, ErrNoMerkleRoot
+	}
+	var entries []*ManifestEntry
+	for _, link := range index.Pages {
+		if len(link.Version) == 0 {
+			return nil, ErrNoMerkleRoot
+		}
+		page, err := r.readManifestPage(ctx, link)
+		if err != nil {
+			err = fmt.Errorf("failed to read manifest page %s: %v", link.Path, err)
+			return nil, err
+		}
+		entries = append(entries, page.Entries...)
+	}
+	idx := sort.Search(len(entries), func(i int) bool {
+		return entries[i].Path >= path
+	})
+	if idx == len(entries) || entries[idx].Path != path {
+		return nil, ErrNotInIndex
+	}
+	leaves := manifestLeaves(entries)
+	if root := hex.EncodeToString(merkleRoot(leaves)); root != index.MerkleRoot {
+		err := fmt.Errorf("manifest pages don't match the Merkle root of the checkpoint")
+		return nil, err
+	}
+	return &RecordProof{
+		Entry:       entries[idx],
+		Checkpoint:  version,
+		NodeID:      index.NodeID,
+		GeneratedAt: index.GeneratedAt,
+		Root:        index.MerkleRoot,
+		Signature:   index.Signature,
+		Index:       idx,
+		Leaves:      len(leaves),
+		Steps:       merkleProof(leaves, idx),
+	}, nil
+}
+
+func (r *recordStore) readManifestPage(ctx context.Context, link *ManifestLink) (*ManifestPage, error) {
+	rec, err := r.ReadRecord(ctx, link.Path, ReadOptions{
+		Version: link.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rec.Body.Close()
+	var page *ManifestPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
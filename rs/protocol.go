@@ -0,0 +1,203 @@
+package rs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+)
+
+const (
+	// ProtocolVersion is the version of the gossip format this node speaks, it is
+	// stamped on every announce and reported in ping responses. Nodes that predate
+	// the negotiation report no version and are treated as version 0.
+	ProtocolVersion uint16 = 1
+	// RecordFormatVersion is the oldest protocol version able to read records produced
+	// by this node. Bump it together with changes of the gossip format that older nodes
+	// cannot read, writes are refused while such nodes are still present in the cluster.
+	RecordFormatVersion uint16 = 0
+
+	ProtocolVersionHeader = "X-Protocol-Version"
+
+	// protocolPeerTTL is how long a peer counts as present after it was last seen.
+	protocolPeerTTL = time.Hour
+)
+
+// ProtocolError explains why the node refuses to produce records.
+type ProtocolError struct {
+	Version    uint16
+	Required   uint16
+	ClusterMin uint16
+	// Outdated lists peers that are below the required version.
+	Outdated []string
+}
+
+func (p *ProtocolError) Error() string {
+	if p.Version < p.Required {
+		return fmt.Sprintf("node protocol version %d is below the minimum %d required by authorities, upgrade the node",
+			p.Version, p.Required)
+	}
+	return fmt.Sprintf("records of format %d cannot be read by peers with protocol version %d still present in the cluster: %s",
+		p.Required, p.ClusterMin, strings.Join(p.Outdated, ", "))
+}
+
+type ProtocolPeer struct {
+	NodeID   string    `json:"node_id"`
+	Version  uint16    `json:"version"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type ProtocolStatus struct {
+	Version      uint16          `json:"version"`
+	RecordFormat uint16          `json:"record_format"`
+	AuthorityMin uint16          `json:"authority_min"`
+	ClusterMin   uint16          `json:"cluster_min"`
+	Peers        []*ProtocolPeer `json:"peers"`
+	// WriteError is set if the node currently refuses to produce records.
+	WriteError string `json:"write_error,omitempty"`
+}
+
+type protocolTracker struct {
+	mux   *sync.RWMutex
+	peers map[string]*ProtocolPeer
+}
+
+func newProtocolTracker() *protocolTracker {
+	return &protocolTracker{
+		mux:   new(sync.RWMutex),
+		peers: make(map[string]*ProtocolPeer),
+	}
+}
+
+func (p *protocolTracker) Observe(nodeID string, version uint16) {
+	p.mux.Lock()
+	peer, ok := p.peers[nodeID]
+	if !ok {
+		peer = &ProtocolPeer{
+			NodeID: nodeID,
+		}
+		p.peers[nodeID] = peer
+	}
+	if ok && peer.Version != version {
+		log.WithField("nodeID", nodeID).Infof("peer protocol version changed from %d to %d", peer.Version, version)
+	}
+	peer.Version = version
+	peer.LastSeen = time.Now()
+	p.mux.Unlock()
+}
+
+// Present returns peers seen recently that are not retired by authorities.
+func (p *protocolTracker) Present(authorityMin uint16) []*ProtocolPeer {
+	var peers []*ProtocolPeer
+	p.mux.RLock()
+	for _, peer := range p.peers {
+		if time.Since(peer.LastSeen) > protocolPeerTTL {
+			continue
+		} else if peer.Version < authorityMin {
+			continue
+		}
+		v := *peer
+		peers = append(peers, &v)
+	}
+	p.mux.RUnlock()
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].NodeID < peers[j].NodeID
+	})
+	return peers
+}
+
+func (p *protocolTracker) CheckProduce() error {
+	authorityMin := uint16(authcenter.Default.MinProtocolVersion())
+	if ProtocolVersion < authorityMin {
+		return &ProtocolError{
+			Version:  ProtocolVersion,
+			Required: authorityMin,
+		}
+	}
+	var outdated []string
+	clusterMin := ProtocolVersion
+	for _, peer := range p.Present(authorityMin) {
+		if peer.Version < clusterMin {
+			clusterMin = peer.Version
+		}
+		if peer.Version < RecordFormatVersion {
+			outdated = append(outdated, peer.NodeID)
+		}
+	}
+	if len(outdated) > 0 {
+		return &ProtocolError{
+			Version:    ProtocolVersion,
+			Required:   RecordFormatVersion,
+			ClusterMin: clusterMin,
+			Outdated:   outdated,
+		}
+	}
+	return nil
+}
+
+// acceptsAnnounce reports whether announces of the given protocol version are accepted,
+// peers below the minimum version set by authorities are retired.
+func acceptsAnnounce(version uint16) bool {
+	return version >= uint16(authcenter.Default.MinProtocolVersion())
+}
+
+func parseProtocolVersion(resp *http.Response) uint16 {
+	v, err := strconv.ParseUint(resp.Header.Get(ProtocolVersionHeader), 10, 16)
+	if err != nil {
+		return 0
+	}
+	return uint16(v)
+}
+
+// NegotiateProtocol periodically pings the nodes listed by authorities, so the
+// protocol versions of the cluster members are known before producing records.
+func (r *recordStore) NegotiateProtocol(ctx context.Context, interval time.Duration) {
+	t := time.NewTimer(time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			var nodeIDs []string
+			for key := range authcenter.Default.Entries() {
+				if key != r.nodeID {
+					nodeIDs = append(nodeIDs, key)
+				}
+			}
+			// pingNode records versions of the nodes that respond
+			r.aliveNodes(ctx, nodeIDs)
+			if err := r.protocol.CheckProduce(); err != nil {
+				log.WithField("alert", "protocol-incompatible").Warningln(err)
+			}
+			t.Reset(interval)
+		}
+	}
+}
+
+func (r *recordStore) ProtocolStatus() *ProtocolStatus {
+	authorityMin := uint16(authcenter.Default.MinProtocolVersion())
+	status := &ProtocolStatus{
+		Version:      ProtocolVersion,
+		RecordFormat: RecordFormatVersion,
+		AuthorityMin: authorityMin,
+		ClusterMin:   ProtocolVersion,
+		Peers:        r.protocol.Present(authorityMin),
+	}
+	for _, peer := range status.Peers {
+		if peer.Version < status.ClusterMin {
+			status.ClusterMin = peer.Version
+		}
+	}
+	if err := r.protocol.CheckProduce(); err != nil {
+		status.WriteError = err.Error()
+	}
+	return status
+}
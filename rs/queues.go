@@ -0,0 +1,81 @@
+package rs
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/AtlantPlatform/atlant-go/metrics"
+)
+
+// syncDurationBuckets are upper bounds of the sync duration histogram, in seconds.
+var syncDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600}
+
+type QueueStats struct {
+	// InboundDepth is the number of announces received and not handled yet.
+	InboundDepth int64 `json:"inbound_depth"`
+	// OutboundDepth is the number of announces produced and not published yet.
+	OutboundDepth int64         `json:"outbound_depth"`
+	Inbound       *InboundStats `json:"inbound"`
+	Journal       *JournalStats `json:"journal"`
+}
+
+type SyncStats struct {
+	// Running is set while a sync is in progress.
+	Running   bool                       `json:"running"`
+	Syncs     uint64                     `json:"syncs_total"`
+	Failures  uint64                     `json:"failures_total"`
+	Durations *metrics.HistogramSnapshot `json:"durations"`
+	// Checkpoint is the last completed sync, nil if the store has never synced.
+	Checkpoint *SyncCheckpoint `json:"checkpoint,omitempty"`
+}
+
+func (r *recordStore) QueueStats() *QueueStats {
+	return &QueueStats{
+		InboundDepth:  atomic.LoadInt64(&r.inbound.queued),
+		OutboundDepth: atomic.LoadInt64(&r.outboundQueued),
+		Inbound:       r.inbound.Stats(),
+		Journal:       r.journal.Stats(),
+	}
+}
+
+func (r *recordStore) SyncStats() *SyncStats {
+	stats := &SyncStats{
+		Running:   atomic.LoadInt32(&r.syncing) == 1,
+		Syncs:     atomic.LoadUint64(&r.syncs),
+		Failures:  atomic.LoadUint64(&r.syncFailures),
+		Durations: r.syncDurations.Snapshot(),
+	}
+	stats.Checkpoint, _ = r.syncCheckpoint()
+	return stats
+}
+
+func (r *recordStore) observeSync(startedAt time.Time, err error) {
+	atomic.AddUint64(&r.syncs, 1)
+	if err != nil {
+		atomic.AddUint64(&r.syncFailures, 1)
+	}
+	r.syncDurations.Observe(time.Since(startedAt).Seconds())
+}
+
+// BeatStats tells when beat ticks and beat infos were last sent, nil if never, and the
+// current interval of ticks.
+type BeatStats struct {
+	LastTick     *time.Time `json:"last_tick,omitempty"`
+	LastInfo     *time.Time `json:"last_info,omitempty"`
+	TickInterval string     `json:"tick_interval"`
+}
+
+func (r *recordStore) BeatStats() *BeatStats {
+	stats := &BeatStats{
+		TickInterval: time.Duration(atomic.LoadInt64(&r.beats.interval)).String(),
+	}
+	if ts := atomic.LoadInt64(&r.lastBeatTick); ts > 0 {
+		t := time.Unix(0, ts)
+		stats.LastTick = &t
+	}
+	if ts := atomic.LoadInt64(&r.lastBeatInfo); ts > 0 {
+		t := time.Unix(0, ts)
+		stats.LastInfo = &t
+	}
+	return stats
+}
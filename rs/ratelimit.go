@@ -0,0 +1,310 @@
+package rs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+)
+
+// RateUsageTopic carries request counters of gateway nodes.
+const RateUsageTopic = "rate-usage"
+
+// rateGossipInterval is how often gateways publish their counters, a client spraying
+// requests across gateways may exceed the limit by what it manages within that time.
+const rateGossipInterval = time.Second
+
+// RateLimitPolicy limits requests of a client to records of a namespace within a window.
+// Usage reported by the listed gateway nodes is added up, so a client can't bypass
+// the limit by spreading requests across them. Windows are aligned to the wall clock,
+// so all gateways agree on them.
+type RateLimitPolicy struct {
+	Requests int    `json:"requests"`
+	Window   string `json:"window"`
+	// Gateways are IDs of nodes that share counters of the namespace.
+	Gateways []string `json:"gateways,omitempty"`
+
+	window time.Duration
+}
+
+func (p *RateLimitPolicy) validate() error {
+	if p.Requests <= 0 {
+		return fmt.Errorf("requests must be positive")
+	}
+	d, err := time.ParseDuration(p.Window)
+	if err != nil {
+		return fmt.Errorf("bad window: %v", err)
+	} else if d <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	p.window = d
+	return nil
+}
+
+func (p *RateLimitPolicy) isGateway(nodeID string) bool {
+	for _, id := range p.Gateways {
+		if id == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+type RateLimitStats struct {
+	Policies map[string]*RateLimitPolicy `json:"policies"`
+	// Clients is the number of clients seen in the current window per namespace.
+	Clients  map[string]int `json:"clients"`
+	Rejected uint64         `json:"rejected_total"`
+}
+
+type rateUsage struct {
+	Namespace string `json:"namespace"`
+	Window    int64  `json:"window"`
+	// Counts are requests of clients served by the sender in the window, by client hash.
+	Counts map[string]int `json:"counts"`
+}
+
+type rateWindow struct {
+	start  time.Time
+	local  map[string]int
+	remote map[string]map[string]int
+	dirty  map[string]struct{}
+}
+
+func newRateWindow(start time.Time) *rateWindow {
+	return &rateWindow{
+		start:  start,
+		local:  make(map[string]int),
+		remote: make(map[string]map[string]int),
+		dirty:  make(map[string]struct{}),
+	}
+}
+
+func (w *rateWindow) total(client string) int {
+	total := w.local[client]
+	for _, counts := range w.remote {
+		total += counts[client]
+	}
+	return total
+}
+
+type rateLimiter struct {
+	nodeID string
+
+	mux      *sync.Mutex
+	policies map[string]*RateLimitPolicy
+	windows  map[string]*rateWindow
+	rejected uint64
+}
+
+func newRateLimiter(nodeID string) *rateLimiter {
+	return &rateLimiter{
+		nodeID:   nodeID,
+		mux:      new(sync.Mutex),
+		policies: make(map[string]*RateLimitPolicy),
+		windows:  make(map[string]*rateWindow),
+	}
+}
+
+// clientHash keeps client addresses out of gossip.
+func clientHash(ns, client string) string {
+	sum := sha256.Sum256([]byte(ns + "/" + client))
+	return hex.EncodeToString(sum[:8])
+}
+
+// window returns the current window of the namespace, must be called with the lock held.
+func (l *rateLimiter) window(ns string, p *RateLimitPolicy, now time.Time) *rateWindow {
+	start := now.Truncate(p.window)
+	w, ok := l.windows[ns]
+	if !ok || !w.start.Equal(start) {
+		w = newRateWindow(start)
+		l.windows[ns] = w
+	}
+	return w
+}
+
+func (l *rateLimiter) Allow(ns, client string) (bool, time.Duration) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	p, ok := l.policies[ns]
+	if !ok {
+		return true, 0
+	}
+	now := time.Now()
+	w := l.window(ns, p, now)
+	key := clientHash(ns, client)
+	if w.total(key) >= p.Requests {
+		atomic.AddUint64(&l.rejected, 1)
+		return false, w.start.Add(p.window).Sub(now)
+	}
+	w.local[key]++
+	if len(p.Gateways) > 0 {
+		w.dirty[key] = struct{}{}
+	}
+	return true, 0
+}
+
+func (l *rateLimiter) setPolicies(policies map[string]*RateLimitPolicy) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for ns, w := range l.windows {
+		if p, ok := policies[ns]; !ok || p.window != l.policies[ns].window {
+			delete(l.windows, ns)
+		} else if len(p.Gateways) == 0 {
+			w.remote = make(map[string]map[string]int)
+		}
+	}
+	l.policies = policies
+}
+
+// observe merges counters of a gateway, stale windows and unlisted nodes are ignored.
+func (l *rateLimiter) observe(from string, usage *rateUsage) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	p, ok := l.policies[usage.Namespace]
+	if !ok || !p.isGateway(from) {
+		return
+	}
+	w := l.window(usage.Namespace, p, time.Now())
+	if w.start.UnixNano() != usage.Window {
+		return
+	}
+	counts, ok := w.remote[from]
+	if !ok {
+		counts = make(map[string]int, len(usage.Counts))
+		w.remote[from] = counts
+	}
+	for client, n := range usage.Counts {
+		// counters only grow within a window, so reordered messages are harmless
+		if n > counts[client] {
+			counts[client] = n
+		}
+	}
+}
+
+// pending returns local counters changed since the last call, for namespaces
+// where this node is a gateway.
+func (l *rateLimiter) pending() []*rateUsage {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	var list []*rateUsage
+	for ns, w := range l.windows {
+		p := l.policies[ns]
+		if p == nil || len(w.dirty) == 0 || !p.isGateway(l.nodeID) {
+			continue
+		}
+		usage := &rateUsage{
+			Namespace: ns,
+			Window:    w.start.UnixNano(),
+			Counts:    make(map[string]int, len(w.dirty)),
+		}
+		for client := range w.dirty {
+			usage.Counts[client] = w.local[client]
+		}
+		w.dirty = make(map[string]struct{})
+		list = append(list, usage)
+	}
+	return list
+}
+
+func (l *rateLimiter) Stats() *RateLimitStats {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	stats := &RateLimitStats{
+		Policies: make(map[string]*RateLimitPolicy, len(l.policies)),
+		Clients:  make(map[string]int, len(l.windows)),
+		Rejected: atomic.LoadUint64(&l.rejected),
+	}
+	for ns, p := range l.policies {
+		stats.Policies[ns] = p
+	}
+	now := time.Now()
+	for ns, w := range l.windows {
+		if p, ok := l.policies[ns]; ok && w.start.Add(p.window).After(now) {
+			stats.Clients[ns] = len(w.local)
+		}
+	}
+	return stats
+}
+
+// AllowRequest counts a request of the client to records of the namespace, it returns
+// false and how long to wait if the client exceeded the rate limit of the namespace.
+func (r *recordStore) AllowRequest(ns, client string) (bool, time.Duration) {
+	return r.rates.Allow(ns, client)
+}
+
+func (r *recordStore) RateLimitStats() *RateLimitStats {
+	return r.rates.Stats()
+}
+
+// ShareRateLimits reloads rate limits of namespaces every interval and exchanges
+// request counters with other gateways of the namespaces.
+func (r *recordStore) ShareRateLimits(ctx context.Context, interval time.Duration) {
+	pub, err := r.fs.PubSub()
+	if err != nil {
+		log.Warningf("rate limits are not shared, failed to use pubsub: %v", err)
+	} else if err := pub.Subscribe(func(m *fs.Message) error {
+		if m.From == r.nodeID {
+			return nil
+		}
+		var usage *rateUsage
+		if err := json.Unmarshal(m.Data, &usage); err != nil || usage == nil {
+			log.Debugln("failed to decode rate usage from", m.From)
+			return nil
+		}
+		r.rates.observe(m.From, usage)
+		return nil
+	}, RateUsageTopic); err != nil {
+		log.Warningf("rate limits are not shared: %v", err)
+	}
+	r.loadRateLimits(ctx)
+	reload := time.NewTicker(interval)
+	defer reload.Stop()
+	gossip := time.NewTicker(rateGossipInterval)
+	defer gossip.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload.C:
+			r.loadRateLimits(ctx)
+		case <-gossip.C:
+			if pub == nil {
+				continue
+			}
+			for _, usage := range r.rates.pending() {
+				data, _ := json.Marshal(usage)
+				if err := pub.Publish(RateUsageTopic, data); err != nil {
+					log.Debugf("failed to publish rate usage: %v", err)
+				}
+			}
+		}
+	}
+}
+
+func (r *recordStore) loadRateLimits(ctx context.Context) {
+	configs, err := r.namespaceConfigs(ctx)
+	if err != nil {
+		log.Warningf("failed to load rate limits: %v", err)
+		return
+	}
+	policies := make(map[string]*RateLimitPolicy)
+	for ns, cfg := range configs {
+		if cfg.RateLimit == nil {
+			continue
+		} else if err := cfg.RateLimit.validate(); err != nil {
+			log.WithField("namespace", ns).Warningf("invalid rate limit: %v", err)
+			continue
+		}
+		policies[ns] = cfg.RateLimit
+	}
+	r.rates.setPolicies(policies)
+}
@@ -0,0 +1,273 @@
+package rs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Re-encryption responds to a compromised folder key: a new key is generated and wrapped
+// for members, every object of the folder is rewritten with it and previous versions
+// holding the old ciphertext are dropped from record history and unpinned. Once all
+// objects are done, the old key versions are removed from the key ring and revoked.
+
+var ErrReencryptRunning = errors.New("shared folder re-encryption is in progress")
+
+const (
+	ReencryptRunning  = "running"
+	ReencryptDone     = "done"
+	ReencryptFailed   = "failed"
+	maxReencryptFails = 20
+)
+
+// ReencryptJob reports progress of a folder re-encryption.
+type ReencryptJob struct {
+	Folder     string    `json:"folder"`
+	KeyVersion int       `json:"key_version"`
+	State      string    `json:"state"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+
+	Total           int      `json:"total"`
+	Reencrypted     int      `json:"reencrypted"`
+	Skipped         int      `json:"skipped"`
+	Failed          int      `json:"failed"`
+	VersionsDropped int      `json:"versions_dropped"`
+	RevokedKeys     []int    `json:"revoked_keys,omitempty"`
+	Errors          []string `json:"errors,omitempty"`
+
+	mux *sync.RWMutex
+}
+
+func (j *ReencryptJob) snapshot() *ReencryptJob {
+	j.mux.RLock()
+	defer j.mux.RUnlock()
+	job := *j
+	job.RevokedKeys = append([]int(nil), j.RevokedKeys...)
+	job.Errors = append([]string(nil), j.Errors...)
+	job.mux = nil
+	return &job
+}
+
+func (j *ReencryptJob) update(fn func(j *ReencryptJob)) {
+	j.mux.Lock()
+	fn(j)
+	j.mux.Unlock()
+}
+
+func (j *ReencryptJob) fail(filePath string, err error) {
+	j.update(func(j *ReencryptJob) {
+		j.Failed++
+		if len(j.Errors) < maxReencryptFails {
+			j.Errors = append(j.Errors, fmt.Sprintf("%s: %v", filePath, err))
+		}
+	})
+}
+
+func (f *sharedFolders) ReencryptFolder(ctx context.Context, name string) (*ReencryptJob, error) {
+	if !isPublishAllowed(f.nodeID) {
+		return nil, ErrNotAuthorized
+	}
+	folder, err := f.GetFolder(ctx, name)
+	if err != nil {
+		return nil, err
+	} else if _, ok := folder.Members[f.nodeID]; !ok {
+		return nil, ErrNotFolderMember
+	}
+	f.jobMux.Lock()
+	if job, ok := f.jobs[name]; ok && job.snapshot().State == ReencryptRunning {
+		f.jobMux.Unlock()
+		return nil, ErrReencryptRunning
+	}
+	job := &ReencryptJob{
+		Folder:    name,
+		State:     ReencryptRunning,
+		StartedAt: time.Now(),
+		mux:       new(sync.RWMutex),
+	}
+	f.jobs[name] = job
+	f.jobMux.Unlock()
+
+	if err := f.rotateKey(folder); err == nil {
+		err = f.saveFolder(ctx, folder)
+	}
+	if err != nil {
+		job.update(func(j *ReencryptJob) {
+			j.State = ReencryptFailed
+			j.FinishedAt = time.Now()
+			j.Errors = append(j.Errors, err.Error())
+		})
+		return nil, err
+	}
+	job.update(func(j *ReencryptJob) {
+		j.KeyVersion = folder.KeyVersion
+	})
+	go f.reencrypt(job, folder)
+	return job.snapshot(), nil
+}
+
+func (f *sharedFolders) ReencryptStatus(name string) (*ReencryptJob, bool) {
+	f.jobMux.RLock()
+	job, ok := f.jobs[name]
+	f.jobMux.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return job.snapshot(), true
+}
+
+func (f *sharedFolders) reencrypt(job *ReencryptJob, folder *SharedFolder) {
+	logger := log.WithFields(log.Fields{
+		"folder":      folder.Name,
+		"key_version": folder.KeyVersion,
+	})
+	logger.Infoln("folder re-encryption started")
+	root := path.Join(folderRoot, folder.Name) + "/"
+	var files []string
+	if err := f.store.WalkRecords(context.Background(), "", func(p string, r *Record) error {
+		if strings.HasPrefix(p, root) && path.Base(p) != folderDescriptorName {
+			files = append(files, strings.TrimPrefix(p, root))
+		}
+		return nil
+	}); err != nil {
+		job.update(func(j *ReencryptJob) {
+			j.State = ReencryptFailed
+			j.FinishedAt = time.Now()
+			j.Errors = append(j.Errors, err.Error())
+		})
+		logger.Warningf("folder re-encryption failed: %v", err)
+		return
+	}
+	job.update(func(j *ReencryptJob) {
+		j.Total = len(files)
+	})
+	for _, filePath := range files {
+		ctx, cancelFn := context.WithTimeout(context.Background(), defaultFolderRewrapTimeout)
+		rewritten, dropped, err := f.reencryptFile(ctx, folder, filePath)
+		cancelFn()
+		if err != nil {
+			logger.WithField("path", filePath).Warningf("failed to re-encrypt folder object: %v", err)
+			job.fail(filePath, err)
+			continue
+		}
+		job.update(func(j *ReencryptJob) {
+			if rewritten {
+				j.Reencrypted++
+			} else {
+				j.Skipped++
+			}
+			j.VersionsDropped += dropped
+		})
+	}
+	if job.snapshot().Failed > 0 {
+		// old keys are kept, so that the job can be run again for the remaining objects
+		job.update(func(j *ReencryptJob) {
+			j.State = ReencryptFailed
+			j.FinishedAt = time.Now()
+		})
+		logger.Warningln("folder re-encryption finished with failures, old keys are kept")
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultFolderRewrapTimeout)
+	revoked, err := f.revokeKeys(ctx, folder.Name, folder.KeyVersion)
+	cancelFn()
+	job.update(func(j *ReencryptJob) {
+		j.FinishedAt = time.Now()
+		j.RevokedKeys = revoked
+		if err != nil {
+			j.State = ReencryptFailed
+			j.Errors = append(j.Errors, fmt.Sprintf("failed to revoke keys: %v", err))
+			return
+		}
+		j.State = ReencryptDone
+	})
+	if err != nil {
+		logger.Warningf("failed to revoke folder keys: %v", err)
+		return
+	}
+	logger.WithField("revoked", revoked).Infoln("folder re-encryption done")
+}
+
+// reencryptFile rewrites the object with the folder key if it's encrypted with an older one,
+// then drops previous versions of the record.
+func (f *sharedFolders) reencryptFile(ctx context.Context, folder *SharedFolder, filePath string) (bool, int, error) {
+	p := folderFilePath(folder.Name, filePath)
+	var rewritten bool
+	r, err := f.store.ReadRecord(ctx, p)
+	if err == nil {
+		if rewritten, err = f.rewriteFile(ctx, folder, filePath, r); err != nil {
+			return false, 0, err
+		}
+	} else if err != ErrRecordNotFound {
+		return false, 0, err
+	}
+	// deleted records may still keep the old ciphertext in their history
+	dropped, err := f.store.DropVersions(ctx, p)
+	if err == ErrRecordNotFound {
+		err = nil
+	}
+	return rewritten, dropped, err
+}
+
+func (f *sharedFolders) rewriteFile(ctx context.Context, folder *SharedFolder, filePath string, r *Record) (bool, error) {
+	data, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return false, err
+	}
+	version, err := folderObjectVersion(data)
+	if err != nil {
+		return false, err
+	} else if version >= folder.KeyVersion {
+		return false, nil
+	}
+	key, err := f.folderKey(folder, version)
+	if err != nil {
+		return false, err
+	}
+	plain, err := decryptFolderObject(key, data)
+	if err != nil {
+		return false, err
+	}
+	if _, err := f.writeFile(ctx, folder, filePath, plain); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// revokeKeys removes key versions older than the given one from the key ring.
+func (f *sharedFolders) revokeKeys(ctx context.Context, name string, keyVersion int) ([]int, error) {
+	folder, err := f.GetFolder(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	revoked := make(map[int]bool, len(folder.RevokedKeys))
+	for _, v := range folder.RevokedKeys {
+		revoked[v] = true
+	}
+	var versions []int
+	for v := 1; v < keyVersion; v++ {
+		if !revoked[v] {
+			versions = append(versions, v)
+			folder.RevokedKeys = append(folder.RevokedKeys, v)
+		}
+		delete(folder.KeyRing, strconv.Itoa(v))
+	}
+	if err := f.saveFolder(ctx, folder); err != nil {
+		return nil, err
+	}
+	f.keyMux.Lock()
+	for v := 1; v < keyVersion; v++ {
+		delete(f.keys, folderKeyID(name, v))
+	}
+	f.keyMux.Unlock()
+	return versions, nil
+}
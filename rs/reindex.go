@@ -0,0 +1,382 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Secondary indexes are derived from records, so they are rebuilt from them when their
+// format changes or they are found damaged. A rebuild rewrites index entries of every
+// record, then sweeps entries of records that are gone. The index keeps serving meanwhile,
+// entries are replaced in place. Rebuilds run in the background at a limited rate of records
+// per second, their progress is saved after each page, so a rebuild interrupted by a restart
+// resumes where it stopped.
+
+const (
+	IndexNamespace = "namespace"
+	IndexSearch    = "search"
+
+	reindexPageSize = 100
+
+	reindexRecords = "records"
+	reindexSweep   = "sweep"
+	reindexDone    = "done"
+)
+
+// Indexes lists indexes that can be rebuilt.
+var Indexes = []string{IndexNamespace, IndexSearch}
+
+var (
+	ErrUnknownIndex    = errors.New("unknown index, expected " + strings.Join(Indexes, " or "))
+	ErrReindexRunning  = errors.New("the index is being rebuilt already")
+	ErrReindexDisabled = errors.New("index rebuilds are not running on the node")
+)
+
+// ReindexJob is the progress of an index rebuild.
+type ReindexJob struct {
+	Index string `json:"index"`
+	// Phase is records while entries of records are rewritten, sweep while stale ones are dropped.
+	Phase     string    `json:"phase"`
+	Cursor    []byte    `json:"cursor,omitempty"`
+	Total     uint64    `json:"total_records"`
+	Processed uint64    `json:"processed_records"`
+	Dropped   uint64    `json:"dropped_entries"`
+	Running   bool      `json:"running"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	DoneAt    time.Time `json:"done_at,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type reindexState struct {
+	enabled int32
+	startC  chan *ReindexJob
+
+	mux  *sync.Mutex
+	jobs map[string]*ReindexJob
+}
+
+func newReindexState() *reindexState {
+	return &reindexState{
+		startC: make(chan *ReindexJob),
+		mux:    new(sync.Mutex),
+		jobs:   make(map[string]*ReindexJob),
+	}
+}
+
+func reindexKey(index string) *state.Key {
+	return state.ReindexKey(index)
+}
+
+// saveJob keeps a copy of the job for the status and persists it, so it can be resumed.
+func (r *recordStore) saveJob(job *ReindexJob) {
+	job.UpdatedAt = time.Now().UTC()
+	r.reindexer.mux.Lock()
+	saved := *job
+	r.reindexer.jobs[job.Index] = &saved
+	r.reindexer.mux.Unlock()
+	data, _ := json.Marshal(&saved)
+	if err := r.ss.Update(context.Background(), reindexKey(job.Index), func(k *state.Key, v []byte) ([]byte, error) {
+		return data, nil
+	}); err != nil {
+		log.WithField("index", job.Index).Warningf("failed to save rebuild progress: %v", err)
+	}
+}
+
+// RunReindexJobs resumes rebuilds interrupted by a restart and runs rebuilds requested by
+// Reindex, limited to rate records per second, zero disables the limit.
+func (r *recordStore) RunReindexJobs(ctx context.Context, rate int) {
+	atomic.StoreInt32(&r.reindexer.enabled, 1)
+	defer atomic.StoreInt32(&r.reindexer.enabled, 0)
+	var resumed []*ReindexJob
+	if _, err := r.ss.RangePeek(ctx, state.NewBucket(state.BucketReindex), func(k *state.Key, v []byte) error {
+		var job ReindexJob
+		if err := json.Unmarshal(v, &job); err != nil {
+			log.Debugf("skipping malformed rebuild job: %v", err)
+			return nil
+		}
+		job.Running = false
+		r.reindexer.mux.Lock()
+		r.reindexer.jobs[job.Index] = &job
+		r.reindexer.mux.Unlock()
+		if job.Phase != reindexDone {
+			resumed = append(resumed, &job)
+		}
+		return nil
+	}); err != nil {
+		log.Warningf("failed to load rebuild jobs: %v", err)
+	}
+	for _, job := range resumed {
+		log.WithField("index", job.Index).Infof("resuming the rebuild at %d of %d records", job.Processed, job.Total)
+		r.startJob(ctx, job, rate)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-r.reindexer.startC:
+			r.startJob(ctx, job, rate)
+		}
+	}
+}
+
+func (r *recordStore) startJob(ctx context.Context, job *ReindexJob, rate int) {
+	if job.Index == IndexSearch && r.search == nil {
+		job.Error = ErrSearchDisabled.Error()
+		r.saveJob(job)
+		return
+	}
+	job.Running = true
+	job.Error = ""
+	r.saveJob(job)
+	go func() {
+		err := r.rebuildIndex(ctx, job, rate)
+		if ctx.Err() != nil {
+			// saved progress is resumed on the next start
+			return
+		}
+		job.Running = false
+		if err != nil {
+			job.Error = err.Error()
+			log.WithField("index", job.Index).Warningf("failed to rebuild the index: %v", err)
+		} else {
+			log.WithField("index", job.Index).Infof("rebuilt the index of %d records, %d stale entries dropped",
+				job.Processed, job.Dropped)
+		}
+		r.saveJob(job)
+	}()
+}
+
+// Reindex starts a rebuild of the index from the beginning.
+func (r *recordStore) Reindex(ctx context.Context, index string) (*ReindexJob, error) {
+	var known bool
+	for _, name := range Indexes {
+		known = known || name == index
+	}
+	if !known {
+		return nil, ErrUnknownIndex
+	} else if index == IndexSearch && r.search == nil {
+		return nil, ErrSearchDisabled
+	} else if atomic.LoadInt32(&r.reindexer.enabled) == 0 {
+		return nil, ErrReindexDisabled
+	}
+	job := &ReindexJob{
+		Index:     index,
+		Phase:     reindexRecords,
+		Running:   true,
+		StartedAt: time.Now().UTC(),
+	}
+	r.reindexer.mux.Lock()
+	prev, ok := r.reindexer.jobs[index]
+	if ok && prev.Running {
+		r.reindexer.mux.Unlock()
+		return nil, ErrReindexRunning
+	}
+	// the job is reserved until it starts, so it isn't started twice
+	reserved := *job
+	r.reindexer.jobs[index] = &reserved
+	r.reindexer.mux.Unlock()
+	release := func() {
+		r.reindexer.mux.Lock()
+		if prev != nil {
+			r.reindexer.jobs[index] = prev
+		} else {
+			delete(r.reindexer.jobs, index)
+		}
+		r.reindexer.mux.Unlock()
+	}
+	total, err := r.countRecords(ctx)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	job.Total = total
+	select {
+	case r.reindexer.startC <- job:
+	case <-time.After(5 * time.Second):
+		release()
+		return nil, ErrReindexDisabled
+	}
+	status := *job
+	return &status, nil
+}
+
+func (r *recordStore) ReindexStatus() []*ReindexJob {
+	r.reindexer.mux.Lock()
+	defer r.reindexer.mux.Unlock()
+	jobs := make([]*ReindexJob, 0, len(r.reindexer.jobs))
+	for _, job := range r.reindexer.jobs {
+		status := *job
+		jobs = append(jobs, &status)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Index < jobs[j].Index
+	})
+	return jobs
+}
+
+func (r *recordStore) countRecords(ctx context.Context) (uint64, error) {
+	var total uint64
+	_, err := r.ss.RangeKeys(ctx, state.NewBucket(state.BucketRecords), func(k *state.Key) error {
+		total++
+		return nil
+	})
+	return total, err
+}
+
+// rebuildIndex runs the job from its saved phase and cursor.
+func (r *recordStore) rebuildIndex(ctx context.Context, job *ReindexJob, rate int) error {
+	type recordPath struct {
+		id, path string
+	}
+	for job.Phase == reindexRecords {
+		started := time.Now()
+		var page []recordPath
+		next, err := r.ss.RangePeek(ctx, state.NewBucket(state.BucketRecords, &state.RangeOptions{
+			Offset: job.Cursor,
+			Limit:  reindexPageSize,
+		}), proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+			page = append(page, recordPath{v.Id(), v.Path()})
+			return nil
+		}))
+		if err != nil {
+			return err
+		}
+		for _, rp := range page {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			switch job.Index {
+			case IndexNamespace:
+				if key, ok := namespaceKey(NamespaceOf(rp.path), rp.id); ok {
+					if err := r.ss.Update(ctx, state.NamespaceRecordsKey(key), func(k *state.Key, v []byte) ([]byte, error) {
+						return []byte(rp.id), nil
+					}); err != nil {
+						return err
+					}
+				}
+			case IndexSearch:
+				if err := r.indexRecord(ctx, rp.id, true); err != nil {
+					log.WithField("id", rp.id).Warningf("failed to index record: %v", err)
+				}
+			}
+		}
+		job.Processed += uint64(len(page))
+		if next == nil {
+			job.Phase, job.Cursor = reindexSweep, nil
+		} else {
+			job.Cursor = next.Offset
+		}
+		r.saveJob(job)
+		throttleReindex(ctx, started, len(page), rate)
+	}
+	for job.Phase == reindexSweep {
+		started := time.Now()
+		next, dropped, err := r.sweepIndex(ctx, job)
+		if err != nil {
+			return err
+		}
+		job.Dropped += uint64(dropped)
+		if next == nil {
+			job.Phase, job.Cursor = reindexDone, nil
+			job.DoneAt = time.Now().UTC()
+		} else {
+			job.Cursor = next.Offset
+		}
+		r.saveJob(job)
+		throttleReindex(ctx, started, reindexPageSize, rate)
+	}
+	return nil
+}
+
+// sweepIndex drops a page of index entries whose records are gone or moved elsewhere.
+func (r *recordStore) sweepIndex(ctx context.Context, job *ReindexJob) (*state.RangeOptions, int, error) {
+	opts := &state.RangeOptions{
+		Offset: job.Cursor,
+		Limit:  reindexPageSize,
+	}
+	var dropped int
+	switch job.Index {
+	case IndexNamespace:
+		type entry struct {
+			key []byte
+			id  string
+		}
+		var page []entry
+		next, err := r.ss.RangePeek(ctx, state.NewBucket(state.BucketNamespaceRecords, opts), func(k *state.Key, v []byte) error {
+			page = append(page, entry{append([]byte(nil), k.Key[:]...), string(v)})
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, e := range page {
+			var path string
+			err := r.ss.View(ctx, state.RecordsKey([]byte(e.id)), proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+				path = v.Path()
+				return nil
+			}))
+			if err != nil && err != state.ErrNotFound {
+				return nil, 0, err
+			} else if err == nil && bytes.HasPrefix(e.key, namespacePrefix(NamespaceOf(path))) {
+				continue
+			}
+			if err := r.ss.Delete(state.NamespaceRecordsKey(e.key)); err != nil && err != state.ErrNotFound {
+				return nil, 0, err
+			}
+			dropped++
+		}
+		return next, dropped, nil
+	default:
+		var ids []string
+		next, err := r.ss.RangeKeys(ctx, state.NewBucket(state.BucketSearchDocs, opts), func(k *state.Key) error {
+			ids = append(ids, strings.TrimRight(string(k.Key[:]), "\x00"))
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, id := range ids {
+			err := r.ss.View(ctx, state.RecordsKey([]byte(id)), func(k *state.Key, v []byte) error {
+				return nil
+			})
+			if err == state.ErrNotFound {
+				// indexing a record that is gone drops its document
+				r.indexRecordLogged(ctx, id)
+				dropped++
+			} else if err != nil {
+				return nil, 0, err
+			}
+		}
+		return next, dropped, nil
+	}
+}
+
+// throttleReindex waits until n records took at least their share of the rate.
+func throttleReindex(ctx context.Context, started time.Time, n, rate int) {
+	if rate <= 0 || n == 0 {
+		return
+	}
+	wait := time.Duration(n)*time.Second/time.Duration(rate) - time.Since(started)
+	if wait <= 0 {
+		return
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
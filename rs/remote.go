@@ -7,12 +7,15 @@ import (
 	"io/ioutil"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	capn "github.com/glycerine/go-capnproto"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/tracing"
 )
 
 type nodeState int
@@ -47,6 +50,7 @@ func (r *recordStore) pingNode(ctx context.Context, nodeID string) nodeState {
 	u := fmt.Sprintf("http://%s/private/v1/ping", nodeID)
 	req, _ := http.NewRequest("GET", u, nil)
 	req = req.WithContext(ctx)
+	sentAt := time.Now()
 	resp, err := r.fs.Client().Do(req)
 	if err != nil {
 		// log.Debugln("pingNode:", nodeID, err)
@@ -60,16 +64,29 @@ func (r *recordStore) pingNode(ctx context.Context, nodeID string) nodeState {
 			return stateError
 		}
 	}
+	resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return stateError
 	}
+	r.protocol.Observe(nodeID, parseProtocolVersion(resp))
+	r.clock.ObservePing(nodeID, resp, sentAt, time.Now())
 	return stateAlive
 }
 
-func (r *recordStore) getNodeRecords(ctx context.Context, nodeID string, rC chan<- *proto.Record) error {
+func (r *recordStore) getNodeRecords(ctx context.Context, nodeID string, since time.Time, rC chan<- *proto.Record) (err error) {
+	ctx, span := tracing.Start(ctx, "rs.sync.peer", attribute.String("node", nodeID))
+	var count int
+	defer func() {
+		span.SetAttributes(attribute.Int("records", count))
+		tracing.End(span, err)
+	}()
 	u := fmt.Sprintf("http://%s/private/v1/records", nodeID)
+	if !since.IsZero() {
+		u = fmt.Sprintf("%s?since=%d", u, since.UnixNano())
+	}
 	req, _ := http.NewRequest("GET", u, nil)
 	req = req.WithContext(ctx)
+	tracing.InjectHTTP(ctx, req.Header)
 	resp, err := r.fs.Client().Do(req)
 	if err != nil {
 		return err
@@ -93,11 +110,14 @@ func (r *recordStore) getNodeRecords(ctx context.Context, nodeID string, rC chan
 		}
 		r := proto.ReadRootRecord(seg)
 		rC <- &r
+		count++
 	}
 	return nil
 }
 
-func (r *recordStore) collectRecords(ctx context.Context, peers []string, rC chan<- *proto.Record) {
+// collectRecords fetches records of peers changed since the given time, synced counts
+// peers that exported all of them.
+func (r *recordStore) collectRecords(ctx context.Context, peers []string, since time.Time, synced *uint32, rC chan<- *proto.Record) {
 	defer close(rC)
 	log.Debugln("collecting records from:", peers)
 
@@ -107,9 +127,11 @@ func (r *recordStore) collectRecords(ctx context.Context, peers []string, rC cha
 		go func(nodeID string) {
 			defer wg.Done()
 			r.outboundWork()
-			if err := r.getNodeRecords(ctx, nodeID, rC); err != nil {
+			if err := r.getNodeRecords(ctx, nodeID, since, rC); err != nil {
 				log.WithField("nodeID", nodeID).Warningf("failed to get node records: %v", err)
+				return
 			}
+			atomic.AddUint32(synced, 1)
 		}(nodeID)
 	}
 	wg.Wait()
@@ -0,0 +1,268 @@
+package rs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Blocks of pinned objects may go missing when the IPFS repo is damaged, e.g. after its disk
+// was replaced or data was partially lost. Versions the node pins but can't read completely
+// are scheduled for a refetch from the swarm, instead of being served as not found until an
+// operator runs fsck. Such versions are found by periodic scans of versions the records refer
+// to and by reads of records whose objects are not found. Versions that are not pinned are
+// left alone, they might have been collected on purpose. Failed refetches are retried with
+// backoff, versions still incomplete after all attempts are reported as lost until the next
+// scan finds them again.
+
+const (
+	repinAttempts = 5
+	repinBackoff  = time.Minute
+	repinTick     = 10 * time.Second
+	repinWarmup   = time.Minute
+	// maxRepinLost limits lost versions listed in the status, the count covers all of them.
+	maxRepinLost = 100
+)
+
+type RepinStatus struct {
+	Enabled    bool      `json:"enabled"`
+	Scanning   bool      `json:"scanning"`
+	LastScanAt time.Time `json:"last_scan_at,omitempty"`
+	// Pending are versions waiting to be refetched, Current is the one being refetched.
+	Pending  int    `json:"pending"`
+	Current  string `json:"current,omitempty"`
+	Detected uint64 `json:"detected_total"`
+	Repaired uint64 `json:"repaired_total"`
+	Fetched  uint64 `json:"fetched_blocks_total"`
+	// LostCount are versions that still miss blocks after all attempts, Lost lists some.
+	LostCount int      `json:"lost_count"`
+	Lost      []string `json:"lost,omitempty"`
+}
+
+type repinTask struct {
+	version  string
+	attempts int
+	next     time.Time
+}
+
+type repinState struct {
+	enabled  int32
+	scanning int32
+	kick     chan struct{}
+
+	mux      *sync.Mutex
+	tasks    map[string]*repinTask
+	lost     map[string]struct{}
+	current  string
+	lastScan time.Time
+	detected uint64
+	repaired uint64
+	fetched  uint64
+}
+
+func newRepinState() *repinState {
+	return &repinState{
+		kick:  make(chan struct{}, 1),
+		mux:   new(sync.Mutex),
+		tasks: make(map[string]*repinTask),
+		lost:  make(map[string]struct{}),
+	}
+}
+
+// schedule queues a refetch of the version, unless it's queued already. Lost versions are
+// queued again only by scans, so reads of them don't keep the worker busy.
+func (x *repinState) schedule(version string, scan bool) {
+	if atomic.LoadInt32(&x.enabled) == 0 || len(version) == 0 {
+		return
+	}
+	x.mux.Lock()
+	if _, ok := x.tasks[version]; ok {
+		x.mux.Unlock()
+		return
+	} else if _, ok := x.lost[version]; ok && !scan {
+		x.mux.Unlock()
+		return
+	}
+	delete(x.lost, version)
+	x.tasks[version] = &repinTask{
+		version: version,
+		next:    time.Now(),
+	}
+	x.mux.Unlock()
+	select {
+	case x.kick <- struct{}{}:
+	default:
+	}
+}
+
+// due returns the task to run next, nil if none is due.
+func (x *repinState) due() *repinTask {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	now := time.Now()
+	var next *repinTask
+	for _, task := range x.tasks {
+		if task.next.After(now) {
+			continue
+		} else if next == nil || task.next.Before(next.next) {
+			next = task
+		}
+	}
+	if next != nil {
+		x.current = next.version
+	}
+	return next
+}
+
+// RepinLost scans the repo for versions with missing blocks every interval and refetches
+// such versions from peers as they are found.
+func (r *recordStore) RepinLost(ctx context.Context, interval time.Duration) {
+	atomic.StoreInt32(&r.repin.enabled, 1)
+	defer atomic.StoreInt32(&r.repin.enabled, 0)
+	scan := time.NewTimer(repinWarmup)
+	defer scan.Stop()
+	tick := time.NewTicker(repinTick)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-scan.C:
+			go func() {
+				r.scanLost(ctx)
+				scan.Reset(interval)
+			}()
+		case <-tick.C:
+			r.refetchLost(ctx)
+		case <-r.repin.kick:
+			r.refetchLost(ctx)
+		}
+	}
+}
+
+// scanLost checks versions the records refer to and schedules pinned ones that miss blocks.
+func (r *recordStore) scanLost(ctx context.Context) {
+	if !atomic.CompareAndSwapInt32(&r.repin.scanning, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&r.repin.scanning, 0)
+	versions, err := r.referencedVersions(ctx)
+	if err != nil {
+		log.Warningf("failed to list versions to scan for lost blocks: %v", err)
+		return
+	}
+	var found int
+	for _, version := range versions {
+		check, err := r.fs.CheckObject(ctx, version, false)
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
+			log.WithField("version", version).Debugf("failed to check the object: %v", err)
+			continue
+		} else if !check.Pinned || check.Missing == 0 {
+			continue
+		}
+		found++
+		r.repin.schedule(version, true)
+	}
+	r.repin.mux.Lock()
+	r.repin.lastScan = time.Now()
+	r.repin.mux.Unlock()
+	if found > 0 {
+		log.Warningf("found %d pinned versions missing blocks, refetching them from peers", found)
+	}
+}
+
+// refetchLost runs due refetches one by one.
+func (r *recordStore) refetchLost(ctx context.Context) {
+	for ctx.Err() == nil {
+		task := r.repin.due()
+		if task == nil {
+			return
+		}
+		r.refetchVersion(ctx, task)
+	}
+}
+
+func (r *recordStore) refetchVersion(ctx context.Context, task *repinTask) {
+	logger := log.WithField("version", task.version)
+	done := func(lost bool) {
+		r.repin.mux.Lock()
+		delete(r.repin.tasks, task.version)
+		if lost {
+			r.repin.lost[task.version] = struct{}{}
+		}
+		r.repin.current = ""
+		r.repin.mux.Unlock()
+	}
+	// reads schedule versions unchecked, objects not pinned are not ours to restore
+	check, err := r.fs.CheckObject(ctx, task.version, false)
+	if err == nil && (!check.Pinned || check.Missing == 0) {
+		done(false)
+		return
+	} else if task.attempts == 0 {
+		r.repin.mux.Lock()
+		r.repin.detected++
+		r.repin.mux.Unlock()
+	}
+	check, err = r.fs.CheckObject(ctx, task.version, true)
+	if check != nil {
+		r.repin.mux.Lock()
+		r.repin.fetched += uint64(check.Fetched)
+		r.repin.mux.Unlock()
+	}
+	if ctx.Err() != nil {
+		return
+	} else if err == nil && check.Missing == 0 {
+		r.repin.mux.Lock()
+		r.repin.repaired++
+		pending := len(r.repin.tasks) - 1
+		r.repin.mux.Unlock()
+		done(false)
+		logger.Infof("refetched %d lost blocks, %d versions left", check.Fetched, pending)
+		return
+	}
+	task.attempts++
+	if task.attempts >= repinAttempts {
+		done(true)
+		logger.Warningf("failed to refetch lost blocks after %d attempts", task.attempts)
+		return
+	}
+	r.repin.mux.Lock()
+	task.next = time.Now().Add(repinBackoff << uint(task.attempts-1))
+	r.repin.current = ""
+	r.repin.mux.Unlock()
+	if err != nil {
+		logger.Debugf("failed to refetch lost blocks: %v", err)
+	} else {
+		logger.Debugf("%d blocks are still missing, retrying at %s", check.Missing, task.next.Format(time.RFC3339))
+	}
+}
+
+func (r *recordStore) RepinStatus() *RepinStatus {
+	r.repin.mux.Lock()
+	defer r.repin.mux.Unlock()
+	status := &RepinStatus{
+		Enabled:    atomic.LoadInt32(&r.repin.enabled) == 1,
+		Scanning:   atomic.LoadInt32(&r.repin.scanning) == 1,
+		LastScanAt: r.repin.lastScan,
+		Pending:    len(r.repin.tasks),
+		Current:    r.repin.current,
+		Detected:   r.repin.detected,
+		Repaired:   r.repin.repaired,
+		Fetched:    r.repin.fetched,
+		LostCount:  len(r.repin.lost),
+	}
+	for version := range r.repin.lost {
+		status.Lost = append(status.Lost, version)
+	}
+	sort.Strings(status.Lost)
+	if len(status.Lost) > maxRepinLost {
+		status.Lost = status.Lost[:maxRepinLost]
+	}
+	return status
+}
@@ -0,0 +1,172 @@
+package rs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// RetentionPolicy limits version history of records in a namespace. The current
+// version is always kept, older versions beyond the limits are dropped from the
+// record, unpinned and removed from the repo by garbage collection.
+type RetentionPolicy struct {
+	// MaxVersions is the number of versions to keep including the current one.
+	MaxVersions int `json:"max_versions,omitempty"`
+	// MaxAge drops previous versions created earlier than that, e.g. "720h".
+	MaxAge string `json:"max_age,omitempty"`
+
+	maxAge time.Duration
+}
+
+func (p *RetentionPolicy) validate() error {
+	if p.MaxVersions < 0 {
+		return fmt.Errorf("max_versions must not be negative")
+	}
+	if len(p.MaxAge) > 0 {
+		d, err := time.ParseDuration(p.MaxAge)
+		if err != nil {
+			return fmt.Errorf("bad max_age: %v", err)
+		} else if d <= 0 {
+			return fmt.Errorf("max_age must be positive")
+		}
+		p.maxAge = d
+	}
+	return nil
+}
+
+// keep returns previous versions that satisfy the policy, the list is ordered from the oldest.
+func (p *RetentionPolicy) keep(previous []proto.RecordVersion, now time.Time) []proto.RecordVersion {
+	kept := previous
+	if p.MaxVersions > 0 && len(kept) > p.MaxVersions-1 {
+		kept = kept[len(kept)-(p.MaxVersions-1):]
+	}
+	if p.maxAge > 0 {
+		threshold := now.Add(-p.maxAge).UnixNano()
+		for len(kept) > 0 && kept[0].Announce().Timestamp() < threshold {
+			kept = kept[1:]
+		}
+	}
+	return kept
+}
+
+type RetentionStatus struct {
+	Policies        map[string]*RetentionPolicy `json:"policies"`
+	LastRun         time.Time                   `json:"last_run,omitempty"`
+	LastError       string                      `json:"last_error,omitempty"`
+	VersionsDropped uint64                      `json:"versions_dropped_total"`
+	BytesReclaimed  int64                       `json:"bytes_reclaimed_total"`
+}
+
+type retentionState struct {
+	mux    *sync.RWMutex
+	status RetentionStatus
+}
+
+func newRetentionState() *retentionState {
+	return &retentionState{
+		mux: new(sync.RWMutex),
+		status: RetentionStatus{
+			Policies: make(map[string]*RetentionPolicy),
+		},
+	}
+}
+
+// EnforceRetention periodically applies retention policies of namespaces.
+func (r *recordStore) EnforceRetention(ctx context.Context, interval time.Duration) {
+	t := time.NewTimer(interval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := r.applyRetention(ctx); err != nil {
+				log.Warningf("failed to apply retention policies: %v", err)
+			}
+			t.Reset(interval)
+		}
+	}
+}
+
+func (r *recordStore) applyRetention(ctx context.Context) error {
+	configs, err := r.namespaceConfigs(ctx)
+	if err != nil {
+		r.retention.finish(nil, 0, 0, err)
+		return err
+	}
+	policies := make(map[string]*RetentionPolicy)
+	for ns, cfg := range configs {
+		if cfg.Retention == nil {
+			continue
+		} else if err := cfg.Retention.validate(); err != nil {
+			log.WithField("namespace", ns).Warningf("invalid retention policy: %v", err)
+			continue
+		}
+		policies[ns] = cfg.Retention
+	}
+	if len(policies) == 0 {
+		r.retention.finish(policies, 0, 0, nil)
+		return nil
+	}
+	var ids []string
+	b := state.NewBucket(state.BucketRecords)
+	if _, err := r.ss.RangePeek(ctx, b, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		p, ok := policies[NamespaceOf(v.Path())]
+		if ok && len(p.keep(v.Previous().ToArray(), time.Now())) < v.Previous().Len() {
+			ids = append(ids, v.Id())
+		}
+		return nil
+	})); err != nil {
+		r.retention.finish(policies, 0, 0, err)
+		return err
+	}
+	dropped := r.trimRecords(ids, func(v *proto.Record) ([]proto.RecordVersion, bool) {
+		p, ok := policies[NamespaceOf(v.Path())]
+		if !ok {
+			return nil, false
+		}
+		return p.keep(v.Previous().ToArray(), time.Now()), true
+	})
+	r.unpinVersions(dropped)
+	var reclaimed int64
+	if len(dropped) > 0 {
+		if reclaimed, err = r.fs.CollectGarbage(ctx); err != nil {
+			r.retention.finish(policies, len(dropped), 0, err)
+			return err
+		}
+		log.Infof("retention dropped %d versions of %d records, reclaimed %d bytes", len(dropped), len(ids), reclaimed)
+	}
+	r.retention.finish(policies, len(dropped), reclaimed, nil)
+	return nil
+}
+
+func (s *retentionState) finish(policies map[string]*RetentionPolicy, dropped int, reclaimed int64, err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if policies != nil {
+		s.status.Policies = policies
+	}
+	s.status.LastRun = time.Now()
+	s.status.LastError = ""
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	s.status.VersionsDropped += uint64(dropped)
+	s.status.BytesReclaimed += reclaimed
+}
+
+func (r *recordStore) RetentionStatus() *RetentionStatus {
+	r.retention.mux.RLock()
+	defer r.retention.mux.RUnlock()
+	status := r.retention.status
+	status.Policies = make(map[string]*RetentionPolicy, len(r.retention.status.Policies))
+	for ns, p := range r.retention.status.Policies {
+		status.Policies[ns] = p
+	}
+	return &status
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,12 +18,16 @@ import (
 	capn "github.com/glycerine/go-capnproto"
 	"github.com/oklog/ulid"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/cache"
 	"github.com/AtlantPlatform/atlant-go/fs"
 	"github.com/AtlantPlatform/atlant-go/logging"
+	"github.com/AtlantPlatform/atlant-go/metrics"
 	"github.com/AtlantPlatform/atlant-go/proto"
 	"github.com/AtlantPlatform/atlant-go/state"
+	"github.com/AtlantPlatform/atlant-go/tracing"
 )
 
 type Record struct {
@@ -32,6 +37,21 @@ type Record struct {
 	Body   io.ReadCloser
 }
 
+// Signer returns the node that signed the announce of the version and the signature,
+// they're empty if the record has no such version.
+func (r *Record) Signer(version string) (nodeID, signature string) {
+	if cur := r.Current(); cur.Version() == version {
+		return cur.Announce().NodeID(), cur.Announce().Signature()
+	}
+	prev := r.Previous()
+	for i := prev.Len() - 1; i >= 0; i-- {
+		if ver := prev.At(i); ver.Version() == version {
+			return ver.Announce().NodeID(), ver.Announce().Signature()
+		}
+	}
+	return "", ""
+}
+
 type RecordCRUD interface {
 	CreateRecord(ctx context.Context, path string, body io.ReadCloser, opts ...CreateOptions) (*Record, error)
 	ReadRecord(ctx context.Context, path string, opts ...ReadOptions) (*Record, error)
@@ -42,16 +62,22 @@ type RecordCRUD interface {
 type CreateOptions struct {
 	UserMeta []byte
 	Size     int64
+	// ExpiresAt makes the version expire, the record is deleted on all nodes then.
+	ExpiresAt time.Time
 }
 
 type UpdateOptions struct {
 	UserMeta []byte
 	Size     int64
+	// ExpiresAt makes the version expire, the record is deleted on all nodes then.
+	ExpiresAt time.Time
 }
 
 type ReadOptions struct {
 	Version   string
 	NoContent bool
+	// AsOf selects the version that was current at the given time.
+	AsOf time.Time
 }
 
 type RecordWalkFunc func(path string, r *Record) error
@@ -59,41 +85,326 @@ type RecordWalkFunc func(path string, r *Record) error
 type PlanetaryRecordStore interface {
 	RecordCRUD
 
-	ExportRecords(ctx context.Context, wr io.Writer) error
+	ExportRecords(ctx context.Context, wr io.Writer, since time.Time) error
+	ExportSnapshot(ctx context.Context, wr io.Writer) error
+	BootstrapFrom(ctx context.Context, nodeID, token string) error
+	// ExportArchive writes records the query selects with blocks of their objects in the
+	// format, ArchiveCAR or ArchiveTar, ImportArchive imports such an archive.
+	ExportArchive(ctx context.Context, q *ArchiveQuery, format string, wr io.Writer) (*ArchiveManifest, error)
+	ImportArchive(ctx context.Context, format string, rd io.Reader) (*ArchiveImport, error)
+	// BackupIndex lists object versions of records and their owners in the plan of a
+	// coordinated backup, ExportBackupShard writes blocks of versions the node owns and
+	// ImportBackupShard restores blocks of the archive of a member, see BackupPlan.
+	BackupIndex(ctx context.Context, plan *BackupPlan) (*BackupIndex, error)
+	ExportBackupShard(ctx context.Context, plan *BackupPlan, wr io.Writer) (int, error)
+	ImportBackupShard(ctx context.Context, plan *BackupPlan, owner string, rd io.Reader) (*BackupImport, error)
+
+	CreateCheckpoint(name string) (*Checkpoint, error)
+	GetCheckpoint(name string) (*Checkpoint, error)
 	WalkRecords(ctx context.Context, root string, fn RecordWalkFunc) error
+	// WalkRecordsPage walks records in ID order starting at the cursor, up to limit of them
+	// if it's positive. It returns the cursor of the next page, empty if the walk is done.
+	WalkRecordsPage(ctx context.Context, cursor string, limit int, fn RecordWalkFunc) (string, error)
+	// WalkNamespacePage walks records of the namespace like WalkRecordsPage, over the namespace index.
+	WalkNamespacePage(ctx context.Context, ns, cursor string, limit int, fn RecordWalkFunc) (string, error)
 
 	Sync() error
 	IsReady() bool
 	WaitInbound(timeout time.Duration)
 	WaitOutbound(timeout time.Duration)
+	// ReplayOutbound queues announces of local writes left unpublished by the last run,
+	// see the outbound journal. It returns the number of queued announces.
+	ReplayOutbound(ctx context.Context) (int, error)
 	ReceiveEventAnnounce(event *EventAnnounce)
 	EmitEventAnnounce(event *EventAnnounce)
-	SendBeats(ctx context.Context, tickDur, infoDur time.Duration, ethAddr string)
+	// SendBeats sends beats as the BeatPolicy of the store sets, PeerLiveness scores peers
+	// by the ticks they sent.
+	SendBeats(ctx context.Context, ethAddr string)
+	PeerLiveness() []*PeerLiveness
 	CommitBeatReports(ctx context.Context, dur time.Duration)
+	// BeatReports builds beat reports from the beat infos kept, by Ethereum address.
+	BeatReports(ctx context.Context) (map[string]*BeatReport, error)
+	// WatchWORM loads WORM flags of namespaces, records of these are never changed once written.
+	WatchWORM(ctx context.Context, interval time.Duration)
+	WORMNamespaces() map[string]time.Time
+	// NodeUsage returns bytes served, bytes pinned and uptime reported by nodes in beat infos,
+	// of a single node if nodeID is set.
+	NodeUsage(ctx context.Context, nodeID string) ([]*NodeUsage, error)
+	// PublishLifecycle signs a lifecycle event of this node and publishes it to the swarm
+	// right away, bypassing the outbound queue.
+	PublishLifecycle(session string, state LifecycleState, version string)
+	// LifecycleEvents returns lifecycle events published by nodes since the given time,
+	// of a single node if nodeID is set.
+	LifecycleEvents(ctx context.Context, nodeID string, since time.Time) ([]*LifecycleEvent, error)
+	WatchPermissions(ctx context.Context, interval time.Duration)
+	PublishManifests(ctx context.Context, dur time.Duration)
+	// RecordChecksum returns the checksum of the record content, of the given version or
+	// the current one if it's empty.
+	RecordChecksum(ctx context.Context, path, version string) (*ManifestEntry, error)
+	// RecordProof returns the Merkle inclusion proof of the record in a manifest index
+	// of its namespace, checkpoint is the CID of the index or empty for the current one.
+	RecordProof(ctx context.Context, path, checkpoint string) (*RecordProof, error)
+	// EnforceRetention trims version history according to namespace retention policies.
+	EnforceRetention(ctx context.Context, interval time.Duration)
+	RetentionStatus() *RetentionStatus
+	// RunGC periodically unpins content that is no longer needed according to the GC policy.
+	RunGC(ctx context.Context, interval time.Duration)
+	// CollectGarbage runs a collection now, ErrGCRunning is returned if one is in progress.
+	CollectGarbage(ctx context.Context) (*GCReport, error)
+	GCStatus() *GCStatus
+	// TrackDiskUsage periodically measures disk usage of the node, writes and pins are
+	// refused while it's over the DiskBudget.
+	TrackDiskUsage(ctx context.Context, interval time.Duration)
+	DiskUsageStatus() *DiskUsageStatus
+	// Fsck checks that objects of records are pinned with all their blocks, with repair set
+	// missing blocks are fetched from peers. ErrFsckRunning is returned if one is in progress.
+	Fsck(ctx context.Context, repair bool) (*FsckReport, error)
+	// RepairShards periodically checks shards of erasure coded objects, fetches shards placed
+	// on this node and rebuilds lost ones.
+	RepairShards(ctx context.Context, interval time.Duration)
+	// CheckShards runs a repair now, ErrRepairRunning is returned if one is in progress.
+	CheckShards(ctx context.Context) (*ErasureStatus, error)
+	ErasureStatus() *ErasureStatus
+	// RepinLost periodically looks for pinned versions that miss blocks, e.g. after a loss of
+	// IPFS repo data, and refetches them from peers. Reads of such versions schedule them too.
+	RepinLost(ctx context.Context, interval time.Duration)
+	RepinStatus() *RepinStatus
+	// RunMirror applies the mirror policy when it changes, after syncs and every interval
+	// if it's positive, see MirrorPolicy. ApplyMirror runs a pass now.
+	RunMirror(ctx context.Context, interval time.Duration)
+	ApplyMirror(ctx context.Context) error
+	MirrorPolicy() *MirrorPolicy
+	// SetMirrorPolicy keeps the policy in the state store and applies it, an empty
+	// policy makes the node a full replica.
+	SetMirrorPolicy(ctx context.Context, policy *MirrorPolicy) error
+	MirrorStatus() *MirrorStatus
+	// RunColdTier offloads versions not read for a while to the cold store, see ColdTierPolicy.
+	// ApplyColdTier runs a pass now.
+	RunColdTier(ctx context.Context)
+	ApplyColdTier(ctx context.Context) error
+	ColdTierStatus() *ColdTierStatus
+	// RunReindexJobs runs rebuilds of secondary indexes requested by Reindex at a limited
+	// rate of records per second, rebuilds interrupted by a restart are resumed.
+	RunReindexJobs(ctx context.Context, rate int)
+	// Reindex starts a rebuild of the index from records, see Indexes.
+	Reindex(ctx context.Context, index string) (*ReindexJob, error)
+	ReindexStatus() []*ReindexJob
+	// RunAntiEntropy periodically compares records with a random peer and exchanges the ones
+	// gossip failed to deliver, see AntiEntropyPolicy.
+	RunAntiEntropy(ctx context.Context, policy *AntiEntropyPolicy)
+	AntiEntropyStatus() *AntiEntropyStatus
+	// DigestRecords and RecordEntries describe records to a peer in an anti-entropy round.
+	DigestRecords(ctx context.Context, namespaces []string) (*AntiEntropyDigest, error)
+	RecordEntries(ctx context.Context, namespaces []string, buckets []int) ([]*AntiEntropyEntry, error)
+	// ExportRecordsByID writes the records a peer pulls in an anti-entropy round.
+	ExportRecordsByID(ctx context.Context, wr io.Writer, ids []string, maxBytes int64) (int, error)
+	// AcceptPush imports records a peer pushes in an anti-entropy round.
+	AcceptPush(ctx context.Context, rd io.Reader) (int, error)
+	// DropVersions removes previous versions from the history of the record and unpins them.
+	DropVersions(ctx context.Context, path string) (int, error)
+	// Vouch writes a vouch of this node for the node, see VouchPolicy.
+	Vouch(ctx context.Context, nodeID string) (*Record, error)
+	// MintJoinToken issues a token new testnet nodes join with, the issuer vouches for them.
+	MintJoinToken(swarmKey string, bootstrap []string, ttl time.Duration) (*JoinToken, error)
+	// RedeemJoinToken vouches for the node of a join request, ErrJoinToken if it's refused.
+	RedeemJoinToken(ctx context.Context, req *JoinRequest) error
+	ListJoinTokens(ctx context.Context) ([]*JoinGrant, error)
+	RevokeJoinToken(id string) error
+	// Join redeems the token this node was initialized with at its issuer.
+	Join(ctx context.Context, token *JoinToken) error
+	// WatchVouches counts vouches and puts vouched nodes on probation.
+	WatchVouches(ctx context.Context, interval time.Duration)
+	// OfferTransfer, AcceptTransfer and WithdrawTransfer move ownership of scopes between
+	// writers, see ParseTransferScope.
+	OfferTransfer(ctx context.Context, scope, to string) (*Transfer, error)
+	AcceptTransfer(ctx context.Context, id string) (*Transfer, error)
+	WithdrawTransfer(ctx context.Context, id string) error
+	Transfers() []*Transfer
+	// TransferOwners returns owners of transferred scopes.
+	TransferOwners() map[string]string
+	// WatchTransfers replays transfers of ownership.
+	WatchTransfers(ctx context.Context, interval time.Duration)
+	// Expiries lists versions of records scheduled to expire, see CreateOptions.
+	Expiries(ctx context.Context) ([]*Expiry, error)
+	// WatchExpiries deletes records whose current version has expired.
+	WatchExpiries(ctx context.Context, interval time.Duration)
+	// RotateIdentity links the node ID to a new identity the node uses once restarted.
+	RotateIdentity(ctx context.Context) (*IdentityRotation, error)
+	// LoadIdentityLinks passes links of rotated identities to the authority.
+	LoadIdentityLinks(ctx context.Context) error
+	WatchIdentityLinks(ctx context.Context, interval time.Duration)
+	// RecordHistory returns the version log of a record from the state store.
+	RecordHistory(ctx context.Context, idOrPath string) (*RecordHistory, error)
+	// AllowRequest tells whether a request of the client is within the rate limit of the namespace.
+	AllowRequest(ns, client string) (bool, time.Duration)
+	// ShareRateLimits loads rate limits of namespaces and shares request counters between gateways.
+	ShareRateLimits(ctx context.Context, interval time.Duration)
+	RateLimitStats() *RateLimitStats
+	// ObserveRead accounts a client read of the record at path in SLIs of its namespace
+	// and in popularity of the record.
+	ObserveRead(path string, d time.Duration, err error)
+	// TrackPopularity keeps read counts of records across restarts, see PopularityOpt.
+	TrackPopularity(ctx context.Context)
+	// PopularRecords lists the most read records, with estimated reads.
+	PopularRecords(ctx context.Context, q *PopularQuery) (*PopularRecords, error)
+	// TrackSLOs loads objectives of namespaces, SLIs are tracked regardless of them.
+	TrackSLOs(ctx context.Context, interval time.Duration)
+	SLOReports() []*SLIReport
+	// WriteFence returns a *FenceError if local writes are fenced.
+	WriteFence() error
 
 	BadgerStats() *BadgerStats
+	// QueueStats reports how many announces wait in the inbound and outbound queues
+	// and the load of inbound workers.
+	QueueStats() *QueueStats
+	SyncStats() *SyncStats
+	// ListNamespaceKeys, RotateNamespaceKey, ExportNamespaceKey and ImportNamespaceKey manage
+	// keys of encrypted namespaces in the state store, see EncryptionPolicy.
+	ListNamespaceKeys(ctx context.Context) ([]*NamespaceKey, error)
+	RotateNamespaceKey(ctx context.Context, ns string) (*NamespaceKey, error)
+	ExportNamespaceKey(ctx context.Context, id string) (*NamespaceKey, error)
+	ImportNamespaceKey(ctx context.Context, key *NamespaceKey) error
+	// ListConflicts and DismissConflict manage write conflicts of records, see ConflictPolicy.
+	ListConflicts(ctx context.Context, all bool) ([]*Conflict, error)
+	DismissConflict(ctx context.Context, id string) (*Conflict, error)
+	// BeatStats reports when this node sent its last beats.
+	BeatStats() *BeatStats
+	// CircuitStates reports state of circuit breakers guarding fs and state reads.
+	CircuitStates() map[string]string
+
+	// RegisterHook adds a pre-commit hook that is run for every local write.
+	RegisterHook(name string, hook PreCommitHook, opts HookOptions) error
+	UnregisterHook(name string) bool
+	ListHooks() []HookInfo
+
+	// RegisterWebhook adds a webhook notified about record changes, see ChangeWebhook.
+	RegisterWebhook(hook *ChangeWebhook) error
+	UnregisterWebhook(name string) error
+	// ListWebhooks returns registered webhooks without their secrets.
+	ListWebhooks() []*ChangeWebhook
+	// DeliverWebhooks sends queued deliveries of changes to webhooks.
+	DeliverWebhooks(ctx context.Context)
+	// DeadLetters lists deliveries that failed all attempts.
+	DeadLetters() ([]*WebhookDelivery, error)
+	// RequeueDeadLetters queues the dead letter again, all of them if id is empty.
+	RequeueDeadLetters(id string) (int, error)
+	// PurgeDeadLetters drops the dead letter, all of them if id is empty.
+	PurgeDeadLetters(id string) (int, error)
+	WebhookStats() *WebhookStats
+
+	// PutScheduledTask creates or replaces a task run by the node on a schedule, see ScheduledTask.
+	PutScheduledTask(task *ScheduledTask) (*ScheduledTask, error)
+	DeleteScheduledTask(name string) error
+	ScheduledTasks() []*ScheduledTask
+	// RunScheduledTask runs the task now, ErrTaskRunning is returned if it's in progress.
+	RunScheduledTask(ctx context.Context, name string) (*TaskRun, error)
+	// TaskRuns returns the run history of the task, newest first, of all tasks if name is empty.
+	TaskRuns(ctx context.Context, name string, limit int) ([]*TaskRun, error)
+	// RegisterTaskAction adds an action scheduled tasks may run.
+	RegisterTaskAction(name string, action TaskAction)
+	TaskActions() []string
+	// ScheduleTasks runs scheduled tasks when they are due.
+	ScheduleTasks(ctx context.Context)
+
+	// Annotations returns annotations of the record, these are not part of its content.
+	Annotations(ctx context.Context, path string) (*RecordAnnotations, error)
+	// Annotate sets and removes annotations of the record without writing a new version.
+	Annotate(ctx context.Context, path string, set Annotations, remove []string) (*RecordAnnotations, error)
+	// AnnotationsOf returns annotations of the record by its ID, nil if there are none.
+	AnnotationsOf(id string) Annotations
+
+	// NegotiateProtocol keeps track of protocol versions present in the cluster.
+	NegotiateProtocol(ctx context.Context, interval time.Duration)
+	ProtocolStatus() *ProtocolStatus
+	// MonitorClock checks skew of the local clock against NTP and peers.
+	MonitorClock(ctx context.Context, interval time.Duration)
+	ClockStatus() *ClockStatus
+	// DedupStats reports how many inbound announces were dropped as duplicates.
+	DedupStats() *DedupStats
+
+	// DryRunWrite validates a local write the same way as the CRUD methods do and
+	// reports what would be written, nothing is committed nor gossiped.
+	DryRunWrite(ctx context.Context, op WriteOp, path string, body io.ReadCloser, opts ...CreateOptions) (*WritePlan, error)
+	// DryRun reports whether writes of contract operations are only simulated, see DryRunOpt.
+	// SimulatedWrites lists the writes skipped so far, oldest first.
+	DryRun() bool
+	SimulatedWrites() []*SimulatedWrite
+
+	// IndexRecords keeps the search index up to date, see SearchOpt.
+	IndexRecords(ctx context.Context, interval time.Duration)
+	// Search queries the search index, ErrSearchDisabled is returned if the node runs without it.
+	Search(ctx context.Context, q *SearchQuery) (*SearchResult, error)
+	SearchStats() *SearchStats
+
+	// Watch registers a watcher that is notified about record changes.
+	Watch(opts WatchOptions) (*Watcher, error)
+	WatchStats() *WatchStats
+
 	Close() error
 }
 
-func NewPlanetaryRecordStore(nodeID string, fileStore fs.PlanetaryFileStore, stateStore state.IndexedStore) (PlanetaryRecordStore, error) {
+func NewPlanetaryRecordStore(nodeID string, fileStore fs.PlanetaryFileStore,
+	stateStore state.IndexedStore, opts ...storeOpt) (PlanetaryRecordStore, error) {
+	options := defaultStoreOptions()
+	for _, o := range opts {
+		o(options)
+	}
 	outboundAnnounces := make(chan *EventAnnounce, 1024)
-	inboundAnnounces := make(chan *EventAnnounce, 1024)
 	r := &recordStore{
-		nodeID:   nodeID,
-		stateMux: new(sync.RWMutex),
-
-		fs: fileStore,
-		ss: stateStore,
+		nodeID:    nodeID,
+		stateMux:  new(sync.RWMutex),
+		opts:      options,
+		startedAt: time.Now(),
+
+		fs:         fileStore,
+		ss:         stateStore,
+		hooks:      newHookRegistry(),
+		webhooks:   newWebhookState(stateStore, options.WebhookAttempts, options.WebhookDeadTTL),
+		tasks:      newTaskState(stateStore),
+		fence:      newWriteFence(),
+		watches:    newWatchHub(fileStore),
+		search:     newSearchIndex(options.Search),
+		worm:       newWORMState(),
+		encryption: newEncryptionState(stateStore, options.Encryption),
+		conflicts:  newConflictState(stateStore, options.Conflicts),
+		transfers:  newTransferState(),
+		expiry:     newExpiryState(),
+		erasure:    newErasureState(),
+		cache:      options.Cache,
+		protocol:   newProtocolTracker(),
+		dedup:      newInboundDedup(stateStore, options.DedupWindow),
+		journal:    newOutboundJournal(stateStore),
+		clock:      newClockMonitor(options),
+
+		retention:   newRetentionState(),
+		gc:          newGCState(options.GC),
+		budget:      newDiskBudgetState(options.DiskBudget),
+		repin:       newRepinState(),
+		mirror:      newMirrorState(options.Mirror),
+		reindexer:   newReindexState(),
+		antiEntropy: newAntiEntropyState(),
+		rates:       newRateLimiter(nodeID),
+		slo:         newSLOTracker(),
+		popularity:  newPopularityTracker(options.PopularitySample),
+		beats:       newBeatState(options.Beats),
+		cold:        newColdTierState(options.ColdTier),
+		simulated:   newSimulatedLog(options.DryRun),
+
+		fsBreaker: newFileStoreBreaker(),
+		ssBreaker: newStateStoreBreaker(),
+
+		syncDurations: metrics.NewHistogramCounter(syncDurationBuckets...),
 
 		outboundWg:        new(sync.WaitGroup),
 		outboundPump:      pumpEventAnnounces(outboundAnnounces),
 		outboundAnnounces: outboundAnnounces,
 
-		inboundWg:        new(sync.WaitGroup),
-		inboundPump:      pumpEventAnnounces(inboundAnnounces),
-		inboundAnnounces: inboundAnnounces,
+		inbound: newInboundPool(options.InboundWorkers),
 	}
-	r.processInbound(4, 10*time.Minute)
+	r.loadWORM()
+	r.loadMirrorPolicy()
+	r.loadColdObjects()
+	r.processInbound(10 * time.Minute)
 	r.processOutbound(4, 10*time.Minute)
 
 	sub, err := r.fs.PubSub()
@@ -102,9 +413,17 @@ func NewPlanetaryRecordStore(nodeID string, fileStore fs.PlanetaryFileStore, sta
 		return r, nil
 	}
 	topics := []string{
-		EventRecordUpdate.String(),
 		EventBeatInfo.String(),
 		EventBeatTick.String(),
+		EventLifecycle.String(),
+	}
+	if len(options.Namespaces) > 0 {
+		// follow only the selected namespaces instead of the whole network's chatter
+		for _, ns := range options.Namespaces {
+			topics = append(topics, NamespaceTopic(EventRecordUpdate, ns))
+		}
+	} else {
+		topics = append(topics, EventRecordUpdate.String())
 	}
 	if err := sub.Subscribe(func(m *fs.Message) error {
 		if m.From == r.nodeID {
@@ -119,7 +438,7 @@ func NewPlanetaryRecordStore(nodeID string, fileStore fs.PlanetaryFileStore, sta
 		case EventUnknown:
 			return nil
 		case EventRecordUpdate:
-			if !isPublishAllowed(m.From) {
+			if !isPublishAllowed(m.From) && !r.onProbation(m.From) && !isTenant(m.From) && !r.transfers.isParty(m.From) {
 				log.Debugln("ignoring EventRecordUpdate from unauthorized node")
 				return nil
 			}
@@ -130,14 +449,16 @@ func NewPlanetaryRecordStore(nodeID string, fileStore fs.PlanetaryFileStore, sta
 				return nil
 			}
 			event.Announce = proto.ReadRootAnnounce(seg)
+			r.protocol.Observe(m.From, event.Announce.ProtocolVersion())
 			r.ReceiveEventAnnounce(event)
-		case EventBeatTick, EventBeatInfo:
+		case EventBeatTick, EventBeatInfo, EventLifecycle:
 			seg, err := capn.ReadFromPackedStream(bytes.NewReader(m.Data), nil)
 			if err != nil {
-				log.Warningln("failed to decode beat announce data:", err)
+				log.Warningln("failed to decode", event.Type.String(), "announce data:", err)
 				return nil
 			}
 			event.Announce = proto.ReadRootAnnounce(seg)
+			r.protocol.Observe(m.From, event.Announce.ProtocolVersion())
 			r.ReceiveEventAnnounce(event)
 		default:
 			log.Warningln("event not handled: %s", event.Type.String())
@@ -155,18 +476,63 @@ type recordStore struct {
 	nodeID   string
 	stateMux *sync.RWMutex
 	state    storeState
-
-	fs fs.PlanetaryFileStore
-	ss state.IndexedStore
+	opts     *storeOptions
+	// startedAt is reported as uptime in lifecycle events.
+	startedAt time.Time
+
+	fs         fs.PlanetaryFileStore
+	ss         state.IndexedStore
+	hooks      *hookRegistry
+	webhooks   *webhookState
+	tasks      *taskState
+	fence      *writeFence
+	watches    *watchHub
+	search     *searchIndex
+	worm       *wormState
+	encryption *encryptionState
+	conflicts  *conflictState
+	transfers  *transferState
+	expiry     *expiryState
+	erasure    *erasureState
+	cache      *cache.Cache
+	protocol   *protocolTracker
+	dedup      *inboundDedup
+	journal    *outboundJournal
+	clock      *clockMonitor
+
+	retention   *retentionState
+	gc          *gcState
+	budget      *diskBudgetState
+	repin       *repinState
+	mirror      *mirrorState
+	reindexer   *reindexState
+	antiEntropy *antiEntropyState
+	rates       *rateLimiter
+	slo         *sloTracker
+	popularity  *popularityTracker
+	beats       *beatState
+	cold        *coldTierState
+	simulated   *simulatedLog
+
+	fsBreaker *circuitBreaker
+	ssBreaker *circuitBreaker
+
+	syncing       int32
+	fscking       int32
+	syncs         uint64
+	syncFailures  uint64
+	syncDurations *metrics.HistogramCounter
+
+	lastBeatTick int64
+	lastBeatInfo int64
 
 	outboundWg          *sync.WaitGroup
 	outboundPump        chan *EventAnnounce
 	outboundAnnounces   chan *EventAnnounce
 	outboundWorkCounter uint64
+	outboundQueued      int64
 
-	inboundWg          *sync.WaitGroup
-	inboundPump        chan *EventAnnounce
-	inboundAnnounces   chan *EventAnnounce
+	inbound            *inboundPool
 	inboundWorkCounter uint64
 }
 
@@ -179,27 +545,38 @@ const (
 )
 
 func (r *recordStore) Close() error {
-	r.inboundPump <- &EventAnnounce{
-		Type: EventStopAnnounce,
-	}
+	r.inbound.close()
 	r.outboundPump <- &EventAnnounce{
 		Type: EventStopAnnounce,
 	}
 	return nil
 }
 
-var ErrNotSynced = errors.New("not synced")
+var (
+	ErrNotSynced   = errors.New("not synced")
+	ErrSyncRunning = errors.New("sync is running already")
+)
 
+// Sync fetches records of peers, it runs on start and may be run again on request.
 func (r *recordStore) Sync() error {
-	var syncCandidates []string
-	entries := authcenter.Default.Entries()
-	for _, e := range entries {
-		if e.Key == r.nodeID {
-			continue
-		} else if e.HasPermissions(authcenter.RecordWritePermission) {
-			syncCandidates = append(syncCandidates, e.Key)
-		}
+	if !atomic.CompareAndSwapInt32(&r.syncing, 0, 1) {
+		return ErrSyncRunning
+	}
+	defer atomic.StoreInt32(&r.syncing, 0)
+	startedAt := time.Now()
+	ctx, span := tracing.Start(context.Background(), "rs.Sync")
+	err := r.sync(ctx)
+	tracing.End(span, err)
+	r.observeSync(startedAt, err)
+	if err == nil {
+		// synced records are not pinned, a mirror pass pins those it selects
+		r.mirror.schedule()
 	}
+	return err
+}
+
+func (r *recordStore) sync(ctx context.Context) error {
+	syncCandidates := r.syncCandidates()
 	if len(syncCandidates) == 0 {
 		log.Warningln("no sync candidates found")
 		r.state = storeActiveState
@@ -207,7 +584,7 @@ func (r *recordStore) Sync() error {
 	} else {
 		log.Debugln("found sync candidates:", len(syncCandidates))
 	}
-	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Minute)
+	ctx, cancelFn := context.WithTimeout(ctx, 10*time.Minute)
 	defer cancelFn()
 	alive := r.aliveNodes(ctx, syncCandidates)
 	if len(alive) == 0 {
@@ -231,12 +608,23 @@ func (r *recordStore) Sync() error {
 	if len(alive) > 2 {
 		alive = alive[:2]
 	}
+	startedAt := time.Now().UTC()
+	since := r.syncSince()
+	var synced uint32
 	rC := make(chan *proto.Record, 100)
-	go r.collectRecords(ctx, alive, rC)
+	go r.collectRecords(ctx, alive, since, &synced, rC)
 	if err := r.startSync(ctx, rC); err != nil {
 		err = fmt.Errorf("failed to sync store: %v", err)
 		return err
 	}
+	// the checkpoint moves only if some peer exported all of its records
+	if atomic.LoadUint32(&synced) > 0 {
+		r.saveSyncCheckpoint(&SyncCheckpoint{
+			Time:  startedAt,
+			Since: since,
+			Peers: alive,
+		})
+	}
 	return nil
 }
 
@@ -256,80 +644,212 @@ func (r *recordStore) startSync(ctx context.Context, rC <-chan *proto.Record) er
 				log.Debugln("sync end")
 				r.setState(storeActiveState)
 				return nil
-			} else if err := validateRecord(record); err != nil {
-				vv, _ := record.MarshalJSON()
-				log.Debugf("failed to validate record in sync: %v, record: %s", err, string(vv))
-				continue
-			} else if ownerID := record.Current().Announce().NodeID(); !isPublishAllowed(ownerID) {
-				log.Debugf("publish not allowed for author of the announce in sync: %s", ownerID)
-				continue
 			}
-			k := state.NewKey(state.BucketRecords, record.IdBytes())
-			if err := r.ss.Update(k, proto.RecordModify(func(k *state.Key, v *proto.Record) (*proto.Record, error) {
-				if v == nil {
-					// if not exists, simply insert
-					log.Debugf("new record imported: %s", record.Id())
-					return record, nil
-				}
-				updNext, err := record.AnnounceEnvelope()
-				if err != nil {
-					log.Debugf("failed to decode record update envelope in sync: %v", err)
-					return nil, state.ErrNoUpdate
-				}
-				updCurrent, err := v.AnnounceEnvelope()
-				if err != nil {
-					log.Debugf("failed to decode current record in store: %v", err)
-					return nil, state.ErrNoUpdate
-				}
-				if updNext.Id() != updCurrent.Id() {
-					log.Warningf("announce envelope record ID mismatch: %s (next) != %s (prev)", updNext.Id(), updCurrent.Id())
-					return nil, state.ErrNoUpdate
-				}
-				if cmp := updNext.Compare(updCurrent); cmp > 0 {
-					// overwrite with new record, since its envelope is newer
-					log.Debugf("record imported, newer version: %s", record.Id())
-					return record, nil
-				} else if cmp == 0 {
-					// current envelopes are the same, compare lists
-					if record.Previous().Len() > v.Previous().Len() {
-						// overwrite if longer
-						log.Debugf("record imported, version chain longer: %s", record.Id())
-						return record, nil
+			// records that arrived already are imported together
+			batch := []*proto.Record{record}
+			closed := false
+		drain:
+			for len(batch) < syncBatchSize {
+				select {
+				case record, ok := <-rC:
+					if !ok {
+						closed = true
+						break drain
 					}
+					batch = append(batch, record)
+				default:
+					break drain
 				}
-				return nil, state.ErrNoUpdate
-			})); err != nil {
+			}
+			if err := r.importRecords(batch); err != nil {
+				return err
+			} else if closed {
+				log.Debugln("sync end")
+				r.setState(storeActiveState)
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// syncBatchSize limits records imported in a single state transaction.
+const syncBatchSize = 256
+
+type syncImport struct {
+	record      *proto.Record
+	prevVersion string
+	changed     bool
+	// worm refuses changes of a stored record, see ErrWORMNamespace.
+	worm bool
+	// conflicts resolves records changed both locally and on the peer, see ConflictPolicy.
+	conflicts *conflictState
+	conflict  *Conflict
+}
+
+// importRecords stores records received in sync, keeping the local ones that are newer.
+// Records are written in a single batch, split in halves if it doesn't fit.
+func (r *recordStore) importRecords(records []*proto.Record) error {
+	imports := make([]*syncImport, 0, len(records))
+	for _, record := range records {
+		if err := r.validateRecord(context.Background(), record); err == ErrNoNamespaceKey {
+			log.Debugf("skipping record of a confidential namespace without its key in sync: %s", record.Id())
+			continue
+		} else if err != nil {
+			vv, _ := record.MarshalJSON()
+			log.Warningf("failed to validate record in sync: %v, record: %s", err, string(vv))
+			continue
+		} else if ownerID := record.Current().Announce().NodeID(); !r.isWriteAllowed(ownerID, record.Path()) {
+			log.Debugf("publish not allowed for author of the announce in sync: %s", ownerID)
+			continue
+		} else if !r.followsNamespace(NamespaceOf(record.Path())) {
+			continue
+		}
+		worm := r.isWORM(record.Path())
+		if worm && record.Previous().Len() > 0 {
+			log.Debugf("skipping record of a write-once namespace that was changed: %s", record.Id())
+			continue
+		}
+		imports = append(imports, &syncImport{
+			record:    record,
+			worm:      worm,
+			conflicts: r.conflicts,
+		})
+	}
+	return r.importBatch(imports)
+}
+
+func (r *recordStore) importBatch(imports []*syncImport) error {
+	if len(imports) == 0 {
+		return nil
+	}
+	err := r.ss.Batch(func(tx state.Txn) error {
+		for _, imp := range imports {
+			imp.changed = false
+			k := state.RecordsKey(imp.record.IdBytes())
+			if err := tx.Update(k, proto.RecordModify(imp.modify)); err != nil {
 				return err
 			}
 		}
+		return nil
+	})
+	if err == state.ErrTxnTooBig && len(imports) > 1 {
+		half := len(imports) / 2
+		if err := r.importBatch(imports[:half]); err != nil {
+			return err
+		}
+		return r.importBatch(imports[half:])
+	} else if err != nil {
+		return err
+	}
+	for _, imp := range imports {
+		if imp.conflict != nil {
+			r.conflicts.save(context.Background(), imp.conflict)
+		}
+		if imp.changed {
+			r.notifyChange(r.syncChange(imp.record, imp.prevVersion))
+		}
 	}
 	return nil
 }
 
-func validateRecord(record *proto.Record) error {
+func (imp *syncImport) modify(k *state.Key, v *proto.Record) (*proto.Record, error) {
+	record := imp.record
+	if v == nil {
+		// if not exists, simply insert
+		log.Debugf("new record imported: %s", record.Id())
+		imp.changed = true
+		imp.prevVersion = ""
+		return record, nil
+	}
+	if imp.worm {
+		return nil, state.ErrNoUpdate
+	}
+	// announces of both records are validated and of the same record ID
+	current := v.Current().Version()
+	switch next := record.Current().Version(); {
+	case next == current:
+		// current versions are the same, compare lists
+		if record.Previous().Len() > v.Previous().Len() {
+			// overwrite if longer
+			log.Debugf("record imported, version chain longer: %s", record.Id())
+			return record, nil
+		}
+		return nil, state.ErrNoUpdate
+	case recordHasVersion(v, next):
+		// the local record is ahead
+		return nil, state.ErrNoUpdate
+	case recordHasVersion(record, current):
+		log.Debugf("record imported, newer version: %s", record.Id())
+		imp.changed = true
+		imp.prevVersion = current
+		return record, nil
+	}
+	// neither side has seen the current version of the other, the loser is kept as history
+	// of the winner. A retried batch finds the local version in the imported record then.
+	conflict, wins := imp.conflicts.decide(record.Id(), v.Path(), conflictVersion(v.Current()), conflictVersion(record.Current()))
+	imp.conflict = conflict
+	if !wins {
+		v.SetPrevious(proto.AppendRecordVersion(v.Previous(), record.Current()))
+		return v, nil
+	}
+	log.Debugf("record imported, conflicting version won: %s", record.Id())
+	record.SetPrevious(proto.AppendRecordVersion(record.Previous(), v.Current()))
+	imp.changed = true
+	imp.prevVersion = current
+	return record, nil
+}
+
+// validateRecord checks that every version of the record is signed by its announcer
+// and that the signed announce is of this record and version. ErrNoNamespaceKey is
+// returned as is for records of confidential namespaces this node can't open.
+func (r *recordStore) validateRecord(ctx context.Context, record *proto.Record) error {
 	if record == nil {
 		return errors.New("record is nil")
 	}
-	ann := record.Current().Announce()
-	ok, err := fs.VerifyDataSignature(ann.NodeID(), ann.Signature(), ann.Envelope())
-	if err != nil {
-		return fmt.Errorf("error checking current version signature: %v", err)
-	} else if !ok {
-		return errors.New("incorrect signature for current version announce")
+	if err := r.validateRecordVersion(ctx, record.Id(), record.Current()); err == ErrNoNamespaceKey {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("current version: %v", err)
 	}
 	list := record.Previous()
 	for i := 0; i < list.Len(); i++ {
-		ann = list.At(i).Announce()
-		ok, err := fs.VerifyDataSignature(ann.NodeID(), ann.Signature(), ann.Envelope())
-		if err != nil {
-			return fmt.Errorf("error checking version(%d) signature: %v", i, err)
-		} else if !ok {
-			return fmt.Errorf("incorrect signature for version(%d) announce", i)
+		if err := r.validateRecordVersion(ctx, record.Id(), list.At(i)); err == ErrNoNamespaceKey {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("version(%d): %v", i, err)
 		}
 	}
 	return nil
 }
 
+// validateRecordVersion checks the signature of the version announce, the CID and the ID
+// of a version are stored outside of the signed envelope and must match it.
+func (r *recordStore) validateRecordVersion(ctx context.Context, id string, ver proto.RecordVersion) error {
+	ann := ver.Announce()
+	ok, err := fs.VerifyDataSignature(ann.NodeID(), ann.Signature(), ann.Envelope())
+	if err != nil {
+		return fmt.Errorf("error checking signature: %v", err)
+	} else if !ok {
+		return errors.New("incorrect signature of announce")
+	} else if ann.Type() != proto.ANNOUNCETYPE_RECORDUPDATE {
+		return fmt.Errorf("announce of type %v is not a record update", ann.Type())
+	}
+	data, err := r.openEnvelope(ctx, ann.Envelope())
+	if err != nil {
+		return err
+	}
+	update, err := proto.UnpackEnvelopeRecordUpdate(data)
+	if err != nil {
+		return fmt.Errorf("failed to unpack record update: %v", err)
+	} else if update.Id() != id {
+		return fmt.Errorf("announce is of record %s", update.Id())
+	} else if update.Version() != ver.Version() {
+		return fmt.Errorf("announce is of version %s", update.Version())
+	}
+	return nil
+}
+
 func (r *recordStore) outboundWork() {
 	atomic.AddUint64(&r.outboundWorkCounter, 1)
 }
@@ -348,9 +868,18 @@ func (r *recordStore) processOutbound(workers int, emitTimeout time.Duration) {
 				time.Sleep(100 * time.Millisecond)
 			}
 			for ev := range r.outboundAnnounces {
-				if err := r.emitEvent(ev, emitTimeout); err != nil {
+				atomic.AddInt64(&r.outboundQueued, -1)
+				if ev.Type == EventRecordUpdate && r.fence.Err() != nil {
+					log.Debugln("dropping record announce, writes are fenced")
+					r.journal.ack(ev)
+					continue
+				}
+				err := r.emitOutbound(ev, emitTimeout)
+				if err != nil {
+					// journaled announces are published again on the next start
 					log.Warningln("error emitting event:", err)
 				} else {
+					r.journal.ack(ev)
 					r.outboundWork()
 				}
 			}
@@ -358,56 +887,6 @@ func (r *recordStore) processOutbound(workers int, emitTimeout time.Duration) {
 	}
 }
 
-func (r *recordStore) processInbound(workers int, timeout time.Duration) {
-	for i := 0; i < workers; i++ {
-		r.inboundWg.Add(1)
-		go func() {
-			defer r.inboundWg.Done()
-
-			for !r.IsReady() {
-				time.Sleep(100 * time.Millisecond)
-			}
-			for ev := range r.inboundAnnounces {
-				if err := r.handleEvent(ev, timeout); err != nil {
-					log.Warningln("error handling event:", err)
-				} else {
-					r.inboundWork()
-				}
-			}
-		}()
-	}
-}
-
-func (r *recordStore) SendBeats(ctx context.Context, tickDur, infoDur time.Duration, ethAddr string) {
-	start := time.Now()
-	session := ctx.Value("session_id").(string)
-	tickTimer := time.NewTimer(tickDur)
-	infoTimer := time.NewTimer(infoDur)
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-tickTimer.C:
-			ann := r.newBeatTickAnnounce(session)
-			r.EmitEventAnnounce(&EventAnnounce{
-				Type:     EventBeatTick,
-				Announce: *ann,
-			})
-			tickTimer.Reset(tickDur)
-		case <-infoTimer.C:
-			uptimeUnix := time.Since(start).Seconds()
-			outboundWork := atomic.LoadUint64(&r.outboundWorkCounter)
-			inboundWork := atomic.LoadUint64(&r.inboundWorkCounter)
-			ann := r.newBeatInfoAnnounce(session, ethAddr, int64(uptimeUnix), outboundWork, inboundWork)
-			r.EmitEventAnnounce(&EventAnnounce{
-				Type:     EventBeatInfo,
-				Announce: *ann,
-			})
-			infoTimer.Reset(infoDur)
-		}
-	}
-}
-
 type BeatReport struct {
 	Sessions []*BeatSessionReport `json:"sessions"`
 }
@@ -418,6 +897,8 @@ type BeatSessionReport struct {
 	Uptime       int    `json:"uptime_hours"`
 	InboundWork  uint64 `json:"in_work"`
 	OutboundWork uint64 `json:"out_work"`
+	BytesServed  uint64 `json:"bytes_served"`
+	BytesPinned  uint64 `json:"bytes_pinned"`
 }
 
 func (r *recordStore) CommitBeatReports(ctx context.Context, dur time.Duration) {
@@ -427,50 +908,38 @@ func (r *recordStore) CommitBeatReports(ctx context.Context, dur time.Duration)
 		case <-ctx.Done():
 			return
 		case <-t.C:
-			if !isPublishAllowed(r.nodeID) {
+			if r.opts.ReadOnly || !isPublishAllowed(r.nodeID) {
 				t.Reset(dur)
 				continue
 			}
-			reports := make(map[string]*BeatReport, 100)
-			b := state.NewBucket(state.BucketBeatInfos)
-			if _, err := r.ss.RangePeek(b,
-				proto.EnvelopeBeatInfoPeek(func(k *state.Key, v *proto.EnvelopeBeatInfo) error {
-					if v == nil {
-						return nil
-					}
-					ethAddr := v.EthereumAddrBytes()
-					if len(ethAddr) == 0 {
-						return nil
-					}
-					report, ok := reports[string(ethAddr)]
-					if !ok {
-						report = &BeatReport{}
-						reports[string(ethAddr)] = report
-					}
-					report.Sessions = append(report.Sessions, &BeatSessionReport{
-						SessionID:    v.Session(),
-						EthereumAddr: string(ethAddr),
-						Uptime:       int(v.UptimeUnix() / 3600),
-						InboundWork:  v.InboundWork(),
-						OutboundWork: v.OutboundWork(),
-					})
-					return nil
-				})); err != nil {
+			reports, err := r.BeatReports(ctx)
+			if err != nil {
 				log.Warningf("failed to count beat ticks: %v", err)
 			}
 			if !isPublishAllowed(r.nodeID) {
 				t.Reset(dur)
 				continue
 			}
+			addrs := make([]string, 0, len(reports))
+			for addr := range reports {
+				addrs = append(addrs, addr)
+			}
+			// a stable order keeps simulated commits comparable between runs
+			sort.Strings(addrs)
 
 			buf := new(bytes.Buffer)
 			enc := json.NewEncoder(buf)
-			for addr, report := range reports {
-				if err := enc.Encode(report); err != nil {
+			for _, addr := range addrs {
+				if err := enc.Encode(reports[addr]); err != nil {
 					log.Errorf("failed to encode beat report: %v", err)
 					return
 				}
 				exportPath := fmt.Sprintf("/beat_reports/%s.json", addr)
+				if r.simulated != nil {
+					r.simulate("beat_report", exportPath, buf.Bytes())
+					buf.Reset()
+					continue
+				}
 				_, err := r.CreateRecord(ctx, exportPath, ioutil.NopCloser(buf), CreateOptions{
 					Size: int64(buf.Len()),
 				})
@@ -492,12 +961,59 @@ func (r *recordStore) CommitBeatReports(ctx context.Context, dur time.Duration)
 	}
 }
 
+func (r *recordStore) BeatReports(ctx context.Context) (map[string]*BeatReport, error) {
+	reports := make(map[string]*BeatReport, 100)
+	b := state.NewBucket(state.BucketBeatInfos)
+	_, err := r.ss.RangePeek(ctx, b,
+		proto.EnvelopeBeatInfoPeek(func(k *state.Key, v *proto.EnvelopeBeatInfo) error {
+			if v == nil {
+				return nil
+			}
+			ethAddr := v.EthereumAddrBytes()
+			if len(ethAddr) == 0 {
+				return nil
+			}
+			report, ok := reports[string(ethAddr)]
+			if !ok {
+				report = &BeatReport{}
+				reports[string(ethAddr)] = report
+			}
+			report.Sessions = append(report.Sessions, &BeatSessionReport{
+				SessionID:    v.Session(),
+				EthereumAddr: string(ethAddr),
+				Uptime:       int(v.UptimeUnix() / 3600),
+				InboundWork:  v.InboundWork(),
+				OutboundWork: v.OutboundWork(),
+				BytesServed:  v.BytesServed(),
+				BytesPinned:  v.BytesPinned(),
+			})
+			return nil
+		}))
+	return reports, err
+}
+
+// emitOutbound emits the event, record updates are traced from the time they were queued
+// as part of the trace of the write.
+func (r *recordStore) emitOutbound(ev *EventAnnounce, timeout time.Duration) error {
+	if ev.Type != EventRecordUpdate {
+		return r.emitEvent(ev, timeout)
+	}
+	ctx := tracing.WithTraceParent(context.Background(), ev.Announce.TraceParent())
+	_, span := tracing.StartAt(ctx, "rs.outbound", ev.queuedAt,
+		attribute.String("announce.id", ev.Announce.Id()),
+		attribute.String("namespace", ev.Namespace),
+		attribute.Float64("queue.wait_s", time.Since(ev.queuedAt).Seconds()),
+	)
+	err := r.emitEvent(ev, timeout)
+	tracing.End(span, err)
+	return err
+}
+
 func (r *recordStore) emitEvent(ev *EventAnnounce, timeout time.Duration) error {
 	// ctx, cancelFn := context.WithTimeout(context.Background(), timeout)
 	// defer cancelFn()
 
 	wg := new(sync.WaitGroup)
-	defer wg.Wait()
 
 	buf := new(bytes.Buffer)
 	if _, err := ev.Announce.Segment.WriteToPacked(buf); err != nil {
@@ -505,25 +1021,34 @@ func (r *recordStore) emitEvent(ev *EventAnnounce, timeout time.Duration) error
 		return err
 	}
 
+	var pubErr error
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		pub, err := r.fs.PubSub()
 		if err != nil {
-			log.Warningf("failed to use pubsub: %v", err)
+			pubErr = fmt.Errorf("failed to use pubsub: %v", err)
 			return
 		}
-		// topic := EventToTopic(r.nodeID, ev.Type)
 		log.Debugf("emitting %s event to pubsub", ev.Type)
 		if err = pub.Publish(ev.Type.String(), buf.Bytes()); err != nil {
-			log.Warningf("pubsub publish failed: %v", err)
+			pubErr = fmt.Errorf("pubsub publish failed: %v", err)
+		}
+		if ev.Type == EventRecordUpdate && len(ev.Namespace) > 0 {
+			// nodes following only some namespaces listen on the namespace topics,
+			// floodsub delivers each topic to its subscribers only.
+			topic := NamespaceTopic(ev.Type, ev.Namespace)
+			if err = pub.Publish(topic, buf.Bytes()); err != nil {
+				pubErr = fmt.Errorf("pubsub publish to %s failed: %v", topic, err)
+			}
 		}
 	}()
-	return nil
+	wg.Wait()
+	return pubErr
 }
 
 func isPublishAllowed(nodeID string) bool {
-	return authcenter.Default.HasPermissions(nodeID, authcenter.RecordWritePermission)
+	return authcenter.HasPermissions(nodeID, authcenter.RecordWritePermission)
 }
 
 var (
@@ -539,7 +1064,12 @@ func (r *recordStore) handleEvent(ev *EventAnnounce, timeout time.Duration) erro
 	if ownerID == r.nodeID {
 		log.WithFields(fields).Debugln("skipping own event", ev.Type.String())
 		return nil
+	} else if version := ev.Announce.ProtocolVersion(); !acceptsAnnounce(version) {
+		log.WithFields(fields).Debugf("skipping %s with retired protocol version %d", ev.Type.String(), version)
+		return nil
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 	validate := func(ev *EventAnnounce) bool {
 		data := ev.Announce.Envelope()
 		ok, err := fs.VerifyDataSignature(ownerID, ev.Announce.Signature(), data)
@@ -560,37 +1090,83 @@ func (r *recordStore) handleEvent(ev *EventAnnounce, timeout time.Duration) erro
 	}
 	switch ev.Type {
 	case EventRecordUpdate:
-		if !isPublishAllowed(ownerID) {
+		// the update joins the trace of the write on the owner node
+		ctx, span := tracing.StartAt(tracing.WithTraceParent(ctx, ev.Announce.TraceParent()), "rs.inbound", ev.queuedAt,
+			attribute.String("announce.id", ev.Announce.Id()),
+			attribute.String("owner", ownerID),
+			attribute.Float64("announce.delay_s", ev.queuedAt.Sub(time.Unix(0, ev.Announce.Timestamp())).Seconds()),
+			attribute.Float64("queue.wait_s", time.Since(ev.queuedAt).Seconds()),
+		)
+		defer span.End()
+		fields = logging.WithMore(fields, tracing.Fields(ctx))
+		if r.dedup.Seen(ev.Announce.IdBytes()) {
+			log.WithFields(fields).Debugln("skipping duplicate record update event")
+			return nil
+		} else if !isPublishAllowed(ownerID) && !r.onProbation(ownerID) && !isTenant(ownerID) && !r.transfers.isParty(ownerID) {
 			log.WithFields(fields).Warningf("skipping record update event from an unauthorized source")
 			return nil
 		} else if !validate(ev) {
 			log.WithFields(fields).Warningf("skipping invalid record update event")
 			return nil
 		}
-		update, err := proto.UnpackEnvelopeRecordUpdate(ev.Announce.Envelope())
+		data, err := r.openEnvelope(ctx, ev.Announce.Envelope())
+		if err == ErrNoNamespaceKey {
+			// relayed by pubsub as is, this node doesn't serve the confidential namespace
+			log.WithFields(fields).Debugln("skipping sealed record update of a confidential namespace")
+			return nil
+		} else if err != nil {
+			log.WithFields(fields).Warningf("failed to open record update: %v", err)
+			return nil
+		}
+		update, err := proto.UnpackEnvelopeRecordUpdate(data)
 		if err != nil {
 			log.WithFields(fields).Errorf("failed to unpack record update: %v", err)
 			return nil
 		}
-		ctx, cancelFn := context.WithTimeout(context.Background(), timeout)
 		ref, err := r.fs.HeadObject(ctx, fs.ObjectRef{
 			Version: update.Version(),
 		})
-		cancelFn()
 		updateFields := logging.WithMore(fields, log.Fields{
 			"Version":     update.Version,
 			"VersionPrev": update.VersionPrev,
 		})
 		if err == fs.ErrNotFound {
 			log.WithFields(updateFields).Warningln("file not found on IPFS but announced")
+			r.dedup.Forget(ev.Announce.IdBytes())
 			return nil
 		} else if err != nil {
 			log.WithFields(updateFields).Errorln("failed to retrieve object: %v", err)
+			r.dedup.Forget(ev.Announce.IdBytes())
+			return nil
+		}
+		if ref.ID != update.Id() {
+			log.WithFields(updateFields).Warningf("skipping record update announced for record %s", update.Id())
 			return nil
+		} else if !r.isWriteAllowed(ownerID, ref.Path) {
+			log.WithFields(updateFields).Warningln("skipping record update of a node outside of its namespaces")
+			return nil
+		} else if r.isWORM(ref.Path) && (len(update.VersionPrev()) > 0 || ref.Meta().IsDeleted()) {
+			log.WithFields(updateFields).Warningln("skipping change of a record in a write-once namespace")
+			return nil
+		}
+		change := &RecordChange{
+			Op:      WriteUpdate,
+			ID:      ref.ID,
+			Path:    ref.Path,
+			Version: ref.Version,
+			NodeID:  ownerID,
+			Time:    time.Unix(0, ev.Announce.Timestamp()),
 		}
-		k := state.NewKey(state.BucketRecords, []byte(ref.ID))
-		if err := r.ss.Update(k, proto.RecordModify(func(k *state.Key, v *proto.Record) (*proto.Record, error) {
+		if ref.Meta().IsDeleted() {
+			change.Op = WriteDelete
+		}
+		k := state.RecordsKey([]byte(ref.ID))
+		var conflict *Conflict
+		var unchanged bool
+		err = r.ss.Update(ctx, k, proto.RecordModify(func(k *state.Key, v *proto.Record) (*proto.Record, error) {
+			conflict, unchanged = nil, false
 			if v == nil {
+				change.Op = WriteCreate
 				vv := proto.AutoNewRecord(capn.NewBuffer(nil))
 				v = &vv
 				v.SetId(ref.ID)
@@ -602,14 +1178,54 @@ func (r *recordStore) handleEvent(ev *EventAnnounce, timeout time.Duration) erro
 				v.SetCurrent(ver)
 				return v, nil
 			}
-			v.SetPrevious(proto.AppendRecordVersion(v.Previous(), v.Current()))
+			if !r.isWriteAllowed(ownerID, v.Path()) {
+				return nil, ErrProbationNamespace
+			} else if r.isWORM(v.Path()) {
+				return nil, ErrWORMNamespace
+			}
 			ver := proto.AutoNewRecordVersion(capn.NewBuffer(nil))
 			ver.SetAnnounce(ev.Announce)
 			ver.SetVersion(ref.Version)
+			if recordHasVersion(v, ref.Version) {
+				// fetched by sync already
+				unchanged = true
+				return nil, state.ErrNoUpdate
+			}
+			if prev := update.VersionPrev(); len(prev) > 0 && prev != v.Current().Version() && recordHasVersion(v, prev) {
+				// the writer hasn't seen the current version, see ConflictPolicy
+				var wins bool
+				conflict, wins = r.conflicts.decide(v.Id(), v.Path(), conflictVersion(v.Current()), conflictVersion(ver))
+				if !wins {
+					unchanged = true
+					v.SetPrevious(proto.AppendRecordVersion(v.Previous(), ver))
+					return v, nil
+				}
+			}
+			change.VersionPrevious = v.Current().Version()
+			v.SetPrevious(proto.AppendRecordVersion(v.Previous(), v.Current()))
 			v.SetCurrent(ver)
 			return v, nil
-		})); err != nil {
+		}))
+		if err == ErrWORMNamespace {
+			log.WithFields(updateFields).Warningln("skipping change of a record in a write-once namespace")
+			return nil
+		} else if err != nil {
 			log.Warningf("failed to update record: %v", err)
+		} else if conflict != nil {
+			r.conflicts.save(ctx, conflict)
+		}
+		if err == nil && !unchanged {
+			r.notifyChange(change)
+			r.slo.observeLag(NamespaceOf(ref.Path), time.Since(change.Time))
+		}
+		if !r.mirrorsContent(ref.Path) {
+			// the record is kept, its content is fetched from peers on reads
+			log.WithFields(updateFields).Debugln("skipping pin of object outside of the mirror policy")
+			return nil
+		} else if !r.budget.allowPin() {
+			// the record is kept, its content is fetched from peers on reads
+			log.WithFields(updateFields).Warningln("skipping pin of object over the disk budget")
+			return nil
 		}
 		if err := r.fs.PinObject(*ref); err != nil {
 			log.WithFields(updateFields).Errorln("failed to pin object: %v", err)
@@ -625,9 +1241,12 @@ func (r *recordStore) handleEvent(ev *EventAnnounce, timeout time.Duration) erro
 			log.WithFields(fields).Errorf("failed to unpack beat tick: %v", err)
 			return nil
 		}
-		k := state.NewKey(state.BucketBeatTicks, tick.IdBytes())
+		if nodeID := ev.Announce.NodeID(); nodeID != r.nodeID {
+			r.beats.observe(nodeID, time.Now())
+		}
+		k := state.BeatTicksKey(tick.IdBytes())
 		k.TTL = defaultBeatTickTTL
-		if err := r.ss.Update(k, proto.EnvelopeBeatTickModify(
+		if err := r.ss.Update(ctx, k, proto.EnvelopeBeatTickModify(
 			func(k *state.Key, v *proto.EnvelopeBeatTick) (*proto.EnvelopeBeatTick, error) {
 				if v == nil {
 					vv := proto.AutoNewEnvelopeBeatTick(capn.NewBuffer(nil))
@@ -661,7 +1280,7 @@ func (r *recordStore) handleEvent(ev *EventAnnounce, timeout time.Duration) erro
 		lowerBound := u.Time() - uint64(info.UptimeUnix()*1000)
 		var ticks int
 		b := state.NewBucket(state.BucketBeatTicks)
-		if _, err := r.ss.RangePeek(b,
+		if _, err := r.ss.RangePeek(ctx, b,
 			proto.EnvelopeBeatTickPeek(func(k *state.Key, v *proto.EnvelopeBeatTick) error {
 				if v == nil {
 					return nil
@@ -680,40 +1299,53 @@ func (r *recordStore) handleEvent(ev *EventAnnounce, timeout time.Duration) erro
 			})); err != nil {
 			log.Warningf("failed to count beat ticks: %v", err)
 		}
-		k := state.NewKey(state.BucketBeatInfos, info.SessionBytes())
+		k := state.BeatInfosKey(info.SessionBytes())
 		k.TTL = defaultBeatInfoTTL
-		if err := r.ss.Update(k, proto.EnvelopeBeatInfoModify(
+		var accepted bool
+		if err := r.ss.Update(ctx, k, proto.EnvelopeBeatInfoModify(
 			func(k *state.Key, v *proto.EnvelopeBeatInfo) (*proto.EnvelopeBeatInfo, error) {
+				accepted = false
 				if v == nil {
 					if ticks == 0 {
 						// no prior ticks
 						return nil, state.ErrNoUpdate
 					}
-					vv := proto.AutoNewEnvelopeBeatInfo(capn.NewBuffer(nil))
-					v = &vv
-					v.SetId(info.Id())
-					v.SetSession(info.Session())
-					v.SetEthereumAddr(info.EthereumAddr())
-					v.SetUptimeUnix(info.UptimeUnix())
-					v.SetOutboundWork(info.OutboundWork())
-					v.SetInboundWork(info.InboundWork())
-					return v, nil
-				} else if info.UptimeUnix() > v.UptimeUnix() {
-					if info.EthereumAddr() != v.EthereumAddr() {
-						// same session, different addr? go away
-						return nil, state.ErrNoUpdate
-					} else if ticks < 3 {
-						return nil, state.ErrNoUpdate
-					}
-					v.SetUptimeUnix(info.UptimeUnix())
-					v.SetOutboundWork(info.OutboundWork())
-					v.SetInboundWork(info.InboundWork())
-					return v, nil
+				} else if info.UptimeUnix() <= v.UptimeUnix() {
+					return nil, state.ErrNoUpdate
+				} else if info.EthereumAddr() != v.EthereumAddr() {
+					// same session, different addr? go away
+					return nil, state.ErrNoUpdate
+				} else if ticks < 3 {
+					return nil, state.ErrNoUpdate
 				}
-				return nil, state.ErrNoUpdate
+				// a new struct, infos stored by older nodes have no room for usage counters
+				vv := proto.AutoNewEnvelopeBeatInfo(capn.NewBuffer(nil))
+				vv.SetId(info.Id())
+				vv.SetSession(info.Session())
+				vv.SetEthereumAddr(info.EthereumAddr())
+				vv.SetUptimeUnix(info.UptimeUnix())
+				vv.SetOutboundWork(info.OutboundWork())
+				vv.SetInboundWork(info.InboundWork())
+				vv.SetBytesServed(info.BytesServed())
+				vv.SetBytesPinned(info.BytesPinned())
+				accepted = true
+				return &vv, nil
 			})); err != nil {
 			log.Warningf("failed to write beat info: %v", err)
+		} else if accepted {
+			r.recordUsage(ev.Announce.NodeID(), info)
+		}
+	case EventLifecycle:
+		if !validate(ev) {
+			log.WithFields(fields).Warningf("skipping invalid lifecycle event")
+			return nil
 		}
+		e, err := proto.UnpackEnvelopeNodeLifecycle(ev.Announce.Envelope())
+		if err != nil {
+			log.WithFields(fields).Errorf("failed to unpack lifecycle event: %v", err)
+			return nil
+		}
+		r.recordLifecycle(ownerID, e)
 	default:
 		log.Warningln("skipping unknown event:", ev.Type.String())
 	}
@@ -738,7 +1370,7 @@ func (r *recordStore) WaitOutbound(timeout time.Duration) {
 }
 
 func (r *recordStore) WaitInbound(timeout time.Duration) {
-	waitWG(r.inboundWg, timeout)
+	waitWG(r.inbound.wg, timeout)
 }
 
 func waitWG(wg *sync.WaitGroup, timeout time.Duration) {
@@ -762,7 +1394,7 @@ func (r *recordStore) ReceiveEventAnnounce(event *EventAnnounce) {
 	if event.Type == EventStopAnnounce {
 		return
 	}
-	r.inboundPump <- event
+	r.inbound.push(event)
 }
 
 // EmitEventAnnounce never blocks. Internal workers will eventually handle the events to emit.
@@ -770,50 +1402,110 @@ func (r *recordStore) EmitEventAnnounce(event *EventAnnounce) {
 	if event.Type == EventStopAnnounce {
 		return
 	}
+	if event.Type == EventRecordUpdate && !event.journaled {
+		r.journal.add(event)
+	}
+	atomic.AddInt64(&r.outboundQueued, 1)
+	if event.queuedAt.IsZero() {
+		event.queuedAt = time.Now()
+	}
 	r.outboundPump <- event
 }
 
 var (
 	ErrNotAuthorized  = errors.New("node is not authorized to create records")
+	ErrReadOnly       = errors.New("node is read-only")
 	ErrRecordExists   = errors.New("record exists")
 	ErrRecordNotFound = errors.New("record not found")
 )
 
+// checkProduce runs the checks shared by all local writes before any work is done.
+// Probationary nodes may write to paths of the probation namespace only, nodes with
+// namespace permissions to paths of their namespaces, transferred scopes aside.
+func (r *recordStore) checkProduce(path string) error {
+	if r.opts.ReadOnly {
+		return ErrReadOnly
+	} else if err := r.fence.Err(); err != nil {
+		return err
+	} else if !isPublishAllowed(r.nodeID) && !r.isWriteAllowed(r.nodeID, path) {
+		if _, ok := r.transfers.ownerOf(path); ok {
+			return ErrNotOwner
+		} else if r.onProbation(r.nodeID) {
+			return ErrProbationNamespace
+		} else if isTenant(r.nodeID) {
+			return ErrNamespaceNotAllowed
+		}
+		return ErrNotAuthorized
+	}
+	if err := r.clock.CheckProduce(); err != nil {
+		return err
+	}
+	return r.protocol.CheckProduce()
+}
+
 func (r *recordStore) CreateRecord(ctx context.Context, path string, body io.ReadCloser, opts ...CreateOptions) (*Record, error) {
-	if !isPublishAllowed(r.nodeID) {
-		return nil, ErrNotAuthorized
+	ctx, span := tracing.Start(ctx, "rs.CreateRecord", attribute.String("path", path))
+	rec, err := r.createRecord(ctx, path, body, opts...)
+	tracing.End(span, err)
+	return rec, err
+}
+
+func (r *recordStore) createRecord(ctx context.Context, path string, body io.ReadCloser, opts ...CreateOptions) (*Record, error) {
+	if err := r.checkProduce(path); err != nil {
+		return nil, err
+	} else if err := r.budget.CheckProduce(); err != nil {
+		return nil, err
 	}
 	defer r.inboundWork()
+	var size int64
+	var userMeta []byte
+	var expiresAt time.Time
+	if len(opts) > 0 {
+		size = opts[0].Size
+		userMeta = opts[0].UserMeta
+		expiresAt = opts[0].ExpiresAt
+	}
 	id, err := r.findRecordID(ctx, path, "")
 	if len(id) > 0 {
 		return nil, ErrRecordExists
 	} else if err != ErrRecordNotFound {
 		return nil, err
 	}
-	id = proto.NewID()
-	k := state.NewKey(state.BucketRecords, []byte(id))
-	var size int64
-	var userMeta []byte
-	if len(opts) > 0 {
-		size = opts[0].Size
-		userMeta = opts[0].UserMeta
+	req := &WriteRequest{
+		Op:       WriteCreate,
+		NodeID:   r.nodeID,
+		Path:     path,
+		UserMeta: userMeta,
 	}
+	body, err = r.hooks.Apply(ctx, req, body)
+	if err != nil {
+		return nil, err
+	} else if req.Body != nil {
+		size = int64(len(req.Body))
+	}
+	userMeta = req.UserMeta
+	id = proto.NewID()
+	k := state.RecordsKey([]byte(id))
 
 	var ann *proto.Announce
 	rec := &Record{}
-	if err := r.ss.Update(k, proto.RecordModify(func(k *state.Key, v *proto.Record) (*proto.Record, error) {
+	if err := r.ss.Update(ctx, k, proto.RecordModify(func(k *state.Key, v *proto.Record) (*proto.Record, error) {
 		if v != nil {
 			return v, ErrRecordExists
 		}
-		ref, err := r.fs.PutObject(ctx, fs.ObjectRef{
-			ID:   id,
-			Path: path,
-			Size: size,
+		ref, err := r.putObject(ctx, fs.ObjectRef{
+			ID:        id,
+			Path:      path,
+			Size:      size,
+			ExpiresAt: expiresAt,
 		}, userMeta, body)
 		if err != nil {
 			return nil, err
 		}
-		ann = r.newRecordUpdateAnnounce(id, ref.Version, "")
+		ann, err = r.newRecordUpdateAnnounce(ctx, path, id, ref.Version, "")
+		if err != nil {
+			return nil, err
+		}
 		rec.Record = proto.AutoNewRecord(capn.NewBuffer(nil))
 		rec.Record.SetId(ref.ID)
 		rec.Record.SetPath(ref.Path)
@@ -829,20 +1521,39 @@ func (r *recordStore) CreateRecord(ctx context.Context, path string, body io.Rea
 		return nil, err
 	} else if ann != nil {
 		r.EmitEventAnnounce(&EventAnnounce{
-			Type:     EventRecordUpdate,
-			Announce: *ann,
+			Type:      EventRecordUpdate,
+			Announce:  *ann,
+			Namespace: NamespaceOf(rec.Path()),
 		})
+		r.notifyChange(r.localChange(WriteCreate, rec, ann, ""))
 	} else {
 		log.Errorln("record updated but the announce is empty")
 	}
 	return rec, nil
 }
 
+func (r *recordStore) CircuitStates() map[string]string {
+	return map[string]string{
+		r.fsBreaker.name: r.fsBreaker.State(),
+		r.ssBreaker.name: r.ssBreaker.State(),
+	}
+}
+
+func (r *recordStore) RegisterHook(name string, hook PreCommitHook, opts HookOptions) error {
+	return r.hooks.Register(name, hook, opts)
+}
+
+func (r *recordStore) UnregisterHook(name string) bool {
+	return r.hooks.Unregister(name)
+}
+
+func (r *recordStore) ListHooks() []HookInfo {
+	return r.hooks.List()
+}
+
 func (r *recordStore) findRecordID(ctx context.Context, path, version string) (string, error) {
 	if len(version) > 0 {
-		if ref, err := r.fs.HeadObject(ctx, fs.ObjectRef{
-			Version: version,
-		}); err == nil && len(ref.ID) > 0 {
+		if ref, err := r.headVersion(ctx, version); err == nil && len(ref.ID) > 0 {
 			return ref.ID, err
 		}
 	}
@@ -850,10 +1561,15 @@ func (r *recordStore) findRecordID(ctx context.Context, path, version string) (s
 		// path parsed as a valid ULID
 		return path, nil
 	}
+	if v, ok := r.cache.Get(cache.Paths, path); ok {
+		return v.(string), nil
+	}
 	b := state.NewBucket(state.BucketRecords)
 	var id string
-	_, err := r.ss.RangePeek(b, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
-		if v.Path() == path {
+	_, err := r.ss.RangePeek(ctx, b, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		} else if v.Path() == path {
 			id = v.Id()
 			return state.ErrRangeStop
 		}
@@ -864,42 +1580,104 @@ func (r *recordStore) findRecordID(ctx context.Context, path, version string) (s
 	} else if len(id) == 0 {
 		return "", ErrRecordNotFound
 	}
+	r.cache.Set(cache.Paths, path, id)
 	return id, nil
 }
 
-func (r *recordStore) UpdateRecord(ctx context.Context, path string, body io.ReadCloser, opts ...UpdateOptions) (*Record, error) {
-	if !isPublishAllowed(r.nodeID) {
-		return nil, ErrNotAuthorized
+// headVersion returns metadata of the version, cached since versions never change.
+func (r *recordStore) headVersion(ctx context.Context, version string) (*fs.ObjectRef, error) {
+	if v, ok := r.cache.Get(cache.Objects, version); ok {
+		return copyObjectRef(v.(*fs.ObjectRef)), nil
 	}
-	defer r.inboundWork()
-	id, err := r.findRecordID(ctx, path, "")
+	ref, err := r.fs.HeadObject(ctx, fs.ObjectRef{
+		Version: version,
+	})
 	if err != nil {
 		return nil, err
 	}
-	k := state.NewKey(state.BucketRecords, []byte(id))
+	r.cache.Set(cache.Objects, version, copyObjectRef(ref))
+	return ref, nil
+}
+
+// copyObjectRef keeps callers from sharing refs with the cache.
+func copyObjectRef(ref *fs.ObjectRef) *fs.ObjectRef {
+	c := &fs.ObjectRef{
+		ID:              ref.ID,
+		Path:            ref.Path,
+		Size:            ref.Size,
+		Version:         ref.Version,
+		VersionPrevious: ref.VersionPrevious,
+		VersionOffset:   ref.VersionOffset,
+		ExpiresAt:       ref.ExpiresAt,
+	}
+	c.SetMeta(ref.Meta())
+	return c
+}
+
+func (r *recordStore) UpdateRecord(ctx context.Context, path string, body io.ReadCloser, opts ...UpdateOptions) (*Record, error) {
+	ctx, span := tracing.Start(ctx, "rs.UpdateRecord", attribute.String("path", path))
+	rec, err := r.updateRecord(ctx, path, body, opts...)
+	tracing.End(span, err)
+	return rec, err
+}
+
+func (r *recordStore) updateRecord(ctx context.Context, path string, body io.ReadCloser, opts ...UpdateOptions) (*Record, error) {
+	if err := r.checkProduce(path); err != nil {
+		return nil, err
+	} else if r.isWORM(path) {
+		return nil, ErrWORMNamespace
+	} else if err := r.budget.CheckProduce(); err != nil {
+		return nil, err
+	}
+	defer r.inboundWork()
 	var size int64
 	var userMeta []byte
+	var expiresAt time.Time
 	if len(opts) > 0 {
 		size = opts[0].Size
 		userMeta = opts[0].UserMeta
+		expiresAt = opts[0].ExpiresAt
+	}
+	id, err := r.findRecordID(ctx, path, "")
+	if err != nil {
+		return nil, err
+	}
+	req := &WriteRequest{
+		Op:       WriteUpdate,
+		NodeID:   r.nodeID,
+		Path:     path,
+		UserMeta: userMeta,
+	}
+	body, err = r.hooks.Apply(ctx, req, body)
+	if err != nil {
+		return nil, err
+	} else if req.Body != nil {
+		size = int64(len(req.Body))
 	}
+	userMeta = req.UserMeta
+	k := state.RecordsKey([]byte(id))
 
 	var ann *proto.Announce
+	var prevVersion string
 	rec := &Record{}
-	if err := r.ss.Update(k, proto.RecordModify(func(k *state.Key, v *proto.Record) (*proto.Record, error) {
+	if err := r.ss.Update(ctx, k, proto.RecordModify(func(k *state.Key, v *proto.Record) (*proto.Record, error) {
 		if v == nil {
 			return nil, ErrRecordNotFound
 		}
-		ref, err := r.fs.PutObject(ctx, fs.ObjectRef{
+		ref, err := r.putObject(ctx, fs.ObjectRef{
 			ID:              v.Id(),
 			Path:            path,
 			VersionPrevious: v.Current().Version(),
 			Size:            size,
+			ExpiresAt:       expiresAt,
 		}, userMeta, body)
 		if err != nil {
 			return nil, err
 		}
-		ann = r.newRecordUpdateAnnounce(id, ref.Version, v.Current().Version())
+		prevVersion = v.Current().Version()
+		if ann, err = r.newRecordUpdateAnnounce(ctx, path, id, ref.Version, prevVersion); err != nil {
+			return nil, err
+		}
 		v.SetPrevious(proto.AppendRecordVersion(v.Previous(), v.Current()))
 		ver := proto.AutoNewRecordVersion(capn.NewBuffer(nil))
 		ver.SetAnnounce(*ann)
@@ -913,9 +1691,11 @@ func (r *recordStore) UpdateRecord(ctx context.Context, path string, body io.Rea
 		return nil, err
 	} else if ann != nil {
 		r.EmitEventAnnounce(&EventAnnounce{
-			Type:     EventRecordUpdate,
-			Announce: *ann,
+			Type:      EventRecordUpdate,
+			Announce:  *ann,
+			Namespace: NamespaceOf(rec.Path()),
 		})
+		r.notifyChange(r.localChange(WriteUpdate, rec, ann, prevVersion))
 	} else {
 		log.Errorln("record updated but the announce is empty")
 	}
@@ -923,19 +1703,36 @@ func (r *recordStore) UpdateRecord(ctx context.Context, path string, body io.Rea
 }
 
 func (r *recordStore) DeleteRecord(ctx context.Context, path string) (*Record, error) {
-	if !isPublishAllowed(r.nodeID) {
-		return nil, ErrNotAuthorized
+	ctx, span := tracing.Start(ctx, "rs.DeleteRecord", attribute.String("path", path))
+	rec, err := r.deleteRecord(ctx, path)
+	tracing.End(span, err)
+	return rec, err
+}
+
+func (r *recordStore) deleteRecord(ctx context.Context, path string) (*Record, error) {
+	if err := r.checkProduce(path); err != nil {
+		return nil, err
+	} else if r.isWORM(path) {
+		return nil, ErrWORMNamespace
 	}
 	defer r.inboundWork()
 	id, err := r.findRecordID(ctx, path, "")
 	if err != nil {
 		return nil, err
 	}
-	k := state.NewKey(state.BucketRecords, []byte(id))
+	if _, err := r.hooks.Apply(ctx, &WriteRequest{
+		Op:     WriteDelete,
+		NodeID: r.nodeID,
+		Path:   path,
+	}, nil); err != nil {
+		return nil, err
+	}
+	k := state.RecordsKey([]byte(id))
 
 	var ann *proto.Announce
+	var prevVersion string
 	rec := &Record{}
-	if err := r.ss.Update(k, proto.RecordModify(func(k *state.Key, v *proto.Record) (*proto.Record, error) {
+	if err := r.ss.Update(ctx, k, proto.RecordModify(func(k *state.Key, v *proto.Record) (*proto.Record, error) {
 		if v == nil {
 			return nil, ErrRecordNotFound
 		}
@@ -958,7 +1755,10 @@ func (r *recordStore) DeleteRecord(ctx context.Context, path string) (*Record, e
 		if err != nil {
 			return nil, err
 		}
-		ann = r.newRecordUpdateAnnounce(id, ref.Version, v.Current().Version())
+		prevVersion = v.Current().Version()
+		if ann, err = r.newRecordUpdateAnnounce(ctx, path, id, ref.Version, prevVersion); err != nil {
+			return nil, err
+		}
 		v.SetPrevious(proto.AppendRecordVersion(v.Previous(), v.Current()))
 		ver := proto.AutoNewRecordVersion(capn.NewBuffer(nil))
 		ver.SetAnnounce(*ann)
@@ -973,9 +1773,11 @@ func (r *recordStore) DeleteRecord(ctx context.Context, path string) (*Record, e
 	}
 	if ann != nil {
 		r.EmitEventAnnounce(&EventAnnounce{
-			Type:     EventRecordUpdate,
-			Announce: *ann,
+			Type:      EventRecordUpdate,
+			Announce:  *ann,
+			Namespace: NamespaceOf(rec.Path()),
 		})
+		r.notifyChange(r.localChange(WriteDelete, rec, ann, prevVersion))
 	}
 	return rec, nil
 }
@@ -999,10 +1801,12 @@ func (r *recordStore) newBeatTickAnnounce(session string) *proto.Announce {
 	a.SetSignature(hex.EncodeToString(sig))
 	a.SetTimestamp(time.Now().UnixNano())
 	a.SetNodeID(r.nodeID)
+	a.SetProtocolVersion(ProtocolVersion)
 	return &a
 }
 
-func (r *recordStore) newBeatInfoAnnounce(session string, ethAddr string, uptimeUnix int64, announcesN, requestsN uint64) *proto.Announce {
+func (r *recordStore) newBeatInfoAnnounce(session string, ethAddr string, uptimeUnix int64,
+	announcesN, requestsN, bytesServed, bytesPinned uint64) *proto.Announce {
 	e := proto.AutoNewEnvelopeBeatInfo(capn.NewBuffer(nil))
 	e.SetId(proto.NewID())
 	e.SetSession(session)
@@ -1010,6 +1814,8 @@ func (r *recordStore) newBeatInfoAnnounce(session string, ethAddr string, uptime
 	e.SetUptimeUnix(uptimeUnix)
 	e.SetOutboundWork(announcesN)
 	e.SetInboundWork(requestsN)
+	e.SetBytesServed(bytesServed)
+	e.SetBytesPinned(bytesPinned)
 	buf := new(bytes.Buffer)
 	if _, err := e.Segment.WriteToPacked(buf); err != nil {
 		panic(fmt.Sprintf("failed to pack data: %v", err))
@@ -1025,10 +1831,13 @@ func (r *recordStore) newBeatInfoAnnounce(session string, ethAddr string, uptime
 	a.SetSignature(hex.EncodeToString(sig))
 	a.SetTimestamp(time.Now().UnixNano())
 	a.SetNodeID(r.nodeID)
+	a.SetProtocolVersion(ProtocolVersion)
 	return &a
 }
 
-func (r *recordStore) newRecordUpdateAnnounce(id, ver, verPrev string) *proto.Announce {
+// newRecordUpdateAnnounce returns the announce of a new version of the record at path,
+// its envelope is sealed if the namespace is confidential.
+func (r *recordStore) newRecordUpdateAnnounce(ctx context.Context, path, id, ver, verPrev string) (*proto.Announce, error) {
 	e := proto.AutoNewEnvelopeRecordUpdate(capn.NewBuffer(nil))
 	e.SetId(id)
 	e.SetVersion(ver)
@@ -1037,92 +1846,195 @@ func (r *recordStore) newRecordUpdateAnnounce(id, ver, verPrev string) *proto.An
 	if _, err := e.Segment.WriteToPacked(buf); err != nil {
 		panic(fmt.Sprintf("failed to pack data: %v", err))
 	}
-	sig, err := r.fs.SignData(r.nodeID, buf.Bytes())
+	data, err := r.sealEnvelope(ctx, NamespaceOf(path), buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal record update: %v", err)
+	}
+	sig, err := r.fs.SignData(r.nodeID, data)
 	if err != nil {
 		panic(fmt.Sprintf("failed to use FS signer: %v", err))
 	}
 	a := proto.AutoNewAnnounce(capn.NewBuffer(nil))
 	a.SetId(proto.NewID())
 	a.SetType(proto.ANNOUNCETYPE_RECORDUPDATE)
-	a.SetEnvelope(buf.Bytes())
+	a.SetEnvelope(data)
 	a.SetSignature(hex.EncodeToString(sig))
 	a.SetTimestamp(time.Now().UnixNano())
 	a.SetNodeID(r.nodeID)
-	return &a
+	a.SetProtocolVersion(ProtocolVersion)
+	a.SetTraceParent(tracing.TraceParent(ctx))
+	return &a, nil
 }
 
 func (r *recordStore) ReadRecord(ctx context.Context, path string, opts ...ReadOptions) (*Record, error) {
+	ctx, span := tracing.Start(ctx, "rs.ReadRecord", attribute.String("path", path))
+	rec, err := r.readRecord(ctx, path, opts...)
+	tracing.End(span, err)
+	return rec, err
+}
+
+func (r *recordStore) readRecord(ctx context.Context, path string, opts ...ReadOptions) (*Record, error) {
 	var version string
 	var noContent bool
+	var asOf time.Time
 	if len(opts) > 0 {
 		version = opts[0].Version
 		noContent = opts[0].NoContent
+		asOf = opts[0].AsOf
 	}
 	defer r.inboundWork()
-	id, err := r.findRecordID(ctx, path, version)
-	if err != nil {
-		return nil, err
+	type recordLookup struct {
+		rec        *Record
+		reqVersion string
 	}
-	k := state.NewKey(state.BucketRecords, []byte(id))
-	rec := &Record{}
-	var reqVersion string
-	if err = r.ss.View(k, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
-		if v == nil {
-			return ErrRecordNotFound
+	v, err := r.ssBreaker.Do(ctx, func(ctx context.Context) (interface{}, error) {
+		id, err := r.findRecordID(ctx, path, version)
+		if err != nil {
+			return nil, err
 		}
-		// careful: a deep copy might be required
-		rec.Record = *v
-		reqVersion = v.Current().Version()
-		return nil
-	})); err != nil && err != ErrRecordNotFound {
-		log.Warningln(err)
+		k := state.RecordsKey([]byte(id))
+		res := &recordLookup{
+			rec: &Record{},
+		}
+		if err = r.ss.View(ctx, k, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+			if v == nil {
+				return ErrRecordNotFound
+			}
+			// careful: a deep copy might be required
+			res.rec.Record = *v
+			res.reqVersion = v.Current().Version()
+			return nil
+		})); err != nil && err != ErrRecordNotFound {
+			log.Warningln(err)
+		}
+		return res, nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	rec := v.(*recordLookup).rec
+	reqVersion := v.(*recordLookup).reqVersion
 	if len(version) > 0 {
 		reqVersion = version
+	} else if !asOf.IsZero() && len(reqVersion) > 0 {
+		reqVersion = versionAsOf(rec, asOf)
 	}
 	if len(reqVersion) == 0 {
 		return nil, ErrRecordNotFound
 	}
+	r.cold.touch(reqVersion)
+	if err := r.rehydrate(ctx, reqVersion); err != nil {
+		// peers may have the content still
+		log.WithField("version", reqVersion).Warningf("failed to rehydrate version from the cold tier: %v", err)
+	}
 	if noContent {
-		ref, err := r.fs.HeadObject(ctx, fs.ObjectRef{
-			Version: reqVersion,
+		v, err := r.fsBreaker.Do(ctx, func(ctx context.Context) (interface{}, error) {
+			return r.headVersion(ctx, reqVersion)
 		})
 		if err == fs.ErrNotFound {
+			r.repin.schedule(reqVersion, false)
 			return nil, ErrRecordNotFound
 		} else if err != nil {
 			return nil, err
+		}
+		ref := v.(*fs.ObjectRef)
+		if versionExpired(ref.Meta()) {
+			return nil, ErrRecordNotFound
 		} else if ref.Meta().IsDeleted() {
 			rec.Object = *ref
 			return rec, ErrRecordNotFound
 		}
 		rec.Object = *ref
 	} else {
-		obj, err := r.fs.GetObject(ctx, fs.ObjectRef{
-			Version: reqVersion,
+		// blocks of the body are fetched while it's read, after the breaker returns, so the
+		// fetch is bound to the context of the caller and stops once the body is closed
+		bodyCtx, cancelBody := context.WithCancel(ctx)
+		v, err := r.fsBreaker.Do(ctx, func(_ context.Context) (interface{}, error) {
+			return r.fs.GetObject(bodyCtx, fs.ObjectRef{
+				Version: reqVersion,
+			})
 		})
 		if err == fs.ErrNotFound {
+			cancelBody()
+			r.repin.schedule(reqVersion, false)
 			return nil, ErrRecordNotFound
 		} else if err != nil {
+			// a call abandoned by the breaker stops as well
+			cancelBody()
 			return nil, err
+		}
+		obj := v.(*fs.Object)
+		if versionExpired(obj.Meta) {
+			if obj.Body != nil {
+				obj.Body.Close()
+			}
+			cancelBody()
+			return nil, ErrRecordNotFound
 		} else if obj.Meta.IsDeleted() {
+			cancelBody()
 			rec.Object = obj.ObjectRef
 			return rec, ErrRecordNotFound
 		}
+		if err := r.openObject(ctx, obj); err != nil {
+			cancelBody()
+			return nil, err
+		}
 		rec.Object = obj.ObjectRef
-		rec.Body = obj.Body
+		if obj.Body == nil {
+			cancelBody()
+			return rec, nil
+		}
+		rec.Body = closeWithCancel(obj.Body, cancelBody)
 	}
 	return rec, nil
 }
 
+// cancelReadCloser stops fetches of the body once it's closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+
+	cancel context.CancelFunc
+}
+
+func (c *cancelReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// closeWithCancel binds cancel to Close of the body, bodies served from the mmap
+// cache stay seekable.
+func closeWithCancel(body io.ReadCloser, cancel context.CancelFunc) io.ReadCloser {
+	rc := &cancelReadCloser{
+		ReadCloser: body,
+		cancel:     cancel,
+	}
+	if seeker, ok := body.(io.Seeker); ok {
+		return struct {
+			*cancelReadCloser
+			io.Seeker
+		}{rc, seeker}
+	}
+	return rc
+}
+
 var ErrWalkStop = errors.New("walk stop")
 
 func (r *recordStore) WalkRecords(ctx context.Context, root string, fn RecordWalkFunc) error {
+	_, err := r.WalkRecordsPage(ctx, "", 0, fn)
+	return err
+}
+
+func (r *recordStore) WalkRecordsPage(ctx context.Context, cursor string, limit int, fn RecordWalkFunc) (string, error) {
 	defer r.inboundWork()
 	b := state.NewBucket(state.BucketRecords, &state.RangeOptions{
-		Offset: []byte(root),
+		Offset: []byte(cursor),
+		Limit:  limit,
 	})
-	_, err := r.ss.RangePeek(b, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
-		if err := fn(v.Path(), &Record{
+	next, err := r.ss.RangePeek(ctx, b, proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		} else if err := fn(v.Path(), &Record{
 			Record: *v,
 		}); err == ErrWalkStop {
 			return state.ErrRangeStop
@@ -1131,16 +2043,45 @@ func (r *recordStore) WalkRecords(ctx context.Context, root string, fn RecordWal
 		}
 		return nil
 	}))
-	return err
+	if err != nil || next == nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(next.Offset, "\x00")), nil
 }
 
-func (r *recordStore) ExportRecords(ctx context.Context, wr io.Writer) error {
+// ExportRecords writes records whose current version was announced since the given time,
+// all records if it's zero.
+func (r *recordStore) ExportRecords(ctx context.Context, wr io.Writer, since time.Time) error {
 	defer r.inboundWork()
 	b := state.NewBucket(state.BucketRecords, &state.RangeOptions{
 		Prefetch: 100,
 	})
-	_, err := r.ss.RangePeek(b, func(k *state.Key, v []byte) error {
+	var sinceTs int64
+	if !since.IsZero() {
+		sinceTs = since.UnixNano()
+	}
+	isChanged := proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		if v.Current().Announce().Timestamp() < sinceTs {
+			return errRecordUnchanged
+		}
+		return nil
+	})
+	// records are written whole and in no particular order
+	wrMux := new(sync.Mutex)
+	return state.Stream(ctx, r.ss, b, 0, func(k *state.Key, v []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if sinceTs > 0 {
+			if err := isChanged(k, v); err == errRecordUnchanged {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		wrMux.Lock()
 		_, err := io.Copy(wr, bytes.NewReader(v))
+		wrMux.Unlock()
 		if err == io.EOF {
 			return state.ErrRangeStop
 		} else if err != nil {
@@ -1148,7 +2089,6 @@ func (r *recordStore) ExportRecords(ctx context.Context, wr io.Writer) error {
 		}
 		return nil
 	})
-	return err
 }
 
 func (r *recordStore) BadgerStats() *BadgerStats {
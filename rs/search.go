@@ -0,0 +1,610 @@
+package rs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/oklog/ulid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// The search index keeps a document per record in the state store: path, content type,
+// size, user meta and timestamps of the current version, so that queries don't fetch
+// objects from the file store. Terms of paths, user meta values and, for small text
+// records, the content are posted under a digest of the term followed by the record ID,
+// a text query intersects postings of its terms. Changes are indexed in the background,
+// a periodic pass indexes records that were missed and drops documents of records gone.
+
+var ErrSearchDisabled = errors.New("search index is disabled")
+
+// SearchQueryError is returned for queries that can't be run.
+type SearchQueryError string
+
+func (e SearchQueryError) Error() string {
+	return "invalid search query: " + string(e)
+}
+
+const (
+	searchQueueSize    = 4096
+	searchMaxTerms     = 512
+	searchMinTermLen   = 2
+	searchTermHashSize = 10
+	searchPageSize     = 1000
+	searchFetchTimeout = 30 * time.Second
+
+	DefaultSearchLimit = 100
+	MaxSearchLimit     = 1000
+)
+
+// SearchPolicy enables the search index.
+type SearchPolicy struct {
+	// ContentMax is the max size of text records whose content is indexed,
+	// zero indexes paths and user meta only.
+	ContentMax int64
+}
+
+// SearchQuery selects records of the index, all conditions must match.
+type SearchQuery struct {
+	// Text is matched against terms of paths, user meta values and text content.
+	Text string
+	// Prefix limits the query to records under the path prefix.
+	Prefix string
+	// ContentType is a media type, e.g. "image/png", or a range of them, e.g. "image/*".
+	ContentType string
+	// Meta lists top-level fields of user meta and their values.
+	Meta map[string]string
+	// Since and Until bound the modification time of the current version.
+	Since time.Time
+	Until time.Time
+	// Deleted includes deleted records.
+	Deleted bool
+	// Cursor is the ID of the record to start at, returned by a previous query.
+	Cursor string
+	Limit  int
+}
+
+type SearchHit struct {
+	ID          string          `json:"id"`
+	Path        string          `json:"path"`
+	Version     string          `json:"version"`
+	ContentType string          `json:"content_type,omitempty"`
+	Size        int64           `json:"size"`
+	UserMeta    json.RawMessage `json:"user_meta,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Deleted     bool            `json:"deleted,omitempty"`
+}
+
+type SearchResult struct {
+	Hits       []*SearchHit `json:"hits"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+type SearchStats struct {
+	Enabled    bool      `json:"enabled"`
+	Queued     int       `json:"queued"`
+	Indexed    uint64    `json:"indexed_total"`
+	Failures   uint64    `json:"failures_total"`
+	Dropped    uint64    `json:"dropped_total"`
+	Reindexing bool      `json:"reindexing"`
+	ReindexAt  time.Time `json:"reindexed_at,omitempty"`
+}
+
+// searchDoc is stored per record, terms are kept to remove their postings on updates.
+type searchDoc struct {
+	SearchHit
+	Terms []string `json:"terms,omitempty"`
+}
+
+type searchIndex struct {
+	policy *SearchPolicy
+	queue  chan string
+
+	// mux serializes updates of documents and their postings
+	mux      *sync.Mutex
+	statsMux *sync.Mutex
+	stats    SearchStats
+}
+
+func newSearchIndex(policy *SearchPolicy) *searchIndex {
+	if policy == nil {
+		return nil
+	}
+	return &searchIndex{
+		policy:   policy,
+		queue:    make(chan string, searchQueueSize),
+		mux:      new(sync.Mutex),
+		statsMux: new(sync.Mutex),
+		stats: SearchStats{
+			Enabled: true,
+		},
+	}
+}
+
+// Notify queues the record of the change for indexing, it never blocks.
+func (s *searchIndex) Notify(change *RecordChange) {
+	if s == nil {
+		return
+	}
+	select {
+	case s.queue <- change.ID:
+	default:
+		// the next pass indexes the record
+		s.count(func(stats *SearchStats) {
+			stats.Dropped++
+		})
+	}
+}
+
+func (s *searchIndex) count(fn func(stats *SearchStats)) {
+	s.statsMux.Lock()
+	fn(&s.stats)
+	s.statsMux.Unlock()
+}
+
+// IndexRecords keeps the search index up to date, the whole store is checked
+// on start and every interval.
+func (r *recordStore) IndexRecords(ctx context.Context, interval time.Duration) {
+	if r.search == nil {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case id := <-r.search.queue:
+				r.indexRecordLogged(ctx, id)
+			}
+		}
+	}()
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := r.reindex(ctx); err != nil {
+				log.Warningf("failed to update the search index: %v", err)
+			}
+			t.Reset(interval)
+		}
+	}
+}
+
+func (r *recordStore) indexRecordLogged(ctx context.Context, id string) {
+	err := r.indexRecord(ctx, id, false)
+	r.search.count(func(stats *SearchStats) {
+		if err != nil {
+			stats.Failures++
+			return
+		}
+		stats.Indexed++
+	})
+	if err != nil {
+		log.WithField("id", id).Warningf("failed to index record: %v", err)
+	}
+}
+
+// reindex indexes records whose documents are missing or outdated and drops documents
+// of records that are gone. Records and documents are checked a page at a time.
+func (r *recordStore) reindex(ctx context.Context) error {
+	r.search.count(func(stats *SearchStats) {
+		stats.Reindexing = true
+	})
+	defer r.search.count(func(stats *SearchStats) {
+		stats.Reindexing = false
+		stats.ReindexAt = time.Now()
+	})
+	type recordVersion struct {
+		id, version string
+	}
+	opts := &state.RangeOptions{
+		Limit: searchPageSize,
+	}
+	for opts != nil {
+		var page []recordVersion
+		next, err := r.ss.RangePeek(ctx, state.NewBucket(state.BucketRecords, opts),
+			proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+				page = append(page, recordVersion{v.Id(), v.Current().Version()})
+				return nil
+			}))
+		if err != nil {
+			return err
+		}
+		for _, rv := range page {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if doc, err := r.searchDoc(ctx, rv.id); err != nil && err != state.ErrNotFound {
+				return err
+			} else if doc != nil && doc.Version == rv.version {
+				continue
+			}
+			r.indexRecordLogged(ctx, rv.id)
+		}
+		opts = next
+	}
+	opts = &state.RangeOptions{
+		Limit: searchPageSize,
+	}
+	for opts != nil {
+		var ids []string
+		next, err := r.ss.RangeKeys(ctx, state.NewBucket(state.BucketSearchDocs, opts), func(k *state.Key) error {
+			ids = append(ids, strings.TrimRight(string(k.Key[:]), "\x00"))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			err := r.ss.View(ctx, state.RecordsKey([]byte(id)), func(k *state.Key, v []byte) error {
+				return nil
+			})
+			if err == state.ErrNotFound {
+				r.indexRecordLogged(ctx, id)
+			} else if err != nil {
+				return err
+			}
+		}
+		opts = next
+	}
+	return nil
+}
+
+func (r *recordStore) searchDoc(ctx context.Context, id string) (*searchDoc, error) {
+	var doc *searchDoc
+	err := r.ss.View(ctx, state.SearchDocsKey(id), func(k *state.Key, v []byte) error {
+		doc = new(searchDoc)
+		return json.Unmarshal(v, doc)
+	})
+	return doc, err
+}
+
+// indexRecord updates the document of the record, or removes it if the record is gone.
+// Documents of the current version are rewritten only if forced.
+func (r *recordStore) indexRecord(ctx context.Context, id string, force bool) error {
+	r.search.mux.Lock()
+	defer r.search.mux.Unlock()
+	prev, err := r.searchDoc(ctx, id)
+	if err != nil && err != state.ErrNotFound {
+		return err
+	}
+	var path, version string
+	var createdAt int64
+	if err := r.ss.View(ctx, state.RecordsKey([]byte(id)), proto.RecordPeek(func(k *state.Key, v *proto.Record) error {
+		path, version, createdAt = v.Path(), v.Current().Version(), v.CreatedAt()
+		return nil
+	})); err == state.ErrNotFound {
+		return r.writeSearchDoc(id, prev, nil)
+	} else if err != nil {
+		return err
+	}
+	if prev != nil && prev.Version == version && !force {
+		return nil
+	}
+	ctx, cancelFn := context.WithTimeout(ctx, searchFetchTimeout)
+	defer cancelFn()
+	ref, err := r.fs.HeadObject(ctx, fs.ObjectRef{
+		Version: version,
+	})
+	if err != nil {
+		return err
+	}
+	meta := ref.Meta()
+	doc := &searchDoc{
+		SearchHit: SearchHit{
+			ID:          id,
+			Path:        path,
+			Version:     version,
+			ContentType: searchContentType(path),
+			Size:        meta.Size(),
+			CreatedAt:   time.Unix(0, createdAt),
+			UpdatedAt:   time.Unix(0, meta.CreatedAt()),
+			Deleted:     meta.IsDeleted(),
+		},
+	}
+	terms := make(map[string]bool)
+	addSearchTerms(terms, path)
+	if m := meta.UserMeta(); len(m) > 0 && json.Valid([]byte(m)) {
+		doc.UserMeta = json.RawMessage(m)
+		var v interface{}
+		if err := json.Unmarshal(doc.UserMeta, &v); err == nil {
+			addValueTerms(terms, v)
+		}
+	}
+	if !doc.Deleted && isTextContentType(doc.ContentType) &&
+		doc.Size > 0 && doc.Size <= r.search.policy.ContentMax {
+		obj, err := r.fs.GetObject(ctx, fs.ObjectRef{
+			Version: version,
+		})
+		if err != nil {
+			return err
+		} else if obj.Body != nil {
+			hdr, body, err := readSealHeader(obj.Body)
+			if err != nil {
+				obj.Body.Close()
+				return err
+			}
+			// content of encrypted namespaces is not indexed, terms would disclose it
+			var content []byte
+			if hdr == nil {
+				content, err = ioutil.ReadAll(io.LimitReader(body, r.search.policy.ContentMax))
+			}
+			body.Close()
+			if err != nil {
+				return err
+			}
+			addSearchTerms(terms, string(content))
+		}
+	}
+	doc.Terms = make([]string, 0, len(terms))
+	for term := range terms {
+		doc.Terms = append(doc.Terms, term)
+	}
+	sort.Strings(doc.Terms)
+	return r.writeSearchDoc(id, prev, doc)
+}
+
+// writeSearchDoc replaces postings of the previous document with the ones of the new
+// document, a nil doc removes the record from the index.
+func (r *recordStore) writeSearchDoc(id string, prev, doc *searchDoc) error {
+	u, err := ulid.Parse(id)
+	if err != nil {
+		return fmt.Errorf("record ID is not a ULID: %v", err)
+	}
+	var data []byte
+	if doc != nil {
+		if data, err = json.Marshal(doc); err != nil {
+			return err
+		}
+	}
+	return r.ss.Batch(func(tx state.Txn) error {
+		if prev != nil {
+			for _, term := range prev.Terms {
+				if err := tx.Delete(searchPostingKey(term, u)); err != nil && err != state.ErrNotFound {
+					return err
+				}
+			}
+		}
+		k := state.SearchDocsKey(id)
+		if doc == nil {
+			if err := tx.Delete(k); err != nil && err != state.ErrNotFound {
+				return err
+			}
+			return nil
+		}
+		for _, term := range doc.Terms {
+			if err := tx.Set(searchPostingKey(term, u), []byte{}); err != nil {
+				return err
+			}
+		}
+		return tx.Set(k, data)
+	})
+}
+
+func searchTermHash(term string) []byte {
+	sum := sha256.Sum256([]byte(term))
+	return sum[:searchTermHashSize]
+}
+
+func searchPostingKey(term string, id ulid.ULID) *state.Key {
+	key := make([]byte, 0, searchTermHashSize+len(id))
+	key = append(key, searchTermHash(term)...)
+	key = append(key, id[:]...)
+	return state.SearchTermsKey(key)
+}
+
+// addSearchTerms splits the text into lowercase words of letters and digits.
+func addSearchTerms(terms map[string]bool, text string) {
+	for _, term := range strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	}) {
+		if len(terms) >= searchMaxTerms {
+			return
+		} else if len(term) >= searchMinTermLen {
+			terms[term] = true
+		}
+	}
+}
+
+func addValueTerms(terms map[string]bool, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, vv := range v {
+			addValueTerms(terms, vv)
+		}
+	case []interface{}:
+		for _, vv := range v {
+			addValueTerms(terms, vv)
+		}
+	case string:
+		addSearchTerms(terms, v)
+	case nil:
+	default:
+		addSearchTerms(terms, fmt.Sprint(v))
+	}
+}
+
+func searchContentType(path string) string {
+	ctype := mime.TypeByExtension(filepath.Ext(path))
+	if i := strings.IndexByte(ctype, ';'); i >= 0 {
+		ctype = ctype[:i]
+	}
+	return strings.TrimSpace(ctype)
+}
+
+func isTextContentType(ctype string) bool {
+	return strings.HasPrefix(ctype, "text/") ||
+		strings.HasSuffix(ctype, "json") ||
+		strings.HasSuffix(ctype, "xml") ||
+		strings.HasSuffix(ctype, "javascript")
+}
+
+func (q *SearchQuery) match(doc *searchDoc) bool {
+	switch {
+	case doc.Deleted && !q.Deleted:
+		return false
+	case !strings.HasPrefix(doc.Path, q.Prefix):
+		return false
+	case !q.Since.IsZero() && doc.UpdatedAt.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !doc.UpdatedAt.Before(q.Until):
+		return false
+	}
+	if len(q.ContentType) > 0 {
+		if strings.HasSuffix(q.ContentType, "/*") {
+			if !strings.HasPrefix(doc.ContentType, strings.TrimSuffix(q.ContentType, "*")) {
+				return false
+			}
+		} else if doc.ContentType != q.ContentType {
+			return false
+		}
+	}
+	if len(q.Meta) > 0 {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(doc.UserMeta, &fields); err != nil {
+			return false
+		}
+		for name, want := range q.Meta {
+			v, ok := fields[name]
+			if !ok || fmt.Sprint(v) != want {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Search returns records of the index that match the query in ID order.
+func (r *recordStore) Search(ctx context.Context, q *SearchQuery) (*SearchResult, error) {
+	if r.search == nil {
+		return nil, ErrSearchDisabled
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	} else if limit > MaxSearchLimit {
+		return nil, SearchQueryError(fmt.Sprintf("limit is above %d", MaxSearchLimit))
+	}
+	if len(q.ContentType) > 0 && !strings.Contains(q.ContentType, "/") {
+		return nil, SearchQueryError("malformed content type: " + q.ContentType)
+	}
+	res := &SearchResult{
+		Hits: []*SearchHit{},
+	}
+	// a hit past the limit ends the page, its ID is the cursor of the next one
+	collect := func(doc *searchDoc) bool {
+		if !q.match(doc) {
+			return true
+		} else if len(res.Hits) == limit {
+			res.NextCursor = doc.ID
+			return false
+		}
+		hit := doc.SearchHit
+		res.Hits = append(res.Hits, &hit)
+		return true
+	}
+	terms := make(map[string]bool)
+	addSearchTerms(terms, q.Text)
+	if len(terms) == 0 {
+		if len(strings.TrimSpace(q.Text)) > 0 {
+			return nil, SearchQueryError(fmt.Sprintf("no terms of %d or more letters", searchMinTermLen))
+		}
+		b := state.NewBucket(state.BucketSearchDocs, &state.RangeOptions{
+			Offset: []byte(q.Cursor),
+		})
+		_, err := r.ss.RangePeek(ctx, b, func(k *state.Key, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			doc := new(searchDoc)
+			if err := json.Unmarshal(v, doc); err != nil {
+				return err
+			} else if !collect(doc) {
+				return state.ErrRangeStop
+			}
+			return nil
+		})
+		return res, err
+	}
+	ids, err := r.searchPostings(ctx, terms)
+	if err != nil {
+		return nil, err
+	}
+	start := sort.SearchStrings(ids, q.Cursor)
+	for _, id := range ids[start:] {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		doc, err := r.searchDoc(ctx, id)
+		if err == state.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		} else if !collect(doc) {
+			break
+		}
+	}
+	return res, nil
+}
+
+// searchPostings returns sorted IDs of records that have all the terms.
+func (r *recordStore) searchPostings(ctx context.Context, terms map[string]bool) ([]string, error) {
+	var found map[ulid.ULID]bool
+	for term := range terms {
+		b := state.NewBucket(state.BucketSearchTerms, &state.RangeOptions{
+			Prefix: searchTermHash(term),
+		})
+		posted := make(map[ulid.ULID]bool)
+		if _, err := r.ss.RangeKeys(ctx, b, func(k *state.Key) error {
+			var u ulid.ULID
+			copy(u[:], k.Key[searchTermHashSize:])
+			if found == nil || found[u] {
+				posted[u] = true
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		found = posted
+		if len(found) == 0 {
+			return nil, nil
+		}
+	}
+	ids := make([]string, 0, len(found))
+	for u := range found {
+		ids = append(ids, u.String())
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// SearchStats reports the state of the search index.
+func (r *recordStore) SearchStats() *SearchStats {
+	if r.search == nil {
+		return &SearchStats{}
+	}
+	r.search.statsMux.Lock()
+	stats := r.search.stats
+	r.search.statsMux.Unlock()
+	stats.Queued = len(r.search.queue)
+	return &stats
+}
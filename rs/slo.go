@@ -0,0 +1,351 @@
+package rs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SLIs are tracked per namespace over a rolling window split into sloSlots slots,
+// latencies are kept as histograms, so percentiles are reported as bucket bounds.
+
+const (
+	sloSlots         = 60
+	defaultSLOWindow = time.Hour
+	maxSLOWindow     = 7 * 24 * time.Hour
+	// sloMinReads is the number of reads in the window needed to judge availability.
+	sloMinReads = 20
+	// sloMaxNamespaces limits namespaces tracked without an SLO, to bound memory.
+	sloMaxNamespaces = 256
+)
+
+// sloBounds are upper bounds of latency buckets in seconds.
+var sloBounds = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+	30, 60, 120, 300, 600, 1800, 3600,
+}
+
+// Names of SLIs reported as breached.
+const (
+	SLIAvailability   = "availability"
+	SLILatency        = "latency"
+	SLIReplicationLag = "replication_lag"
+)
+
+// SLOPolicy sets objectives of a namespace, zero values are not checked.
+// Latency and replication lag are 99th percentiles within the window.
+type SLOPolicy struct {
+	// Availability is the share of reads that must succeed, e.g. 0.999.
+	Availability   float64 `json:"availability,omitempty"`
+	LatencyP99     string  `json:"latency_p99,omitempty"`
+	ReplicationLag string  `json:"replication_lag,omitempty"`
+	Window         string  `json:"window,omitempty"`
+
+	latency time.Duration
+	lag     time.Duration
+	window  time.Duration
+}
+
+func (p *SLOPolicy) validate() error {
+	if p.Availability < 0 || p.Availability > 1 {
+		return fmt.Errorf("availability must be within [0, 1]")
+	}
+	parse := func(name, v string, d *time.Duration) error {
+		if len(v) == 0 {
+			return nil
+		}
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("bad %s: %v", name, err)
+		} else if dur <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+		*d = dur
+		return nil
+	}
+	if err := parse("latency_p99", p.LatencyP99, &p.latency); err != nil {
+		return err
+	} else if err := parse("replication_lag", p.ReplicationLag, &p.lag); err != nil {
+		return err
+	} else if err := parse("window", p.Window, &p.window); err != nil {
+		return err
+	}
+	if p.window == 0 {
+		p.window = defaultSLOWindow
+	} else if p.window > maxSLOWindow {
+		return fmt.Errorf("window must not exceed %s", maxSLOWindow)
+	}
+	return nil
+}
+
+// SLIReport holds SLIs of a namespace within the window and objectives breached.
+type SLIReport struct {
+	Namespace string `json:"namespace"`
+	Window    string `json:"window"`
+
+	Reads        uint64  `json:"reads"`
+	Failures     uint64  `json:"failures"`
+	Availability float64 `json:"availability"`
+	LatencyP99   float64 `json:"latency_p99_seconds"`
+
+	Replicated        uint64  `json:"replicated"`
+	ReplicationLagP99 float64 `json:"replication_lag_p99_seconds"`
+
+	SLO      *SLOPolicy `json:"slo,omitempty"`
+	Breaches []string   `json:"breaches,omitempty"`
+}
+
+type sloSlot struct {
+	start    time.Time
+	reads    uint64
+	failures uint64
+	latency  []uint64
+	lag      []uint64
+}
+
+func (s *sloSlot) reset(start time.Time) {
+	s.start = start
+	s.reads = 0
+	s.failures = 0
+	s.latency = make([]uint64, len(sloBounds)+1)
+	s.lag = make([]uint64, len(sloBounds)+1)
+}
+
+type sloSeries struct {
+	window time.Duration
+	slots  []*sloSlot
+}
+
+func newSLOSeries(window time.Duration) *sloSeries {
+	s := &sloSeries{
+		window: window,
+		slots:  make([]*sloSlot, sloSlots),
+	}
+	for i := range s.slots {
+		s.slots[i] = &sloSlot{}
+	}
+	return s
+}
+
+func (s *sloSeries) slotDuration() time.Duration {
+	return s.window / sloSlots
+}
+
+func (s *sloSeries) slot(now time.Time) *sloSlot {
+	d := s.slotDuration()
+	start := now.Truncate(d)
+	slot := s.slots[int((start.UnixNano()/int64(d))%sloSlots)]
+	if !slot.start.Equal(start) {
+		slot.reset(start)
+	}
+	return slot
+}
+
+func sloBucket(d time.Duration) int {
+	v := d.Seconds()
+	for i, bound := range sloBounds {
+		if v <= bound {
+			return i
+		}
+	}
+	return len(sloBounds)
+}
+
+// sloQuantile returns the upper bound of the bucket holding the quantile,
+// values above the last bound are reported as the last bound.
+func sloQuantile(counts []uint64, total uint64, q float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(total) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+	var cum uint64
+	for i, n := range counts {
+		if cum += n; cum >= rank {
+			if i >= len(sloBounds) {
+				break
+			}
+			return sloBounds[i]
+		}
+	}
+	return sloBounds[len(sloBounds)-1]
+}
+
+func (s *sloSeries) report(ns string, now time.Time) *SLIReport {
+	rep := &SLIReport{
+		Namespace:    ns,
+		Window:       s.window.String(),
+		Availability: 1,
+	}
+	latency := make([]uint64, len(sloBounds)+1)
+	lag := make([]uint64, len(sloBounds)+1)
+	since := now.Add(-s.window)
+	for _, slot := range s.slots {
+		if slot.start.IsZero() || !slot.start.After(since) {
+			continue
+		}
+		rep.Reads += slot.reads
+		rep.Failures += slot.failures
+		for i := range latency {
+			latency[i] += slot.latency[i]
+			lag[i] += slot.lag[i]
+			rep.Replicated += slot.lag[i]
+		}
+	}
+	if rep.Reads > 0 {
+		rep.Availability = float64(rep.Reads-rep.Failures) / float64(rep.Reads)
+	}
+	rep.LatencyP99 = sloQuantile(latency, rep.Reads, 0.99)
+	rep.ReplicationLagP99 = sloQuantile(lag, rep.Replicated, 0.99)
+	return rep
+}
+
+func (rep *SLIReport) check(p *SLOPolicy) {
+	rep.SLO = p
+	if p.Availability > 0 && rep.Reads >= sloMinReads && rep.Availability < p.Availability {
+		rep.Breaches = append(rep.Breaches, SLIAvailability)
+	}
+	if p.latency > 0 && rep.Reads > 0 && rep.LatencyP99 > p.latency.Seconds() {
+		rep.Breaches = append(rep.Breaches, SLILatency)
+	}
+	if p.lag > 0 && rep.Replicated > 0 && rep.ReplicationLagP99 > p.lag.Seconds() {
+		rep.Breaches = append(rep.Breaches, SLIReplicationLag)
+	}
+}
+
+type sloTracker struct {
+	mux      *sync.Mutex
+	policies map[string]*SLOPolicy
+	series   map[string]*sloSeries
+}
+
+func newSLOTracker() *sloTracker {
+	return &sloTracker{
+		mux:      new(sync.Mutex),
+		policies: make(map[string]*SLOPolicy),
+		series:   make(map[string]*sloSeries),
+	}
+}
+
+// get returns series of the namespace or nil if too many are tracked,
+// must be called with the lock held.
+func (t *sloTracker) get(ns string) *sloSeries {
+	if s, ok := t.series[ns]; ok {
+		return s
+	}
+	window := defaultSLOWindow
+	if p, ok := t.policies[ns]; ok {
+		window = p.window
+	} else if len(t.series) >= sloMaxNamespaces {
+		return nil
+	}
+	s := newSLOSeries(window)
+	t.series[ns] = s
+	return s
+}
+
+func (t *sloTracker) observeRead(ns string, d time.Duration, failed bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	s := t.get(ns)
+	if s == nil {
+		return
+	}
+	slot := s.slot(time.Now())
+	slot.reads++
+	if failed {
+		slot.failures++
+	}
+	slot.latency[sloBucket(d)]++
+}
+
+func (t *sloTracker) observeLag(ns string, d time.Duration) {
+	if d < 0 {
+		// clocks of nodes are off, the record arrived right away
+		d = 0
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	s := t.get(ns)
+	if s == nil {
+		return
+	}
+	s.slot(time.Now()).lag[sloBucket(d)]++
+}
+
+func (t *sloTracker) setPolicies(policies map[string]*SLOPolicy) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for ns, s := range t.series {
+		window := defaultSLOWindow
+		if p, ok := policies[ns]; ok {
+			window = p.window
+		}
+		if s.window != window {
+			delete(t.series, ns)
+		}
+	}
+	t.policies = policies
+}
+
+func (t *sloTracker) Reports() []*SLIReport {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	now := time.Now()
+	reports := make([]*SLIReport, 0, len(t.series))
+	for ns, s := range t.series {
+		rep := s.report(ns, now)
+		if p, ok := t.policies[ns]; ok {
+			rep.check(p)
+		}
+		reports = append(reports, rep)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Namespace < reports[j].Namespace
+	})
+	return reports
+}
+
+func (r *recordStore) SLOReports() []*SLIReport {
+	return r.slo.Reports()
+}
+
+// TrackSLOs reloads objectives of namespaces every interval.
+func (r *recordStore) TrackSLOs(ctx context.Context, interval time.Duration) {
+	r.loadSLOs(ctx)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			r.loadSLOs(ctx)
+		}
+	}
+}
+
+func (r *recordStore) loadSLOs(ctx context.Context) {
+	configs, err := r.namespaceConfigs(ctx)
+	if err != nil {
+		log.Warningf("failed to load SLOs: %v", err)
+		return
+	}
+	policies := make(map[string]*SLOPolicy)
+	for ns, cfg := range configs {
+		if cfg.SLO == nil {
+			continue
+		} else if err := cfg.SLO.validate(); err != nil {
+			log.WithField("namespace", ns).Warningf("invalid SLO: %v", err)
+			continue
+		}
+		policies[ns] = cfg.SLO
+	}
+	r.slo.setPolicies(policies)
+}
@@ -0,0 +1,459 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Scheduled tasks replace cron jobs calling the private API of a node. Tasks are defined
+// over the private API and kept in the state store with a history of their runs, each node
+// runs its own tasks. Builtin actions publish a record from a template and run GC, other
+// layers of the node register more with RegisterTaskAction, e.g. rotation of the key of
+// pre-signed URLs. A task doesn't run again while its previous run is in progress, runs
+// missed while the node was down are not caught up.
+
+const (
+	TaskActionPublish = "publish"
+	TaskActionGC      = "gc"
+
+	taskPollInterval  = 15 * time.Second
+	taskRunTimeout    = time.Hour
+	taskRunHistoryTTL = 30 * 24 * time.Hour
+	maxTaskNameSize   = state.MaxKeySize
+	maxTaskTemplate   = 64 * 1024
+)
+
+var (
+	ErrTaskNotFound = errors.New("scheduled task not found")
+	ErrTaskRunning  = errors.New("scheduled task is already running")
+)
+
+// TaskAction runs a scheduled task with its params, the result is kept in the run history.
+type TaskAction func(ctx context.Context, params map[string]string) (result string, err error)
+
+// ScheduledTask is an action run by the node on a schedule, see ParseSchedule.
+type ScheduledTask struct {
+	Name     string            `json:"name"`
+	Schedule string            `json:"schedule"`
+	Action   string            `json:"action"`
+	Params   map[string]string `json:"params,omitempty"`
+	Paused   bool              `json:"paused,omitempty"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+	LastRun   *TaskRun   `json:"last_run,omitempty"`
+	Running   bool       `json:"running,omitempty"`
+}
+
+// TaskRun is an entry of the run history of scheduled tasks.
+type TaskRun struct {
+	ID        string    `json:"id"`
+	Task      string    `json:"task"`
+	Action    string    `json:"action"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	Manual    bool      `json:"manual,omitempty"`
+	Result    string    `json:"result,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// PublishTemplateData is passed to templates of the publish action.
+type PublishTemplateData struct {
+	Task   string
+	NodeID string
+	Time   time.Time
+	Params map[string]string
+}
+
+type taskState struct {
+	ss state.IndexedStore
+
+	mux      *sync.Mutex
+	tasks    map[string]*ScheduledTask
+	next     map[string]time.Time
+	running  map[string]bool
+	actions  map[string]TaskAction
+	schedule map[string]TaskSchedule
+}
+
+func newTaskState(ss state.IndexedStore) *taskState {
+	t := &taskState{
+		ss:       ss,
+		mux:      new(sync.Mutex),
+		tasks:    make(map[string]*ScheduledTask),
+		next:     make(map[string]time.Time),
+		running:  make(map[string]bool),
+		actions:  make(map[string]TaskAction),
+		schedule: make(map[string]TaskSchedule),
+	}
+	t.load()
+	return t
+}
+
+func (t *taskState) load() {
+	now := time.Now()
+	if _, err := t.ss.RangePeek(context.Background(), state.NewBucket(state.BucketScheduledTasks), func(k *state.Key, v []byte) error {
+		var task ScheduledTask
+		if err := json.Unmarshal(v, &task); err != nil {
+			log.Warningf("skipping malformed scheduled task: %v", err)
+			return nil
+		}
+		sched, err := ParseSchedule(task.Schedule)
+		if err != nil {
+			log.Warningf("skipping scheduled task %s: %v", task.Name, err)
+			return nil
+		}
+		t.tasks[task.Name] = &task
+		t.schedule[task.Name] = sched
+		t.next[task.Name] = sched.Next(now)
+		return nil
+	}); err != nil {
+		log.Warningf("failed to load scheduled tasks: %v", err)
+	}
+}
+
+func (t *taskState) store(task *ScheduledTask) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	return t.ss.Update(context.Background(), state.ScheduledTasksKey(task.Name), func(k *state.Key, v []byte) ([]byte, error) {
+		return data, nil
+	})
+}
+
+func (t *taskState) view(task *ScheduledTask) *ScheduledTask {
+	v := *task
+	if next, ok := t.next[task.Name]; ok && !task.Paused && !next.IsZero() {
+		v.NextRun = &next
+	}
+	v.Running = t.running[task.Name]
+	return &v
+}
+
+// RegisterTaskAction adds an action scheduled tasks may run, it replaces an action of the same name.
+func (r *recordStore) RegisterTaskAction(name string, action TaskAction) {
+	r.tasks.mux.Lock()
+	defer r.tasks.mux.Unlock()
+	r.tasks.actions[name] = action
+}
+
+func (r *recordStore) taskAction(name string) (TaskAction, bool) {
+	switch name {
+	case TaskActionPublish:
+		return r.publishTaskAction, true
+	case TaskActionGC:
+		return r.gcTaskAction, true
+	}
+	r.tasks.mux.Lock()
+	defer r.tasks.mux.Unlock()
+	action, ok := r.tasks.actions[name]
+	return action, ok
+}
+
+// TaskActions lists actions scheduled tasks may run.
+func (r *recordStore) TaskActions() []string {
+	r.tasks.mux.Lock()
+	names := []string{TaskActionPublish, TaskActionGC}
+	for name := range r.tasks.actions {
+		names = append(names, name)
+	}
+	r.tasks.mux.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// PutScheduledTask creates or replaces the task, the history of its runs is kept.
+func (r *recordStore) PutScheduledTask(task *ScheduledTask) (*ScheduledTask, error) {
+	if len(task.Name) == 0 || len(task.Name) > maxTaskNameSize || strings.ContainsAny(task.Name, "/ ") {
+		return nil, fmt.Errorf("task name must be 1-%d characters without slashes and spaces", maxTaskNameSize)
+	}
+	sched, err := ParseSchedule(task.Schedule)
+	if err != nil {
+		return nil, err
+	} else if sched.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never matches", task.Schedule)
+	}
+	if _, ok := r.taskAction(task.Action); !ok {
+		return nil, fmt.Errorf("unknown action: %s", task.Action)
+	} else if task.Action == TaskActionPublish {
+		if _, err := parsePublishParams(task.Params); err != nil {
+			return nil, err
+		}
+	}
+	t := r.tasks
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	task.CreatedAt = time.Now().UTC()
+	task.NextRun = nil
+	task.Running = false
+	task.LastRun = nil
+	if prev, ok := t.tasks[task.Name]; ok {
+		task.CreatedAt = prev.CreatedAt
+		task.LastRun = prev.LastRun
+	}
+	if err := t.store(task); err != nil {
+		return nil, err
+	}
+	t.tasks[task.Name] = task
+	t.schedule[task.Name] = sched
+	t.next[task.Name] = sched.Next(time.Now())
+	return t.view(task), nil
+}
+
+func (r *recordStore) DeleteScheduledTask(name string) error {
+	t := r.tasks
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if _, ok := t.tasks[name]; !ok {
+		return ErrTaskNotFound
+	}
+	if err := t.ss.Delete(state.ScheduledTasksKey(name)); err != nil && err != state.ErrNotFound {
+		return err
+	}
+	delete(t.tasks, name)
+	delete(t.schedule, name)
+	delete(t.next, name)
+	return nil
+}
+
+func (r *recordStore) ScheduledTasks() []*ScheduledTask {
+	t := r.tasks
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	list := make([]*ScheduledTask, 0, len(t.tasks))
+	for _, task := range t.tasks {
+		list = append(list, t.view(task))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// TaskRuns returns runs of the task in the history, newest first, runs of all tasks
+// if name is empty.
+func (r *recordStore) TaskRuns(ctx context.Context, name string, limit int) ([]*TaskRun, error) {
+	var runs []*TaskRun
+	if _, err := r.ss.RangePeek(ctx, state.NewBucket(state.BucketTaskRuns), func(k *state.Key, v []byte) error {
+		var run TaskRun
+		if err := json.Unmarshal(v, &run); err != nil {
+			log.Debugf("skipping malformed task run: %v", err)
+			return nil
+		}
+		if len(name) == 0 || run.Task == name {
+			runs = append(runs, &run)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	// IDs are ULIDs, so keys are in the order of runs
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+// RunScheduledTask runs the task now, regardless of its schedule.
+func (r *recordStore) RunScheduledTask(ctx context.Context, name string) (*TaskRun, error) {
+	t := r.tasks
+	t.mux.Lock()
+	task, ok := t.tasks[name]
+	if !ok {
+		t.mux.Unlock()
+		return nil, ErrTaskNotFound
+	} else if t.running[name] {
+		t.mux.Unlock()
+		return nil, ErrTaskRunning
+	}
+	t.running[name] = true
+	task = t.view(task)
+	t.mux.Unlock()
+	return r.runTask(ctx, task, true), nil
+}
+
+// ScheduleTasks runs scheduled tasks when they are due.
+func (r *recordStore) ScheduleTasks(ctx context.Context) {
+	ticker := time.NewTicker(taskPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, task := range r.dueTasks(now) {
+				go r.runTask(ctx, task, false)
+			}
+		}
+	}
+}
+
+// dueTasks returns tasks due at now marked as running, their next runs are scheduled.
+func (r *recordStore) dueTasks(now time.Time) []*ScheduledTask {
+	t := r.tasks
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	var due []*ScheduledTask
+	for name, task := range t.tasks {
+		next := t.next[name]
+		if task.Paused || next.IsZero() || next.After(now) {
+			continue
+		}
+		t.next[name] = t.schedule[name].Next(now)
+		if t.running[name] {
+			log.WithField("task", name).Warningln("skipping a run of the scheduled task, the previous one is still running")
+			continue
+		}
+		t.running[name] = true
+		due = append(due, t.view(task))
+	}
+	return due
+}
+
+func (r *recordStore) runTask(ctx context.Context, task *ScheduledTask, manual bool) *TaskRun {
+	run := &TaskRun{
+		ID:        proto.NewID(),
+		Task:      task.Name,
+		Action:    task.Action,
+		StartedAt: time.Now().UTC(),
+		Manual:    manual,
+	}
+	if action, ok := r.taskAction(task.Action); !ok {
+		run.Error = fmt.Sprintf("unknown action: %s", task.Action)
+	} else {
+		runCtx, cancelFn := context.WithTimeout(ctx, taskRunTimeout)
+		result, err := action(context.WithValue(runCtx, taskNameKey{}, task.Name), task.Params)
+		cancelFn()
+		run.Result = result
+		if err != nil {
+			run.Error = err.Error()
+		}
+	}
+	run.Duration = time.Since(run.StartedAt).String()
+	fields := log.Fields{
+		"task":     task.Name,
+		"action":   task.Action,
+		"duration": run.Duration,
+	}
+	if len(run.Error) > 0 {
+		log.WithFields(fields).Warningf("scheduled task failed: %s", run.Error)
+	} else {
+		log.WithFields(fields).Infoln("scheduled task done")
+	}
+
+	t := r.tasks
+	t.mux.Lock()
+	delete(t.running, task.Name)
+	if current, ok := t.tasks[task.Name]; ok {
+		current.LastRun = run
+		if err := t.store(current); err != nil {
+			log.WithFields(fields).Warningf("failed to save scheduled task: %v", err)
+		}
+	}
+	t.mux.Unlock()
+	data, err := json.Marshal(run)
+	if err == nil {
+		k := state.TaskRunsKey(run.ID)
+		k.TTL = taskRunHistoryTTL
+		err = r.ss.Update(context.Background(), k, func(k *state.Key, v []byte) ([]byte, error) {
+			return data, nil
+		})
+	}
+	if err != nil {
+		log.WithFields(fields).Warningf("failed to save task run: %v", err)
+	}
+	return run
+}
+
+type taskNameKey struct{}
+
+type publishParams struct {
+	path     string
+	template *template.Template
+	userMeta []byte
+}
+
+// parsePublishParams checks params of the publish action: path and template are required,
+// user_meta is optional JSON. Templates get PublishTemplateData.
+func parsePublishParams(params map[string]string) (*publishParams, error) {
+	p := &publishParams{
+		path: params["path"],
+	}
+	if !strings.HasPrefix(p.path, "/") {
+		return nil, errors.New("publish action requires an absolute path param")
+	} else if len(params["template"]) > maxTaskTemplate {
+		return nil, fmt.Errorf("template of the publish action is over %d bytes", maxTaskTemplate)
+	}
+	tpl, err := template.New("publish").Parse(params["template"])
+	if err != nil {
+		return nil, fmt.Errorf("malformed template: %v", err)
+	}
+	p.template = tpl
+	if m := params["user_meta"]; len(m) > 0 {
+		if !json.Valid([]byte(m)) {
+			return nil, errors.New("user_meta must be JSON")
+		}
+		p.userMeta = []byte(m)
+	}
+	return p, nil
+}
+
+// publishTaskAction writes the record at the path with the rendered template.
+func (r *recordStore) publishTaskAction(ctx context.Context, params map[string]string) (string, error) {
+	p, err := parsePublishParams(params)
+	if err != nil {
+		return "", err
+	}
+	name, _ := ctx.Value(taskNameKey{}).(string)
+	buf := new(bytes.Buffer)
+	if err := p.template.Execute(buf, &PublishTemplateData{
+		Task:   name,
+		NodeID: r.nodeID,
+		Time:   time.Now().UTC(),
+		Params: params,
+	}); err != nil {
+		return "", fmt.Errorf("failed to render template: %v", err)
+	}
+	data := buf.Bytes()
+	rec, err := r.CreateRecord(ctx, p.path, ioutil.NopCloser(bytes.NewReader(data)), CreateOptions{
+		Size:     int64(len(data)),
+		UserMeta: p.userMeta,
+	})
+	if err == ErrRecordExists {
+		rec, err = r.UpdateRecord(ctx, p.path, ioutil.NopCloser(bytes.NewReader(data)), UpdateOptions{
+			Size:     int64(len(data)),
+			UserMeta: p.userMeta,
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("published %s version %s", p.path, rec.Object.Meta().Version()), nil
+}
+
+func (r *recordStore) gcTaskAction(ctx context.Context, params map[string]string) (string, error) {
+	report, err := r.CollectGarbage(ctx)
+	if err != nil {
+		return "", err
+	}
+	data, _ := json.Marshal(report)
+	return string(data), nil
+}
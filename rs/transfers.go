@@ -0,0 +1,467 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/fs"
+)
+
+// Ownership of a path or a namespace moves between writers without the authority. The owner
+// offers a scope by writing /transfers/<id>/offer, the recipient accepts it by writing
+// /transfers/<id>/accept with the version of the offer. Both records are signed as any other,
+// so the authors of their versions are the parties. Once accepted, the recipient owns the
+// scope: it may write there without a permission of the authority, while the former owner
+// may not, holders of the write permission of the whole network aside. Every node replays
+// accepted transfers in the order of acceptance, so all nodes agree on owners. The owner
+// withdraws an offer by deleting it before it's accepted, deleting it later has no effect.
+
+const (
+	transferNamespace = "transfers"
+	transferRoot      = "/transfers/"
+
+	maxTransferSize = 4 * 1024
+)
+
+// Statuses of transfers.
+const (
+	TransferPending   = "pending"
+	TransferAccepted  = "accepted"
+	TransferWithdrawn = "withdrawn"
+	// TransferRejected is a transfer accepted while the proposer didn't own the scope.
+	TransferRejected = "rejected"
+)
+
+var (
+	ErrTransferNotFound     = errors.New("transfer not found")
+	ErrTransferNotPending   = errors.New("transfer is not pending")
+	ErrTransferNotOwner     = errors.New("only the owner of the scope may offer it")
+	ErrTransferNotRecipient = errors.New("transfer is offered to another node")
+	// ErrNotOwner is returned for writes to a scope that has been transferred to another node.
+	ErrNotOwner = errors.New("scope is owned by another node")
+)
+
+// Transfer of the ownership of a scope, a record path or a folder ending with a slash.
+type Transfer struct {
+	ID    string `json:"id"`
+	Scope string `json:"scope"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	// Offer is the version of the offer record.
+	Offer      string     `json:"offer"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	OfferedAt  time.Time  `json:"offered_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+type transferOffer struct {
+	Scope string `json:"scope"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+type transferAccept struct {
+	Offer string `json:"offer"`
+}
+
+type transferState struct {
+	mux *sync.RWMutex
+	// owners of transferred scopes
+	owners map[string]string
+	// recipients of pending offers
+	recipients map[string]bool
+	transfers  []*Transfer
+}
+
+func newTransferState() *transferState {
+	return &transferState{
+		mux:        new(sync.RWMutex),
+		owners:     make(map[string]string),
+		recipients: make(map[string]bool),
+	}
+}
+
+// ParseTransferScope returns the scope of a namespace name or a record path, folders end
+// with a slash, e.g. docs is /docs/.
+func ParseTransferScope(scope string) (string, error) {
+	if len(scope) == 0 {
+		return "", errors.New("empty scope")
+	} else if !strings.HasPrefix(scope, "/") {
+		if strings.Contains(scope, "/") {
+			return "", fmt.Errorf("invalid namespace %s", scope)
+		}
+		scope = "/" + scope + "/"
+	}
+	folder := strings.HasSuffix(scope, "/")
+	scope = path.Clean(scope)
+	if folder {
+		scope += "/"
+	}
+	if ns := NamespaceOf(scope); len(ns) == 0 {
+		return "", errors.New("scope must be within a namespace")
+	} else if ns == transferNamespace {
+		return "", errors.New("transfers can't be transferred")
+	}
+	return scope, nil
+}
+
+func scopeCovers(scope, p string) bool {
+	if strings.HasSuffix(scope, "/") {
+		return strings.HasPrefix(p, scope)
+	}
+	return p == scope
+}
+
+// ownerOf returns the owner of the most specific transferred scope that covers the path.
+func (t *transferState) ownerOf(p string) (string, bool) {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	return ownerIn(t.owners, p)
+}
+
+func ownerIn(owners map[string]string, p string) (string, bool) {
+	var owner, best string
+	for scope, nodeID := range owners {
+		if len(scope) > len(best) && scopeCovers(scope, p) {
+			owner, best = nodeID, scope
+		}
+	}
+	return owner, len(best) > 0
+}
+
+// isParty tells whether the node owns a scope or is offered one, such nodes may publish
+// without permissions of the authority.
+func (t *transferState) isParty(nodeID string) bool {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	return t.recipients[nodeID] || ownsAny(t.owners, nodeID)
+}
+
+// mayWrite tells whether the node may write the transfer record at path: offers of known
+// transfers are written by their proposers and acceptances by their recipients only.
+func (t *transferState) mayWrite(nodeID, p string) bool {
+	id, kind, ok := transferPath(p)
+	if !ok {
+		return false
+	}
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	for _, v := range t.transfers {
+		if v.ID != id {
+			continue
+		} else if kind == "offer" {
+			return nodeID == v.From
+		}
+		return nodeID == v.To
+	}
+	return kind == "offer" && (isTenant(nodeID) || t.recipients[nodeID] || ownsAny(t.owners, nodeID))
+}
+
+func ownsAny(owners map[string]string, nodeID string) bool {
+	for _, owner := range owners {
+		if owner == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// authorityAllows tells whether the authority lets the node write at the path, transfers aside.
+func authorityAllows(nodeID, p string) bool {
+	return isPublishAllowed(nodeID) ||
+		authcenter.HasPermissions(nodeID, authcenter.NamespaceWritePermission(NamespaceOf(p)))
+}
+
+// transferPath returns the ID and the kind, offer or accept, of a path of transfers.
+func transferPath(p string) (id, kind string, ok bool) {
+	if !strings.HasPrefix(p, transferRoot) {
+		return "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(p, transferRoot), "/")
+	if len(parts) != 2 || len(parts[0]) == 0 || (parts[1] != "offer" && parts[1] != "accept") {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func versionTime(rec *Record, version string) (time.Time, bool) {
+	if cur := rec.Current(); cur.Version() == version {
+		return time.Unix(0, cur.Announce().Timestamp()).UTC(), true
+	}
+	prev := rec.Previous()
+	for i := prev.Len() - 1; i >= 0; i-- {
+		if ver := prev.At(i); ver.Version() == version {
+			return time.Unix(0, ver.Announce().Timestamp()).UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (r *recordStore) readTransferJSON(ctx context.Context, p, version string, v interface{}) (*Record, error) {
+	rec, err := r.ReadRecord(ctx, p, ReadOptions{
+		Version: version,
+	})
+	if err != nil {
+		return rec, err
+	}
+	defer rec.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(rec.Body, maxTransferSize)).Decode(v); err != nil {
+		return nil, fmt.Errorf("malformed transfer record %s: %v", p, err)
+	}
+	return rec, nil
+}
+
+// loadTransfer reads the offer of a transfer and its acceptance, if any. An acceptance
+// counts if the recipient wrote it after the offer and before the offer was withdrawn.
+func (r *recordStore) loadTransfer(ctx context.Context, id string, hasAccept bool) (*Transfer, error) {
+	offerPath := transferRoot + id + "/offer"
+	cur, err := r.ReadRecord(ctx, offerPath, ReadOptions{
+		NoContent: true,
+	})
+	deleted := err == ErrRecordNotFound && cur != nil
+	if err != nil && !deleted {
+		return nil, err
+	}
+	t := &Transfer{
+		ID:     id,
+		Offer:  cur.Current().Version(),
+		Status: TransferPending,
+	}
+	if deleted {
+		prev := cur.Previous()
+		if prev.Len() == 0 {
+			return nil, ErrTransferNotFound
+		}
+		t.Offer = prev.At(prev.Len() - 1).Version()
+	}
+	var acceptedBy string
+	var acceptedAt time.Time
+	if hasAccept {
+		var accept transferAccept
+		if rec, err := r.readTransferJSON(ctx, transferRoot+id+"/accept", "", &accept); err != nil {
+			log.WithField("transfer", id).Debugf("skipping acceptance: %v", err)
+		} else if len(accept.Offer) > 0 {
+			ann := rec.Current().Announce()
+			t.Offer = accept.Offer
+			acceptedBy, acceptedAt = ann.NodeID(), time.Unix(0, ann.Timestamp()).UTC()
+		}
+	}
+	var offer transferOffer
+	offerRec, err := r.readTransferJSON(ctx, offerPath, t.Offer, &offer)
+	if err != nil {
+		return nil, err
+	}
+	if signer, _ := offerRec.Signer(t.Offer); signer != offer.From ||
+		!fs.IsValidNodeID(offer.To) || offer.To == offer.From {
+		return nil, fmt.Errorf("transfer %s is not offered by its author", id)
+	}
+	if t.Scope, err = ParseTransferScope(offer.Scope); err != nil {
+		return nil, err
+	}
+	t.From, t.To = offer.From, offer.To
+	t.OfferedAt, _ = versionTime(offerRec, t.Offer)
+	ann := cur.Current().Announce()
+	withdrawn := deleted && ann.NodeID() == t.From
+	withdrawnAt := time.Unix(0, ann.Timestamp()).UTC()
+	switch {
+	case acceptedBy == t.To && acceptedAt.After(t.OfferedAt) && (!withdrawn || withdrawnAt.After(acceptedAt)):
+		t.Status, t.AcceptedAt = TransferAccepted, &acceptedAt
+	case withdrawn:
+		t.Status = TransferWithdrawn
+	}
+	return t, nil
+}
+
+// refreshTransfers replays accepted transfers and updates owners of scopes.
+func (r *recordStore) refreshTransfers(ctx context.Context) error {
+	offers := make(map[string]bool)
+	accepts := make(map[string]bool)
+	if _, err := r.WalkNamespacePage(ctx, transferNamespace, "", 0, func(p string, _ *Record) error {
+		if id, kind, ok := transferPath(p); ok && kind == "offer" {
+			offers[id] = true
+		} else if ok {
+			accepts[id] = true
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	list := make([]*Transfer, 0, len(offers))
+	for id := range offers {
+		t, err := r.loadTransfer(ctx, id, accepts[id])
+		if err != nil {
+			log.WithField("transfer", id).Debugf("skipping transfer: %v", err)
+			continue
+		}
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].AcceptedAt != nil && list[j].AcceptedAt != nil {
+			return list[i].AcceptedAt.Before(*list[j].AcceptedAt)
+		} else if list[i].AcceptedAt != nil || list[j].AcceptedAt != nil {
+			return list[i].AcceptedAt != nil
+		}
+		return list[i].OfferedAt.Before(list[j].OfferedAt)
+	})
+	owners := make(map[string]string)
+	recipients := make(map[string]bool)
+	for _, t := range list {
+		owner, ok := ownerIn(owners, t.Scope)
+		isOwner := ok && owner == t.From || !ok && authorityAllows(t.From, t.Scope)
+		switch {
+		case t.Status == TransferAccepted && isOwner:
+			owners[t.Scope] = t.To
+		case t.Status == TransferAccepted:
+			t.Status, t.Error = TransferRejected, ErrTransferNotOwner.Error()
+		case t.Status == TransferPending:
+			recipients[t.To] = true
+		}
+	}
+	r.transfers.mux.Lock()
+	r.transfers.owners = owners
+	r.transfers.recipients = recipients
+	r.transfers.transfers = list
+	r.transfers.mux.Unlock()
+	return nil
+}
+
+// WatchTransfers periodically replays transfers, transfer records that change in between
+// are replayed right away.
+func (r *recordStore) WatchTransfers(ctx context.Context, interval time.Duration) {
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := r.refreshTransfers(ctx); err != nil {
+				log.Warningf("failed to replay ownership transfers: %v", err)
+			}
+			t.Reset(interval)
+		}
+	}
+}
+
+// Transfers lists transfers, accepted ones first in the order of acceptance.
+func (r *recordStore) Transfers() []*Transfer {
+	r.transfers.mux.RLock()
+	defer r.transfers.mux.RUnlock()
+	return append([]*Transfer(nil), r.transfers.transfers...)
+}
+
+// TransferOwners returns owners of transferred scopes.
+func (r *recordStore) TransferOwners() map[string]string {
+	r.transfers.mux.RLock()
+	defer r.transfers.mux.RUnlock()
+	owners := make(map[string]string, len(r.transfers.owners))
+	for scope, owner := range r.transfers.owners {
+		owners[scope] = owner
+	}
+	return owners
+}
+
+func (r *recordStore) findTransfer(ctx context.Context, id string) (*Transfer, error) {
+	if _, _, ok := transferPath(transferRoot + id + "/offer"); !ok {
+		return nil, ErrTransferNotFound
+	} else if err := r.refreshTransfers(ctx); err != nil {
+		return nil, err
+	}
+	for _, t := range r.Transfers() {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return nil, ErrTransferNotFound
+}
+
+// OfferTransfer offers ownership of the scope to the node, see ParseTransferScope.
+func (r *recordStore) OfferTransfer(ctx context.Context, scope, to string) (*Transfer, error) {
+	scope, err := ParseTransferScope(scope)
+	if err != nil {
+		return nil, err
+	} else if !fs.IsValidNodeID(to) || to == r.nodeID {
+		return nil, fmt.Errorf("can't transfer to node %s", to)
+	}
+	if owner, ok := r.transfers.ownerOf(scope); ok && owner != r.nodeID || !ok && !authorityAllows(r.nodeID, scope) {
+		return nil, ErrTransferNotOwner
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	t := &Transfer{
+		ID:     hex.EncodeToString(id),
+		Scope:  scope,
+		From:   r.nodeID,
+		To:     to,
+		Status: TransferPending,
+	}
+	body, _ := json.Marshal(&transferOffer{
+		Scope: t.Scope,
+		From:  t.From,
+		To:    t.To,
+	})
+	rec, err := r.CreateRecord(ctx, transferRoot+t.ID+"/offer", ioutil.NopCloser(bytes.NewReader(body)))
+	if err != nil {
+		return nil, err
+	}
+	t.Offer = rec.Current().Version()
+	t.OfferedAt, _ = versionTime(rec, t.Offer)
+	return t, nil
+}
+
+// AcceptTransfer accepts the transfer offered to this node.
+func (r *recordStore) AcceptTransfer(ctx context.Context, id string) (*Transfer, error) {
+	t, err := r.findTransfer(ctx, id)
+	if err != nil {
+		return nil, err
+	} else if t.To != r.nodeID {
+		return nil, ErrTransferNotRecipient
+	} else if t.Status != TransferPending {
+		return nil, ErrTransferNotPending
+	}
+	body, _ := json.Marshal(&transferAccept{
+		Offer: t.Offer,
+	})
+	p := transferRoot + id + "/accept"
+	if _, err = r.CreateRecord(ctx, p, ioutil.NopCloser(bytes.NewReader(body))); err == ErrRecordExists {
+		_, err = r.UpdateRecord(ctx, p, ioutil.NopCloser(bytes.NewReader(body)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.findTransfer(ctx, id)
+}
+
+// WithdrawTransfer withdraws a pending transfer offered by this node.
+func (r *recordStore) WithdrawTransfer(ctx context.Context, id string) error {
+	t, err := r.findTransfer(ctx, id)
+	if err != nil {
+		return err
+	} else if t.From != r.nodeID {
+		return ErrTransferNotOwner
+	} else if t.Status != TransferPending {
+		return ErrTransferNotPending
+	}
+	if _, err := r.DeleteRecord(ctx, transferRoot+id+"/offer"); err != nil {
+		return err
+	}
+	return r.refreshTransfers(ctx)
+}
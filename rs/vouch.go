@@ -0,0 +1,176 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/fs"
+)
+
+// Vouches let permission holders bootstrap new nodes without the central authority. A holder
+// vouches for a node by writing /vouches/<node ID>/<own node ID>, the record is signed as any
+// other, so the voucher is the author of its current version. Once the threshold of vouches is
+// met, the node is on probation: it may write to the probation namespace until the probation
+// ends. Every node counts vouches on its own, the start of a probation is the time of the vouch
+// that met the threshold, so all nodes agree on it.
+
+const vouchRoot = "/vouches/"
+
+var ErrProbationNamespace = errors.New("probationary nodes may write to the probation namespace only")
+
+// VouchPolicy sets when vouched nodes are put on probation, zero threshold disables vouches.
+type VouchPolicy struct {
+	Threshold int
+	Namespace string
+	Duration  time.Duration
+}
+
+type vouch struct {
+	voucher string
+	time    time.Time
+}
+
+// onProbation tells whether the node may write to the probation namespace.
+func (r *recordStore) onProbation(nodeID string) bool {
+	return r.opts.Vouch != nil && r.opts.Vouch.Threshold > 0 && authcenter.OnProbation(nodeID)
+}
+
+// isWriteAllowed tells whether records of the node at path are accepted, owners of
+// transferred scopes replace permissions of the authority there, see Transfer.
+func (r *recordStore) isWriteAllowed(nodeID, recPath string) bool {
+	if isPublishAllowed(nodeID) {
+		return true
+	} else if owner, ok := r.transfers.ownerOf(recPath); ok {
+		return nodeID == owner
+	} else if strings.HasPrefix(recPath, transferRoot) {
+		return r.transfers.mayWrite(nodeID, recPath)
+	} else if ns := NamespaceOf(recPath); len(ns) > 0 &&
+		authcenter.HasPermissions(nodeID, authcenter.NamespaceWritePermission(ns)) {
+		return true
+	}
+	return r.onProbation(nodeID) && NamespaceOf(recPath) == r.opts.Vouch.Namespace
+}
+
+// Vouch writes a vouch of this node for the given one.
+func (r *recordStore) Vouch(ctx context.Context, nodeID string) (*Record, error) {
+	if !isPublishAllowed(r.nodeID) {
+		return nil, ErrNotAuthorized
+	} else if !fs.IsValidNodeID(nodeID) || nodeID == r.nodeID {
+		return nil, fmt.Errorf("can't vouch for node %s", nodeID)
+	}
+	body, _ := json.Marshal(map[string]string{
+		"node_id": nodeID,
+		"voucher": r.nodeID,
+	})
+	p := vouchRoot + nodeID + "/" + r.nodeID
+	rec, err := r.CreateRecord(ctx, p, ioutil.NopCloser(bytes.NewReader(body)))
+	if err == ErrRecordExists {
+		return r.UpdateRecord(ctx, p, ioutil.NopCloser(bytes.NewReader(body)))
+	}
+	return rec, err
+}
+
+// WatchVouches periodically counts vouches and updates probations of the authority.
+func (r *recordStore) WatchVouches(ctx context.Context, interval time.Duration) {
+	if r.opts.Vouch == nil || r.opts.Vouch.Threshold <= 0 {
+		return
+	}
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if list, err := r.countVouches(ctx); err != nil {
+				log.Warningf("failed to count vouches: %v", err)
+			} else {
+				authcenter.SetProbations(list)
+			}
+			t.Reset(interval)
+		}
+	}
+}
+
+func (r *recordStore) countVouches(ctx context.Context) ([]*authcenter.Probation, error) {
+	policy := r.opts.Vouch
+	type vouchRecord struct {
+		candidate string
+		version   string
+		vouch     vouch
+	}
+	var records []vouchRecord
+	if err := r.WalkRecords(ctx, "", func(p string, rec *Record) error {
+		if !strings.HasPrefix(p, vouchRoot) {
+			return nil
+		}
+		candidate, voucher := path.Split(strings.TrimPrefix(p, vouchRoot))
+		candidate = strings.TrimSuffix(candidate, "/")
+		ann := rec.Current().Announce()
+		// only the voucher may vouch for itself and only holders of the permission count
+		if len(candidate) == 0 || strings.Contains(candidate, "/") || candidate == voucher ||
+			ann.NodeID() != voucher || !isPublishAllowed(voucher) {
+			return nil
+		}
+		records = append(records, vouchRecord{
+			candidate: candidate,
+			version:   rec.Current().Version(),
+			vouch: vouch{
+				voucher: voucher,
+				time:    time.Unix(0, ann.Timestamp()),
+			},
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	byCandidate := make(map[string][]vouch)
+	for _, v := range records {
+		// withdrawn vouches are deleted records
+		ref, err := r.fs.HeadObject(ctx, fs.ObjectRef{
+			Version: v.version,
+		})
+		if err != nil {
+			log.WithField("node", v.candidate).Debugf("failed to read vouch: %v", err)
+			continue
+		} else if ref.Meta().IsDeleted() {
+			continue
+		}
+		byCandidate[v.candidate] = append(byCandidate[v.candidate], v.vouch)
+	}
+	var list []*authcenter.Probation
+	now := time.Now()
+	for candidate, vouches := range byCandidate {
+		if len(vouches) < policy.Threshold {
+			continue
+		}
+		sort.Slice(vouches, func(i, j int) bool {
+			return vouches[i].time.Before(vouches[j].time)
+		})
+		since := vouches[policy.Threshold-1].time
+		p := &authcenter.Probation{
+			Key:   candidate,
+			Since: since,
+			Until: since.Add(policy.Duration),
+		}
+		if now.After(p.Until) {
+			continue
+		}
+		for _, v := range vouches {
+			p.Vouchers = append(p.Vouchers, v.voucher)
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
@@ -0,0 +1,350 @@
+package rs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/cache"
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/proto"
+)
+
+// RecordChange is delivered to watchers after a record has been changed locally
+// or an update has been received from the network.
+type RecordChange struct {
+	Op              WriteOp   `json:"op"`
+	ID              string    `json:"id"`
+	Path            string    `json:"path"`
+	Version         string    `json:"version"`
+	VersionPrevious string    `json:"version_previous,omitempty"`
+	NodeID          string    `json:"node_id"`
+	Time            time.Time `json:"time"`
+	// Changed lists the filters of the watcher whose selected values differ
+	// between the previous and the current version.
+	Changed []string `json:"changed,omitempty"`
+}
+
+type WatchOptions struct {
+	// Prefix limits the watcher to records under the given path prefix.
+	Prefix string
+	// Filters are JSONPath expressions, if set the watcher is notified only when
+	// the selected fields of a JSON record change. Non-JSON records never match.
+	Filters []string
+	// Buffer is the size of the watcher's channel, changes are dropped when it's full.
+	Buffer int
+}
+
+type WatchStats struct {
+	Watchers  int    `json:"watchers"`
+	Delivered uint64 `json:"delivered_total"`
+	Dropped   uint64 `json:"dropped_total"`
+	Filtered  uint64 `json:"filtered_total"`
+}
+
+const (
+	defaultWatchBuffer       = 64
+	maxWatchDocumentSize     = 4 * 1024 * 1024
+	defaultWatchFetchTimeout = 30 * time.Second
+)
+
+type Watcher struct {
+	C <-chan *RecordChange
+
+	c       chan *RecordChange
+	id      uint64
+	hub     *watchHub
+	prefix  string
+	filters []*JSONPath
+	once    sync.Once
+}
+
+// Close unregisters the watcher and closes its channel.
+func (w *Watcher) Close() {
+	w.once.Do(func() {
+		w.hub.remove(w)
+	})
+}
+
+type watchHub struct {
+	fs      fs.PlanetaryFileStore
+	changes chan *RecordChange
+
+	mux      *sync.RWMutex
+	watchers map[uint64]*Watcher
+	lastID   uint64
+
+	delivered uint64
+	dropped   uint64
+	filtered  uint64
+}
+
+func newWatchHub(fileStore fs.PlanetaryFileStore) *watchHub {
+	h := &watchHub{
+		fs:       fileStore,
+		changes:  make(chan *RecordChange, 1024),
+		mux:      new(sync.RWMutex),
+		watchers: make(map[uint64]*Watcher),
+	}
+	go h.run()
+	return h
+}
+
+func (h *watchHub) Add(opts WatchOptions) (*Watcher, error) {
+	filters := make([]*JSONPath, 0, len(opts.Filters))
+	for _, expr := range opts.Filters {
+		p, err := ParseJSONPath(expr)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, p)
+	}
+	buffer := opts.Buffer
+	if buffer <= 0 {
+		buffer = defaultWatchBuffer
+	}
+	c := make(chan *RecordChange, buffer)
+	w := &Watcher{
+		C:       c,
+		c:       c,
+		hub:     h,
+		prefix:  opts.Prefix,
+		filters: filters,
+	}
+	h.mux.Lock()
+	h.lastID++
+	w.id = h.lastID
+	h.watchers[w.id] = w
+	h.mux.Unlock()
+	return w, nil
+}
+
+func (h *watchHub) remove(w *Watcher) {
+	h.mux.Lock()
+	if _, ok := h.watchers[w.id]; ok {
+		delete(h.watchers, w.id)
+		close(w.c)
+	}
+	h.mux.Unlock()
+}
+
+// Active reports whether there are any watchers, so callers may skip extra work.
+func (h *watchHub) Active() bool {
+	h.mux.RLock()
+	active := len(h.watchers) > 0
+	h.mux.RUnlock()
+	return active
+}
+
+// Notify never blocks, changes are evaluated against watchers in the background.
+func (h *watchHub) Notify(change *RecordChange) {
+	if !h.Active() {
+		return
+	}
+	select {
+	case h.changes <- change:
+	default:
+		atomic.AddUint64(&h.dropped, 1)
+		log.WithField("path", change.Path).Warningln("watch queue is full, dropping record change")
+	}
+}
+
+func (h *watchHub) Stats() *WatchStats {
+	h.mux.RLock()
+	watchers := len(h.watchers)
+	h.mux.RUnlock()
+	return &WatchStats{
+		Watchers:  watchers,
+		Delivered: atomic.LoadUint64(&h.delivered),
+		Dropped:   atomic.LoadUint64(&h.dropped),
+		Filtered:  atomic.LoadUint64(&h.filtered),
+	}
+}
+
+func (h *watchHub) run() {
+	for change := range h.changes {
+		h.dispatch(change)
+	}
+}
+
+func (h *watchHub) dispatch(change *RecordChange) {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	// documents are fetched once per change and only if some watcher has filters
+	var docs *changeDocs
+	for _, w := range h.watchers {
+		if !strings.HasPrefix(change.Path, w.prefix) {
+			continue
+		}
+		c := *change
+		if len(w.filters) > 0 {
+			if docs == nil {
+				docs = h.fetchDocs(change)
+			}
+			c.Changed = docs.changed(w.filters)
+			if len(c.Changed) == 0 {
+				atomic.AddUint64(&h.filtered, 1)
+				continue
+			}
+		}
+		select {
+		case w.c <- &c:
+			atomic.AddUint64(&h.delivered, 1)
+		default:
+			atomic.AddUint64(&h.dropped, 1)
+		}
+	}
+}
+
+type changeDocs struct {
+	prev    interface{}
+	current interface{}
+	// valid is false if any of the versions is not a JSON document.
+	valid bool
+}
+
+func (h *watchHub) fetchDocs(change *RecordChange) *changeDocs {
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultWatchFetchTimeout)
+	defer cancelFn()
+	docs := &changeDocs{
+		valid: true,
+	}
+	var err error
+	if len(change.VersionPrevious) > 0 {
+		if docs.prev, err = h.readJSON(ctx, change.VersionPrevious); err != nil {
+			log.WithField("path", change.Path).Debugf("watch filters skipped: %v", err)
+			docs.valid = false
+			return docs
+		}
+	}
+	if change.Op != WriteDelete {
+		if docs.current, err = h.readJSON(ctx, change.Version); err != nil {
+			log.WithField("path", change.Path).Debugf("watch filters skipped: %v", err)
+			docs.valid = false
+		}
+	}
+	return docs
+}
+
+var errNotJSONDocument = errors.New("not a JSON document")
+
+func (h *watchHub) readJSON(ctx context.Context, version string) (interface{}, error) {
+	obj, err := h.fs.GetObject(ctx, fs.ObjectRef{
+		Version: version,
+	})
+	if err != nil {
+		return nil, err
+	} else if obj.Body == nil {
+		return nil, nil
+	}
+	defer obj.Body.Close()
+	if obj.Meta.IsDeleted() {
+		return nil, nil
+	}
+	var doc interface{}
+	dec := json.NewDecoder(io.LimitReader(obj.Body, maxWatchDocumentSize))
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errNotJSONDocument
+	}
+	return doc, nil
+}
+
+func (d *changeDocs) changed(filters []*JSONPath) []string {
+	if !d.valid {
+		return nil
+	}
+	var changed []string
+	for _, f := range filters {
+		var prev, current []interface{}
+		if d.prev != nil {
+			prev = f.Select(d.prev)
+		}
+		if d.current != nil {
+			current = f.Select(d.current)
+		}
+		if !reflect.DeepEqual(prev, current) {
+			changed = append(changed, f.String())
+		}
+	}
+	return changed
+}
+
+func (r *recordStore) Watch(opts WatchOptions) (*Watcher, error) {
+	return r.watches.Add(opts)
+}
+
+func (r *recordStore) WatchStats() *WatchStats {
+	return r.watches.Stats()
+}
+
+// notifyChange passes the change to watchers, webhooks, the search index and the namespace index.
+func (r *recordStore) notifyChange(change *RecordChange) {
+	r.cache.Invalidate(cache.Paths, change.Path)
+	r.expiry.track(change)
+	r.indexNamespace(change.ID, change.Path)
+	r.watches.Notify(change)
+	r.webhooks.Notify(change)
+	r.search.Notify(change)
+	if change.Op == WriteDelete {
+		r.dropAnnotations(change.ID)
+	}
+	if _, ok := namespaceOfConfig(change.Path); ok && change.Op != WriteDelete {
+		go r.checkNamespaceConfig(change.Path)
+	}
+	if _, _, ok := transferPath(change.Path); ok {
+		go func() {
+			if err := r.refreshTransfers(context.Background()); err != nil {
+				log.Warningf("failed to replay ownership transfers: %v", err)
+			}
+		}()
+	}
+}
+
+func (r *recordStore) localChange(op WriteOp, rec *Record, ann *proto.Announce, prevVersion string) *RecordChange {
+	return &RecordChange{
+		Op:              op,
+		ID:              rec.Id(),
+		Path:            rec.Path(),
+		Version:         rec.Current().Version(),
+		VersionPrevious: prevVersion,
+		NodeID:          r.nodeID,
+		Time:            time.Unix(0, ann.Timestamp()),
+	}
+}
+
+// syncChange describes a record imported during sync, deletes are detected
+// only when somebody is watching, since that requires an object lookup.
+func (r *recordStore) syncChange(rec *proto.Record, prevVersion string) *RecordChange {
+	ann := rec.Current().Announce()
+	change := &RecordChange{
+		Op:              WriteUpdate,
+		ID:              rec.Id(),
+		Path:            rec.Path(),
+		Version:         rec.Current().Version(),
+		VersionPrevious: prevVersion,
+		NodeID:          ann.NodeID(),
+		Time:            time.Unix(0, ann.Timestamp()),
+	}
+	if len(prevVersion) == 0 {
+		change.Op = WriteCreate
+	}
+	if !r.watches.Active() {
+		return change
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultWatchFetchTimeout)
+	defer cancelFn()
+	if ref, err := r.fs.HeadObject(ctx, fs.ObjectRef{
+		Version: change.Version,
+	}); err == nil && ref.Meta().IsDeleted() {
+		change.Op = WriteDelete
+	}
+	return change
+}
@@ -0,0 +1,551 @@
+package rs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/proxy"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Change webhooks notify downstream applications about record changes, unlike pre-commit
+// hooks they can't affect a write. Webhooks are kept in the state store and every change
+// matching the prefix and the operations of a webhook is queued there as a delivery, so
+// deliveries survive restarts. A delivery is POSTed as JSON signed with the secret of the
+// webhook, failed ones are retried with exponential backoff. Deliveries that failed all
+// attempts are moved to the dead-letter queue, where they can be inspected and requeued.
+
+const (
+	WebhookSignatureHeader = "X-Atlant-Signature"
+	WebhookTimestampHeader = "X-Atlant-Timestamp"
+	WebhookDeliveryHeader  = "X-Atlant-Delivery"
+	WebhookEventHeader     = "X-Atlant-Event"
+
+	defaultWebhookAttempts = 8
+	defaultWebhookDeadTTL  = 7 * 24 * time.Hour
+	webhookTimeout         = 10 * time.Second
+	webhookBackoffMin      = 5 * time.Second
+	webhookBackoffMax      = time.Hour
+	webhookPollInterval    = 5 * time.Second
+	webhookConcurrency     = 8
+	webhookSecretSize      = 32
+)
+
+var (
+	ErrWebhookExists   = errors.New("webhook with the same name exists")
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrWebhookURL      = errors.New("webhook URL must be http or https")
+)
+
+// ChangeWebhook is a registered receiver of record changes.
+type ChangeWebhook struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Prefix limits the webhook to records under the given path prefix.
+	Prefix string `json:"prefix"`
+	// Ops limits the webhook to the listed operations, all are delivered if it's empty.
+	Ops []string `json:"ops,omitempty"`
+	// Secret signs payloads, it's generated if not set on registration.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (w *ChangeWebhook) matches(change *RecordChange) bool {
+	if !strings.HasPrefix(change.Path, w.Prefix) {
+		return false
+	} else if len(w.Ops) == 0 {
+		return true
+	}
+	for _, op := range w.Ops {
+		if op == change.Op.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookPayload is the JSON body POSTed to webhooks.
+type WebhookPayload struct {
+	Delivery        string    `json:"delivery"`
+	Webhook         string    `json:"webhook"`
+	Op              string    `json:"op"`
+	ID              string    `json:"id"`
+	Path            string    `json:"path"`
+	Version         string    `json:"version"`
+	VersionPrevious string    `json:"version_previous,omitempty"`
+	NodeID          string    `json:"node_id"`
+	Time            time.Time `json:"time"`
+}
+
+// WebhookDelivery is a payload queued for a webhook or kept as a dead letter.
+type WebhookDelivery struct {
+	Payload     *WebhookPayload `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+type WebhookStats struct {
+	Webhooks   int    `json:"webhooks"`
+	Queued     int64  `json:"queued"`
+	Delivered  uint64 `json:"delivered_total"`
+	Failed     uint64 `json:"failed_total"`
+	DeadLetter uint64 `json:"dead_letters_total"`
+}
+
+// SignWebhookPayload returns the signature of a payload sent at the timestamp (Unix seconds),
+// receivers compute it over the raw body and compare with the signature header.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type webhookState struct {
+	ss          state.IndexedStore
+	client      *http.Client
+	maxAttempts int
+	deadTTL     time.Duration
+
+	mux      *sync.RWMutex
+	webhooks map[string]*ChangeWebhook
+	wakeC    chan struct{}
+
+	queued     int64
+	delivered  uint64
+	failed     uint64
+	deadLetter uint64
+}
+
+func newWebhookState(ss state.IndexedStore, maxAttempts int, deadTTL time.Duration) *webhookState {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultWebhookAttempts
+	}
+	w := &webhookState{
+		ss:          ss,
+		client:      proxy.Client(webhookTimeout),
+		maxAttempts: maxAttempts,
+		deadTTL:     deadTTL,
+		mux:         new(sync.RWMutex),
+		webhooks:    make(map[string]*ChangeWebhook),
+		wakeC:       make(chan struct{}, 1),
+	}
+	w.load()
+	return w
+}
+
+func (w *webhookState) load() {
+	if _, err := w.ss.RangePeek(context.Background(), state.NewBucket(state.BucketWebhooks), func(k *state.Key, v []byte) error {
+		var hook ChangeWebhook
+		if err := json.Unmarshal(v, &hook); err != nil {
+			log.Warningf("skipping malformed webhook: %v", err)
+			return nil
+		}
+		w.webhooks[hook.Name] = &hook
+		return nil
+	}); err != nil {
+		log.Warningf("failed to load webhooks: %v", err)
+	}
+	if _, err := w.ss.RangeKeys(context.Background(), state.NewBucket(state.BucketWebhookQueue), func(k *state.Key) error {
+		w.queued++
+		return nil
+	}); err != nil {
+		log.Warningf("failed to count queued webhook deliveries: %v", err)
+	}
+}
+
+func (w *webhookState) register(hook *ChangeWebhook) error {
+	if !strings.HasPrefix(hook.URL, "http://") && !strings.HasPrefix(hook.URL, "https://") {
+		return ErrWebhookURL
+	}
+	for _, op := range hook.Ops {
+		switch op {
+		case WriteCreate.String(), WriteUpdate.String(), WriteDelete.String():
+		default:
+			return fmt.Errorf("unknown operation: %s", op)
+		}
+	}
+	if len(hook.Secret) == 0 {
+		secret := make([]byte, webhookSecretSize)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		hook.Secret = hex.EncodeToString(secret)
+	}
+	hook.CreatedAt = time.Now().UTC()
+	data, err := json.Marshal(hook)
+	if err != nil {
+		return err
+	}
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if _, ok := w.webhooks[hook.Name]; ok {
+		return ErrWebhookExists
+	}
+	if err := w.ss.Update(context.Background(), state.WebhooksKey(hook.Name), func(k *state.Key, v []byte) ([]byte, error) {
+		return data, nil
+	}); err != nil {
+		return err
+	}
+	w.webhooks[hook.Name] = hook
+	return nil
+}
+
+// unregister removes the webhook, its queued deliveries are dropped when their turn comes.
+func (w *webhookState) unregister(name string) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if _, ok := w.webhooks[name]; !ok {
+		return ErrWebhookNotFound
+	}
+	if err := w.ss.Delete(state.WebhooksKey(name)); err != nil && err != state.ErrNotFound {
+		return err
+	}
+	delete(w.webhooks, name)
+	return nil
+}
+
+func (w *webhookState) get(name string) *ChangeWebhook {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	return w.webhooks[name]
+}
+
+// list returns webhooks without their secrets.
+func (w *webhookState) list() []*ChangeWebhook {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	list := make([]*ChangeWebhook, 0, len(w.webhooks))
+	for _, hook := range w.webhooks {
+		h := *hook
+		h.Secret = ""
+		list = append(list, &h)
+	}
+	return list
+}
+
+// Notify queues a delivery of the change for every matching webhook.
+func (w *webhookState) Notify(change *RecordChange) {
+	w.mux.RLock()
+	var matching []string
+	for name, hook := range w.webhooks {
+		if hook.matches(change) {
+			matching = append(matching, name)
+		}
+	}
+	w.mux.RUnlock()
+	if len(matching) == 0 {
+		return
+	}
+	for _, name := range matching {
+		d := &WebhookDelivery{
+			Payload: &WebhookPayload{
+				Delivery:        proto.NewID(),
+				Webhook:         name,
+				Op:              change.Op.String(),
+				ID:              change.ID,
+				Path:            change.Path,
+				Version:         change.Version,
+				VersionPrevious: change.VersionPrevious,
+				NodeID:          change.NodeID,
+				Time:            change.Time,
+			},
+			NextAttempt: time.Now(),
+		}
+		if err := w.store(state.BucketWebhookQueue, d, 0); err != nil {
+			log.WithFields(log.Fields{
+				"webhook": name,
+				"path":    change.Path,
+			}).Warningf("failed to queue webhook delivery: %v", err)
+			continue
+		}
+		atomic.AddInt64(&w.queued, 1)
+	}
+	w.wake()
+}
+
+func (w *webhookState) wake() {
+	select {
+	case w.wakeC <- struct{}{}:
+	default:
+	}
+}
+
+func (w *webhookState) store(bucket state.BucketID, d *WebhookDelivery, ttl time.Duration) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	k := state.NewKey(bucket, []byte(d.Payload.Delivery))
+	k.TTL = ttl
+	return w.ss.Update(context.Background(), k, func(k *state.Key, v []byte) ([]byte, error) {
+		return data, nil
+	})
+}
+
+func (w *webhookState) remove(bucket state.BucketID, id string) error {
+	err := w.ss.Delete(state.NewKey(bucket, []byte(id)))
+	if err != nil && err != state.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func (w *webhookState) deliveries(bucket state.BucketID, fn func(d *WebhookDelivery) bool) error {
+	_, err := w.ss.RangePeek(context.Background(), state.NewBucket(bucket), func(k *state.Key, v []byte) error {
+		var d WebhookDelivery
+		if err := json.Unmarshal(v, &d); err != nil || d.Payload == nil {
+			log.Debugf("skipping malformed webhook delivery: %v", err)
+			return nil
+		}
+		if !fn(&d) {
+			return state.ErrRangeStop
+		}
+		return nil
+	})
+	return err
+}
+
+// due returns up to max queued deliveries whose next attempt is due.
+func (w *webhookState) due(max int) []*WebhookDelivery {
+	now := time.Now()
+	var list []*WebhookDelivery
+	if err := w.deliveries(state.BucketWebhookQueue, func(d *WebhookDelivery) bool {
+		if !d.NextAttempt.After(now) {
+			list = append(list, d)
+		}
+		return len(list) < max
+	}); err != nil {
+		log.Warningf("failed to read webhook queue: %v", err)
+	}
+	return list
+}
+
+func (w *webhookState) run(ctx context.Context) {
+	t := time.NewTicker(webhookPollInterval)
+	defer t.Stop()
+	for {
+		for {
+			list := w.due(webhookConcurrency)
+			if len(list) == 0 {
+				break
+			}
+			wg := new(sync.WaitGroup)
+			for _, d := range list {
+				wg.Add(1)
+				go func(d *WebhookDelivery) {
+					defer wg.Done()
+					w.attempt(ctx, d)
+				}(d)
+			}
+			wg.Wait()
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-w.wakeC:
+		}
+	}
+}
+
+// attempt sends the delivery once and reschedules, dead-letters or removes it.
+func (w *webhookState) attempt(ctx context.Context, d *WebhookDelivery) {
+	id := d.Payload.Delivery
+	hook := w.get(d.Payload.Webhook)
+	if hook == nil {
+		// the webhook was unregistered
+		if err := w.remove(state.BucketWebhookQueue, id); err == nil {
+			atomic.AddInt64(&w.queued, -1)
+		}
+		return
+	}
+	fields := log.Fields{
+		"webhook":  hook.Name,
+		"delivery": id,
+		"path":     d.Payload.Path,
+	}
+	err := w.send(ctx, hook, d.Payload)
+	if err == nil {
+		if err := w.remove(state.BucketWebhookQueue, id); err != nil {
+			log.WithFields(fields).Warningf("failed to remove delivered webhook delivery: %v", err)
+			return
+		}
+		atomic.AddInt64(&w.queued, -1)
+		atomic.AddUint64(&w.delivered, 1)
+		return
+	} else if ctx.Err() != nil {
+		return
+	}
+	atomic.AddUint64(&w.failed, 1)
+	d.Attempts++
+	d.LastError = err.Error()
+	if d.Attempts >= w.maxAttempts {
+		log.WithFields(fields).Warningf("webhook delivery failed %d times, moving to dead letters: %v", d.Attempts, err)
+		if err := w.store(state.BucketWebhookDead, d, w.deadTTL); err != nil {
+			log.WithFields(fields).Warningf("failed to store dead letter: %v", err)
+			return
+		}
+		if err := w.remove(state.BucketWebhookQueue, id); err == nil {
+			atomic.AddInt64(&w.queued, -1)
+		}
+		atomic.AddUint64(&w.deadLetter, 1)
+		return
+	}
+	backoff := webhookBackoffMin << uint(d.Attempts-1)
+	if backoff > webhookBackoffMax || backoff <= 0 {
+		backoff = webhookBackoffMax
+	}
+	d.NextAttempt = time.Now().Add(backoff)
+	log.WithFields(fields).Debugf("webhook delivery failed, retrying in %s: %v", backoff, err)
+	if err := w.store(state.BucketWebhookQueue, d, 0); err != nil {
+		log.WithFields(fields).Warningf("failed to reschedule webhook delivery: %v", err)
+	}
+}
+
+func (w *webhookState) send(ctx context.Context, hook *ChangeWebhook, payload *WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(hook.Secret, timestamp, body))
+	req.Header.Set(WebhookDeliveryHeader, payload.Delivery)
+	req.Header.Set(WebhookEventHeader, payload.Op)
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// requeue moves dead letters back to the queue, all of them if id is empty.
+func (w *webhookState) requeue(id string) (int, error) {
+	var list []*WebhookDelivery
+	if err := w.deliveries(state.BucketWebhookDead, func(d *WebhookDelivery) bool {
+		if len(id) == 0 || d.Payload.Delivery == id {
+			list = append(list, d)
+		}
+		return true
+	}); err != nil {
+		return 0, err
+	}
+	for i, d := range list {
+		d.Attempts = 0
+		d.NextAttempt = time.Now()
+		if err := w.store(state.BucketWebhookQueue, d, 0); err != nil {
+			return i, err
+		}
+		atomic.AddInt64(&w.queued, 1)
+		if err := w.remove(state.BucketWebhookDead, d.Payload.Delivery); err != nil {
+			return i, err
+		}
+	}
+	w.wake()
+	return len(list), nil
+}
+
+// purge drops dead letters, all of them if id is empty.
+func (w *webhookState) purge(id string) (int, error) {
+	var ids []string
+	if err := w.deliveries(state.BucketWebhookDead, func(d *WebhookDelivery) bool {
+		if len(id) == 0 || d.Payload.Delivery == id {
+			ids = append(ids, d.Payload.Delivery)
+		}
+		return true
+	}); err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := w.remove(state.BucketWebhookDead, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+func (w *webhookState) Stats() *WebhookStats {
+	w.mux.RLock()
+	webhooks := len(w.webhooks)
+	w.mux.RUnlock()
+	return &WebhookStats{
+		Webhooks:   webhooks,
+		Queued:     atomic.LoadInt64(&w.queued),
+		Delivered:  atomic.LoadUint64(&w.delivered),
+		Failed:     atomic.LoadUint64(&w.failed),
+		DeadLetter: atomic.LoadUint64(&w.deadLetter),
+	}
+}
+
+func (r *recordStore) RegisterWebhook(hook *ChangeWebhook) error {
+	return r.webhooks.register(hook)
+}
+
+func (r *recordStore) UnregisterWebhook(name string) error {
+	return r.webhooks.unregister(name)
+}
+
+func (r *recordStore) ListWebhooks() []*ChangeWebhook {
+	return r.webhooks.list()
+}
+
+// DeliverWebhooks sends queued deliveries until the context is done.
+func (r *recordStore) DeliverWebhooks(ctx context.Context) {
+	r.webhooks.run(ctx)
+}
+
+func (r *recordStore) DeadLetters() ([]*WebhookDelivery, error) {
+	var list []*WebhookDelivery
+	if err := r.webhooks.deliveries(state.BucketWebhookDead, func(d *WebhookDelivery) bool {
+		list = append(list, d)
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *recordStore) RequeueDeadLetters(id string) (int, error) {
+	return r.webhooks.requeue(id)
+}
+
+func (r *recordStore) PurgeDeadLetters(id string) (int, error) {
+	return r.webhooks.purge(id)
+}
+
+func (r *recordStore) WebhookStats() *WebhookStats {
+	return r.webhooks.Stats()
+}
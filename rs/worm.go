@@ -0,0 +1,129 @@
+package rs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// Records of WORM (write once, read many) namespaces are immutable after the first write,
+// they are never updated or deleted: local writes are refused, announces and synced records
+// of peers that change such records are dropped. A namespace is made WORM with "worm": true
+// in its settings. Once a node has seen the flag the namespace stays WORM on it for good,
+// the flag is kept in the state store and dropping it from the settings has no effect.
+
+var ErrWORMNamespace = errors.New("records of a write-once namespace can't be updated or deleted")
+
+type wormState struct {
+	mux   *sync.RWMutex
+	since map[string]time.Time
+}
+
+func newWORMState() *wormState {
+	return &wormState{
+		mux:   new(sync.RWMutex),
+		since: make(map[string]time.Time),
+	}
+}
+
+type wormEntry struct {
+	Namespace string    `json:"namespace"`
+	Since     time.Time `json:"since"`
+}
+
+// isWORM tells whether the record at path is in a WORM namespace.
+func (r *recordStore) isWORM(path string) bool {
+	ns := NamespaceOf(path)
+	if len(ns) == 0 {
+		return false
+	}
+	r.worm.mux.RLock()
+	_, ok := r.worm.since[ns]
+	r.worm.mux.RUnlock()
+	return ok
+}
+
+// loadWORM reads namespaces that were made WORM before.
+func (r *recordStore) loadWORM() {
+	b := state.NewBucket(state.BucketWORM)
+	if _, err := r.ss.RangePeek(context.Background(), b, func(k *state.Key, v []byte) error {
+		var e wormEntry
+		if err := json.Unmarshal(v, &e); err != nil || len(e.Namespace) == 0 {
+			log.Warningf("skipping malformed WORM namespace: %v", err)
+			return nil
+		}
+		r.worm.mux.Lock()
+		r.worm.since[e.Namespace] = e.Since
+		r.worm.mux.Unlock()
+		return nil
+	}); err != nil {
+		log.Warningf("failed to load WORM namespaces: %v", err)
+	}
+}
+
+func (r *recordStore) markWORM(ns string) {
+	r.worm.mux.RLock()
+	_, ok := r.worm.since[ns]
+	r.worm.mux.RUnlock()
+	if ok {
+		return
+	}
+	e := &wormEntry{
+		Namespace: ns,
+		Since:     time.Now().UTC(),
+	}
+	data, _ := json.Marshal(e)
+	if err := r.ss.Update(context.Background(), state.WORMKey(ns), func(k *state.Key, v []byte) ([]byte, error) {
+		if v != nil {
+			return nil, state.ErrNoUpdate
+		}
+		return data, nil
+	}); err != nil {
+		log.WithField("namespace", ns).Warningf("failed to keep WORM flag: %v", err)
+	}
+	r.worm.mux.Lock()
+	if _, ok := r.worm.since[ns]; !ok {
+		r.worm.since[ns] = e.Since
+	}
+	r.worm.mux.Unlock()
+	log.WithField("namespace", ns).Infoln("namespace is write-once from now on")
+}
+
+// WatchWORM checks settings of namespaces for the WORM flag, settings that change
+// in between are checked right away.
+func (r *recordStore) WatchWORM(ctx context.Context, interval time.Duration) {
+	t := time.NewTimer(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			configs, err := r.namespaceConfigs(ctx)
+			if err != nil {
+				log.Warningf("failed to load namespace settings: %v", err)
+			}
+			for ns, cfg := range configs {
+				if cfg.WORM {
+					r.markWORM(ns)
+				}
+			}
+			t.Reset(interval)
+		}
+	}
+}
+
+func (r *recordStore) WORMNamespaces() map[string]time.Time {
+	r.worm.mux.RLock()
+	defer r.worm.mux.RUnlock()
+	namespaces := make(map[string]time.Time, len(r.worm.since))
+	for ns, since := range r.worm.since {
+		namespaces[ns] = since
+	}
+	return namespaces
+}
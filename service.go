@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+// With --service the node reports its state to the service manager it runs under. On Linux
+// systemd is notified over NOTIFY_SOCKET once the node is started, see Type=notify units,
+// its watchdog is pinged at half of WatchdogSec, and the stop timeout of the unit is extended
+// by the drain. On Windows the node runs as a service of the service control manager, stop
+// and shutdown requests drain it like SIGTERM does elsewhere.
+
+// serviceManager is notified of the state of the node.
+type serviceManager interface {
+	// Ready reports that the node has started.
+	Ready()
+	// Stopping reports that the node drains for up to timeout.
+	Stopping(timeout time.Duration)
+	// Stopped reports that the node has stopped, the process exits next.
+	Stopped()
+}
+
+// noService is used when the node doesn't run under a service manager.
+type noService struct{}
+
+func (noService) Ready()                 {}
+func (noService) Stopping(time.Duration) {}
+func (noService) Stopped()               {}
+
+// serviceStopGrace is added to the drain timeout reported to service managers, it covers
+// closing of the stores after the drain.
+const serviceStopGrace = 30 * time.Second
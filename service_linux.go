@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type systemdService struct {
+	socket   string
+	stopOnce *sync.Once
+	stopC    chan struct{}
+}
+
+// startService notifies systemd of the node, the node is stopped by SIGTERM of systemd.
+func startService(_ func()) serviceManager {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if len(socket) == 0 {
+		log.Warningln("--service has no effect: NOTIFY_SOCKET is not set, the unit must be of Type=notify")
+		return noService{}
+	}
+	s := &systemdService{
+		socket:   socket,
+		stopOnce: new(sync.Once),
+		stopC:    make(chan struct{}),
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		go s.watchdog(interval)
+	}
+	return s
+}
+
+// watchdogInterval returns half of WatchdogSec of the unit, zero if it has no watchdog.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); len(pid) > 0 && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+func (s *systemdService) watchdog(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stopC:
+			return
+		case <-t.C:
+			s.notify("WATCHDOG=1")
+		}
+	}
+}
+
+// notify sends the state to systemd, see sd_notify(3).
+func (s *systemdService) notify(state string) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{
+		Name: s.socket,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		log.Debugf("failed to notify systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Debugf("failed to notify systemd: %v", err)
+	}
+}
+
+func (s *systemdService) Ready() {
+	s.notify("READY=1\nSTATUS=serving")
+}
+
+func (s *systemdService) Stopping(timeout time.Duration) {
+	extend := (timeout + serviceStopGrace) / time.Microsecond
+	s.notify(fmt.Sprintf("STOPPING=1\nSTATUS=draining\nEXTEND_TIMEOUT_USEC=%d", extend))
+}
+
+func (s *systemdService) Stopped() {
+	s.stopOnce.Do(func() {
+		close(s.stopC)
+	})
+}
@@ -0,0 +1,10 @@
+//+build !linux,!windows
+
+package main
+
+import log "github.com/sirupsen/logrus"
+
+func startService(_ func()) serviceManager {
+	log.Warningln("--service has no effect: no service manager is supported on this platform")
+	return noService{}
+}
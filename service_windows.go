@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+)
+
+const windowsServiceName = "atlant-go"
+
+type windowsService struct {
+	stop      func()
+	readyC    chan struct{}
+	stoppingC chan time.Duration
+	stoppedC  chan struct{}
+	doneC     chan struct{}
+	stopOnce  *sync.Once
+}
+
+// startService runs the node as a Windows service, stop and shutdown requests of the
+// service control manager call stop.
+func startService(stop func()) serviceManager {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		log.Warningf("--service has no effect: failed to detect the service control manager: %v", err)
+		return noService{}
+	} else if !isService {
+		log.Warningln("--service has no effect: the node is not started by the service control manager")
+		return noService{}
+	}
+	s := &windowsService{
+		stop:      stop,
+		readyC:    make(chan struct{}),
+		stoppingC: make(chan time.Duration, 1),
+		stoppedC:  make(chan struct{}),
+		doneC:     make(chan struct{}),
+		stopOnce:  new(sync.Once),
+	}
+	go func() {
+		defer close(s.doneC)
+		if err := svc.Run(windowsServiceName, s); err != nil {
+			log.Errorf("failed to run as Windows service: %v", err)
+		}
+	}()
+	return s
+}
+
+// Execute reports the state of the node to the service control manager until it's stopped.
+func (s *windowsService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.StartPending}
+	readyC := s.readyC
+	for {
+		select {
+		case <-readyC:
+			readyC = nil
+			status <- svc.Status{State: svc.Running, Accepts: accepts}
+		case timeout := <-s.stoppingC:
+			readyC = nil
+			status <- svc.Status{
+				State:    svc.StopPending,
+				WaitHint: uint32((timeout + serviceStopGrace) / time.Millisecond),
+			}
+		case <-s.stoppedC:
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				// the node drains as if it got SIGTERM, Stopping reports it
+				go s.stop()
+			}
+		}
+	}
+}
+
+func (s *windowsService) Ready() {
+	close(s.readyC)
+}
+
+func (s *windowsService) Stopping(timeout time.Duration) {
+	select {
+	case s.stoppingC <- timeout:
+	default:
+	}
+}
+
+// Stopped waits until the service control manager is told that the service has stopped.
+func (s *windowsService) Stopped() {
+	s.stopOnce.Do(func() {
+		close(s.stoppedC)
+	})
+	select {
+	case <-s.doneC:
+	case <-time.After(5 * time.Second):
+	}
+}
@@ -0,0 +1,49 @@
+package state
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrBackupUnsupported = errors.New("state backend doesn't support backups")
+
+// backupStore is implemented by backends able to dump and load their contents.
+type backupStore interface {
+	Backup(w io.Writer, since uint64) (uint64, error)
+	Load(r io.Reader) error
+}
+
+// CanBackup tells whether the backend of the store supports backups.
+func CanBackup(s IndexedStore) bool {
+	_, ok := s.(backupStore)
+	return ok
+}
+
+// Backup writes a consistent dump of keys changed since the given version into w,
+// zero since dumps the whole store. It returns the version to pass as since
+// to make the next incremental dump.
+func Backup(s IndexedStore, w io.Writer, since uint64) (uint64, error) {
+	b, ok := s.(backupStore)
+	if !ok {
+		return 0, ErrBackupUnsupported
+	}
+	return b.Backup(w, since)
+}
+
+// Restore loads a dump made by Backup into the store, incremental dumps
+// must be loaded in the order they were made.
+func Restore(s IndexedStore, r io.Reader) error {
+	b, ok := s.(backupStore)
+	if !ok {
+		return ErrBackupUnsupported
+	}
+	return b.Load(r)
+}
+
+func (s *badgerStore) Backup(w io.Writer, since uint64) (uint64, error) {
+	return s.db.Backup(w, since)
+}
+
+func (s *badgerStore) Load(r io.Reader) error {
+	return s.db.Load(r)
+}
@@ -1,19 +1,30 @@
 package state
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/dgraph-io/badger"
+	log "github.com/sirupsen/logrus"
 )
 
 // badgerStore implements IndexedStore.
 type badgerStore struct {
-	opts *storeOptions
-	db   *badger.DB
+	dir    string
+	opts   *storeOptions
+	db     *badger.DB
+	guard  *sizeGuard
+	retry  *conflictRetrier
+	damage *damageTracker
+	maint  *maintenance
+	spare  *spareKeeper
+	// integrity frames values with checksums and seals values of encrypted buckets.
+	integrity *integrity
 }
 
 func newBadgerStore(prefix string, opts ...storeOpt) (*badgerStore, error) {
 	s := &badgerStore{
+		dir:  prefix,
 		opts: defaultStoreOptions(),
 	}
 	for _, o := range opts {
@@ -25,15 +36,44 @@ func newBadgerStore(prefix string, opts ...storeOpt) (*badgerStore, error) {
 	badgerOpts.Dir = prefix
 	badgerOpts.ValueDir = prefix
 	badgerOpts.SyncWrites = s.opts.SyncWrites
-	db, err := badger.Open(badgerOpts)
+	var err error
+	if s.integrity, err = newIntegrity(prefix, s.opts); err != nil {
+		return nil, err
+	}
+	s.damage = newDamageTracker(prefix)
+	var db *badger.DB
+	if s.opts.Recover {
+		db, err = openBadgerRecover(badgerOpts, s.opts.SpareDir, s.damage)
+	} else {
+		db, err = badger.Open(badgerOpts)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if report := s.damage.Report(); report != nil {
+		log.WithFields(log.Fields{
+			"action":      report.Action,
+			"quarantined": report.QuarantinedTotal,
+		}).Warningln("state store runs degraded, see the damage report")
+	}
 	s.db = db
+	s.guard = newSizeGuard(s.opts.MaxValueSize)
+	s.retry = newConflictRetrier(s.opts.ConflictRetries, s.opts.ConflictModes)
+	s.maint = newMaintenance(db, prefix, s.opts.MaintenanceInterval)
+	s.maint.start()
+	if len(s.opts.SpareDir) > 0 && s.opts.SpareInterval > 0 {
+		s.spare = newSpareKeeper(db, s.opts.SpareDir, s.opts.SpareInterval)
+		s.spare.start()
+	}
 	return s, nil
 }
 
-func (s *badgerStore) View(k *Key, fn PeekFunc) error {
+func (s *badgerStore) View(ctx context.Context, k *Key, fn PeekFunc) error {
+	if err := s.guard.checkKey(k); err != nil {
+		return err
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
 	return s.db.View(func(tx *badger.Txn) error {
 		v, err := tx.Get(k.Bytes())
 		if err == badger.ErrKeyNotFound {
@@ -42,8 +82,10 @@ func (s *badgerStore) View(k *Key, fn PeekFunc) error {
 			err = fmt.Errorf("item get error: %v", err)
 			return err
 		}
-		vv, err := v.Value()
-		if err != nil {
+		vv, err := s.openItem(v)
+		if err == ErrStateKey || IsCorrupted(err) {
+			return err
+		} else if err != nil {
 			err = fmt.Errorf("value read error: %v", err)
 			return err
 		}
@@ -51,136 +93,196 @@ func (s *badgerStore) View(k *Key, fn PeekFunc) error {
 	})
 }
 
-func (s *badgerStore) Update(k *Key, fn ModifyFunc) error {
-	return s.db.Update(func(tx *badger.Txn) error {
-		if fn == nil {
-			return nil
-		}
-		key := k.Bytes()
-		v, err := tx.Get(key)
-		if err == badger.ErrKeyNotFound {
-			vv, err := fn(k, nil)
-			if err == ErrNoUpdate {
-				return nil
-			} else if err != nil {
-				return err
-			}
-			if k.TTL > 0 {
-				return tx.SetWithTTL(key, vv, k.TTL)
-			}
-			return tx.Set(key, vv)
-		} else if err != nil {
-			err = fmt.Errorf("item set error: %v", err)
-			return err
-		}
-		vv, err := v.ValueCopy(nil)
-		if err != nil {
+func (s *badgerStore) Update(ctx context.Context, k *Key, fn ModifyFunc) error {
+	if err := s.guard.checkKey(k); err != nil {
+		return err
+	}
+	if fn == nil {
+		return nil
+	}
+	return s.retry.Do(k.Bucket.ID, func() error {
+		// conflicts are not retried past the deadline
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		vv, err = fn(k, vv)
+		return s.db.Update(func(tx *badger.Txn) error {
+			return s.update(tx, k, fn)
+		})
+	})
+}
+
+func (s *badgerStore) update(tx *badger.Txn, k *Key, fn ModifyFunc) error {
+	key := k.Bytes()
+	v, err := tx.Get(key)
+	if err == badger.ErrKeyNotFound {
+		vv, err := fn(k, nil)
 		if err == ErrNoUpdate {
 			return nil
 		} else if err != nil {
 			return err
+		} else if err := s.guard.checkValue(k, vv); err != nil {
+			return err
+		} else if vv, err = s.integrity.seal(k, vv); err != nil {
+			return err
 		}
 		if k.TTL > 0 {
 			return tx.SetWithTTL(key, vv, k.TTL)
 		}
 		return tx.Set(key, vv)
-	})
+	} else if err != nil {
+		err = fmt.Errorf("item set error: %v", err)
+		return err
+	}
+	vv, err := v.ValueCopy(nil)
+	if err != nil {
+		return err
+	} else if vv, _, err = s.integrity.open(key, vv); err != nil {
+		if IsCorrupted(err) {
+			s.damage.quarantine(key, err)
+		}
+		return err
+	}
+	vv, err = fn(k, vv)
+	if err == ErrNoUpdate {
+		return nil
+	} else if err != nil {
+		return err
+	} else if err := s.guard.checkValue(k, vv); err != nil {
+		return err
+	} else if vv, err = s.integrity.seal(k, vv); err != nil {
+		return err
+	}
+	if k.TTL > 0 {
+		return tx.SetWithTTL(key, vv, k.TTL)
+	}
+	return tx.Set(key, vv)
 }
 
-func (s *badgerStore) RangeKeys(b Bucket, fn KeyFunc) (*RangeOptions, error) {
-	var opt *RangeOptions
+func (s *badgerStore) RangeKeys(ctx context.Context, b Bucket, fn KeyFunc) (*RangeOptions, error) {
+	w := newRangeWalk(ctx, b)
 	err := s.db.View(func(tx *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
-		opts.PrefetchSize = 10
+		opts.PrefetchSize = w.prefetch(10)
 		opts.PrefetchValues = false
 		it := tx.NewIterator(opts)
 		defer it.Close()
 
-		for it.Seek(b.NewKey(nil).Bytes()); it.Valid(); it.Next() {
+		for it.Seek(w.start); it.Valid(); it.Next() {
 			item := it.Item()
-			k := (&Key{}).Unmarshal(item.Key())
-			if k.Bucket.ID != b.ID {
+			switch w.next(item.Key()) {
+			case rangeDone:
 				return nil
+			case rangeSkip:
+				continue
+			}
+			k := (&Key{}).Unmarshal(item.Key())
+			if err := fn(k); err == ErrRangeStop {
+				w.stop()
+			} else if err != nil {
+				return err
 			}
-			fn(k)
 		}
 		return nil
 	})
-	return opt, err
+	return w.result(err)
 }
 
-func (s *badgerStore) RangePeek(b Bucket, fn PeekFunc) (*RangeOptions, error) {
-	var opt *RangeOptions
+func (s *badgerStore) RangePeek(ctx context.Context, b Bucket, fn PeekFunc) (*RangeOptions, error) {
+	w := newRangeWalk(ctx, b)
 	err := s.db.View(func(tx *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
-		opts.PrefetchSize = 10
+		opts.PrefetchSize = w.prefetch(10)
 		it := tx.NewIterator(opts)
 		defer it.Close()
 
-		for it.Seek(b.NewKey(nil).Bytes()); it.Valid(); it.Next() {
+		for it.Seek(w.start); it.Valid(); it.Next() {
 			item := it.Item()
-			k := (&Key{}).Unmarshal(item.Key())
-			if k.Bucket.ID != b.ID {
+			switch w.next(item.Key()) {
+			case rangeDone:
 				return nil
+			case rangeSkip:
+				continue
 			}
-			v, err := it.Item().Value()
-			if err != nil {
+			k := (&Key{}).Unmarshal(item.Key())
+			v, err := s.openItem(item)
+			if err != nil && (isCorruption(err) || IsCorrupted(err)) {
+				continue
+			} else if err != nil {
 				return err
 			}
 			if err := fn(k, v); err == ErrRangeStop {
-				return nil
+				w.stop()
 			} else if err != nil {
 				return err
 			}
 		}
 		return nil
 	})
-	return opt, err
+	return w.result(err)
 }
 
-func (s *badgerStore) RangeModify(b Bucket, fn ModifyFunc) (*RangeOptions, error) {
-	var opt *RangeOptions
-	err := s.db.Update(func(tx *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchSize = 10
-		it := tx.NewIterator(opts)
-		defer it.Close()
+func (s *badgerStore) RangeModify(ctx context.Context, b Bucket, fn ModifyFunc) (*RangeOptions, error) {
+	var w *rangeWalk
+	err := s.retry.Do(b.ID, func() error {
+		// a retried transaction starts the range over
+		w = newRangeWalk(ctx, b)
+		return s.db.Update(func(tx *badger.Txn) error {
+			return s.rangeModify(tx, w, fn)
+		})
+	})
+	return w.result(err)
+}
 
-		for it.Seek(b.NewKey(nil).Bytes()); it.Valid(); it.Next() {
-			item := it.Item()
-			k := (&Key{}).Unmarshal(item.Key())
-			if k.Bucket.ID != b.ID {
-				return nil
-			}
-			v, err := it.Item().Value()
-			if err != nil {
-				return err
-			}
-			vv, err := fn(k, v)
-			if err == ErrNoUpdate {
-				continue
-			} else if err != nil && err != ErrRangeStop {
-				return err
-			} else if err := tx.Set(item.Key(), vv); err != nil {
-				return err
-			}
-			if err == ErrRangeStop {
-				return nil
-			}
+func (s *badgerStore) rangeModify(tx *badger.Txn, w *rangeWalk, fn ModifyFunc) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchSize = w.prefetch(10)
+	it := tx.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(w.start); it.Valid(); it.Next() {
+		item := it.Item()
+		switch w.next(item.Key()) {
+		case rangeDone:
+			// writes of a canceled range are discarded
+			return w.err
+		case rangeSkip:
+			continue
 		}
-		return nil
-	})
-	return opt, err
+		k := (&Key{}).Unmarshal(item.Key())
+		v, err := s.openItem(item)
+		if err != nil && (isCorruption(err) || IsCorrupted(err)) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		vv, err := fn(k, v)
+		if err == ErrNoUpdate {
+			continue
+		} else if err != nil && err != ErrRangeStop {
+			return err
+		} else if err := s.guard.checkValue(k, vv); err != nil {
+			return err
+		}
+		sealed, sealErr := s.integrity.seal(k, vv)
+		if sealErr != nil {
+			return sealErr
+		} else if err := tx.Set(item.Key(), sealed); err != nil {
+			return err
+		}
+		if err == ErrRangeStop {
+			w.stop()
+		}
+	}
+	return nil
 }
 
 func (s *badgerStore) Delete(k *Key) error {
 	if k == nil {
 		return nil
+	} else if err := s.guard.checkKey(k); err != nil {
+		return err
 	}
-	return s.db.View(func(tx *badger.Txn) error {
+	return s.db.Update(func(tx *badger.Txn) error {
 		if err := tx.Delete(k.Bytes()); err == badger.ErrKeyNotFound {
 			return nil
 		} else if err != nil {
@@ -190,6 +292,38 @@ func (s *badgerStore) Delete(k *Key) error {
 	})
 }
 
+func (s *badgerStore) Stats() *StoreStats {
+	stats := s.guard.Stats()
+	stats.Conflicts = s.retry.Stats()
+	stats.DiskUsage = dirSize(s.dir)
+	stats.Maintenance = s.maint.Stats()
+	return stats
+}
+
+func (s *badgerStore) Maintenance() *MaintenanceStats {
+	stats := s.maint.Stats()
+	if s.spare != nil {
+		stats.Spare = s.spare.Stats()
+	}
+	return stats
+}
+
+func (s *badgerStore) RunMaintenance() (*MaintenanceRun, error) {
+	return s.maint.run(true)
+}
+
+func (s *badgerStore) Damage() *DamageReport {
+	return s.damage.Report()
+}
+
+func (s *badgerStore) ClearDamage() error {
+	return s.damage.clear()
+}
+
 func (s *badgerStore) Close() error {
+	s.maint.stop()
+	if s.spare != nil {
+		s.spare.stop()
+	}
 	return s.db.Close()
 }
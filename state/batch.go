@@ -0,0 +1,241 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/dgraph-io/badger"
+)
+
+// ErrTxnTooBig is returned by Batch when the writes don't fit into a single transaction,
+// callers should split the batch.
+var ErrTxnTooBig = errors.New("transaction is too big")
+
+// Txn stages reads and writes of a batch, keys of any bucket may be used.
+// Reads see the writes staged earlier in the same batch.
+type Txn interface {
+	View(k *Key, fn PeekFunc) error
+	Update(k *Key, fn ModifyFunc) error
+	Set(k *Key, v []byte) error
+	Delete(k *Key) error
+}
+
+func setValue(v []byte) ModifyFunc {
+	return func(k *Key, _ []byte) ([]byte, error) {
+		return v, nil
+	}
+}
+
+// badgerTxn stages writes in a badger transaction, WriteBatch is not available in
+// the badger version used, but a transaction is committed with a single sync as well.
+type badgerTxn struct {
+	s  *badgerStore
+	tx *badger.Txn
+}
+
+// Batch runs fn in a single transaction, it's retried on write conflicts as a whole,
+// so fn may be called more than once. Batches are optimistic regardless of bucket modes.
+func (s *badgerStore) Batch(fn func(tx Txn) error) error {
+	err := s.retry.DoBatch(func() error {
+		return s.db.Update(func(tx *badger.Txn) error {
+			return fn(&badgerTxn{
+				s:  s,
+				tx: tx,
+			})
+		})
+	})
+	if err == badger.ErrTxnTooBig {
+		return ErrTxnTooBig
+	}
+	return err
+}
+
+func (t *badgerTxn) View(k *Key, fn PeekFunc) error {
+	if err := t.s.guard.checkKey(k); err != nil {
+		return err
+	}
+	item, err := t.tx.Get(k.Bytes())
+	if err == badger.ErrKeyNotFound {
+		return ErrNotFound
+	} else if err != nil {
+		err = fmt.Errorf("item get error: %v", err)
+		return err
+	}
+	v, err := t.s.openItem(item)
+	if err == ErrStateKey || IsCorrupted(err) {
+		return err
+	} else if err != nil {
+		err = fmt.Errorf("value read error: %v", err)
+		return err
+	}
+	return fn(k, v)
+}
+
+func (t *badgerTxn) Update(k *Key, fn ModifyFunc) error {
+	if err := t.s.guard.checkKey(k); err != nil {
+		return err
+	} else if fn == nil {
+		return nil
+	}
+	return t.s.update(t.tx, k, fn)
+}
+
+func (t *badgerTxn) Set(k *Key, v []byte) error {
+	return t.Update(k, setValue(v))
+}
+
+func (t *badgerTxn) Delete(k *Key) error {
+	if err := t.s.guard.checkKey(k); err != nil {
+		return err
+	}
+	if err := t.tx.Delete(k.Bytes()); err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	return nil
+}
+
+// boltTxn stages writes in a bolt transaction.
+type boltTxn struct {
+	s *boltStore
+	b *bolt.Bucket
+}
+
+// Batch runs fn in a single transaction, writes are committed once fn returns nil.
+func (s *boltStore) Batch(fn func(tx Txn) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTxn{
+			s: s,
+			b: tx.Bucket(boltBucket),
+		})
+	})
+}
+
+func (t *boltTxn) View(k *Key, fn PeekFunc) error {
+	if err := t.s.guard.checkKey(k); err != nil {
+		return err
+	}
+	v, ok := boltDecode(t.b.Get(k.Bytes()), time.Now())
+	if !ok {
+		return ErrNotFound
+	}
+	return fn(k, append([]byte(nil), v...))
+}
+
+func (t *boltTxn) Update(k *Key, fn ModifyFunc) error {
+	if err := t.s.guard.checkKey(k); err != nil {
+		return err
+	} else if fn == nil {
+		return nil
+	}
+	return t.s.update(t.b, k.Bytes(), k, fn)
+}
+
+func (t *boltTxn) Set(k *Key, v []byte) error {
+	return t.Update(k, setValue(v))
+}
+
+func (t *boltTxn) Delete(k *Key) error {
+	if err := t.s.guard.checkKey(k); err != nil {
+		return err
+	}
+	return t.b.Delete(k.Bytes())
+}
+
+// memoryTxn stages writes in an overlay applied once the batch succeeds,
+// nil entries stand for deleted keys.
+type memoryTxn struct {
+	s      *memoryStore
+	staged map[string]*memoryEntry
+}
+
+// Batch runs fn holding the write lock, so fn must access the store through tx only.
+func (s *memoryStore) Batch(fn func(tx Txn) error) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	tx := &memoryTxn{
+		s:      s,
+		staged: make(map[string]*memoryEntry),
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for key, e := range tx.staged {
+		if e == nil {
+			s.remove(key)
+			continue
+		}
+		var ttl time.Duration
+		if !e.expiresAt.IsZero() {
+			if ttl = time.Until(e.expiresAt); ttl <= 0 {
+				s.remove(key)
+				continue
+			}
+		}
+		s.set(key, e.value, ttl)
+	}
+	return nil
+}
+
+func (t *memoryTxn) get(key string) ([]byte, bool) {
+	if e, ok := t.staged[key]; ok {
+		if e == nil {
+			return nil, false
+		}
+		return e.value, true
+	}
+	return t.s.get(key, time.Now())
+}
+
+func (t *memoryTxn) View(k *Key, fn PeekFunc) error {
+	if err := t.s.guard.checkKey(k); err != nil {
+		return err
+	}
+	v, ok := t.get(string(k.Bytes()))
+	if !ok {
+		return ErrNotFound
+	}
+	return fn(k, append([]byte(nil), v...))
+}
+
+func (t *memoryTxn) Update(k *Key, fn ModifyFunc) error {
+	if err := t.s.guard.checkKey(k); err != nil {
+		return err
+	} else if fn == nil {
+		return nil
+	}
+	key := string(k.Bytes())
+	var v []byte
+	if current, ok := t.get(key); ok {
+		v = append([]byte(nil), current...)
+	}
+	vv, err := fn(k, v)
+	if err == ErrNoUpdate {
+		return nil
+	} else if err != nil {
+		return err
+	} else if err := t.s.guard.checkValue(k, vv); err != nil {
+		return err
+	}
+	e := &memoryEntry{
+		value: append([]byte(nil), vv...),
+	}
+	if k.TTL > 0 {
+		e.expiresAt = time.Now().Add(k.TTL)
+	}
+	t.staged[key] = e
+	return nil
+}
+
+func (t *memoryTxn) Set(k *Key, v []byte) error {
+	return t.Update(k, setValue(v))
+}
+
+func (t *memoryTxn) Delete(k *Key) error {
+	if err := t.s.guard.checkKey(k); err != nil {
+		return err
+	}
+	t.staged[string(k.Bytes())] = nil
+	return nil
+}
@@ -0,0 +1,294 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	boltFile = "state.bolt"
+	// boltOpenTimeout limits waiting for the file lock held by another process.
+	boltOpenTimeout = 10 * time.Second
+)
+
+var boltBucket = []byte("state")
+
+// boltStore implements IndexedStore on top of BoltDB. All keys are kept in a single
+// bolt bucket ordered the same way as in badger. Values are prefixed with the expiry
+// time in Unix nanoseconds, 0 means the key never expires. Writes are serialized,
+// so they never conflict.
+type boltStore struct {
+	dir   string
+	opts  *storeOptions
+	db    *bolt.DB
+	guard *sizeGuard
+}
+
+func newBoltStore(prefix string, opts ...storeOpt) (*boltStore, error) {
+	s := &boltStore{
+		dir:  prefix,
+		opts: defaultStoreOptions(),
+	}
+	for _, o := range opts {
+		if o != nil {
+			o(s.opts)
+		}
+	}
+	if err := os.MkdirAll(prefix, 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(prefix, boltFile), 0600, &bolt.Options{
+		Timeout: boltOpenTimeout,
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to open bolt db: %v", err)
+		return nil, err
+	}
+	db.NoSync = !s.opts.SyncWrites
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s.db = db
+	s.guard = newSizeGuard(s.opts.MaxValueSize)
+	return s, nil
+}
+
+func boltEncode(v []byte, ttl time.Duration) []byte {
+	buf := make([]byte, 8+len(v))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(buf[:8], uint64(time.Now().Add(ttl).UnixNano()))
+	}
+	copy(buf[8:], v)
+	return buf
+}
+
+// boltDecode returns the value and whether it's alive, the value is valid
+// only within the transaction.
+func boltDecode(buf []byte, now time.Time) ([]byte, bool) {
+	if len(buf) < 8 {
+		return nil, false
+	}
+	expiresAt := int64(binary.BigEndian.Uint64(buf[:8]))
+	if expiresAt > 0 && expiresAt <= now.UnixNano() {
+		return nil, false
+	}
+	return buf[8:], true
+}
+
+func (s *boltStore) View(ctx context.Context, k *Key, fn PeekFunc) error {
+	if err := s.guard.checkKey(k); err != nil {
+		return err
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.View(func(tx *bolt.Tx) error {
+		v, ok := boltDecode(tx.Bucket(boltBucket).Get(k.Bytes()), time.Now())
+		if !ok {
+			return ErrNotFound
+		}
+		return fn(k, append([]byte(nil), v...))
+	})
+}
+
+func (s *boltStore) Update(ctx context.Context, k *Key, fn ModifyFunc) error {
+	if err := s.guard.checkKey(k); err != nil {
+		return err
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+	if fn == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.update(tx.Bucket(boltBucket), k.Bytes(), k, fn)
+	})
+}
+
+func (s *boltStore) update(b *bolt.Bucket, key []byte, k *Key, fn ModifyFunc) error {
+	var v []byte
+	if current, ok := boltDecode(b.Get(key), time.Now()); ok {
+		v = append([]byte(nil), current...)
+	}
+	vv, err := fn(k, v)
+	if err == ErrNoUpdate {
+		return nil
+	} else if err != nil && err != ErrRangeStop {
+		return err
+	} else if err := s.guard.checkValue(k, vv); err != nil {
+		return err
+	}
+	if err := b.Put(key, boltEncode(vv, k.TTL)); err != nil {
+		return err
+	}
+	return err
+}
+
+func (s *boltStore) Delete(k *Key) error {
+	if k == nil {
+		return nil
+	} else if err := s.guard.checkKey(k); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(k.Bytes())
+	})
+}
+
+func (s *boltStore) RangeKeys(ctx context.Context, b Bucket, fn KeyFunc) (*RangeOptions, error) {
+	w := newRangeWalk(ctx, b)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		c := tx.Bucket(boltBucket).Cursor()
+		for key, v := c.Seek(w.start); key != nil; key, v = c.Next() {
+			if _, ok := boltDecode(v, now); !ok {
+				continue
+			}
+			switch w.next(key) {
+			case rangeDone:
+				return nil
+			case rangeSkip:
+				continue
+			}
+			k := (&Key{}).Unmarshal(key)
+			if err := fn(k); err == ErrRangeStop {
+				w.stop()
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return w.result(err)
+}
+
+func (s *boltStore) RangePeek(ctx context.Context, b Bucket, fn PeekFunc) (*RangeOptions, error) {
+	w := newRangeWalk(ctx, b)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		c := tx.Bucket(boltBucket).Cursor()
+		for key, v := c.Seek(w.start); key != nil; key, v = c.Next() {
+			vv, ok := boltDecode(v, now)
+			if !ok {
+				continue
+			}
+			switch w.next(key) {
+			case rangeDone:
+				return nil
+			case rangeSkip:
+				continue
+			}
+			k := (&Key{}).Unmarshal(key)
+			if err := fn(k, append([]byte(nil), vv...)); err == ErrRangeStop {
+				w.stop()
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return w.result(err)
+}
+
+func (s *boltStore) RangeModify(ctx context.Context, b Bucket, fn ModifyFunc) (*RangeOptions, error) {
+	w := newRangeWalk(ctx, b)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		now := time.Now()
+		// keys are collected first, bolt cursors must not be used while the bucket changes
+		var keys [][]byte
+		c := bucket.Cursor()
+		for key, v := c.Seek(w.start); key != nil; key, v = c.Next() {
+			if _, ok := boltDecode(v, now); !ok {
+				continue
+			}
+			step := w.next(key)
+			if step == rangeDone {
+				break
+			} else if step == rangeVisit {
+				keys = append(keys, append([]byte(nil), key...))
+			}
+		}
+		if w.err != nil {
+			return w.err
+		}
+		for i, key := range keys {
+			k := (&Key{}).Unmarshal(key)
+			if err := s.update(bucket, key, k, fn); err == ErrRangeStop {
+				if i+1 < len(keys) {
+					w.cursor = keys[i+1][2:]
+				}
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return w.result(err)
+}
+
+func (s *boltStore) Expire(id BucketID, ttl time.Duration) (int, error) {
+	var expired int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		prefix := id.Bytes()
+		var keys [][]byte
+		c := bucket.Cursor()
+		for key, v := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, v = c.Next() {
+			if len(v) >= 8 && binary.BigEndian.Uint64(v[:8]) == 0 {
+				keys = append(keys, append([]byte(nil), key...))
+			}
+		}
+		for _, key := range keys {
+			v := bucket.Get(key)
+			if err := bucket.Put(key, boltEncode(v[8:], ttl)); err != nil {
+				return err
+			}
+			expired++
+		}
+		return nil
+	})
+	return expired, err
+}
+
+// Compact removes expired keys, their pages are reused by later writes.
+func (s *boltStore) Compact() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		now := time.Now()
+		var keys [][]byte
+		c := bucket.Cursor()
+		for key, v := c.First(); key != nil; key, v = c.Next() {
+			if _, ok := boltDecode(v, now); !ok {
+				keys = append(keys, append([]byte(nil), key...))
+			}
+		}
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Stats() *StoreStats {
+	stats := s.guard.Stats()
+	stats.DiskUsage = dirSize(s.dir)
+	return stats
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,292 @@
+// Code generated by bucketgen from catalog.go. DO NOT EDIT.
+
+package state
+
+const (
+	// BucketRecords keeps records by ID, along with their version history.
+	BucketRecords BucketID = 0x10
+	// BucketBeatTicks keeps beat ticks by ID.
+	BucketBeatTicks BucketID = 0x11
+	// BucketBeatInfos keeps beat infos by session.
+	BucketBeatInfos BucketID = 0x12
+	// BucketFolderKeys keeps the key pair the node opens shared folders with.
+	BucketFolderKeys BucketID = 0x13
+	// BucketChecksums keeps content checksums of records by ID.
+	BucketChecksums BucketID = 0x14
+	// BucketCheckpoints keeps named checkpoints of the node.
+	BucketCheckpoints BucketID = 0x15
+	// BucketInboundSeen keeps IDs of announces already handled.
+	BucketInboundSeen BucketID = 0x16
+	// BucketIdempotency keeps outcomes of writes by idempotency key.
+	BucketIdempotency BucketID = 0x17
+	// BucketHealth keeps the probe of health checks.
+	BucketHealth BucketID = 0x18
+	// BucketSearchDocs keeps indexed documents of records by ID.
+	BucketSearchDocs BucketID = 0x19
+	// BucketSearchTerms keeps postings of search terms.
+	BucketSearchTerms BucketID = 0x1a
+	// BucketNodeUsage keeps usage of nodes by session.
+	BucketNodeUsage BucketID = 0x1b
+	// BucketLifecycle keeps lifecycle events of nodes.
+	BucketLifecycle BucketID = 0x1c
+	// BucketPeers keeps peers learned from peer exchange by node ID.
+	BucketPeers BucketID = 0x1d
+	// BucketWORM keeps write-once namespaces.
+	BucketWORM BucketID = 0x1e
+	// BucketSyncState keeps the checkpoint of delta sync and the mirror policy, see rs.MirrorPolicy.
+	BucketSyncState BucketID = 0x1f
+	// BucketShards keeps pins of erasure coded shards by version.
+	BucketShards BucketID = 0x20
+	// BucketNamespaceRecords indexes records under a key prefix of their namespace.
+	BucketNamespaceRecords BucketID = 0x21
+	// BucketSchema keeps the schema version of the store, see Migrate.
+	BucketSchema BucketID = 0x22
+	// BucketInline keeps small objects of the file store, see fs.UseInlineOpt.
+	BucketInline BucketID = 0x23
+	// BucketWebhooks keeps change webhooks by name.
+	BucketWebhooks BucketID = 0x24
+	// BucketWebhookQueue keeps queued deliveries of webhooks.
+	BucketWebhookQueue BucketID = 0x25
+	// BucketWebhookDead keeps dead letters of webhooks.
+	BucketWebhookDead BucketID = 0x26
+	// BucketAnnotations keeps annotations of records, see rs.Annotations.
+	BucketAnnotations BucketID = 0x27
+	// BucketJoinTokens keeps join tokens issued by the node, see rs.JoinToken.
+	BucketJoinTokens BucketID = 0x28
+	// BucketReindex keeps progress of index rebuilds, see rs.Reindex.
+	BucketReindex BucketID = 0x29
+	// BucketNamespaceKeys keeps keys of encrypted namespaces, see rs.EncryptionPolicy.
+	BucketNamespaceKeys BucketID = 0x2a
+	// BucketScheduledTasks keeps tasks scheduled on the node, see rs.ScheduledTask.
+	BucketScheduledTasks BucketID = 0x2b
+	// BucketTaskRuns keeps the history of scheduled task runs by ID.
+	BucketTaskRuns BucketID = 0x2c
+	// BucketConflicts keeps write conflicts of records, see rs.Conflict.
+	BucketConflicts BucketID = 0x2d
+	// BucketExpiries schedules versions of records that expire by version, see rs.Expiry.
+	BucketExpiries BucketID = 0x2e
+	// BucketOutboundJournal keeps announces of local writes until they are published, by announce ID.
+	BucketOutboundJournal BucketID = 0x2f
+	// BucketWriteGates keeps outcomes of on-chain checks of writers by Ethereum address, see api.GateResult.
+	BucketWriteGates BucketID = 0x30
+	// BucketRecordAccess keeps sampled read counts of records by path, see rs.PopularRecords.
+	BucketRecordAccess BucketID = 0x31
+	// BucketColdObjects maps versions offloaded to the cold tier to their keys in the cold store, see rs.ColdObject.
+	BucketColdObjects BucketID = 0x32
+)
+
+// bucketIDsUnique doesn't compile if two buckets share an ID.
+func bucketIDsUnique(id BucketID) {
+	switch id {
+	case BucketRecords:
+	case BucketBeatTicks:
+	case BucketBeatInfos:
+	case BucketFolderKeys:
+	case BucketChecksums:
+	case BucketCheckpoints:
+	case BucketInboundSeen:
+	case BucketIdempotency:
+	case BucketHealth:
+	case BucketSearchDocs:
+	case BucketSearchTerms:
+	case BucketNodeUsage:
+	case BucketLifecycle:
+	case BucketPeers:
+	case BucketWORM:
+	case BucketSyncState:
+	case BucketShards:
+	case BucketNamespaceRecords:
+	case BucketSchema:
+	case BucketInline:
+	case BucketWebhooks:
+	case BucketWebhookQueue:
+	case BucketWebhookDead:
+	case BucketAnnotations:
+	case BucketJoinTokens:
+	case BucketReindex:
+	case BucketNamespaceKeys:
+	case BucketScheduledTasks:
+	case BucketTaskRuns:
+	case BucketConflicts:
+	case BucketExpiries:
+	case BucketOutboundJournal:
+	case BucketWriteGates:
+	case BucketRecordAccess:
+	case BucketColdObjects:
+	}
+}
+
+// RecordsKey returns the key of BucketRecords.
+func RecordsKey(key []byte) *Key {
+	return NewKey(BucketRecords, key)
+}
+
+// BeatTicksKey returns the key of BucketBeatTicks.
+func BeatTicksKey(key []byte) *Key {
+	return NewKey(BucketBeatTicks, key)
+}
+
+// BeatInfosKey returns the key of BucketBeatInfos.
+func BeatInfosKey(key []byte) *Key {
+	return NewKey(BucketBeatInfos, key)
+}
+
+// FolderKeysKey returns the key of BucketFolderKeys.
+func FolderKeysKey(key string) *Key {
+	return NewKey(BucketFolderKeys, []byte(key))
+}
+
+// ChecksumsKey returns the key of BucketChecksums.
+func ChecksumsKey(key string) *Key {
+	return NewKey(BucketChecksums, []byte(key))
+}
+
+// CheckpointsKey returns the key of BucketCheckpoints.
+func CheckpointsKey(key string) *Key {
+	return NewKey(BucketCheckpoints, []byte(key))
+}
+
+// InboundSeenKey returns the key of BucketInboundSeen.
+func InboundSeenKey(key []byte) *Key {
+	return NewKey(BucketInboundSeen, key)
+}
+
+// IdempotencyKey returns the key of BucketIdempotency.
+func IdempotencyKey(key []byte) *Key {
+	return NewKey(BucketIdempotency, key)
+}
+
+// HealthKey returns the key of BucketHealth.
+func HealthKey(key string) *Key {
+	return NewKey(BucketHealth, []byte(key))
+}
+
+// SearchDocsKey returns the key of BucketSearchDocs.
+func SearchDocsKey(key string) *Key {
+	return NewKey(BucketSearchDocs, []byte(key))
+}
+
+// SearchTermsKey returns the key of BucketSearchTerms.
+func SearchTermsKey(key []byte) *Key {
+	return NewKey(BucketSearchTerms, key)
+}
+
+// NodeUsageKey returns the key of BucketNodeUsage.
+func NodeUsageKey(key []byte) *Key {
+	return NewKey(BucketNodeUsage, key)
+}
+
+// LifecycleKey returns the key of BucketLifecycle.
+func LifecycleKey(key []byte) *Key {
+	return NewKey(BucketLifecycle, key)
+}
+
+// PeersKey returns the key of BucketPeers, the key is hashed to fit MaxKeySize.
+func PeersKey(key string) *Key {
+	return NewKey(BucketPeers, hashedKey(key))
+}
+
+// WORMKey returns the key of BucketWORM, the key is hashed to fit MaxKeySize.
+func WORMKey(key string) *Key {
+	return NewKey(BucketWORM, hashedKey(key))
+}
+
+// SyncStateKey returns the key of BucketSyncState.
+func SyncStateKey(key string) *Key {
+	return NewKey(BucketSyncState, []byte(key))
+}
+
+// ShardsKey returns the key of BucketShards, the key is hashed to fit MaxKeySize.
+func ShardsKey(key string) *Key {
+	return NewKey(BucketShards, hashedKey(key))
+}
+
+// NamespaceRecordsKey returns the key of BucketNamespaceRecords.
+func NamespaceRecordsKey(key []byte) *Key {
+	return NewKey(BucketNamespaceRecords, key)
+}
+
+// SchemaKey returns the key of BucketSchema.
+func SchemaKey(key string) *Key {
+	return NewKey(BucketSchema, []byte(key))
+}
+
+// InlineKey returns the key of BucketInline, the key is hashed to fit MaxKeySize.
+func InlineKey(key string) *Key {
+	return NewKey(BucketInline, hashedKey(key))
+}
+
+// WebhooksKey returns the key of BucketWebhooks, the key is hashed to fit MaxKeySize.
+func WebhooksKey(key string) *Key {
+	return NewKey(BucketWebhooks, hashedKey(key))
+}
+
+// WebhookQueueKey returns the key of BucketWebhookQueue.
+func WebhookQueueKey(key string) *Key {
+	return NewKey(BucketWebhookQueue, []byte(key))
+}
+
+// WebhookDeadKey returns the key of BucketWebhookDead.
+func WebhookDeadKey(key string) *Key {
+	return NewKey(BucketWebhookDead, []byte(key))
+}
+
+// AnnotationsKey returns the key of BucketAnnotations.
+func AnnotationsKey(key string) *Key {
+	return NewKey(BucketAnnotations, []byte(key))
+}
+
+// JoinTokensKey returns the key of BucketJoinTokens.
+func JoinTokensKey(key string) *Key {
+	return NewKey(BucketJoinTokens, []byte(key))
+}
+
+// ReindexKey returns the key of BucketReindex.
+func ReindexKey(key string) *Key {
+	return NewKey(BucketReindex, []byte(key))
+}
+
+// NamespaceKeysKey returns the key of BucketNamespaceKeys.
+func NamespaceKeysKey(key string) *Key {
+	return NewKey(BucketNamespaceKeys, []byte(key))
+}
+
+// ScheduledTasksKey returns the key of BucketScheduledTasks.
+func ScheduledTasksKey(key string) *Key {
+	return NewKey(BucketScheduledTasks, []byte(key))
+}
+
+// TaskRunsKey returns the key of BucketTaskRuns.
+func TaskRunsKey(key string) *Key {
+	return NewKey(BucketTaskRuns, []byte(key))
+}
+
+// ConflictsKey returns the key of BucketConflicts.
+func ConflictsKey(key string) *Key {
+	return NewKey(BucketConflicts, []byte(key))
+}
+
+// ExpiriesKey returns the key of BucketExpiries.
+func ExpiriesKey(key string) *Key {
+	return NewKey(BucketExpiries, []byte(key))
+}
+
+// OutboundJournalKey returns the key of BucketOutboundJournal.
+func OutboundJournalKey(key string) *Key {
+	return NewKey(BucketOutboundJournal, []byte(key))
+}
+
+// WriteGatesKey returns the key of BucketWriteGates, the key is hashed to fit MaxKeySize.
+func WriteGatesKey(key string) *Key {
+	return NewKey(BucketWriteGates, hashedKey(key))
+}
+
+// RecordAccessKey returns the key of BucketRecordAccess, the key is hashed to fit MaxKeySize.
+func RecordAccessKey(key string) *Key {
+	return NewKey(BucketRecordAccess, hashedKey(key))
+}
+
+// ColdObjectsKey returns the key of BucketColdObjects.
+func ColdObjectsKey(key string) *Key {
+	return NewKey(BucketColdObjects, []byte(key))
+}
@@ -0,0 +1,275 @@
+package state
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+//go:generate go run ./internal/bucketgen -in catalog.go -out buckets_gen.go
+
+// The catalog below is the single place buckets of the state store are declared. Constants
+// of bucket IDs and key builders of each bucket, e.g. BucketRecords and RecordsKey, are
+// generated from it by go generate, which fails on IDs or names used twice. Duplicates that
+// slip into the generated file break the build, and RegisterBucket refuses them at runtime.
+//
+// To add a bucket, append its spec with the next free ID and run go generate ./state.
+// IDs and names of buckets are never reused, even after a bucket is dropped. Bump Version
+// of the spec along with a migration whenever the encoding of its keys or values changes.
+
+// KeyCodec defines how keys of a bucket are built.
+type KeyCodec int
+
+const (
+	// KeyBytes keys are raw bytes of at most MaxKeySize.
+	KeyBytes KeyCodec = iota
+	// KeyString keys are strings of at most MaxKeySize bytes.
+	KeyString
+	// KeyHashed keys are strings of any length hashed with SHA-256, so they fit MaxKeySize.
+	KeyHashed
+)
+
+func (c KeyCodec) String() string {
+	switch c {
+	case KeyBytes:
+		return "bytes"
+	case KeyString:
+		return "string"
+	case KeyHashed:
+		return "hashed"
+	}
+	return fmt.Sprintf("key_codec(%d)", int(c))
+}
+
+// ValueCodec defines how values of a bucket are encoded.
+type ValueCodec int
+
+const (
+	// ValueRaw values are opaque bytes, their layout is up to the owner of the bucket.
+	ValueRaw ValueCodec = iota
+	// ValueJSON values are JSON documents, see MarshalValue.
+	ValueJSON
+	// ValueCapnp values are packed Cap'n Proto messages of the proto package.
+	ValueCapnp
+	// ValueUint64 values are big-endian uint64 numbers, see MarshalValue.
+	ValueUint64
+)
+
+func (c ValueCodec) String() string {
+	switch c {
+	case ValueRaw:
+		return "raw"
+	case ValueJSON:
+		return "json"
+	case ValueCapnp:
+		return "capnp"
+	case ValueUint64:
+		return "uint64"
+	}
+	return fmt.Sprintf("value_codec(%d)", int(c))
+}
+
+// BucketSpec describes a bucket of the state store.
+type BucketSpec struct {
+	ID BucketID `json:"id"`
+	// Ident names the generated BucketIdent constant and IdentKey builder.
+	Ident string `json:"-"`
+	// Name is used in configs, stats and metrics, e.g. "records".
+	Name    string     `json:"name"`
+	Version int        `json:"version"`
+	Key     KeyCodec   `json:"-"`
+	Value   ValueCodec `json:"-"`
+	Doc     string     `json:"-"`
+}
+
+var catalog = []BucketSpec{
+	{ID: 0x10, Ident: "Records", Name: "records", Version: 1, Key: KeyBytes, Value: ValueCapnp,
+		Doc: "keeps records by ID, along with their version history"},
+	{ID: 0x11, Ident: "BeatTicks", Name: "beat_ticks", Version: 1, Key: KeyBytes, Value: ValueCapnp,
+		Doc: "keeps beat ticks by ID"},
+	{ID: 0x12, Ident: "BeatInfos", Name: "beat_infos", Version: 1, Key: KeyBytes, Value: ValueCapnp,
+		Doc: "keeps beat infos by session"},
+	{ID: 0x13, Ident: "FolderKeys", Name: "folder_keys", Version: 1, Key: KeyString, Value: ValueRaw,
+		Doc: "keeps the key pair the node opens shared folders with"},
+	{ID: 0x14, Ident: "Checksums", Name: "checksums", Version: 1, Key: KeyString, Value: ValueRaw,
+		Doc: "keeps content checksums of records by ID"},
+	{ID: 0x15, Ident: "Checkpoints", Name: "checkpoints", Version: 1, Key: KeyString, Value: ValueUint64,
+		Doc: "keeps named checkpoints of the node"},
+	{ID: 0x16, Ident: "InboundSeen", Name: "inbound_seen", Version: 1, Key: KeyBytes, Value: ValueRaw,
+		Doc: "keeps IDs of announces already handled"},
+	{ID: 0x17, Ident: "Idempotency", Name: "idempotency", Version: 1, Key: KeyBytes, Value: ValueJSON,
+		Doc: "keeps outcomes of writes by idempotency key"},
+	{ID: 0x18, Ident: "Health", Name: "health", Version: 1, Key: KeyString, Value: ValueRaw,
+		Doc: "keeps the probe of health checks"},
+	{ID: 0x19, Ident: "SearchDocs", Name: "search_docs", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "keeps indexed documents of records by ID"},
+	{ID: 0x1a, Ident: "SearchTerms", Name: "search_terms", Version: 1, Key: KeyBytes, Value: ValueRaw,
+		Doc: "keeps postings of search terms"},
+	{ID: 0x1b, Ident: "NodeUsage", Name: "node_usage", Version: 1, Key: KeyBytes, Value: ValueJSON,
+		Doc: "keeps usage of nodes by session"},
+	{ID: 0x1c, Ident: "Lifecycle", Name: "lifecycle", Version: 1, Key: KeyBytes, Value: ValueJSON,
+		Doc: "keeps lifecycle events of nodes"},
+	{ID: 0x1d, Ident: "Peers", Name: "peers", Version: 1, Key: KeyHashed, Value: ValueJSON,
+		Doc: "keeps peers learned from peer exchange by node ID"},
+	{ID: 0x1e, Ident: "WORM", Name: "worm", Version: 1, Key: KeyHashed, Value: ValueJSON,
+		Doc: "keeps write-once namespaces"},
+	{ID: 0x1f, Ident: "SyncState", Name: "sync_state", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "keeps the checkpoint of delta sync and the mirror policy, see rs.MirrorPolicy"},
+	{ID: 0x20, Ident: "Shards", Name: "shards", Version: 1, Key: KeyHashed, Value: ValueJSON,
+		Doc: "keeps pins of erasure coded shards by version"},
+	{ID: 0x21, Ident: "NamespaceRecords", Name: "ns_records", Version: 1, Key: KeyBytes, Value: ValueRaw,
+		Doc: "indexes records under a key prefix of their namespace"},
+	{ID: 0x22, Ident: "Schema", Name: "schema", Version: 1, Key: KeyString, Value: ValueUint64,
+		Doc: "keeps the schema version of the store, see Migrate"},
+	{ID: 0x23, Ident: "Inline", Name: "inline", Version: 1, Key: KeyHashed, Value: ValueRaw,
+		Doc: "keeps small objects of the file store, see fs.UseInlineOpt"},
+	{ID: 0x24, Ident: "Webhooks", Name: "webhooks", Version: 1, Key: KeyHashed, Value: ValueJSON,
+		Doc: "keeps change webhooks by name"},
+	{ID: 0x25, Ident: "WebhookQueue", Name: "webhook_queue", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "keeps queued deliveries of webhooks"},
+	{ID: 0x26, Ident: "WebhookDead", Name: "webhook_dead", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "keeps dead letters of webhooks"},
+	{ID: 0x27, Ident: "Annotations", Name: "annotations", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "keeps annotations of records, see rs.Annotations"},
+	{ID: 0x28, Ident: "JoinTokens", Name: "join_tokens", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "keeps join tokens issued by the node, see rs.JoinToken"},
+	{ID: 0x29, Ident: "Reindex", Name: "reindex", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "keeps progress of index rebuilds, see rs.Reindex"},
+	{ID: 0x2a, Ident: "NamespaceKeys", Name: "namespace_keys", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "keeps keys of encrypted namespaces, see rs.EncryptionPolicy"},
+	{ID: 0x2b, Ident: "ScheduledTasks", Name: "scheduled_tasks", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "keeps tasks scheduled on the node, see rs.ScheduledTask"},
+	{ID: 0x2c, Ident: "TaskRuns", Name: "task_runs", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "keeps the history of scheduled task runs by ID"},
+	{ID: 0x2d, Ident: "Conflicts", Name: "conflicts", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "keeps write conflicts of records, see rs.Conflict"},
+	{ID: 0x2e, Ident: "Expiries", Name: "expiries", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "schedules versions of records that expire by version, see rs.Expiry"},
+	{ID: 0x2f, Ident: "OutboundJournal", Name: "outbound_journal", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "keeps announces of local writes until they are published, by announce ID"},
+	{ID: 0x30, Ident: "WriteGates", Name: "write_gates", Version: 1, Key: KeyHashed, Value: ValueJSON,
+		Doc: "keeps outcomes of on-chain checks of writers by Ethereum address, see api.GateResult"},
+	{ID: 0x31, Ident: "RecordAccess", Name: "record_access", Version: 1, Key: KeyHashed, Value: ValueJSON,
+		Doc: "keeps sampled read counts of records by path, see rs.PopularRecords"},
+	{ID: 0x32, Ident: "ColdObjects", Name: "cold_objects", Version: 1, Key: KeyString, Value: ValueJSON,
+		Doc: "maps versions offloaded to the cold tier to their keys in the cold store, see rs.ColdObject"},
+}
+
+// BucketCollisionError is returned by RegisterBucket for IDs or names already taken.
+type BucketCollisionError struct {
+	Spec  BucketSpec
+	Taken BucketSpec
+}
+
+func (e *BucketCollisionError) Error() string {
+	return fmt.Sprintf("bucket %s (0x%02x) collides with registered bucket %s (0x%02x)",
+		e.Spec.Name, uint16(e.Spec.ID), e.Taken.Name, uint16(e.Taken.ID))
+}
+
+type bucketRegistry struct {
+	mux    *sync.RWMutex
+	ids    map[BucketID]*BucketSpec
+	byName map[string]*BucketSpec
+}
+
+var registry = newBucketRegistry(catalog)
+
+func newBucketRegistry(specs []BucketSpec) *bucketRegistry {
+	r := &bucketRegistry{
+		mux:    new(sync.RWMutex),
+		ids:    make(map[BucketID]*BucketSpec, len(specs)),
+		byName: make(map[string]*BucketSpec, len(specs)),
+	}
+	for _, spec := range specs {
+		if err := r.register(spec); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+func (r *bucketRegistry) register(spec BucketSpec) error {
+	if len(spec.Name) == 0 {
+		return fmt.Errorf("bucket 0x%02x has no name", uint16(spec.ID))
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if taken, ok := r.ids[spec.ID]; ok {
+		return &BucketCollisionError{Spec: spec, Taken: *taken}
+	} else if taken, ok := r.byName[spec.Name]; ok {
+		return &BucketCollisionError{Spec: spec, Taken: *taken}
+	}
+	r.ids[spec.ID] = &spec
+	r.byName[spec.Name] = &spec
+	return nil
+}
+
+func (r *bucketRegistry) spec(id BucketID) (*BucketSpec, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	spec, ok := r.ids[id]
+	return spec, ok
+}
+
+// RegisterBucket adds a bucket outside of the catalog, e.g. of an embedder of the node.
+// A *BucketCollisionError is returned if its ID or name is already taken.
+func RegisterBucket(spec BucketSpec) error {
+	return registry.register(spec)
+}
+
+// Spec returns the spec of a registered bucket.
+func Spec(id BucketID) (BucketSpec, bool) {
+	spec, ok := registry.spec(id)
+	if !ok {
+		return BucketSpec{}, false
+	}
+	return *spec, true
+}
+
+// Catalog returns specs of all registered buckets ordered by ID.
+func Catalog() []BucketSpec {
+	registry.mux.RLock()
+	specs := make([]BucketSpec, 0, len(registry.ids))
+	for _, spec := range registry.ids {
+		specs = append(specs, *spec)
+	}
+	registry.mux.RUnlock()
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].ID < specs[j].ID
+	})
+	return specs
+}
+
+func (b BucketID) String() string {
+	if spec, ok := registry.spec(b); ok {
+		return spec.Name
+	}
+	return fmt.Sprintf("0x%02x", uint16(b))
+}
+
+// Buckets returns IDs of all known buckets in order.
+func Buckets() []BucketID {
+	specs := Catalog()
+	ids := make([]BucketID, 0, len(specs))
+	for _, spec := range specs {
+		ids = append(ids, spec.ID)
+	}
+	return ids
+}
+
+// BucketByName returns ID of the bucket with the given name, e.g. "records".
+func BucketByName(name string) (BucketID, bool) {
+	registry.mux.RLock()
+	defer registry.mux.RUnlock()
+	if spec, ok := registry.byName[name]; ok {
+		return spec.ID, true
+	}
+	return 0, false
+}
+
+// hashedKey is the key of KeyHashed buckets.
+func hashedKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:MaxKeySize]
+}
@@ -0,0 +1,88 @@
+package state
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// CodecError is returned when a value is encoded or decoded with a codec other than
+// the one of its bucket, e.g. JSON written into a bucket of Cap'n Proto records.
+type CodecError struct {
+	Bucket BucketID
+	Want   ValueCodec
+	Got    string
+}
+
+func (e *CodecError) Error() string {
+	return fmt.Sprintf("values of bucket %s are %s, not %s", e.Bucket, e.Want, e.Got)
+}
+
+func valueCodec(k *Key) (ValueCodec, error) {
+	spec, ok := registry.spec(k.Bucket.ID)
+	if !ok {
+		return 0, fmt.Errorf("bucket %s is not registered", k.Bucket.ID)
+	}
+	return spec.Value, nil
+}
+
+// MarshalValue encodes v with the value codec of the key's bucket. JSON buckets take any
+// value, uint64 buckets take an uint64 or an int, raw buckets take []byte. Values of
+// Cap'n Proto buckets are encoded by the proto package.
+func MarshalValue(k *Key, v interface{}) ([]byte, error) {
+	codec, err := valueCodec(k)
+	if err != nil {
+		return nil, err
+	}
+	switch codec {
+	case ValueJSON:
+		return json.Marshal(v)
+	case ValueUint64:
+		buf := make([]byte, 8)
+		switch n := v.(type) {
+		case uint64:
+			binary.BigEndian.PutUint64(buf, n)
+		case int:
+			binary.BigEndian.PutUint64(buf, uint64(n))
+		default:
+			return nil, &CodecError{Bucket: k.Bucket.ID, Want: codec, Got: fmt.Sprintf("%T", v)}
+		}
+		return buf, nil
+	case ValueRaw:
+		if buf, ok := v.([]byte); ok {
+			return buf, nil
+		}
+	}
+	return nil, &CodecError{Bucket: k.Bucket.ID, Want: codec, Got: fmt.Sprintf("%T", v)}
+}
+
+// UnmarshalValue decodes data of the key's bucket into v, a pointer of the types
+// MarshalValue takes.
+func UnmarshalValue(k *Key, data []byte, v interface{}) error {
+	codec, err := valueCodec(k)
+	if err != nil {
+		return err
+	}
+	switch codec {
+	case ValueJSON:
+		return json.Unmarshal(data, v)
+	case ValueUint64:
+		if len(data) != 8 {
+			return fmt.Errorf("malformed uint64 value of bucket %s: %x", k.Bucket.ID, data)
+		}
+		switch n := v.(type) {
+		case *uint64:
+			*n = binary.BigEndian.Uint64(data)
+			return nil
+		case *int:
+			*n = int(binary.BigEndian.Uint64(data))
+			return nil
+		}
+	case ValueRaw:
+		if buf, ok := v.(*[]byte); ok {
+			*buf = append((*buf)[:0], data...)
+			return nil
+		}
+	}
+	return &CodecError{Bucket: k.Bucket.ID, Want: codec, Got: fmt.Sprintf("%T", v)}
+}
@@ -0,0 +1,161 @@
+package state
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConflictMode defines how concurrent writes to a bucket are handled.
+type ConflictMode int
+
+const (
+	// ConflictOptimistic runs write transactions concurrently and retries them on conflicts.
+	ConflictOptimistic ConflictMode = 0
+	// ConflictPessimistic serializes write transactions of the bucket, so local writers
+	// never conflict with each other. Suits buckets with hot keys and expensive updates.
+	ConflictPessimistic ConflictMode = 1
+)
+
+func (c ConflictMode) String() string {
+	if c == ConflictPessimistic {
+		return "pessimistic"
+	}
+	return "optimistic"
+}
+
+type ConflictStats struct {
+	Mode      string `json:"mode"`
+	Attempts  uint64 `json:"attempts_total"`
+	Conflicts uint64 `json:"conflicts_total"`
+	// Exhausted counts writes that failed with a conflict after all retries.
+	Exhausted    uint64  `json:"exhausted_total"`
+	ConflictRate float64 `json:"conflict_rate"`
+}
+
+const (
+	// batchConflictsName is the key of batch transactions in ConflictStats.
+	batchConflictsName = "batch"
+
+	defaultConflictRetries = 5
+	conflictBackoffBase    = 5 * time.Millisecond
+	conflictBackoffMax     = 250 * time.Millisecond
+)
+
+type bucketConflicts struct {
+	writeMux *sync.Mutex
+
+	attempts  uint64
+	conflicts uint64
+	exhausted uint64
+}
+
+// conflictRetrier retries write transactions that failed with badger.ErrConflict,
+// with exponential backoff and full jitter.
+type conflictRetrier struct {
+	maxRetries int
+	modes      map[BucketID]ConflictMode
+
+	mux     *sync.Mutex
+	buckets map[BucketID]*bucketConflicts
+	// batches are accounted separately, as they span buckets
+	batches *bucketConflicts
+}
+
+func newConflictRetrier(maxRetries int, modes map[BucketID]ConflictMode) *conflictRetrier {
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	return &conflictRetrier{
+		maxRetries: maxRetries,
+		modes:      modes,
+		mux:        new(sync.Mutex),
+		buckets:    make(map[BucketID]*bucketConflicts),
+		batches: &bucketConflicts{
+			writeMux: new(sync.Mutex),
+		},
+	}
+}
+
+func (c *conflictRetrier) bucket(id BucketID) *bucketConflicts {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	b, ok := c.buckets[id]
+	if !ok {
+		b = &bucketConflicts{
+			writeMux: new(sync.Mutex),
+		}
+		c.buckets[id] = b
+	}
+	return b
+}
+
+// Do runs the write transaction fn, note that fn may be called more than once.
+func (c *conflictRetrier) Do(id BucketID, fn func() error) error {
+	b := c.bucket(id)
+	if c.modes[id] == ConflictPessimistic {
+		b.writeMux.Lock()
+		defer b.writeMux.Unlock()
+	}
+	return c.do(b, id.String(), fn)
+}
+
+// DoBatch runs the write transaction of a batch, note that fn may be called more than once.
+func (c *conflictRetrier) DoBatch(fn func() error) error {
+	return c.do(c.batches, batchConflictsName, fn)
+}
+
+func (c *conflictRetrier) do(b *bucketConflicts, name string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		atomic.AddUint64(&b.attempts, 1)
+		err := fn()
+		if err != badger.ErrConflict {
+			return err
+		}
+		atomic.AddUint64(&b.conflicts, 1)
+		if attempt >= c.maxRetries {
+			atomic.AddUint64(&b.exhausted, 1)
+			log.WithField("bucket", name).Warningf("write conflict persists after %d retries", attempt)
+			return err
+		}
+		time.Sleep(conflictBackoff(attempt))
+	}
+}
+
+func conflictBackoff(attempt int) time.Duration {
+	d := conflictBackoffBase << uint(attempt)
+	if d <= 0 || d > conflictBackoffMax {
+		d = conflictBackoffMax
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+func (c *conflictRetrier) Stats() map[string]*ConflictStats {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	stats := make(map[string]*ConflictStats, len(c.buckets)+1)
+	for id, b := range c.buckets {
+		stats[id.String()] = b.stats(c.modes[id])
+	}
+	if atomic.LoadUint64(&c.batches.attempts) > 0 {
+		stats[batchConflictsName] = c.batches.stats(ConflictOptimistic)
+	}
+	return stats
+}
+
+func (b *bucketConflicts) stats(mode ConflictMode) *ConflictStats {
+	s := &ConflictStats{
+		Mode:      mode.String(),
+		Attempts:  atomic.LoadUint64(&b.attempts),
+		Conflicts: atomic.LoadUint64(&b.conflicts),
+		Exhausted: atomic.LoadUint64(&b.exhausted),
+	}
+	if s.Attempts > 0 {
+		s.ConflictRate = float64(s.Conflicts) / float64(s.Attempts)
+	}
+	return s
+}
@@ -0,0 +1,118 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	// expireBatchSize limits keys updated in a single transaction.
+	expireBatchSize = 1000
+	// valueLogDiscardRatio is the share of stale data that makes a value log file rewritten.
+	valueLogDiscardRatio = 0.5
+)
+
+// ParseBucketTTLs parses a comma-separated list of bucket=duration pairs,
+// e.g. "beat_infos=720h,inbound_seen=24h". Records cannot expire this way.
+func ParseBucketTTLs(s string) (map[BucketID]time.Duration, error) {
+	ttls := make(map[BucketID]time.Duration)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad bucket TTL: %s", pair)
+		}
+		id, ok := BucketByName(parts[0])
+		if !ok {
+			return nil, fmt.Errorf("bad bucket TTL: %s: unknown bucket", pair)
+		} else if id == BucketRecords {
+			return nil, fmt.Errorf("bad bucket TTL: %s: records are expired by age", pair)
+		} else if id == BucketNamespaceRecords {
+			return nil, fmt.Errorf("bad bucket TTL: %s: the index follows records", pair)
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad bucket TTL: %s: %v", pair, err)
+		} else if d <= 0 {
+			return nil, fmt.Errorf("bad bucket TTL: %s: must be positive", pair)
+		}
+		ttls[id] = d
+	}
+	return ttls, nil
+}
+
+// Expire sets the TTL on keys of the bucket that have no expiry yet,
+// returns the number of keys updated.
+func (s *badgerStore) Expire(id BucketID, ttl time.Duration) (int, error) {
+	var keys [][]byte
+	b := NewBucket(id)
+	if err := s.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(b.NewKey(nil).Bytes()); it.Valid(); it.Next() {
+			item := it.Item()
+			k := (&Key{}).Unmarshal(item.Key())
+			if k.Bucket.ID != b.ID {
+				return nil
+			}
+			if item.ExpiresAt() == 0 {
+				keys = append(keys, append([]byte(nil), item.Key()...))
+			}
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	var expired int
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > expireBatchSize {
+			batch = batch[:expireBatchSize]
+		}
+		keys = keys[len(batch):]
+		if err := s.retry.Do(id, func() error {
+			return s.db.Update(func(tx *badger.Txn) error {
+				for _, key := range batch {
+					item, err := tx.Get(key)
+					if err == badger.ErrKeyNotFound {
+						continue
+					} else if err != nil {
+						return err
+					} else if item.ExpiresAt() != 0 {
+						continue
+					}
+					v, err := item.ValueCopy(nil)
+					if err != nil {
+						return err
+					}
+					if err := tx.SetWithTTL(key, v, ttl); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}); err != nil {
+			return expired, err
+		}
+		expired += len(batch)
+	}
+	return expired, nil
+}
+
+// Compact rewrites value log files until no file has enough stale data,
+// so space of deleted and expired keys is returned to the disk.
+func (s *badgerStore) Compact() error {
+	if _, err := s.maint.run(true); err != nil && err != ErrMaintenanceRunning {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+//+build gofuzz testing
+
+package state
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// FuzzKey unmarshals a key followed by a value of its bucket. The first byte of the input
+// is the length of the key, the rest after the key is the value.
+func FuzzKey(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	n := int(data[0]) % (2 + 26 + 1)
+	data = data[1:]
+	if n > len(data) {
+		n = len(data)
+	}
+	keyData, value := data[:n], data[n:]
+	k := new(Key).Unmarshal(keyData)
+	_ = k.String()
+	if n == 2+26 && !bytes.Equal(k.Bytes(), keyData) {
+		panic(fmt.Sprintf("key doesn't round-trip: %x != %x", k.Bytes(), keyData))
+	}
+	spec, ok := Spec(k.Bucket.ID)
+	if !ok {
+		return 0
+	}
+	var err error
+	switch spec.Value {
+	case ValueJSON:
+		var v interface{}
+		err = UnmarshalValue(k, value, &v)
+	case ValueUint64:
+		var v uint64
+		err = UnmarshalValue(k, value, &v)
+	case ValueRaw:
+		var v []byte
+		err = UnmarshalValue(k, value, &v)
+	default:
+		// Cap'n Proto values are fuzzed by the proto package
+		return 0
+	}
+	if err != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,286 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// Values of the badger store are framed with a CRC-32C checksum verified on every read, so bit
+// rot in the value log fails reads with a *CorruptionError instead of returning garbage. Values
+// of encrypted buckets are also sealed with AES-256-GCM under the data key of the store, the key
+// of the value is the additional data, so sealed values can't be swapped between keys either.
+// A frame is the magic, a flags byte, for sealed values the ID of the data key and the nonce,
+// the payload and the checksum of all that precedes it. Values written before checksums were
+// turned on have no frame, they're read as they are and framed on their next write.
+
+var frameMagic = []byte{0xa7, 's', 'v'}
+
+const (
+	frameFlagSealed byte = 1 << 0
+
+	frameHeaderSize = 4
+	frameCRCSize    = 4
+	keyIDSize       = 4
+	sealNonceSize   = 12
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrStateKey is returned for values sealed with another data key than the one of the store,
+// e.g. of a state dir copied from another node. Such values are not treated as corrupted.
+var ErrStateKey = errors.New("state value is sealed with another key")
+
+// CorruptionError is returned when a value fails its checksum or can't be opened.
+type CorruptionError struct {
+	Bucket BucketID
+	Key    string
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corrupted value of bucket %s: %s", e.Bucket, e.Reason)
+}
+
+// IsCorrupted tells whether the error is a *CorruptionError.
+func IsCorrupted(err error) bool {
+	_, ok := err.(*CorruptionError)
+	return ok
+}
+
+// frameKind is how a value was found on the disk.
+type frameKind int
+
+const (
+	frameLegacy frameKind = iota
+	framePlain
+	frameSealed
+)
+
+type integrity struct {
+	checksums bool
+	sealAll   bool
+	sealed    map[BucketID]bool
+	aead      cipher.AEAD
+	keyID     []byte
+}
+
+func newIntegrity(dir string, o *storeOptions) (*integrity, error) {
+	in := &integrity{
+		checksums: o.Checksums,
+		sealAll:   o.EncryptAll,
+		sealed:    o.EncryptedBuckets,
+	}
+	if len(o.EncryptionSecret) == 0 || (!o.EncryptAll && len(o.EncryptedBuckets) == 0) {
+		return in, nil
+	}
+	key, err := loadDataKey(dir, o.EncryptionSecret)
+	if err != nil {
+		return nil, err
+	}
+	if in.aead, err = newAEAD(key); err != nil {
+		return nil, err
+	}
+	in.keyID = dataKeyID(key)
+	return in, nil
+}
+
+func (in *integrity) sealing(id BucketID) bool {
+	return in.aead != nil && (in.sealAll || in.sealed[id])
+}
+
+// seal frames the value of the key for the disk.
+func (in *integrity) seal(k *Key, v []byte) ([]byte, error) {
+	sealing := in.sealing(k.Bucket.ID)
+	if !sealing && !in.checksums && !bytes.HasPrefix(v, frameMagic) {
+		return v, nil
+	}
+	size := frameHeaderSize + len(v) + frameCRCSize
+	if sealing {
+		size += keyIDSize + sealNonceSize + in.aead.Overhead()
+	}
+	buf := make([]byte, frameHeaderSize, size)
+	copy(buf, frameMagic)
+	if sealing {
+		buf[3] = frameFlagSealed
+		buf = append(buf, in.keyID...)
+		nonce := make([]byte, sealNonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		buf = append(buf, nonce...)
+		buf = in.aead.Seal(buf, nonce, v, k.Bytes())
+	} else {
+		buf = append(buf, v...)
+	}
+	sum := make([]byte, frameCRCSize)
+	binary.BigEndian.PutUint32(sum, crc32.Checksum(buf, crcTable))
+	return append(buf, sum...), nil
+}
+
+// open verifies the value of the key read from the disk and returns its content.
+func (in *integrity) open(key, raw []byte) ([]byte, frameKind, error) {
+	if !bytes.HasPrefix(raw, frameMagic) {
+		return raw, frameLegacy, nil
+	}
+	corrupted := func(reason string) error {
+		k := (&Key{}).Unmarshal(key)
+		return &CorruptionError{
+			Bucket: k.Bucket.ID,
+			Key:    hex.EncodeToString(key),
+			Reason: reason,
+		}
+	}
+	if len(raw) < frameHeaderSize+frameCRCSize {
+		return nil, frameLegacy, corrupted("truncated frame")
+	}
+	body := raw[:len(raw)-frameCRCSize]
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(raw[len(body):]) {
+		return nil, frameLegacy, corrupted("checksum mismatch")
+	}
+	flags := body[3]
+	payload := body[frameHeaderSize:]
+	if flags&^frameFlagSealed != 0 {
+		return nil, frameLegacy, corrupted(fmt.Sprintf("unknown frame flags %#x", flags))
+	} else if flags&frameFlagSealed == 0 {
+		return payload, framePlain, nil
+	}
+	if len(payload) < keyIDSize+sealNonceSize {
+		return nil, frameSealed, corrupted("truncated seal")
+	} else if in.aead == nil || !bytes.Equal(payload[:keyIDSize], in.keyID) {
+		return nil, frameSealed, ErrStateKey
+	}
+	nonce := payload[keyIDSize : keyIDSize+sealNonceSize]
+	v, err := in.aead.Open(nil, nonce, payload[keyIDSize+sealNonceSize:], key)
+	if err != nil {
+		return nil, frameSealed, corrupted("sealed value fails authentication")
+	}
+	return v, frameSealed, nil
+}
+
+// openItem reads the value of a badger item, corrupted values are quarantined.
+func (s *badgerStore) openItem(item *badger.Item) ([]byte, error) {
+	raw, err := item.Value()
+	if err != nil {
+		if isCorruption(err) {
+			s.damage.quarantine(item.KeyCopy(nil), err)
+		}
+		return nil, err
+	}
+	v, _, err := s.integrity.open(item.Key(), raw)
+	if IsCorrupted(err) {
+		s.damage.quarantine(item.KeyCopy(nil), err)
+	}
+	return v, err
+}
+
+// BucketVerify counts values of a bucket by how they were found.
+type BucketVerify struct {
+	Bucket string `json:"bucket"`
+	Keys   int    `json:"keys"`
+	// Verified values passed their checksum, Sealed ones are encrypted too.
+	Verified  int `json:"verified"`
+	Sealed    int `json:"sealed"`
+	Legacy    int `json:"legacy"`
+	WrongKey  int `json:"wrong_key"`
+	Corrupted int `json:"corrupted"`
+}
+
+// VerifyReport is the result of a scan of all values of the store.
+type VerifyReport struct {
+	Buckets   []*BucketVerify   `json:"buckets"`
+	Keys      int               `json:"keys"`
+	Corrupted int               `json:"corrupted"`
+	WrongKey  int               `json:"wrong_key"`
+	Damaged   []*QuarantinedKey `json:"damaged,omitempty"`
+	Duration  string            `json:"duration"`
+}
+
+var ErrVerifyUnsupported = errors.New("state backend doesn't keep checksums of values")
+
+// verifyStore is implemented by backends that frame values with checksums.
+type verifyStore interface {
+	Verify(ctx context.Context) (*VerifyReport, error)
+}
+
+// Verify reads every value of the store and checks it against its checksum, corrupted
+// values are listed in the report, up to maxQuarantinedReported of them.
+func Verify(ctx context.Context, s IndexedStore) (*VerifyReport, error) {
+	vs, ok := s.(verifyStore)
+	if !ok {
+		return nil, ErrVerifyUnsupported
+	}
+	return vs.Verify(ctx)
+}
+
+func (s *badgerStore) Verify(ctx context.Context) (*VerifyReport, error) {
+	startedAt := time.Now()
+	report := &VerifyReport{}
+	buckets := make(map[BucketID]*BucketVerify)
+	err := s.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchSize = 100
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			if report.Keys%256 == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			item := it.Item()
+			k := (&Key{}).Unmarshal(item.Key())
+			b, ok := buckets[k.Bucket.ID]
+			if !ok {
+				b = &BucketVerify{
+					Bucket: k.Bucket.ID.String(),
+				}
+				buckets[k.Bucket.ID] = b
+				report.Buckets = append(report.Buckets, b)
+			}
+			b.Keys++
+			report.Keys++
+			raw, err := item.Value()
+			var kind frameKind
+			if err == nil {
+				_, kind, err = s.integrity.open(item.Key(), raw)
+			}
+			switch {
+			case err == ErrStateKey:
+				b.WrongKey++
+				report.WrongKey++
+			case err != nil:
+				b.Corrupted++
+				report.Corrupted++
+				if len(report.Damaged) < maxQuarantinedReported {
+					report.Damaged = append(report.Damaged, &QuarantinedKey{
+						Bucket: b.Bucket,
+						Key:    hex.EncodeToString(item.Key()),
+						Error:  err.Error(),
+						At:     time.Now().UTC(),
+					})
+				}
+			case kind == frameLegacy:
+				b.Legacy++
+			case kind == frameSealed:
+				b.Verified++
+				b.Sealed++
+			default:
+				b.Verified++
+			}
+		}
+		return nil
+	})
+	report.Duration = time.Since(startedAt).String()
+	return report, err
+}
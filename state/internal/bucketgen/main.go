@@ -0,0 +1,178 @@
+// Command bucketgen generates constants of bucket IDs and key builders from the bucket
+// catalog of the state package, see state/catalog.go.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+)
+
+var (
+	in  = flag.String("in", "catalog.go", "File with the catalog var.")
+	out = flag.String("out", "buckets_gen.go", "File to write.")
+)
+
+type spec struct {
+	ID    uint64
+	Ident string
+	Name  string
+	Key   string
+	Doc   string
+}
+
+func main() {
+	flag.Parse()
+	specs, err := parseCatalog(*in)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := checkSpecs(specs); err != nil {
+		log.Fatalln(err)
+	}
+	src, err := generate(specs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := ioutil.WriteFile(*out, src, 0644); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func parseCatalog(file string) ([]*spec, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	var list *ast.CompositeLit
+	ast.Inspect(f, func(n ast.Node) bool {
+		vs, ok := n.(*ast.ValueSpec)
+		if !ok || len(vs.Names) != 1 || vs.Names[0].Name != "catalog" || len(vs.Values) != 1 {
+			return true
+		}
+		list, _ = vs.Values[0].(*ast.CompositeLit)
+		return false
+	})
+	if list == nil {
+		return nil, fmt.Errorf("%s: no catalog var", file)
+	}
+	var specs []*spec
+	for _, elt := range list.Elts {
+		lit, ok := elt.(*ast.CompositeLit)
+		if !ok {
+			return nil, fmt.Errorf("%s: catalog entries must be literals", fset.Position(elt.Pos()))
+		}
+		s := new(spec)
+		for _, field := range lit.Elts {
+			kv, ok := field.(*ast.KeyValueExpr)
+			if !ok {
+				return nil, fmt.Errorf("%s: catalog fields must be named", fset.Position(field.Pos()))
+			}
+			name := kv.Key.(*ast.Ident).Name
+			switch v := kv.Value.(type) {
+			case *ast.BasicLit:
+				if err := s.set(name, v); err != nil {
+					return nil, fmt.Errorf("%s: %v", fset.Position(v.Pos()), err)
+				}
+			case *ast.Ident:
+				if name == "Key" {
+					s.Key = v.Name
+				}
+			}
+		}
+		specs = append(specs, s)
+	}
+	return specs, nil
+}
+
+func (s *spec) set(field string, v *ast.BasicLit) (err error) {
+	switch field {
+	case "ID":
+		s.ID, err = strconv.ParseUint(v.Value, 0, 16)
+	case "Ident":
+		s.Ident, err = strconv.Unquote(v.Value)
+	case "Name":
+		s.Name, err = strconv.Unquote(v.Value)
+	case "Doc":
+		s.Doc, err = strconv.Unquote(v.Value)
+	}
+	return err
+}
+
+func checkSpecs(specs []*spec) error {
+	ids := make(map[uint64]string)
+	names := make(map[string]bool)
+	idents := make(map[string]bool)
+	for _, s := range specs {
+		if len(s.Ident) == 0 || len(s.Name) == 0 || len(s.Key) == 0 {
+			return fmt.Errorf("bucket 0x%02x must have Ident, Name and Key", s.ID)
+		} else if name, ok := ids[s.ID]; ok {
+			return fmt.Errorf("buckets %s and %s share ID 0x%02x", name, s.Name, s.ID)
+		} else if names[s.Name] {
+			return fmt.Errorf("bucket name %s is used twice", s.Name)
+		} else if idents[s.Ident] {
+			return fmt.Errorf("bucket ident %s is used twice", s.Ident)
+		}
+		ids[s.ID] = s.Name
+		names[s.Name] = true
+		idents[s.Ident] = true
+	}
+	return nil
+}
+
+func generate(specs []*spec) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "// Code generated by bucketgen from catalog.go. DO NOT EDIT.")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "package state")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "const (")
+	for _, s := range specs {
+		fmt.Fprintf(buf, "\t// Bucket%s %s.\n", s.Ident, s.Doc)
+		fmt.Fprintf(buf, "\tBucket%s BucketID = 0x%02x\n", s.Ident, s.ID)
+	}
+	fmt.Fprintln(buf, ")")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "// bucketIDsUnique doesn't compile if two buckets share an ID.")
+	fmt.Fprintln(buf, "func bucketIDsUnique(id BucketID) {")
+	fmt.Fprintln(buf, "\tswitch id {")
+	for _, s := range specs {
+		fmt.Fprintf(buf, "\tcase Bucket%s:\n", s.Ident)
+	}
+	fmt.Fprintln(buf, "\t}")
+	fmt.Fprintln(buf, "}")
+	for _, s := range specs {
+		fmt.Fprintln(buf)
+		switch s.Key {
+		case "KeyBytes":
+			fmt.Fprintf(buf, "// %sKey returns the key of Bucket%s.\n", s.Ident, s.Ident)
+			fmt.Fprintf(buf, "func %sKey(key []byte) *Key {\n", s.Ident)
+			fmt.Fprintf(buf, "\treturn NewKey(Bucket%s, key)\n", s.Ident)
+		case "KeyString":
+			fmt.Fprintf(buf, "// %sKey returns the key of Bucket%s.\n", s.Ident, s.Ident)
+			fmt.Fprintf(buf, "func %sKey(key string) *Key {\n", s.Ident)
+			fmt.Fprintf(buf, "\treturn NewKey(Bucket%s, []byte(key))\n", s.Ident)
+		case "KeyHashed":
+			fmt.Fprintf(buf, "// %sKey returns the key of Bucket%s, the key is hashed to fit MaxKeySize.\n", s.Ident, s.Ident)
+			fmt.Fprintf(buf, "func %sKey(key string) *Key {\n", s.Ident)
+			fmt.Fprintf(buf, "\treturn NewKey(Bucket%s, hashedKey(key))\n", s.Ident)
+		default:
+			return nil, fmt.Errorf("bucket %s has unknown key codec %s", s.Name, s.Key)
+		}
+		fmt.Fprintln(buf, "}")
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %v\n%s", err, strings.TrimSpace(buf.String()))
+	}
+	return src, nil
+}
@@ -0,0 +1,135 @@
+package state
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxKeySize is the maximum length of a key within a bucket, longer keys
+// would be truncated and collide, so they are rejected instead.
+const MaxKeySize = 26
+
+const (
+	defaultMaxValueSize = 32 * 1024 * 1024
+	// badgerValueLimit is the hard limit, values must fit into a single value log file.
+	badgerValueLimit = 1 << 30
+	// values larger than this share of the limit trigger a warning.
+	valueWarnRatio = 0.8
+)
+
+type KeyTooLargeError struct {
+	Size int
+}
+
+func (e *KeyTooLargeError) Error() string {
+	return fmt.Sprintf("state key is too large: %d bytes, max %d", e.Size, MaxKeySize)
+}
+
+type ValueTooLargeError struct {
+	Key   *Key
+	Size  int
+	Limit int
+}
+
+func (e *ValueTooLargeError) Error() string {
+	return fmt.Sprintf("state value for key %s is too large: %d bytes, max %d", e.Key.String(), e.Size, e.Limit)
+}
+
+// valueSizeBuckets are upper bounds of the value size histogram.
+var valueSizeBuckets = []int{
+	256, 1024, 4 * 1024, 16 * 1024, 64 * 1024, 256 * 1024,
+	1024 * 1024, 4 * 1024 * 1024, 16 * 1024 * 1024,
+}
+
+type StoreStats struct {
+	MaxValueSize int `json:"max_value_size"`
+	// ValueSizes is a histogram of written value sizes, keyed by upper bound in bytes.
+	ValueSizes     map[string]uint64 `json:"value_sizes"`
+	RejectedKeys   uint64            `json:"rejected_keys"`
+	RejectedValues uint64            `json:"rejected_values"`
+	NearLimit      uint64            `json:"near_limit"`
+	// Conflicts reports write conflicts per bucket.
+	Conflicts map[string]*ConflictStats `json:"conflicts,omitempty"`
+	// DiskUsage is the size of the store files in bytes, including badger value logs.
+	DiskUsage int64 `json:"disk_usage"`
+	// Maintenance reports value log GC of the badger store.
+	Maintenance *MaintenanceStats `json:"maintenance,omitempty"`
+}
+
+type sizeGuard struct {
+	maxValueSize int
+
+	histogram      []uint64
+	rejectedKeys   uint64
+	rejectedValues uint64
+	nearLimit      uint64
+}
+
+func newSizeGuard(maxValueSize int) *sizeGuard {
+	if maxValueSize <= 0 || maxValueSize > badgerValueLimit {
+		maxValueSize = badgerValueLimit
+	}
+	return &sizeGuard{
+		maxValueSize: maxValueSize,
+		histogram:    make([]uint64, len(valueSizeBuckets)+1),
+	}
+}
+
+func (g *sizeGuard) checkKey(k *Key) error {
+	if k.size > MaxKeySize {
+		atomic.AddUint64(&g.rejectedKeys, 1)
+		return &KeyTooLargeError{
+			Size: k.size,
+		}
+	}
+	return nil
+}
+
+func (g *sizeGuard) checkValue(k *Key, v []byte) error {
+	size := len(v)
+	if size > g.maxValueSize {
+		atomic.AddUint64(&g.rejectedValues, 1)
+		return &ValueTooLargeError{
+			Key:   k,
+			Size:  size,
+			Limit: g.maxValueSize,
+		}
+	}
+	if float64(size) > float64(g.maxValueSize)*valueWarnRatio {
+		atomic.AddUint64(&g.nearLimit, 1)
+		log.WithFields(log.Fields{
+			"key":   k.String(),
+			"size":  size,
+			"limit": g.maxValueSize,
+		}).Warningln("state value is approaching the size limit")
+	}
+	idx := len(valueSizeBuckets)
+	for i, bound := range valueSizeBuckets {
+		if size <= bound {
+			idx = i
+			break
+		}
+	}
+	atomic.AddUint64(&g.histogram[idx], 1)
+	return nil
+}
+
+func (g *sizeGuard) Stats() *StoreStats {
+	stats := &StoreStats{
+		MaxValueSize:   g.maxValueSize,
+		ValueSizes:     make(map[string]uint64, len(g.histogram)),
+		RejectedKeys:   atomic.LoadUint64(&g.rejectedKeys),
+		RejectedValues: atomic.LoadUint64(&g.rejectedValues),
+		NearLimit:      atomic.LoadUint64(&g.nearLimit),
+	}
+	for i := range g.histogram {
+		label := "+Inf"
+		if i < len(valueSizeBuckets) {
+			label = fmt.Sprintf("%d", valueSizeBuckets[i])
+		}
+		stats.ValueSizes[label] = atomic.LoadUint64(&g.histogram[i])
+	}
+	return stats
+}
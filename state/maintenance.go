@@ -0,0 +1,217 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	log "github.com/sirupsen/logrus"
+)
+
+// Badger never returns space of stale values on its own, value log files keep deleted,
+// expired and overwritten values until they're rewritten by value log GC. The maintenance
+// of the badger store runs GC every MaintenanceIntervalOpt and on demand, and reports the
+// space reclaimed. BoltDB and memory stores have no maintenance.
+
+const defaultMaintenanceInterval = 10 * time.Minute
+
+var (
+	ErrMaintenanceRunning = errors.New("state store maintenance is already running")
+	ErrNoMaintenance      = errors.New("state store backend has no maintenance")
+)
+
+// MaintenanceRun reports a single value log GC run.
+type MaintenanceRun struct {
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	// Rewrites is the number of value log files rewritten.
+	Rewrites       int    `json:"rewrites"`
+	ReclaimedBytes int64  `json:"reclaimed_bytes"`
+	Manual         bool   `json:"manual,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+type MaintenanceStats struct {
+	Interval       string          `json:"interval,omitempty"`
+	Running        bool            `json:"running"`
+	Runs           uint64          `json:"runs_total"`
+	Rewrites       uint64          `json:"rewrites_total"`
+	ReclaimedBytes uint64          `json:"reclaimed_bytes_total"`
+	Failures       uint64          `json:"failures_total"`
+	LastRun        *MaintenanceRun `json:"last_run,omitempty"`
+	// Spare is set if the store keeps a warm spare, see SpareOpt.
+	Spare *SpareStats `json:"spare,omitempty"`
+}
+
+type maintainedStore interface {
+	Maintenance() *MaintenanceStats
+	RunMaintenance() (*MaintenanceRun, error)
+}
+
+// Maintenance reports value log GC of the store, nil for backends without maintenance.
+func Maintenance(s IndexedStore) *MaintenanceStats {
+	if ms, ok := s.(maintainedStore); ok {
+		return ms.Maintenance()
+	}
+	return nil
+}
+
+// RunMaintenance runs value log GC of the store now, ErrMaintenanceRunning is returned
+// if a run is in progress.
+func RunMaintenance(s IndexedStore) (*MaintenanceRun, error) {
+	if ms, ok := s.(maintainedStore); ok {
+		return ms.RunMaintenance()
+	}
+	return nil, ErrNoMaintenance
+}
+
+type maintenance struct {
+	db       *badger.DB
+	dir      string
+	interval time.Duration
+	running  int32
+
+	runs      uint64
+	rewrites  uint64
+	reclaimed uint64
+	failures  uint64
+
+	mux     *sync.Mutex
+	lastRun *MaintenanceRun
+
+	stopC chan struct{}
+	doneC chan struct{}
+}
+
+func newMaintenance(db *badger.DB, dir string, interval time.Duration) *maintenance {
+	return &maintenance{
+		db:       db,
+		dir:      dir,
+		interval: interval,
+		mux:      new(sync.Mutex),
+		stopC:    make(chan struct{}),
+		doneC:    make(chan struct{}),
+	}
+}
+
+// start runs GC every interval until stop is called, zero interval leaves it to manual runs.
+func (m *maintenance) start() {
+	if m.interval <= 0 {
+		close(m.doneC)
+		return
+	}
+	go func() {
+		defer close(m.doneC)
+		t := time.NewTicker(m.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-m.stopC:
+				return
+			case <-t.C:
+				if _, err := m.run(false); err != nil && err != ErrMaintenanceRunning {
+					log.Warningf("state store maintenance failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// stop waits for a scheduled run in progress, so the store isn't closed under it.
+func (m *maintenance) stop() {
+	close(m.stopC)
+	<-m.doneC
+}
+
+func (m *maintenance) run(manual bool) (*MaintenanceRun, error) {
+	if !atomic.CompareAndSwapInt32(&m.running, 0, 1) {
+		return nil, ErrMaintenanceRunning
+	}
+	defer atomic.StoreInt32(&m.running, 0)
+	run := &MaintenanceRun{
+		StartedAt: time.Now().UTC(),
+		Manual:    manual,
+	}
+	sizeBefore := valueLogSize(m.dir)
+	var err error
+	// each call rewrites at most one file, the rest is left to the next start if the store closes
+	for !m.stopping() {
+		if err = m.db.RunValueLogGC(valueLogDiscardRatio); err != nil {
+			break
+		}
+		run.Rewrites++
+	}
+	if err == badger.ErrNoRewrite {
+		err = nil
+	}
+	if reclaimed := sizeBefore - valueLogSize(m.dir); reclaimed > 0 {
+		run.ReclaimedBytes = reclaimed
+	}
+	run.Duration = time.Since(run.StartedAt).String()
+	atomic.AddUint64(&m.runs, 1)
+	atomic.AddUint64(&m.rewrites, uint64(run.Rewrites))
+	atomic.AddUint64(&m.reclaimed, uint64(run.ReclaimedBytes))
+	if err != nil {
+		atomic.AddUint64(&m.failures, 1)
+		run.Error = err.Error()
+	}
+	m.mux.Lock()
+	m.lastRun = run
+	m.mux.Unlock()
+	if run.Rewrites > 0 {
+		log.WithFields(log.Fields{
+			"rewrites":  run.Rewrites,
+			"reclaimed": run.ReclaimedBytes,
+			"duration":  run.Duration,
+		}).Infoln("state store value log GC done")
+	}
+	return run, err
+}
+
+func (m *maintenance) stopping() bool {
+	select {
+	case <-m.stopC:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *maintenance) Stats() *MaintenanceStats {
+	stats := &MaintenanceStats{
+		Running:        atomic.LoadInt32(&m.running) == 1,
+		Runs:           atomic.LoadUint64(&m.runs),
+		Rewrites:       atomic.LoadUint64(&m.rewrites),
+		ReclaimedBytes: atomic.LoadUint64(&m.reclaimed),
+		Failures:       atomic.LoadUint64(&m.failures),
+	}
+	if m.interval > 0 {
+		stats.Interval = m.interval.String()
+	}
+	m.mux.Lock()
+	if m.lastRun != nil {
+		run := *m.lastRun
+		stats.LastRun = &run
+	}
+	m.mux.Unlock()
+	return stats
+}
+
+// valueLogSize sums sizes of badger value log files in dir.
+func valueLogSize(dir string) int64 {
+	files, err := filepath.Glob(filepath.Join(dir, "*.vlog"))
+	if err != nil {
+		return 0
+	}
+	var size int64
+	for _, name := range files {
+		if info, err := os.Stat(name); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
@@ -0,0 +1,300 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memorySweepWrites is the number of writes between sweeps of expired keys.
+const memorySweepWrites = 10000
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// memoryStore implements IndexedStore in memory, the state is lost once the store
+// is closed. Suits tests and ephemeral nodes. Writes are serialized, so they never
+// conflict; ranges iterate over a snapshot of the bucket.
+type memoryStore struct {
+	opts  *storeOptions
+	guard *sizeGuard
+
+	mux     *sync.RWMutex
+	keys    []string
+	entries map[string]*memoryEntry
+	writes  int
+}
+
+func newMemoryStore(opts ...storeOpt) *memoryStore {
+	s := &memoryStore{
+		opts:    defaultStoreOptions(),
+		mux:     new(sync.RWMutex),
+		entries: make(map[string]*memoryEntry),
+	}
+	for _, o := range opts {
+		if o != nil {
+			o(s.opts)
+		}
+	}
+	s.guard = newSizeGuard(s.opts.MaxValueSize)
+	return s
+}
+
+func (s *memoryStore) get(key string, now time.Time) ([]byte, bool) {
+	e, ok := s.entries[key]
+	if !ok || e.expired(now) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// set must be called with the write lock held.
+func (s *memoryStore) set(key string, v []byte, ttl time.Duration) {
+	e := &memoryEntry{
+		value: append([]byte(nil), v...),
+	}
+	if ttl > 0 {
+		e.expiresAt = time.Now().Add(ttl)
+	}
+	if _, ok := s.entries[key]; !ok {
+		idx := sort.SearchStrings(s.keys, key)
+		s.keys = append(s.keys, "")
+		copy(s.keys[idx+1:], s.keys[idx:])
+		s.keys[idx] = key
+	}
+	s.entries[key] = e
+	s.writes++
+	if s.writes%memorySweepWrites == 0 {
+		s.sweep()
+	}
+}
+
+// remove must be called with the write lock held.
+func (s *memoryStore) remove(key string) {
+	if _, ok := s.entries[key]; !ok {
+		return
+	}
+	delete(s.entries, key)
+	idx := sort.SearchStrings(s.keys, key)
+	if idx < len(s.keys) && s.keys[idx] == key {
+		s.keys = append(s.keys[:idx], s.keys[idx+1:]...)
+	}
+}
+
+// sweep removes expired keys, must be called with the write lock held.
+func (s *memoryStore) sweep() {
+	now := time.Now()
+	keys := s.keys[:0]
+	for _, key := range s.keys {
+		if s.entries[key].expired(now) {
+			delete(s.entries, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	s.keys = keys
+}
+
+func (s *memoryStore) View(ctx context.Context, k *Key, fn PeekFunc) error {
+	if err := s.guard.checkKey(k); err != nil {
+		return err
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mux.RLock()
+	v, ok := s.get(string(k.Bytes()), time.Now())
+	s.mux.RUnlock()
+	if !ok {
+		return ErrNotFound
+	}
+	return fn(k, append([]byte(nil), v...))
+}
+
+func (s *memoryStore) Update(ctx context.Context, k *Key, fn ModifyFunc) error {
+	if err := s.guard.checkKey(k); err != nil {
+		return err
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+	if fn == nil {
+		return nil
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.update(string(k.Bytes()), k, fn)
+}
+
+// update applies fn to the value of the key, must be called with the write lock held.
+// The value is written if fn returns ErrRangeStop as well.
+func (s *memoryStore) update(key string, k *Key, fn ModifyFunc) error {
+	var v []byte
+	if current, ok := s.get(key, time.Now()); ok {
+		v = append([]byte(nil), current...)
+	}
+	vv, err := fn(k, v)
+	if err == ErrNoUpdate {
+		return nil
+	} else if err != nil && err != ErrRangeStop {
+		return err
+	} else if err := s.guard.checkValue(k, vv); err != nil {
+		return err
+	}
+	s.set(key, vv, k.TTL)
+	return err
+}
+
+func (s *memoryStore) Delete(k *Key) error {
+	if k == nil {
+		return nil
+	} else if err := s.guard.checkKey(k); err != nil {
+		return err
+	}
+	s.mux.Lock()
+	s.remove(string(k.Bytes()))
+	s.mux.Unlock()
+	return nil
+}
+
+type memoryItem struct {
+	key   []byte
+	value []byte
+}
+
+// snapshot returns live items of the range in key order, the limit is applied by the caller.
+func (s *memoryStore) snapshot(w *rangeWalk, withValues bool) []memoryItem {
+	prefix := w.prefix
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	now := time.Now()
+	var items []memoryItem
+	for idx := sort.SearchStrings(s.keys, string(w.start)); idx < len(s.keys); idx++ {
+		key := s.keys[idx]
+		if !bytes.HasPrefix([]byte(key), prefix) {
+			break
+		}
+		e := s.entries[key]
+		if e.expired(now) {
+			continue
+		}
+		item := memoryItem{
+			key: []byte(key),
+		}
+		if withValues {
+			item.value = append([]byte(nil), e.value...)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func (s *memoryStore) RangeKeys(ctx context.Context, b Bucket, fn KeyFunc) (*RangeOptions, error) {
+	w := newRangeWalk(ctx, b)
+	for _, item := range s.snapshot(w, false) {
+		switch w.next(item.key) {
+		case rangeDone:
+			return w.result(nil)
+		case rangeSkip:
+			continue
+		}
+		k := (&Key{}).Unmarshal(item.key)
+		if err := fn(k); err == ErrRangeStop {
+			w.stop()
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return w.result(nil)
+}
+
+func (s *memoryStore) RangePeek(ctx context.Context, b Bucket, fn PeekFunc) (*RangeOptions, error) {
+	w := newRangeWalk(ctx, b)
+	for _, item := range s.snapshot(w, true) {
+		switch w.next(item.key) {
+		case rangeDone:
+			return w.result(nil)
+		case rangeSkip:
+			continue
+		}
+		k := (&Key{}).Unmarshal(item.key)
+		if err := fn(k, item.value); err == ErrRangeStop {
+			w.stop()
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return w.result(nil)
+}
+
+// RangeModify applies fn to each key of the bucket, unlike badger the range is not
+// a single transaction, but each key is updated atomically.
+func (s *memoryStore) RangeModify(ctx context.Context, b Bucket, fn ModifyFunc) (*RangeOptions, error) {
+	w := newRangeWalk(ctx, b)
+	for _, item := range s.snapshot(w, false) {
+		switch w.next(item.key) {
+		case rangeDone:
+			return w.result(nil)
+		case rangeSkip:
+			continue
+		}
+		k := (&Key{}).Unmarshal(item.key)
+		key := string(item.key)
+		s.mux.Lock()
+		var err error
+		if _, ok := s.get(key, time.Now()); ok {
+			err = s.update(key, k, fn)
+		}
+		s.mux.Unlock()
+		if err == ErrRangeStop {
+			w.stop()
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return w.result(nil)
+}
+
+func (s *memoryStore) Expire(id BucketID, ttl time.Duration) (int, error) {
+	prefix := id.Bytes()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	now := time.Now()
+	var expired int
+	for idx := sort.SearchStrings(s.keys, string(prefix)); idx < len(s.keys); idx++ {
+		key := s.keys[idx]
+		if !bytes.HasPrefix([]byte(key), prefix) {
+			break
+		}
+		if e := s.entries[key]; e.expiresAt.IsZero() {
+			e.expiresAt = now.Add(ttl)
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (s *memoryStore) Compact() error {
+	s.mux.Lock()
+	s.sweep()
+	s.mux.Unlock()
+	return nil
+}
+
+func (s *memoryStore) Stats() *StoreStats {
+	return s.guard.Stats()
+}
+
+func (s *memoryStore) Close() error {
+	s.mux.Lock()
+	s.keys = nil
+	s.entries = make(map[string]*memoryEntry)
+	s.mux.Unlock()
+	return nil
+}
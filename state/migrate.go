@@ -0,0 +1,123 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The layout of buckets may change between releases, e.g. a new index of existing records.
+// The schema version of the store is kept under its own key, migrations of newer versions
+// are run in order on start, and the version is saved after each one, so an interrupted
+// upgrade resumes where it stopped. Stores without the version key predate it and run all
+// migrations, so migrations must be safe to run on a store that already has their layout.
+// A node refuses a store of a newer version instead of reading a layout it doesn't know.
+
+var schemaVersionKey = SchemaKey("version")
+
+// SchemaTooNewError is returned for stores written by a newer release of the node.
+type SchemaTooNewError struct {
+	Version   int
+	Supported int
+}
+
+func (e *SchemaTooNewError) Error() string {
+	return fmt.Sprintf("state schema version %d is newer than %d supported by this release, upgrade the node",
+		e.Version, e.Supported)
+}
+
+// Migration changes the layout of the store to its version.
+type Migration struct {
+	Version int
+	Name    string
+	// Apply changes keys of the store, or only counts the keys it would change if dryRun is set.
+	Apply func(s IndexedStore, dryRun bool) (changed int, err error)
+}
+
+type MigrationReport struct {
+	Version  int    `json:"version"`
+	Name     string `json:"name"`
+	Changed  int    `json:"changed_keys"`
+	Duration string `json:"duration"`
+	DryRun   bool   `json:"dry_run,omitempty"`
+}
+
+// SchemaVersion returns the schema version of the store, zero if it has never been migrated.
+func SchemaVersion(s IndexedStore) (int, error) {
+	var version int
+	err := s.View(context.Background(), schemaVersionKey, func(k *Key, v []byte) error {
+		return UnmarshalValue(k, v, &version)
+	})
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	return version, err
+}
+
+func setSchemaVersion(s IndexedStore, version int) error {
+	return s.Update(context.Background(), schemaVersionKey, func(k *Key, v []byte) ([]byte, error) {
+		return MarshalValue(k, version)
+	})
+}
+
+// Migrate runs migrations newer than the schema version of the store in order. With dryRun
+// set nothing is changed, reports tell what would be. Versions of migrations must be unique.
+func Migrate(s IndexedStore, migrations []*Migration, dryRun bool) ([]*MigrationReport, error) {
+	migrations = append([]*Migration(nil), migrations...)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	var latest int
+	for i, m := range migrations {
+		if i > 0 && migrations[i-1].Version == m.Version {
+			return nil, fmt.Errorf("duplicate state migration version %d", m.Version)
+		}
+		latest = m.Version
+	}
+	version, err := SchemaVersion(s)
+	if err != nil {
+		return nil, err
+	} else if version > latest {
+		return nil, &SchemaTooNewError{
+			Version:   version,
+			Supported: latest,
+		}
+	}
+	var reports []*MigrationReport
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		fields := log.Fields{
+			"version": m.Version,
+			"name":    m.Name,
+		}
+		if !dryRun {
+			log.WithFields(fields).Println("migrating the state store")
+		}
+		startedAt := time.Now()
+		changed, err := m.Apply(s, dryRun)
+		if err != nil {
+			err = fmt.Errorf("state migration %d (%s) failed: %v", m.Version, m.Name, err)
+			return reports, err
+		}
+		reports = append(reports, &MigrationReport{
+			Version:  m.Version,
+			Name:     m.Name,
+			Changed:  changed,
+			Duration: time.Since(startedAt).String(),
+			DryRun:   dryRun,
+		})
+		if dryRun {
+			continue
+		} else if err := setSchemaVersion(s, m.Version); err != nil {
+			err = fmt.Errorf("failed to save state schema version %d: %v", m.Version, err)
+			return reports, err
+		}
+		log.WithFields(fields).Printf("state store migrated, %d keys changed", changed)
+	}
+	return reports, nil
+}
@@ -1,14 +1,44 @@
 package state
 
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
 type storeOptions struct {
-	SyncWrites bool
+	SyncWrites      bool
+	MaxValueSize    int
+	ConflictRetries int
+	ConflictModes   map[BucketID]ConflictMode
+	// Recover salvages a damaged badger store on open instead of failing, see DamageReport.
+	Recover bool
+	// MaintenanceInterval is the interval of value log GC of badger, zero disables it.
+	MaintenanceInterval time.Duration
+	// SpareDir keeps a warm spare of the badger store taken every SpareInterval, see SpareOpt.
+	SpareDir      string
+	SpareInterval time.Duration
+	// Checksums frames values of the badger store with checksums verified on reads.
+	Checksums bool
+	// EncryptionSecret wraps the data key values of encrypted buckets are sealed with,
+	// see EncryptionOpt.
+	EncryptionSecret []byte
+	EncryptedBuckets map[BucketID]bool
+	EncryptAll       bool
 }
 
 type storeOpt func(o *storeOptions)
 
 func defaultStoreOptions() *storeOptions {
 	return &storeOptions{
-		SyncWrites: true,
+		SyncWrites:      true,
+		MaxValueSize:    defaultMaxValueSize,
+		ConflictRetries: defaultConflictRetries,
+		ConflictModes:   make(map[BucketID]ConflictMode),
+		Recover:         true,
+		Checksums:       true,
+
+		MaintenanceInterval: defaultMaintenanceInterval,
 	}
 }
 
@@ -17,3 +47,93 @@ func NoSyncOption() storeOpt {
 		o.SyncWrites = false
 	}
 }
+
+// MaxValueSizeOpt sets the maximum size of a value, writes of larger values are rejected.
+// The limit cannot exceed the hard limit of badger.
+func MaxValueSizeOpt(size int) storeOpt {
+	return func(o *storeOptions) {
+		o.MaxValueSize = size
+	}
+}
+
+// ConflictRetriesOpt sets how many times a write transaction is retried on conflicts.
+func ConflictRetriesOpt(n int) storeOpt {
+	return func(o *storeOptions) {
+		o.ConflictRetries = n
+	}
+}
+
+// RecoverOpt sets whether a damaged store is recovered on open, the open fails otherwise.
+func RecoverOpt(recover bool) storeOpt {
+	return func(o *storeOptions) {
+		o.Recover = recover
+	}
+}
+
+// MaintenanceIntervalOpt sets how often value log GC of the badger store runs, zero
+// leaves it to RunMaintenance.
+func MaintenanceIntervalOpt(interval time.Duration) storeOpt {
+	return func(o *storeOptions) {
+		o.MaintenanceInterval = interval
+	}
+}
+
+// SpareOpt keeps a warm spare of the badger store in dir, refreshed every interval. A damaged
+// store is restored from the spare on open instead of being salvaged, see RecoverOpt.
+func SpareOpt(dir string, interval time.Duration) storeOpt {
+	return func(o *storeOptions) {
+		o.SpareDir = dir
+		o.SpareInterval = interval
+	}
+}
+
+// PessimisticBucketsOpt serializes writes to the named buckets instead of retrying
+// conflicting transactions, unknown names are ignored.
+func PessimisticBucketsOpt(names ...string) storeOpt {
+	return func(o *storeOptions) {
+		for _, name := range names {
+			if len(name) == 0 {
+				continue
+			}
+			id, ok := BucketByName(name)
+			if !ok {
+				log.Warningf("unknown state bucket: %s", name)
+				continue
+			}
+			o.ConflictModes[id] = ConflictPessimistic
+		}
+	}
+}
+
+// ChecksumsOpt sets whether values written to the badger store carry checksums, values
+// written with checksums are verified on reads either way.
+func ChecksumsOpt(enabled bool) storeOpt {
+	return func(o *storeOptions) {
+		o.Checksums = enabled
+	}
+}
+
+// EncryptionOpt seals values of the named buckets with AES-GCM, "*" names all of them. The data
+// key is kept in the state dir wrapped with the secret, unknown names are ignored.
+func EncryptionOpt(secret []byte, names ...string) storeOpt {
+	return func(o *storeOptions) {
+		o.EncryptionSecret = secret
+		for _, name := range names {
+			if len(name) == 0 {
+				continue
+			} else if name == "*" {
+				o.EncryptAll = true
+				continue
+			}
+			id, ok := BucketByName(name)
+			if !ok {
+				log.Warningf("unknown state bucket: %s", name)
+				continue
+			}
+			if o.EncryptedBuckets == nil {
+				o.EncryptedBuckets = make(map[BucketID]bool)
+			}
+			o.EncryptedBuckets[id] = true
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package state
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	log "github.com/sirupsen/logrus"
+)
+
+// A badger store with a damaged value log or tables fails to open, reads of damaged values
+// fail too. Instead of a crash loop the store is recovered on open: first the value log is
+// truncated at the damaged entry, and if the store still can't be opened it's moved aside to
+// a quarantine dir and keys that can be read are salvaged into a new store. Keys whose values
+// can't be read, on salvage or later, are quarantined: they're listed in the damage report and
+// skipped by ranges. The sync checkpoint is not salvaged, so the next sync fetches all records.
+// A recovered store runs degraded until the damage is cleared, the report is kept in the state
+// dir across restarts.
+
+const (
+	damageReportFile = "damage.json"
+	// maxQuarantinedReported limits keys listed in the report, all of them are counted.
+	maxQuarantinedReported = 1000
+	salvageBatchSize       = 1000
+)
+
+const (
+	RecoveryTruncated = "truncated"
+	RecoverySalvaged  = "salvaged"
+	RecoveryReset     = "reset"
+	RecoverySpare     = "spare"
+	RecoveryNone      = "none"
+)
+
+// QuarantinedKey is a key whose value couldn't be read.
+type QuarantinedKey struct {
+	Bucket string    `json:"bucket"`
+	Key    string    `json:"key"`
+	Error  string    `json:"error"`
+	At     time.Time `json:"at"`
+}
+
+// DamageReport describes the damage found in the state store and what was done about it.
+type DamageReport struct {
+	DetectedAt time.Time `json:"detected_at"`
+	Cause      string    `json:"cause"`
+	// Action is how the store was recovered on open, "none" if the damage was found on reads.
+	Action string `json:"action"`
+	// QuarantineDir keeps the damaged store as it was, set if keys were salvaged from it.
+	QuarantineDir string `json:"quarantine_dir,omitempty"`
+	// SpareAt is when the warm spare the store was restored from was taken.
+	SpareAt          *time.Time        `json:"spare_at,omitempty"`
+	SalvagedKeys     int               `json:"salvaged_keys"`
+	QuarantinedTotal int               `json:"quarantined_total"`
+	Quarantined      []*QuarantinedKey `json:"quarantined,omitempty"`
+}
+
+// damageStore is implemented by backends that recover from damage.
+type damageStore interface {
+	Damage() *DamageReport
+	ClearDamage() error
+}
+
+// Damage returns the damage report of the store, nil if it's not degraded.
+func Damage(s IndexedStore) *DamageReport {
+	if ds, ok := s.(damageStore); ok {
+		return ds.Damage()
+	}
+	return nil
+}
+
+// ClearDamage drops the damage report once the operator has dealt with it, the store
+// leaves degraded mode.
+func ClearDamage(s IndexedStore) error {
+	if ds, ok := s.(damageStore); ok {
+		return ds.ClearDamage()
+	}
+	return nil
+}
+
+// isCorruption tells whether a badger error means that data on the disk is damaged.
+func isCorruption(err error) bool {
+	if err == nil {
+		return false
+	} else if err == badger.ErrTruncateNeeded {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{
+		"checksum", "corrupt", "truncate", "unexpected eof", "bad magic",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+type damageTracker struct {
+	mux    *sync.RWMutex
+	path   string
+	report *DamageReport
+}
+
+func newDamageTracker(prefix string) *damageTracker {
+	t := &damageTracker{
+		mux:  new(sync.RWMutex),
+		path: filepath.Join(prefix, damageReportFile),
+	}
+	data, err := ioutil.ReadFile(t.path)
+	if os.IsNotExist(err) {
+		return t
+	} else if err != nil {
+		log.Warningf("failed to read state damage report: %v", err)
+		return t
+	}
+	var report *DamageReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		log.Warningf("malformed state damage report: %v", err)
+		return t
+	}
+	t.report = report
+	return t
+}
+
+func (t *damageTracker) Report() *DamageReport {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	if t.report == nil {
+		return nil
+	}
+	report := *t.report
+	report.Quarantined = append([]*QuarantinedKey(nil), t.report.Quarantined...)
+	return &report
+}
+
+// recovered starts a report of the recovery on open.
+func (t *damageTracker) recovered(cause error, action string) *DamageReport {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.report = &DamageReport{
+		DetectedAt: time.Now().UTC(),
+		Cause:      cause.Error(),
+		Action:     action,
+	}
+	return t.report
+}
+
+// quarantine adds the key to the report, starting one if the store was fine so far.
+func (t *damageTracker) quarantine(key []byte, err error) {
+	k := (&Key{}).Unmarshal(key)
+	qk := &QuarantinedKey{
+		Bucket: k.Bucket.ID.String(),
+		Key:    hex.EncodeToString(key),
+		Error:  err.Error(),
+		At:     time.Now().UTC(),
+	}
+	log.WithFields(log.Fields{
+		"bucket": qk.Bucket,
+		"key":    qk.Key,
+	}).Errorf("state value is unreadable, the key is quarantined: %v", err)
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.report == nil {
+		t.report = &DamageReport{
+			DetectedAt: qk.At,
+			Cause:      err.Error(),
+			Action:     RecoveryNone,
+		}
+	}
+	t.report.QuarantinedTotal++
+	if len(t.report.Quarantined) < maxQuarantinedReported {
+		t.report.Quarantined = append(t.report.Quarantined, qk)
+	}
+	t.saveLocked()
+}
+
+func (t *damageTracker) save() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.saveLocked()
+}
+
+func (t *damageTracker) saveLocked() {
+	if t.report == nil {
+		return
+	}
+	data, err := json.MarshalIndent(t.report, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(t.path, data, 0600); err != nil {
+		log.Warningf("failed to save state damage report: %v", err)
+	}
+}
+
+func (t *damageTracker) clear() error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.report = nil
+	if err := os.Remove(t.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// openBadgerRecover opens the store, recovering it if it's damaged. The warm spare in
+// spareDir is preferred to salvage if there's one.
+func openBadgerRecover(opts badger.Options, spareDir string, damage *damageTracker) (*badger.DB, error) {
+	db, err := badger.Open(opts)
+	if err == nil || !isCorruption(err) {
+		return db, err
+	}
+	cause := err
+	log.Errorf("state store is damaged: %v", cause)
+
+	log.Warningln("truncating the value log of the state store at the damaged entry")
+	truncOpts := opts
+	truncOpts.Truncate = true
+	if db, err = badger.Open(truncOpts); err == nil {
+		damage.recovered(cause, RecoveryTruncated)
+		damage.save()
+		log.Warningln("state store is recovered, entries after the damaged one are lost")
+		return db, nil
+	}
+	log.Errorf("state store can't be opened after truncation: %v", err)
+	if len(spareDir) > 0 {
+		if db, err = restoreSpare(opts, spareDir, cause, damage); err == nil {
+			return db, nil
+		} else if err != errNoSpare {
+			log.Errorf("state store can't be restored from the warm spare: %v", err)
+		}
+	}
+	return salvageBadger(opts, cause, damage)
+}
+
+// salvageBadger moves the damaged store aside and copies keys it can read into a new store.
+func salvageBadger(opts badger.Options, cause error, damage *damageTracker) (*badger.DB, error) {
+	dir := filepath.Clean(opts.Dir)
+	quarantineDir := fmt.Sprintf("%s.damaged-%s", dir, time.Now().UTC().Format("20060102T150405"))
+	if err := quarantineBadgerFiles(dir, quarantineDir); err != nil {
+		err = fmt.Errorf("failed to quarantine the damaged state store: %v", err)
+		return nil, err
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		err = fmt.Errorf("failed to create a new state store: %v", err)
+		return nil, err
+	}
+	report := damage.recovered(cause, RecoveryReset)
+	damage.mux.Lock()
+	report.QuarantineDir = quarantineDir
+	damage.mux.Unlock()
+	log.Warningf("damaged state store is moved to %s, salvaging keys", quarantineDir)
+
+	srcOpts := opts
+	srcOpts.Dir = quarantineDir
+	srcOpts.ValueDir = quarantineDir
+	srcOpts.ReadOnly = true
+	src, err := badger.Open(srcOpts)
+	if err != nil {
+		log.Errorf("damaged state store can't be read, starting with an empty one: %v", err)
+		damage.save()
+		return db, nil
+	}
+	defer src.Close()
+	salvaged, err := copyReadable(src, db, damage)
+	damage.mux.Lock()
+	report.Action = RecoverySalvaged
+	report.SalvagedKeys = salvaged
+	damage.mux.Unlock()
+	damage.save()
+	if err != nil {
+		log.Errorf("salvage of the state store stopped early: %v", err)
+	}
+	log.Warningf("salvaged %d keys of the damaged state store", salvaged)
+	return db, nil
+}
+
+// quarantineBadgerFiles moves files of badger to the quarantine dir, other files
+// of the state dir like the private API token stay in place.
+func quarantineBadgerFiles(dir, quarantineDir string) error {
+	if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() {
+			continue
+		} else if ext := filepath.Ext(name); ext != ".sst" && ext != ".vlog" &&
+			name != "MANIFEST" && name != "LOCK" {
+			continue
+		}
+		if err := os.Rename(filepath.Join(dir, name), filepath.Join(quarantineDir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type salvagedItem struct {
+	key       []byte
+	value     []byte
+	expiresAt uint64
+}
+
+// copyReadable copies keys of src to dst, keys with unreadable values are quarantined.
+func copyReadable(src, dst *badger.DB, damage *damageTracker) (salvaged int, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic while reading the damaged store: %v", v)
+		}
+	}()
+	var batch []salvagedItem
+	flush := func() error {
+		if err := writeSalvaged(dst, batch); err != nil {
+			return err
+		}
+		salvaged += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	err = src.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := tx.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			key := item.KeyCopy(nil)
+			if k := (&Key{}).Unmarshal(key); k.Bucket.ID == BucketSyncState {
+				continue
+			}
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				damage.quarantine(key, err)
+				continue
+			}
+			batch = append(batch, salvagedItem{
+				key:       key,
+				value:     value,
+				expiresAt: item.ExpiresAt(),
+			})
+			if len(batch) >= salvageBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return salvaged, err
+	}
+	return salvaged, flush()
+}
+
+func writeSalvaged(db *badger.DB, items []salvagedItem) error {
+	write := func(items []salvagedItem) error {
+		return db.Update(func(tx *badger.Txn) error {
+			for _, item := range items {
+				if item.expiresAt == 0 {
+					if err := tx.Set(item.key, item.value); err != nil {
+						return err
+					}
+					continue
+				}
+				ttl := time.Until(time.Unix(int64(item.expiresAt), 0))
+				if ttl <= 0 {
+					continue
+				} else if err := tx.SetWithTTL(item.key, item.value, ttl); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err := write(items); err != badger.ErrTxnTooBig {
+		return err
+	}
+	for i := range items {
+		if err := write(items[i : i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
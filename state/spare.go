@@ -0,0 +1,303 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	log "github.com/sirupsen/logrus"
+)
+
+// A warm spare is a copy of the badger store taken every SpareOpt interval: a backup at
+// the read version of a transaction is loaded into a new badger dir next to the spare, which
+// replaces the spare once it's closed. If the store is damaged and truncation of the value log
+// doesn't help, the spare is copied in place of the damaged files and opened right away,
+// instead of salvaging keys one by one. The spare holds the sync checkpoint of its time, so
+// the next sync fetches only records changed since the spare was taken, and announces kept in
+// the outbound journal at that time are published again.
+
+const spareMetaFile = "spare.json"
+
+var errNoSpare = errors.New("no warm spare of the state store")
+
+type spareMeta struct {
+	Version   uint64    `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Duration  string    `json:"duration"`
+}
+
+// SpareStats reports the warm spare of the badger store.
+type SpareStats struct {
+	Dir      string `json:"dir"`
+	Interval string `json:"interval"`
+	// Version is the badger version the spare was taken at, zero if there's no spare yet.
+	Version   uint64     `json:"version"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	Duration  string     `json:"duration,omitempty"`
+	Refreshes uint64     `json:"refreshes_total"`
+	Failures  uint64     `json:"failures_total"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+type spareKeeper struct {
+	db       *badger.DB
+	dir      string
+	interval time.Duration
+	running  int32
+
+	refreshes uint64
+	failures  uint64
+
+	mux     *sync.Mutex
+	meta    *spareMeta
+	lastErr string
+
+	stopC chan struct{}
+	doneC chan struct{}
+}
+
+func newSpareKeeper(db *badger.DB, dir string, interval time.Duration) *spareKeeper {
+	return &spareKeeper{
+		db:       db,
+		dir:      dir,
+		interval: interval,
+		mux:      new(sync.Mutex),
+		meta:     readSpareMeta(dir),
+		stopC:    make(chan struct{}),
+		doneC:    make(chan struct{}),
+	}
+}
+
+// start refreshes the spare every interval until stop is called, the first refresh
+// is done right away if the spare is missing or stale.
+func (k *spareKeeper) start() {
+	go func() {
+		defer close(k.doneC)
+		wait := k.interval
+		if k.meta == nil {
+			wait = 0
+		} else if age := time.Since(k.meta.UpdatedAt); age < k.interval {
+			wait = k.interval - age
+		} else {
+			wait = 0
+		}
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		for {
+			select {
+			case <-k.stopC:
+				return
+			case <-t.C:
+				if err := k.refresh(); err != nil {
+					log.Warningf("failed to refresh the warm spare of the state store: %v", err)
+				}
+				t.Reset(k.interval)
+			}
+		}
+	}()
+}
+
+// stop waits for a refresh in progress, so the store isn't closed under it.
+func (k *spareKeeper) stop() {
+	close(k.stopC)
+	<-k.doneC
+}
+
+func (k *spareKeeper) refresh() (err error) {
+	if !atomic.CompareAndSwapInt32(&k.running, 0, 1) {
+		return nil
+	}
+	defer atomic.StoreInt32(&k.running, 0)
+	startedAt := time.Now()
+	defer func() {
+		atomic.AddUint64(&k.refreshes, 1)
+		k.mux.Lock()
+		k.lastErr = ""
+		if err != nil {
+			atomic.AddUint64(&k.failures, 1)
+			k.lastErr = err.Error()
+		}
+		k.mux.Unlock()
+	}()
+	tmpDir := k.dir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	} else if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return err
+	}
+	version, err := copyBadger(k.db, tmpDir)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	meta := &spareMeta{
+		Version:   version,
+		UpdatedAt: time.Now().UTC(),
+		Duration:  time.Since(startedAt).String(),
+	}
+	data, _ := json.MarshalIndent(meta, "", "  ")
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, spareMetaFile), data, 0600); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	// the old spare is kept until the new one is in place
+	oldDir := k.dir + ".old"
+	os.RemoveAll(oldDir)
+	if err := os.Rename(k.dir, oldDir); err != nil && !os.IsNotExist(err) {
+		return err
+	} else if err := os.Rename(tmpDir, k.dir); err != nil {
+		return err
+	}
+	os.RemoveAll(oldDir)
+	k.mux.Lock()
+	k.meta = meta
+	k.mux.Unlock()
+	log.WithFields(log.Fields{
+		"version":  meta.Version,
+		"duration": meta.Duration,
+	}).Debugln("warm spare of the state store is refreshed")
+	return nil
+}
+
+// copyBadger loads a backup of src into a new badger store in dir, it returns the
+// version of the backup.
+func copyBadger(src *badger.DB, dir string) (uint64, error) {
+	opts := badger.DefaultOptions
+	opts.Dir = dir
+	opts.ValueDir = dir
+	opts.SyncWrites = false
+	dst, err := badger.Open(opts)
+	if err != nil {
+		return 0, err
+	}
+	pr, pw := io.Pipe()
+	versionC := make(chan uint64, 1)
+	go func() {
+		v, err := src.Backup(pw, 0)
+		pw.CloseWithError(err)
+		versionC <- v
+	}()
+	err = dst.Load(pr)
+	// unblocks the backup if the load stopped early
+	pr.CloseWithError(err)
+	version := <-versionC
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return version, err
+}
+
+func (k *spareKeeper) Stats() *SpareStats {
+	stats := &SpareStats{
+		Dir:       k.dir,
+		Interval:  k.interval.String(),
+		Refreshes: atomic.LoadUint64(&k.refreshes),
+		Failures:  atomic.LoadUint64(&k.failures),
+	}
+	k.mux.Lock()
+	if k.meta != nil {
+		updatedAt := k.meta.UpdatedAt
+		stats.Version = k.meta.Version
+		stats.UpdatedAt = &updatedAt
+		stats.Duration = k.meta.Duration
+	}
+	stats.LastError = k.lastErr
+	k.mux.Unlock()
+	return stats
+}
+
+func readSpareMeta(dir string) *spareMeta {
+	data, err := ioutil.ReadFile(filepath.Join(dir, spareMetaFile))
+	if err != nil {
+		return nil
+	}
+	var meta spareMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil
+	}
+	return &meta
+}
+
+// restoreSpare moves the damaged store aside and opens a copy of the warm spare in its place.
+func restoreSpare(opts badger.Options, spareDir string, cause error, damage *damageTracker) (*badger.DB, error) {
+	meta := readSpareMeta(spareDir)
+	if meta == nil {
+		return nil, errNoSpare
+	}
+	dir := filepath.Clean(opts.Dir)
+	quarantineDir := fmt.Sprintf("%s.damaged-%s", dir, time.Now().UTC().Format("20060102T150405"))
+	if err := quarantineBadgerFiles(dir, quarantineDir); err != nil {
+		err = fmt.Errorf("failed to quarantine the damaged state store: %v", err)
+		return nil, err
+	}
+	if err := copyBadgerFiles(spareDir, dir); err != nil {
+		err = fmt.Errorf("failed to copy the warm spare: %v", err)
+		return nil, err
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		err = fmt.Errorf("failed to open the warm spare: %v", err)
+		return nil, err
+	}
+	report := damage.recovered(cause, RecoverySpare)
+	damage.mux.Lock()
+	report.QuarantineDir = quarantineDir
+	spareAt := meta.UpdatedAt
+	report.SpareAt = &spareAt
+	damage.mux.Unlock()
+	damage.save()
+	log.WithFields(log.Fields{
+		"spare_at":   meta.UpdatedAt.Format(time.RFC3339),
+		"quarantine": quarantineDir,
+	}).Warningln("state store is restored from the warm spare, changes since are fetched by sync")
+	return db, nil
+}
+
+// copyBadgerFiles copies files of badger from src to dst, the spare stays usable.
+func copyBadgerFiles(src, dst string) error {
+	infos, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() {
+			continue
+		} else if ext := filepath.Ext(name); ext != ".sst" && ext != ".vlog" && name != "MANIFEST" {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, name), filepath.Join(dst, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -1,9 +1,11 @@
 package state
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -12,15 +14,31 @@ var ErrNotFound = errors.New("not found")
 // IndexedStore is an interface to internal node state.
 // The state includes file names, version history, in other words it's a collection of indexed metadata.
 // The state must be synchronised across all utility Atlant nodes.
+// Implementations are backed by badger, BoltDB or memory, see NewIndexedStore.
+//
+// Reads and updates fail with the context error once ctx is done. Ranges check ctx every
+// few hundred keys and end with its error, writes of a canceled RangeModify are discarded
+// by badger and BoltDB, while the memory store keeps keys updated before the cancellation.
 type IndexedStore interface {
-	View(k *Key, fn PeekFunc) error
-	Update(k *Key, fn ModifyFunc) error
+	View(ctx context.Context, k *Key, fn PeekFunc) error
+	Update(ctx context.Context, k *Key, fn ModifyFunc) error
 	Delete(k *Key) error
 
-	RangeKeys(b Bucket, fn KeyFunc) (*RangeOptions, error)
-	RangePeek(b Bucket, fn PeekFunc) (*RangeOptions, error)
-	RangeModify(b Bucket, fn ModifyFunc) (*RangeOptions, error)
+	RangeKeys(ctx context.Context, b Bucket, fn KeyFunc) (*RangeOptions, error)
+	RangePeek(ctx context.Context, b Bucket, fn PeekFunc) (*RangeOptions, error)
+	RangeModify(ctx context.Context, b Bucket, fn ModifyFunc) (*RangeOptions, error)
 
+	// Batch runs fn in a single transaction, writes staged through tx are committed
+	// atomically once fn returns nil, and discarded otherwise. Writes that don't fit
+	// into a transaction fail with ErrTxnTooBig. fn may be called more than once.
+	Batch(fn func(tx Txn) error) error
+
+	// Expire sets the TTL on keys of the bucket that don't expire yet.
+	Expire(id BucketID, ttl time.Duration) (int, error)
+	// Compact returns space of deleted and expired keys to the disk.
+	Compact() error
+
+	Stats() *StoreStats
 	Close() error
 }
 
@@ -44,26 +62,29 @@ func (b Bucket) WithRangeOptions(opt *RangeOptions) Bucket {
 	}
 }
 
-var (
-	BucketRecords   BucketID = 0x10
-	BucketBeatTicks BucketID = 0x11
-	BucketBeatInfos BucketID = 0x12
-)
-
 var NoKey = Bucket{}.NewKey(nil)
 
 func (b Bucket) NewKey(key []byte) *Key {
 	k := &Key{
 		Bucket: b,
+		size:   len(key),
 	}
 	copy(k.Key[:], key)
 	return k
 }
 
+// RangeOptions narrow a range of bucket keys. Range functions return options to continue
+// with if the range ended early because of the Limit or ErrRangeStop, nil if it's done.
 type RangeOptions struct {
 	Prefetch int
-	Offset   []byte
-	Limit    int
+	// Prefix limits the range to keys starting with it.
+	Prefix []byte
+	// Offset is the key to start at, usually the cursor returned by a previous range.
+	Offset []byte
+	// Skip is the number of keys skipped before fn is called.
+	Skip int
+	// Limit is the max number of keys fn is called for, zero means no limit.
+	Limit int
 }
 
 func NewBucket(id BucketID, opts ...*RangeOptions) Bucket {
@@ -80,13 +101,19 @@ type Key struct {
 	Bucket Bucket
 	Key    [26]byte
 	TTL    time.Duration
+
+	// size is the length of the original key, used to reject truncated keys.
+	size int
 }
 
+// NewKey builds a key of any bucket, keys of known buckets are better built with the
+// builders generated from the catalog, e.g. RecordsKey, which apply the key codec.
 func NewKey(bucket BucketID, key []byte) *Key {
 	k := &Key{
 		Bucket: Bucket{
 			ID: bucket,
 		},
+		size: len(key),
 	}
 	copy(k.Key[:], key)
 	return k
@@ -107,8 +134,14 @@ func (k *Key) Bytes() []byte {
 }
 
 func (k *Key) Unmarshal(buf []byte) *Key {
+	if len(buf) < 2 {
+		// too short to name a bucket, e.g. a corrupted key
+		k.Bucket.ID = 0
+		k.Key = [26]byte{}
+		return k
+	}
 	k.Bucket.ID = BucketID(binary.BigEndian.Uint16(buf[:2]))
-	copy(k.Key[:], buf)
+	copy(k.Key[:], buf[2:])
 	return k
 }
 
@@ -129,6 +162,47 @@ type KeyFunc func(k *Key) error
 type PeekFunc func(k *Key, v []byte) error
 type ModifyFunc func(k *Key, v []byte) ([]byte, error)
 
+// Names of state store backends.
+const (
+	BackendBadger = "badger"
+	BackendBolt   = "bolt"
+	BackendMemory = "memory"
+)
+
+// NewIndexedStore opens the state store using the named backend, the prefix is
+// ignored by the memory backend.
+func NewIndexedStore(backend, prefix string, opts ...storeOpt) (IndexedStore, error) {
+	switch backend {
+	case BackendBadger, "":
+		return NewIndexedStoreBadger(prefix, opts...)
+	case BackendBolt:
+		return NewIndexedStoreBolt(prefix, opts...)
+	case BackendMemory:
+		return NewIndexedStoreMemory(opts...), nil
+	default:
+		return nil, fmt.Errorf("unknown state backend: %s", backend)
+	}
+}
+
 func NewIndexedStoreBadger(prefix string, opts ...storeOpt) (IndexedStore, error) {
 	return newBadgerStore(prefix, opts...)
 }
+
+func NewIndexedStoreBolt(prefix string, opts ...storeOpt) (IndexedStore, error) {
+	return newBoltStore(prefix, opts...)
+}
+
+// NewIndexedStoreMemory returns a store that keeps the state in memory only.
+func NewIndexedStoreMemory(opts ...storeOpt) IndexedStore {
+	return newMemoryStore(opts...)
+}
+
+// CountKeys returns the number of keys in the bucket, values are not read.
+func CountKeys(ctx context.Context, s IndexedStore, id BucketID) (int, error) {
+	var count int
+	_, err := s.RangeKeys(ctx, NewBucket(id), func(k *Key) error {
+		count++
+		return nil
+	})
+	return count, err
+}
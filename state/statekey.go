@@ -0,0 +1,127 @@
+package state
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Values of encrypted buckets are sealed with a random data key kept in the state dir, wrapped
+// with a key derived from a secret of the node, its identity key. When the secret changes, e.g.
+// on a rotation of the identity, the data key is wrapped anew with RewrapDataKey, so sealed
+// values stay readable without rewriting them.
+
+// DataKeyFile is the name of the wrapped data key in the state dir.
+const DataKeyFile = "state.key"
+
+var ErrDataKeyWrap = errors.New("data key of the state store doesn't open with the secret of the node")
+
+// wrapKey derives the key that wraps the data key from the secret.
+func wrapKey(secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("atlant-go state data key"))
+	return mac.Sum(nil)
+}
+
+func dataKeyID(key []byte) []byte {
+	sum := sha256.Sum256(key)
+	return sum[:keyIDSize]
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadDataKey unwraps the data key kept in dir, a new key is made if there's none yet.
+func loadDataKey(dir string, secret []byte) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, DataKeyFile))
+	if os.IsNotExist(err) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		} else if err := writeDataKey(dir, key, secret); err != nil {
+			return nil, fmt.Errorf("failed to save the data key of the state store: %v", err)
+		}
+		return key, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return unwrapDataKey(data, secret)
+}
+
+func unwrapDataKey(data, secret []byte) ([]byte, error) {
+	aead, err := newAEAD(wrapKey(secret))
+	if err != nil {
+		return nil, err
+	} else if len(data) < aead.NonceSize() {
+		return nil, ErrDataKeyWrap
+	}
+	key, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(DataKeyFile))
+	if err != nil {
+		return nil, ErrDataKeyWrap
+	}
+	return key, nil
+}
+
+// writeDataKey wraps the key with the secret and replaces the key file atomically.
+func writeDataKey(dir string, key, secret []byte) error {
+	aead, err := newAEAD(wrapKey(secret))
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data := aead.Seal(nonce, nonce, key, []byte(DataKeyFile))
+	path := filepath.Join(dir, DataKeyFile)
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// RewrapDataKey wraps the data key of the state dir with the next secret instead of the
+// previous one. Dirs without a data key are left as they are.
+func RewrapDataKey(dir string, prev, next []byte) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, DataKeyFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	key, err := unwrapDataKey(data, prev)
+	if err != nil {
+		return err
+	}
+	return writeDataKey(dir, key, next)
+}
+
+// rewrapStore is implemented by backends that keep a data key.
+type rewrapStore interface {
+	rewrapDataKey(prev, next []byte) error
+}
+
+// RewrapStoreKey is RewrapDataKey of the dir of an open store, other backends than badger
+// keep no data key.
+func RewrapStoreKey(s IndexedStore, prev, next []byte) error {
+	if rs, ok := s.(rewrapStore); ok {
+		return rs.rewrapDataKey(prev, next)
+	}
+	return nil
+}
+
+func (s *badgerStore) rewrapDataKey(prev, next []byte) error {
+	return RewrapDataKey(s.dir, prev, next)
+}
@@ -0,0 +1,163 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+// Streams range a bucket from several goroutines for bulk work like exports. The bucket
+// is split into ranges of streamSplitSize keys by a keys-only pass, then workers read
+// values of the ranges in their own transactions. So unlike RangePeek, a stream is not
+// a single snapshot: keys written while it runs may or may not be seen.
+
+const streamSplitSize = 1024
+
+// streamStore is implemented by backends able to range a bucket in parallel.
+type streamStore interface {
+	RangeStream(ctx context.Context, b Bucket, workers int, fn PeekFunc) error
+}
+
+// Stream calls fn for every key of the bucket from up to workers goroutines, in no
+// particular order, so fn must be safe for concurrent use. Zero workers means one per CPU.
+// Backends unable to split ranges call fn sequentially. Returning ErrRangeStop from fn
+// stops the stream without an error, the stream ends with the context error once ctx is done.
+func Stream(ctx context.Context, s IndexedStore, b Bucket, workers int, fn PeekFunc) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if ss, ok := s.(streamStore); ok && workers > 1 {
+		return ss.RangeStream(ctx, b, workers, fn)
+	}
+	_, err := s.RangePeek(ctx, b, fn)
+	return err
+}
+
+type keyRange struct {
+	start []byte
+	// end is excluded, nil for the end of the bucket.
+	end []byte
+}
+
+func (s *badgerStore) RangeStream(ctx context.Context, b Bucket, workers int, fn PeekFunc) error {
+	ranges, err := s.splitBucket(ctx, b)
+	if err != nil {
+		return err
+	}
+	rangeC := make(chan keyRange, len(ranges))
+	for _, r := range ranges {
+		rangeC <- r
+	}
+	close(rangeC)
+
+	var (
+		wg      sync.WaitGroup
+		errMux  sync.Mutex
+		lastErr error
+		stop    = make(chan struct{})
+		stopped bool
+	)
+	halt := func(err error) {
+		errMux.Lock()
+		defer errMux.Unlock()
+		if err != ErrRangeStop && lastErr == nil {
+			lastErr = err
+		}
+		if !stopped {
+			stopped = true
+			close(stop)
+		}
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range rangeC {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := s.rangeValues(ctx, b, r, stop, fn); err != nil {
+					halt(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return lastErr
+}
+
+// splitBucket walks keys of the bucket and cuts them into ranges of streamSplitSize keys.
+func (s *badgerStore) splitBucket(ctx context.Context, b Bucket) ([]keyRange, error) {
+	prefix := b.ID.Bytes()
+	var ranges []keyRange
+	err := s.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		start := b.NewKey(nil).Bytes()
+		var n int
+		for it.Seek(start); it.ValidForPrefix(prefix); it.Next() {
+			if n++; n%streamSplitSize == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				end := append([]byte(nil), it.Item().Key()...)
+				ranges = append(ranges, keyRange{
+					start: start,
+					end:   end,
+				})
+				start = end
+			}
+		}
+		ranges = append(ranges, keyRange{
+			start: start,
+		})
+		return nil
+	})
+	return ranges, err
+}
+
+func (s *badgerStore) rangeValues(ctx context.Context, b Bucket, r keyRange, stop <-chan struct{}, fn PeekFunc) error {
+	prefix := b.ID.Bytes()
+	return s.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchSize = 100
+		if b.RangeOptions.Prefetch > 0 {
+			opts.PrefetchSize = b.RangeOptions.Prefetch
+		}
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(r.start); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			if r.end != nil && bytes.Compare(item.Key(), r.end) >= 0 {
+				return nil
+			}
+			select {
+			case <-stop:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			v, err := s.openItem(item)
+			if err != nil && (isCorruption(err) || IsCorrupted(err)) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if err := fn((&Key{}).Unmarshal(item.Key()), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
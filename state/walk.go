@@ -0,0 +1,101 @@
+package state
+
+import (
+	"bytes"
+	"context"
+)
+
+type rangeStep int
+
+const (
+	rangeVisit rangeStep = iota
+	rangeSkip
+	rangeDone
+)
+
+// rangeCheckInterval is the number of keys between checks of the range context, so that
+// long ranges end soon after their context is done without paying for a check per key.
+const rangeCheckInterval = 256
+
+// rangeWalk applies RangeOptions of a bucket to keys in their order, so that backends
+// share the semantics of prefixes, offsets, limits and cancellation.
+type rangeWalk struct {
+	ctx     context.Context
+	b       Bucket
+	start   []byte
+	prefix  []byte
+	skipped int
+	visited int
+	stopped bool
+	cursor  []byte
+	steps   int
+	err     error
+}
+
+func newRangeWalk(ctx context.Context, b Bucket) *rangeWalk {
+	w := &rangeWalk{
+		ctx:    ctx,
+		b:      b,
+		prefix: append(b.ID.Bytes(), b.RangeOptions.Prefix...),
+	}
+	w.start = w.prefix
+	if offset := append(b.ID.Bytes(), b.RangeOptions.Offset...); bytes.Compare(offset, w.start) > 0 {
+		w.start = offset
+	}
+	return w
+}
+
+// prefetch returns the prefetch size of the options, or defaults if it's not set.
+func (w *rangeWalk) prefetch(defaults int) int {
+	if w.b.RangeOptions.Prefetch > 0 {
+		return w.b.RangeOptions.Prefetch
+	}
+	return defaults
+}
+
+// next tells what to do with the key: visit it, skip it, or end the range. If the range
+// ends before the key because of the limit or a stop, the key becomes the cursor. The range
+// also ends once its context is done, then result returns the context error.
+func (w *rangeWalk) next(key []byte) rangeStep {
+	if w.steps%rangeCheckInterval == 0 {
+		if err := w.ctx.Err(); err != nil {
+			w.err = err
+			return rangeDone
+		}
+	}
+	w.steps++
+	switch {
+	case bytes.Compare(key, w.start) < 0:
+		return rangeSkip
+	case !bytes.HasPrefix(key, w.prefix):
+		return rangeDone
+	case w.stopped, w.b.RangeOptions.Limit > 0 && w.visited >= w.b.RangeOptions.Limit:
+		w.cursor = append([]byte(nil), key[2:]...)
+		return rangeDone
+	case w.skipped < w.b.RangeOptions.Skip:
+		w.skipped++
+		return rangeSkip
+	}
+	w.visited++
+	return rangeVisit
+}
+
+// stop ends the range at the next key, fn has returned ErrRangeStop.
+func (w *rangeWalk) stop() {
+	w.stopped = true
+}
+
+// result returns the options to continue the range with, nil if it's done, and the error
+// the range ended with: err of the backend or the context error.
+func (w *rangeWalk) result(err error) (*RangeOptions, error) {
+	if err == nil {
+		err = w.err
+	}
+	if err != nil || w.cursor == nil {
+		return nil, err
+	}
+	opts := w.b.RangeOptions
+	opts.Offset = w.cursor
+	opts.Skip = 0
+	return &opts, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+// openStateStore opens the state store of the stopped node for commands, with the same
+// checksums and encryption the node runs with.
+func openStateStore() (state.IndexedStore, error) {
+	buckets := trimList(*stateEncryptBuckets)
+	var secret []byte
+	if len(buckets) > 0 {
+		id, err := fs.ReadIdentity(*fsDir)
+		if err != nil {
+			return nil, fmt.Errorf("encryption of the state store needs the node identity: %v", err)
+		}
+		secret = id.PrivKey
+	}
+	return state.NewIndexedStore(*stateBackend, *stateDir,
+		state.ChecksumsOpt(toBool(*stateChecksums)),
+		state.EncryptionOpt(secret, buckets...),
+	)
+}
+
+func stateCmd(c *cli.Cmd) {
+	c.Command("verify", "Check every value of the state store against its checksum, the node must be stopped.", stateVerifyCmd)
+}
+
+func stateVerifyCmd(c *cli.Cmd) {
+	c.Spec = "[--json]"
+	asJSON := c.Bool(cli.BoolOpt{
+		Name:  "json",
+		Desc:  "Print the report as JSON.",
+		Value: false,
+	})
+	c.Action = func() {
+		if _, err := readPrivateAPIFile(); err == nil {
+			log.Warningln("node seems to be running, stop it before verifying the state store")
+		}
+		store, err := openStateStore()
+		if err != nil {
+			log.Fatalln("failed to open the state store:", err)
+		}
+		defer store.Close()
+		report, err := state.Verify(context.Background(), store)
+		if err != nil {
+			store.Close()
+			log.Fatalln(err)
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(report)
+		} else {
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "BUCKET\tKEYS\tVERIFIED\tSEALED\tLEGACY\tWRONG KEY\tCORRUPTED")
+			for _, b := range report.Buckets {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n",
+					b.Bucket, b.Keys, b.Verified, b.Sealed, b.Legacy, b.WrongKey, b.Corrupted)
+			}
+			w.Flush()
+			for _, k := range report.Damaged {
+				fmt.Printf("corrupted: %s %s: %s\n", k.Bucket, k.Key, k.Error)
+			}
+			fmt.Printf("%d keys verified in %s\n", report.Keys, report.Duration)
+		}
+		if report.Corrupted > 0 || report.WrongKey > 0 {
+			store.Close()
+			log.Fatalf("state store has %d corrupted values and %d sealed with another key",
+				report.Corrupted, report.WrongKey)
+		}
+	}
+}
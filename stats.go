@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/rs"
+)
+
+func statsCmd(c *cli.Cmd) {
+	c.Spec = "[--node] [--json]"
+	node := c.String(cli.StringOpt{
+		Name: "node",
+		Desc: "Show usage of a single node with its sessions.",
+	})
+	asJSON := c.Bool(cli.BoolOpt{
+		Name:  "json",
+		Desc:  "Print the usage as JSON.",
+		Value: false,
+	})
+	c.Action = func() {
+		info, err := readPrivateAPIFile()
+		if err != nil {
+			log.Fatalln("failed to find the running node:", err)
+		}
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/private/v1/usage?node=%s",
+			info.Addr, url.QueryEscape(*node)), nil)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+info.Token)
+		resp, err := (&http.Client{Timeout: time.Minute}).Do(req)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024*1024))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		var usage []*rs.NodeUsage
+		if resp.StatusCode != 200 {
+			log.Fatalf("node responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		} else if err := json.Unmarshal(body, &usage); err != nil {
+			log.Fatalln("failed to read usage:", err)
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(usage)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NODE\tETH ADDRESS\tUPTIME\tSERVED\tPINNED\tSESSIONS\tREPORTED")
+		for _, n := range usage {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n", n.NodeID, n.EthereumAddr,
+				time.Duration(n.Uptime)*time.Second, n.BytesServed, n.BytesPinned,
+				len(n.Sessions), n.ReportedAt.Format(time.RFC3339))
+			if len(*node) == 0 {
+				continue
+			}
+			for _, s := range n.Sessions {
+				fmt.Fprintf(w, "  %s\t%s\t%s\t%d\t%d\t\t%s\n", s.SessionID, s.EthereumAddr,
+					time.Duration(s.Uptime)*time.Second, s.BytesServed, s.BytesPinned,
+					s.ReportedAt.Format(time.RFC3339))
+			}
+		}
+		w.Flush()
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+	"github.com/xlab/closer"
+
+	"github.com/AtlantPlatform/atlant-go/api"
+)
+
+// privateAPIFile is written to the state dir of a running node, so local tools
+// can find its private API.
+const privateAPIFile = "private-api.json"
+
+// privateTokenFile keeps the bearer token of the private API in the state dir.
+const privateTokenFile = "private-api.token"
+
+type privateAPIInfo struct {
+	Addr string `json:"addr"`
+	// Token is read from the token file, it's not stored with the address.
+	Token string `json:"-"`
+}
+
+func privateTokenPath() string {
+	return filepath.Join(*stateDir, privateTokenFile)
+}
+
+func writePrivateAPIFile(addr string) {
+	path := filepath.Join(*stateDir, privateAPIFile)
+	data, _ := json.Marshal(&privateAPIInfo{
+		Addr: addr,
+	})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		log.Warningln("failed to write private API file:", err)
+		return
+	}
+	closer.Bind(func() {
+		os.Remove(path)
+	})
+}
+
+var sensitiveConfigNames = []string{"token", "key", "secret", "password", "passphrase"}
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitiveConfigNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedConfig returns command line args and AN_* environment of the node,
+// with values of tokens, keys and passwords replaced.
+func redactedConfig() map[string]interface{} {
+	args := make([]string, 0, len(os.Args))
+	var redactNext bool
+	for _, arg := range os.Args {
+		if redactNext {
+			args = append(args, "<redacted>")
+			redactNext = false
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") || !isSensitive(arg) {
+			args = append(args, arg)
+			continue
+		}
+		if parts := strings.SplitN(arg, "=", 2); len(parts) == 2 {
+			args = append(args, parts[0]+"=<redacted>")
+			continue
+		}
+		args = append(args, arg)
+		redactNext = true
+	}
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "AN_") {
+			continue
+		}
+		if isSensitive(parts[0]) {
+			env[parts[0]] = "<redacted>"
+			continue
+		}
+		env[parts[0]] = parts[1]
+	}
+	return map[string]interface{}{
+		"args": args,
+		"env":  env,
+	}
+}
+
+func supportBundleCmd(c *cli.Cmd) {
+	out := c.String(cli.StringOpt{
+		Name:  "o out",
+		Desc:  "Output file of the bundle.",
+		Value: fmt.Sprintf("atlant-support-%s.tar.gz", time.Now().UTC().Format("20060102-150405")),
+	})
+	logWindow := c.String(cli.StringOpt{
+		Name:  "logs",
+		Desc:  "Include logs of the last period.",
+		Value: "72h",
+	})
+	c.Action = func() {
+		window := duration(*logWindow, 72*time.Hour)
+		f, err := os.OpenFile(*out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatalln("failed to create bundle file:", err)
+		}
+		defer f.Close()
+		err = fetchSupportBundle(f, window)
+		if err == nil {
+			log.Println("support bundle of the running node saved to", *out)
+			return
+		}
+		log.Warningln("failed to get bundle from the running node, creating an offline one:", err)
+		if err := f.Truncate(0); err != nil {
+			log.Fatalln(err)
+		} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+			log.Fatalln(err)
+		}
+		b := api.NewSupportBundle(f)
+		if err := b.AddJSON("config.json", redactedConfig()); err != nil {
+			log.Fatalln(err)
+		}
+		if len(*logDir) > 0 {
+			if err := b.AddLogs(*logDir, window); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		if err := b.AddRuntime(appVersion); err != nil {
+			log.Fatalln(err)
+		}
+		if err := b.Close(); err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("offline support bundle saved to", *out)
+	}
+}
+
+// readPrivateAPIFile returns the private API of the node running with the same state dir.
+func readPrivateAPIFile() (*privateAPIInfo, error) {
+	data, err := ioutil.ReadFile(filepath.Join(*stateDir, privateAPIFile))
+	if os.IsNotExist(err) {
+		return nil, errors.New("node is not running")
+	} else if err != nil {
+		return nil, err
+	}
+	var info privateAPIInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	token, err := ioutil.ReadFile(privateTokenPath())
+	if err != nil {
+		err = fmt.Errorf("failed to read private API token: %v", err)
+		return nil, err
+	}
+	info.Token = strings.TrimSpace(string(token))
+	return &info, nil
+}
+
+func fetchSupportBundle(w io.Writer, window time.Duration) error {
+	info, err := readPrivateAPIFile()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/private/v1/support/bundle?logs=%s", info.Addr, window), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+info.Token)
+	resp, err := (&http.Client{Timeout: 5 * time.Minute}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("node responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
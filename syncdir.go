@@ -0,0 +1,792 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/api"
+	"github.com/AtlantPlatform/atlant-go/client"
+)
+
+// sync-dir mirrors a local directory into records under a prefix over the public API of a node.
+// Changes are picked up by fsnotify and by a full rescan every interval, with --pull records
+// changed remotely are written back into the directory. The versions of the last sync are kept
+// in a state file of the directory, a file that changed on both sides since is a conflict.
+// Conflicts are resolved by the --conflicts policy: local keeps the local file, remote takes the
+// record and keep-both saves the record beside the file as <name>.conflict-<version>. Record
+// history keeps the versions that were overwritten either way.
+
+const (
+	syncStateFile    = ".atlant-sync.json"
+	syncIgnoreFile   = ".atlantignore"
+	syncDebounce     = time.Second
+	syncMaxConflicts = 100
+)
+
+const (
+	syncConflictsLocal    = "local"
+	syncConflictsRemote   = "remote"
+	syncConflictsKeepBoth = "keep-both"
+)
+
+// these are never synced
+var syncBuiltinIgnore = []string{
+	syncStateFile + "*",
+	"*.conflict-*",
+	".atlant-sync-*",
+}
+
+func syncDirCmd(c *cli.Cmd) {
+	c.Spec = "[--remote] [--pull] [--conflicts] [--ignore]... [--interval] [--status-addr] [--once] LOCAL PREFIX"
+	local := c.StringArg("LOCAL", "", "Local directory to mirror.")
+	prefix := c.StringArg("PREFIX", "", "Record prefix the directory is mirrored into, e.g. /photos.")
+	remote := c.String(cli.StringOpt{
+		Name:   "remote",
+		Desc:   "Address of the public API of the node, the web listen address is used if not set.",
+		EnvVar: "AN_SYNC_REMOTE",
+	})
+	pull := c.Bool(cli.BoolOpt{
+		Name:   "pull",
+		Desc:   "Write records changed remotely back into the directory.",
+		EnvVar: "AN_SYNC_PULL",
+		Value:  false,
+	})
+	conflicts := c.String(cli.StringOpt{
+		Name:   "conflicts",
+		Desc:   "Resolution of files changed on both sides: local, remote or keep-both.",
+		EnvVar: "AN_SYNC_CONFLICTS",
+		Value:  syncConflictsKeepBoth,
+	})
+	ignore := c.Strings(cli.StringsOpt{
+		Name:   "ignore",
+		Desc:   "Glob patterns of files and dirs to skip, matched against names and relative paths.",
+		EnvVar: "AN_SYNC_IGNORE",
+	})
+	interval := c.String(cli.StringOpt{
+		Name:   "interval",
+		Desc:   "Interval of full rescans, and of remote checks with --pull.",
+		EnvVar: "AN_SYNC_INTERVAL",
+		Value:  "30s",
+	})
+	statusAddr := c.String(cli.StringOpt{
+		Name:   "status-addr",
+		Desc:   "Listen address of the sync status API, empty disables it.",
+		EnvVar: "AN_SYNC_STATUS_ADDR",
+		Value:  "127.0.0.1:33790",
+	})
+	once := c.Bool(cli.BoolOpt{
+		Name:  "once",
+		Desc:  "Sync once and exit.",
+		Value: false,
+	})
+	c.Action = func() {
+		switch *conflicts {
+		case syncConflictsLocal, syncConflictsRemote, syncConflictsKeepBoth:
+		default:
+			log.Fatalln("unknown conflict policy:", *conflicts)
+		}
+		root, err := filepath.Abs(*local)
+		if err != nil {
+			log.Fatalln(err)
+		} else if info, err := os.Stat(root); err != nil {
+			log.Fatalln(err)
+		} else if !info.IsDir() {
+			log.Fatalln("not a directory:", root)
+		}
+		p := "/" + strings.Trim(*prefix, "/")
+		if p == "/" {
+			log.Fatalln("prefix must not be the root")
+		}
+		addr := *remote
+		if len(addr) == 0 {
+			addr = localAddr(webListenAddrs())
+			if len(addr) == 0 {
+				log.Fatalln("the public API has no TCP listen address, please set --remote")
+			}
+		}
+		s := &dirSyncer{
+			root:      root,
+			prefix:    p,
+			pull:      *pull,
+			conflicts: *conflicts,
+			ignore:    *ignore,
+			cli:       client.New(addr, 10*time.Minute),
+			mux:       new(sync.Mutex),
+			status: &SyncDirStatus{
+				Local:     root,
+				Prefix:    p,
+				Remote:    addr,
+				Pull:      *pull,
+				Conflicts: []*SyncConflict{},
+			},
+		}
+		if err := s.loadState(); err != nil {
+			log.Fatalln("failed to load sync state:", err)
+		}
+		ctx, cancelFn := context.WithCancel(context.Background())
+		defer cancelFn()
+		if *once {
+			if err := s.sync(ctx); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+		if len(*statusAddr) > 0 {
+			go s.serveStatus(*statusAddr)
+		}
+		go func() {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+			<-sig
+			cancelFn()
+		}()
+		s.run(ctx, duration(*interval, 30*time.Second))
+	}
+}
+
+// localAddr turns a listen address into one to connect to.
+// localAddr returns the URL of the first TCP address of the public API.
+func localAddr(listenAddrs []string) string {
+	for _, addr := range listenAddrs {
+		a, err := api.ParseListenAddr(addr)
+		if err != nil || a.Network == "unix" {
+			continue
+		}
+		host, port, _ := net.SplitHostPort(a.Address)
+		switch host {
+		case "", "0.0.0.0", "::":
+			host = "localhost"
+		}
+		return "http://" + net.JoinHostPort(host, port)
+	}
+	return ""
+}
+
+type SyncDirStatus struct {
+	Local     string          `json:"local"`
+	Prefix    string          `json:"prefix"`
+	Remote    string          `json:"remote"`
+	Pull      bool            `json:"pull"`
+	Files     int             `json:"files"`
+	Pushed    uint64          `json:"pushed_total"`
+	Pulled    uint64          `json:"pulled_total"`
+	Deleted   uint64          `json:"deleted_total"`
+	Syncing   bool            `json:"syncing"`
+	LastSync  time.Time       `json:"last_sync"`
+	LastError string          `json:"last_error,omitempty"`
+	Conflicts []*SyncConflict `json:"conflicts"`
+}
+
+// SyncConflict is a file that changed locally and remotely since the last sync.
+type SyncConflict struct {
+	Path       string    `json:"path"`
+	Version    string    `json:"cid"`
+	Resolution string    `json:"resolution"`
+	Copy       string    `json:"copy,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// syncedFile is a file as of its last sync.
+type syncedFile struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	SHA256  string `json:"sha256"`
+	ID      string `json:"id"`
+	Version string `json:"cid"`
+}
+
+type syncState struct {
+	Prefix string                 `json:"prefix"`
+	Files  map[string]*syncedFile `json:"files"`
+}
+
+type dirSyncer struct {
+	root      string
+	prefix    string
+	pull      bool
+	conflicts string
+	ignore    []string
+	cli       *client.Client
+
+	// state is accessed by the sync loop only
+	state *syncState
+
+	mux    *sync.Mutex
+	status *SyncDirStatus
+}
+
+func (s *dirSyncer) loadState() error {
+	s.state = &syncState{
+		Prefix: s.prefix,
+		Files:  make(map[string]*syncedFile),
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.root, syncStateFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var st syncState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	} else if st.Prefix != s.prefix {
+		// the directory was mirrored elsewhere, versions of that prefix are of no use
+		log.WithField("prefix", st.Prefix).Warningln("sync state is of another prefix, starting over")
+		return nil
+	}
+	if st.Files != nil {
+		s.state.Files = st.Files
+	}
+	return nil
+}
+
+func (s *dirSyncer) saveState() error {
+	data, err := json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.root, syncStateFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.root, syncStateFile))
+}
+
+// ignorePatterns returns patterns of the command line and of the ignore file,
+// the file is re-read on each pass so that edits apply right away.
+func (s *dirSyncer) ignorePatterns() []string {
+	patterns := append([]string{}, syncBuiltinIgnore...)
+	patterns = append(patterns, s.ignore...)
+	data, err := ioutil.ReadFile(filepath.Join(s.root, syncIgnoreFile))
+	if err != nil {
+		return patterns
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, strings.TrimSuffix(line, "/"))
+	}
+	return patterns
+}
+
+func isIgnored(patterns []string, rel string) bool {
+	name := path.Base(rel)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		} else if ok, _ := path.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *dirSyncer) run(ctx context.Context, interval time.Duration) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Warningf("failed to watch the directory, changes are picked up every %s: %v", interval, err)
+	} else {
+		defer watcher.Close()
+		s.watchDir(watcher, s.root)
+	}
+	var events chan fsnotify.Event
+	var watchErrs chan error
+	if watcher != nil {
+		events, watchErrs = watcher.Events, watcher.Errors
+	}
+	debounce := time.NewTimer(0)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-events:
+			rel, err := filepath.Rel(s.root, ev.Name)
+			if err != nil || isIgnored(s.ignorePatterns(), filepath.ToSlash(rel)) {
+				continue
+			}
+			if ev.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					s.watchDir(watcher, ev.Name)
+				}
+			}
+			// bursts of events, e.g. of a file being written, are synced once
+			debounce.Reset(syncDebounce)
+		case err := <-watchErrs:
+			log.Warningf("directory watch failed: %v", err)
+		case <-debounce.C:
+			s.syncAndLog(ctx)
+		case <-t.C:
+			s.syncAndLog(ctx)
+		}
+	}
+}
+
+// watchDir watches the dir with its subdirs, fsnotify doesn't watch recursively.
+func (s *dirSyncer) watchDir(watcher *fsnotify.Watcher, dir string) {
+	patterns := s.ignorePatterns()
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if rel, _ := filepath.Rel(s.root, p); rel != "." && isIgnored(patterns, filepath.ToSlash(rel)) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(p); err != nil {
+			log.WithField("dir", p).Warningf("failed to watch dir: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s *dirSyncer) syncAndLog(ctx context.Context) {
+	if err := s.sync(ctx); err != nil {
+		log.Warningf("failed to sync %s: %v", s.root, err)
+	}
+}
+
+// sync runs a pass: records changed remotely are pulled first if enabled,
+// then local changes are pushed.
+func (s *dirSyncer) sync(ctx context.Context) (err error) {
+	s.mux.Lock()
+	s.status.Syncing = true
+	s.mux.Unlock()
+	defer func() {
+		if saveErr := s.saveState(); saveErr != nil && err == nil {
+			err = fmt.Errorf("failed to save sync state: %v", saveErr)
+		}
+		s.mux.Lock()
+		s.status.Syncing = false
+		s.status.Files = len(s.state.Files)
+		s.status.LastSync = time.Now().UTC()
+		s.status.LastError = ""
+		if err != nil {
+			s.status.LastError = err.Error()
+		}
+		s.mux.Unlock()
+	}()
+	patterns := s.ignorePatterns()
+	if s.pull {
+		if err := s.pullChanges(ctx, patterns); err != nil {
+			return err
+		}
+	}
+	return s.pushChanges(ctx, patterns)
+}
+
+func (s *dirSyncer) remotePath(rel string) string {
+	return path.Join(s.prefix, rel)
+}
+
+func (s *dirSyncer) localPath(rel string) string {
+	return filepath.Join(s.root, filepath.FromSlash(rel))
+}
+
+// localChanged tells whether the file differs from its last sync, the content is hashed
+// only if the size or the modification time changed.
+func (s *dirSyncer) localChanged(rel string, info os.FileInfo) (bool, string, error) {
+	f := s.state.Files[rel]
+	if f != nil && f.Size == info.Size() && f.ModTime == info.ModTime().UnixNano() {
+		return false, f.SHA256, nil
+	}
+	sum, err := fileSHA256(s.localPath(rel))
+	if err != nil {
+		return false, "", err
+	}
+	if f != nil && f.SHA256 == sum {
+		f.ModTime = info.ModTime().UnixNano()
+		return false, sum, nil
+	}
+	return true, sum, nil
+}
+
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *dirSyncer) pushChanges(ctx context.Context, patterns []string) error {
+	seen := make(map[string]bool)
+	err := filepath.Walk(s.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil || rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if isIgnored(patterns, rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if !info.Mode().IsRegular() {
+			return nil
+		}
+		seen[rel] = true
+		changed, sum, err := s.localChanged(rel, info)
+		if err != nil {
+			log.WithField("file", rel).Warningf("failed to read file: %v", err)
+			return nil
+		} else if !changed {
+			return nil
+		}
+		return s.pushFile(ctx, rel, sum)
+	})
+	if err != nil {
+		return err
+	}
+	for rel, f := range s.state.Files {
+		if seen[rel] || isIgnored(patterns, rel) {
+			continue
+		}
+		if err := s.pushDelete(ctx, rel, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushFile uploads a changed file, unless the record has changed since the last sync too.
+func (s *dirSyncer) pushFile(ctx context.Context, rel, sum string) error {
+	meta, err := s.cli.Meta(ctx, s.remotePath(rel), "")
+	if err == client.ErrNotFound {
+		meta = nil
+	} else if err != nil {
+		return err
+	}
+	if meta != nil && !meta.IsDeleted {
+		f := s.state.Files[rel]
+		if f == nil && s.pull {
+			if same, err := s.adoptIfSame(ctx, rel, meta, sum); err != nil || same {
+				return err
+			}
+			return s.resolveConflict(ctx, rel, meta)
+		} else if f != nil && f.Version != meta.Version {
+			return s.resolveConflict(ctx, rel, meta)
+		}
+	}
+	return s.upload(ctx, rel, sum)
+}
+
+func (s *dirSyncer) upload(ctx context.Context, rel, sum string) error {
+	file, err := os.Open(s.localPath(rel))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	meta, err := s.cli.Put(ctx, s.remotePath(rel), file, info.Size(), "")
+	if err != nil {
+		return fmt.Errorf("failed to push %s: %v", rel, err)
+	}
+	s.state.Files[rel] = &syncedFile{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		SHA256:  sum,
+		ID:      meta.ID,
+		Version: meta.Version,
+	}
+	s.mux.Lock()
+	s.status.Pushed++
+	s.mux.Unlock()
+	log.WithField("file", rel).Infoln("pushed")
+	return nil
+}
+
+// pushDelete deletes the record of a removed file, a record that changed since
+// the last sync is kept.
+func (s *dirSyncer) pushDelete(ctx context.Context, rel string, f *syncedFile) error {
+	meta, err := s.cli.Meta(ctx, s.remotePath(rel), "")
+	if err == client.ErrNotFound {
+		delete(s.state.Files, rel)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !meta.IsDeleted && meta.Version == f.Version {
+		if _, err := s.cli.Delete(ctx, f.ID); err != nil && err != client.ErrNotFound {
+			return fmt.Errorf("failed to delete %s: %v", rel, err)
+		}
+		s.mux.Lock()
+		s.status.Deleted++
+		s.mux.Unlock()
+		log.WithField("file", rel).Infoln("deleted record")
+	} else if !meta.IsDeleted {
+		log.WithField("file", rel).Warningln("record changed since the file was removed, keeping it")
+	}
+	delete(s.state.Files, rel)
+	return nil
+}
+
+// remoteFiles lists the records under the prefix, keyed by their relative paths.
+func (s *dirSyncer) remoteFiles(ctx context.Context, prefix string, files map[string]*client.ObjectMeta) error {
+	list, err := s.cli.ListAll(ctx, prefix)
+	if err == client.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, meta := range list.Files {
+		if meta.IsDeleted {
+			continue
+		}
+		files[strings.TrimPrefix(meta.Path, s.prefix+"/")] = meta
+	}
+	for _, dir := range list.Dirs {
+		if err := s.remoteFiles(ctx, dir, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *dirSyncer) pullChanges(ctx context.Context, patterns []string) error {
+	files := make(map[string]*client.ObjectMeta)
+	if err := s.remoteFiles(ctx, s.prefix, files); err != nil {
+		return fmt.Errorf("failed to list records: %v", err)
+	}
+	for rel, meta := range files {
+		if isIgnored(patterns, rel) {
+			continue
+		}
+		f := s.state.Files[rel]
+		if f != nil && f.Version == meta.Version {
+			continue
+		}
+		info, err := os.Stat(s.localPath(rel))
+		if os.IsNotExist(err) {
+			if err := s.download(ctx, rel, meta, s.localPath(rel)); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		changed, sum, err := s.localChanged(rel, info)
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			if same, err := s.adoptIfSame(ctx, rel, meta, sum); err != nil {
+				return err
+			} else if same {
+				continue
+			}
+		}
+		if f == nil || changed {
+			if err := s.resolveConflict(ctx, rel, meta); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.download(ctx, rel, meta, s.localPath(rel)); err != nil {
+			return err
+		}
+	}
+	// records deleted remotely, files changed since the last sync are kept and pushed again
+	for rel, f := range s.state.Files {
+		if _, ok := files[rel]; ok || isIgnored(patterns, rel) {
+			continue
+		}
+		info, err := os.Stat(s.localPath(rel))
+		if os.IsNotExist(err) {
+			delete(s.state.Files, rel)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if changed, _, err := s.localChanged(rel, info); err != nil {
+			return err
+		} else if changed {
+			continue
+		}
+		if err := os.Remove(s.localPath(rel)); err != nil {
+			return err
+		}
+		delete(s.state.Files, rel)
+		s.mux.Lock()
+		s.status.Deleted++
+		s.mux.Unlock()
+		log.WithField("file", rel).WithField("cid", f.Version).Infoln("removed file of a deleted record")
+	}
+	return nil
+}
+
+// download writes the record into name through a temp file, the sync state is updated
+// unless it's a conflict copy.
+func (s *dirSyncer) download(ctx context.Context, rel string, meta *client.ObjectMeta, name string) error {
+	body, _, err := s.cli.Get(ctx, meta.Path, meta.Version)
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %v", rel, err)
+	}
+	defer body.Close()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".atlant-sync-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to pull %s: %v", rel, err)
+	} else if err := tmp.Close(); err != nil {
+		return err
+	} else if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	if name != s.localPath(rel) {
+		return nil
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	s.state.Files[rel] = &syncedFile{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		SHA256:  hex.EncodeToString(h.Sum(nil)),
+		ID:      meta.ID,
+		Version: meta.Version,
+	}
+	s.mux.Lock()
+	s.status.Pulled++
+	s.mux.Unlock()
+	log.WithField("file", rel).Infoln("pulled")
+	return nil
+}
+
+// adoptIfSame takes the record as the synced version of a file that was never synced
+// if they have the same content, e.g. when a tree that was copied by hand is mirrored.
+func (s *dirSyncer) adoptIfSame(ctx context.Context, rel string, meta *client.ObjectMeta, sum string) (bool, error) {
+	info, err := os.Stat(s.localPath(rel))
+	if err != nil {
+		return false, err
+	} else if meta.Size > 0 && meta.Size != info.Size() {
+		return false, nil
+	}
+	body, _, err := s.cli.Get(ctx, meta.Path, meta.Version)
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return false, err
+	} else if hex.EncodeToString(h.Sum(nil)) != sum {
+		return false, nil
+	}
+	s.state.Files[rel] = &syncedFile{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		SHA256:  sum,
+		ID:      meta.ID,
+		Version: meta.Version,
+	}
+	return true, nil
+}
+
+func (s *dirSyncer) resolveConflict(ctx context.Context, rel string, meta *client.ObjectMeta) error {
+	conflict := &SyncConflict{
+		Path:       rel,
+		Version:    meta.Version,
+		Resolution: s.conflicts,
+		Time:       time.Now().UTC(),
+	}
+	switch s.conflicts {
+	case syncConflictsRemote:
+		if err := s.download(ctx, rel, meta, s.localPath(rel)); err != nil {
+			return err
+		}
+	case syncConflictsKeepBoth:
+		conflict.Copy = conflictCopyName(rel, meta.Version)
+		if err := s.download(ctx, rel, meta, s.localPath(conflict.Copy)); err != nil {
+			return err
+		}
+		fallthrough
+	case syncConflictsLocal:
+		sum, err := fileSHA256(s.localPath(rel))
+		if err != nil {
+			return err
+		} else if err := s.upload(ctx, rel, sum); err != nil {
+			return err
+		}
+	}
+	s.mux.Lock()
+	s.status.Conflicts = append(s.status.Conflicts, conflict)
+	if len(s.status.Conflicts) > syncMaxConflicts {
+		s.status.Conflicts = s.status.Conflicts[len(s.status.Conflicts)-syncMaxConflicts:]
+	}
+	s.mux.Unlock()
+	log.WithFields(log.Fields{
+		"file":       rel,
+		"cid":        meta.Version,
+		"resolution": s.conflicts,
+	}).Warningln("file changed locally and remotely")
+	return nil
+}
+
+// conflictCopyName returns e.g. docs/a.conflict-QmXyZ123.txt for docs/a.txt.
+func conflictCopyName(rel, version string) string {
+	if len(version) > 8 {
+		version = version[len(version)-8:]
+	}
+	ext := path.Ext(rel)
+	return strings.TrimSuffix(rel, ext) + ".conflict-" + version + ext
+}
+
+func (s *dirSyncer) serveStatus(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		s.mux.Lock()
+		data, err := json.Marshal(s.status)
+		s.mux.Unlock()
+		if err != nil {
+			http.Error(w, "error: "+err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+	log.Infoln("sync status is served at", "http://"+addr+"/status")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Warningf("failed to serve sync status: %v", err)
+	}
+}
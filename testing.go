@@ -19,13 +19,15 @@ import (
 	"github.com/AtlantPlatform/atlant-go/eth/contracts"
 	"github.com/AtlantPlatform/atlant-go/fs"
 	"github.com/AtlantPlatform/atlant-go/logging"
+	"github.com/AtlantPlatform/atlant-go/node"
+	"github.com/AtlantPlatform/atlant-go/plugins"
 )
 
 func init() {
 	// this init will be called only within builds that have `-tags testing`
 	defaultLogLevel = "4"
 
-	testingCommands = []testingCmd{{
+	testingCommands = []plugins.Command{{
 		Name: "test-ipfs-put",
 		Desc: "Test for IPFS API: Put object method",
 		Init: testIpfsPut,
@@ -130,7 +132,7 @@ func testIpfsPut(c *cli.Cmd) {
 	content := c.StringArg("CONTENT", "hello world!", "Content to store.")
 	c.Action = func() {
 		printInfo(meta)
-		runWithPlanetaryContext(func(ctx PlanetaryContext) {
+		runWithPlanetaryContext(func(ctx node.Context) {
 			log.Println("IPFS identity:", ctx.FileStore().NodeID())
 			ref := fs.ObjectRef{
 				ID:              *id,
@@ -158,7 +160,7 @@ func testIpfsGet(c *cli.Cmd) {
 	versionOffset := c.IntOpt("O offset", 0, "Specify version offset.")
 	c.Action = func() {
 		printInfo(meta)
-		runWithPlanetaryContext(func(ctx PlanetaryContext) {
+		runWithPlanetaryContext(func(ctx node.Context) {
 			log.Println("IPFS identity:", ctx.FileStore().NodeID())
 			ref := fs.ObjectRef{
 				ID:              *id,
@@ -192,7 +194,7 @@ func testIpfsList(c *cli.Cmd) {
 	versionOffset := c.IntOpt("O offset", 0, "Specify version offset.")
 	c.Action = func() {
 		printInfo(meta)
-		runWithPlanetaryContext(func(ctx PlanetaryContext) {
+		runWithPlanetaryContext(func(ctx node.Context) {
 			log.Println("IPFS identity:", ctx.FileStore().NodeID())
 			ref := fs.ObjectRef{
 				ID:              *id,
@@ -216,7 +218,7 @@ func testIpfsPubSub(c *cli.Cmd) {
 	meta := logging.WithFn()
 	c.Action = func() {
 		printInfo(meta)
-		runWithPlanetaryContext(func(ctx PlanetaryContext) {
+		runWithPlanetaryContext(func(ctx node.Context) {
 			log.Println("IPFS identity:", ctx.FileStore().NodeID())
 			ps, err := ctx.FileStore().PubSub()
 			if err != nil {
@@ -252,7 +254,7 @@ func testAuthority(c *cli.Cmd) {
 	meta := logging.WithFn()
 	c.Action = func() {
 		printInfo(meta)
-		runWithPlanetaryContext(func(ctx PlanetaryContext) {
+		runWithPlanetaryContext(func(ctx node.Context) {
 			dnsAuth := authority.GetDnsAuthorityInstance()
 			auth := authority.NewAuthority(dnsAuth)
 			nodeID := ctx.FileStore().NodeID()
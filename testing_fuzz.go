@@ -0,0 +1,443 @@
+//+build testing
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	capn "github.com/glycerine/go-capnproto"
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/api"
+	"github.com/AtlantPlatform/atlant-go/logging"
+	"github.com/AtlantPlatform/atlant-go/plugins"
+	"github.com/AtlantPlatform/atlant-go/proto"
+	"github.com/AtlantPlatform/atlant-go/rs"
+	"github.com/AtlantPlatform/atlant-go/state"
+)
+
+func init() {
+	// testing.go assigns the list in its init, which runs first
+	testingCommands = append(testingCommands, plugins.Command{
+		Name: "test-fuzz",
+		Desc: "Fuzz decoders of gossip envelopes, state keys and API requests",
+		Init: testFuzz,
+	})
+}
+
+// Targets are the fuzz entry points of the packages, they're go-fuzz compatible, so a
+// work dir of test-fuzz can be used with go-fuzz as is and the other way around:
+//
+//	fuzz/<target>/corpus    inputs to start from, seeds built in are added to them
+//	fuzz/<target>/crashers  inputs that crashed, with the panic in <name>.output
+//
+// Crashers are replayed before fuzzing, so the checked in ones guard against regressions.
+// Mutations are blind, without coverage, inputs the target accepts are kept to mutate further.
+
+var fuzzTargets = map[string]func([]byte) int{
+	"announce": proto.FuzzAnnounce,
+	"record":   proto.FuzzRecord,
+	"meta":     proto.FuzzObjectMeta,
+	"key":      state.FuzzKey,
+	"request":  api.FuzzRequest,
+}
+
+const (
+	fuzzCorpusMax = 4096
+	fuzzInputMax  = 64 * 1024
+)
+
+// fuzzTokens are spliced into inputs, mostly to get through the parsers of the API.
+var fuzzTokens = [][]byte{
+	[]byte("bytes="), []byte("bytes "), []byte("-"), []byte(","), []byte("/"), []byte("*"),
+	[]byte("W/"), []byte(`"`), []byte("\r\n"), []byte(": "), []byte("9223372036854775807"),
+	[]byte("-9223372036854775808"), []byte("Range: "), []byte("Content-Range: "),
+	[]byte("X-Meta-TTL: "), []byte("X-Meta-ExpiresAt: "), []byte("{\"paths\":["),
+}
+
+var fuzzInteresting = []uint64{
+	0, 1, 2, 0x7f, 0x80, 0xff, 0x100, 0x7fff, 0x8000, 0xffff,
+	0x7fffffff, 0x80000000, 0xffffffff, 1 << 62, 1<<63 - 1, 1 << 63, 1<<64 - 1,
+}
+
+func testFuzz(c *cli.Cmd) {
+	c.Spec = "[OPTIONS] [TARGET...]"
+	meta := logging.WithFn()
+	targets := c.StringsArg("TARGET", nil, "Targets to fuzz: announce, record, meta, key or request, all by default.")
+	workDir := c.StringOpt("dir", "fuzz", "Work dir with corpus and crashers of the targets.")
+	runFor := c.StringOpt("duration", "1m", "How long to fuzz each target, 0 only replays crashers.")
+	hangAfter := c.StringOpt("timeout", "10s", "An input running longer is reported as a hang.")
+	seed := c.IntOpt("seed", 0, "Seed of the mutator, the current time by default.")
+	c.Action = func() {
+		printInfo(meta)
+		names := *targets
+		if len(names) == 0 {
+			for name := range fuzzTargets {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		for _, name := range names {
+			if _, ok := fuzzTargets[name]; !ok {
+				log.Fatalf("unknown fuzz target: %s", name)
+			}
+		}
+		rndSeed := int64(*seed)
+		if rndSeed == 0 {
+			rndSeed = time.Now().UnixNano()
+		}
+		fmt.Printf("seed %d\n", rndSeed)
+		failed := false
+		for _, name := range names {
+			f := &fuzzer{
+				name:    name,
+				fn:      fuzzTargets[name],
+				dir:     filepath.Join(*workDir, name),
+				timeout: duration(*hangAfter, 10*time.Second),
+				rnd:     rand.New(rand.NewSource(rndSeed)),
+			}
+			if !f.run(duration(*runFor, time.Minute)) {
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	}
+}
+
+type fuzzer struct {
+	name    string
+	fn      func([]byte) int
+	dir     string
+	timeout time.Duration
+	rnd     *rand.Rand
+
+	corpus [][]byte
+	seen   map[string]struct{}
+	execs  int
+	found  int
+}
+
+// fuzzResult is the outcome of a single input, panic holds the panic with its stack.
+type fuzzResult struct {
+	ret   int
+	panic string
+}
+
+// exec runs the target over the input, a hang is reported as a panic.
+func (f *fuzzer) exec(data []byte) *fuzzResult {
+	f.execs++
+	resC := make(chan *fuzzResult, 1)
+	go func() {
+		res := new(fuzzResult)
+		defer func() {
+			if v := recover(); v != nil {
+				res.panic = fmt.Sprintf("panic: %v\n\n%s", v, debug.Stack())
+			}
+			resC <- res
+		}()
+		// the target may keep the input, it's mutated in place afterwards
+		res.ret = f.fn(append([]byte(nil), data...))
+	}()
+	select {
+	case res := <-resC:
+		return res
+	case <-time.After(f.timeout):
+		return &fuzzResult{
+			panic: fmt.Sprintf("hang: input ran for longer than %s", f.timeout),
+		}
+	}
+}
+
+// run replays crashers and fuzzes the target for the duration, it's false if any input crashed.
+func (f *fuzzer) run(d time.Duration) bool {
+	ok := f.replayCrashers()
+	if d <= 0 {
+		return ok
+	}
+	f.seen = make(map[string]struct{})
+	for _, data := range append(fuzzSeeds(f.name), f.readDir("corpus")...) {
+		f.keep(data)
+	}
+	if len(f.corpus) == 0 {
+		f.corpus = append(f.corpus, []byte{})
+	}
+	startedAt := time.Now()
+	for time.Since(startedAt) < d {
+		data := f.mutate(f.corpus[f.rnd.Intn(len(f.corpus))])
+		res := f.exec(data)
+		if len(res.panic) > 0 {
+			ok = false
+			f.found++
+			name := f.saveCrasher(data, res.panic)
+			fmt.Printf("CRASH %s %s: %s\n", f.name, name, firstLine(res.panic))
+			if strings.HasPrefix(res.panic, "hang:") {
+				// the goroutine can't be stopped, don't pile them up
+				break
+			}
+			continue
+		} else if res.ret > 0 {
+			f.keep(data)
+		}
+	}
+	fmt.Printf("fuzzed %s for %s: %d execs, corpus %d, crashers %d\n",
+		f.name, time.Since(startedAt).Round(time.Second), f.execs, len(f.corpus), f.found)
+	return ok
+}
+
+// replayCrashers runs crashers found before, they must not crash anymore.
+func (f *fuzzer) replayCrashers() bool {
+	ok := true
+	for _, name := range f.listDir("crashers") {
+		data, err := ioutil.ReadFile(filepath.Join(f.dir, "crashers", name))
+		if err != nil {
+			log.Warningf("failed to read crasher: %v", err)
+			continue
+		}
+		if res := f.exec(data); len(res.panic) > 0 {
+			ok = false
+			fmt.Printf("FAIL %s crasher %s: %s\n", f.name, name, firstLine(res.panic))
+			continue
+		}
+		fmt.Printf("PASS %s crasher %s\n", f.name, name)
+	}
+	return ok
+}
+
+func (f *fuzzer) keep(data []byte) {
+	sum := sha1.Sum(data)
+	id := string(sum[:])
+	if _, ok := f.seen[id]; ok {
+		return
+	}
+	f.seen[id] = struct{}{}
+	if len(f.corpus) < fuzzCorpusMax {
+		f.corpus = append(f.corpus, data)
+		return
+	}
+	f.corpus[f.rnd.Intn(len(f.corpus))] = data
+}
+
+func (f *fuzzer) saveCrasher(data []byte, output string) string {
+	sum := sha1.Sum(data)
+	name := hex.EncodeToString(sum[:])
+	dir := filepath.Join(f.dir, "crashers")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warningf("failed to save crasher: %v", err)
+		return name
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		log.Warningf("failed to save crasher: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".output"), []byte(output), 0644); err != nil {
+		log.Warningf("failed to save crasher output: %v", err)
+	}
+	return name
+}
+
+// listDir lists inputs in the subdir of the target, skipping outputs of go-fuzz and test-fuzz.
+func (f *fuzzer) listDir(sub string) []string {
+	infos, err := ioutil.ReadDir(filepath.Join(f.dir, sub))
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) == ".output" || filepath.Ext(name) == ".quoted" {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func (f *fuzzer) readDir(sub string) [][]byte {
+	var inputs [][]byte
+	for _, name := range f.listDir(sub) {
+		data, err := ioutil.ReadFile(filepath.Join(f.dir, sub, name))
+		if err != nil {
+			log.Warningf("failed to read corpus input: %v", err)
+			continue
+		}
+		inputs = append(inputs, data)
+	}
+	return inputs
+}
+
+// mutate returns a copy of the input with a few random mutations applied.
+func (f *fuzzer) mutate(data []byte) []byte {
+	data = append([]byte(nil), data...)
+	for n := 1 + f.rnd.Intn(4); n > 0; n-- {
+		switch f.rnd.Intn(8) {
+		case 0: // flip a bit
+			if len(data) > 0 {
+				data[f.rnd.Intn(len(data))] ^= 1 << uint(f.rnd.Intn(8))
+			}
+		case 1: // set a random byte
+			if len(data) > 0 {
+				data[f.rnd.Intn(len(data))] = byte(f.rnd.Intn(256))
+			}
+		case 2: // insert random bytes
+			pos := f.rnd.Intn(len(data) + 1)
+			ins := make([]byte, 1+f.rnd.Intn(8))
+			f.rnd.Read(ins)
+			data = append(data[:pos], append(ins, data[pos:]...)...)
+		case 3: // delete a range
+			if len(data) > 0 {
+				pos := f.rnd.Intn(len(data))
+				end := pos + 1 + f.rnd.Intn(len(data)-pos)
+				data = append(data[:pos], data[end:]...)
+			}
+		case 4: // duplicate a range
+			if len(data) > 0 {
+				pos := f.rnd.Intn(len(data))
+				end := pos + 1 + f.rnd.Intn(len(data)-pos)
+				dup := append([]byte(nil), data[pos:end]...)
+				at := f.rnd.Intn(len(data) + 1)
+				data = append(data[:at], append(dup, data[at:]...)...)
+			}
+		case 5: // overwrite with an interesting number
+			v := fuzzInteresting[f.rnd.Intn(len(fuzzInteresting))]
+			width := 1 << uint(f.rnd.Intn(4))
+			if len(data) >= width {
+				pos := f.rnd.Intn(len(data) - width + 1)
+				bigEndian := f.rnd.Intn(2) == 0
+				for i := 0; i < width; i++ {
+					shift := uint(8 * i)
+					if bigEndian {
+						shift = uint(8 * (width - 1 - i))
+					}
+					data[pos+i] = byte(v >> shift)
+				}
+			}
+		case 6: // insert a token
+			tok := fuzzTokens[f.rnd.Intn(len(fuzzTokens))]
+			pos := f.rnd.Intn(len(data) + 1)
+			data = append(data[:pos], append(append([]byte(nil), tok...), data[pos:]...)...)
+		case 7: // splice with another input
+			other := f.corpus[f.rnd.Intn(len(f.corpus))]
+			if len(other) > 0 && len(data) > 0 {
+				pos := f.rnd.Intn(len(data))
+				from := f.rnd.Intn(len(other))
+				data = append(data[:pos], other[from:]...)
+			}
+		}
+	}
+	if len(data) > fuzzInputMax {
+		data = data[:fuzzInputMax]
+	}
+	return data
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// fuzzSeeds builds valid inputs of the target to start mutating from.
+func fuzzSeeds(target string) [][]byte {
+	switch target {
+	case "announce":
+		return [][]byte{
+			packSegment(fuzzRecordAnnounce().Segment),
+			packSegment(fuzzBeatTickAnnounce().Segment),
+		}
+	case "record":
+		rec := proto.AutoNewRecord(capn.NewBuffer(nil))
+		rec.SetId(proto.NewID())
+		rec.SetPath("/fuzz/record.txt")
+		rec.SetCreatedAt(time.Now().UnixNano())
+		ver := proto.AutoNewRecordVersion(capn.NewBuffer(nil))
+		ver.SetVersion(proto.NewID())
+		ver.SetAnnounce(fuzzRecordAnnounce())
+		rec.SetCurrent(ver)
+		rec.SetPrevious(proto.NewRecordVersionListFrom([]proto.RecordVersion{ver}))
+		buf := new(bytes.Buffer)
+		if _, err := rec.Segment.WriteTo(buf); err != nil {
+			log.Fatalln(err)
+		}
+		return [][]byte{buf.Bytes()}
+	case "meta":
+		meta := proto.AutoNewObjectMeta(capn.NewBuffer(nil))
+		meta.SetId(proto.NewID())
+		meta.SetPath("/fuzz/record.txt")
+		meta.SetVersion(proto.NewID())
+		meta.SetSize(1024)
+		meta.SetCreatedAt(time.Now().UnixNano())
+		meta.SetExpiresAt(time.Now().Add(time.Hour).UnixNano())
+		return [][]byte{packSegment(meta.Segment)}
+	case "key":
+		var seeds [][]byte
+		for _, k := range []*state.Key{
+			state.RecordsKey([]byte(proto.NewID())),
+			state.CheckpointsKey("fuzz"),
+			state.ExpiriesKey(proto.NewID()),
+		} {
+			seeds = append(seeds, append([]byte{byte(len(k.Bytes()))}, k.Bytes()...))
+		}
+		seeds[1] = append(seeds[1], 0, 0, 0, 0, 0, 0, 0, 1)
+		seeds[2] = append(seeds[2], []byte(`{"path":"/fuzz/record.txt","expires_at":"2030-01-01T00:00:00Z"}`)...)
+		return seeds
+	case "request":
+		return [][]byte{
+			[]byte("GET /api/v1/content/fuzz/record.txt HTTP/1.1\r\nHost: localhost\r\n" +
+				"Range: bytes=0-99\r\nIf-None-Match: W/\"fuzz\", \"other\"\r\n\r\n"),
+			[]byte("PUT /api/v1/put/fuzz/record.txt HTTP/1.1\r\nHost: localhost\r\n" +
+				"X-Meta-TTL: 1h\r\nContent-Range: bytes 0-99/1024\r\nContent-Length: 0\r\n\r\n"),
+			[]byte("POST /api/v1/batch HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\n" +
+				"Content-Length: 36\r\n\r\n{\"paths\":[\"/a.txt\",\"/fuzz/b.txt\"]}\n"),
+		}
+	}
+	return nil
+}
+
+func fuzzRecordAnnounce() proto.Announce {
+	e := proto.AutoNewEnvelopeRecordUpdate(capn.NewBuffer(nil))
+	e.SetId(proto.NewID())
+	e.SetVersion(proto.NewID())
+	e.SetVersionPrev(proto.NewID())
+	return fuzzAnnounce(proto.ANNOUNCETYPE_RECORDUPDATE, packSegment(e.Segment))
+}
+
+func fuzzBeatTickAnnounce() proto.Announce {
+	e := proto.AutoNewEnvelopeBeatTick(capn.NewBuffer(nil))
+	e.SetId(proto.NewID())
+	e.SetSession(proto.NewID())
+	return fuzzAnnounce(proto.ANNOUNCETYPE_BEATTICK, packSegment(e.Segment))
+}
+
+func fuzzAnnounce(typ proto.AnnounceType, envelope []byte) proto.Announce {
+	a := proto.AutoNewAnnounce(capn.NewBuffer(nil))
+	a.SetId(proto.NewID())
+	a.SetType(typ)
+	a.SetEnvelope(envelope)
+	a.SetSignature(hex.EncodeToString(make([]byte, 64)))
+	a.SetTimestamp(time.Now().UnixNano())
+	a.SetNodeID("QmFuzz")
+	a.SetProtocolVersion(rs.ProtocolVersion)
+	return a
+}
+
+func packSegment(seg *capn.Segment) []byte {
+	buf := new(bytes.Buffer)
+	if _, err := seg.WriteToPacked(buf); err != nil {
+		log.Fatalf("failed to pack data: %v", err)
+	}
+	return buf.Bytes()
+}
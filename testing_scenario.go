@@ -0,0 +1,717 @@
+//+build testing
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/AtlantPlatform/atlant-go/authcenter"
+	"github.com/AtlantPlatform/atlant-go/client"
+	"github.com/AtlantPlatform/atlant-go/fs"
+	"github.com/AtlantPlatform/atlant-go/logging"
+	"github.com/AtlantPlatform/atlant-go/node"
+	"github.com/AtlantPlatform/atlant-go/plugins"
+)
+
+func init() {
+	// testing.go assigns the list in its init, which runs first
+	testingCommands = append(testingCommands, plugins.Command{
+		Name: "test-scenario",
+		Desc: "Run a scripted scenario of writes, partitions and restarts against in-process nodes",
+		Init: testScenario,
+	})
+}
+
+// A scenario file drives a swarm of nodes embedded into the process, see node.New:
+//
+//	name: partition-heal
+//	nodes: 3
+//	timeout: 1m
+//	steps:
+//	  - write: {node: 0, path: /a.txt, body: hello}
+//	  - expect: {path: /a.txt, body: hello}
+//	  - partition: [[0], [1, 2]]
+//	  - write: {node: 1, path: /b.txt, body: split}
+//	  - expect: {nodes: [0], path: /b.txt, absent: true, for: 10s}
+//	  - heal: true
+//	  - restart: [2]
+//	  - expect: {path: /b.txt, body: split}
+//
+// Paths are prefixed with the run ID, so repos reused via --dir don't see records of
+// previous runs. Expectations are polled until they hold or their timeout ends, absent
+// ones must hold for the whole duration.
+
+type scenarioFile struct {
+	Name  string `yaml:"name"`
+	Nodes int    `yaml:"nodes"`
+	// Writers are indexes of nodes the faucet grants write permissions, all nodes by default.
+	Writers []int           `yaml:"writers"`
+	Timeout string          `yaml:"timeout"`
+	Steps   []*scenarioStep `yaml:"steps"`
+}
+
+// scenarioStep has exactly one action set.
+type scenarioStep struct {
+	Write     *scenarioWrite  `yaml:"write"`
+	Delete    *scenarioWrite  `yaml:"delete"`
+	Expect    *scenarioExpect `yaml:"expect"`
+	Partition [][]int         `yaml:"partition"`
+	Heal      bool            `yaml:"heal"`
+	Stop      []int           `yaml:"stop"`
+	Start     []int           `yaml:"start"`
+	Restart   []int           `yaml:"restart"`
+	Sleep     string          `yaml:"sleep"`
+}
+
+type scenarioWrite struct {
+	Node int    `yaml:"node"`
+	Path string `yaml:"path"`
+	Body string `yaml:"body"`
+}
+
+type scenarioExpect struct {
+	// Nodes to check, all running nodes by default.
+	Nodes []int  `yaml:"nodes"`
+	Path  string `yaml:"path"`
+	Body  string `yaml:"body"`
+	// Deleted records may also be gone from the node entirely.
+	Deleted bool `yaml:"deleted"`
+	Absent  bool `yaml:"absent"`
+	// Converged requires the nodes to agree on the current version.
+	Converged bool `yaml:"converged"`
+	// Within limits polling, the timeout of the scenario by default.
+	Within string `yaml:"within"`
+	// For is how long an absent record must stay absent, 5s by default.
+	For string `yaml:"for"`
+}
+
+func (s *scenarioStep) String() string {
+	switch {
+	case s.Write != nil:
+		return fmt.Sprintf("write %s on node%d", s.Write.Path, s.Write.Node)
+	case s.Delete != nil:
+		return fmt.Sprintf("delete %s on node%d", s.Delete.Path, s.Delete.Node)
+	case s.Expect != nil:
+		return fmt.Sprintf("expect %s on %s", s.Expect.Path, nodeList(s.Expect.Nodes))
+	case len(s.Partition) > 0:
+		parts := make([]string, 0, len(s.Partition))
+		for _, group := range s.Partition {
+			parts = append(parts, nodeList(group))
+		}
+		return "partition " + strings.Join(parts, " | ")
+	case s.Heal:
+		return "heal"
+	case len(s.Stop) > 0:
+		return "stop " + nodeList(s.Stop)
+	case len(s.Start) > 0:
+		return "start " + nodeList(s.Start)
+	case len(s.Restart) > 0:
+		return "restart " + nodeList(s.Restart)
+	case len(s.Sleep) > 0:
+		return "sleep " + s.Sleep
+	}
+	return "unknown step"
+}
+
+func nodeList(list []int) string {
+	if len(list) == 0 {
+		return "all nodes"
+	}
+	names := make([]string, 0, len(list))
+	for _, i := range list {
+		names = append(names, fmt.Sprintf("node%d", i))
+	}
+	return strings.Join(names, ",")
+}
+
+func loadScenario(path string) (*scenarioFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sc := new(scenarioFile)
+	if err := yaml.UnmarshalStrict(data, sc); err != nil {
+		return nil, fmt.Errorf("malformed scenario %s: %v", path, err)
+	}
+	if len(sc.Name) == 0 {
+		sc.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if sc.Nodes < 2 {
+		return nil, errors.New("scenario needs at least 2 nodes")
+	}
+	checkNodes := func(list []int) error {
+		for _, i := range list {
+			if i < 0 || i >= sc.Nodes {
+				return fmt.Errorf("no node%d in the scenario", i)
+			}
+		}
+		return nil
+	}
+	if err := checkNodes(sc.Writers); err != nil {
+		return nil, err
+	}
+	for i, step := range sc.Steps {
+		var err error
+		switch {
+		case step.Write != nil:
+			err = checkNodes([]int{step.Write.Node})
+		case step.Delete != nil:
+			err = checkNodes([]int{step.Delete.Node})
+		case step.Expect != nil:
+			err = checkNodes(step.Expect.Nodes)
+		case len(step.Partition) > 0:
+			seen := make(map[int]bool)
+			for _, group := range step.Partition {
+				if err = checkNodes(group); err != nil {
+					break
+				}
+				for _, n := range group {
+					seen[n] = true
+				}
+			}
+			if err == nil && len(seen) != sc.Nodes {
+				err = errors.New("partition must place every node in a group")
+			}
+		case len(step.Stop) > 0:
+			err = checkNodes(step.Stop)
+		case len(step.Start) > 0:
+			err = checkNodes(step.Start)
+		case len(step.Restart) > 0:
+			err = checkNodes(step.Restart)
+		case step.Heal, len(step.Sleep) > 0:
+		default:
+			err = errors.New("no action")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %v", i+1, err)
+		}
+	}
+	return sc, nil
+}
+
+// scenarioNode is a node embedded into the process, it keeps its config across restarts.
+type scenarioNode struct {
+	Index     int
+	NodeID    string
+	cfg       *node.Config
+	node      *node.Node
+	client    *client.Client
+	blocklist string
+}
+
+func (n *scenarioNode) Name() string {
+	return fmt.Sprintf("node%d", n.Index)
+}
+
+func (n *scenarioNode) MultiAddr() string {
+	return "/ip4/" + strings.Replace(n.cfg.FSListenAddr, ":", "/tcp/", 1) + "/ipfs/" + n.NodeID
+}
+
+// scenarioFaucet grants write permissions to writers of the scenario, it stands in for
+// the DNS authority of the testnet.
+type scenarioFaucet struct {
+	keys []string
+}
+
+func (f *scenarioFaucet) Name() string {
+	return "faucet:test-scenario"
+}
+
+func (f *scenarioFaucet) Load(ctx context.Context) ([]authcenter.Entry, error) {
+	entries := make([]authcenter.Entry, 0, len(f.keys))
+	for _, key := range f.keys {
+		entries = append(entries, authcenter.Entry{
+			Key:         key,
+			Permissions: []authcenter.Permission{authcenter.RecordWritePermission},
+		})
+	}
+	return entries, nil
+}
+
+type scenarioStepResult struct {
+	Step     int    `json:"step"`
+	Action   string `json:"action"`
+	Status   string `json:"status"`
+	Duration string `json:"duration,omitempty"`
+	Details  string `json:"details,omitempty"`
+}
+
+type scenarioReport struct {
+	Scenario  string                `json:"scenario"`
+	Nodes     int                   `json:"nodes"`
+	StartedAt time.Time             `json:"started_at"`
+	Duration  string                `json:"duration"`
+	Passed    bool                  `json:"passed"`
+	Steps     []*scenarioStepResult `json:"steps"`
+}
+
+type scenarioRunner struct {
+	sc      *scenarioFile
+	nodes   []*scenarioNode
+	timeout time.Duration
+	prefix  string
+}
+
+func testScenario(c *cli.Cmd) {
+	c.Spec = "[OPTIONS] FILE"
+	meta := logging.WithFn()
+	file := c.StringArg("FILE", "", "Scenario file in YAML.")
+	workDir := c.StringOpt("dir", "", "Work dir of the nodes, a temp dir is used by default. Node repos found there are reused.")
+	reportFile := c.StringOpt("report", "", "Write the JSON report to this file.")
+	keep := c.BoolOpt("keep", false, "Keep the temp work dir after a successful run.")
+	c.Action = func() {
+		printInfo(meta)
+		sc, err := loadScenario(*file)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		dir := *workDir
+		if len(dir) == 0 {
+			tmp, err := ioutil.TempDir("", "atlant-scenario")
+			if err != nil {
+				log.Fatalln(err)
+			}
+			dir = tmp
+		}
+		r := &scenarioRunner{
+			sc:      sc,
+			timeout: duration(sc.Timeout, time.Minute),
+			prefix:  "/test-scenario/" + strconv.FormatInt(time.Now().Unix(), 36),
+		}
+		report := &scenarioReport{
+			Scenario:  sc.Name,
+			Nodes:     sc.Nodes,
+			StartedAt: time.Now(),
+		}
+		if err := r.start(dir); err != nil {
+			report.Steps = append(report.Steps, &scenarioStepResult{
+				Action:  "launch",
+				Status:  swarmFail,
+				Details: err.Error(),
+			})
+			fmt.Printf("FAIL launch: %v\n", err)
+		} else {
+			report.Steps = r.run()
+		}
+		r.stop()
+		report.Duration = time.Since(report.StartedAt).String()
+		report.Passed = true
+		for _, res := range report.Steps {
+			if res.Status != swarmPass {
+				report.Passed = false
+			}
+		}
+		if len(*reportFile) > 0 {
+			data, _ := json.MarshalIndent(report, "", "  ")
+			if err := ioutil.WriteFile(*reportFile, data, 0644); err != nil {
+				log.Errorln("failed to write report:", err)
+			}
+		}
+		if !report.Passed {
+			fmt.Printf("scenario %s failed, node repos are kept in %s\n", sc.Name, dir)
+			os.Exit(1)
+		}
+		fmt.Printf("scenario %s passed in %s\n", sc.Name, report.Duration)
+		if len(*workDir) == 0 && !*keep {
+			os.RemoveAll(dir)
+		}
+	}
+}
+
+// initScenarioRepo initializes the IPFS repo of a testnet node like atlant-go -T init does.
+func initScenarioRepo(fsDir string) error {
+	if fileNotEmpty(filepath.Join(fsDir, ipfsConfigFile)) {
+		return nil
+	}
+	if err := os.MkdirAll(fsDir, 0700); err != nil {
+		return err
+	}
+	keyData := []byte(ipfsKeyDataPrefix + *envTestnetKey)
+	if err := ioutil.WriteFile(filepath.Join(fsDir, ipfsKeyFile), keyData, 0600); err != nil {
+		return err
+	} else if err := ioutil.WriteFile(filepath.Join(fsDir, "testnet"), nil, 0600); err != nil {
+		return err
+	}
+	fileStore, err := fs.InitPlanetaryFileStore(fsDir)
+	if err != nil {
+		return err
+	}
+	return fileStore.Close()
+}
+
+func (r *scenarioRunner) start(dir string) error {
+	for i := 0; i < r.sc.Nodes; i++ {
+		n := &scenarioNode{
+			Index: i,
+		}
+		nodeDir := filepath.Join(dir, n.Name())
+		fsDir := filepath.Join(nodeDir, "fs")
+		if err := initScenarioRepo(fsDir); err != nil {
+			return fmt.Errorf("failed to init %s: %v", n.Name(), err)
+		}
+		nodeID, err := readPeerID(fsDir)
+		if err != nil {
+			return fmt.Errorf("failed to read identity of %s: %v", n.Name(), err)
+		}
+		n.NodeID = nodeID
+		// partitions are made by blocklisting peers, see partition
+		n.blocklist = filepath.Join(nodeDir, "blocklist.txt")
+		if err := ioutil.WriteFile(n.blocklist, nil, 0600); err != nil {
+			return err
+		}
+		fsPort, err := freePort()
+		if err != nil {
+			return err
+		}
+		webPort, err := freePort()
+		if err != nil {
+			return err
+		}
+		cfg := node.DefaultConfig()
+		cfg.Testnet = true
+		cfg.Version = appVersion
+		cfg.StateDir = filepath.Join(nodeDir, "state")
+		cfg.StateBackend = *stateBackend
+		cfg.FSDir = fsDir
+		cfg.FSListenAddr = fmt.Sprintf("127.0.0.1:%d", fsPort)
+		cfg.WebListenAddrs = []string{fmt.Sprintf("127.0.0.1:%d", webPort)}
+		cfg.LogDir = filepath.Join(nodeDir, "log")
+		cfg.Warmup = time.Second
+		cfg.Blocklist = []string{n.blocklist}
+		cfg.BlocklistRefresh = time.Second
+		// a node that fails stays down, the scenario reports it
+		cfg.Shutdown = func() {}
+		n.cfg = cfg
+		n.client = client.New(cfg.WebListenAddrs[0], 30*time.Second)
+		r.nodes = append(r.nodes, n)
+	}
+	faucet := new(scenarioFaucet)
+	for _, n := range r.nodes {
+		for _, peer := range r.nodes {
+			if peer != n {
+				n.cfg.BootstrapPeers = append(n.cfg.BootstrapPeers, peer.MultiAddr())
+			}
+		}
+		if len(r.sc.Writers) == 0 {
+			faucet.keys = append(faucet.keys, n.NodeID)
+		}
+	}
+	for _, i := range r.sc.Writers {
+		faucet.keys = append(faucet.keys, r.nodes[i].NodeID)
+	}
+	auth := authcenter.NewAuth(nil, []authcenter.Provider{faucet}, time.Minute)
+	authcenter.Init(auth)
+	if err := auth.Refresh(context.Background()); err != nil {
+		return fmt.Errorf("faucet failed: %v", err)
+	}
+	return r.startNodes(r.nodes)
+}
+
+// startNodes starts the nodes together, a node syncs with peers on start.
+func (r *scenarioRunner) startNodes(list []*scenarioNode) error {
+	errC := make(chan error, len(list))
+	for _, n := range list {
+		go func(n *scenarioNode) {
+			if n.node != nil {
+				errC <- fmt.Errorf("%s is running", n.Name())
+				return
+			}
+			nd, err := node.New(n.cfg)
+			if err != nil {
+				errC <- fmt.Errorf("failed to open %s: %v", n.Name(), err)
+				return
+			}
+			log.WithField("node", n.Name()).Infoln("starting", n.NodeID)
+			if err := nd.Start(); err != nil {
+				nd.Stop()
+				errC <- fmt.Errorf("failed to start %s: %v", n.Name(), err)
+				return
+			}
+			n.node = nd
+			errC <- nil
+		}(n)
+	}
+	var msgs []string
+	for range list {
+		if err := <-errC; err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "; "))
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), r.timeout)
+	defer cancelFn()
+	for _, n := range list {
+		if err := poll(ctx, func() error {
+			_, err := n.client.Ping(ctx)
+			return err
+		}); err != nil {
+			return fmt.Errorf("%s is not serving: %v", n.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (r *scenarioRunner) stopNodes(list []*scenarioNode) error {
+	var msgs []string
+	for _, n := range list {
+		if n.node == nil {
+			continue
+		}
+		if err := n.node.Stop(); err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", n.Name(), err))
+		}
+		n.node = nil
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+func (r *scenarioRunner) stop() {
+	if err := r.stopNodes(r.nodes); err != nil {
+		log.Warningln("failed to stop nodes:", err)
+	}
+}
+
+func (r *scenarioRunner) pick(list []int) []*scenarioNode {
+	if len(list) == 0 {
+		var running []*scenarioNode
+		for _, n := range r.nodes {
+			if n.node != nil {
+				running = append(running, n)
+			}
+		}
+		return running
+	}
+	nodes := make([]*scenarioNode, 0, len(list))
+	for _, i := range list {
+		nodes = append(nodes, r.nodes[i])
+	}
+	return nodes
+}
+
+func (r *scenarioRunner) run() []*scenarioStepResult {
+	var results []*scenarioStepResult
+	var failed bool
+	for i, step := range r.sc.Steps {
+		res := &scenarioStepResult{
+			Step:   i + 1,
+			Action: step.String(),
+		}
+		results = append(results, res)
+		if failed {
+			res.Status = swarmSkip
+			res.Details = "a previous step failed"
+			continue
+		}
+		startedAt := time.Now()
+		details, err := r.runStep(step)
+		res.Duration = time.Since(startedAt).String()
+		if err != nil {
+			res.Status = swarmFail
+			res.Details = err.Error()
+			failed = true
+			fmt.Printf("FAIL %d. %s: %v\n", res.Step, res.Action, err)
+			continue
+		}
+		res.Status = swarmPass
+		res.Details = details
+		fmt.Printf("PASS %d. %s (%s)\n", res.Step, res.Action, res.Duration)
+	}
+	return results
+}
+
+func (r *scenarioRunner) runStep(step *scenarioStep) (string, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), r.timeout)
+	defer cancelFn()
+	switch {
+	case step.Write != nil:
+		n := r.nodes[step.Write.Node]
+		path := r.prefix + step.Write.Path
+		body := step.Write.Body
+		meta, err := n.client.Put(ctx, path, strings.NewReader(body), int64(len(body)), "")
+		if err != nil {
+			return "", err
+		}
+		return "version " + meta.Version, nil
+	case step.Delete != nil:
+		n := r.nodes[step.Delete.Node]
+		meta, err := n.client.Meta(ctx, r.prefix+step.Delete.Path, "")
+		if err != nil {
+			return "", err
+		} else if _, err := n.client.Delete(ctx, meta.ID); err != nil {
+			return "", err
+		}
+		return "", nil
+	case step.Expect != nil:
+		return r.expect(step.Expect)
+	case len(step.Partition) > 0:
+		return "", r.partition(step.Partition)
+	case step.Heal:
+		return "", r.heal(ctx)
+	case len(step.Stop) > 0:
+		return "", r.stopNodes(r.pick(step.Stop))
+	case len(step.Start) > 0:
+		return "", r.startNodes(r.pick(step.Start))
+	case len(step.Restart) > 0:
+		list := r.pick(step.Restart)
+		if err := r.stopNodes(list); err != nil {
+			return "", err
+		}
+		return "", r.startNodes(list)
+	case len(step.Sleep) > 0:
+		time.Sleep(duration(step.Sleep, 0))
+		return "", nil
+	}
+	return "", errors.New("no action")
+}
+
+// partition blocklists nodes of other groups on every node and drops connections to them,
+// blocklists of the nodes are refreshed every second.
+func (r *scenarioRunner) partition(groups [][]int) error {
+	groupOf := make(map[int]int)
+	for g, group := range groups {
+		for _, i := range group {
+			groupOf[i] = g
+		}
+	}
+	for _, n := range r.nodes {
+		var blocked []string
+		for _, peer := range r.nodes {
+			if groupOf[peer.Index] != groupOf[n.Index] {
+				blocked = append(blocked, peer.NodeID)
+			}
+		}
+		data := []byte(strings.Join(blocked, "\n") + "\n")
+		if err := ioutil.WriteFile(n.blocklist, data, 0600); err != nil {
+			return err
+		}
+		if n.node == nil {
+			continue
+		}
+		for _, nodeID := range blocked {
+			if err := n.node.Context().FileStore().DisconnectPeer(nodeID); err != nil {
+				log.WithField("node", n.Name()).Warningln("failed to disconnect peer:", err)
+			}
+		}
+	}
+	// wait for the blocklists to be refreshed, so peers don't reconnect
+	time.Sleep(2 * time.Second)
+	return nil
+}
+
+// heal clears the blocklists and connects the running nodes with each other again.
+func (r *scenarioRunner) heal(ctx context.Context) error {
+	for _, n := range r.nodes {
+		if err := ioutil.WriteFile(n.blocklist, nil, 0600); err != nil {
+			return err
+		}
+	}
+	time.Sleep(2 * time.Second)
+	running := r.pick(nil)
+	for _, n := range running {
+		for _, peer := range running {
+			if peer == n {
+				continue
+			}
+			if _, err := n.node.Context().FileStore().ConnectPeer(ctx, peer.MultiAddr()); err != nil {
+				return fmt.Errorf("%s failed to connect %s: %v", n.Name(), peer.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *scenarioRunner) expect(e *scenarioExpect) (string, error) {
+	path := r.prefix + e.Path
+	nodes := r.pick(e.Nodes)
+	if e.Absent {
+		hold := duration(e.For, 5*time.Second)
+		deadline := time.Now().Add(hold)
+		for time.Now().Before(deadline) {
+			for _, n := range nodes {
+				_, err := n.client.Meta(context.Background(), path, "")
+				if err == nil {
+					return "", fmt.Errorf("%s has %s", n.Name(), e.Path)
+				} else if err != client.ErrNotFound {
+					return "", fmt.Errorf("%s: %v", n.Name(), err)
+				}
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+		return fmt.Sprintf("absent on %d nodes for %s", len(nodes), hold), nil
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), duration(e.Within, r.timeout))
+	defer cancelFn()
+	for _, n := range nodes {
+		if err := poll(ctx, func() error {
+			return r.check(ctx, n, path, e)
+		}); err != nil {
+			return "", fmt.Errorf("%s: %v", n.Name(), err)
+		}
+	}
+	if !e.Converged {
+		return "", nil
+	}
+	err := poll(ctx, func() error {
+		seen := make(map[string][]string)
+		for _, n := range nodes {
+			meta, err := n.client.Meta(ctx, path, "")
+			if err != nil {
+				return fmt.Errorf("%s: %v", n.Name(), err)
+			}
+			seen[meta.Version] = append(seen[meta.Version], n.Name())
+		}
+		if len(seen) > 1 {
+			return fmt.Errorf("nodes disagree on the current version: %v", seen)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d nodes converged", len(nodes)), nil
+}
+
+// check tells whether the record on the node is as expected.
+func (r *scenarioRunner) check(ctx context.Context, n *scenarioNode, path string, e *scenarioExpect) error {
+	if e.Deleted {
+		meta, err := n.client.Meta(ctx, path, "")
+		if err == client.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		} else if !meta.IsDeleted {
+			return fmt.Errorf("%s is not deleted", e.Path)
+		}
+		return nil
+	}
+	body, _, err := n.client.Get(ctx, path, "")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	} else if len(e.Body) > 0 && string(data) != e.Body {
+		return fmt.Errorf("content of %s is %q", e.Path, data)
+	}
+	return nil
+}
@@ -0,0 +1,700 @@
+//+build testing
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jawher/mow.cli"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/AtlantPlatform/atlant-go/client"
+	"github.com/AtlantPlatform/atlant-go/logging"
+	"github.com/AtlantPlatform/atlant-go/plugins"
+)
+
+func init() {
+	// testing.go assigns the list in its init, which runs first
+	testingCommands = append(testingCommands, plugins.Command{
+		Name: "test-swarm",
+		Desc: "Test replication of records across a swarm of local or containerized nodes",
+		Init: testSwarm,
+	})
+}
+
+type swarmNode struct {
+	Index   int
+	Dir     string
+	FSPort  int
+	WebPort int
+	NodeID  string
+	// Writer is set if the node has write permissions in the authority.
+	Writer bool
+
+	cmd       *exec.Cmd
+	exited    chan struct{}
+	container string
+	client    *client.Client
+}
+
+func (n *swarmNode) Name() string {
+	return fmt.Sprintf("node%d", n.Index)
+}
+
+func (n *swarmNode) MultiAddr() string {
+	return fmt.Sprintf("/ip4/127.0.0.1/tcp/%d/ipfs/%s", n.FSPort, n.NodeID)
+}
+
+// swarmLauncher runs nodes of the swarm, args are the node flags besides dirs.
+type swarmLauncher interface {
+	Init(n *swarmNode) error
+	Start(n *swarmNode, args []string) error
+	Stop(n *swarmNode) error
+}
+
+// processLauncher runs nodes as child processes of the current binary.
+type processLauncher struct {
+	exe string
+}
+
+func (p *processLauncher) dirArgs(n *swarmNode) []string {
+	return []string{
+		"-F", filepath.Join(n.Dir, "fs"),
+		"-S", filepath.Join(n.Dir, "state"),
+		"--log-dir", filepath.Join(n.Dir, "log"),
+	}
+}
+
+func (p *processLauncher) Init(n *swarmNode) error {
+	args := append([]string{"-T"}, p.dirArgs(n)...)
+	out, err := exec.Command(p.exe, append(args, "init")...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (p *processLauncher) Start(n *swarmNode, args []string) error {
+	out, err := os.Create(filepath.Join(n.Dir, "node.out"))
+	if err != nil {
+		return err
+	}
+	n.cmd = exec.Command(p.exe, append(p.dirArgs(n), args...)...)
+	n.cmd.Stdout = out
+	n.cmd.Stderr = out
+	if err := n.cmd.Start(); err != nil {
+		out.Close()
+		return err
+	}
+	n.exited = make(chan struct{})
+	go func() {
+		n.cmd.Wait()
+		out.Close()
+		close(n.exited)
+	}()
+	return nil
+}
+
+func (p *processLauncher) Stop(n *swarmNode) error {
+	if n.exited == nil {
+		return nil
+	}
+	if err := n.cmd.Process.Signal(os.Interrupt); err == nil {
+		select {
+		case <-n.exited:
+			return nil
+		case <-time.After(30 * time.Second):
+		}
+	}
+	if err := n.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	<-n.exited
+	return nil
+}
+
+// dockerLauncher runs nodes in containers of an image with atlant-go in PATH.
+// Containers use the host network, so nodes reach each other as local processes do.
+type dockerLauncher struct {
+	image string
+	runID string
+}
+
+func (d *dockerLauncher) dockerArgs(n *swarmNode) []string {
+	return []string{
+		"--network", "host",
+		"-v", n.Dir + ":/data",
+		"-e", "AN_TESTNET_KEY",
+		"--entrypoint", "atlant-go",
+		d.image,
+		"-F", "/data/fs",
+		"-S", "/data/state",
+		"--log-dir", "/data/log",
+	}
+}
+
+func (d *dockerLauncher) Init(n *swarmNode) error {
+	args := append([]string{"run", "--rm"}, d.dockerArgs(n)...)
+	out, err := exec.Command("docker", append(args, "-T", "init")...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (d *dockerLauncher) Start(n *swarmNode, args []string) error {
+	n.container = fmt.Sprintf("atlant-swarm-%s-%d", d.runID, n.Index)
+	runArgs := append([]string{"run", "-d", "--rm", "--name", n.container}, d.dockerArgs(n)...)
+	out, err := exec.Command("docker", append(runArgs, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (d *dockerLauncher) Stop(n *swarmNode) error {
+	if len(n.container) == 0 {
+		return nil
+	}
+	return exec.Command("docker", "stop", n.container).Run()
+}
+
+type swarmResult struct {
+	Scenario string `json:"scenario"`
+	Status   string `json:"status"`
+	Duration string `json:"duration"`
+	Details  string `json:"details,omitempty"`
+}
+
+const (
+	swarmPass = "pass"
+	swarmFail = "fail"
+	swarmSkip = "skip"
+)
+
+type swarmReport struct {
+	Nodes     int            `json:"nodes"`
+	Launcher  string         `json:"launcher"`
+	StartedAt time.Time      `json:"started_at"`
+	Duration  string         `json:"duration"`
+	Passed    bool           `json:"passed"`
+	Results   []*swarmResult `json:"results"`
+}
+
+// errSwarmSkip marks scenarios that can't run in the swarm, e.g. without writers.
+type errSwarmSkip string
+
+func (e errSwarmSkip) Error() string {
+	return string(e)
+}
+
+type swarmScenario struct {
+	Name string
+	Run  func(s *swarm, ctx context.Context) (string, error)
+}
+
+var swarmScenarios = []swarmScenario{
+	{"startup", (*swarm).checkStartup},
+	{"peering", (*swarm).checkPeering},
+	{"write-read-local", (*swarm).checkLocalWrites},
+	{"replication", (*swarm).checkReplication},
+	{"update-convergence", (*swarm).checkUpdateConvergence},
+	{"delete-propagation", (*swarm).checkDeletePropagation},
+}
+
+type swarm struct {
+	nodes    []*swarmNode
+	launcher swarmLauncher
+	timeout  time.Duration
+	records  int
+	prefix   string
+
+	// written are records created by the write-read-local scenario, by path.
+	written map[string]*swarmRecord
+}
+
+type swarmRecord struct {
+	Meta *client.ObjectMeta
+	Body string
+}
+
+func testSwarm(c *cli.Cmd) {
+	meta := logging.WithFn()
+	numNodes := c.IntOpt("n nodes", 3, "Number of nodes in the swarm.")
+	workDir := c.StringOpt("dir", "", "Work dir of the swarm, a temp dir is used by default. Node repos found there are reused.")
+	image := c.StringOpt("docker", "", "Run nodes in containers of this image instead of local processes.")
+	records := c.IntOpt("records", 10, "Number of records written by each writer node.")
+	timeout := c.StringOpt("timeout", "2m", "How long to wait for nodes to start and records to converge.")
+	reportFile := c.StringOpt("report", "", "Write the JSON report to this file instead of stdout.")
+	keep := c.BoolOpt("keep", false, "Keep the temp work dir after a successful run.")
+	c.Action = func() {
+		printInfo(meta)
+		dir := *workDir
+		if len(dir) == 0 {
+			tmp, err := ioutil.TempDir("", "atlant-swarm")
+			if err != nil {
+				log.Fatalln(err)
+			}
+			dir = tmp
+		}
+		runID := strconv.FormatInt(time.Now().Unix(), 36)
+		s := &swarm{
+			timeout: duration(*timeout, 2*time.Minute),
+			records: *records,
+			prefix:  "/test-swarm/" + runID,
+			written: make(map[string]*swarmRecord),
+		}
+		launcherName := "process"
+		if len(*image) > 0 {
+			launcherName = "docker"
+			s.launcher = &dockerLauncher{
+				image: *image,
+				runID: runID,
+			}
+		} else {
+			exe, err := os.Executable()
+			if err != nil {
+				log.Fatalln(err)
+			}
+			s.launcher = &processLauncher{
+				exe: exe,
+			}
+		}
+		report := &swarmReport{
+			Nodes:     *numNodes,
+			Launcher:  launcherName,
+			StartedAt: time.Now(),
+		}
+		if err := s.start(dir, *numNodes); err != nil {
+			report.Results = append(report.Results, &swarmResult{
+				Scenario: "launch",
+				Status:   swarmFail,
+				Details:  err.Error(),
+			})
+		} else {
+			report.Results = s.run()
+		}
+		s.stop()
+		report.Duration = time.Since(report.StartedAt).String()
+		report.Passed = len(report.Results) > 0
+		for _, r := range report.Results {
+			if r.Status != swarmPass {
+				report.Passed = false
+			}
+		}
+		data, _ := json.MarshalIndent(report, "", "  ")
+		if len(*reportFile) > 0 {
+			if err := ioutil.WriteFile(*reportFile, data, 0644); err != nil {
+				log.Errorln("failed to write report:", err)
+			}
+		} else {
+			fmt.Println(string(data))
+		}
+		if !report.Passed {
+			log.Errorln("swarm test failed, node logs are kept in", dir)
+			os.Exit(1)
+		}
+		log.Println("swarm test passed")
+		if len(*workDir) == 0 && !*keep {
+			os.RemoveAll(dir)
+		}
+	}
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// readPeerID reads the node identity from the IPFS config of an initialized repo.
+func readPeerID(fsDir string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(fsDir, ipfsConfigFile))
+	if err != nil {
+		return "", err
+	}
+	var cfg struct {
+		Identity struct {
+			PeerID string
+		}
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return "", err
+	} else if len(cfg.Identity.PeerID) == 0 {
+		return "", errors.New("no peer ID in IPFS config")
+	}
+	return cfg.Identity.PeerID, nil
+}
+
+func (s *swarm) start(dir string, num int) error {
+	if num < 2 {
+		return errors.New("swarm needs at least 2 nodes")
+	}
+	for i := 0; i < num; i++ {
+		n := &swarmNode{
+			Index: i,
+			Dir:   filepath.Join(dir, fmt.Sprintf("node%d", i)),
+		}
+		if err := os.MkdirAll(n.Dir, 0700); err != nil {
+			return err
+		}
+		if !fileNotEmpty(filepath.Join(n.Dir, "fs", ipfsConfigFile)) {
+			if err := s.launcher.Init(n); err != nil {
+				return fmt.Errorf("failed to init %s: %v", n.Name(), err)
+			}
+		}
+		nodeID, err := readPeerID(filepath.Join(n.Dir, "fs"))
+		if err != nil {
+			return fmt.Errorf("failed to read identity of %s: %v", n.Name(), err)
+		}
+		n.NodeID = nodeID
+		if n.FSPort, err = freePort(); err != nil {
+			return err
+		} else if n.WebPort, err = freePort(); err != nil {
+			return err
+		}
+		n.client = client.New(fmt.Sprintf("127.0.0.1:%d", n.WebPort), 30*time.Second)
+		s.nodes = append(s.nodes, n)
+	}
+	for _, n := range s.nodes {
+		args := []string{
+			"-T",
+			"-L", fmt.Sprintf("127.0.0.1:%d", n.FSPort),
+			"-W", fmt.Sprintf("127.0.0.1:%d", n.WebPort),
+		}
+		for _, domain := range *envTestnetDomains {
+			args = append(args, "--testnet-auth-domains", domain)
+		}
+		for _, peer := range s.nodes {
+			if peer != n {
+				args = append(args, "-B", peer.MultiAddr())
+			}
+		}
+		log.WithField("node", n.Name()).Infoln("starting", n.NodeID)
+		if err := s.launcher.Start(n, args); err != nil {
+			return fmt.Errorf("failed to start %s: %v", n.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (s *swarm) stop() {
+	for _, n := range s.nodes {
+		if err := s.launcher.Stop(n); err != nil {
+			log.WithField("node", n.Name()).Warningln("failed to stop:", err)
+		}
+	}
+}
+
+func (s *swarm) run() []*swarmResult {
+	var results []*swarmResult
+	var failed bool
+	for _, sc := range swarmScenarios {
+		r := &swarmResult{
+			Scenario: sc.Name,
+		}
+		results = append(results, r)
+		if failed {
+			r.Status = swarmSkip
+			r.Details = "a previous scenario failed"
+			continue
+		}
+		startedAt := time.Now()
+		ctx, cancelFn := context.WithTimeout(context.Background(), s.timeout)
+		details, err := sc.Run(s, ctx)
+		cancelFn()
+		r.Duration = time.Since(startedAt).String()
+		switch err := err.(type) {
+		case nil:
+			r.Status = swarmPass
+			r.Details = details
+		case errSwarmSkip:
+			r.Status = swarmSkip
+			r.Details = err.Error()
+		default:
+			r.Status = swarmFail
+			r.Details = err.Error()
+			failed = true
+		}
+		log.WithField("scenario", sc.Name).Infoln(r.Status, r.Details)
+	}
+	return results
+}
+
+// poll calls fn until it succeeds or the context is done, the last error is returned.
+func poll(ctx context.Context, fn func() error) error {
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+func (s *swarm) forEach(fn func(n *swarmNode) error) error {
+	errs := make([]error, len(s.nodes))
+	wg := new(sync.WaitGroup)
+	for i, n := range s.nodes {
+		wg.Add(1)
+		go func(i int, n *swarmNode) {
+			defer wg.Done()
+			if err := fn(n); err != nil {
+				errs[i] = fmt.Errorf("%s: %v", n.Name(), err)
+			}
+		}(i, n)
+	}
+	wg.Wait()
+	var msgs []string
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+func (s *swarm) writers() []*swarmNode {
+	var list []*swarmNode
+	for _, n := range s.nodes {
+		if n.Writer {
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
+func (s *swarm) checkStartup(ctx context.Context) (string, error) {
+	err := s.forEach(func(n *swarmNode) error {
+		return poll(ctx, func() error {
+			nodeID, err := n.client.Ping(ctx)
+			if err != nil {
+				return err
+			} else if nodeID != n.NodeID {
+				return fmt.Errorf("unexpected node ID %s", nodeID)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d nodes are up", len(s.nodes)), nil
+}
+
+func (s *swarm) checkPeering(ctx context.Context) (string, error) {
+	err := s.forEach(func(n *swarmNode) error {
+		return poll(ctx, func() error {
+			var stats struct {
+				BitswapStats struct {
+					Peers []string `json:"peers"`
+				} `json:"bitswap_stats"`
+			}
+			// the client doesn't ask for bitswap stats, they are costly
+			resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/api/v1/stats?bitswap=1", n.WebPort))
+			if err != nil {
+				return err
+			}
+			err = json.NewDecoder(resp.Body).Decode(&stats)
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+			peers := make(map[string]bool, len(stats.BitswapStats.Peers))
+			for _, p := range stats.BitswapStats.Peers {
+				peers[p] = true
+			}
+			for _, peer := range s.nodes {
+				if peer != n && !peers[peer.NodeID] {
+					return fmt.Errorf("not connected to %s", peer.Name())
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return "all nodes are connected to each other", nil
+}
+
+func (s *swarm) checkLocalWrites(ctx context.Context) (string, error) {
+	mux := new(sync.Mutex)
+	err := s.forEach(func(n *swarmNode) error {
+		for i := 0; i < s.records; i++ {
+			path := fmt.Sprintf("%s/%s/%d.txt", s.prefix, n.Name(), i)
+			body := fmt.Sprintf("record %d of %s", i, n.NodeID)
+			meta, err := n.client.Put(ctx, path, strings.NewReader(body), int64(len(body)), "")
+			if apiErr, ok := err.(*client.APIError); ok && apiErr.StatusCode == 403 && i == 0 {
+				// not in the authority, the node only reads
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("put %s: %v", path, err)
+			}
+			if err := checkContent(ctx, n, path, meta.Version, body); err != nil {
+				return err
+			}
+			mux.Lock()
+			n.Writer = true
+			s.written[path] = &swarmRecord{
+				Meta: meta,
+				Body: body,
+			}
+			mux.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	writers := s.writers()
+	if len(writers) == 0 {
+		return "", errSwarmSkip("no node has write permissions, list node IDs on --testnet-auth-domains or reuse authorized repos via --dir")
+	}
+	return fmt.Sprintf("%d records written by %d writers", len(s.written), len(writers)), nil
+}
+
+func checkContent(ctx context.Context, n *swarmNode, path, version, expected string) error {
+	body, meta, err := n.client.Get(ctx, path, "")
+	if err != nil {
+		return fmt.Errorf("get %s: %v", path, err)
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("get %s: %v", path, err)
+	} else if string(data) != expected {
+		return fmt.Errorf("get %s: content mismatch", path)
+	} else if len(version) > 0 && meta.Version != version {
+		return fmt.Errorf("get %s: version %s, expected %s", path, meta.Version, version)
+	}
+	return nil
+}
+
+func (s *swarm) checkReplication(ctx context.Context) (string, error) {
+	if len(s.writers()) == 0 {
+		return "", errSwarmSkip("no writers in the swarm")
+	}
+	startedAt := time.Now()
+	paths := make([]string, 0, len(s.written))
+	for path := range s.written {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	err := s.forEach(func(n *swarmNode) error {
+		for _, path := range paths {
+			rec := s.written[path]
+			if err := poll(ctx, func() error {
+				return checkContent(ctx, n, path, rec.Meta.Version, rec.Body)
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d records replicated to %d nodes in %s", len(paths), len(s.nodes), time.Since(startedAt)), nil
+}
+
+func (s *swarm) checkUpdateConvergence(ctx context.Context) (string, error) {
+	writers := s.writers()
+	if len(writers) == 0 {
+		return "", errSwarmSkip("no writers in the swarm")
+	}
+	path := s.prefix + "/contended.txt"
+	for round := 0; round < 3; round++ {
+		err := s.forEach(func(n *swarmNode) error {
+			if !n.Writer {
+				return nil
+			}
+			body := fmt.Sprintf("round %d by %s", round, n.NodeID)
+			_, err := n.client.Put(ctx, path, strings.NewReader(body), int64(len(body)), "")
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	var version string
+	err := poll(ctx, func() error {
+		versions := make(map[string][]string)
+		for _, n := range s.nodes {
+			meta, err := n.client.Meta(ctx, path, "")
+			if err != nil {
+				return fmt.Errorf("%s: %v", n.Name(), err)
+			}
+			versions[meta.Version] = append(versions[meta.Version], n.Name())
+		}
+		if len(versions) > 1 {
+			return fmt.Errorf("nodes disagree on the current version: %v", versions)
+		}
+		for v := range versions {
+			version = v
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d writers converged on version %s", len(writers), version), nil
+}
+
+func (s *swarm) checkDeletePropagation(ctx context.Context) (string, error) {
+	writers := s.writers()
+	if len(writers) == 0 {
+		return "", errSwarmSkip("no writers in the swarm")
+	}
+	w := writers[0]
+	path := fmt.Sprintf("%s/%s/0.txt", s.prefix, w.Name())
+	rec, ok := s.written[path]
+	if !ok {
+		return "", fmt.Errorf("record %s was not written", path)
+	}
+	if _, err := w.client.Delete(ctx, rec.Meta.ID); err != nil {
+		return "", fmt.Errorf("delete %s: %v", path, err)
+	}
+	err := s.forEach(func(n *swarmNode) error {
+		return poll(ctx, func() error {
+			meta, err := n.client.Meta(ctx, path, "")
+			if err == client.ErrNotFound {
+				return nil
+			} else if err != nil {
+				return err
+			} else if !meta.IsDeleted {
+				return fmt.Errorf("%s is not deleted", path)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("deletion of %s reached %d nodes", path, len(s.nodes)), nil
+}
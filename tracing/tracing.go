@@ -0,0 +1,184 @@
+// Package tracing records spans of API requests, writes, announces and IPFS fetches with
+// OpenTelemetry and exports them to an OTLP collector. Announces of record updates carry
+// the trace context of the write, so spans of the nodes that apply the update join the
+// trace of the writer and the time a record takes to appear on another node can be
+// followed hop by hop: queued for gossip, received, queued inbound, fetched from IPFS.
+// Without an exporter spans are not recorded, trace IDs of incoming requests and
+// announces are still passed on and logged.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/AtlantPlatform/atlant-go"
+
+// Config sets up the OTLP exporter.
+type Config struct {
+	// Endpoint of the OTLP/HTTP collector, e.g. http://127.0.0.1:4318, empty disables export.
+	Endpoint string
+	// SampleRatio is the fraction of traces started by this node that are recorded,
+	// traces continued from requests and announces follow the decision of their parent.
+	SampleRatio float64
+
+	ServiceVersion string
+	NodeID         string
+}
+
+// DefaultSampleRatio records every trace.
+const DefaultSampleRatio = 1.0
+
+var propagator = propagation.TraceContext{}
+
+// ParseEndpoint checks the URL of an OTLP/HTTP collector.
+func ParseEndpoint(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad OTLP endpoint: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("bad OTLP endpoint %s: use http:// or https://", s)
+	}
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("bad OTLP endpoint %s: no host", s)
+	}
+	return u, nil
+}
+
+// Init starts exporting spans, it returns a func that flushes pending spans and stops
+// the exporter. Nothing is exported if no endpoint is set.
+func Init(cfg *Config) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+	if cfg == nil || len(cfg.Endpoint) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+	u, err := ParseEndpoint(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(u.Path) > 0 && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %v", err)
+	}
+	ratio := cfg.SampleRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = DefaultSampleRatio
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "atlant-go"),
+			attribute.String("service.version", cfg.ServiceVersion),
+			attribute.String("service.instance.id", cfg.NodeID),
+		)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	log.WithFields(log.Fields{
+		"endpoint": u.String(),
+		"sample":   ratio,
+	}).Infoln("exporting traces over OTLP")
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span in the context.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartAt starts a span back in time, e.g. when the work it covers was queued.
+func StartAt(ctx context.Context, name string, at time.Time, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if at.IsZero() {
+		return Start(ctx, name, attrs...)
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...), trace.WithTimestamp(at))
+}
+
+// End ends the span, marking it failed if there's an error.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceParent returns the W3C traceparent of the span in the context, empty if there's none.
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// WithTraceParent returns a context that continues the trace of a traceparent received
+// from another node, the context is returned as is if the traceparent is empty or malformed.
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if len(traceParent) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{
+		"traceparent": traceParent,
+	})
+}
+
+// ExtractHTTP continues the trace of the traceparent header of a request.
+func ExtractHTTP(ctx context.Context, h http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(h))
+}
+
+// InjectHTTP sets the traceparent header of an outgoing request.
+func InjectHTTP(ctx context.Context, h http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// TraceID returns the ID of the trace in the context, empty if there's none.
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// Fields returns IDs of the trace and the span in the context for log entries,
+// so that logs of a slow write can be found by the trace ID and the other way around.
+func Fields(ctx context.Context) log.Fields {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return log.Fields{}
+	}
+	return log.Fields{
+		"trace_id": sc.TraceID().String(),
+		"span_id":  sc.SpanID().String(),
+	}
+}
+
+// Log returns a log entry with IDs of the trace in the context.
+func Log(ctx context.Context) *log.Entry {
+	return log.WithFields(Fields(ctx))
+}